@@ -0,0 +1,92 @@
+// Command conformance records golden-stream fixtures for internal/conformance.
+//
+// It sends a client request through the real Messages handler backed by the
+// load-test mock upstream, saves the upstream events it produced as a
+// fixture and writes the matching golden file:
+//
+//	go run ./cmd/conformance -name text_stream -request req.json
+//
+// Fixtures for events the mock does not produce (tool calls, thinking) can
+// be edited by hand afterwards; regenerate their golden files with
+// `go test ./internal/conformance -update`.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/goccy/go-json"
+
+	"orchids-api/internal/conformance"
+	"orchids-api/internal/loadtest"
+)
+
+func main() {
+	name := flag.String("name", "", "Fixture name (file name without extension)")
+	requestPath := flag.String("request", "-", "Client request body file (- for stdin)")
+	path := flag.String("path", "/v1/messages", "Route the request is sent to")
+	description := flag.String("description", "", "What the fixture covers")
+	dir := flag.String("dir", filepath.Join("internal", "conformance", "testdata"), "Testdata directory")
+	chunks := flag.Int("chunks", 4, "Text deltas in the mock reply")
+	force := flag.Bool("force", false, "Overwrite an existing fixture")
+	flag.Parse()
+
+	if err := run(*name, *requestPath, *path, *description, *dir, *chunks, *force); err != nil {
+		fmt.Fprintln(os.Stderr, "conformance:", err)
+		os.Exit(1)
+	}
+}
+
+func run(name, requestPath, path, description, dir string, chunks int, force bool) error {
+	name = strings.TrimSpace(name)
+	if name == "" || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("-name must be a plain file name")
+	}
+	fixtureDir := filepath.Join(dir, "fixtures")
+	if _, err := os.Stat(filepath.Join(fixtureDir, name+".json")); err == nil && !force {
+		return fmt.Errorf("fixture %s already exists (use -force to overwrite)", name)
+	}
+
+	body, err := readRequest(requestPath)
+	if err != nil {
+		return err
+	}
+	if !json.Valid(body) {
+		return fmt.Errorf("request is not valid JSON")
+	}
+
+	f := &conformance.Fixture{Name: name, Description: description, Path: path, Request: body}
+	rec := &conformance.Recorder{Client: &loadtest.MockUpstream{Chunks: chunks}}
+	res := conformance.Run(f, rec)
+	f.Upstream = rec.Events()
+	if len(f.Upstream) == 0 {
+		return fmt.Errorf("the request never reached the upstream (status %d): %s", res.Status, res.Body)
+	}
+
+	if err := f.Save(fixtureDir); err != nil {
+		return err
+	}
+	// The golden file comes from replaying the saved fixture, exactly as the
+	// test will.
+	goldenDir := filepath.Join(dir, "golden")
+	if err := os.MkdirAll(goldenDir, 0o755); err != nil {
+		return err
+	}
+	golden := conformance.GoldenPath(goldenDir, f)
+	if err := os.WriteFile(golden, conformance.RunFixture(f).Golden(), 0o644); err != nil {
+		return err
+	}
+	fmt.Printf("wrote %s and %s (%d upstream events)\n", filepath.Join(fixtureDir, name+".json"), golden, len(f.Upstream))
+	return nil
+}
+
+func readRequest(path string) ([]byte, error) {
+	if path == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(path)
+}
@@ -4,11 +4,16 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"log/slog"
 	"os"
+	"runtime/pprof"
 	"sort"
 	"strings"
 	"time"
 
+	"github.com/goccy/go-json"
+
+	"orchids-api/internal/config"
 	"orchids-api/internal/loadtest"
 )
 
@@ -16,7 +21,7 @@ func main() {
 	cfg := loadtest.DefaultConfig()
 
 	baseURL := flag.String("base", "", "Base URL (default: auto-detect via ORCHIDS_BASE_URL/config.json)")
-	mode := flag.String("mode", string(loadtest.ModeExternal), "Mode: external|self (self not yet implemented)")
+	mode := flag.String("mode", string(loadtest.ModeExternal), "Mode: external|self|inproc (inproc runs the real handler against a mock upstream)")
 	channel := flag.String("channel", string(loadtest.ChannelBoth), "Channel: orchids|warp|both")
 	model := flag.String("model", cfg.Model, "Model ID")
 	dur := flag.Duration("duration", cfg.Duration, "Run duration")
@@ -26,6 +31,14 @@ func main() {
 	timeout := flag.Duration("timeout", cfg.RequestTimeout, "Per-request timeout")
 	largeKB := flag.Int("large_kb", cfg.LargeBytes/1024, "Large payload size (KB)")
 	seed := flag.Int64("seed", cfg.Seed, "RNG seed")
+	replay := flag.String("replay", "", "JSONL file of recorded requests to replay instead of synthetic scenarios")
+	configPath := flag.String("config", "", "Server config for -mode inproc (default: built-in defaults)")
+	upstreamLatency := flag.Duration("upstream_latency", 0, "Mock upstream latency for -mode inproc")
+	cpuProfile := flag.String("cpuprofile", "", "Write a CPU profile of the run to this file")
+	memProfile := flag.String("memprofile", "", "Write an allocation profile of the run to this file")
+	out := flag.String("out", "", "Write the summary as JSON to this file (use as a later -baseline)")
+	baseline := flag.String("baseline", "", "Compare against a summary written by -out; exit 3 on regression")
+	tolerance := flag.Float64("tolerance", 0.1, "Allowed regression against -baseline (0.1 = 10%)")
 
 	flag.Parse()
 
@@ -46,23 +59,69 @@ func main() {
 		cfg.BaseURL = loadtest.DetectBaseURL()
 	}
 
+	if *replay != "" {
+		recs, err := loadtest.LoadRecording(*replay)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "replay:", err)
+			os.Exit(2)
+		}
+		cfg.Replay = recs
+	}
+
 	var self *loadtest.SelfServer
-	if cfg.Mode == loadtest.ModeSelf {
+	switch cfg.Mode {
+	case loadtest.ModeExternal:
+	case loadtest.ModeSelf:
 		self = loadtest.StartSelfServer()
+	case loadtest.ModeInProc:
+		var serverCfg *config.Config
+		if *configPath != "" {
+			c, _, err := config.Load(*configPath)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "config:", err)
+				os.Exit(2)
+			}
+			serverCfg = c
+		}
+		// Keep the server's per-request logs out of the report.
+		slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn})))
+		self = loadtest.StartInProcServer(serverCfg, &loadtest.MockUpstream{Latency: *upstreamLatency})
+	default:
+		fmt.Fprintln(os.Stderr, "Invalid -mode; use external, self or inproc")
+		os.Exit(2)
+	}
+	if self != nil {
 		defer self.Close()
 		cfg.BaseURL = self.BaseURL
 	}
-	if cfg.Mode != loadtest.ModeExternal && cfg.Mode != loadtest.ModeSelf {
-		fmt.Fprintln(os.Stderr, "Invalid -mode; use external or self")
-		os.Exit(2)
+
+	if *cpuProfile != "" {
+		f, err := os.Create(*cpuProfile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "cpuprofile:", err)
+			os.Exit(2)
+		}
+		defer f.Close()
+		if err := pprof.StartCPUProfile(f); err != nil {
+			fmt.Fprintln(os.Stderr, "cpuprofile:", err)
+			os.Exit(2)
+		}
 	}
 
 	ctx := context.Background()
 	res, err := loadtest.Run(ctx, cfg)
+	if *cpuProfile != "" {
+		pprof.StopCPUProfile()
+	}
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "loadtest error:", err)
 		os.Exit(1)
 	}
+	if *memProfile != "" {
+		if err := writeProfile(*memProfile, "allocs"); err != nil {
+			fmt.Fprintln(os.Stderr, "memprofile:", err)
+		}
+	}
 
 	s := loadtest.Summarize(res)
 
@@ -78,6 +137,10 @@ func main() {
 	fmt.Printf("  p90: %d\n", s.P90Ms)
 	fmt.Printf("  p99: %d\n", s.P99Ms)
 	fmt.Println()
+	fmt.Println("Allocations per request (whole process):")
+	fmt.Printf("  bytes: %.0f\n", s.AllocBytesPerReq)
+	fmt.Printf("  objects: %.0f\n", s.AllocsPerReq)
+	fmt.Println()
 	fmt.Println("Results:")
 	fmt.Printf("  success(non-empty): %d\n", s.Success)
 	fmt.Printf("  empty: %d\n", s.Empty)
@@ -95,4 +158,40 @@ func main() {
 		fmt.Printf("  %-15s  total=%d success=%d empty=%d errors=%d\n", k, r.Total, r.Success, r.Empty, r.Errors)
 	}
 	fmt.Println("============================")
+
+	if *out != "" {
+		data, _ := json.MarshalIndent(s, "", "  ")
+		if err := os.WriteFile(*out, data, 0o644); err != nil {
+			fmt.Fprintln(os.Stderr, "out:", err)
+		}
+	}
+	if *baseline != "" {
+		data, err := os.ReadFile(*baseline)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "baseline:", err)
+			os.Exit(2)
+		}
+		var base loadtest.Summary
+		if err := json.Unmarshal(data, &base); err != nil {
+			fmt.Fprintln(os.Stderr, "baseline:", err)
+			os.Exit(2)
+		}
+		if regressions := loadtest.Compare(base, s, *tolerance); len(regressions) > 0 {
+			fmt.Println("Regressions against baseline:")
+			for _, r := range regressions {
+				fmt.Println("  " + r)
+			}
+			os.Exit(3)
+		}
+		fmt.Println("No regressions against baseline.")
+	}
+}
+
+func writeProfile(path, name string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return pprof.Lookup(name).WriteTo(f, 0)
 }
@@ -16,9 +16,11 @@ import (
 	"orchids-api/internal/auth"
 	"orchids-api/internal/clerk"
 	"orchids-api/internal/config"
+	"orchids-api/internal/events"
 	"orchids-api/internal/grok"
 	"orchids-api/internal/loadbalancer"
 	"orchids-api/internal/orchids"
+	"orchids-api/internal/scheduler"
 	"orchids-api/internal/store"
 	"orchids-api/internal/util"
 	"orchids-api/internal/warp"
@@ -29,604 +31,750 @@ const (
 	grokModelProbeInterval    = 250 * time.Millisecond
 )
 
+// featureFlagRefreshInterval bounds how long a flag change made on another
+// instance takes to apply here.
+const featureFlagRefreshInterval = 30 * time.Second
+
 var grokProbeCursor uint64
 
-func startTokenRefreshLoop(ctx context.Context, cfg *config.Config, s *store.Store, lb *loadbalancer.LoadBalancer) {
-	if !cfg.AutoRefreshToken {
-		return
-	}
-	interval := time.Duration(cfg.TokenRefreshInterval) * time.Minute
-	if interval <= 0 {
-		interval = 30 * time.Minute
+// newScheduler registers the server's periodic jobs, listed and triggered
+// through /api/jobs. job_intervals overrides their intervals by name.
+func newScheduler(cfg *config.Config, s *store.Store, lb *loadbalancer.LoadBalancer) *scheduler.Scheduler {
+	jobs := scheduler.New()
+	for _, job := range []scheduler.Job{
+		tokenRefreshJob(cfg, s, lb),
+		tokenExpiryJob(cfg, s, accountRefresher(cfg, s, lb)),
+		clerkKeepAliveJob(cfg, s, lb),
+		tokenRefreshAheadJob(),
+		authCleanupJob(),
+		grokMediaCacheJob(cfg),
+		modelSyncJob(cfg, s),
+	} {
+		if secs, ok := cfg.JobIntervals[job.Name]; ok && secs != 0 {
+			job.Interval = time.Duration(secs) * time.Second
+			if secs < 0 {
+				job.Interval, job.Delay, job.RunAtStart = 0, 0, false
+			}
+		}
+		if err := jobs.Add(job); err != nil {
+			slog.Error("Failed to register scheduled job", "job", job.Name, "error", err)
+		}
 	}
-	slog.Info("Auto refresh token enabled", "interval", interval.String())
+	return jobs
+}
 
-	refreshAccounts := func() {
-		accounts, err := s.GetEnabledAccounts(context.Background())
-		if err != nil {
-			slog.Error("Auto refresh token: list accounts failed", "error", err)
+// accountRefresher returns a function that refreshes one account's token and
+// usage and saves the account; failures are logged and, where they mean the
+// credentials are dead, recorded as the account status.
+func accountRefresher(cfg *config.Config, s *store.Store, lb *loadbalancer.LoadBalancer) func(acc *store.Account) {
+	return func(acc *store.Account) {
+		if strings.EqualFold(acc.AccountType, "warp") {
+			if !acc.QuotaResetAt.IsZero() && time.Now().Before(acc.QuotaResetAt) {
+				return
+			}
+			if strings.TrimSpace(acc.RefreshToken) == "" && strings.TrimSpace(acc.ClientCookie) == "" {
+				return
+			}
+			warpClient := warp.NewFromAccount(acc, cfg)
+			jwt, err := warpClient.RefreshAccount(context.Background())
+			if err != nil {
+				retryAfter := warp.RetryAfter(err)
+				httpStatus := warp.HTTPStatusCode(err)
+				if httpStatus == 401 || httpStatus == 403 {
+					lb.MarkAccountStatus(context.Background(), acc, fmt.Sprintf("%d", httpStatus))
+				} else if retryAfter > 0 {
+					acc.QuotaResetAt = time.Now().Add(retryAfter)
+					if updateErr := s.UpdateAccount(context.Background(), acc); updateErr != nil {
+						slog.Warn("Auto refresh token: record warp retry-after failed", "account", acc.Name, "type", "warp", "error", updateErr)
+					}
+				}
+				slog.Warn("Auto refresh token failed", "account", acc.Name, "type", "warp", "http_status", httpStatus, "error", err)
+				return
+			}
+			if jwt != "" {
+				acc.Token = jwt
+			}
+			warpClient.SyncAccountState()
+
+			// Sync Warp usage quota via GraphQL
+			limitCtx, limitCancel := context.WithTimeout(context.Background(), 15*time.Second)
+			limitInfo, bonuses, limitErr := warpClient.GetRequestLimitInfo(limitCtx)
+			limitCancel()
+			if limitErr != nil {
+				slog.Warn("Warp usage sync failed", "account", acc.Name, "error", limitErr)
+			} else if limitInfo != nil {
+				if limitInfo.IsUnlimited {
+					acc.Subscription = "unlimited"
+				} else {
+					acc.Subscription = "free"
+				}
+				totalLimit := float64(limitInfo.RequestLimit)
+				for _, bg := range bonuses {
+					totalLimit += float64(bg.RequestCreditsRemaining)
+				}
+				usedRequests := float64(limitInfo.RequestsUsedSinceLastRefresh)
+				acc.UsageLimit = totalLimit
+				acc.UsageCurrent = usedRequests
+				if limitInfo.NextRefreshTime != "" {
+					if t, err := time.Parse(time.RFC3339, limitInfo.NextRefreshTime); err == nil {
+						acc.QuotaResetAt = t
+					}
+				}
+				slog.Debug("Warp usage synced", "account", acc.Name, "limit", acc.UsageLimit, "used", acc.UsageCurrent, "subscription", acc.Subscription)
+			}
+
+			if err := s.UpdateAccount(context.Background(), acc); err != nil {
+				slog.Warn("Auto refresh token: update account failed", "account", acc.Name, "type", "warp", "error", err)
+			}
 			return
 		}
-		for _, acc := range accounts {
-			if strings.EqualFold(acc.AccountType, "warp") {
-				if !acc.QuotaResetAt.IsZero() && time.Now().Before(acc.QuotaResetAt) {
-					continue
+		// Grok accounts store SSO tokens in ClientCookie and are not Clerk-backed.
+		if strings.EqualFold(acc.AccountType, "grok") {
+			return
+		}
+		proxyFunc := http.ProxyFromEnvironment
+		if cfg != nil {
+			proxyFunc = util.ProxyFunc(cfg.ProxyHTTP, cfg.ProxyHTTPS, cfg.ProxyUser, cfg.ProxyPass, cfg.ProxyBypass)
+		}
+		if strings.TrimSpace(acc.ClientCookie) == "" {
+			jwt := strings.TrimSpace(acc.Token)
+			if jwt == "" {
+				return
+			}
+			if sid, sub := clerk.ParseSessionInfoFromJWT(jwt); sub != "" {
+				if acc.SessionID == "" && sid != "" {
+					acc.SessionID = sid
 				}
-				if strings.TrimSpace(acc.RefreshToken) == "" && strings.TrimSpace(acc.ClientCookie) == "" {
-					continue
+				if acc.UserID == "" {
+					acc.UserID = sub
 				}
-				warpClient := warp.NewFromAccount(acc, cfg)
-				jwt, err := warpClient.RefreshAccount(context.Background())
-				if err != nil {
-					retryAfter := warp.RetryAfter(err)
-					httpStatus := warp.HTTPStatusCode(err)
-					if httpStatus == 401 || httpStatus == 403 {
-						lb.MarkAccountStatus(context.Background(), acc, fmt.Sprintf("%d", httpStatus))
-					} else if retryAfter > 0 {
-						acc.QuotaResetAt = time.Now().Add(retryAfter)
-						if updateErr := s.UpdateAccount(context.Background(), acc); updateErr != nil {
-							slog.Warn("Auto refresh token: record warp retry-after failed", "account", acc.Name, "type", "warp", "error", updateErr)
-						}
+			}
+			creditsInfo, creditsErr := orchids.FetchCreditsWithProxy(context.Background(), jwt, acc.UserID, proxyFunc)
+			if creditsErr != nil {
+				slog.Warn("Orchids credits sync failed (token-only)", "account", acc.Name, "error", creditsErr)
+				return
+			}
+			if creditsInfo != nil {
+				acc.Subscription = strings.ToLower(creditsInfo.Plan)
+				acc.UsageCurrent = creditsInfo.Credits
+				acc.UsageLimit = orchids.PlanCreditLimit(creditsInfo.Plan)
+				slog.Debug("Orchids credits synced (token-only)", "account", acc.Name, "credits", acc.UsageCurrent, "limit", acc.UsageLimit, "plan", acc.Subscription)
+			}
+			if err := s.UpdateAccount(context.Background(), acc); err != nil {
+				slog.Warn("Auto refresh token: update account failed (token-only)", "account", acc.Name, "error", err)
+			}
+			return
+		}
+		info, err := clerk.FetchAccountInfoWithSessionProxy(acc.ClientCookie, acc.SessionCookie, proxyFunc)
+		if err != nil {
+			errLower := strings.ToLower(err.Error())
+			if strings.Contains(errLower, "no active sessions") {
+				jwt := strings.TrimSpace(acc.Token)
+				if jwt == "" {
+					orchidsClient := orchids.NewFromAccount(acc, cfg)
+					if refreshed, jwtErr := orchidsClient.GetToken(); jwtErr == nil {
+						jwt = strings.TrimSpace(refreshed)
 					}
-					slog.Warn("Auto refresh token failed", "account", acc.Name, "type", "warp", "http_status", httpStatus, "error", err)
-					continue
 				}
 				if jwt != "" {
 					acc.Token = jwt
-				}
-				warpClient.SyncAccountState()
-
-				// Sync Warp usage quota via GraphQL
-				limitCtx, limitCancel := context.WithTimeout(context.Background(), 15*time.Second)
-				limitInfo, bonuses, limitErr := warpClient.GetRequestLimitInfo(limitCtx)
-				limitCancel()
-				if limitErr != nil {
-					slog.Warn("Warp usage sync failed", "account", acc.Name, "error", limitErr)
-				} else if limitInfo != nil {
-					if limitInfo.IsUnlimited {
-						acc.Subscription = "unlimited"
-					} else {
-						acc.Subscription = "free"
+					if sid, sub := clerk.ParseSessionInfoFromJWT(jwt); sub != "" {
+						if acc.SessionID == "" && sid != "" {
+							acc.SessionID = sid
+						}
+						if acc.UserID == "" {
+							acc.UserID = sub
+						}
 					}
-					totalLimit := float64(limitInfo.RequestLimit)
-					for _, bg := range bonuses {
-						totalLimit += float64(bg.RequestCreditsRemaining)
+					creditsInfo, creditsErr := orchids.FetchCreditsWithProxy(context.Background(), jwt, acc.UserID, proxyFunc)
+					if creditsErr != nil {
+						slog.Warn("Orchids credits sync failed (fallback)", "account", acc.Name, "error", creditsErr)
+					} else if creditsInfo != nil {
+						acc.Subscription = strings.ToLower(creditsInfo.Plan)
+						acc.UsageCurrent = creditsInfo.Credits
+						acc.UsageLimit = orchids.PlanCreditLimit(creditsInfo.Plan)
+						slog.Debug("Orchids credits synced (fallback)", "account", acc.Name, "credits", acc.UsageCurrent, "limit", acc.UsageLimit, "plan", acc.Subscription)
 					}
-					usedRequests := float64(limitInfo.RequestsUsedSinceLastRefresh)
-					acc.UsageLimit = totalLimit
-					acc.UsageCurrent = usedRequests
-					if limitInfo.NextRefreshTime != "" {
-						if t, err := time.Parse(time.RFC3339, limitInfo.NextRefreshTime); err == nil {
-							acc.QuotaResetAt = t
-						}
+					if err := s.UpdateAccount(context.Background(), acc); err != nil {
+						slog.Warn("Auto refresh token: update account failed (fallback)", "account", acc.Name, "error", err)
 					}
-					slog.Debug("Warp usage synced", "account", acc.Name, "limit", acc.UsageLimit, "used", acc.UsageCurrent, "subscription", acc.Subscription)
+					return
 				}
+			}
+			if status := refreshFailureStatus(err); status != "" {
+				lb.MarkAccountStatus(context.Background(), acc, status)
+			}
+			slog.Warn("Auto refresh token failed", "account", acc.Name, "error", err)
+			return
+		}
+		if info.SessionID != "" {
+			acc.SessionID = info.SessionID
+		}
+		if info.ClientUat != "" {
+			acc.ClientUat = info.ClientUat
+		}
+		if info.ProjectID != "" {
+			acc.ProjectID = info.ProjectID
+		}
+		if info.UserID != "" {
+			acc.UserID = info.UserID
+		}
+		if info.Email != "" {
+			acc.Email = info.Email
+		}
+		if info.JWT != "" {
+			acc.Token = info.JWT
+		}
+		if info.ClientCookie != "" {
+			acc.ClientCookie = info.ClientCookie
+		}
+		lb.ClearReloginStatus(context.Background(), acc)
+
+		// Sync Orchids credits via RSC Server Action
+		if info.JWT != "" {
+			creditsCtx, creditsCancel := context.WithTimeout(context.Background(), 15*time.Second)
+			uid := info.UserID
+			if strings.TrimSpace(uid) == "" {
+				uid = acc.UserID
+			}
+			creditsInfo, creditsErr := orchids.FetchCreditsWithProxy(creditsCtx, info.JWT, uid, proxyFunc)
+			creditsCancel()
+			if creditsErr != nil {
+				slog.Warn("Orchids credits sync failed", "account", acc.Name, "error", creditsErr)
+			} else if creditsInfo != nil {
+				acc.Subscription = strings.ToLower(creditsInfo.Plan)
+				acc.UsageCurrent = creditsInfo.Credits
+				acc.UsageLimit = orchids.PlanCreditLimit(creditsInfo.Plan)
+				slog.Debug("Orchids credits synced", "account", acc.Name, "credits", acc.UsageCurrent, "limit", acc.UsageLimit, "plan", acc.Subscription)
+			}
+		}
 
-				if err := s.UpdateAccount(context.Background(), acc); err != nil {
-					slog.Warn("Auto refresh token: update account failed", "account", acc.Name, "type", "warp", "error", err)
-				}
+		if err := s.UpdateAccount(context.Background(), acc); err != nil {
+			slog.Warn("Auto refresh token: update account failed", "account", acc.Name, "error", err)
+		}
+	}
+}
+
+// refreshFailureStatus maps a failed Clerk refresh to the account status to
+// mark: a dead cookie needs re-login, while a plain 401 or 403 only takes the
+// usual cooldown. It returns "" for other failures.
+func refreshFailureStatus(err error) string {
+	errLower := strings.ToLower(err.Error())
+	switch {
+	case clerk.IsReloginRequired(err):
+		return loadbalancer.StatusReloginRequired
+	case strings.Contains(errLower, "status code 401") || strings.Contains(errLower, "unauthorized"):
+		return "401"
+	case strings.Contains(errLower, "status code 403") || strings.Contains(errLower, "forbidden"):
+		return "403"
+	}
+	return ""
+}
+
+// tokenRefreshJob refreshes account tokens and usage. Without
+// auto_refresh_token it only runs when triggered from /api/jobs.
+func tokenRefreshJob(cfg *config.Config, s *store.Store, lb *loadbalancer.LoadBalancer) scheduler.Job {
+	var interval time.Duration
+	if cfg.AutoRefreshToken {
+		interval = time.Duration(cfg.TokenRefreshInterval) * time.Minute
+		if interval <= 0 {
+			interval = 30 * time.Minute
+		}
+		slog.Info("Auto refresh token enabled", "interval", interval.String())
+	}
+
+	refreshAccount := accountRefresher(cfg, s, lb)
+	refreshAccounts := func(ctx context.Context) error {
+		accounts, err := s.GetEnabledAccounts(ctx)
+		if err != nil {
+			return fmt.Errorf("list accounts: %w", err)
+		}
+		for _, acc := range accounts {
+			refreshAccount(acc)
+		}
+		return nil
+	}
+
+	return scheduler.Job{
+		Name:       "token_refresh",
+		Interval:   interval,
+		RunAtStart: cfg.AutoRefreshToken,
+		Run:        refreshAccounts,
+	}
+}
+
+// tokenExpiryJob refreshes accounts whose stored JWT expires within
+// token_expiry_window, and flags the ones it can't renew (token-only accounts,
+// failed refreshes) with an account.token_expiring event, once per token.
+// Orchids accounts with a Clerk cookie are skipped: their short-lived JWTs are
+// minted per request.
+func tokenExpiryJob(cfg *config.Config, s *store.Store, refresh func(acc *store.Account)) scheduler.Job {
+	flagged := make(map[int64]time.Time) // account ID -> expiry already reported
+	checkAccounts := func(ctx context.Context) error {
+		accounts, err := s.GetEnabledAccounts(ctx)
+		if err != nil {
+			return fmt.Errorf("list accounts: %w", err)
+		}
+		window := time.Duration(cfg.TokenExpiryWindow) * time.Second
+		now := time.Now()
+		for _, acc := range accounts {
+			if isOrchidsAccountType(acc.AccountType) && strings.TrimSpace(acc.ClientCookie) != "" {
 				continue
 			}
-			// Grok accounts store SSO tokens in ClientCookie and are not Clerk-backed.
-			if strings.EqualFold(acc.AccountType, "grok") {
+			if !acc.TokenExpiresWithin(now, window) {
+				delete(flagged, acc.ID)
 				continue
 			}
-			proxyFunc := http.ProxyFromEnvironment
-			if cfg != nil {
-				proxyFunc = util.ProxyFunc(cfg.ProxyHTTP, cfg.ProxyHTTPS, cfg.ProxyUser, cfg.ProxyPass, cfg.ProxyBypass)
+			if reported, ok := flagged[acc.ID]; ok && reported.Equal(*acc.TokenExpiresAt) {
+				continue
 			}
-			if strings.TrimSpace(acc.ClientCookie) == "" {
-				jwt := strings.TrimSpace(acc.Token)
-				if jwt == "" {
-					continue
-				}
-				if sid, sub := clerk.ParseSessionInfoFromJWT(jwt); sub != "" {
-					if acc.SessionID == "" && sid != "" {
-						acc.SessionID = sid
-					}
-					if acc.UserID == "" {
-						acc.UserID = sub
-					}
-				}
-				creditsInfo, creditsErr := orchids.FetchCreditsWithProxy(context.Background(), jwt, acc.UserID, proxyFunc)
-				if creditsErr != nil {
-					slog.Warn("Orchids credits sync failed (token-only)", "account", acc.Name, "error", creditsErr)
+			if strings.EqualFold(acc.AccountType, "warp") {
+				refresh(acc)
+				acc.TokenExpiresAt = store.TokenExpiry(acc.Token)
+				if !acc.TokenExpiresWithin(now, window) {
+					slog.Info("Token expiry: refreshed ahead of expiry", "account", acc.Name)
 					continue
 				}
-				if creditsInfo != nil {
-					acc.Subscription = strings.ToLower(creditsInfo.Plan)
-					acc.UsageCurrent = creditsInfo.Credits
-					acc.UsageLimit = orchids.PlanCreditLimit(creditsInfo.Plan)
-					slog.Debug("Orchids credits synced (token-only)", "account", acc.Name, "credits", acc.UsageCurrent, "limit", acc.UsageLimit, "plan", acc.Subscription)
-				}
-				if err := s.UpdateAccount(context.Background(), acc); err != nil {
-					slog.Warn("Auto refresh token: update account failed (token-only)", "account", acc.Name, "error", err)
-				}
+			}
+			if acc.TokenExpiresAt == nil {
+				continue
+			}
+			flagged[acc.ID] = *acc.TokenExpiresAt
+			slog.Warn("Token expiry: account token expires soon and could not be refreshed", "account", acc.Name, "type", acc.AccountType, "expires_at", acc.TokenExpiresAt)
+			events.Publish(events.AccountTokenExpiring, events.LevelWarning,
+				fmt.Sprintf("Account %s token expires at %s", acc.Name, acc.TokenExpiresAt.Format(time.RFC3339)),
+				map[string]interface{}{"account_id": acc.ID, "account": acc.Name, "expires_at": acc.TokenExpiresAt})
+		}
+		return nil
+	}
+
+	return scheduler.Job{
+		Name:     "token_expiry",
+		Interval: time.Minute,
+		Delay:    30 * time.Second,
+		Run:      checkAccounts,
+	}
+}
+
+// clerkKeepAliveInterval is how often Clerk sessions are touched. Clerk extends
+// the __client cookie on activity, so a periodic touch keeps idle accounts alive.
+const clerkKeepAliveInterval = 6 * time.Hour
+
+func clerkKeepAliveJob(cfg *config.Config, s *store.Store, lb *loadbalancer.LoadBalancer) scheduler.Job {
+	touchAccounts := func(ctx context.Context) error {
+		accounts, err := s.GetEnabledAccounts(ctx)
+		if err != nil {
+			return fmt.Errorf("list accounts: %w", err)
+		}
+		proxyFunc := http.ProxyFromEnvironment
+		if cfg != nil {
+			proxyFunc = util.ProxyFunc(cfg.ProxyHTTP, cfg.ProxyHTTPS, cfg.ProxyUser, cfg.ProxyPass, cfg.ProxyBypass)
+		}
+		for _, acc := range accounts {
+			if !isOrchidsAccountType(acc.AccountType) || strings.TrimSpace(acc.ClientCookie) == "" || strings.TrimSpace(acc.SessionID) == "" {
 				continue
 			}
-			info, err := clerk.FetchAccountInfoWithSessionProxy(acc.ClientCookie, acc.SessionCookie, proxyFunc)
+			rotated, err := clerk.TouchSessionWithProxy(acc.ClientCookie, acc.SessionCookie, acc.SessionID, proxyFunc)
 			if err != nil {
-				errLower := strings.ToLower(err.Error())
-				if strings.Contains(errLower, "no active sessions") {
-					jwt := strings.TrimSpace(acc.Token)
-					if jwt == "" {
-						orchidsClient := orchids.NewFromAccount(acc, cfg)
-						if refreshed, jwtErr := orchidsClient.GetToken(); jwtErr == nil {
-							jwt = strings.TrimSpace(refreshed)
-						}
-					}
-					if jwt != "" {
-						acc.Token = jwt
-						if sid, sub := clerk.ParseSessionInfoFromJWT(jwt); sub != "" {
-							if acc.SessionID == "" && sid != "" {
-								acc.SessionID = sid
-							}
-							if acc.UserID == "" {
-								acc.UserID = sub
-							}
-						}
-						creditsInfo, creditsErr := orchids.FetchCreditsWithProxy(context.Background(), jwt, acc.UserID, proxyFunc)
-						if creditsErr != nil {
-							slog.Warn("Orchids credits sync failed (fallback)", "account", acc.Name, "error", creditsErr)
-						} else if creditsInfo != nil {
-							acc.Subscription = strings.ToLower(creditsInfo.Plan)
-							acc.UsageCurrent = creditsInfo.Credits
-							acc.UsageLimit = orchids.PlanCreditLimit(creditsInfo.Plan)
-							slog.Debug("Orchids credits synced (fallback)", "account", acc.Name, "credits", acc.UsageCurrent, "limit", acc.UsageLimit, "plan", acc.Subscription)
-						}
-						if err := s.UpdateAccount(context.Background(), acc); err != nil {
-							slog.Warn("Auto refresh token: update account failed (fallback)", "account", acc.Name, "error", err)
-						}
-						continue
-					}
+				if clerk.IsReloginRequired(err) {
+					slog.Warn("Clerk keep-alive: account needs re-login", "account", acc.Name, "error", err)
+					lb.MarkAccountStatus(context.Background(), acc, loadbalancer.StatusReloginRequired)
+				} else {
+					slog.Warn("Clerk keep-alive: touch failed", "account", acc.Name, "error", err)
 				}
-				switch {
-				case strings.Contains(errLower, "status code 401") || strings.Contains(errLower, "unauthorized"):
-					lb.MarkAccountStatus(context.Background(), acc, "401")
-				case strings.Contains(errLower, "status code 403") || strings.Contains(errLower, "forbidden"):
-					lb.MarkAccountStatus(context.Background(), acc, "403")
-				case strings.Contains(errLower, "no active sessions"):
-					lb.MarkAccountStatus(context.Background(), acc, "401")
-				}
-				slog.Warn("Auto refresh token failed", "account", acc.Name, "error", err)
 				continue
 			}
-			if info.SessionID != "" {
-				acc.SessionID = info.SessionID
+			if rotated == "" {
+				lb.ClearReloginStatus(context.Background(), acc)
+				continue
 			}
-			if info.ClientUat != "" {
-				acc.ClientUat = info.ClientUat
+			acc.ClientCookie = rotated
+			lb.ClearReloginStatus(context.Background(), acc)
+			if err := s.UpdateAccount(context.Background(), acc); err != nil {
+				slog.Warn("Clerk keep-alive: persist rotated cookie failed", "account", acc.Name, "error", err)
+				continue
 			}
-			if info.ProjectID != "" {
-				acc.ProjectID = info.ProjectID
+			slog.Info("Clerk keep-alive: client cookie rotated", "account", acc.Name)
+		}
+		return nil
+	}
+
+	return scheduler.Job{
+		Name:     "clerk_keepalive",
+		Interval: clerkKeepAliveInterval,
+		Run:      touchAccounts,
+	}
+}
+
+// tokenRefreshAheadJob mints Clerk session tokens in the background for
+// Orchids accounts with recent traffic before their cached token runs out.
+// Clerk tokens live about a minute, so it runs every few seconds.
+func tokenRefreshAheadJob() scheduler.Job {
+	return scheduler.Job{
+		Name:     "token_refresh_ahead",
+		Interval: 5 * time.Second,
+		Run:      orchids.RefreshTokensAhead,
+	}
+}
+
+// authCleanupJob drops expired admin sessions.
+func authCleanupJob() scheduler.Job {
+	return scheduler.Job{
+		Name:     "session_cleanup",
+		Interval: time.Hour,
+		Run: func(context.Context) error {
+			auth.CleanupExpiredSessions()
+			return nil
+		},
+	}
+}
+
+// grokMediaCacheJob prunes cached grok images/videos older than
+// grok_media_cache_ttl. The TTL is re-read each run so config changes apply.
+func grokMediaCacheJob(cfg *config.Config) scheduler.Job {
+	return scheduler.Job{
+		Name:     "grok_media_cache_prune",
+		Interval: time.Hour,
+		Run: func(context.Context) error {
+			ttl := time.Duration(cfg.GrokMediaCacheTTL) * time.Hour
+			if removed := grok.PruneMediaCache(ttl); removed > 0 {
+				slog.Info("Pruned expired grok media cache", "removed", removed, "ttl", ttl)
+			}
+			return nil
+		},
+	}
+}
+
+// modelSyncJob syncs the model lists of the Orchids, Warp and Grok
+// upstreams, starting shortly after boot so token refresh can finish first.
+func modelSyncJob(cfg *config.Config, s *store.Store) scheduler.Job {
+	syncModels := func() {
+		fetchCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		proxyFunc := http.ProxyFromEnvironment
+		if cfg != nil {
+			proxyFunc = util.ProxyFunc(cfg.ProxyHTTP, cfg.ProxyHTTPS, cfg.ProxyUser, cfg.ProxyPass, cfg.ProxyBypass)
+		}
+		publicModels, pubErr := orchids.FetchPublicModelChoicesWithProxy(fetchCtx, proxyFunc)
+		if pubErr != nil {
+			slog.Warn("上游模型同步: 公共模型抓取失败，使用 fallback", "error", pubErr)
+		}
+
+		if len(publicModels) == 0 {
+			slog.Debug("上游模型同步: 无模型返回")
+			return
+		}
+
+		added := 0
+		updated := 0
+		disabled := 0
+		publicSet := map[string]string{}
+		for _, pm := range publicModels {
+			modelID := strings.TrimSpace(pm.ID)
+			if modelID == "" {
+				continue
 			}
-			if info.UserID != "" {
-				acc.UserID = info.UserID
+			name := strings.TrimSpace(pm.Name)
+			if name == "" {
+				name = modelID
 			}
-			if info.Email != "" {
-				acc.Email = info.Email
+			publicSet[modelID] = name
+		}
+		for modelID, name := range publicSet {
+			if existing, err := s.GetModelByModelID(context.Background(), modelID); err == nil && existing != nil {
+				needsUpdate := false
+				if !strings.EqualFold(existing.Channel, "orchids") {
+					existing.Channel = "Orchids"
+					needsUpdate = true
+				}
+				if existing.Status != store.ModelStatusAvailable {
+					existing.Status = store.ModelStatusAvailable
+					needsUpdate = true
+				}
+				if strings.TrimSpace(existing.Name) != name {
+					existing.Name = name
+					needsUpdate = true
+				}
+				if needsUpdate {
+					if err := s.UpdateModel(context.Background(), existing); err != nil {
+						slog.Warn("上游模型同步: 更新模型失败", "model_id", modelID, "error", err)
+					} else {
+						updated++
+					}
+				}
+				continue
 			}
-			if info.JWT != "" {
-				acc.Token = info.JWT
+			newModel := &store.Model{
+				Channel: "Orchids",
+				ModelID: modelID,
+				Name:    name,
+				Status:  store.ModelStatusAvailable,
 			}
-			if info.ClientCookie != "" {
-				acc.ClientCookie = info.ClientCookie
+			if err := s.CreateModel(context.Background(), newModel); err != nil {
+				slog.Warn("上游模型同步: 创建模型失败", "model_id", modelID, "error", err)
+				continue
 			}
+			added++
+			slog.Info("上游模型同步: 新增模型", "model_id", modelID, "channel", "Orchids")
+		}
 
-			// Sync Orchids credits via RSC Server Action
-			if info.JWT != "" {
-				creditsCtx, creditsCancel := context.WithTimeout(context.Background(), 15*time.Second)
-				uid := info.UserID
-				if strings.TrimSpace(uid) == "" {
-					uid = acc.UserID
+		if existing, err := s.ListModels(context.Background()); err == nil {
+			for _, m := range existing {
+				if !strings.EqualFold(strings.TrimSpace(m.Channel), "orchids") {
+					continue
 				}
-				creditsInfo, creditsErr := orchids.FetchCreditsWithProxy(creditsCtx, info.JWT, uid, proxyFunc)
-				creditsCancel()
-				if creditsErr != nil {
-					slog.Warn("Orchids credits sync failed", "account", acc.Name, "error", creditsErr)
-				} else if creditsInfo != nil {
-					acc.Subscription = strings.ToLower(creditsInfo.Plan)
-					acc.UsageCurrent = creditsInfo.Credits
-					acc.UsageLimit = orchids.PlanCreditLimit(creditsInfo.Plan)
-					slog.Debug("Orchids credits synced", "account", acc.Name, "credits", acc.UsageCurrent, "limit", acc.UsageLimit, "plan", acc.Subscription)
+				id := strings.TrimSpace(m.ModelID)
+				if id == "" {
+					continue
+				}
+				if _, ok := publicSet[id]; ok {
+					continue
+				}
+				if m.Status != store.ModelStatusOffline {
+					m.Status = store.ModelStatusOffline
+					if err := s.UpdateModel(context.Background(), m); err != nil {
+						slog.Warn("上游模型同步: 下线模型失败", "model_id", id, "error", err)
+						continue
+					}
+					disabled++
 				}
 			}
-
-			if err := s.UpdateAccount(context.Background(), acc); err != nil {
-				slog.Warn("Auto refresh token: update account failed", "account", acc.Name, "error", err)
-				continue
-			}
+		}
+		if added > 0 {
+			slog.Info("上游模型同步完成", "total_public", len(publicModels), "added", added, "updated", updated, "disabled", disabled)
+		} else {
+			slog.Debug("上游模型同步完成，无新增", "total_public", len(publicModels), "updated", updated, "disabled", disabled)
 		}
 	}
 
-	go func() {
-		defer func() {
-			if err := recover(); err != nil {
-				slog.Error("Panic in token refresh loop", "error", err)
-			}
-		}()
-		refreshAccounts()
-		ticker := time.NewTicker(interval)
-		defer ticker.Stop()
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			case <-ticker.C:
-				refreshAccounts()
-			}
+	syncWarpModels := func() {
+		accounts, err := s.GetEnabledAccounts(context.Background())
+		if err != nil {
+			slog.Warn("Warp 模型同步: 获取账号失败", "error", err)
+			return
 		}
-	}()
-}
-
-func startAuthCleanupLoop(ctx context.Context) {
-	go func() {
-		defer func() {
-			if err := recover(); err != nil {
-				slog.Error("Panic in auth cleanup loop", "error", err)
-			}
-		}()
-		ticker := time.NewTicker(time.Hour)
-		defer ticker.Stop()
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			case <-ticker.C:
-				auth.CleanupExpiredSessions()
+		var warpAcc *store.Account
+		for _, acc := range accounts {
+			if strings.EqualFold(acc.AccountType, "warp") && strings.TrimSpace(acc.Token) != "" {
+				warpAcc = acc
+				break
 			}
 		}
-	}()
-}
-
-func startModelSyncLoop(ctx context.Context, cfg *config.Config, s *store.Store) {
-	go func() {
-		defer func() {
-			if err := recover(); err != nil {
-				slog.Error("Panic in upstream model sync loop", "error", err)
-			}
-		}()
+		if warpAcc == nil {
+			slog.Debug("Warp 模型同步: 无可用 Warp 账号")
+			return
+		}
 
-		syncModels := func() {
-			fetchCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-			defer cancel()
+		warpClient := warp.NewFromAccount(warpAcc, cfg)
+		fetchCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
 
-			proxyFunc := http.ProxyFromEnvironment
-			if cfg != nil {
-				proxyFunc = util.ProxyFunc(cfg.ProxyHTTP, cfg.ProxyHTTPS, cfg.ProxyUser, cfg.ProxyPass, cfg.ProxyBypass)
-			}
-			publicModels, pubErr := orchids.FetchPublicModelChoicesWithProxy(fetchCtx, proxyFunc)
-			if pubErr != nil {
-				slog.Warn("上游模型同步: 公共模型抓取失败，使用 fallback", "error", pubErr)
-			}
+		choices, err := warpClient.GetFeatureModelChoices(fetchCtx)
+		if err != nil {
+			slog.Warn("Warp 模型同步: 获取失败", "error", err)
+			return
+		}
 
-			if len(publicModels) == 0 {
-				slog.Debug("上游模型同步: 无模型返回")
-				return
+		seen := make(map[string]bool)
+		added := 0
+		categories := []*warp.FeatureModelCategory{choices.AgentMode, choices.Planning, choices.Coding, choices.CliAgent}
+		for _, cat := range categories {
+			if cat == nil {
+				continue
 			}
-
-			added := 0
-			updated := 0
-			disabled := 0
-			publicSet := map[string]string{}
-			for _, pm := range publicModels {
-				modelID := strings.TrimSpace(pm.ID)
-				if modelID == "" {
+			for _, choice := range cat.Choices {
+				modelID := strings.TrimSpace(choice.ID)
+				if modelID == "" || seen[modelID] {
 					continue
 				}
-				name := strings.TrimSpace(pm.Name)
-				if name == "" {
-					name = modelID
-				}
-				publicSet[modelID] = name
-			}
-			for modelID, name := range publicSet {
-				if existing, err := s.GetModelByModelID(context.Background(), modelID); err == nil && existing != nil {
-					needsUpdate := false
-					if !strings.EqualFold(existing.Channel, "orchids") {
-						existing.Channel = "Orchids"
-						needsUpdate = true
-					}
-					if existing.Status != store.ModelStatusAvailable {
-						existing.Status = store.ModelStatusAvailable
-						needsUpdate = true
-					}
-					if strings.TrimSpace(existing.Name) != name {
-						existing.Name = name
-						needsUpdate = true
-					}
-					if needsUpdate {
-						if err := s.UpdateModel(context.Background(), existing); err != nil {
-							slog.Warn("上游模型同步: 更新模型失败", "model_id", modelID, "error", err)
-						} else {
-							updated++
-						}
-					}
+				seen[modelID] = true
+				if _, err := s.GetModelByModelID(context.Background(), modelID); err == nil {
 					continue
 				}
+				displayName := choice.DisplayName
+				if displayName == "" {
+					displayName = modelID
+				}
 				newModel := &store.Model{
-					Channel: "Orchids",
+					Channel: "Warp",
 					ModelID: modelID,
-					Name:    name,
+					Name:    displayName + " (Warp)",
 					Status:  store.ModelStatusAvailable,
 				}
 				if err := s.CreateModel(context.Background(), newModel); err != nil {
-					slog.Warn("上游模型同步: 创建模型失败", "model_id", modelID, "error", err)
+					slog.Warn("Warp 模型同步: 创建模型失败", "model_id", modelID, "error", err)
 					continue
 				}
 				added++
-				slog.Info("上游模型同步: 新增模型", "model_id", modelID, "channel", "Orchids")
+				slog.Info("Warp 模型同步: 新增模型", "model_id", modelID, "name", displayName)
 			}
+		}
+		if added > 0 {
+			slog.Info("Warp 模型同步完成", "added", added)
+		} else {
+			slog.Debug("Warp 模型同步完成，无新增")
+		}
+	}
 
-			if existing, err := s.ListModels(context.Background()); err == nil {
-				for _, m := range existing {
-					if !strings.EqualFold(strings.TrimSpace(m.Channel), "orchids") {
-						continue
-					}
-					id := strings.TrimSpace(m.ModelID)
-					if id == "" {
-						continue
-					}
-					if _, ok := publicSet[id]; ok {
-						continue
-					}
-					if m.Status != store.ModelStatusOffline {
-						m.Status = store.ModelStatusOffline
-						if err := s.UpdateModel(context.Background(), m); err != nil {
-							slog.Warn("上游模型同步: 下线模型失败", "model_id", id, "error", err)
-							continue
-						}
-						disabled++
-					}
-				}
-			}
-			if added > 0 {
-				slog.Info("上游模型同步完成", "total_public", len(publicModels), "added", added, "updated", updated, "disabled", disabled)
-			} else {
-				slog.Debug("上游模型同步完成，无新增", "total_public", len(publicModels), "updated", updated, "disabled", disabled)
-			}
+	syncGrokModels := func(ctx context.Context) {
+		accounts, err := s.GetEnabledAccounts(context.Background())
+		if err != nil {
+			slog.Warn("Grok 模型同步: 获取账号失败", "error", err)
+			return
 		}
 
-		syncWarpModels := func() {
-			accounts, err := s.GetEnabledAccounts(context.Background())
-			if err != nil {
-				slog.Warn("Warp 模型同步: 获取账号失败", "error", err)
-				return
+		var token string
+		for _, acc := range accounts {
+			if !strings.EqualFold(acc.AccountType, "grok") {
+				continue
 			}
-			var warpAcc *store.Account
-			for _, acc := range accounts {
-				if strings.EqualFold(acc.AccountType, "warp") && strings.TrimSpace(acc.Token) != "" {
-					warpAcc = acc
-					break
-				}
+			token = grok.NormalizeSSOToken(acc.ClientCookie)
+			if token == "" {
+				token = grok.NormalizeSSOToken(acc.RefreshToken)
 			}
-			if warpAcc == nil {
-				slog.Debug("Warp 模型同步: 无可用 Warp 账号")
-				return
+			if token != "" {
+				break
 			}
+		}
+		if token == "" {
+			slog.Debug("Grok 模型同步: 无可用 Grok 账号")
+			return
+		}
 
-			warpClient := warp.NewFromAccount(warpAcc, cfg)
-			fetchCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-			defer cancel()
-
-			choices, err := warpClient.GetFeatureModelChoices(fetchCtx)
-			if err != nil {
-				slog.Warn("Warp 模型同步: 获取失败", "error", err)
-				return
-			}
+		existingModels, err := s.ListModels(context.Background())
+		if err != nil {
+			slog.Warn("Grok 模型同步: 获取模型列表失败", "error", err)
+			return
+		}
 
-			seen := make(map[string]bool)
-			added := 0
-			categories := []*warp.FeatureModelCategory{choices.AgentMode, choices.Planning, choices.Coding, choices.CliAgent}
-			for _, cat := range categories {
-				if cat == nil {
-					continue
-				}
-				for _, choice := range cat.Choices {
-					modelID := strings.TrimSpace(choice.ID)
-					if modelID == "" || seen[modelID] {
-						continue
-					}
-					seen[modelID] = true
-					if _, err := s.GetModelByModelID(context.Background(), modelID); err == nil {
-						continue
-					}
-					displayName := choice.DisplayName
-					if displayName == "" {
-						displayName = modelID
-					}
-					newModel := &store.Model{
-						Channel: "Warp",
-						ModelID: modelID,
-						Name:    displayName + " (Warp)",
-						Status:  store.ModelStatusAvailable,
-					}
-					if err := s.CreateModel(context.Background(), newModel); err != nil {
-						slog.Warn("Warp 模型同步: 创建模型失败", "model_id", modelID, "error", err)
-						continue
-					}
-					added++
-					slog.Info("Warp 模型同步: 新增模型", "model_id", modelID, "name", displayName)
-				}
+		candidateSet := map[string]struct{}{}
+		for _, m := range grok.SupportedModels {
+			if m.IsImage || m.IsVideo {
+				continue
 			}
-			if added > 0 {
-				slog.Info("Warp 模型同步完成", "added", added)
-			} else {
-				slog.Debug("Warp 模型同步完成，无新增")
+			id := strings.TrimSpace(m.ID)
+			if id != "" {
+				candidateSet[id] = struct{}{}
 			}
 		}
-
-		syncGrokModels := func() {
-			accounts, err := s.GetEnabledAccounts(context.Background())
-			if err != nil {
-				slog.Warn("Grok 模型同步: 获取账号失败", "error", err)
-				return
+		for _, probe := range buildGrokVersionProbes(existingModels) {
+			if probe != "" {
+				candidateSet[probe] = struct{}{}
 			}
+		}
 
-			var token string
-			for _, acc := range accounts {
-				if !strings.EqualFold(acc.AccountType, "grok") {
-					continue
-				}
-				token = grok.NormalizeSSOToken(acc.ClientCookie)
-				if token == "" {
-					token = grok.NormalizeSSOToken(acc.RefreshToken)
-				}
-				if token != "" {
-					break
+		publicCandidates, fetchErr := fetchPublicGrokModelIDs(context.Background())
+		if fetchErr != nil {
+			slog.Warn("Grok 模型同步: 公共模型源抓取失败", "error", fetchErr)
+		} else {
+			for _, id := range publicCandidates {
+				if id != "" {
+					candidateSet[id] = struct{}{}
 				}
 			}
-			if token == "" {
-				slog.Debug("Grok 模型同步: 无可用 Grok 账号")
-				return
-			}
+		}
 
-			existingModels, err := s.ListModels(context.Background())
-			if err != nil {
-				slog.Warn("Grok 模型同步: 获取模型列表失败", "error", err)
-				return
-			}
+		if len(candidateSet) == 0 {
+			slog.Debug("Grok 模型同步: 无候选模型")
+			return
+		}
 
-			candidateSet := map[string]struct{}{}
-			for _, m := range grok.SupportedModels {
-				if m.IsImage || m.IsVideo {
-					continue
-				}
-				id := strings.TrimSpace(m.ID)
-				if id != "" {
-					candidateSet[id] = struct{}{}
-				}
+		candidates := make([]string, 0, len(candidateSet))
+		for id := range candidateSet {
+			candidates = append(candidates, id)
+		}
+		sort.Strings(candidates)
+
+		pendingModelIDs := make([]string, 0, len(candidates))
+		pendingSeen := map[string]struct{}{}
+		pendingNames := map[string]string{}
+		for _, candidate := range candidates {
+			spec, ok := grok.ResolveModelOrDynamic(candidate)
+			if !ok || spec.IsImage || spec.IsVideo {
+				continue
 			}
-			for _, probe := range buildGrokVersionProbes(existingModels) {
-				if probe != "" {
-					candidateSet[probe] = struct{}{}
-				}
+			modelID := strings.TrimSpace(spec.ID)
+			if modelID == "" {
+				continue
 			}
-
-			publicCandidates, fetchErr := fetchPublicGrokModelIDs(context.Background())
-			if fetchErr != nil {
-				slog.Warn("Grok 模型同步: 公共模型源抓取失败", "error", fetchErr)
-			} else {
-				for _, id := range publicCandidates {
-					if id != "" {
-						candidateSet[id] = struct{}{}
-					}
-				}
+			if _, exists := pendingSeen[modelID]; exists {
+				continue
 			}
-
-			if len(candidateSet) == 0 {
-				slog.Debug("Grok 模型同步: 无候选模型")
-				return
+			if _, err := s.GetModelByModelID(context.Background(), modelID); err == nil {
+				continue
 			}
+			pendingSeen[modelID] = struct{}{}
+			pendingNames[modelID] = strings.TrimSpace(spec.Name)
+			pendingModelIDs = append(pendingModelIDs, modelID)
+		}
+		if len(pendingModelIDs) == 0 {
+			slog.Debug("Grok 模型同步: 无需探测候选", "candidates", len(candidates))
+			return
+		}
 
-			candidates := make([]string, 0, len(candidateSet))
-			for id := range candidateSet {
-				candidates = append(candidates, id)
-			}
-			sort.Strings(candidates)
+		probeModelIDs, limited := limitProbeModelIDs(pendingModelIDs, grokModelProbeLimitPerRun)
+		if limited {
+			slog.Info("Grok 模型同步: 本轮探测限流", "pending", len(pendingModelIDs), "limit", len(probeModelIDs))
+		}
 
-			pendingModelIDs := make([]string, 0, len(candidates))
-			pendingSeen := map[string]struct{}{}
-			pendingNames := map[string]string{}
-			for _, candidate := range candidates {
-				spec, ok := grok.ResolveModelOrDynamic(candidate)
-				if !ok || spec.IsImage || spec.IsVideo {
-					continue
-				}
-				modelID := strings.TrimSpace(spec.ID)
-				if modelID == "" {
-					continue
-				}
-				if _, exists := pendingSeen[modelID]; exists {
-					continue
-				}
-				if _, err := s.GetModelByModelID(context.Background(), modelID); err == nil {
-					continue
-				}
-				pendingSeen[modelID] = struct{}{}
-				pendingNames[modelID] = strings.TrimSpace(spec.Name)
-				pendingModelIDs = append(pendingModelIDs, modelID)
-			}
-			if len(pendingModelIDs) == 0 {
-				slog.Debug("Grok 模型同步: 无需探测候选", "candidates", len(candidates))
+		grokClient := grok.New(cfg)
+		added := 0
+		checked := 0
+		for i, modelID := range probeModelIDs {
+			if i > 0 && !sleepWithContext(ctx, grokModelProbeInterval) {
 				return
 			}
 
-			probeModelIDs, limited := limitProbeModelIDs(pendingModelIDs, grokModelProbeLimitPerRun)
-			if limited {
-				slog.Info("Grok 模型同步: 本轮探测限流", "pending", len(pendingModelIDs), "limit", len(probeModelIDs))
+			verifyCtx, cancel := context.WithTimeout(ctx, 12*time.Second)
+			_, verifyErr := grokClient.GetUsage(verifyCtx, token, modelID)
+			cancel()
+			checked++
+			if verifyErr != nil {
+				slog.Debug("Grok 模型同步: 候选模型校验失败", "model_id", modelID, "error", verifyErr)
+				continue
 			}
 
-			grokClient := grok.New(cfg)
-			added := 0
-			checked := 0
-			for i, modelID := range probeModelIDs {
-				if i > 0 && !sleepWithContext(ctx, grokModelProbeInterval) {
-					return
-				}
-
-				verifyCtx, cancel := context.WithTimeout(ctx, 12*time.Second)
-				_, verifyErr := grokClient.GetUsage(verifyCtx, token, modelID)
-				cancel()
-				checked++
-				if verifyErr != nil {
-					slog.Debug("Grok 模型同步: 候选模型校验失败", "model_id", modelID, "error", verifyErr)
-					continue
-				}
-
-				name := strings.TrimSpace(pendingNames[modelID])
-				if name == "" {
-					name = modelID
-				}
-				newModel := &store.Model{
-					Channel: "Grok",
-					ModelID: modelID,
-					Name:    name,
-					Status:  store.ModelStatusAvailable,
-				}
-				if err := s.CreateModel(context.Background(), newModel); err != nil {
-					// Handle create races gracefully.
-					if _, getErr := s.GetModelByModelID(context.Background(), modelID); getErr == nil {
-						continue
-					}
-					slog.Warn("Grok 模型同步: 创建模型失败", "model_id", modelID, "error", err)
+			name := strings.TrimSpace(pendingNames[modelID])
+			if name == "" {
+				name = modelID
+			}
+			newModel := &store.Model{
+				Channel: "Grok",
+				ModelID: modelID,
+				Name:    name,
+				Status:  store.ModelStatusAvailable,
+			}
+			if err := s.CreateModel(context.Background(), newModel); err != nil {
+				// Handle create races gracefully.
+				if _, getErr := s.GetModelByModelID(context.Background(), modelID); getErr == nil {
 					continue
 				}
-				added++
-				slog.Info("Grok 模型同步: 新增模型", "model_id", modelID)
-			}
-
-			if added > 0 {
-				slog.Info("Grok 模型同步完成", "candidates", len(candidates), "pending", len(pendingModelIDs), "checked", checked, "added", added)
-			} else {
-				slog.Debug("Grok 模型同步完成，无新增", "candidates", len(candidates), "pending", len(pendingModelIDs), "checked", checked)
+				slog.Warn("Grok 模型同步: 创建模型失败", "model_id", modelID, "error", err)
+				continue
 			}
+			added++
+			slog.Info("Grok 模型同步: 新增模型", "model_id", modelID)
 		}
 
-		// Wait 10 seconds at startup for token refresh to complete
-		select {
-		case <-ctx.Done():
-			return
-		case <-time.After(10 * time.Second):
+		if added > 0 {
+			slog.Info("Grok 模型同步完成", "candidates", len(candidates), "pending", len(pendingModelIDs), "checked", checked, "added", added)
+		} else {
+			slog.Debug("Grok 模型同步完成，无新增", "candidates", len(candidates), "pending", len(pendingModelIDs), "checked", checked)
 		}
-		syncModels()
-		syncWarpModels()
-		syncGrokModels()
+	}
 
-		ticker := time.NewTicker(30 * time.Minute)
-		defer ticker.Stop()
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			case <-ticker.C:
-				syncModels()
-				syncWarpModels()
-				syncGrokModels()
-			}
-		}
-	}()
+	return scheduler.Job{
+		Name:     "model_sync",
+		Interval: 30 * time.Minute,
+		Delay:    10 * time.Second,
+		Run: func(ctx context.Context) error {
+			syncModels()
+			syncWarpModels()
+			syncGrokModels(ctx)
+			return nil
+		},
+	}
 }
 
 var grokModelIDPattern = regexp.MustCompile(`\bgrok-[a-z0-9][a-z0-9.-]*\b`)
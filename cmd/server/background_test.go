@@ -1,10 +1,21 @@
 package main
 
 import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
 	"slices"
 	"testing"
+	"time"
 
+	"github.com/alicebob/miniredis/v2"
+
+	"orchids-api/internal/clerk"
+	"orchids-api/internal/config"
+	"orchids-api/internal/events"
 	"orchids-api/internal/grok"
+	"orchids-api/internal/loadbalancer"
 	"orchids-api/internal/store"
 )
 
@@ -98,3 +109,84 @@ func TestProbeModelWindow(t *testing.T) {
 		t.Fatalf("probeModelWindow()=%+v want %+v", got, want)
 	}
 }
+
+func TestTokenExpiryJob(t *testing.T) {
+	mr := miniredis.RunT(t)
+	s, err := store.New(store.Options{RedisAddr: mr.Addr(), RedisPrefix: "test:"})
+	if err != nil {
+		t.Fatalf("store.New: %v", err)
+	}
+	ctx := context.Background()
+	jwt := func(exp time.Time) string {
+		payload := fmt.Sprintf(`{"exp":%d}`, exp.Unix())
+		return "eyJhbGciOiJIUzI1NiJ9." + base64.RawURLEncoding.EncodeToString([]byte(payload)) + ".c2lnbmF0dXJl"
+	}
+	soon := jwt(time.Now().Add(2 * time.Minute))
+	accounts := map[string]*store.Account{
+		"renewable": {AccountType: "warp", RefreshToken: "rt", Token: soon},
+		"stuck":     {AccountType: "warp", RefreshToken: "rt", Token: soon},
+		"token":     {AccountType: "orchids", Token: soon},
+		"clerk":     {AccountType: "orchids", ClientCookie: "cookie", Token: soon},
+		"fresh":     {AccountType: "warp", RefreshToken: "rt", Token: jwt(time.Now().Add(time.Hour))},
+	}
+	for name, acc := range accounts {
+		acc.Name, acc.Enabled = name, true
+		if err := s.CreateAccount(ctx, acc); err != nil {
+			t.Fatalf("CreateAccount: %v", err)
+		}
+	}
+
+	var refreshed []string
+	refresh := func(acc *store.Account) {
+		refreshed = append(refreshed, acc.Name)
+		if acc.Name == "renewable" {
+			acc.Token = jwt(time.Now().Add(time.Hour))
+			if err := s.UpdateAccount(ctx, acc); err != nil {
+				t.Fatalf("UpdateAccount: %v", err)
+			}
+		}
+	}
+	ch, cancel := events.Default.Subscribe(10)
+	defer cancel()
+
+	job := tokenExpiryJob(&config.Config{TokenExpiryWindow: 600}, s, refresh)
+	for i := 0; i < 2; i++ {
+		if err := job.Run(ctx); err != nil {
+			t.Fatalf("run %d: %v", i, err)
+		}
+	}
+
+	slices.Sort(refreshed)
+	if !slices.Equal(refreshed, []string{"renewable", "stuck"}) {
+		t.Fatalf("refreshed %v, want renewable and stuck once each", refreshed)
+	}
+	var flagged []string
+	for len(ch) > 0 {
+		e := <-ch
+		if e.Type != events.AccountTokenExpiring || e.Level != events.LevelWarning {
+			t.Fatalf("unexpected event %+v", e)
+		}
+		flagged = append(flagged, e.Data["account"].(string))
+	}
+	slices.Sort(flagged)
+	if !slices.Equal(flagged, []string{"stuck", "token"}) {
+		t.Fatalf("flagged %v, want stuck and token once each", flagged)
+	}
+}
+
+func TestRefreshFailureStatus(t *testing.T) {
+	cases := map[string]error{
+		"401":                              errors.New("unexpected status code 401: temporarily unauthorized"),
+		"403":                              errors.New("unexpected status code 403: forbidden"),
+		loadbalancer.StatusReloginRequired: fmt.Errorf("touch: %w", clerk.ErrReloginRequired),
+		"":                                 errors.New("unexpected status code 502: bad gateway"),
+	}
+	for want, err := range cases {
+		if got := refreshFailureStatus(err); got != want {
+			t.Errorf("refreshFailureStatus(%v) = %q, want %q", err, got, want)
+		}
+	}
+	if got := refreshFailureStatus(errors.New("no active sessions")); got != loadbalancer.StatusReloginRequired {
+		t.Errorf("no active sessions = %q, want %q", got, loadbalancer.StatusReloginRequired)
+	}
+}
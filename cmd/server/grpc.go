@@ -0,0 +1,48 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"orchids-api/internal/api"
+	"orchids-api/internal/config"
+	"orchids-api/internal/grpcapi"
+	"orchids-api/internal/handler"
+	"orchids-api/internal/middleware"
+)
+
+// newGRPCServer builds the optional gRPC listener. It serves cleartext HTTP/2
+// only, since gRPC clients do not fall back to HTTP/1.1.
+func newGRPCServer(cfg *config.Config, h *handler.Handler, apiHandler *api.API, limiter *middleware.ConcurrencyLimiter) *http.Server {
+	sessionAuth := func(h http.HandlerFunc) http.HandlerFunc {
+		return middleware.SessionAuth(cfg.AdminPass, cfg.AdminToken, h)
+	}
+	grpcHandler := grpcapi.New(limiter.Limit(h.HandleMessages), map[string]http.HandlerFunc{
+		"ListAccounts": sessionAuth(apiHandler.HandleAccounts),
+		"ListKeys":     sessionAuth(apiHandler.HandleKeys),
+		"ListModels":   sessionAuth(apiHandler.HandleModels),
+		"GetConfig":    sessionAuth(apiHandler.HandleConfig),
+	})
+
+	// Same chain as the main server minus SecurityHeaders, so panics in the
+	// messages pipeline are recovered and logged here too.
+	wrapped := middleware.Chain(
+		middleware.BodyReadDeadline(time.Duration(cfg.RequestBodyTimeout)*time.Second),
+		middleware.TraceMiddleware,
+		middleware.LoggingMiddleware,
+		middleware.Recover,
+	)(grpcHandler)
+
+	var protocols http.Protocols
+	protocols.SetUnencryptedHTTP2(true)
+	server := &http.Server{
+		Addr:              cfg.GRPCAddr,
+		Handler:           wrapped,
+		Protocols:         &protocols,
+		ReadHeaderTimeout: time.Duration(cfg.ServerReadHeaderTimeout) * time.Second,
+		IdleTimeout:       time.Duration(cfg.ServerIdleTimeout) * time.Second,
+	}
+	slog.Info("gRPC API enabled", "addr", cfg.GRPCAddr)
+	return server
+}
@@ -17,8 +17,10 @@ import (
 	"orchids-api/internal/audit"
 	"orchids-api/internal/config"
 	"orchids-api/internal/debug"
+	"orchids-api/internal/featureflag"
 	"orchids-api/internal/grok"
 	"orchids-api/internal/handler"
+	"orchids-api/internal/hooks"
 	"orchids-api/internal/loadbalancer"
 	"orchids-api/internal/middleware"
 	"orchids-api/internal/provider"
@@ -80,7 +82,13 @@ func main() {
 		}
 	}
 
+	if err := featureflag.Default.Load(context.Background(), s); err != nil {
+		slog.Warn("Failed to load feature flags, using defaults", "error", err)
+	}
+
 	lb := loadbalancer.NewWithCacheTTL(s, time.Duration(cfg.LoadBalancerCacheTTL)*time.Second)
+	lb.SetAccountConcurrencyLimit(cfg.AccountMaxConcurrency)
+	lb.SetAccountRPMLimit(cfg.AccountMaxRPM)
 
 	// Connection tracker: use Redis when available
 	if redisClient := s.RedisClient(); redisClient != nil {
@@ -90,6 +98,10 @@ func main() {
 
 	apiHandler := api.New(s, cfg.AdminUser, cfg.AdminPass, cfg)
 	h := handler.NewWithLoadBalancer(cfg, lb)
+	if cfg.HealthWeighting {
+		lb.SetHealthWeighting(h, cfg.HealthWeightLow, cfg.HealthWeightHigh, cfg.HealthWeightMin)
+	}
+	lb.SetSlowAccountChecker(h)
 	grokHandler := grok.NewHandler(cfg, lb)
 
 	// Token cache: use Redis when available, fall back to memory
@@ -98,11 +110,14 @@ func main() {
 		tokenCache = tokencache.NewRedisCache(redisClient, s.RedisPrefix(), time.Duration(cfg.CacheTTL)*time.Minute)
 		slog.Info("Token cache initialized", "backend", "redis")
 	} else {
-		tokenCache = tokencache.NewMemoryCache(time.Duration(cfg.CacheTTL)*time.Minute, 10000)
+		tokenCache = tokencache.NewMemoryCacheWithLimits(time.Duration(cfg.CacheTTL)*time.Minute, 10000, cfg.CacheMaxBytes)
 		slog.Info("Token cache initialized", "backend", "memory")
 	}
 	h.SetTokenCache(tokenCache)
 	apiHandler.SetTokenCache(tokenCache)
+	apiHandler.SetAccountRequests(h)
+	apiHandler.SetAccountHealth(h)
+	apiHandler.SetAccountCleanup(h)
 
 	// Session store: use Redis when available, fall back to memory
 	if redisClient := s.RedisClient(); redisClient != nil {
@@ -110,12 +125,20 @@ func main() {
 		h.SetSessionStore(sessionStore)
 		slog.Info("Session store initialized", "backend", "redis")
 
+		usageTTL := time.Duration(cfg.ConversationUsageTTL) * time.Hour
+		h.SetConversationUsageStore(handler.NewRedisConversationUsageStore(redisClient, s.RedisPrefix(), usageTTL))
+		slog.Info("Conversation usage store initialized", "backend", "redis")
+
+		h.SetKeyUsageStore(handler.NewRedisKeyUsageStore(redisClient, s.RedisPrefix()))
+		slog.Info("API key usage store initialized", "backend", "redis")
+
 		dedupStore := handler.NewRedisDedupStore(redisClient, s.RedisPrefix(), 2*time.Second)
 		h.SetDedupStore(dedupStore)
 		slog.Info("Dedup store initialized", "backend", "redis")
 
 		auditLogger := audit.NewRedisLogger(redisClient, s.RedisPrefix(), 10000)
 		h.SetAuditLogger(auditLogger)
+		apiHandler.SetAuditLog(auditLogger)
 		defer auditLogger.Close()
 		slog.Info("Audit logger initialized", "backend", "redis")
 	}
@@ -124,6 +147,9 @@ func main() {
 	registry := provider.NewRegistry()
 	registry.Register("orchids", provider.NewOrchidsProvider())
 	registry.Register("warp", provider.NewWarpProvider())
+	registry.Register("kiro", provider.NewKiroProvider())
+	registry.Register("openai-compatible", provider.NewOpenAICompatProvider())
+	registry.Register("anthropic", provider.NewAnthropicProvider())
 	h.SetClientFactory(func(acc *store.Account, c *config.Config) handler.UpstreamClient {
 		if p := registry.Get(acc.AccountType); p != nil {
 			if client, ok := p.NewClient(acc, c).(handler.UpstreamClient); ok {
@@ -139,6 +165,20 @@ func main() {
 		return nil
 	})
 
+	// Transformation hooks: config rules (declarative fields and Lua scripts);
+	// custom Go hooks can be registered on the same registry.
+	hookRegistry := hooks.NewRegistry()
+	for _, err := range hooks.RegisterRules(hookRegistry, cfg.Hooks) {
+		slog.Warn("Invalid hook rule skipped", "error", err)
+	}
+	if redaction, err := hooks.NewSecretRedactionHook(cfg.OutputRedaction); err != nil {
+		slog.Warn("Invalid output_redaction config, redaction disabled", "error", err)
+	} else if redaction != nil {
+		hookRegistry.Register("", redaction)
+		slog.Info("Output redaction enabled", "patterns", cfg.OutputRedaction)
+	}
+	h.SetHooks(hookRegistry)
+
 	// Initialize template renderer
 	tmplRenderer, err := template.NewRenderer()
 	if err != nil {
@@ -147,6 +187,9 @@ func main() {
 	}
 	slog.Info("Template renderer initialized")
 
+	jobs := newScheduler(cfg, s, lb)
+	apiHandler.SetScheduler(jobs)
+
 	// Register routes
 	mux := http.NewServeMux()
 	limiter := middleware.NewConcurrencyLimiter(cfg.ConcurrencyLimit, time.Duration(cfg.ConcurrencyTimeout)*time.Second, cfg.AdaptiveTimeout)
@@ -156,22 +199,34 @@ func main() {
 	server := &http.Server{
 		Addr: ":" + cfg.Port,
 		Handler: middleware.Chain(
+			middleware.BodyReadDeadline(time.Duration(cfg.RequestBodyTimeout)*time.Second),
 			middleware.SecurityHeaders,
 			middleware.TraceMiddleware,
 			middleware.LoggingMiddleware,
+			middleware.Recover,
 		)(mux),
-		ReadHeaderTimeout: 10 * time.Second,
-		ReadTimeout:       30 * time.Second,
-		IdleTimeout:       60 * time.Second,
+		ReadHeaderTimeout: time.Duration(cfg.ServerReadHeaderTimeout) * time.Second,
+		ReadTimeout:       time.Duration(cfg.ServerReadTimeout) * time.Second,
+		WriteTimeout:      time.Duration(cfg.ServerWriteTimeout) * time.Second,
+		IdleTimeout:       time.Duration(cfg.ServerIdleTimeout) * time.Second,
+	}
+
+	var grpcServer *http.Server
+	if cfg.GRPCAddr != "" {
+		grpcServer = newGRPCServer(cfg, h, apiHandler, limiter)
+		go func() {
+			if err := grpcServer.ListenAndServe(); err != http.ErrServerClosed {
+				slog.Error("gRPC server failed", "error", err)
+			}
+		}()
 	}
 
 	// Start background tasks
 	ctx, cancelBackground := context.WithCancel(context.Background())
 	defer cancelBackground()
 
-	startTokenRefreshLoop(ctx, cfg, s, lb)
-	startAuthCleanupLoop(ctx)
-	startModelSyncLoop(ctx, cfg, s)
+	jobs.Start(ctx)
+	featureflag.Default.StartRefresh(ctx, s, featureFlagRefreshInterval)
 
 	// Graceful shutdown
 	idleConnsClosed := make(chan struct{})
@@ -189,6 +244,11 @@ func main() {
 		if err := server.Shutdown(shutdownCtx); err != nil {
 			slog.Error("Server shutdown error", "error", err)
 		}
+		if grpcServer != nil {
+			if err := grpcServer.Shutdown(shutdownCtx); err != nil {
+				slog.Error("gRPC server shutdown error", "error", err)
+			}
+		}
 		close(idleConnsClosed)
 	}()
 
@@ -36,19 +36,41 @@ func registerRoutes(
 	tmplRenderer *template.Renderer,
 ) {
 	// --- Channel-specific message routes ---
-	mux.HandleFunc("/orchids/v1/messages", limiter.Limit(h.HandleMessages))
+	// "Prefer: respond-async" requests are queued as operations.
+	messages := h.AsyncMessagesHandler(limiter.Limit(h.HandleMessages))
+	mux.HandleFunc("/orchids/v1/messages", messages)
 	mux.HandleFunc("/orchids/v1/messages/count_tokens", limiter.Limit(h.HandleCountTokens))
-	mux.HandleFunc("/warp/v1/messages", limiter.Limit(h.HandleMessages))
+	mux.HandleFunc("/warp/v1/messages", messages)
 	mux.HandleFunc("/warp/v1/messages/count_tokens", limiter.Limit(h.HandleCountTokens))
+	mux.HandleFunc("/kiro/v1/messages", messages)
+	mux.HandleFunc("/kiro/v1/messages/count_tokens", limiter.Limit(h.HandleCountTokens))
+	// Unscoped route: the channel is inferred from the requested model.
+	mux.HandleFunc("/v1/messages", messages)
+	mux.HandleFunc("/v1/messages/count_tokens", limiter.Limit(h.HandleCountTokens))
 
-	// --- Model routes (4 channel prefixes → same handlers) ---
-	modelPrefixes := []string{"/orchids/v1", "/warp/v1", "/grok/v1", "/v1"}
+	// Results of requests sent with "Prefer: respond-async", and of recent
+	// non-stream requests whose client gave up waiting.
+	mux.HandleFunc("/v1/operations/", h.HandleOperation)
+	mux.HandleFunc("/v1/messages/", h.HandleMessageResult)
+
+	// OpenAI-compatible embeddings, served by the configured embeddings backend.
+	mux.HandleFunc("/v1/embeddings", limiter.Limit(h.HandleEmbeddings))
+
+	// The calling API key's own usage and limits; no admin session needed.
+	mux.HandleFunc("/v1/usage", h.HandleKeyUsage)
+
+	// --- WebSocket message streaming (each request still passes the limiter) ---
+	messagesWS := h.MessagesWSHandler(limiter.Limit(h.HandleMessages))
+	registerWithPrefixes(mux, []string{"/orchids/v1", "/warp/v1", "/kiro/v1", "/v1"}, "/messages/ws", messagesWS)
+
+	// --- Model routes (5 channel prefixes → same handlers) ---
+	modelPrefixes := []string{"/orchids/v1", "/warp/v1", "/kiro/v1", "/grok/v1", "/v1"}
 	registerWithPrefixes(mux, modelPrefixes, "/models", h.HandleModels)
 	registerWithPrefixes(mux, modelPrefixes, "/models/", h.HandleModelByID)
 
 	// --- OpenAI-compatible chat/image routes (channel-specific + unified) ---
-	mux.HandleFunc("/orchids/v1/chat/completions", limiter.Limit(h.HandleMessages))
-	mux.HandleFunc("/warp/v1/chat/completions", limiter.Limit(h.HandleMessages))
+	mux.HandleFunc("/orchids/v1/chat/completions", messages)
+	mux.HandleFunc("/warp/v1/chat/completions", messages)
 
 	grokPrefixes := []string{"/grok/v1", "/v1"}
 	registerWithPrefixes(mux, grokPrefixes, "/chat/completions", limiter.Limit(grokHandler.HandleChatCompletions))
@@ -65,18 +87,50 @@ func registerRoutes(
 		return middleware.SessionAuth(cfg.AdminPass, cfg.AdminToken, h)
 	}
 
+	// Tenant admins may manage their own accounts and keys and read their
+	// model view; every other admin route is for the global admin only.
+	tenantAuth := func(h http.HandlerFunc) http.HandlerFunc {
+		return middleware.TenantSessionAuth(cfg.AdminPass, cfg.AdminToken, h)
+	}
+
 	// Admin routes under /api/* only (no dual prefix)
-	mux.HandleFunc("/api/accounts", sessionAuth(apiHandler.HandleAccounts))
-	mux.HandleFunc("/api/accounts/", sessionAuth(apiHandler.HandleAccountByID))
-	mux.HandleFunc("/api/keys", sessionAuth(apiHandler.HandleKeys))
-	mux.HandleFunc("/api/keys/", sessionAuth(apiHandler.HandleKeyByID))
-	mux.HandleFunc("/api/models", sessionAuth(apiHandler.HandleModels))
-	mux.HandleFunc("/api/models/", sessionAuth(apiHandler.HandleModelByID))
+	mux.HandleFunc("/api/accounts", tenantAuth(apiHandler.HandleAccounts))
+	mux.HandleFunc("/api/accounts/", tenantAuth(apiHandler.HandleAccountByID))
+	mux.HandleFunc("/api/accounts/parse", sessionAuth(apiHandler.HandleParseAccount))
+	mux.HandleFunc("/api/keys", tenantAuth(apiHandler.HandleKeys))
+	mux.HandleFunc("/api/keys/", tenantAuth(apiHandler.HandleKeyByID))
+	mux.HandleFunc("/api/models", tenantAuth(middleware.TenantReadOnly(apiHandler.HandleModels)))
+	mux.HandleFunc("/api/models/", tenantAuth(middleware.TenantReadOnly(apiHandler.HandleModelByID)))
+	mux.HandleFunc("/api/tenants", sessionAuth(apiHandler.HandleTenants))
+	mux.HandleFunc("/api/tenants/", sessionAuth(apiHandler.HandleTenantByID))
+	mux.HandleFunc("/api/experiments", sessionAuth(apiHandler.HandleExperiments))
+	mux.HandleFunc("/api/experiments/", sessionAuth(apiHandler.HandleExperimentByID))
+	mux.HandleFunc("/api/channels", sessionAuth(apiHandler.HandleChannels))
+	mux.HandleFunc("/api/channels/", sessionAuth(apiHandler.HandleChannelByName))
 	mux.HandleFunc("/api/export", sessionAuth(apiHandler.HandleExport))
 	mux.HandleFunc("/api/import", sessionAuth(apiHandler.HandleImport))
 	mux.HandleFunc("/api/config", sessionAuth(apiHandler.HandleConfig))
+	mux.HandleFunc("/api/config/history", sessionAuth(apiHandler.HandleConfigHistory))
+	mux.HandleFunc("/api/config/history/", sessionAuth(apiHandler.HandleConfigHistory))
+	mux.HandleFunc("/api/config/rollback/", sessionAuth(apiHandler.HandleConfigRollback))
 	mux.HandleFunc("/api/config/cache/stats", sessionAuth(apiHandler.HandleCacheStats))
 	mux.HandleFunc("/api/config/cache/clear", sessionAuth(apiHandler.HandleCacheClear))
+	mux.HandleFunc("/api/token-cache/stats", sessionAuth(apiHandler.HandleCacheStats))
+	mux.HandleFunc("/api/token-cache/clear", sessionAuth(apiHandler.HandleCacheClear))
+	mux.HandleFunc("/api/usage/users", sessionAuth(h.HandleEndUserUsage))
+	mux.HandleFunc("/api/conversations/", sessionAuth(h.HandleConversationUsage))
+	mux.HandleFunc("/api/requests/active", sessionAuth(h.HandleActiveRequests))
+	mux.HandleFunc("/api/requests/active/", sessionAuth(h.HandleActiveRequestByID))
+	mux.HandleFunc("/api/route/preview", sessionAuth(h.HandleRoutePreview))
+	mux.HandleFunc("/api/prompt/preview", sessionAuth(h.HandlePromptPreview))
+	mux.HandleFunc("/api/settings", sessionAuth(apiHandler.HandleSettings))
+	mux.HandleFunc("/api/settings/", sessionAuth(apiHandler.HandleSettingByKey))
+	mux.HandleFunc("/api/flags", sessionAuth(apiHandler.HandleFlags))
+	mux.HandleFunc("/api/flags/", sessionAuth(apiHandler.HandleFlagByName))
+	mux.HandleFunc("/api/jobs", sessionAuth(apiHandler.HandleJobs))
+	mux.HandleFunc("/api/jobs/", sessionAuth(apiHandler.HandleJobByName))
+	// Server-sent admin notifications (account disabled, breaker opened, ...).
+	mux.HandleFunc("/api/events", sessionAuth(apiHandler.HandleEvents))
 
 	// Admin routes with dual prefix: /api/v1/admin/* and /v1/admin/*
 	adminPrefixes := []string{"/api/v1/admin", "/v1/admin"}
@@ -0,0 +1,64 @@
+package adapter
+
+import (
+	"bytes"
+
+	"github.com/goccy/go-json"
+)
+
+// StreamEncoder renders the handler's internal event stream (Anthropic-shaped
+// events such as message_start / content_block_delta) in one wire format.
+// Encoders may keep per-stream state and are not safe for concurrent use.
+type StreamEncoder interface {
+	// Encode writes the wire form of one event to buf and reports whether
+	// anything was written; events with no equivalent are dropped.
+	Encode(buf *bytes.Buffer, event string, data []byte) bool
+	// KeepAlive returns a frame that keeps idle connections open.
+	KeepAlive() []byte
+}
+
+// NewStreamEncoder returns the encoder for format.
+func NewStreamEncoder(format ResponseFormat, msgID string, created int64) StreamEncoder {
+	switch format {
+	case FormatOpenAI:
+		return newOpenAIStreamEncoder(msgID, created)
+	case FormatNDJSON:
+		return ndjsonEncoder{}
+	default:
+		return anthropicSSEEncoder{}
+	}
+}
+
+var sseKeepAlive = []byte(": keep-alive\n\n")
+
+type anthropicSSEEncoder struct{}
+
+func (anthropicSSEEncoder) Encode(buf *bytes.Buffer, event string, data []byte) bool {
+	buf.WriteString("event: ")
+	buf.WriteString(event)
+	buf.WriteString("\ndata: ")
+	buf.Write(data)
+	buf.WriteString("\n\n")
+	return true
+}
+
+func (anthropicSSEEncoder) KeepAlive() []byte { return sseKeepAlive }
+
+type ndjsonEncoder struct{}
+
+var ndjsonKeepAlive = []byte(`{"event":"ping","data":{"type":"ping"}}` + "\n")
+
+func (ndjsonEncoder) Encode(buf *bytes.Buffer, event string, data []byte) bool {
+	name, err := json.Marshal(event)
+	if err != nil || !json.Valid(data) {
+		return false
+	}
+	buf.WriteString(`{"event":`)
+	buf.Write(name)
+	buf.WriteString(`,"data":`)
+	buf.Write(data)
+	buf.WriteString("}\n")
+	return true
+}
+
+func (ndjsonEncoder) KeepAlive() []byte { return ndjsonKeepAlive }
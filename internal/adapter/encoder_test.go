@@ -0,0 +1,137 @@
+package adapter
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/goccy/go-json"
+)
+
+func TestNegotiateResponseFormat(t *testing.T) {
+	cases := []struct {
+		path, accept string
+		want         ResponseFormat
+	}{
+		{"/orchids/v1/chat/completions", "application/x-ndjson", FormatOpenAI},
+		{"/orchids/v1/messages", "application/x-ndjson", FormatNDJSON},
+		{"/orchids/v1/messages", "Application/JSONL", FormatNDJSON},
+		{"/orchids/v1/messages", "text/event-stream", FormatAnthropic},
+		{"/orchids/v1/messages", "", FormatAnthropic},
+	}
+	for _, tc := range cases {
+		if got := NegotiateResponseFormat(tc.path, tc.accept); got != tc.want {
+			t.Fatalf("NegotiateResponseFormat(%q, %q) = %q, want %q", tc.path, tc.accept, got, tc.want)
+		}
+	}
+}
+
+func openAIChunks(t *testing.T, enc StreamEncoder, events [][2]string) []map[string]interface{} {
+	t.Helper()
+	var buf bytes.Buffer
+	for _, ev := range events {
+		enc.Encode(&buf, ev[0], []byte(ev[1]))
+	}
+	var chunks []map[string]interface{}
+	for _, frame := range strings.Split(strings.TrimSpace(buf.String()), "\n\n") {
+		payload := strings.TrimPrefix(frame, "data: ")
+		if payload == "[DONE]" {
+			continue
+		}
+		var chunk map[string]interface{}
+		if err := json.Unmarshal([]byte(payload), &chunk); err != nil {
+			t.Fatalf("invalid chunk %q: %v", frame, err)
+		}
+		chunks = append(chunks, chunk)
+	}
+	if !strings.HasSuffix(buf.String(), "data: [DONE]\n\n") {
+		t.Fatalf("stream should end with [DONE]: %q", buf.String())
+	}
+	return chunks
+}
+
+func TestOpenAIEncoderParallelToolCalls(t *testing.T) {
+	enc := NewStreamEncoder(FormatOpenAI, "msg_1", 1)
+	chunks := openAIChunks(t, enc, [][2]string{
+		{"message_start", `{"type":"message_start","message":{"model":"m","usage":{"input_tokens":7}}}`},
+		{"content_block_start", `{"index":0,"content_block":{"type":"tool_use","id":"a","name":"Read"}}`},
+		{"content_block_start", `{"index":1,"content_block":{"type":"tool_use","id":"b","name":"Write"}}`},
+		{"content_block_delta", `{"index":1,"delta":{"type":"input_json_delta","partial_json":"{}"}}`},
+		{"content_block_stop", `{"index":1}`},
+		{"message_delta", `{"delta":{"stop_reason":"tool_use"},"usage":{"output_tokens":3}}`},
+		{"message_stop", `{}`},
+	})
+	if len(chunks) != 5 {
+		t.Fatalf("expected 5 chunks, got %d", len(chunks))
+	}
+	toolIndex := func(chunk map[string]interface{}) float64 {
+		delta := chunk["choices"].([]interface{})[0].(map[string]interface{})["delta"].(map[string]interface{})
+		return delta["tool_calls"].([]interface{})[0].(map[string]interface{})["index"].(float64)
+	}
+	if toolIndex(chunks[1]) != 0 || toolIndex(chunks[2]) != 1 || toolIndex(chunks[3]) != 1 {
+		t.Fatalf("unexpected tool indexes: %v", chunks[1:4])
+	}
+	last := chunks[4]
+	if last["model"] != "m" {
+		t.Fatalf("model = %v", last["model"])
+	}
+	if reason := last["choices"].([]interface{})[0].(map[string]interface{})["finish_reason"]; reason != "tool_calls" {
+		t.Fatalf("finish_reason = %v", reason)
+	}
+	if usage := last["usage"].(map[string]interface{}); usage["total_tokens"].(float64) != 10 {
+		t.Fatalf("usage = %v", usage)
+	}
+}
+
+func TestNDJSONEncoder(t *testing.T) {
+	enc := NewStreamEncoder(FormatNDJSON, "msg_1", 1)
+	var buf bytes.Buffer
+	if !enc.Encode(&buf, "content_block_delta", []byte(`{"index":0}`)) {
+		t.Fatalf("valid event should be written")
+	}
+	if enc.Encode(&buf, "broken", []byte(`{`)) {
+		t.Fatalf("invalid data should be dropped")
+	}
+	if got := buf.String(); got != `{"event":"content_block_delta","data":{"index":0}}`+"\n" {
+		t.Fatalf("ndjson line = %q", got)
+	}
+}
+
+func TestBuildOpenAICompletion(t *testing.T) {
+	resp := BuildOpenAICompletion("msg_1", 1, "m", []map[string]interface{}{
+		{"type": "thinking", "thinking": "hmm"},
+		{"type": "text", "text": "hi"},
+		{"type": "tool_use", "id": "a", "name": "Read", "input": map[string]interface{}{"p": "x"}},
+	}, "max_tokens", 2, 3, 1)
+	choice := resp["choices"].([]map[string]interface{})[0]
+	msg := choice["message"].(map[string]interface{})
+	if msg["content"] != "hi" || msg["reasoning_content"] != "hmm" || choice["finish_reason"] != "length" {
+		t.Fatalf("unexpected completion: %v", resp)
+	}
+	call := msg["tool_calls"].([]map[string]interface{})[0]
+	if args := call["function"].(map[string]interface{})["arguments"]; args != `{"p":"x"}` {
+		t.Fatalf("arguments = %v", args)
+	}
+	usage := resp["usage"].(map[string]interface{})
+	if details := usage["completion_tokens_details"].(map[string]int); details["reasoning_tokens"] != 1 {
+		t.Fatalf("usage = %v", usage)
+	}
+	resp = BuildOpenAICompletion("msg_1", 1, "m", nil, "end_turn", 2, 3, -1)
+	if _, ok := resp["usage"].(map[string]interface{})["completion_tokens_details"]; ok {
+		t.Fatal("completion_tokens_details must be omitted without reasoning accounting")
+	}
+}
+
+func TestOpenAIEncoderReasoningUsage(t *testing.T) {
+	enc := NewStreamEncoder(FormatOpenAI, "msg_1", 1)
+	chunks := openAIChunks(t, enc, [][2]string{
+		{"message_start", `{"type":"message_start","message":{"model":"m","usage":{"input_tokens":7}}}`},
+		{"message_delta", `{"delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":30,"reasoning_output_tokens":12}}`},
+		{"message_stop", `{}`},
+	})
+	usage := chunks[len(chunks)-1]["usage"].(map[string]interface{})
+	details, _ := usage["completion_tokens_details"].(map[string]interface{})
+	if usage["completion_tokens"].(float64) != 30 || details["reasoning_tokens"] != float64(12) {
+		t.Fatalf("usage = %v", usage)
+	}
+}
@@ -7,6 +7,9 @@ type ResponseFormat string
 const (
 	FormatAnthropic ResponseFormat = "anthropic"
 	FormatOpenAI    ResponseFormat = "openai"
+	// FormatNDJSON emits one {"event":...,"data":...} object per line, for
+	// clients that would rather not parse SSE framing.
+	FormatNDJSON ResponseFormat = "ndjson"
 )
 
 func DetectResponseFormat(path string) ResponseFormat {
@@ -15,3 +18,36 @@ func DetectResponseFormat(path string) ResponseFormat {
 	}
 	return FormatAnthropic
 }
+
+// NegotiateResponseFormat picks the output format from the endpoint first
+// (chat/completions always speaks OpenAI) and then the Accept header.
+func NegotiateResponseFormat(path, accept string) ResponseFormat {
+	if format := DetectResponseFormat(path); format != FormatAnthropic {
+		return format
+	}
+	accept = strings.ToLower(accept)
+	if strings.Contains(accept, "application/x-ndjson") || strings.Contains(accept, "application/jsonl") {
+		return FormatNDJSON
+	}
+	return FormatAnthropic
+}
+
+// StreamContentType is the Content-Type of a streaming response in this format.
+func (f ResponseFormat) StreamContentType() string {
+	if f == FormatNDJSON {
+		return "application/x-ndjson"
+	}
+	return "text/event-stream"
+}
+
+// OpenAIFinishReason maps an Anthropic stop_reason onto OpenAI's finish_reason.
+func OpenAIFinishReason(stopReason string) string {
+	switch stopReason {
+	case "tool_use":
+		return "tool_calls"
+	case "max_tokens":
+		return "length"
+	default:
+		return "stop"
+	}
+}
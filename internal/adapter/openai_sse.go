@@ -1,101 +1,234 @@
 package adapter
 
-import "github.com/goccy/go-json"
+import (
+	"bytes"
+	"strings"
 
-// BuildOpenAIChunk 将 Anthropic SSE 事件转换为 OpenAI chunk。
-func BuildOpenAIChunk(msgID string, created int64, event string, data []byte) ([]byte, bool) {
-	chunk := map[string]interface{}{
-		"id":      msgID,
-		"object":  "chat.completion.chunk",
-		"created": created,
-		"model":   "",
-		"choices": []map[string]interface{}{},
+	"github.com/goccy/go-json"
+)
+
+// openAIStreamEncoder 将 Anthropic SSE 事件转换为 OpenAI chat.completion.chunk。
+// 它记录 message_start 中的模型与输入 token，并为每个 tool_use 块分配独立的
+// tool_calls 下标，以支持并行工具调用。
+type openAIStreamEncoder struct {
+	msgID       string
+	created     int64
+	model       string
+	inputTokens int
+	toolIndexes map[int]int // content block index -> tool_calls index
+}
+
+func newOpenAIStreamEncoder(msgID string, created int64) *openAIStreamEncoder {
+	return &openAIStreamEncoder{msgID: msgID, created: created, toolIndexes: make(map[int]int)}
+}
+
+var openAIDone = []byte("data: [DONE]\n\n")
+
+func (e *openAIStreamEncoder) KeepAlive() []byte { return sseKeepAlive }
+
+func (e *openAIStreamEncoder) Encode(buf *bytes.Buffer, event string, data []byte) bool {
+	if event == "message_stop" {
+		buf.Write(openAIDone)
+		return true
 	}
 
 	var parsedData map[string]interface{}
 	if err := json.Unmarshal(data, &parsedData); err != nil {
-		return nil, false
+		return false
 	}
+	blockIndex := intValue(parsedData["index"])
 
 	choice := map[string]interface{}{
 		"index": 0,
 		"delta": map[string]interface{}{},
 	}
+	var usage map[string]interface{}
 
 	switch event {
 	case "message_start":
-		if msg, ok := parsedData["message"].(map[string]interface{}); ok {
-			choice["delta"] = map[string]interface{}{"role": "assistant"}
-			if model, ok := msg["model"].(string); ok {
-				chunk["model"] = model
-			}
+		msg, ok := parsedData["message"].(map[string]interface{})
+		if !ok {
+			return false
+		}
+		choice["delta"] = map[string]interface{}{"role": "assistant"}
+		if model, ok := msg["model"].(string); ok {
+			e.model = model
+		}
+		if u, ok := msg["usage"].(map[string]interface{}); ok {
+			e.inputTokens = intValue(u["input_tokens"])
 		}
 	case "content_block_start":
-		if cb, ok := parsedData["content_block"].(map[string]interface{}); ok {
-			if cb["type"] == "text" {
-				if text, ok := cb["text"].(string); ok && text != "" {
-					choice["delta"] = map[string]interface{}{"content": text}
-				}
-			} else if cb["type"] == "tool_use" {
-				choice["delta"] = map[string]interface{}{
-					"tool_calls": []map[string]interface{}{
-						{
-							"index": 0,
-							"id":    cb["id"],
-							"type":  "function",
-							"function": map[string]interface{}{
-								"name":      cb["name"],
-								"arguments": "",
-							},
+		cb, ok := parsedData["content_block"].(map[string]interface{})
+		if !ok {
+			return false
+		}
+		switch cb["type"] {
+		case "text":
+			if text, ok := cb["text"].(string); ok && text != "" {
+				choice["delta"] = map[string]interface{}{"content": text}
+			}
+		case "tool_use":
+			toolIndex := len(e.toolIndexes)
+			e.toolIndexes[blockIndex] = toolIndex
+			choice["delta"] = map[string]interface{}{
+				"tool_calls": []map[string]interface{}{
+					{
+						"index": toolIndex,
+						"id":    cb["id"],
+						"type":  "function",
+						"function": map[string]interface{}{
+							"name":      cb["name"],
+							"arguments": "",
 						},
 					},
-				}
+				},
 			}
 		}
 	case "content_block_delta":
-		if delta, ok := parsedData["delta"].(map[string]interface{}); ok {
-			if delta["type"] == "text_delta" {
-				choice["delta"] = map[string]interface{}{"content": delta["text"]}
-			} else if delta["type"] == "input_json_delta" {
-				choice["delta"] = map[string]interface{}{
-					"tool_calls": []map[string]interface{}{
-						{
-							"index": 0,
-							"function": map[string]interface{}{
-								"arguments": delta["partial_json"],
-							},
+		delta, ok := parsedData["delta"].(map[string]interface{})
+		if !ok {
+			return false
+		}
+		switch delta["type"] {
+		case "text_delta":
+			choice["delta"] = map[string]interface{}{"content": delta["text"]}
+		case "input_json_delta":
+			choice["delta"] = map[string]interface{}{
+				"tool_calls": []map[string]interface{}{
+					{
+						"index": e.toolIndexes[blockIndex],
+						"function": map[string]interface{}{
+							"arguments": delta["partial_json"],
 						},
 					},
-				}
-			} else if delta["type"] == "thinking_delta" {
-				choice["delta"] = map[string]interface{}{"reasoning_content": delta["thinking"]}
+				},
 			}
+		case "thinking_delta":
+			choice["delta"] = map[string]interface{}{"reasoning_content": delta["thinking"]}
 		}
 	case "message_delta":
+		stopReason := ""
 		if delta, ok := parsedData["delta"].(map[string]interface{}); ok {
-			if stopReason, ok := delta["stop_reason"].(string); ok {
-				choice["finish_reason"] = stopReason
+			stopReason, _ = delta["stop_reason"].(string)
+		}
+		choice["finish_reason"] = OpenAIFinishReason(stopReason)
+		outputTokens, reasoningTokens := 0, -1
+		if u, ok := parsedData["usage"].(map[string]interface{}); ok {
+			outputTokens = intValue(u["output_tokens"])
+			if v, ok := u["reasoning_output_tokens"]; ok {
+				reasoningTokens = intValue(v)
 			}
 		}
-		choice["delta"] = map[string]interface{}{}
-	case "message_stop":
-		choice["finish_reason"] = "stop"
-		choice["delta"] = map[string]interface{}{}
-	case "content_block_stop":
-		return nil, false
+		usage = openAIUsage(e.inputTokens, outputTokens, reasoningTokens)
 	default:
-		return nil, false
+		return false
 	}
 
 	delta, _ := choice["delta"].(map[string]interface{})
 	if len(delta) == 0 && choice["finish_reason"] == nil {
-		return nil, false
+		return false
 	}
 
-	chunk["choices"] = []interface{}{choice}
-	bytes, err := json.Marshal(chunk)
+	chunk := map[string]interface{}{
+		"id":      e.msgID,
+		"object":  "chat.completion.chunk",
+		"created": e.created,
+		"model":   e.model,
+		"choices": []interface{}{choice},
+	}
+	if usage != nil {
+		chunk["usage"] = usage
+	}
+	raw, err := json.Marshal(chunk)
 	if err != nil {
-		return nil, false
+		return false
+	}
+	buf.WriteString("data: ")
+	buf.Write(raw)
+	buf.WriteString("\n\n")
+	return true
+}
+
+// BuildOpenAICompletion 将非流式 Anthropic 消息内容转换为 OpenAI chat.completion 响应。
+// reasoningTokens < 0 时不输出 completion_tokens_details。
+func BuildOpenAICompletion(msgID string, created int64, model string, content []map[string]interface{}, stopReason string, inputTokens, outputTokens, reasoningTokens int) map[string]interface{} {
+	var text, reasoning strings.Builder
+	toolCalls := make([]map[string]interface{}, 0)
+	for _, block := range content {
+		switch block["type"] {
+		case "text":
+			s, _ := block["text"].(string)
+			text.WriteString(s)
+		case "thinking":
+			s, _ := block["thinking"].(string)
+			reasoning.WriteString(s)
+		case "tool_use":
+			args := "{}"
+			if input, ok := block["input"]; ok && input != nil {
+				if raw, err := json.Marshal(input); err == nil {
+					args = string(raw)
+				}
+			}
+			toolCalls = append(toolCalls, map[string]interface{}{
+				"id":   block["id"],
+				"type": "function",
+				"function": map[string]interface{}{
+					"name":      block["name"],
+					"arguments": args,
+				},
+			})
+		}
+	}
+
+	message := map[string]interface{}{
+		"role":    "assistant",
+		"content": text.String(),
+	}
+	if reasoning.Len() > 0 {
+		message["reasoning_content"] = reasoning.String()
+	}
+	if len(toolCalls) > 0 {
+		message["tool_calls"] = toolCalls
+	}
+	return map[string]interface{}{
+		"id":      msgID,
+		"object":  "chat.completion",
+		"created": created,
+		"model":   model,
+		"choices": []map[string]interface{}{
+			{
+				"index":         0,
+				"message":       message,
+				"finish_reason": OpenAIFinishReason(stopReason),
+			},
+		},
+		"usage": openAIUsage(inputTokens, outputTokens, reasoningTokens),
+	}
+}
+
+// openAIUsage builds an OpenAI usage object; reasoningTokens < 0 omits
+// completion_tokens_details.
+func openAIUsage(inputTokens, outputTokens, reasoningTokens int) map[string]interface{} {
+	usage := map[string]interface{}{
+		"prompt_tokens":     inputTokens,
+		"completion_tokens": outputTokens,
+		"total_tokens":      inputTokens + outputTokens,
+	}
+	if reasoningTokens >= 0 {
+		usage["completion_tokens_details"] = map[string]int{"reasoning_tokens": reasoningTokens}
+	}
+	return usage
+}
+
+func intValue(v interface{}) int {
+	switch n := v.(type) {
+	case float64:
+		return int(n)
+	case int:
+		return n
+	case json.Number:
+		i, _ := n.Int64()
+		return int(i)
 	}
-	return bytes, true
+	return 0
 }
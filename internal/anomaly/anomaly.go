@@ -0,0 +1,347 @@
+// Package anomaly tracks request duration, upstream errors, empty responses
+// and tool-loop depth per account and channel over a sliding window, raises alerts when a
+// rate crosses its threshold and derives a health score for each account.
+package anomaly
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+const webhookTimeout = 5 * time.Second
+
+// Alert metrics.
+const (
+	MetricSlowRequests   = "slow_requests"
+	MetricEmptyResponses = "empty_responses"
+	MetricToolLoops      = "tool_loops"
+	MetricTokenFailures  = "token_failures"
+)
+
+// Options configures detection. A rate of zero or less disables its metric;
+// alerts are always logged and also posted to WebhookURL when set.
+type Options struct {
+	WebhookURL  string
+	Window      time.Duration
+	MinRequests int // samples needed in the window before rates are judged
+	Cooldown    time.Duration
+
+	SlowRequest   time.Duration // a request at least this slow counts as slow
+	SlowRate      float64
+	EmptyRate     float64
+	ToolLoopDepth int // a request with at least this many tool rounds counts as a loop
+	ToolLoopRate  float64
+}
+
+// Sample is one finished request.
+type Sample struct {
+	AccountID  int64
+	Channel    string
+	Duration   time.Duration
+	Error      bool
+	Empty      bool
+	ToolRounds int
+}
+
+// Alert is sent to the webhook when a rate crosses its threshold.
+type Alert struct {
+	Event         string    `json:"event"`
+	Scope         string    `json:"scope"` // "account" or "channel"
+	AccountID     int64     `json:"account_id,omitempty"`
+	Channel       string    `json:"channel,omitempty"`
+	Metric        string    `json:"metric"`
+	Rate          float64   `json:"rate"`
+	Threshold     float64   `json:"threshold"`
+	Requests      int       `json:"requests"`
+	WindowSeconds int       `json:"window_seconds"`
+	At            time.Time `json:"at"`
+}
+
+// Health summarizes an account's recent requests. Score runs from 100
+// (healthy) down to 0; Flags lists the metrics currently over threshold.
+type Health struct {
+	Score        int     `json:"score"`
+	Requests     int     `json:"requests"`
+	ErrorRate    float64 `json:"error_rate"`
+	SlowRate     float64 `json:"slow_rate"`
+	EmptyRate    float64 `json:"empty_rate"`
+	ToolLoopRate float64 `json:"tool_loop_rate"`
+	QuotaUsed    float64 `json:"quota_used,omitempty"`
+	// TokenFailures counts the account's consecutive failed token fetches.
+	TokenFailures int      `json:"token_failures,omitempty"`
+	Flags         []string `json:"flags,omitempty"`
+}
+
+type event struct {
+	at       time.Time
+	err      bool
+	slow     bool
+	empty    bool
+	toolLoop bool
+}
+
+type window struct {
+	events    []event
+	lastAlert map[string]time.Time
+}
+
+// Detector holds the recent requests of every account and channel.
+type Detector struct {
+	client *http.Client
+	now    func() time.Time
+
+	mu      sync.Mutex
+	opts    Options
+	windows map[string]*window
+}
+
+// New returns an empty Detector.
+func New() *Detector {
+	return &Detector{
+		client:  &http.Client{Timeout: webhookTimeout},
+		now:     time.Now,
+		windows: make(map[string]*window),
+	}
+}
+
+func accountKey(id int64) string  { return "account:" + strconv.FormatInt(id, 10) }
+func channelKey(ch string) string { return "channel:" + ch }
+
+// Record adds a finished request and sends any alerts it triggers. The
+// options are re-read on every call so config changes apply immediately.
+func (d *Detector) Record(s Sample, opts Options) {
+	if d == nil || opts.Window <= 0 {
+		return
+	}
+	now := d.now()
+	ev := event{
+		at:       now,
+		err:      s.Error,
+		slow:     opts.SlowRequest > 0 && s.Duration >= opts.SlowRequest,
+		empty:    s.Empty,
+		toolLoop: opts.ToolLoopDepth > 0 && s.ToolRounds >= opts.ToolLoopDepth,
+	}
+
+	var alerts []Alert
+	d.mu.Lock()
+	d.opts = opts
+	if s.AccountID > 0 {
+		alerts = append(alerts, d.add(accountKey(s.AccountID), ev, opts, Alert{Scope: "account", AccountID: s.AccountID, Channel: s.Channel})...)
+	}
+	if s.Channel != "" {
+		alerts = append(alerts, d.add(channelKey(s.Channel), ev, opts, Alert{Scope: "channel", Channel: s.Channel})...)
+	}
+	d.mu.Unlock()
+
+	for _, a := range alerts {
+		slog.Warn("anomaly: threshold exceeded", "scope", a.Scope, "account_id", a.AccountID, "channel", a.Channel,
+			"metric", a.Metric, "rate", a.Rate, "threshold", a.Threshold, "requests", a.Requests)
+		if opts.WebhookURL != "" {
+			go d.post(opts.WebhookURL, a)
+		}
+	}
+}
+
+// add appends ev to the window at key and returns the alerts now due.
+// Callers hold d.mu.
+func (d *Detector) add(key string, ev event, opts Options, base Alert) []Alert {
+	w := d.windows[key]
+	if w == nil {
+		w = &window{lastAlert: make(map[string]time.Time)}
+		d.windows[key] = w
+	}
+	w.events = append(pruned(w.events, ev.at.Add(-opts.Window)), ev)
+	d.sweep(ev.at.Add(-opts.Window))
+
+	if len(w.events) < opts.MinRequests {
+		return nil
+	}
+	_, slow, empty, loops := rates(w.events)
+	var alerts []Alert
+	for _, m := range []struct {
+		name      string
+		rate      float64
+		threshold float64
+	}{
+		{MetricSlowRequests, slow, opts.SlowRate},
+		{MetricEmptyResponses, empty, opts.EmptyRate},
+		{MetricToolLoops, loops, opts.ToolLoopRate},
+	} {
+		if m.threshold <= 0 || m.rate < m.threshold {
+			continue
+		}
+		if last, ok := w.lastAlert[m.name]; ok && ev.at.Sub(last) < opts.Cooldown {
+			continue
+		}
+		w.lastAlert[m.name] = ev.at
+		a := base
+		a.Event = "anomaly"
+		a.Metric = m.name
+		a.Rate = round(m.rate)
+		a.Threshold = m.threshold
+		a.Requests = len(w.events)
+		a.WindowSeconds = int(opts.Window / time.Second)
+		a.At = ev.at
+		alerts = append(alerts, a)
+	}
+	return alerts
+}
+
+// sweep drops windows whose last request is older than cutoff. Callers hold
+// d.mu.
+func (d *Detector) sweep(cutoff time.Time) {
+	for key, w := range d.windows {
+		if n := len(w.events); n == 0 || w.events[n-1].at.Before(cutoff) {
+			delete(d.windows, key)
+		}
+	}
+}
+
+func pruned(events []event, cutoff time.Time) []event {
+	i := sort.Search(len(events), func(i int) bool { return !events[i].at.Before(cutoff) })
+	return events[i:]
+}
+
+func rates(events []event) (errs, slow, empty, loops float64) {
+	if len(events) == 0 {
+		return 0, 0, 0, 0
+	}
+	var f, s, e, l int
+	for _, ev := range events {
+		if ev.err {
+			f++
+		}
+		if ev.slow {
+			s++
+		}
+		if ev.empty {
+			e++
+		}
+		if ev.toolLoop {
+			l++
+		}
+	}
+	n := float64(len(events))
+	return float64(f) / n, float64(s) / n, float64(e) / n, float64(l) / n
+}
+
+func round(v float64) float64 {
+	return math.Round(v*1000) / 1000
+}
+
+// AccountHealth returns the health of accountID over the current window, or
+// false when it has served no request in the window.
+func (d *Detector) AccountHealth(accountID int64) (Health, bool) {
+	if d == nil {
+		return Health{}, false
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	opts := d.opts
+	w := d.windows[accountKey(accountID)]
+	if w == nil {
+		return Health{}, false
+	}
+	events := pruned(w.events, d.now().Add(-opts.Window))
+	if len(events) == 0 {
+		return Health{}, false
+	}
+	errs, slow, empty, loops := rates(events)
+	h := Health{
+		Requests:     len(events),
+		ErrorRate:    round(errs),
+		SlowRate:     round(slow),
+		EmptyRate:    round(empty),
+		ToolLoopRate: round(loops),
+	}
+	// Failed and empty requests hurt clients most; slow requests and tool
+	// loops less.
+	score := 100 * (1 - 0.4*errs - 0.3*empty - 0.2*slow - 0.1*loops)
+	h.Score = int(math.Max(0, math.Round(score)))
+	if len(events) >= opts.MinRequests {
+		if opts.SlowRate > 0 && slow >= opts.SlowRate {
+			h.Flags = append(h.Flags, MetricSlowRequests)
+		}
+		if opts.EmptyRate > 0 && empty >= opts.EmptyRate {
+			h.Flags = append(h.Flags, MetricEmptyResponses)
+		}
+		if opts.ToolLoopRate > 0 && loops >= opts.ToolLoopRate {
+			h.Flags = append(h.Flags, MetricToolLoops)
+		}
+	}
+	return h, true
+}
+
+// quotaHeadroom is the share of quota an account may use before its score
+// starts dropping; an exhausted account loses quotaPenalty points.
+const (
+	quotaHeadroom = 0.8
+	quotaPenalty  = 50
+)
+
+// ApplyQuota records that the account has used the given share of its quota
+// and lowers the score once it is nearly exhausted.
+func (h *Health) ApplyQuota(used float64) {
+	used = math.Min(math.Max(used, 0), 1)
+	h.QuotaUsed = round(used)
+	if used > quotaHeadroom {
+		penalty := quotaPenalty * (used - quotaHeadroom) / (1 - quotaHeadroom)
+		h.Score = int(math.Max(0, math.Round(float64(h.Score)-penalty)))
+	}
+}
+
+// Each consecutive failed token fetch costs tokenFailurePenalty points, up to
+// tokenFailureMaxPenalty; from tokenFailureFlag failures on the account is
+// flagged.
+const (
+	tokenFailurePenalty    = 20
+	tokenFailureMaxPenalty = 80
+	tokenFailureFlag       = 3
+)
+
+// ApplyTokenFailures records that the account's last failures token fetches
+// failed and lowers the score accordingly.
+func (h *Health) ApplyTokenFailures(failures int) {
+	if failures <= 0 {
+		return
+	}
+	h.TokenFailures = failures
+	penalty := min(failures*tokenFailurePenalty, tokenFailureMaxPenalty)
+	h.Score = max(0, h.Score-penalty)
+	if failures >= tokenFailureFlag {
+		h.Flags = append(h.Flags, MetricTokenFailures)
+	}
+}
+
+func (d *Detector) post(url string, a Alert) {
+	body, err := json.Marshal(a)
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		slog.Warn("anomaly: invalid webhook URL", "error", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := d.client.Do(req)
+	if err != nil {
+		slog.Warn("anomaly: webhook failed", "error", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		slog.Warn("anomaly: webhook rejected alert", "status", resp.StatusCode)
+	}
+}
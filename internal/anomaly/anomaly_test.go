@@ -0,0 +1,129 @@
+package anomaly
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+func testOptions() Options {
+	return Options{
+		Window:        time.Minute,
+		MinRequests:   4,
+		Cooldown:      10 * time.Minute,
+		SlowRequest:   10 * time.Second,
+		SlowRate:      0.5,
+		EmptyRate:     0.5,
+		ToolLoopDepth: 5,
+		ToolLoopRate:  0.5,
+	}
+}
+
+func TestRecordAlertsOncePerCooldown(t *testing.T) {
+	alerts := make(chan Alert, 8)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var a Alert
+		json.NewDecoder(r.Body).Decode(&a)
+		alerts <- a
+	}))
+	defer srv.Close()
+
+	d := New()
+	opts := testOptions()
+	opts.WebhookURL = srv.URL
+	for i := 0; i < 6; i++ {
+		d.Record(Sample{AccountID: 7, Duration: time.Second, Empty: i%2 == 0}, opts)
+	}
+
+	select {
+	case a := <-alerts:
+		if a.Scope != "account" || a.AccountID != 7 || a.Metric != MetricEmptyResponses || a.Requests != 4 || a.Rate != 0.5 {
+			t.Fatalf("alert=%+v", a)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no alert posted")
+	}
+	select {
+	case a := <-alerts:
+		t.Fatalf("unexpected second alert within cooldown: %+v", a)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestRecordChannelScope(t *testing.T) {
+	d := New()
+	opts := testOptions()
+	var got []Alert
+	for i := 0; i < 4; i++ {
+		got = append(got, d.add(channelKey("warp"), event{at: time.Now(), toolLoop: true}, opts, Alert{Scope: "channel", Channel: "warp"})...)
+	}
+	if len(got) != 1 || got[0].Metric != MetricToolLoops || got[0].Channel != "warp" {
+		t.Fatalf("alerts=%+v", got)
+	}
+}
+
+func TestAccountHealth(t *testing.T) {
+	d := New()
+	now := time.Now()
+	d.now = func() time.Time { return now }
+	opts := testOptions()
+
+	if _, ok := d.AccountHealth(1); ok {
+		t.Fatal("expected no health without requests")
+	}
+	d.Record(Sample{AccountID: 1, Duration: 20 * time.Second}, opts)
+	for i := 0; i < 3; i++ {
+		d.Record(Sample{AccountID: 1, Duration: 20 * time.Second, ToolRounds: 1}, opts)
+	}
+	h, ok := d.AccountHealth(1)
+	if !ok || h.Requests != 4 || h.SlowRate != 1 || h.Score != 80 || len(h.Flags) != 1 || h.Flags[0] != MetricSlowRequests {
+		t.Fatalf("health=%+v ok=%v", h, ok)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, ok := d.AccountHealth(1); ok {
+		t.Fatal("expected requests outside the window to be dropped")
+	}
+}
+
+func TestApplyQuota(t *testing.T) {
+	cases := []struct {
+		used  float64
+		score int
+	}{
+		{0.5, 100},
+		{0.9, 75},
+		{1, 50},
+		{3, 50},
+	}
+	for _, tc := range cases {
+		h := Health{Score: 100}
+		h.ApplyQuota(tc.used)
+		if h.Score != tc.score {
+			t.Fatalf("used=%v: score=%d want %d", tc.used, h.Score, tc.score)
+		}
+	}
+}
+
+func TestApplyTokenFailures(t *testing.T) {
+	cases := []struct {
+		failures int
+		score    int
+		flagged  bool
+	}{
+		{0, 100, false},
+		{1, 80, false},
+		{3, 40, true},
+		{10, 20, true},
+	}
+	for _, tc := range cases {
+		h := Health{Score: 100}
+		h.ApplyTokenFailures(tc.failures)
+		if h.Score != tc.score || h.TokenFailures != tc.failures || (len(h.Flags) > 0) != tc.flagged {
+			t.Fatalf("failures=%d: %+v, want score %d flagged %v", tc.failures, h, tc.score, tc.flagged)
+		}
+	}
+}
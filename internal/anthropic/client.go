@@ -0,0 +1,307 @@
+// Package anthropic forwards requests to an official (or Anthropic-compatible)
+// Messages API using a per-account API key and base URL.
+package anthropic
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/goccy/go-json"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"orchids-api/internal/config"
+	"orchids-api/internal/debug"
+	"orchids-api/internal/prompt"
+	"orchids-api/internal/store"
+	"orchids-api/internal/upstream"
+	"orchids-api/internal/util"
+)
+
+const (
+	defaultBaseURL        = "https://api.anthropic.com"
+	apiVersion            = "2023-06-01"
+	defaultRequestTimeout = 120 * time.Second
+)
+
+type Client struct {
+	config     *config.Config
+	account    *store.Account
+	httpClient *http.Client
+}
+
+func NewFromAccount(acc *store.Account, cfg *config.Config) *Client {
+	timeout := defaultRequestTimeout
+	if cfg != nil && cfg.RequestTimeout > 0 {
+		timeout = time.Duration(cfg.RequestTimeout) * time.Second
+	}
+	proxyFunc := http.ProxyFromEnvironment
+	if cfg != nil {
+		proxyFunc = util.ProxyFunc(cfg.ProxyHTTP, cfg.ProxyHTTPS, cfg.ProxyUser, cfg.ProxyPass, cfg.ProxyBypass)
+	}
+	return &Client{
+		config:  cfg,
+		account: acc,
+		httpClient: &http.Client{
+			Timeout:   timeout,
+			Transport: &http.Transport{Proxy: proxyFunc, ForceAttemptHTTP2: true},
+		},
+	}
+}
+
+// MessagesURL returns the Messages endpoint for a configured base URL. An
+// empty base URL means the official API; ".../v1" and the full endpoint are
+// accepted as well.
+func MessagesURL(baseURL string) string {
+	base := strings.TrimRight(strings.TrimSpace(baseURL), "/")
+	if base == "" {
+		base = defaultBaseURL
+	}
+	switch {
+	case strings.HasSuffix(base, "/messages"):
+		return base
+	case strings.HasSuffix(base, "/v1"):
+		return base + "/messages"
+	default:
+		return base + "/v1/messages"
+	}
+}
+
+func (c *Client) SendRequest(ctx context.Context, prompt string, chatHistory []interface{}, model string, onMessage func(upstream.SSEMessage), logger *debug.Logger) error {
+	req := upstream.UpstreamRequest{
+		Prompt:      prompt,
+		ChatHistory: chatHistory,
+		Model:       model,
+	}
+	return c.SendRequestWithPayload(ctx, req, onMessage, logger)
+}
+
+func (c *Client) SendRequestWithPayload(ctx context.Context, req upstream.UpstreamRequest, onMessage func(upstream.SSEMessage), logger *debug.Logger) error {
+	if c.account == nil || strings.TrimSpace(c.account.APIKey) == "" {
+		return fmt.Errorf("anthropic account has no api_key")
+	}
+	if len(req.Messages) == 0 && strings.TrimSpace(req.Prompt) != "" {
+		req.Messages = []prompt.Message{{Role: "user", Content: prompt.MessageContent{Text: req.Prompt}}}
+	}
+	payload, err := buildRequest(req)
+	if err != nil {
+		return err
+	}
+
+	endpoint := MessagesURL(c.account.BaseURL)
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Accept", "text/event-stream")
+	request.Header.Set("X-Api-Key", strings.TrimSpace(c.account.APIKey))
+	request.Header.Set("Anthropic-Version", apiVersion)
+	upstream.ApplyAccountHeaders(request.Header, c.account)
+	upstream.ApplyTraceHeaders(ctx, request.Header)
+	upstream.MeterSent(ctx, len(payload))
+	if logger != nil {
+		logger.LogUpstreamRequest(endpoint, map[string]string{"content-type": "application/json"}, payload)
+	}
+	if c.config != nil && c.config.DebugEnabled {
+		slog.Debug("Anthropic: Dispatching request", "url", endpoint, "model", req.Model, "body_size", len(payload))
+	}
+
+	result, err := upstream.GetAccountBreaker(c.breakerKey()).Execute(func() (interface{}, error) {
+		return c.httpClient.Do(request)
+	})
+	if err != nil {
+		return err
+	}
+	resp, ok := result.(*http.Response)
+	if !ok || resp == nil {
+		return fmt.Errorf("anthropic api error: unexpected response type")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		if logger != nil {
+			logger.LogUpstreamHTTPError(endpoint, resp.StatusCode, string(body), nil)
+		}
+		slog.Warn("Anthropic request failed", "status", resp.StatusCode, "body", string(body))
+		return fmt.Errorf("anthropic api error: HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	ctxDone := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = resp.Body.Close()
+		case <-ctxDone:
+		}
+	}()
+	defer close(ctxDone)
+
+	err = consumeStream(upstream.MeterBody(ctx, resp.Body), onMessage, logger)
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+type streamEvent struct {
+	Type    string `json:"type"`
+	Index   int    `json:"index"`
+	Message *struct {
+		Usage usage `json:"usage"`
+	} `json:"message"`
+	ContentBlock *struct {
+		Type string `json:"type"`
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"content_block"`
+	Delta *struct {
+		Type         string `json:"type"`
+		Text         string `json:"text"`
+		Thinking     string `json:"thinking"`
+		Signature    string `json:"signature"`
+		PartialJSON  string `json:"partial_json"`
+		StopReason   string `json:"stop_reason"`
+		StopSequence string `json:"stop_sequence"`
+	} `json:"delta"`
+	Usage *usage `json:"usage"`
+	Error *struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+type usage struct {
+	InputTokens              int `json:"input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens"`
+}
+
+type pendingToolUse struct {
+	id    string
+	name  string
+	input strings.Builder
+}
+
+// consumeStream maps Anthropic SSE events onto the shared model.* messages.
+// Input usage counts cached prompt tokens too, so accounting matches what the
+// upstream bills. The upstream stop_reason (and stop_sequence) is passed on
+// as the finish reason.
+func consumeStream(body io.Reader, onMessage func(upstream.SSEMessage), logger *debug.Logger) error {
+	reader := bufio.NewReader(body)
+	tools := make(map[int]*pendingToolUse)
+	toolCallSeen := false
+	stopReason, stopSequence := "", ""
+	inputTokens, outputTokens := 0, 0
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "data:") {
+			data := strings.TrimSpace(line[5:])
+			if logger != nil {
+				logger.LogUpstreamSSE("anthropic", data)
+			}
+			var ev streamEvent
+			if jsonErr := json.Unmarshal([]byte(data), &ev); jsonErr == nil {
+				switch ev.Type {
+				case "message_start":
+					if ev.Message != nil {
+						u := ev.Message.Usage
+						inputTokens = u.InputTokens + u.CacheCreationInputTokens + u.CacheReadInputTokens
+						outputTokens = u.OutputTokens
+					}
+				case "content_block_start":
+					if ev.ContentBlock != nil && ev.ContentBlock.Type == "tool_use" {
+						tools[ev.Index] = &pendingToolUse{id: ev.ContentBlock.ID, name: ev.ContentBlock.Name}
+					}
+				case "content_block_delta":
+					if ev.Delta == nil {
+						break
+					}
+					switch ev.Delta.Type {
+					case "text_delta":
+						if ev.Delta.Text != "" {
+							onMessage(upstream.SSEMessage{Type: "model.text-delta", Event: map[string]interface{}{"delta": ev.Delta.Text}})
+						}
+					case "thinking_delta":
+						if ev.Delta.Thinking != "" {
+							onMessage(upstream.SSEMessage{Type: "model.reasoning-delta", Event: map[string]interface{}{"delta": ev.Delta.Thinking}})
+						}
+					case "signature_delta":
+						if ev.Delta.Signature != "" {
+							onMessage(upstream.SSEMessage{Type: "model.reasoning-delta", Event: map[string]interface{}{"delta": "", "signature": ev.Delta.Signature}})
+						}
+					case "input_json_delta":
+						if pending, ok := tools[ev.Index]; ok {
+							pending.input.WriteString(ev.Delta.PartialJSON)
+						}
+					}
+				case "content_block_stop":
+					pending, ok := tools[ev.Index]
+					if !ok {
+						break
+					}
+					delete(tools, ev.Index)
+					input := strings.TrimSpace(pending.input.String())
+					if input == "" {
+						input = "{}"
+					}
+					toolCallSeen = true
+					onMessage(upstream.SSEMessage{Type: "model.tool-call", Event: map[string]interface{}{"toolCallId": pending.id, "toolName": pending.name, "input": input}})
+				case "message_delta":
+					if ev.Delta != nil && ev.Delta.StopReason != "" {
+						stopReason = ev.Delta.StopReason
+						stopSequence = ev.Delta.StopSequence
+					}
+					if ev.Usage != nil && ev.Usage.OutputTokens > 0 {
+						outputTokens = ev.Usage.OutputTokens
+					}
+				case "error":
+					msg := data
+					if ev.Error != nil {
+						msg = ev.Error.Type + ": " + ev.Error.Message
+					}
+					return fmt.Errorf("anthropic stream error: %s", msg)
+				}
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+
+	finish := map[string]interface{}{"finishReason": "end_turn"}
+	switch {
+	case toolCallSeen:
+		finish["finishReason"] = "tool_use"
+	case stopReason != "":
+		finish["finishReason"] = stopReason
+	}
+	if stopSequence != "" {
+		finish["stopSequence"] = stopSequence
+	}
+	if inputTokens > 0 || outputTokens > 0 {
+		finish["usage"] = map[string]interface{}{"inputTokens": inputTokens, "outputTokens": outputTokens}
+	}
+	onMessage(upstream.SSEMessage{Type: "model.finish", Event: finish})
+	return nil
+}
+
+func (c *Client) breakerKey() string {
+	if c == nil || c.account == nil {
+		return "anthropic:default"
+	}
+	if name := strings.TrimSpace(c.account.Name); name != "" {
+		return "anthropic:" + name
+	}
+	return fmt.Sprintf("anthropic:%d", c.account.ID)
+}
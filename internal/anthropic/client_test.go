@@ -0,0 +1,211 @@
+package anthropic
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/goccy/go-json"
+
+	"orchids-api/internal/prompt"
+	"orchids-api/internal/store"
+	"orchids-api/internal/upstream"
+)
+
+func TestMessagesURL(t *testing.T) {
+	cases := map[string]string{
+		"":                                "https://api.anthropic.com/v1/messages",
+		"https://gateway.example.com":     "https://gateway.example.com/v1/messages",
+		"https://gateway.example.com/v1/": "https://gateway.example.com/v1/messages",
+		"https://gateway.example.com/v1/messages": "https://gateway.example.com/v1/messages",
+	}
+	for in, want := range cases {
+		if got := MessagesURL(in); got != want {
+			t.Fatalf("MessagesURL(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestSendRequestWithPayload(t *testing.T) {
+	var lastBody []byte
+	var apiKey, version string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/messages" {
+			http.NotFound(w, r)
+			return
+		}
+		apiKey = r.Header.Get("X-Api-Key")
+		version = r.Header.Get("Anthropic-Version")
+		lastBody, _ = io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"usage\":{\"input_tokens\":10,\"cache_read_input_tokens\":90,\"output_tokens\":1}}}\n\n")
+		io.WriteString(w, "event: content_block_start\ndata: {\"type\":\"content_block_start\",\"index\":0,\"content_block\":{\"type\":\"thinking\"}}\n\n")
+		io.WriteString(w, "event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"thinking_delta\",\"thinking\":\"hmm\"}}\n\n")
+		io.WriteString(w, "event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":1,\"delta\":{\"type\":\"text_delta\",\"text\":\"Reading file\"}}\n\n")
+		io.WriteString(w, "event: content_block_start\ndata: {\"type\":\"content_block_start\",\"index\":2,\"content_block\":{\"type\":\"tool_use\",\"id\":\"toolu_1\",\"name\":\"Read\",\"input\":{}}}\n\n")
+		io.WriteString(w, "event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":2,\"delta\":{\"type\":\"input_json_delta\",\"partial_json\":\"{\\\"path\\\":\"}}\n\n")
+		io.WriteString(w, "event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":2,\"delta\":{\"type\":\"input_json_delta\",\"partial_json\":\"\\\"a.go\\\"}\"}}\n\n")
+		io.WriteString(w, "event: content_block_stop\ndata: {\"type\":\"content_block_stop\",\"index\":2}\n\n")
+		io.WriteString(w, "event: message_delta\ndata: {\"type\":\"message_delta\",\"delta\":{\"stop_reason\":\"tool_use\"},\"usage\":{\"output_tokens\":25}}\n\n")
+		io.WriteString(w, "event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n")
+	}))
+	defer srv.Close()
+
+	acc := &store.Account{ID: 1, Name: "anthropic-test", AccountType: "anthropic", BaseURL: srv.URL, APIKey: "sk-ant-test"}
+	client := NewFromAccount(acc, nil)
+
+	req := upstream.UpstreamRequest{
+		Model:     "claude-sonnet-4-5",
+		MaxTokens: 1024,
+		System:    []prompt.SystemItem{{Type: "text", Text: "Be brief."}},
+		Messages: []prompt.Message{
+			{Role: "user", Content: prompt.MessageContent{Text: "open main.go"}},
+			{Role: "assistant", Content: prompt.MessageContent{Blocks: []prompt.ContentBlock{
+				{Type: "thinking", Thinking: "from another channel"},
+				{Type: "tool_use", ID: "toolu_0", Name: "Read", Input: map[string]interface{}{"path": "main.go"}},
+			}}},
+			{Role: "user", Content: prompt.MessageContent{Blocks: []prompt.ContentBlock{
+				{Type: "tool_result", ToolUseID: "toolu_0", Content: "package main"},
+			}}},
+		},
+		Tools: []interface{}{map[string]interface{}{"name": "Read", "input_schema": map[string]interface{}{"type": "object"}}},
+	}
+	var messages []upstream.SSEMessage
+	err := client.SendRequestWithPayload(context.Background(), req, func(msg upstream.SSEMessage) {
+		messages = append(messages, msg)
+	}, nil)
+	if err != nil {
+		t.Fatalf("SendRequestWithPayload: %v", err)
+	}
+	if apiKey != "sk-ant-test" || version != apiVersion {
+		t.Fatalf("headers: x-api-key=%q anthropic-version=%q", apiKey, version)
+	}
+
+	var text, reasoning strings.Builder
+	var toolInput string
+	var finish map[string]interface{}
+	for _, msg := range messages {
+		switch msg.Type {
+		case "model.text-delta":
+			text.WriteString(msg.Event["delta"].(string))
+		case "model.reasoning-delta":
+			reasoning.WriteString(msg.Event["delta"].(string))
+		case "model.tool-call":
+			toolInput, _ = msg.Event["input"].(string)
+		case "model.finish":
+			finish = msg.Event
+		}
+	}
+	if text.String() != "Reading file" || reasoning.String() != "hmm" {
+		t.Fatalf("text = %q reasoning = %q", text.String(), reasoning.String())
+	}
+	if toolInput != `{"path":"a.go"}` {
+		t.Fatalf("tool input = %q", toolInput)
+	}
+	if finish["finishReason"] != "tool_use" {
+		t.Fatalf("finish = %v", finish)
+	}
+	if usage, _ := finish["usage"].(map[string]interface{}); usage["inputTokens"] != 100 || usage["outputTokens"] != 25 {
+		t.Fatalf("usage = %v", finish["usage"])
+	}
+
+	var sent struct {
+		Model     string           `json:"model"`
+		MaxTokens int              `json:"max_tokens"`
+		Stream    bool             `json:"stream"`
+		Messages  []prompt.Message `json:"messages"`
+		Tools     []interface{}    `json:"tools"`
+	}
+	if err := json.Unmarshal(lastBody, &sent); err != nil {
+		t.Fatalf("decode request: %v", err)
+	}
+	if sent.Model != "claude-sonnet-4-5" || sent.MaxTokens != 1024 || !sent.Stream || len(sent.Tools) != 1 || len(sent.Messages) != 3 {
+		t.Fatalf("unexpected request: %s", lastBody)
+	}
+	if blocks := sent.Messages[1].Content.GetBlocks(); len(blocks) != 1 || blocks[0].Type != "tool_use" {
+		t.Fatalf("unsigned thinking block should be dropped from history: %+v", blocks)
+	}
+}
+
+func TestSendRequestWithPayloadStreamError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "event: error\ndata: {\"type\":\"error\",\"error\":{\"type\":\"overloaded_error\",\"message\":\"Overloaded\"}}\n\n")
+	}))
+	defer srv.Close()
+
+	acc := &store.Account{ID: 2, Name: "anthropic-overloaded", AccountType: "anthropic", BaseURL: srv.URL, APIKey: "sk-ant-test"}
+	err := NewFromAccount(acc, nil).SendRequest(context.Background(), "hi", nil, "claude-sonnet-4-5", func(upstream.SSEMessage) {}, nil)
+	if err == nil || !strings.Contains(err.Error(), "overloaded_error") {
+		t.Fatalf("expected stream error, got %v", err)
+	}
+}
+
+func TestBuildRequestForwardsControls(t *testing.T) {
+	temperature, topP := 0.2, 0.9
+	req := upstream.UpstreamRequest{
+		Model:         "claude-sonnet-4-5",
+		Messages:      []prompt.Message{{Role: "assistant", Content: prompt.MessageContent{Blocks: []prompt.ContentBlock{{Type: "thinking", Thinking: "signed", Signature: "sig"}, {Type: "redacted_thinking", Data: "opaque"}, {Type: "text", Text: "hi"}}}}},
+		Tools:         []interface{}{map[string]interface{}{"name": "Read"}},
+		ToolChoice:    map[string]interface{}{"type": "any"},
+		Temperature:   &temperature,
+		TopP:          &topP,
+		StopSequences: []string{"END"},
+		Thinking:      map[string]interface{}{"type": "enabled", "budget_tokens": 2048},
+		UserID:        "user-1",
+	}
+	payload, err := buildRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sent map[string]interface{}
+	if err := json.Unmarshal(payload, &sent); err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range []string{"tool_choice", "temperature", "top_p", "stop_sequences", "thinking", "metadata"} {
+		if _, ok := sent[field]; !ok {
+			t.Fatalf("%s not forwarded: %s", field, payload)
+		}
+	}
+	if !strings.Contains(string(payload), `"signature":"sig"`) || !strings.Contains(string(payload), `"data":"opaque"`) {
+		t.Fatalf("signed thinking blocks should be kept: %s", payload)
+	}
+
+	req.NoTools, req.NoThinking = true, true
+	payload, _ = buildRequest(req)
+	if strings.Contains(string(payload), "tool_choice") || strings.Contains(string(payload), "budget_tokens") {
+		t.Fatalf("tool_choice and thinking should follow tools and thinking gating: %s", payload)
+	}
+}
+
+func TestConsumeStreamPassesStopReason(t *testing.T) {
+	for _, tc := range []struct{ delta, reason, sequence string }{
+		{`{"stop_reason":"max_tokens","stop_sequence":null}`, "max_tokens", ""},
+		{`{"stop_reason":"stop_sequence","stop_sequence":"END"}`, "stop_sequence", "END"},
+		{`{"stop_reason":"end_turn"}`, "end_turn", ""},
+	} {
+		body := "data: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"signature_delta\",\"signature\":\"sig\"}}\n\n" +
+			"data: {\"type\":\"message_delta\",\"delta\":" + tc.delta + "}\n\n"
+		var finish map[string]interface{}
+		signature := ""
+		err := consumeStream(strings.NewReader(body), func(msg upstream.SSEMessage) {
+			switch msg.Type {
+			case "model.finish":
+				finish = msg.Event
+			case "model.reasoning-delta":
+				signature, _ = msg.Event["signature"].(string)
+			}
+		}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if finish["finishReason"] != tc.reason || (tc.sequence != "" && finish["stopSequence"] != tc.sequence) {
+			t.Fatalf("%s: finish = %v", tc.delta, finish)
+		}
+		if signature != "sig" {
+			t.Fatalf("signature not forwarded: %q", signature)
+		}
+	}
+}
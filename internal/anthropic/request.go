@@ -0,0 +1,81 @@
+package anthropic
+
+import (
+	"github.com/goccy/go-json"
+
+	"orchids-api/internal/prompt"
+	"orchids-api/internal/upstream"
+)
+
+const defaultMaxTokens = 8192
+
+type messagesRequest struct {
+	Model         string              `json:"model"`
+	MaxTokens     int                 `json:"max_tokens"`
+	System        []prompt.SystemItem `json:"system,omitempty"`
+	Messages      []prompt.Message    `json:"messages"`
+	Tools         []interface{}       `json:"tools,omitempty"`
+	ToolChoice    interface{}         `json:"tool_choice,omitempty"`
+	Temperature   *float64            `json:"temperature,omitempty"`
+	TopP          *float64            `json:"top_p,omitempty"`
+	StopSequences []string            `json:"stop_sequences,omitempty"`
+	Thinking      interface{}         `json:"thinking,omitempty"`
+	Metadata      *requestMetadata    `json:"metadata,omitempty"`
+	Stream        bool                `json:"stream"`
+}
+
+type requestMetadata struct {
+	UserID string `json:"user_id"`
+}
+
+// buildRequest re-encodes the proxied request as an Anthropic Messages body.
+// The request is already in Anthropic shape, so only fields the official API
+// would reject are dropped.
+func buildRequest(req upstream.UpstreamRequest) ([]byte, error) {
+	body := messagesRequest{
+		Model:         req.Model,
+		MaxTokens:     req.MaxTokens,
+		System:        req.System,
+		Messages:      make([]prompt.Message, 0, len(req.Messages)),
+		Temperature:   req.Temperature,
+		TopP:          req.TopP,
+		StopSequences: req.StopSequences,
+		Stream:        true,
+	}
+	if body.MaxTokens <= 0 {
+		body.MaxTokens = defaultMaxTokens
+	}
+	if !req.NoTools {
+		body.Tools = req.Tools
+		body.ToolChoice = req.ToolChoice
+	}
+	if !req.NoThinking {
+		body.Thinking = req.Thinking
+	}
+	// The official API accepts only user_id in metadata.
+	if req.UserID != "" {
+		body.Metadata = &requestMetadata{UserID: req.UserID}
+	}
+	for _, m := range req.Messages {
+		if m.Content.IsString() {
+			body.Messages = append(body.Messages, m)
+			continue
+		}
+		// Thinking blocks produced by other channels carry no upstream
+		// signature, which the official API rejects; signed ones go back
+		// as they came.
+		blocks := make([]prompt.ContentBlock, 0, len(m.Content.GetBlocks()))
+		for _, block := range m.Content.GetBlocks() {
+			if (block.Type == "thinking" && block.Signature == "") || (block.Type == "redacted_thinking" && block.Data == "") {
+				continue
+			}
+			blocks = append(blocks, block)
+		}
+		if len(blocks) == 0 {
+			continue
+		}
+		m.Content = prompt.MessageContent{Blocks: blocks}
+		body.Messages = append(body.Messages, m)
+	}
+	return json.Marshal(body)
+}
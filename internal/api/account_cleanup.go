@@ -0,0 +1,19 @@
+package api
+
+// AccountCleanup drops the state this instance keeps for an account once it
+// is deleted. The messages handler implements it.
+type AccountCleanup interface {
+	ForgetAccount(accountID int64)
+}
+
+// SetAccountCleanup wires the per-account state pruned on account delete.
+func (a *API) SetAccountCleanup(c AccountCleanup) {
+	a.accountCleanup = c
+}
+
+// forgetAccount prunes the per-account state of a deleted account.
+func (a *API) forgetAccount(accountID int64) {
+	if a.accountCleanup != nil {
+		a.accountCleanup.ForgetAccount(accountID)
+	}
+}
@@ -0,0 +1,36 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"orchids-api/internal/store"
+)
+
+type recordingCleanup struct{ forgotten []int64 }
+
+func (c *recordingCleanup) ForgetAccount(accountID int64) {
+	c.forgotten = append(c.forgotten, accountID)
+}
+
+func TestAccountDeleteForgetsAccountState(t *testing.T) {
+	a := newTransferAPI(t)
+	cleanup := &recordingCleanup{}
+	a.SetAccountCleanup(cleanup)
+	acc := &store.Account{Name: "a", AccountType: "orchids", Weight: 1, Enabled: true}
+	if err := a.store.CreateAccount(context.Background(), acc); err != nil {
+		t.Fatalf("CreateAccount: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	a.HandleAccountByID(rec, httptest.NewRequest(http.MethodDelete, "/api/accounts/"+strconv.FormatInt(acc.ID, 10), nil))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("delete: %d %s", rec.Code, rec.Body.String())
+	}
+	if len(cleanup.forgotten) != 1 || cleanup.forgotten[0] != acc.ID {
+		t.Fatalf("forgotten = %v, want [%d]", cleanup.forgotten, acc.ID)
+	}
+}
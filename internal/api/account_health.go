@@ -0,0 +1,56 @@
+package api
+
+import (
+	"strings"
+	"time"
+
+	"orchids-api/internal/anomaly"
+	"orchids-api/internal/store"
+)
+
+// AccountHealth reports the recent request health of an account. The
+// messages handler implements it.
+type AccountHealth interface {
+	AccountHealth(acc *store.Account) (anomaly.Health, bool)
+}
+
+// SetAccountHealth wires the health scores shown by /api/accounts.
+func (a *API) SetAccountHealth(h AccountHealth) {
+	a.accountHealth = h
+}
+
+// accountView is an account as listed by /api/accounts, with its health over
+// the anomaly window when it served requests recently and the seconds left
+// on its token when that is a JWT.
+type accountView struct {
+	*store.Account
+	Health         *anomaly.Health `json:"health,omitempty"`
+	TokenExpiresIn *int64          `json:"token_expires_in,omitempty"` // Negative once expired
+	TokenExpiring  bool            `json:"token_expiring,omitempty"`   // Within token_expiry_window
+}
+
+func (a *API) accountViews(accounts []*store.Account) []accountView {
+	window := 600 * time.Second
+	if cfg := a.config.Load(); cfg != nil && cfg.TokenExpiryWindow > 0 {
+		window = time.Duration(cfg.TokenExpiryWindow) * time.Second
+	}
+	now := time.Now()
+	views := make([]accountView, 0, len(accounts))
+	for _, acc := range accounts {
+		view := accountView{Account: accountOutput(acc)}
+		if acc.TokenExpiresAt != nil {
+			left := int64(acc.TokenExpiresAt.Sub(now) / time.Second)
+			view.TokenExpiresIn = &left
+			// Orchids accounts with a Clerk cookie mint a fresh JWT per request.
+			clerkBacked := acc.ClientCookie != "" && (acc.AccountType == "" || strings.EqualFold(acc.AccountType, "orchids"))
+			view.TokenExpiring = !clerkBacked && acc.TokenExpiresWithin(now, window)
+		}
+		if a.accountHealth != nil {
+			if health, ok := a.accountHealth.AccountHealth(acc); ok {
+				view.Health = &health
+			}
+		}
+		views = append(views, view)
+	}
+	return views
+}
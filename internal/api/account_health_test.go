@@ -0,0 +1,96 @@
+package api
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/goccy/go-json"
+
+	"orchids-api/internal/anomaly"
+	"orchids-api/internal/store"
+)
+
+type fakeAccountHealth map[int64]anomaly.Health
+
+func (f fakeAccountHealth) AccountHealth(acc *store.Account) (anomaly.Health, bool) {
+	h, ok := f[acc.ID]
+	return h, ok
+}
+
+func TestHandleAccounts_Health(t *testing.T) {
+	a := newTransferAPI(t)
+	ctx := context.Background()
+	busy := &store.Account{Name: "busy", AccountType: "warp", RefreshToken: "rt", Enabled: true}
+	idle := &store.Account{Name: "idle", AccountType: "warp", RefreshToken: "rt", Enabled: true}
+	for _, acc := range []*store.Account{busy, idle} {
+		if err := a.store.CreateAccount(ctx, acc); err != nil {
+			t.Fatalf("CreateAccount: %v", err)
+		}
+	}
+	a.SetAccountHealth(fakeAccountHealth{busy.ID: {Score: 50, Requests: 12, EmptyRate: 1, Flags: []string{anomaly.MetricEmptyResponses}}})
+
+	rec := httptest.NewRecorder()
+	a.HandleAccounts(rec, httptest.NewRequest(http.MethodGet, "/api/accounts?sort=name", nil))
+	var got []struct {
+		Name   string          `json:"name"`
+		Health *anomaly.Health `json:"health"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("decode: %v (%s)", err, rec.Body.String())
+	}
+	if len(got) != 2 || got[0].Name != "busy" || got[1].Name != "idle" {
+		t.Fatalf("accounts=%s", rec.Body.String())
+	}
+	if h := got[0].Health; h == nil || h.Score != 50 || len(h.Flags) != 1 {
+		t.Fatalf("busy health=%+v", h)
+	}
+	if got[1].Health != nil {
+		t.Fatalf("idle account should have no health, got %+v", got[1].Health)
+	}
+}
+
+func TestHandleAccounts_TokenExpiry(t *testing.T) {
+	a := newTransferAPI(t)
+	ctx := context.Background()
+	jwt := func(exp time.Time) string {
+		payload := fmt.Sprintf(`{"exp":%d}`, exp.Unix())
+		return "eyJhbGciOiJIUzI1NiJ9." + base64.RawURLEncoding.EncodeToString([]byte(payload)) + ".c2lnbmF0dXJl"
+	}
+	soon := &store.Account{Name: "soon", AccountType: "warp", RefreshToken: "rt", Token: jwt(time.Now().Add(5 * time.Minute)), Enabled: true}
+	later := &store.Account{Name: "later", AccountType: "warp", RefreshToken: "rt", Token: jwt(time.Now().Add(2 * time.Hour)), Enabled: true}
+	opaque := &store.Account{Name: "opaque", AccountType: "kiro", RefreshToken: "rt", Token: "aoa-opaque", Enabled: true}
+	for _, acc := range []*store.Account{soon, later, opaque} {
+		if err := a.store.CreateAccount(ctx, acc); err != nil {
+			t.Fatalf("CreateAccount: %v", err)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	a.HandleAccounts(rec, httptest.NewRequest(http.MethodGet, "/api/accounts?sort=name", nil))
+	var got []struct {
+		Name           string     `json:"name"`
+		TokenExpiresAt *time.Time `json:"token_expires_at"`
+		TokenExpiresIn *int64     `json:"token_expires_in"`
+		TokenExpiring  bool       `json:"token_expiring"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("decode: %v (%s)", err, rec.Body.String())
+	}
+	if len(got) != 3 || got[0].Name != "later" || got[1].Name != "opaque" || got[2].Name != "soon" {
+		t.Fatalf("accounts=%s", rec.Body.String())
+	}
+	if in := got[0].TokenExpiresIn; in == nil || *in < 7000 || got[0].TokenExpiring {
+		t.Fatalf("later: %s", rec.Body.String())
+	}
+	if got[1].TokenExpiresAt != nil || got[1].TokenExpiresIn != nil || got[1].TokenExpiring {
+		t.Fatalf("opaque token should have no expiry: %s", rec.Body.String())
+	}
+	if in := got[2].TokenExpiresIn; in == nil || *in > 300 || !got[2].TokenExpiring {
+		t.Fatalf("soon: %s", rec.Body.String())
+	}
+}
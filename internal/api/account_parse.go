@@ -0,0 +1,149 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"github.com/goccy/go-json"
+	"net/http"
+	"strings"
+	"time"
+
+	"orchids-api/internal/clerk"
+	"orchids-api/internal/orchids"
+	"orchids-api/internal/store"
+	"orchids-api/internal/util"
+)
+
+// ParseAccountResponse is the pre-filled account returned by /api/accounts/parse.
+// Warnings list the lookups that failed; the fields they would fill stay empty.
+type ParseAccountResponse struct {
+	Account  *store.Account `json:"account"`
+	Warnings []string       `json:"warnings"`
+}
+
+// HandleParseAccount serves POST /api/accounts/parse. It turns a raw browser
+// cookie header into an Orchids account without saving it, so the admin UI can
+// show the filled-in fields before creating the account.
+func (a *API) HandleParseAccount(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Cookie string `json:"cookie"`
+		Name   string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	acc, err := parseAccountCookie(req.Cookie)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	acc.Name = strings.TrimSpace(req.Name)
+
+	warnings := a.fillAccountFromUpstream(r.Context(), acc)
+	if acc.Name == "" {
+		acc.Name = defaultAccountName(acc)
+	}
+
+	json.NewEncoder(w).Encode(ParseAccountResponse{
+		Account:  normalizeAccountOutput(acc),
+		Warnings: warnings,
+	})
+}
+
+// parseAccountCookie extracts the Clerk cookie values and whatever the session
+// JWT reveals locally, without any network calls.
+func parseAccountCookie(raw string) (*store.Account, error) {
+	raw = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(raw), "Cookie:"))
+	raw = strings.TrimSpace(strings.TrimPrefix(raw, "Bearer "))
+	if raw == "" {
+		return nil, fmt.Errorf("cookie is required")
+	}
+
+	acc := &store.Account{
+		AccountType: "orchids",
+		Enabled:     true,
+		Weight:      1,
+	}
+	if !strings.Contains(raw, "__client=") && isLikelyJWT(raw) && !jwtHasRotatingToken(raw) {
+		// A bare session token rather than a __client cookie: usable as a bearer token only.
+		acc.Token = raw
+		acc.SessionID, acc.UserID = clerk.ParseSessionInfoFromJWT(raw)
+		return acc, nil
+	}
+	clientJWT, sessionJWT, err := clerk.ParseClientCookies(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid client cookie: %w", err)
+	}
+
+	acc.ClientCookie = clientJWT
+	if sessionJWT != "" {
+		acc.SessionCookie = sessionJWT
+		acc.SessionID, acc.UserID = clerk.ParseSessionInfoFromJWT(sessionJWT)
+	}
+	return acc, nil
+}
+
+// fillAccountFromUpstream completes acc from Clerk (session, email, user id)
+// and the Orchids credits action (subscription and usage).
+func (a *API) fillAccountFromUpstream(ctx context.Context, acc *store.Account) []string {
+	warnings := []string{}
+	proxyFunc := http.ProxyFromEnvironment
+	if cfg := a.config.Load(); cfg != nil {
+		proxyFunc = util.ProxyFunc(cfg.ProxyHTTP, cfg.ProxyHTTPS, cfg.ProxyUser, cfg.ProxyPass, cfg.ProxyBypass)
+	}
+
+	jwt := acc.Token
+	if acc.ClientCookie != "" {
+		info, err := clerk.FetchAccountInfoWithSessionProxy(acc.ClientCookie, acc.SessionCookie, proxyFunc)
+		if err != nil {
+			warnings = append(warnings, "clerk account info: "+err.Error())
+		} else {
+			acc.SessionID = info.SessionID
+			acc.ClientUat = info.ClientUat
+			acc.ProjectID = info.ProjectID
+			acc.UserID = info.UserID
+			acc.Email = info.Email
+			if info.ClientCookie != "" {
+				acc.ClientCookie = info.ClientCookie
+			}
+			jwt = info.JWT
+		}
+	}
+
+	if jwt == "" {
+		warnings = append(warnings, "credits: no session token available")
+		return warnings
+	}
+	creditsCtx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+	creditsInfo, err := orchids.FetchCreditsWithProxy(creditsCtx, jwt, acc.UserID, proxyFunc)
+	if err != nil {
+		warnings = append(warnings, "credits: "+err.Error())
+	} else if creditsInfo != nil {
+		acc.Subscription = strings.ToLower(creditsInfo.Plan)
+		acc.UsageCurrent = creditsInfo.Credits
+		acc.UsageLimit = orchids.PlanCreditLimit(creditsInfo.Plan)
+	}
+	return warnings
+}
+
+func defaultAccountName(acc *store.Account) string {
+	if email := strings.TrimSpace(acc.Email); email != "" {
+		if at := strings.Index(email, "@"); at > 0 {
+			return email[:at]
+		}
+		return email
+	}
+	if acc.UserID != "" {
+		return acc.UserID
+	}
+	return "orchids"
+}
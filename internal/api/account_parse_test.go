@@ -0,0 +1,68 @@
+package api
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"orchids-api/internal/store"
+)
+
+func TestParseAccountCookie(t *testing.T) {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{"sid":"sess_1","sub":"user_2"}`))
+	sessionJWT := fmt.Sprintf("%s.%s.%s", "aaaaaaaaaa", payload, "cccccccccc")
+
+	acc, err := parseAccountCookie("Cookie: __client=opaque-client-value; __session=" + sessionJWT)
+	if err != nil {
+		t.Fatalf("parseAccountCookie: %v", err)
+	}
+	if acc.ClientCookie != "opaque-client-value" || acc.SessionCookie != sessionJWT {
+		t.Fatalf("unexpected cookies: %+v", acc)
+	}
+	if acc.SessionID != "sess_1" || acc.UserID != "user_2" {
+		t.Fatalf("session info not extracted: %+v", acc)
+	}
+	if acc.AccountType != "orchids" || !acc.Enabled {
+		t.Fatalf("unexpected defaults: %+v", acc)
+	}
+
+	acc, err = parseAccountCookie(sessionJWT)
+	if err != nil {
+		t.Fatalf("parseAccountCookie(bare jwt): %v", err)
+	}
+	if acc.Token != sessionJWT || acc.ClientCookie != "" || acc.UserID != "user_2" {
+		t.Fatalf("bare session token should become bearer token: %+v", acc)
+	}
+
+	if _, err := parseAccountCookie("   "); err == nil {
+		t.Fatal("expected error for empty cookie")
+	}
+	if _, err := parseAccountCookie("foo=bar; baz"); err == nil {
+		t.Fatal("expected error for unrelated cookie")
+	}
+}
+
+func TestHandleParseAccountRejectsBadInput(t *testing.T) {
+	a := &API{}
+
+	rec := httptest.NewRecorder()
+	a.HandleParseAccount(rec, httptest.NewRequest(http.MethodGet, "/api/accounts/parse", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("GET status = %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	a.HandleParseAccount(rec, httptest.NewRequest(http.MethodPost, "/api/accounts/parse", strings.NewReader(`{"cookie":""}`)))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("empty cookie status = %d", rec.Code)
+	}
+}
+
+func TestDefaultAccountName(t *testing.T) {
+	if got := defaultAccountName(&store.Account{Email: "alice@example.com"}); got != "alice" {
+		t.Fatalf("defaultAccountName = %q", got)
+	}
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/goccy/go-json"
+
+	"orchids-api/internal/store"
+)
+
+// accountPatch is a partial account update. Unlike PUT it never touches
+// credentials, so quick toggles don't have to send secrets back.
+type accountPatch struct {
+	Enabled *bool     `json:"enabled"`
+	Weight  *int      `json:"weight"`
+	Tags    *[]string `json:"tags"`
+}
+
+func (p *accountPatch) empty() bool {
+	return p.Enabled == nil && p.Weight == nil && p.Tags == nil
+}
+
+func (p *accountPatch) validate() error {
+	if p.empty() {
+		return errors.New("at least one of enabled, weight or tags is required")
+	}
+	if p.Weight != nil && *p.Weight < 1 {
+		return errors.New("weight must be at least 1")
+	}
+	return nil
+}
+
+func (p *accountPatch) apply(acc *store.Account) {
+	if p.Enabled != nil {
+		acc.Enabled = *p.Enabled
+	}
+	if p.Weight != nil {
+		acc.Weight = *p.Weight
+	}
+	if p.Tags != nil {
+		acc.Tags = normalizeTags(*p.Tags)
+	}
+}
+
+// patchAccount loads account id, applies p and saves it.
+func (a *API) patchAccount(r *http.Request, id int64, p *accountPatch) (*store.Account, error) {
+	acc, err := a.store.GetAccount(r.Context(), id)
+	if err != nil {
+		return nil, err
+	}
+	p.apply(acc)
+	if err := a.store.UpdateAccount(r.Context(), acc); err != nil {
+		return nil, err
+	}
+	return acc, nil
+}
+
+// handleAccountPatch serves PATCH /api/accounts/{id}.
+func (a *API) handleAccountPatch(w http.ResponseWriter, r *http.Request, id int64) {
+	var p accountPatch
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := p.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	acc, err := a.patchAccount(r, id, &p)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, store.ErrNoRows) {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	json.NewEncoder(w).Encode(accountOutput(acc))
+}
+
+type bulkAccountPatch struct {
+	IDs []int64 `json:"ids"`
+	accountPatch
+}
+
+type bulkPatchFailure struct {
+	ID    int64  `json:"id"`
+	Error string `json:"error"`
+}
+
+// handleAccountsBulkPatch serves PATCH /api/accounts: the same partial update
+// applied to every account in ids. Accounts that fail are reported and the
+// rest are still updated.
+func (a *API) handleAccountsBulkPatch(w http.ResponseWriter, r *http.Request) {
+	var req bulkAccountPatch
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(req.IDs) == 0 {
+		http.Error(w, "ids is required", http.StatusBadRequest)
+		return
+	}
+	if err := req.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	updated := make([]int64, 0, len(req.IDs))
+	failed := []bulkPatchFailure{}
+	for _, id := range req.IDs {
+		if _, err := a.patchAccount(r, id, &req.accountPatch); err != nil {
+			msg := err.Error()
+			if errors.Is(err, store.ErrNoRows) {
+				msg = "not found"
+			}
+			failed = append(failed, bulkPatchFailure{ID: id, Error: msg})
+			continue
+		}
+		updated = append(updated, id)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"updated": updated,
+		"failed":  failed,
+	})
+}
@@ -0,0 +1,70 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/goccy/go-json"
+
+	"orchids-api/internal/store"
+)
+
+func TestAccountPatch(t *testing.T) {
+	a := newTransferAPI(t)
+	ctx := context.Background()
+	first := &store.Account{Name: "a", AccountType: "warp", RefreshToken: "secret-rt", Weight: 1, Enabled: true}
+	second := &store.Account{Name: "b", AccountType: "warp", RefreshToken: "rt-b", Weight: 1, Enabled: true}
+	for _, acc := range []*store.Account{first, second} {
+		if err := a.store.CreateAccount(ctx, acc); err != nil {
+			t.Fatalf("CreateAccount: %v", err)
+		}
+	}
+	path := "/api/accounts/" + strconv.FormatInt(first.ID, 10)
+
+	rec := httptest.NewRecorder()
+	a.HandleAccountByID(rec, httptest.NewRequest(http.MethodPatch, path, strings.NewReader(`{"weight":5,"tags":[" pro ","pro",""]}`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("patch: %d %s", rec.Code, rec.Body.String())
+	}
+	got, _ := a.store.GetAccount(ctx, first.ID)
+	if got.Weight != 5 || !got.Enabled || len(got.Tags) != 1 || got.Tags[0] != "pro" || got.RefreshToken != "secret-rt" {
+		t.Fatalf("patched account: %+v", got)
+	}
+
+	for body, want := range map[string]int{`{}`: http.StatusBadRequest, `{"weight":0}`: http.StatusBadRequest} {
+		rec := httptest.NewRecorder()
+		a.HandleAccountByID(rec, httptest.NewRequest(http.MethodPatch, path, strings.NewReader(body)))
+		if rec.Code != want {
+			t.Fatalf("patch %s: %d want %d", body, rec.Code, want)
+		}
+	}
+	rec = httptest.NewRecorder()
+	a.HandleAccountByID(rec, httptest.NewRequest(http.MethodPatch, "/api/accounts/999", strings.NewReader(`{"enabled":false}`)))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("patch missing account: %d", rec.Code)
+	}
+
+	body := `{"ids":[` + strconv.FormatInt(first.ID, 10) + `,` + strconv.FormatInt(second.ID, 10) + `,999],"enabled":false}`
+	rec = httptest.NewRecorder()
+	a.HandleAccounts(rec, httptest.NewRequest(http.MethodPatch, "/api/accounts", strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("bulk patch: %d %s", rec.Code, rec.Body.String())
+	}
+	var res struct {
+		Updated []int64            `json:"updated"`
+		Failed  []bulkPatchFailure `json:"failed"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &res)
+	if len(res.Updated) != 2 || len(res.Failed) != 1 || res.Failed[0].ID != 999 {
+		t.Fatalf("bulk result: %+v", res)
+	}
+	for _, id := range res.Updated {
+		if acc, _ := a.store.GetAccount(ctx, id); acc.Enabled {
+			t.Fatalf("account %d still enabled", id)
+		}
+	}
+}
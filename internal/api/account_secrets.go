@@ -0,0 +1,111 @@
+package api
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/goccy/go-json"
+
+	"orchids-api/internal/audit"
+	"orchids-api/internal/auth"
+	"orchids-api/internal/middleware"
+	"orchids-api/internal/store"
+)
+
+// accountSecretFields returns pointers to the credential fields of acc, in a
+// fixed order shared by masking and unmasking. Token holds the live bearer
+// JWT (for Orchids bearer accounts the only credential).
+func accountSecretFields(acc *store.Account) []*string {
+	return []*string{&acc.ClientCookie, &acc.SessionCookie, &acc.RefreshToken, &acc.APIKey, &acc.Token}
+}
+
+// accountOutput is the form of acc returned by the admin API: credentials are
+// masked and only revealed through /api/accounts/{id}/secret.
+func accountOutput(acc *store.Account) *store.Account {
+	out := normalizeAccountOutput(acc)
+	if out == nil {
+		return nil
+	}
+	for _, field := range accountSecretFields(out) {
+		*field = auth.MaskSensitive(*field)
+	}
+	return out
+}
+
+// unmaskAccountSecrets restores the credentials a client sent back in their
+// masked form (e.g. an edit form saved without touching them), so a PUT does
+// not overwrite them with the mask. Warp accounts show their token under
+// another field than the one it is stored in, so every stored credential is
+// tried, the same field first.
+func unmaskAccountSecrets(acc, existing *store.Account) {
+	originals := accountSecretFields(existing)
+	for i, field := range accountSecretFields(acc) {
+		if *field == "" {
+			continue
+		}
+		candidates := append([]*string{originals[i]}, originals...)
+		for _, orig := range candidates {
+			if *orig != "" && *field == auth.MaskSensitive(*orig) {
+				*field = *orig
+				break
+			}
+		}
+	}
+}
+
+type accountSecret struct {
+	AccountID     int64  `json:"account_id"`
+	SessionID     string `json:"session_id,omitempty"`
+	ClientCookie  string `json:"client_cookie,omitempty"`
+	SessionCookie string `json:"session_cookie,omitempty"`
+	RefreshToken  string `json:"refresh_token,omitempty"`
+	APIKey        string `json:"api_key,omitempty"`
+	Token         string `json:"token,omitempty"`
+}
+
+// handleAccountSecret serves GET /api/accounts/{id}/secret, the unmasked
+// credentials of an account. Only the global admin may reveal them; every
+// reveal is written to the audit log.
+func (a *API) handleAccountSecret(w http.ResponseWriter, r *http.Request, id int64) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if _, scoped := store.TenantFromContext(r.Context()); scoped {
+		http.Error(w, "revealing account credentials requires the global admin", http.StatusForbidden)
+		return
+	}
+	acc, err := a.store.GetAccount(r.Context(), id)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, store.ErrNoRows) {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	out := normalizeAccountOutput(acc)
+
+	slog.Warn("Account credentials revealed", "account_id", id, "trace_id", middleware.GetTraceID(r.Context()), "client_ip", r.RemoteAddr)
+	if a.auditLog != nil {
+		a.auditLog.Log(r.Context(), audit.Event{
+			Action:    "account_secret_reveal",
+			AccountID: id,
+			ClientIP:  r.RemoteAddr,
+			UserAgent: r.UserAgent(),
+			Status:    "success",
+		})
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(accountSecret{
+		AccountID:     id,
+		SessionID:     out.SessionID,
+		ClientCookie:  out.ClientCookie,
+		SessionCookie: out.SessionCookie,
+		RefreshToken:  out.RefreshToken,
+		APIKey:        out.APIKey,
+		Token:         out.Token,
+	})
+}
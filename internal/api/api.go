@@ -7,23 +7,32 @@ import (
 	"crypto/subtle"
 	"encoding/hex"
 	"errors"
+	"fmt"
 	"github.com/goccy/go-json"
+	"io"
 	"log/slog"
 	"math/big"
 	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"orchids-api/internal/audit"
 	"orchids-api/internal/auth"
 	"orchids-api/internal/clerk"
 	"orchids-api/internal/config"
 	apperrors "orchids-api/internal/errors"
+	"orchids-api/internal/events"
+	"orchids-api/internal/featureflag"
 	"orchids-api/internal/grok"
 	"orchids-api/internal/middleware"
 	"orchids-api/internal/orchids"
+	"orchids-api/internal/scheduler"
 	"orchids-api/internal/store"
 	"orchids-api/internal/tokencache"
 	"orchids-api/internal/util"
@@ -37,6 +46,21 @@ type API struct {
 	adminPass    string
 	loginLimiter *middleware.RateLimiter
 	config       atomic.Pointer[config.Config]
+	flags        *featureflag.Registry
+	// accountRequests lets account drain see and cancel in-flight requests.
+	accountRequests AccountRequests
+	// accountHealth adds health scores to the account list.
+	accountHealth AccountHealth
+	// accountCleanup prunes per-account state on account delete.
+	accountCleanup AccountCleanup
+	// auditLog backs /api/keys/{id}/stats.
+	auditLog audit.Logger
+	// jobs backs /api/jobs.
+	jobs *scheduler.Scheduler
+	// events backs /api/events.
+	events *events.Bus
+	// configHistoryMu serializes writes to the config history.
+	configHistoryMu sync.Mutex
 
 	// Account check backoff / storm control
 	checkMu          sync.Mutex
@@ -65,6 +89,120 @@ func normalizeWarpTokenInput(acc *store.Account) {
 	acc.SessionCookie = ""
 }
 
+func normalizeKiroTokenInput(acc *store.Account) {
+	if acc == nil || !strings.EqualFold(acc.AccountType, "kiro") {
+		return
+	}
+	if acc.RefreshToken == "" && acc.ClientCookie != "" {
+		acc.RefreshToken = acc.ClientCookie
+	}
+	acc.RefreshToken = strings.TrimSpace(acc.RefreshToken)
+	// Kiro 只使用 refresh_token（access token 由刷新得到）
+	acc.ClientCookie = ""
+	acc.SessionCookie = ""
+}
+
+func normalizeAPIKeyInput(acc *store.Account) {
+	if acc == nil || !isAPIKeyAccountType(acc.AccountType) {
+		return
+	}
+	if acc.APIKey == "" && acc.ClientCookie != "" {
+		acc.APIKey = acc.ClientCookie
+	}
+	acc.APIKey = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(acc.APIKey), "Bearer "))
+	acc.BaseURL = strings.TrimRight(strings.TrimSpace(acc.BaseURL), "/")
+	// API-key 渠道只使用 base_url + api_key
+	acc.ClientCookie = ""
+	acc.SessionCookie = ""
+}
+
+// normalizeBaseURL validates an account or channel base_url: an absolute
+// http(s) URL without credentials, query or fragment. Trailing slashes are
+// dropped; an empty value means the channel's default endpoint.
+func normalizeBaseURL(raw, accountType string) (string, error) {
+	raw = strings.TrimRight(strings.TrimSpace(raw), "/")
+	if raw == "" {
+		return "", nil
+	}
+	if strings.EqualFold(strings.TrimSpace(accountType), "grok") {
+		return "", errors.New("base_url is not supported for grok")
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", fmt.Errorf("invalid base_url: %w", err)
+	}
+	switch {
+	case u.Scheme != "http" && u.Scheme != "https":
+		return "", fmt.Errorf("invalid base_url %q: scheme must be http or https", raw)
+	case u.Host == "":
+		return "", fmt.Errorf("invalid base_url %q: missing host", raw)
+	case u.User != nil:
+		return "", fmt.Errorf("invalid base_url %q: credentials belong in api_key or headers", raw)
+	case u.RawQuery != "" || u.Fragment != "":
+		return "", fmt.Errorf("invalid base_url %q: query and fragment are not allowed", raw)
+	}
+	return raw, nil
+}
+
+// normalizeAccountCaps clamps the request and token caps to >= 0 and the cap
+// reset day to 1-28 (0 = calendar month).
+func normalizeAccountCaps(acc *store.Account) {
+	if acc == nil {
+		return
+	}
+	acc.DailyRequestCap = max(acc.DailyRequestCap, 0)
+	acc.MonthlyRequestCap = max(acc.MonthlyRequestCap, 0)
+	acc.DailyTokenCap = max(acc.DailyTokenCap, 0)
+	acc.MonthlyTokenCap = max(acc.MonthlyTokenCap, 0)
+	acc.CapResetDay = min(max(acc.CapResetDay, 0), 28)
+}
+
+// normalizeAccountHeaders trims the per-account header overrides and drops
+// blank User-Agent entries and tags.
+func normalizeAccountHeaders(acc *store.Account) {
+	if acc == nil {
+		return
+	}
+	if acc.Headers != nil {
+		headers := make(map[string]string, len(acc.Headers))
+		for name, value := range acc.Headers {
+			if name = strings.TrimSpace(name); name != "" {
+				headers[name] = strings.TrimSpace(value)
+			}
+		}
+		acc.Headers = headers
+	}
+	if acc.UserAgents != nil {
+		agents := make([]string, 0, len(acc.UserAgents))
+		for _, ua := range acc.UserAgents {
+			if ua = strings.TrimSpace(ua); ua != "" {
+				agents = append(agents, ua)
+			}
+		}
+		acc.UserAgents = agents
+	}
+	if acc.Tags != nil {
+		acc.Tags = normalizeTags(acc.Tags)
+	}
+}
+
+// normalizeTags trims tags and drops blanks and duplicates.
+func normalizeTags(tags []string) []string {
+	out := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		if tag = strings.TrimSpace(tag); tag != "" && !slices.Contains(out, tag) {
+			out = append(out, tag)
+		}
+	}
+	return out
+}
+
+// isAPIKeyAccountType reports whether the account authenticates with a
+// per-account base_url + api_key instead of session credentials.
+func isAPIKeyAccountType(accountType string) bool {
+	return strings.EqualFold(accountType, "openai-compatible") || strings.EqualFold(accountType, "anthropic")
+}
+
 func normalizeWarpTokenOutput(acc *store.Account) *store.Account {
 	if acc == nil {
 		return nil
@@ -132,27 +270,80 @@ func normalizeAccountOutput(acc *store.Account) *store.Account {
 type ExportData struct {
 	Version  int             `json:"version"`
 	ExportAt time.Time       `json:"export_at"`
-	Accounts []store.Account `json:"accounts"`
+	Accounts []store.Account `json:"accounts,omitempty"`
+	Keys     []ExportedKey   `json:"keys,omitempty"`
+	Models   []store.Model   `json:"models,omitempty"`
+	Settings json.RawMessage `json:"settings,omitempty"`
+}
+
+// ExportedKey carries the key hash (and full key, when stored) so an imported
+// key keeps authenticating on the target instance.
+type ExportedKey struct {
+	store.ApiKey
+	KeyHash string `json:"key_hash"`
+	KeyFull string `json:"key_full,omitempty"`
 }
 
 type ImportResult struct {
+	Total    int                           `json:"total"`
+	Imported int                           `json:"imported"`
+	Updated  int                           `json:"updated"`
+	Deleted  int                           `json:"deleted"`
+	Skipped  int                           `json:"skipped"`
+	Strategy string                        `json:"strategy"`
+	DryRun   bool                          `json:"dry_run,omitempty"`
+	Scopes   map[string]*ImportScopeResult `json:"scopes"`
+	// Changes lists every planned action; only filled in for dry runs.
+	Changes []ImportChange `json:"changes,omitempty"`
+}
+
+type ImportScopeResult struct {
 	Total    int `json:"total"`
 	Imported int `json:"imported"`
+	Updated  int `json:"updated"`
+	Deleted  int `json:"deleted"`
 	Skipped  int `json:"skipped"`
 }
 
+type ImportChange struct {
+	Scope  string   `json:"scope"`
+	Action string   `json:"action"` // create, update, delete, skip, unchanged
+	Key    string   `json:"key"`
+	Fields []string `json:"fields,omitempty"`
+}
+
 type CreateKeyResponse struct {
-	ID        int64     `json:"id"`
-	Key       string    `json:"key"`
-	Name      string    `json:"name"`
-	KeyPrefix string    `json:"key_prefix"`
-	KeySuffix string    `json:"key_suffix"`
-	Enabled   bool      `json:"enabled"`
-	CreatedAt time.Time `json:"created_at"`
+	ID                int64               `json:"id"`
+	Key               string              `json:"key"`
+	Name              string              `json:"name"`
+	KeyPrefix         string              `json:"key_prefix"`
+	KeySuffix         string              `json:"key_suffix"`
+	Enabled           bool                `json:"enabled"`
+	SystemPrompt      string              `json:"system_prompt,omitempty"`
+	AllowPinning      bool                `json:"allow_pinning"`
+	TPMLimit          int                 `json:"tpm_limit,omitempty"`
+	ToolGateMaxChars  int                 `json:"tool_gate_max_chars,omitempty"`
+	SkipPromptHygiene bool                `json:"skip_prompt_hygiene,omitempty"`
+	StrictModels      bool                `json:"strict_models,omitempty"`
+	Description       string              `json:"description,omitempty"`
+	Owner             string              `json:"owner,omitempty"`
+	MonthlyTokenQuota int                 `json:"monthly_token_quota,omitempty"`
+	ExpiresAt         *time.Time          `json:"expires_at,omitempty"`
+	AllowedIPs        []string            `json:"allowed_ips,omitempty"`
+	TenantID          int64               `json:"tenant_id,omitempty"`
+	LogResidency      *store.LogResidency `json:"log_residency,omitempty"`
+	CreatedAt         time.Time           `json:"created_at"`
 }
 
 type UpdateKeyRequest struct {
-	Enabled *bool `json:"enabled"`
+	Enabled           *bool               `json:"enabled"`
+	SystemPrompt      *string             `json:"system_prompt"`
+	AllowPinning      *bool               `json:"allow_pinning"`
+	TPMLimit          *int                `json:"tpm_limit"`
+	ToolGateMaxChars  *int                `json:"tool_gate_max_chars"`
+	SkipPromptHygiene *bool               `json:"skip_prompt_hygiene"`
+	StrictModels      *bool               `json:"strict_models"`
+	LogResidency      *store.LogResidency `json:"log_residency"`
 }
 
 func New(s *store.Store, adminUser, adminPass string, cfg *config.Config) *API {
@@ -161,6 +352,8 @@ func New(s *store.Store, adminUser, adminPass string, cfg *config.Config) *API {
 		adminUser:    adminUser,
 		adminPass:    adminPass,
 		loginLimiter: middleware.NewRateLimiter(5, 15*time.Minute),
+		flags:        featureflag.Default,
+		events:       events.Default,
 
 		checkInFlight:    map[int64]bool{},
 		checkFailCount:   map[int64]int{},
@@ -198,12 +391,16 @@ func (a *API) HandleLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if !secureCompare(req.Username, a.adminUser) || !secureCompare(req.Password, a.adminPass) {
+	var token string
+	var err error
+	if secureCompare(req.Username, a.adminUser) && secureCompare(req.Password, a.adminPass) {
+		token, err = auth.GenerateSessionToken()
+	} else if t := a.tenantLogin(r, req.Username, req.Password); t != nil {
+		token, err = auth.GenerateTenantSessionToken(t.ID)
+	} else {
 		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
 		return
 	}
-
-	token, err := auth.GenerateSessionToken()
 	if err != nil {
 		slog.Error("Failed to generate session token", "error", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
@@ -262,15 +459,8 @@ func (a *API) HandleConfig(w http.ResponseWriter, r *http.Request) {
 		}
 		config.ApplyHardcoded(&newCfg)
 
-		// Save to Redis
-		data, err := json.Marshal(&newCfg)
-		if err != nil {
-			http.Error(w, "Failed to marshal config: "+err.Error(), http.StatusInternalServerError)
-			return
-		}
-		a.config.Store(&newCfg)
-
-		if err := a.store.SetSetting(r.Context(), "config", string(data)); err != nil {
+		// Save to Redis and record the change in the config history
+		if _, err := a.saveConfig(r.Context(), current, &newCfg, a.configEditor(r, "api")); err != nil {
 			http.Error(w, "Failed to save config to Redis: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -292,14 +482,10 @@ func (a *API) HandleAccounts(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		if accounts == nil {
-			accounts = []*store.Account{}
-		}
-		normalized := make([]*store.Account, 0, len(accounts))
-		for _, acc := range accounts {
-			normalized = append(normalized, normalizeAccountOutput(acc))
-		}
-		json.NewEncoder(w).Encode(normalized)
+		writeList(w, r, a.accountViews(accounts))
+
+	case http.MethodPatch:
+		a.handleAccountsBulkPatch(w, r)
 
 	case http.MethodPost:
 		var acc store.Account
@@ -310,8 +496,20 @@ func (a *API) HandleAccounts(w http.ResponseWriter, r *http.Request) {
 		if strings.TrimSpace(acc.AccountType) == "" {
 			acc.AccountType = "orchids"
 		}
+		normalizeAccountHeaders(&acc)
+		normalizeAccountCaps(&acc)
+		baseURL, err := normalizeBaseURL(acc.BaseURL, acc.AccountType)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		acc.BaseURL = baseURL
 		if strings.EqualFold(acc.AccountType, "warp") {
 			normalizeWarpTokenInput(&acc)
+		} else if strings.EqualFold(acc.AccountType, "kiro") {
+			normalizeKiroTokenInput(&acc)
+		} else if isAPIKeyAccountType(acc.AccountType) {
+			normalizeAPIKeyInput(&acc)
 		} else if strings.EqualFold(acc.AccountType, "grok") {
 			normalizeGrokTokenInput(&acc)
 		} else if acc.ClientCookie != "" {
@@ -374,6 +572,10 @@ func (a *API) HandleAccounts(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 
+		if err := a.tenantExists(r, acc.TenantID); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		if err := a.store.CreateAccount(r.Context(), &acc); err != nil {
 			slog.Error("Failed to create account", "error", err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -381,7 +583,7 @@ func (a *API) HandleAccounts(w http.ResponseWriter, r *http.Request) {
 		}
 
 		w.WriteHeader(http.StatusCreated)
-		json.NewEncoder(w).Encode(normalizeAccountOutput(&acc))
+		json.NewEncoder(w).Encode(accountOutput(&acc))
 
 	default:
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -403,6 +605,14 @@ func (a *API) HandleAccountByID(w http.ResponseWriter, r *http.Request) {
 	isVerify := len(parts) > 1 && parts[1] == "verify"
 	isCheck := len(parts) > 1 && parts[1] == "check"
 	isUsage := len(parts) > 1 && parts[1] == "usage"
+	if len(parts) > 1 && parts[1] == "drain" {
+		a.handleAccountDrain(w, r, id)
+		return
+	}
+	if len(parts) > 1 && parts[1] == "secret" {
+		a.handleAccountSecret(w, r, id)
+		return
+	}
 
 	switch r.Method {
 	case http.MethodGet:
@@ -554,6 +764,16 @@ func (a *API) HandleAccountByID(w http.ResponseWriter, r *http.Request) {
 						}
 					}
 				}
+			} else if strings.EqualFold(acc.AccountType, "openai-compatible") {
+				if strings.TrimSpace(acc.BaseURL) == "" {
+					http.Error(w, "Failed to verify openai-compatible account: missing base_url", http.StatusBadRequest)
+					return
+				}
+			} else if strings.EqualFold(acc.AccountType, "anthropic") {
+				if strings.TrimSpace(acc.APIKey) == "" {
+					http.Error(w, "Failed to verify anthropic account: missing api_key", http.StatusBadRequest)
+					return
+				}
 			} else if strings.EqualFold(acc.AccountType, "grok") {
 				if strings.TrimSpace(acc.ClientCookie) == "" {
 					http.Error(w, "Failed to verify grok account: missing sso token", http.StatusBadRequest)
@@ -634,7 +854,7 @@ func (a *API) HandleAccountByID(w http.ResponseWriter, r *http.Request) {
 						http.Error(w, "Failed to save checked account: "+err.Error(), http.StatusInternalServerError)
 						return
 					}
-					json.NewEncoder(w).Encode(normalizeAccountOutput(acc))
+					json.NewEncoder(w).Encode(accountOutput(acc))
 					return
 				}
 				info, err := clerk.FetchAccountInfoWithSessionProxy(acc.ClientCookie, acc.SessionCookie, proxyFunc)
@@ -722,7 +942,7 @@ func (a *API) HandleAccountByID(w http.ResponseWriter, r *http.Request) {
 				http.Error(w, "Failed to save checked account: "+err.Error(), http.StatusInternalServerError)
 				return
 			}
-			json.NewEncoder(w).Encode(normalizeAccountOutput(acc))
+			json.NewEncoder(w).Encode(accountOutput(acc))
 			return
 		}
 		acc, err := a.store.GetAccount(r.Context(), id)
@@ -730,7 +950,7 @@ func (a *API) HandleAccountByID(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, err.Error(), http.StatusNotFound)
 			return
 		}
-		json.NewEncoder(w).Encode(normalizeAccountOutput(acc))
+		json.NewEncoder(w).Encode(accountOutput(acc))
 
 	case http.MethodPut:
 		existing, err := a.store.GetAccount(r.Context(), id)
@@ -744,6 +964,7 @@ func (a *API) HandleAccountByID(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
+		unmaskAccountSecrets(&acc, existing)
 		acc.ID = id
 		if strings.TrimSpace(acc.AccountType) == "" {
 			acc.AccountType = existing.AccountType
@@ -751,8 +972,20 @@ func (a *API) HandleAccountByID(w http.ResponseWriter, r *http.Request) {
 		if strings.TrimSpace(acc.AccountType) == "" {
 			acc.AccountType = "orchids"
 		}
+		normalizeAccountHeaders(&acc)
+		normalizeAccountCaps(&acc)
+		baseURL, err := normalizeBaseURL(acc.BaseURL, acc.AccountType)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		acc.BaseURL = baseURL
 		if strings.EqualFold(acc.AccountType, "warp") {
 			normalizeWarpTokenInput(&acc)
+		} else if strings.EqualFold(acc.AccountType, "kiro") {
+			normalizeKiroTokenInput(&acc)
+		} else if isAPIKeyAccountType(acc.AccountType) {
+			normalizeAPIKeyInput(&acc)
 		} else if strings.EqualFold(acc.AccountType, "grok") {
 			normalizeGrokTokenInput(&acc)
 		} else if acc.ClientCookie != "" {
@@ -778,6 +1011,22 @@ func (a *API) HandleAccountByID(w http.ResponseWriter, r *http.Request) {
 		if acc.SessionID == "" {
 			acc.SessionID = existing.SessionID
 		}
+		if acc.BaseURL == "" {
+			acc.BaseURL = existing.BaseURL
+		}
+		if acc.APIKey == "" {
+			acc.APIKey = existing.APIKey
+		}
+		// nil means the field was omitted; an explicit {} / [] clears it.
+		if acc.Headers == nil {
+			acc.Headers = existing.Headers
+		}
+		if acc.UserAgents == nil {
+			acc.UserAgents = existing.UserAgents
+		}
+		if acc.Tags == nil {
+			acc.Tags = existing.Tags
+		}
 		if acc.SessionCookie == "" {
 			acc.SessionCookie = existing.SessionCookie
 		}
@@ -801,13 +1050,23 @@ func (a *API) HandleAccountByID(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		json.NewEncoder(w).Encode(normalizeAccountOutput(&acc))
+		// Drain state is only changed through /drain.
+		acc.Draining = existing.Draining
+		json.NewEncoder(w).Encode(accountOutput(&acc))
+
+	case http.MethodPatch:
+		if len(parts) > 1 {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		a.handleAccountPatch(w, r, id)
 
 	case http.MethodDelete:
 		if err := a.store.DeleteAccount(r.Context(), id); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		a.forgetAccount(id)
 		w.WriteHeader(http.StatusNoContent)
 
 	default:
@@ -821,25 +1080,43 @@ func (a *API) HandleExport(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	accounts, err := a.store.ListAccounts(r.Context())
+	scopes, err := parseTransferScopes(r.URL.Query().Get("scope"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(scopes) == 0 {
+		scopes = map[string]bool{transferAccounts: true}
+	}
+
+	exportData, err := a.buildExport(r.Context(), scopes)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	exportData := ExportData{
-		Version:  1,
-		ExportAt: time.Now(),
-		Accounts: make([]store.Account, len(accounts)),
+	filename := "accounts_export.json"
+	if len(scopes) > 1 || !scopes[transferAccounts] {
+		filename = "orchids_export.json"
 	}
-	for i, acc := range accounts {
-		exportData.Accounts[i] = *normalizeAccountOutput(acc)
-		exportData.Accounts[i].ID = 0
-		exportData.Accounts[i].RequestCount = 0
+	if passphrase := r.Header.Get(backupPassphraseHeader); passphrase != "" {
+		plaintext, err := json.Marshal(exportData)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		sealed, err := encryptBackup(plaintext, passphrase)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", "attachment; filename="+strings.TrimSuffix(filename, ".json")+".enc.json")
+		w.Write(sealed)
+		return
 	}
-
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Content-Disposition", "attachment; filename=accounts_export.json")
+	w.Header().Set("Content-Disposition", "attachment; filename="+filename)
 	json.NewEncoder(w).Encode(exportData)
 }
 
@@ -849,69 +1126,75 @@ func (a *API) HandleImport(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	query := r.URL.Query()
+	scopes, err := parseTransferScopes(query.Get("scope"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	strategy := strings.ToLower(strings.TrimSpace(query.Get("strategy")))
+	if strategy == "" {
+		strategy = importStrategySkip
+	}
+	if !validImportStrategy(strategy) {
+		http.Error(w, "invalid strategy: "+strategy, http.StatusBadRequest)
+		return
+	}
+	dryRun, _ := strconv.ParseBool(query.Get("dry_run"))
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	body, err = decodeBackup(body, r.Header.Get(backupPassphraseHeader))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	var exportData ExportData
-	if err := json.NewDecoder(r.Body).Decode(&exportData); err != nil {
+	if err := json.Unmarshal(body, &exportData); err != nil {
 		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
 		return
 	}
+	if len(scopes) == 0 {
+		scopes = exportData.presentScopes()
+	}
 
-	result := ImportResult{Total: len(exportData.Accounts)}
-
-	for _, acc := range exportData.Accounts {
-		acc.ID = 0
-		acc.RequestCount = 0
-		if strings.TrimSpace(acc.AccountType) == "" {
-			acc.AccountType = "orchids"
-		}
-		if strings.EqualFold(acc.AccountType, "warp") {
-			normalizeWarpTokenInput(&acc)
-		} else if strings.EqualFold(acc.AccountType, "grok") {
-			normalizeGrokTokenInput(&acc)
-		} else if acc.ClientCookie != "" {
-			acc.ClientCookie = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(acc.ClientCookie), "Bearer "))
-			clientJWT, sessionJWT, err := clerk.ParseClientCookies(acc.ClientCookie)
-			if err != nil {
-				if isLikelyJWT(acc.ClientCookie) {
-					if jwtHasRotatingToken(acc.ClientCookie) {
-						acc.SessionCookie = ""
-						acc.SessionID = ""
-						acc.Token = ""
-					} else {
-						acc.Token = strings.TrimSpace(acc.ClientCookie)
-						acc.ClientCookie = ""
-						acc.SessionCookie = ""
-						acc.SessionID = ""
-					}
-				} else {
-					slog.Warn("Invalid client cookie in import", "name", acc.Name, "error", err)
-					result.Skipped++
-					continue
-				}
-			} else {
-				acc.ClientCookie = clientJWT
-				if sessionJWT != "" {
-					acc.SessionCookie = sessionJWT
-					if acc.SessionID == "" {
-						if sid, sub := clerk.ParseSessionInfoFromJWT(sessionJWT); sid != "" {
-							acc.SessionID = sid
-							if acc.UserID == "" {
-								acc.UserID = sub
-							}
-						}
-					}
-				}
-			}
+	im := &importer{a: a, ctx: r.Context(), strategy: strategy, dryRun: dryRun, editor: a.configEditor(r, "import")}
+	if err := im.run(&exportData, scopes); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, errInvalidImport) {
+			status = http.StatusBadRequest
 		}
-		if err := a.store.CreateAccount(r.Context(), &acc); err != nil {
-			slog.Warn("Failed to import account", "name", acc.Name, "error", err)
-			result.Skipped++
-		} else {
-			result.Imported++
+		if !dryRun {
+			a.events.Publish(events.Event{Type: events.ImportFinished, Level: events.LevelError, Message: "import failed: " + err.Error(), Data: map[string]interface{}{
+				"strategy": strategy,
+				"error":    err.Error(),
+			}})
 		}
+		http.Error(w, err.Error(), status)
+		return
 	}
 
+	res := im.result()
+	if !dryRun {
+		a.events.Publish(events.Event{
+			Type:    events.ImportFinished,
+			Level:   events.LevelInfo,
+			Message: fmt.Sprintf("import finished: %d created, %d updated, %d deleted, %d skipped", res.Imported, res.Updated, res.Deleted, res.Skipped),
+			Data: map[string]interface{}{
+				"strategy": res.Strategy,
+				"total":    res.Total,
+				"imported": res.Imported,
+				"updated":  res.Updated,
+				"deleted":  res.Deleted,
+				"skipped":  res.Skipped,
+			},
+		})
+	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(result)
+	json.NewEncoder(w).Encode(res)
 }
 
 func generateApiKey() (string, error) {
@@ -928,6 +1211,29 @@ func generateApiKey() (string, error) {
 	return "sk-" + string(b), nil
 }
 
+// normalizeAllowedIPs validates allowed_ips entries, which are single
+// addresses or CIDR prefixes, and drops blanks.
+func normalizeAllowedIPs(entries []string) ([]string, error) {
+	var out []string
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if strings.Contains(entry, "/") {
+			prefix, err := netip.ParsePrefix(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid allowed_ips entry %q", entry)
+			}
+			entry = prefix.Masked().String()
+		} else if _, err := netip.ParseAddr(entry); err != nil {
+			return nil, fmt.Errorf("invalid allowed_ips entry %q", entry)
+		}
+		out = append(out, entry)
+	}
+	return out, nil
+}
+
 func (a *API) HandleKeys(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
@@ -938,11 +1244,24 @@ func (a *API) HandleKeys(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		json.NewEncoder(w).Encode(keys)
+		writeList(w, r, keys)
 
 	case http.MethodPost:
 		var req struct {
-			Name string `json:"name"`
+			Name              string              `json:"name"`
+			SystemPrompt      string              `json:"system_prompt"`
+			AllowPinning      bool                `json:"allow_pinning"`
+			TPMLimit          int                 `json:"tpm_limit"`
+			ToolGateMaxChars  int                 `json:"tool_gate_max_chars"`
+			SkipPromptHygiene bool                `json:"skip_prompt_hygiene"`
+			StrictModels      bool                `json:"strict_models"`
+			Description       string              `json:"description"`
+			Owner             string              `json:"owner"`
+			MonthlyTokenQuota int                 `json:"monthly_token_quota"`
+			ExpiresAt         *time.Time          `json:"expires_at"`
+			AllowedIPs        []string            `json:"allowed_ips"`
+			TenantID          int64               `json:"tenant_id"`
+			LogResidency      *store.LogResidency `json:"log_residency"`
 		}
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
@@ -953,6 +1272,26 @@ func (a *API) HandleKeys(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "name is required", http.StatusBadRequest)
 			return
 		}
+		if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+			http.Error(w, "expires_at must be in the future", http.StatusBadRequest)
+			return
+		}
+		allowedIPs, err := normalizeAllowedIPs(req.AllowedIPs)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := a.tenantExists(r, req.TenantID); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if status, err := normalizeLogResidency(r.Context(), req.LogResidency); err != nil {
+			http.Error(w, err.Error(), status)
+			return
+		}
+		if req.LogResidency.IsZero() {
+			req.LogResidency = nil
+		}
 
 		fullKey, err := generateApiKey()
 		if err != nil {
@@ -964,12 +1303,25 @@ func (a *API) HandleKeys(w http.ResponseWriter, r *http.Request) {
 		hash := sha256.Sum256([]byte(fullKey))
 		hashStr := hex.EncodeToString(hash[:])
 		key := store.ApiKey{
-			Name:      req.Name,
-			KeyHash:   hashStr,
-			KeyFull:   fullKey,
-			KeyPrefix: "sk-",
-			KeySuffix: fullKey[len(fullKey)-4:],
-			Enabled:   true,
+			Name:              req.Name,
+			KeyHash:           hashStr,
+			KeyFull:           fullKey,
+			KeyPrefix:         "sk-",
+			KeySuffix:         fullKey[len(fullKey)-4:],
+			Enabled:           true,
+			SystemPrompt:      strings.TrimSpace(req.SystemPrompt),
+			AllowPinning:      req.AllowPinning,
+			TPMLimit:          max(req.TPMLimit, 0),
+			ToolGateMaxChars:  req.ToolGateMaxChars,
+			SkipPromptHygiene: req.SkipPromptHygiene,
+			StrictModels:      req.StrictModels,
+			Description:       strings.TrimSpace(req.Description),
+			Owner:             strings.TrimSpace(req.Owner),
+			MonthlyTokenQuota: max(req.MonthlyTokenQuota, 0),
+			ExpiresAt:         req.ExpiresAt,
+			AllowedIPs:        allowedIPs,
+			TenantID:          req.TenantID,
+			LogResidency:      req.LogResidency,
 		}
 		if err := a.store.CreateApiKey(r.Context(), &key); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -978,13 +1330,26 @@ func (a *API) HandleKeys(w http.ResponseWriter, r *http.Request) {
 
 		w.WriteHeader(http.StatusCreated)
 		json.NewEncoder(w).Encode(CreateKeyResponse{
-			ID:        key.ID,
-			Key:       fullKey,
-			Name:      key.Name,
-			KeyPrefix: key.KeyPrefix,
-			KeySuffix: key.KeySuffix,
-			Enabled:   key.Enabled,
-			CreatedAt: key.CreatedAt,
+			ID:                key.ID,
+			Key:               fullKey,
+			Name:              key.Name,
+			KeyPrefix:         key.KeyPrefix,
+			KeySuffix:         key.KeySuffix,
+			Enabled:           key.Enabled,
+			SystemPrompt:      key.SystemPrompt,
+			AllowPinning:      key.AllowPinning,
+			TPMLimit:          key.TPMLimit,
+			ToolGateMaxChars:  key.ToolGateMaxChars,
+			SkipPromptHygiene: key.SkipPromptHygiene,
+			StrictModels:      key.StrictModels,
+			Description:       key.Description,
+			Owner:             key.Owner,
+			MonthlyTokenQuota: key.MonthlyTokenQuota,
+			ExpiresAt:         key.ExpiresAt,
+			AllowedIPs:        key.AllowedIPs,
+			TenantID:          key.TenantID,
+			LogResidency:      key.LogResidency,
+			CreatedAt:         key.CreatedAt,
 		})
 
 	default:
@@ -995,12 +1360,21 @@ func (a *API) HandleKeys(w http.ResponseWriter, r *http.Request) {
 func (a *API) HandleKeyByID(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	idStr := strings.TrimPrefix(r.URL.Path, "/api/keys/")
+	idStr, sub, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/keys/"), "/")
 	id, err := strconv.ParseInt(idStr, 10, 64)
 	if err != nil {
 		http.Error(w, "Invalid ID", http.StatusBadRequest)
 		return
 	}
+	switch sub {
+	case "":
+	case "stats":
+		a.handleKeyStats(w, r, id)
+		return
+	default:
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
 
 	switch r.Method {
 	case http.MethodPatch:
@@ -1009,12 +1383,42 @@ func (a *API) HandleKeyByID(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		if req.Enabled == nil {
-			http.Error(w, "enabled is required", http.StatusBadRequest)
+		if req.Enabled == nil && req.SystemPrompt == nil && req.AllowPinning == nil && req.TPMLimit == nil && req.ToolGateMaxChars == nil && req.SkipPromptHygiene == nil && req.StrictModels == nil && req.LogResidency == nil {
+			http.Error(w, "enabled, system_prompt, allow_pinning, tpm_limit, tool_gate_max_chars, skip_prompt_hygiene, strict_models or log_residency is required", http.StatusBadRequest)
+			return
+		}
+		if status, err := normalizeLogResidency(r.Context(), req.LogResidency); err != nil {
+			http.Error(w, err.Error(), status)
 			return
 		}
 
-		if err := a.store.UpdateApiKeyEnabled(r.Context(), id, *req.Enabled); err != nil {
+		err = a.store.UpdateApiKey(r.Context(), id, func(key *store.ApiKey) {
+			if req.Enabled != nil {
+				key.Enabled = *req.Enabled
+			}
+			if req.SystemPrompt != nil {
+				key.SystemPrompt = strings.TrimSpace(*req.SystemPrompt)
+			}
+			if req.AllowPinning != nil {
+				key.AllowPinning = *req.AllowPinning
+			}
+			if req.TPMLimit != nil {
+				key.TPMLimit = max(*req.TPMLimit, 0)
+			}
+			if req.ToolGateMaxChars != nil {
+				key.ToolGateMaxChars = *req.ToolGateMaxChars
+			}
+			if req.SkipPromptHygiene != nil {
+				key.SkipPromptHygiene = *req.SkipPromptHygiene
+			}
+			if req.StrictModels != nil {
+				key.StrictModels = *req.StrictModels
+			}
+			if req.LogResidency != nil {
+				key.LogResidency = req.LogResidency
+			}
+		})
+		if err != nil {
 			if errors.Is(err, store.ErrNoRows) {
 				http.Error(w, "not found", http.StatusNotFound)
 				return
@@ -1060,7 +1464,7 @@ func (a *API) HandleModels(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		json.NewEncoder(w).Encode(models)
+		writeList(w, r, models)
 
 	case http.MethodPost:
 		var m store.Model
@@ -1157,11 +1561,30 @@ func (a *API) HandleCacheStats(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	json.NewEncoder(w).Encode(map[string]interface{}{
+	out := map[string]interface{}{
 		"count":      count,
 		"size_bytes": size,
 		"status":     "enabled",
-	})
+		"backend":    tokenCacheBackend(a.tokenCache),
+	}
+	if counter, ok := a.tokenCache.(tokencache.HitCounter); ok {
+		hits, misses := counter.HitStats()
+		out["hits"] = hits
+		out["misses"] = misses
+		out["hit_rate"] = tokencache.HitRate(hits, misses)
+	}
+	json.NewEncoder(w).Encode(out)
+}
+
+func tokenCacheBackend(c tokencache.Cache) string {
+	switch c.(type) {
+	case *tokencache.RedisCache:
+		return "redis"
+	case *tokencache.MemoryCache:
+		return "memory"
+	default:
+		return "custom"
+	}
 }
 
 func (a *API) HandleCacheClear(w http.ResponseWriter, r *http.Request) {
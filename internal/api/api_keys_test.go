@@ -0,0 +1,59 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+func TestCreateKeyWithMetadata(t *testing.T) {
+	a := newTransferAPI(t)
+	expires := time.Now().Add(24 * time.Hour).UTC().Truncate(time.Second)
+	body := `{"name":"ci","description":" nightly jobs ","owner":"platform","monthly_token_quota":5000000,` +
+		`"expires_at":"` + expires.Format(time.RFC3339) + `","allowed_ips":["10.0.0.7/8"," 192.168.1.20 ",""]}`
+
+	rec := httptest.NewRecorder()
+	a.HandleKeys(rec, httptest.NewRequest(http.MethodPost, "/api/keys", strings.NewReader(body)))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status=%d body=%s", rec.Code, rec.Body.String())
+	}
+	var created CreateKeyResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &created); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if created.Description != "nightly jobs" || created.Owner != "platform" || created.MonthlyTokenQuota != 5000000 {
+		t.Fatalf("unexpected metadata: %+v", created)
+	}
+	if got := strings.Join(created.AllowedIPs, ","); got != "10.0.0.0/8,192.168.1.20" {
+		t.Fatalf("allowed_ips=%s", got)
+	}
+
+	keys, err := a.store.ListApiKeys(context.Background())
+	if err != nil || len(keys) != 1 {
+		t.Fatalf("ListApiKeys: %v %d", err, len(keys))
+	}
+	if keys[0].ExpiresAt == nil || !keys[0].ExpiresAt.Equal(expires) || len(keys[0].AllowedIPs) != 2 || keys[0].Owner != "platform" {
+		t.Fatalf("metadata not stored: %+v", keys[0])
+	}
+}
+
+func TestCreateKeyRejectsBadMetadata(t *testing.T) {
+	a := newTransferAPI(t)
+	past := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	for _, body := range []string{
+		`{"name":"k","allowed_ips":["10.0.0.300"]}`,
+		`{"name":"k","allowed_ips":["10.0.0.0/40"]}`,
+		`{"name":"k","expires_at":"` + past + `"}`,
+	} {
+		rec := httptest.NewRecorder()
+		a.HandleKeys(rec, httptest.NewRequest(http.MethodPost, "/api/keys", strings.NewReader(body)))
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("%s: status=%d want 400", body, rec.Code)
+		}
+	}
+}
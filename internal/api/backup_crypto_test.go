@@ -0,0 +1,78 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"orchids-api/internal/store"
+)
+
+func TestBackupEncryptRoundTrip(t *testing.T) {
+	plain := []byte(`{"version":2,"accounts":[{"name":"a","client_cookie":"secret-cookie"}]}`)
+	sealed, err := encryptBackup(plain, "correct horse")
+	if err != nil {
+		t.Fatalf("encryptBackup: %v", err)
+	}
+	if bytes.Contains(sealed, []byte("secret-cookie")) {
+		t.Fatal("ciphertext leaks plaintext")
+	}
+
+	got, err := decodeBackup(sealed, "correct horse")
+	if err != nil || !bytes.Equal(got, plain) {
+		t.Fatalf("decodeBackup: %v %s", err, got)
+	}
+	if _, err := decodeBackup(sealed, "wrong horse"); !errors.Is(err, errBackupDecrypt) {
+		t.Fatalf("wrong passphrase err=%v", err)
+	}
+	if _, err := decodeBackup(sealed, ""); !errors.Is(err, errBackupPassphraseRequired) {
+		t.Fatalf("missing passphrase err=%v", err)
+	}
+	if got, err := decodeBackup(plain, ""); err != nil || !bytes.Equal(got, plain) {
+		t.Fatalf("plain export should pass through: %v", err)
+	}
+	if _, err := encryptBackup(plain, "short"); err == nil {
+		t.Fatal("short passphrase should be rejected")
+	}
+}
+
+func TestEncryptedExportImport(t *testing.T) {
+	ctx := context.Background()
+	src, dst := newTransferAPI(t), newTransferAPI(t)
+	if err := src.store.CreateAccount(ctx, &store.Account{Name: "router", AccountType: "openai-compatible", APIKey: "sk-upstream-secret", Enabled: true}); err != nil {
+		t.Fatalf("CreateAccount: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/export", nil)
+	req.Header.Set(backupPassphraseHeader, "backup-pass")
+	rec := httptest.NewRecorder()
+	src.HandleExport(rec, req)
+	if rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), "sk-upstream-secret") {
+		t.Fatalf("expected encrypted export, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if cd := rec.Header().Get("Content-Disposition"); !strings.Contains(cd, ".enc.json") {
+		t.Fatalf("Content-Disposition=%q", cd)
+	}
+	sealed := rec.Body.Bytes()
+
+	rec = httptest.NewRecorder()
+	dst.HandleImport(rec, httptest.NewRequest(http.MethodPost, "/api/import", bytes.NewReader(sealed)))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("import without passphrase: status=%d", rec.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/api/import", bytes.NewReader(sealed))
+	req.Header.Set(backupPassphraseHeader, "backup-pass")
+	rec = httptest.NewRecorder()
+	dst.HandleImport(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("import: status=%d body=%s", rec.Code, rec.Body.String())
+	}
+	if accounts, _ := dst.store.ListAccounts(ctx); len(accounts) != 1 || accounts[0].APIKey != "sk-upstream-secret" {
+		t.Fatalf("encrypted import did not restore the account: %+v", accounts)
+	}
+}
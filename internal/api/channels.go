@@ -0,0 +1,154 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/goccy/go-json"
+
+	"orchids-api/internal/store"
+)
+
+// channelView is a channel as listed by /api/channels. Built-in channels
+// without a record are listed with their defaults.
+type channelView struct {
+	*store.Channel
+	Builtin bool `json:"builtin"`
+}
+
+// validateChannel checks a channel before it is saved.
+func validateChannel(ch *store.Channel) error {
+	ch.Name = store.NormalizeChannelName(ch.Name)
+	ch.Type = store.NormalizeChannelName(ch.Type)
+	ch.DefaultModel = strings.TrimSpace(ch.DefaultModel)
+	baseURL, err := normalizeBaseURL(ch.BaseURL, ch.Name)
+	if err != nil {
+		return err
+	}
+	ch.BaseURL = baseURL
+	switch {
+	case ch.Name == "":
+		return errors.New("channel name is required")
+	case strings.ContainsAny(ch.Name, "/ "):
+		return fmt.Errorf("invalid channel name %q", ch.Name)
+	case store.IsBuiltinChannel(ch.Name) && ch.Type != "":
+		return fmt.Errorf("built-in channel %s cannot change its type", ch.Name)
+	case !store.IsBuiltinChannel(ch.Name) && !isAPIKeyAccountType(ch.Type):
+		return errors.New("type must be openai-compatible or anthropic for a new channel")
+	case ch.TokenBudget < 0:
+		return errors.New("token_budget must not be negative")
+	case ch.Retry != nil && (ch.Retry.MaxRetries < 0 || ch.Retry.DelayMs < 0):
+		return errors.New("retry values must not be negative")
+	}
+	return nil
+}
+
+// HandleChannels lists channels (GET) or creates one (POST).
+func (a *API) HandleChannels(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodGet:
+		channels, err := a.store.ListChannels(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		views := make([]channelView, 0, len(channels)+len(store.BuiltinChannels))
+		seen := make(map[string]bool, len(channels))
+		for _, ch := range channels {
+			seen[ch.Name] = true
+			views = append(views, channelView{Channel: ch, Builtin: store.IsBuiltinChannel(ch.Name)})
+		}
+		for _, name := range store.BuiltinChannels {
+			if !seen[name] {
+				views = append(views, channelView{Channel: &store.Channel{Name: name, Enabled: true}, Builtin: true})
+			}
+		}
+		sort.Slice(views, func(i, j int) bool { return views[i].Name < views[j].Name })
+		writeList(w, r, views)
+
+	case http.MethodPost:
+		ch := store.Channel{Enabled: true}
+		if err := json.NewDecoder(r.Body).Decode(&ch); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := validateChannel(&ch); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if _, err := a.store.GetChannel(r.Context(), ch.Name); err == nil {
+			http.Error(w, "channel already exists: "+ch.Name, http.StatusConflict)
+			return
+		}
+		if err := a.store.SaveChannel(r.Context(), &ch); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(&ch)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleChannelByName reads, replaces (PUT) or deletes a channel record.
+// Deleting a built-in channel's record restores its defaults.
+func (a *API) HandleChannelByName(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	name := store.NormalizeChannelName(strings.TrimPrefix(r.URL.Path, "/api/channels/"))
+	if name == "" {
+		http.Error(w, "channel name is required", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		ch, err := a.store.GetChannel(r.Context(), name)
+		if errors.Is(err, store.ErrNoRows) && store.IsBuiltinChannel(name) {
+			ch, err = &store.Channel{Name: name, Enabled: true}, nil
+		}
+		if err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, store.ErrNoRows) {
+				status = http.StatusNotFound
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		json.NewEncoder(w).Encode(channelView{Channel: ch, Builtin: store.IsBuiltinChannel(name)})
+
+	case http.MethodPut:
+		ch := store.Channel{Enabled: true}
+		if err := json.NewDecoder(r.Body).Decode(&ch); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ch.Name = name
+		if err := validateChannel(&ch); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := a.store.SaveChannel(r.Context(), &ch); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(&ch)
+
+	case http.MethodDelete:
+		if err := a.store.DeleteChannel(r.Context(), name); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,282 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-json"
+
+	"orchids-api/internal/auth"
+	"orchids-api/internal/config"
+	"orchids-api/internal/middleware"
+)
+
+const (
+	// configHistorySettingKey holds the config versions saved through
+	// /api/config, /api/import and /api/config/rollback.
+	configHistorySettingKey = "config_history"
+	// configHistoryLimit is how many versions are kept.
+	configHistoryLimit = 50
+)
+
+// configSecretFields are masked in the diffs of /api/config/history.
+var configSecretFields = []string{
+	"admin_pass",
+	"admin_token",
+	"redis_password",
+	"proxy_pass",
+	"served_by_salt",
+	"web_search_api_key",
+	"embeddings_api_key",
+}
+
+// configChange is one changed config field; secrets read "***".
+type configChange struct {
+	Field string          `json:"field"`
+	Old   json.RawMessage `json:"old,omitempty"`
+	New   json.RawMessage `json:"new,omitempty"`
+}
+
+// configVersion is a saved config and how it differs from the one before.
+// Version 1 is the config that was running before the first recorded change.
+type configVersion struct {
+	Version    int             `json:"version"`
+	Time       time.Time       `json:"time"`
+	Source     string          `json:"source"` // baseline, api, import or rollback
+	Actor      string          `json:"actor,omitempty"`
+	ClientIP   string          `json:"client_ip,omitempty"`
+	UserAgent  string          `json:"user_agent,omitempty"`
+	RollbackOf int             `json:"rollback_of,omitempty"`
+	Changes    []configChange  `json:"changes"`
+	Config     json.RawMessage `json:"config,omitempty"`
+}
+
+// configRollbackResult is the response of POST /api/config/rollback/{version}.
+// Version is 0 when the running config already matched.
+type configRollbackResult struct {
+	RolledBackTo int            `json:"rolled_back_to"`
+	Version      int            `json:"version"`
+	Changes      []configChange `json:"changes"`
+}
+
+// configEditor describes who is changing the config. Admin requests carry no
+// user name, so a session counts as the admin user and anything else as the
+// admin token.
+func (a *API) configEditor(r *http.Request, source string) configVersion {
+	actor := "admin_token"
+	if cookie, err := r.Cookie("session_token"); err == nil {
+		if tenantID, ok := auth.SessionTenant(cookie.Value); ok && tenantID == 0 {
+			actor = a.adminUser
+		}
+	}
+	return configVersion{
+		Source:    source,
+		Actor:     actor,
+		ClientIP:  middleware.ExtractIP(r.RemoteAddr, r.Header.Get("X-Forwarded-For"), r.Header.Get("X-Real-IP")),
+		UserAgent: r.UserAgent(),
+	}
+}
+
+// saveConfig makes next the running config, persists it and records it in
+// the config history. It returns the recorded version, or nil when next
+// doesn't differ from prev. A history that can't be written is logged but
+// doesn't fail the save.
+func (a *API) saveConfig(ctx context.Context, prev, next *config.Config, editor configVersion) (*configVersion, error) {
+	data, err := json.Marshal(next)
+	if err != nil {
+		return nil, err
+	}
+	a.config.Store(next)
+	if err := a.store.SetSetting(ctx, configSettingKey, string(data)); err != nil {
+		return nil, err
+	}
+	changes := configDiff(prev, next)
+	if len(changes) == 0 {
+		return nil, nil
+	}
+	version, err := a.recordConfigVersion(ctx, prev, data, changes, editor)
+	if err != nil {
+		slog.Warn("Failed to record config history", "error", err)
+		return nil, nil
+	}
+	return version, nil
+}
+
+func (a *API) recordConfigVersion(ctx context.Context, prev *config.Config, data []byte, changes []configChange, editor configVersion) (*configVersion, error) {
+	a.configHistoryMu.Lock()
+	defer a.configHistoryMu.Unlock()
+
+	history, err := a.loadConfigHistory(ctx)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	if len(history) == 0 {
+		baseline, err := json.Marshal(prev)
+		if err != nil {
+			return nil, err
+		}
+		history = append(history, configVersion{Version: 1, Time: now, Source: "baseline", Changes: []configChange{}, Config: baseline})
+	}
+	entry := editor
+	entry.Version = history[len(history)-1].Version + 1
+	entry.Time = now
+	entry.Changes = changes
+	entry.Config = data
+	history = append(history, entry)
+	if len(history) > configHistoryLimit {
+		history = history[len(history)-configHistoryLimit:]
+	}
+	raw, err := json.Marshal(history)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.store.SetSetting(ctx, configHistorySettingKey, string(raw)); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// loadConfigHistory returns the saved versions, oldest first.
+func (a *API) loadConfigHistory(ctx context.Context) ([]configVersion, error) {
+	raw, err := a.store.GetSetting(ctx, configHistorySettingKey)
+	if err != nil || raw == "" {
+		return nil, err
+	}
+	var history []configVersion
+	if err := json.Unmarshal([]byte(raw), &history); err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+// configDiff lists the fields that differ between old and new.
+func configDiff(old, new *config.Config) []configChange {
+	fields := changedFields(old, new)
+	if len(fields) == 0 {
+		return nil
+	}
+	oldFields, _ := jsonFields(old)
+	newFields, _ := jsonFields(new)
+	changes := make([]configChange, 0, len(fields))
+	for _, name := range fields {
+		change := configChange{Field: name, Old: oldFields[name], New: newFields[name]}
+		if slices.Contains(configSecretFields, name) {
+			change.Old, change.New = json.RawMessage(`"***"`), json.RawMessage(`"***"`)
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+// HandleConfigHistory serves GET /api/config/history, newest version first
+// and without the config snapshots, and GET /api/config/history/{version}
+// with the snapshot.
+func (a *API) HandleConfigHistory(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	history, err := a.loadConfigHistory(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if raw := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/config/history"), "/"); raw != "" {
+		entry, err := findConfigVersion(history, raw)
+		if err != nil {
+			http.Error(w, err.Error(), configVersionStatus(err))
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(entry)
+		return
+	}
+
+	out := make([]configVersion, 0, len(history))
+	for i := len(history) - 1; i >= 0; i-- {
+		entry := history[i]
+		entry.Config = nil
+		out = append(out, entry)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"versions": out})
+}
+
+// HandleConfigRollback serves POST /api/config/rollback/{version}: it makes
+// the saved config of that version the running one and records the rollback
+// as a new version.
+func (a *API) HandleConfigRollback(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ctx := r.Context()
+	history, err := a.loadConfigHistory(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	target, err := findConfigVersion(history, strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/config/rollback"), "/"))
+	if err != nil {
+		http.Error(w, err.Error(), configVersionStatus(err))
+		return
+	}
+
+	// Decode into a fresh Config: merging into a copy of the current one
+	// would share its maps and keep entries the snapshot does not have.
+	current := a.config.Load()
+	var next config.Config
+	if err := json.Unmarshal(target.Config, &next); err != nil {
+		http.Error(w, "Failed to decode saved config: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	config.ApplyHardcoded(&next)
+
+	editor := a.configEditor(r, "rollback")
+	editor.RollbackOf = target.Version
+	version, err := a.saveConfig(ctx, current, &next, editor)
+	if err != nil {
+		http.Error(w, "Failed to save config to Redis: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	slog.Info("Config rolled back", "version", target.Version, "actor", editor.Actor, "client_ip", editor.ClientIP)
+	out := configRollbackResult{RolledBackTo: target.Version, Changes: []configChange{}}
+	if version != nil {
+		out.Version, out.Changes = version.Version, version.Changes
+	}
+	json.NewEncoder(w).Encode(out)
+}
+
+var (
+	errInvalidConfigVersion = errors.New("invalid config version")
+	errConfigVersionMissing = errors.New("config version not found")
+)
+
+func findConfigVersion(history []configVersion, raw string) (*configVersion, error) {
+	version, err := strconv.Atoi(raw)
+	if err != nil || version <= 0 {
+		return nil, errInvalidConfigVersion
+	}
+	for i := range history {
+		if history[i].Version == version {
+			return &history[i], nil
+		}
+	}
+	return nil, errConfigVersionMissing
+}
+
+func configVersionStatus(err error) int {
+	if errors.Is(err, errConfigVersionMissing) {
+		return http.StatusNotFound
+	}
+	return http.StatusBadRequest
+}
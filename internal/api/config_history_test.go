@@ -0,0 +1,106 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/goccy/go-json"
+)
+
+func TestConfigHistoryAndRollback(t *testing.T) {
+	a := newTransferAPI(t)
+	post := func(body string) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		a.HandleConfig(rec, httptest.NewRequest(http.MethodPost, "/api/config", strings.NewReader(body)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("POST /api/config: %d %s", rec.Code, rec.Body.String())
+		}
+	}
+	history := func() []configVersion {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		a.HandleConfigHistory(rec, httptest.NewRequest(http.MethodGet, "/api/config/history", nil))
+		var out struct {
+			Versions []configVersion `json:"versions"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+			t.Fatalf("history: %v %s", err, rec.Body.String())
+		}
+		return out.Versions
+	}
+
+	original := a.config.Load().AccountTPMLimit
+	post(`{"account_tpm_limit":1234,"proxy_pass":"hunter2"}`)
+	post(`{"account_tpm_limit":1234}`) // unchanged, not recorded
+	post(`{"account_tpm_limit":999}`)
+
+	versions := history()
+	if len(versions) != 3 || versions[0].Version != 3 || versions[2].Source != "baseline" {
+		t.Fatalf("versions = %+v", versions)
+	}
+	second := versions[1]
+	if second.Source != "api" || second.Actor != "admin_token" || second.Config != nil || len(second.Changes) != 2 {
+		t.Fatalf("version 2 = %+v", second)
+	}
+	if second.Changes[0].Field != "account_tpm_limit" || string(second.Changes[0].New) != "1234" {
+		t.Fatalf("changes = %+v", second.Changes)
+	}
+	if second.Changes[1].Field != "proxy_pass" || strings.Contains(string(second.Changes[1].New), "hunter2") {
+		t.Fatalf("secret not masked: %+v", second.Changes[1])
+	}
+
+	rec := httptest.NewRecorder()
+	a.HandleConfigHistory(rec, httptest.NewRequest(http.MethodGet, "/api/config/history/1", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"config":{`) {
+		t.Fatalf("GET version 1: %d %s", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	a.HandleConfigRollback(rec, httptest.NewRequest(http.MethodPost, "/api/config/rollback/1", nil))
+	var result configRollbackResult
+	if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &result) != nil {
+		t.Fatalf("rollback: %d %s", rec.Code, rec.Body.String())
+	}
+	if result.RolledBackTo != 1 || result.Version != 4 || len(result.Changes) != 2 {
+		t.Fatalf("rollback result = %+v", result)
+	}
+	if cfg := a.config.Load(); cfg.AccountTPMLimit != original || cfg.ProxyPass != "" {
+		t.Fatalf("config after rollback: account_tpm_limit=%d proxy_pass=%q", cfg.AccountTPMLimit, cfg.ProxyPass)
+	}
+	saved, _ := a.store.GetSetting(t.Context(), configSettingKey)
+	if strings.Contains(saved, "hunter2") {
+		t.Fatal("rollback not persisted")
+	}
+	if latest := history()[0]; latest.Source != "rollback" || latest.RollbackOf != 1 {
+		t.Fatalf("latest = %+v", latest)
+	}
+
+	for path, want := range map[string]int{"/api/config/rollback/99": http.StatusNotFound, "/api/config/rollback/x": http.StatusBadRequest} {
+		rec = httptest.NewRecorder()
+		a.HandleConfigRollback(rec, httptest.NewRequest(http.MethodPost, path, nil))
+		if rec.Code != want {
+			t.Fatalf("%s: %d, want %d", path, rec.Code, want)
+		}
+	}
+	// Map entries added after the target version do not survive a rollback,
+	// and the live config's map is not modified in place.
+	post(`{"model_max_concurrency":{"claude-sonnet-4-5":2}}`)
+	before := a.config.Load()
+	rec = httptest.NewRecorder()
+	a.HandleConfigRollback(rec, httptest.NewRequest(http.MethodPost, "/api/config/rollback/1", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("rollback: %d %s", rec.Code, rec.Body.String())
+	}
+	if cfg := a.config.Load(); len(cfg.ModelMaxConcurrency) != 0 || before.ModelMaxConcurrency["claude-sonnet-4-5"] != 2 {
+		t.Fatalf("model_max_concurrency after rollback = %v, before = %v", cfg.ModelMaxConcurrency, before.ModelMaxConcurrency)
+	}
+
+	rec = httptest.NewRecorder()
+	a.HandleSettingByKey(rec, httptest.NewRequest(http.MethodPut, "/api/settings/"+configHistorySettingKey, strings.NewReader(`{"value":"[]"}`)))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("PUT config_history should be rejected, got %d", rec.Code)
+	}
+}
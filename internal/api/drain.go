@@ -0,0 +1,68 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/goccy/go-json"
+
+	"orchids-api/internal/store"
+)
+
+// AccountRequests reports and cancels the in-flight messages requests served
+// by an account on this instance. The messages handler implements it.
+type AccountRequests interface {
+	AccountRequestCount(accountID int64) int
+	CancelAccountRequests(accountID int64) int
+}
+
+// SetAccountRequests wires the in-flight request registry used by account drain.
+func (a *API) SetAccountRequests(r AccountRequests) {
+	a.accountRequests = r
+}
+
+type drainStatus struct {
+	AccountID int64 `json:"account_id"`
+	Draining  bool  `json:"draining"`
+	InFlight  int   `json:"in_flight"`
+	Cancelled int   `json:"cancelled,omitempty"`
+}
+
+// handleAccountDrain serves /api/accounts/{id}/drain. POST takes the account
+// out of rotation (?cancel=true also cancels its in-flight requests), GET
+// reports progress and DELETE puts the account back. A drained account keeps
+// serving requests already in flight, so callers poll GET until in_flight
+// reaches 0 before deleting or re-authenticating it.
+func (a *API) handleAccountDrain(w http.ResponseWriter, r *http.Request, id int64) {
+	acc, err := a.store.GetAccount(r.Context(), id)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, store.ErrNoRows) {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	res := drainStatus{AccountID: id, Draining: acc.Draining}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost, http.MethodDelete:
+		res.Draining = r.Method == http.MethodPost
+		if err := a.store.SetAccountDraining(r.Context(), id, res.Draining); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if cancel, _ := strconv.ParseBool(r.URL.Query().Get("cancel")); cancel && res.Draining && a.accountRequests != nil {
+			res.Cancelled = a.accountRequests.CancelAccountRequests(id)
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if a.accountRequests != nil {
+		res.InFlight = a.accountRequests.AccountRequestCount(id)
+	}
+	json.NewEncoder(w).Encode(res)
+}
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/goccy/go-json"
+
+	"orchids-api/internal/store"
+)
+
+type fakeAccountRequests struct {
+	inFlight  map[int64]int
+	cancelled []int64
+}
+
+func (f *fakeAccountRequests) AccountRequestCount(id int64) int { return f.inFlight[id] }
+
+func (f *fakeAccountRequests) CancelAccountRequests(id int64) int {
+	f.cancelled = append(f.cancelled, id)
+	n := f.inFlight[id]
+	f.inFlight[id] = 0
+	return n
+}
+
+func TestAccountDrain(t *testing.T) {
+	a := newTransferAPI(t)
+	reqs := &fakeAccountRequests{inFlight: map[int64]int{}}
+	a.SetAccountRequests(reqs)
+	ctx := context.Background()
+	acc := &store.Account{Name: "drain-me", AccountType: "warp", RefreshToken: "rt", Enabled: true}
+	if err := a.store.CreateAccount(ctx, acc); err != nil {
+		t.Fatalf("CreateAccount: %v", err)
+	}
+	reqs.inFlight[acc.ID] = 2
+	path := "/api/accounts/" + strconv.FormatInt(acc.ID, 10)
+
+	drain := func(method, query string) drainStatus {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		a.HandleAccountByID(rec, httptest.NewRequest(method, path+"/drain"+query, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s drain: %d %s", method, rec.Code, rec.Body.String())
+		}
+		var res drainStatus
+		if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		return res
+	}
+
+	if res := drain(http.MethodPost, ""); !res.Draining || res.InFlight != 2 || res.Cancelled != 0 || len(reqs.cancelled) != 0 {
+		t.Fatalf("drain without cancel: %+v cancelled=%v", res, reqs.cancelled)
+	}
+
+	// A regular account update must not undo the drain.
+	rec := httptest.NewRecorder()
+	a.HandleAccountByID(rec, httptest.NewRequest(http.MethodPut, path, strings.NewReader(`{"name":"renamed","enabled":true}`)))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"draining":true`) {
+		t.Fatalf("PUT: %d %s", rec.Code, rec.Body.String())
+	}
+	if got, _ := a.store.GetAccount(ctx, acc.ID); !got.Draining || got.Name != "renamed" {
+		t.Fatalf("stored account after PUT: %+v", got)
+	}
+
+	if res := drain(http.MethodPost, "?cancel=true"); res.Cancelled != 2 || res.InFlight != 0 {
+		t.Fatalf("drain with cancel: %+v", res)
+	}
+	if res := drain(http.MethodGet, ""); !res.Draining {
+		t.Fatalf("GET drain: %+v", res)
+	}
+	if res := drain(http.MethodDelete, ""); res.Draining {
+		t.Fatalf("undrain: %+v", res)
+	}
+	if got, _ := a.store.GetAccount(ctx, acc.ID); got.Draining {
+		t.Fatal("account still draining after DELETE")
+	}
+
+	rec = httptest.NewRecorder()
+	a.HandleAccountByID(rec, httptest.NewRequest(http.MethodPost, "/api/accounts/999/drain", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("unknown account: %d", rec.Code)
+	}
+}
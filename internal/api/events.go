@@ -0,0 +1,118 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-json"
+
+	"orchids-api/internal/events"
+)
+
+// eventsHeartbeat is how often an idle /api/events stream sends a comment so
+// proxies keep the connection open.
+var eventsHeartbeat = 15 * time.Second
+
+// eventFilter matches the event types a client asked for with ?types=; an
+// entry without a dot matches every type in that group ("account" matches
+// account.disabled and account.recovered).
+type eventFilter []string
+
+func parseEventFilter(raw string) eventFilter {
+	var f eventFilter
+	for _, t := range strings.Split(raw, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			f = append(f, t)
+		}
+	}
+	return f
+}
+
+func (f eventFilter) match(typ string) bool {
+	if len(f) == 0 {
+		return true
+	}
+	for _, t := range f {
+		if typ == t || (!strings.Contains(t, ".") && strings.HasPrefix(typ, t+".")) {
+			return true
+		}
+	}
+	return false
+}
+
+// HandleEvents serves GET /api/events, a server-sent event stream of admin
+// notifications. Clients resuming with Last-Event-ID (or ?last_event_id=)
+// first receive the kept events they missed.
+func (a *API) HandleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("last_event_id")
+	}
+	var since uint64
+	if lastID != "" {
+		id, err := strconv.ParseUint(lastID, 10, 64)
+		if err != nil {
+			http.Error(w, "invalid last event id", http.StatusBadRequest)
+			return
+		}
+		since = id
+	}
+	filter := parseEventFilter(r.URL.Query().Get("types"))
+
+	// The stream outlives server_write_timeout.
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	ch, cancel := a.events.Subscribe(64)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", (3 * time.Second).Milliseconds())
+
+	send := func(e events.Event) {
+		if e.ID <= since {
+			return
+		}
+		since = e.ID
+		if !filter.match(e.Type) {
+			return
+		}
+		data, _ := json.Marshal(e)
+		fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+	}
+	if lastID != "" {
+		for _, e := range a.events.Since(since) {
+			send(e)
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(eventsHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e := <-ch:
+			send(e)
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+		}
+		flusher.Flush()
+	}
+}
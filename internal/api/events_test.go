@@ -0,0 +1,106 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goccy/go-json"
+
+	"orchids-api/internal/events"
+)
+
+// readSSE returns the next event's id and decoded data, skipping comments
+// and the retry hint.
+func readSSE(t *testing.T, sc *bufio.Scanner) events.Event {
+	t.Helper()
+	for sc.Scan() {
+		line := sc.Text()
+		if data, ok := strings.CutPrefix(line, "data: "); ok {
+			var e events.Event
+			if err := json.Unmarshal([]byte(data), &e); err != nil {
+				t.Fatalf("decode %q: %v", data, err)
+			}
+			return e
+		}
+	}
+	t.Fatalf("stream ended: %v", sc.Err())
+	return events.Event{}
+}
+
+func TestHandleEvents(t *testing.T) {
+	bus := events.NewBus(10)
+	a := &API{events: bus}
+	srv := httptest.NewServer(http.HandlerFunc(a.HandleEvents))
+	defer srv.Close()
+
+	bus.Publish(events.Event{Type: events.AccountDisabled, Message: "before"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	open := func(query, lastID string) *bufio.Scanner {
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+query, nil)
+		if lastID != "" {
+			req.Header.Set("Last-Event-ID", lastID)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("GET: %v", err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+			t.Fatalf("Content-Type=%q", ct)
+		}
+		return bufio.NewScanner(resp.Body)
+	}
+
+	// A resuming client gets the events it missed, then live ones.
+	resumed := open("", "0")
+	if e := readSSE(t, resumed); e.ID != 1 || e.Message != "before" {
+		t.Fatalf("replayed event: %+v", e)
+	}
+
+	// A filtered client only sees the requested groups.
+	jobsOnly := open("?types=job", "")
+	// Both streams subscribed before their response headers were sent.
+	bus.Publish(events.Event{Type: events.BreakerOpened, Message: "breaker"})
+	bus.Publish(events.Event{Type: events.JobFailed, Level: events.LevelError, Message: "job"})
+
+	if e := readSSE(t, resumed); e.Type != events.BreakerOpened {
+		t.Fatalf("live event: %+v", e)
+	}
+	if e := readSSE(t, resumed); e.Type != events.JobFailed {
+		t.Fatalf("live event: %+v", e)
+	}
+	if e := readSSE(t, jobsOnly); e.Type != events.JobFailed || e.ID != 3 {
+		t.Fatalf("filtered event: %+v", e)
+	}
+}
+
+func TestHandleEventsBadLastID(t *testing.T) {
+	a := &API{events: events.NewBus(1)}
+	rec := httptest.NewRecorder()
+	a.HandleEvents(rec, httptest.NewRequest(http.MethodGet, "/api/events?last_event_id=abc", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status=%d want 400", rec.Code)
+	}
+}
+
+func TestEventFilter(t *testing.T) {
+	f := parseEventFilter("account, breaker.opened")
+	for typ, want := range map[string]bool{
+		events.AccountDisabled:  true,
+		events.AccountRecovered: true,
+		events.BreakerOpened:    true,
+		events.BreakerClosed:    false,
+		events.JobFailed:        false,
+	} {
+		if f.match(typ) != want {
+			t.Errorf("match(%q)=%v want %v", typ, !want, want)
+		}
+	}
+}
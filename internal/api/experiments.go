@@ -0,0 +1,227 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/goccy/go-json"
+
+	"orchids-api/internal/store"
+)
+
+type experimentRequest struct {
+	Name        *string                    `json:"name"`
+	Description *string                    `json:"description"`
+	Enabled     *bool                      `json:"enabled"`
+	Models      *[]string                  `json:"models"`
+	Split       *int                       `json:"split"`
+	Variants    *[]store.ExperimentVariant `json:"variants"`
+}
+
+// apply copies the set fields of req onto e.
+func (req *experimentRequest) apply(e *store.Experiment) error {
+	if req.Name != nil {
+		e.Name = strings.TrimSpace(*req.Name)
+	}
+	if req.Description != nil {
+		e.Description = strings.TrimSpace(*req.Description)
+	}
+	if req.Enabled != nil {
+		e.Enabled = *req.Enabled
+	}
+	if req.Models != nil {
+		models := make([]string, 0, len(*req.Models))
+		for _, m := range *req.Models {
+			if m = strings.TrimSpace(m); m != "" {
+				models = append(models, m)
+			}
+		}
+		e.Models = models
+	}
+	if req.Split != nil {
+		if *req.Split < 0 || *req.Split > 100 {
+			return errors.New("split must be between 0 and 100")
+		}
+		e.Split = *req.Split
+	}
+	if req.Variants != nil {
+		if len(*req.Variants) != 2 {
+			return errors.New("variants must list exactly two variants")
+		}
+		for i, v := range *req.Variants {
+			e.Variants[i] = store.ExperimentVariant{
+				Name:         strings.TrimSpace(v.Name),
+				SystemPrompt: strings.TrimSpace(v.SystemPrompt),
+				Model:        strings.TrimSpace(v.Model),
+				Channel:      store.NormalizeChannelName(v.Channel),
+			}
+		}
+	}
+	if e.Name == "" {
+		return errors.New("name is required")
+	}
+	return nil
+}
+
+// experimentVariantResult summarizes the collected results of one variant.
+type experimentVariantResult struct {
+	Variant string `json:"variant"`
+	store.ExperimentStats
+	AvgLatencyMs    float64 `json:"avg_latency_ms"`
+	AvgOutputTokens float64 `json:"avg_output_tokens"`
+	ToolCallRate    float64 `json:"tool_call_rate"`
+	ErrorRate       float64 `json:"error_rate"`
+}
+
+type experimentOutput struct {
+	*store.Experiment
+	Results [2]experimentVariantResult `json:"results"`
+}
+
+// experimentOutput attaches the per-variant results to e.
+func (a *API) experimentOutput(r *http.Request, e *store.Experiment) (experimentOutput, error) {
+	stats, err := a.store.GetExperimentStats(r.Context(), e.ID)
+	if err != nil {
+		return experimentOutput{}, err
+	}
+	out := experimentOutput{Experiment: e}
+	for i, s := range stats {
+		res := experimentVariantResult{Variant: e.Variants[i].Name, ExperimentStats: s}
+		if res.Variant == "" {
+			res.Variant = string(rune('A' + i))
+		}
+		if s.Requests > 0 {
+			n := float64(s.Requests)
+			res.AvgLatencyMs = float64(s.LatencyMs) / n
+			res.AvgOutputTokens = float64(s.OutputTokens) / n
+			res.ToolCallRate = float64(s.ToolCallRequests) / n
+			res.ErrorRate = float64(s.Errors) / n
+		}
+		out.Results[i] = res
+	}
+	return out, nil
+}
+
+// HandleExperiments serves GET and POST /api/experiments.
+func (a *API) HandleExperiments(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodGet:
+		experiments, err := a.store.ListExperiments(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		out := make([]experimentOutput, 0, len(experiments))
+		for _, e := range experiments {
+			o, err := a.experimentOutput(r, e)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			out = append(out, o)
+		}
+		writeList(w, r, out)
+
+	case http.MethodPost:
+		var req experimentRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		e := store.Experiment{Enabled: true, Split: 50}
+		if err := req.apply(&e); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := a.store.CreateExperiment(r.Context(), &e); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(e)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleExperimentByID serves GET, PATCH and DELETE /api/experiments/{id}
+// and POST /api/experiments/{id}/reset.
+func (a *API) HandleExperimentByID(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	idStr, sub, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/experiments/"), "/")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	e, err := a.store.GetExperiment(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, store.ErrNoRows) {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	switch sub {
+	case "":
+	case "reset":
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := a.store.ResetExperimentStats(r.Context(), id); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	default:
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		out, err := a.experimentOutput(r, e)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(out)
+
+	case http.MethodPatch:
+		var req experimentRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := req.apply(e); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := a.store.UpdateExperiment(r.Context(), e); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(e)
+
+	case http.MethodDelete:
+		if err := a.store.DeleteExperiment(r.Context(), id); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
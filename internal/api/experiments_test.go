@@ -0,0 +1,86 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/goccy/go-json"
+
+	"orchids-api/internal/store"
+)
+
+func TestExperiments(t *testing.T) {
+	a := newTransferAPI(t)
+	ctx := context.Background()
+
+	do := func(h http.HandlerFunc, method, path, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h(rec, httptest.NewRequest(method, path, bytes.NewReader([]byte(body))))
+		return rec
+	}
+
+	if rec := do(a.HandleExperiments, http.MethodPost, "/api/experiments", `{"name":"x","variants":[{"name":"a"}]}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("one variant: status=%d", rec.Code)
+	}
+	if rec := do(a.HandleExperiments, http.MethodPost, "/api/experiments", `{"name":"x","split":120}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("split out of range: status=%d", rec.Code)
+	}
+
+	rec := do(a.HandleExperiments, http.MethodPost, "/api/experiments", `{"name":"terse","models":["claude-sonnet-4-5"],"variants":[{"name":"control"},{"name":"terse","system_prompt":"Be brief.","channel":"Warp"}]}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create: status=%d body=%s", rec.Code, rec.Body.String())
+	}
+	var e store.Experiment
+	json.Unmarshal(rec.Body.Bytes(), &e)
+	if !e.Enabled || e.Split != 50 || e.Variants[1].Channel != "warp" {
+		t.Fatalf("created experiment=%+v", e)
+	}
+
+	a.store.RecordExperimentResult(ctx, e.ID, 1, store.ExperimentResult{Latency: 300 * time.Millisecond, OutputTokens: 40, ToolCalls: true})
+	a.store.RecordExperimentResult(ctx, e.ID, 1, store.ExperimentResult{Latency: 100 * time.Millisecond, OutputTokens: 20, Error: true})
+
+	path := "/api/experiments/" + strconv.FormatInt(e.ID, 10)
+	rec = do(a.HandleExperimentByID, http.MethodGet, path, "")
+	var out experimentOutput
+	if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+		t.Fatalf("decode: %v body=%s", err, rec.Body.String())
+	}
+	b := out.Results[1]
+	if b.Variant != "terse" || b.Requests != 2 || b.AvgLatencyMs != 200 || b.AvgOutputTokens != 30 || b.ToolCallRate != 0.5 || b.ErrorRate != 0.5 {
+		t.Fatalf("variant B results=%+v", b)
+	}
+	if out.Results[0].Requests != 0 {
+		t.Fatalf("variant A results=%+v", out.Results[0])
+	}
+
+	if rec := do(a.HandleExperimentByID, http.MethodPatch, path, `{"enabled":false,"split":10}`); rec.Code != http.StatusOK {
+		t.Fatalf("patch: status=%d body=%s", rec.Code, rec.Body.String())
+	}
+	got, _ := a.store.GetExperiment(ctx, e.ID)
+	if got.Enabled || got.Split != 10 || got.Variants[1].SystemPrompt != "Be brief." {
+		t.Fatalf("patched experiment=%+v", got)
+	}
+
+	if rec := do(a.HandleExperimentByID, http.MethodPost, path+"/reset", ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("reset: status=%d", rec.Code)
+	}
+	if stats, _ := a.store.GetExperimentStats(ctx, e.ID); stats[1].Requests != 0 {
+		t.Fatalf("stats after reset=%+v", stats)
+	}
+
+	if err := a.store.UpdateExperiment(store.WithTenant(ctx, 1), got); err == nil {
+		t.Fatal("tenant changed an experiment")
+	}
+	if rec := do(a.HandleExperimentByID, http.MethodDelete, path, ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("delete: status=%d", rec.Code)
+	}
+	if rec := do(a.HandleExperimentByID, http.MethodGet, path, ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("get deleted: status=%d", rec.Code)
+	}
+}
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/goccy/go-json"
+
+	"orchids-api/internal/scheduler"
+)
+
+// SetScheduler wires the scheduler whose jobs /api/jobs reports and triggers.
+func (a *API) SetScheduler(s *scheduler.Scheduler) {
+	a.jobs = s
+}
+
+// HandleJobs serves GET /api/jobs.
+func (a *API) HandleJobs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	statuses := []scheduler.Status{}
+	if a.jobs != nil {
+		statuses = a.jobs.Statuses()
+	}
+	json.NewEncoder(w).Encode(statuses)
+}
+
+// HandleJobByName serves GET /api/jobs/{name} and POST /api/jobs/{name}/run.
+// A triggered job runs in the background; poll GET for its result.
+func (a *API) HandleJobByName(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	name, sub, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/jobs/"), "/")
+	if a.jobs == nil || name == "" {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	switch {
+	case sub == "" && r.Method == http.MethodGet:
+	case sub == "run" && r.Method == http.MethodPost:
+		if err := a.jobs.Trigger(name); err != nil {
+			status := http.StatusInternalServerError
+			switch {
+			case errors.Is(err, scheduler.ErrUnknownJob):
+				status = http.StatusNotFound
+			case errors.Is(err, scheduler.ErrJobRunning):
+				status = http.StatusConflict
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	case sub == "" || sub == "run":
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	default:
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	status, ok := a.jobs.Status(name)
+	if !ok {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(status)
+}
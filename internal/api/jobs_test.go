@@ -0,0 +1,45 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"orchids-api/internal/scheduler"
+)
+
+func TestHandleJobByName(t *testing.T) {
+	jobs := scheduler.New()
+	ran := make(chan struct{}, 1)
+	jobs.Add(scheduler.Job{Name: "sync", Interval: time.Hour, Run: func(context.Context) error {
+		ran <- struct{}{}
+		return nil
+	}})
+	a := &API{}
+	a.SetScheduler(jobs)
+
+	do := func(method, path string) int {
+		rec := httptest.NewRecorder()
+		a.HandleJobByName(rec, httptest.NewRequest(method, path, nil))
+		return rec.Code
+	}
+	if code := do(http.MethodPost, "/api/jobs/sync/run"); code != http.StatusAccepted {
+		t.Fatalf("run: status=%d", code)
+	}
+	select {
+	case <-ran:
+	case <-time.After(time.Second):
+		t.Fatal("triggered job did not run")
+	}
+	if code := do(http.MethodPost, "/api/jobs/missing/run"); code != http.StatusNotFound {
+		t.Fatalf("unknown job: status=%d", code)
+	}
+	if code := do(http.MethodGet, "/api/jobs/sync/run"); code != http.StatusMethodNotAllowed {
+		t.Fatalf("GET run: status=%d", code)
+	}
+	if code := do(http.MethodGet, "/api/jobs/sync"); code != http.StatusOK {
+		t.Fatalf("status: status=%d", code)
+	}
+}
@@ -0,0 +1,175 @@
+package api
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-json"
+
+	"orchids-api/internal/audit"
+	"orchids-api/internal/store"
+)
+
+const (
+	defaultKeyStatsWindow = 24 * time.Hour
+	maxKeyStatsWindow     = 30 * 24 * time.Hour
+	// keyStatsMaxEvents bounds one stats scan; it matches the audit stream length.
+	keyStatsMaxEvents = 10000
+	keyStatsMaxModels = 10
+)
+
+// SetAuditLog wires the audit log that per-key statistics are computed from.
+func (a *API) SetAuditLog(l audit.Logger) {
+	a.auditLog = l
+}
+
+type keyModelStats struct {
+	Model      string    `json:"model"`
+	Requests   int       `json:"requests"`
+	LastUsedAt time.Time `json:"last_used_at"`
+}
+
+// keyStats summarises the chat requests of one API key over a window.
+// Truncated is set when the window held more events than one scan reads.
+type keyStats struct {
+	KeyID         int64           `json:"key_id"`
+	Window        string          `json:"window"`
+	Since         time.Time       `json:"since"`
+	Requests      int             `json:"requests"`
+	Errors        int             `json:"errors"`
+	ErrorRate     float64         `json:"error_rate"`
+	InputTokens   int64           `json:"input_tokens"`
+	OutputTokens  int64           `json:"output_tokens"`
+	AvgDurationMs int64           `json:"avg_duration_ms"`
+	LastRequestAt *time.Time      `json:"last_request_at,omitempty"`
+	Models        []keyModelStats `json:"models"`
+	Truncated     bool            `json:"truncated,omitempty"`
+}
+
+// parseStatsWindow accepts Go durations ("90m", "6h") and whole days ("7d").
+func parseStatsWindow(raw string) (time.Duration, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return defaultKeyStatsWindow, nil
+	}
+	var window time.Duration
+	if days, ok := strings.CutSuffix(raw, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, errors.New("invalid window")
+		}
+		window = time.Duration(n) * 24 * time.Hour
+	} else {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return 0, errors.New("invalid window")
+		}
+		window = d
+	}
+	if window < time.Minute || window > maxKeyStatsWindow {
+		return 0, errors.New("window must be between 1m and 30d")
+	}
+	return window, nil
+}
+
+// handleKeyStats serves GET /api/keys/{id}/stats?window=24h from the
+// chat_request events of the audit log.
+func (a *API) handleKeyStats(w http.ResponseWriter, r *http.Request, id int64) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	window, err := parseStatsWindow(r.URL.Query().Get("window"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	key, err := a.store.GetApiKeyByID(r.Context(), id)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, store.ErrNoRows) {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	if a.auditLog == nil {
+		http.Error(w, "key statistics require the Redis audit log", http.StatusServiceUnavailable)
+		return
+	}
+
+	since := time.Now().Add(-window)
+	events, err := a.auditLog.Query(r.Context(), audit.QueryOpts{
+		Start:  since,
+		Action: "chat_request",
+		KeyID:  id,
+		Limit:  keyStatsMaxEvents,
+		Prefix: a.keyAuditPrefix(r.Context(), key),
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	stats := summarizeKeyEvents(events)
+	stats.KeyID = id
+	stats.Window = strings.TrimSpace(r.URL.Query().Get("window"))
+	if stats.Window == "" {
+		stats.Window = "24h"
+	}
+	stats.Since = since
+	stats.Truncated = len(events) >= keyStatsMaxEvents
+	json.NewEncoder(w).Encode(stats)
+}
+
+// summarizeKeyEvents aggregates events, which are newest first.
+func summarizeKeyEvents(events []audit.Event) keyStats {
+	stats := keyStats{Models: []keyModelStats{}}
+	models := make(map[string]int)
+	var totalDuration int64
+	for _, ev := range events {
+		stats.Requests++
+		if ev.Status == "error" {
+			stats.Errors++
+		}
+		stats.InputTokens += metadataInt(ev.Metadata, "input_tokens")
+		stats.OutputTokens += metadataInt(ev.Metadata, "output_tokens")
+		totalDuration += ev.Duration
+		if stats.LastRequestAt == nil {
+			ts := ev.Timestamp
+			stats.LastRequestAt = &ts
+		}
+		if ev.Model == "" {
+			continue
+		}
+		if i, ok := models[ev.Model]; ok {
+			stats.Models[i].Requests++
+			continue
+		}
+		models[ev.Model] = len(stats.Models)
+		stats.Models = append(stats.Models, keyModelStats{Model: ev.Model, Requests: 1, LastUsedAt: ev.Timestamp})
+	}
+	if stats.Requests > 0 {
+		stats.ErrorRate = math.Round(float64(stats.Errors)/float64(stats.Requests)*10000) / 10000
+		stats.AvgDurationMs = totalDuration / int64(stats.Requests)
+	}
+	// First-seen order is already most recent first.
+	stats.Models = stats.Models[:min(len(stats.Models), keyStatsMaxModels)]
+	return stats
+}
+
+func metadataInt(metadata map[string]interface{}, key string) int64 {
+	switch v := metadata[key].(type) {
+	case float64:
+		return int64(v)
+	case int:
+		return int64(v)
+	case int64:
+		return v
+	}
+	return 0
+}
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/goccy/go-json"
+
+	"orchids-api/internal/audit"
+	"orchids-api/internal/store"
+)
+
+// sliceAuditLog serves Query from fixed events, newest first.
+type sliceAuditLog struct {
+	audit.NopLogger
+	events []audit.Event
+	last   audit.QueryOpts
+}
+
+func (l *sliceAuditLog) Query(_ context.Context, opts audit.QueryOpts) ([]audit.Event, error) {
+	l.last = opts
+	var out []audit.Event
+	for _, ev := range l.events {
+		if ev.KeyID == opts.KeyID && !ev.Timestamp.Before(opts.Start) {
+			out = append(out, ev)
+		}
+	}
+	return out, nil
+}
+
+func TestHandleKeyStats(t *testing.T) {
+	a := newTransferAPI(t)
+	key := &store.ApiKey{Name: "ci", KeyHash: "h1", Enabled: true}
+	if err := a.store.CreateApiKey(context.Background(), key); err != nil {
+		t.Fatalf("CreateApiKey: %v", err)
+	}
+	now := time.Now()
+	tokens := func(in, out int) map[string]interface{} {
+		return map[string]interface{}{"input_tokens": float64(in), "output_tokens": float64(out)}
+	}
+	log := &sliceAuditLog{events: []audit.Event{
+		{Timestamp: now.Add(-time.Minute), KeyID: key.ID, Model: "claude-opus-4-5", Status: "success", Duration: 300, Metadata: tokens(1000, 200)},
+		{Timestamp: now.Add(-2 * time.Minute), KeyID: key.ID, Model: "claude-sonnet-4-5", Status: "error", Duration: 100, Metadata: tokens(500, 0)},
+		{Timestamp: now.Add(-3 * time.Minute), KeyID: key.ID, Model: "claude-opus-4-5", Status: "success", Duration: 200, Metadata: tokens(800, 100)},
+		{Timestamp: now.Add(-4 * time.Minute), KeyID: key.ID + 1, Model: "claude-opus-4-5", Status: "success"},
+		{Timestamp: now.Add(-2 * time.Hour), KeyID: key.ID, Model: "claude-haiku-4-5", Status: "success"},
+	}}
+	a.SetAuditLog(log)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		a.HandleKeyByID(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	rec := get("/api/keys/1/stats?window=1h")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status=%d body=%s", rec.Code, rec.Body.String())
+	}
+	var stats keyStats
+	if err := json.Unmarshal(rec.Body.Bytes(), &stats); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if log.last.Action != "chat_request" || log.last.KeyID != key.ID {
+		t.Fatalf("unexpected query: %+v", log.last)
+	}
+	if stats.Window != "1h" || stats.Requests != 3 || stats.Errors != 1 || stats.ErrorRate != 0.3333 {
+		t.Fatalf("unexpected counts: %+v", stats)
+	}
+	if stats.InputTokens != 2300 || stats.OutputTokens != 300 || stats.AvgDurationMs != 200 {
+		t.Fatalf("unexpected tokens: %+v", stats)
+	}
+	if len(stats.Models) != 2 || stats.Models[0].Model != "claude-opus-4-5" || stats.Models[0].Requests != 2 || stats.Models[1].Model != "claude-sonnet-4-5" {
+		t.Fatalf("unexpected models: %+v", stats.Models)
+	}
+
+	if rec := get("/api/keys/1/stats?window=7d"); rec.Code != http.StatusOK {
+		t.Fatalf("7d window: status=%d", rec.Code)
+	}
+	for path, want := range map[string]int{
+		"/api/keys/1/stats?window=45d": http.StatusBadRequest,
+		"/api/keys/1/stats?window=abc": http.StatusBadRequest,
+		"/api/keys/99/stats":           http.StatusNotFound,
+		"/api/keys/1/unknown":          http.StatusNotFound,
+	} {
+		if rec := get(path); rec.Code != want {
+			t.Errorf("%s: status=%d want %d", path, rec.Code, want)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package api
+
+import (
+	"cmp"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/goccy/go-json"
+)
+
+// maxListLimit caps the page size of admin list endpoints.
+const maxListLimit = 1000
+
+// listQuery holds the pagination, sorting and field selection parameters
+// accepted by the admin list endpoints:
+//
+//	?limit=50&offset=100&sort=-created_at&fields=id,name,enabled
+//
+// Sort and field names are the JSON field names of the listed objects; a
+// leading "-" sorts descending.
+type listQuery struct {
+	limit  int
+	offset int
+	sort   string
+	desc   bool
+	fields []string
+	// paged is set when limit or offset was given; only then is the response
+	// wrapped in an envelope, so clients expecting a bare array keep working.
+	paged bool
+}
+
+func parseListQuery(values url.Values) (listQuery, error) {
+	var q listQuery
+	if raw := strings.TrimSpace(values.Get("limit")); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 {
+			return q, fmt.Errorf("invalid limit %q", raw)
+		}
+		q.limit = min(n, maxListLimit)
+		q.paged = true
+	}
+	if raw := strings.TrimSpace(values.Get("offset")); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			return q, fmt.Errorf("invalid offset %q", raw)
+		}
+		q.offset = n
+		q.paged = true
+	}
+	if q.paged && q.limit == 0 {
+		q.limit = maxListLimit
+	}
+	q.sort = strings.TrimSpace(values.Get("sort"))
+	if strings.HasPrefix(q.sort, "-") {
+		q.sort = q.sort[1:]
+		q.desc = true
+	}
+	for _, f := range strings.Split(values.Get("fields"), ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			q.fields = append(q.fields, f)
+		}
+	}
+	return q, nil
+}
+
+// listPage is the response envelope for paginated list requests.
+type listPage struct {
+	Items  interface{} `json:"items"`
+	Total  int         `json:"total"`
+	Limit  int         `json:"limit"`
+	Offset int         `json:"offset"`
+}
+
+// writeList encodes items according to the request's list query. The total
+// count before pagination is always reported in X-Total-Count.
+func writeList[T any](w http.ResponseWriter, r *http.Request, items []T) {
+	q, err := parseListQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if items == nil {
+		items = []T{}
+	}
+
+	var out interface{} = items
+	total := len(items)
+	if q.sort != "" || len(q.fields) > 0 {
+		rows, err := listRows(items)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if q.sort != "" {
+			if !rowsHaveField(rows, q.sort) {
+				http.Error(w, fmt.Sprintf("unknown sort field %q", q.sort), http.StatusBadRequest)
+				return
+			}
+			sortRows(rows, q.sort, q.desc)
+		}
+		rows = pageOf(rows, q)
+		if len(q.fields) > 0 {
+			for i, row := range rows {
+				rows[i] = selectFields(row, q.fields)
+			}
+		}
+		out = rows
+	} else {
+		out = pageOf(items, q)
+	}
+
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	if !q.paged {
+		json.NewEncoder(w).Encode(out)
+		return
+	}
+	json.NewEncoder(w).Encode(listPage{Items: out, Total: total, Limit: q.limit, Offset: q.offset})
+}
+
+func pageOf[T any](items []T, q listQuery) []T {
+	if !q.paged {
+		return items
+	}
+	start := min(q.offset, len(items))
+	end := min(start+q.limit, len(items))
+	return items[start:end]
+}
+
+// listRows converts items to their JSON object form so they can be sorted and
+// projected by JSON field name.
+func listRows[T any](items []T) ([]map[string]interface{}, error) {
+	data, err := json.Marshal(items)
+	if err != nil {
+		return nil, err
+	}
+	var rows []map[string]interface{}
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// rowsHaveField reports whether any row has field; omitempty fields may be
+// missing from some rows.
+func rowsHaveField(rows []map[string]interface{}, field string) bool {
+	if len(rows) == 0 {
+		return true
+	}
+	for _, row := range rows {
+		if _, ok := row[field]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func sortRows(rows []map[string]interface{}, field string, desc bool) {
+	slices.SortStableFunc(rows, func(a, b map[string]interface{}) int {
+		c := compareListValues(a[field], b[field])
+		if desc {
+			return -c
+		}
+		return c
+	})
+}
+
+// compareListValues orders JSON values: missing/null first, then booleans,
+// numbers and strings (case-insensitive); other kinds compare equal.
+func compareListValues(a, b interface{}) int {
+	switch av := a.(type) {
+	case nil:
+		if b == nil {
+			return 0
+		}
+		return -1
+	case bool:
+		if bv, ok := b.(bool); ok {
+			switch {
+			case av == bv:
+				return 0
+			case !av:
+				return -1
+			}
+			return 1
+		}
+	case float64:
+		if bv, ok := b.(float64); ok {
+			return cmp.Compare(av, bv)
+		}
+	case string:
+		if bv, ok := b.(string); ok {
+			return cmp.Compare(strings.ToLower(av), strings.ToLower(bv))
+		}
+	}
+	if b == nil {
+		return 1
+	}
+	return 0
+}
+
+func selectFields(row map[string]interface{}, fields []string) map[string]interface{} {
+	out := make(map[string]interface{}, len(fields))
+	for _, f := range fields {
+		if v, ok := row[f]; ok {
+			out[f] = v
+		}
+	}
+	return out
+}
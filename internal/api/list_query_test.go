@@ -0,0 +1,78 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/goccy/go-json"
+)
+
+type listItem struct {
+	ID      int    `json:"id"`
+	Name    string `json:"name"`
+	Enabled bool   `json:"enabled"`
+}
+
+var listItems = []listItem{
+	{ID: 1, Name: "charlie", Enabled: true},
+	{ID: 2, Name: "Alpha", Enabled: false},
+	{ID: 3, Name: "bravo", Enabled: true},
+}
+
+func TestWriteListBareArrayWithoutPagination(t *testing.T) {
+	rec := httptest.NewRecorder()
+	writeList(rec, httptest.NewRequest(http.MethodGet, "/api/keys", nil), listItems)
+	var got []listItem
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("expected a bare array: %v (%s)", err, rec.Body.String())
+	}
+	if len(got) != 3 || rec.Header().Get("X-Total-Count") != "3" {
+		t.Fatalf("unexpected list: %+v total=%s", got, rec.Header().Get("X-Total-Count"))
+	}
+}
+
+func TestWriteListPaginatesSortsAndSelectsFields(t *testing.T) {
+	rec := httptest.NewRecorder()
+	writeList(rec, httptest.NewRequest(http.MethodGet, "/api/keys?sort=-name&limit=2&offset=1&fields=id,name", nil), listItems)
+	var page struct {
+		Items  []map[string]interface{} `json:"items"`
+		Total  int                      `json:"total"`
+		Limit  int                      `json:"limit"`
+		Offset int                      `json:"offset"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil {
+		t.Fatalf("decode: %v (%s)", err, rec.Body.String())
+	}
+	if page.Total != 3 || page.Limit != 2 || page.Offset != 1 || len(page.Items) != 2 {
+		t.Fatalf("unexpected envelope: %+v", page)
+	}
+	if page.Items[0]["name"] != "bravo" || page.Items[1]["name"] != "Alpha" {
+		t.Fatalf("expected case-insensitive descending order, got %v", page.Items)
+	}
+	if _, ok := page.Items[0]["enabled"]; ok || len(page.Items[0]) != 2 {
+		t.Fatalf("expected only id and name, got %v", page.Items[0])
+	}
+}
+
+func TestWriteListRejectsBadQuery(t *testing.T) {
+	for _, query := range []string{"limit=0", "limit=x", "offset=-1", "sort=missing"} {
+		rec := httptest.NewRecorder()
+		writeList(rec, httptest.NewRequest(http.MethodGet, "/api/models?"+query, nil), listItems)
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("%s: status=%d want 400", query, rec.Code)
+		}
+	}
+}
+
+func TestWriteListOffsetPastEnd(t *testing.T) {
+	rec := httptest.NewRecorder()
+	writeList(rec, httptest.NewRequest(http.MethodGet, "/api/models?offset=10", nil), listItems)
+	var page listPage
+	if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if items, _ := page.Items.([]interface{}); len(items) != 0 || page.Total != 3 {
+		t.Fatalf("expected empty page with total 3, got %s", rec.Body.String())
+	}
+}
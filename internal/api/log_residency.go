@@ -0,0 +1,42 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"orchids-api/internal/store"
+)
+
+// normalizeLogResidency trims res and checks who may set it. Tenant admins
+// may disable logs for their keys but not redirect them, since the
+// destinations are paths and Redis prefixes of the shared server.
+func normalizeLogResidency(ctx context.Context, res *store.LogResidency) (int, error) {
+	if res == nil {
+		return 0, nil
+	}
+	res.DebugDir = strings.TrimSpace(res.DebugDir)
+	res.AuditPrefix = strings.TrimSpace(res.AuditPrefix)
+	if _, scoped := store.TenantFromContext(ctx); scoped && (res.DebugDir != "" || res.AuditPrefix != "") {
+		return http.StatusForbidden, errors.New("only the global admin may set debug_dir or audit_prefix")
+	}
+	if res.DebugDir != "" {
+		res.DebugDir = filepath.Clean(res.DebugDir)
+	}
+	if strings.ContainsAny(res.AuditPrefix, " \t\r\n") {
+		return http.StatusBadRequest, errors.New("audit_prefix must not contain whitespace")
+	}
+	return 0, nil
+}
+
+// keyAuditPrefix is the audit stream prefix the requests of key are logged
+// under.
+func (a *API) keyAuditPrefix(ctx context.Context, key *store.ApiKey) string {
+	var tenant *store.Tenant
+	if key.TenantID != 0 {
+		tenant, _ = a.store.GetTenant(store.WithoutTenant(ctx), key.TenantID)
+	}
+	return store.EffectiveLogResidency(tenant, key).AuditPrefix
+}
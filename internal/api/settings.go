@@ -0,0 +1,162 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/goccy/go-json"
+
+	"orchids-api/internal/featureflag"
+)
+
+// configSettingKey holds the runtime config saved by /api/config.
+const configSettingKey = "config"
+
+// reservedSettingKey reports whether key is managed by a dedicated endpoint
+// and must not be written through /api/settings.
+func reservedSettingKey(key string) (string, bool) {
+	switch {
+	case key == configSettingKey:
+		return "use /api/config to change the runtime config", true
+	case key == configHistorySettingKey:
+		return "use /api/config/rollback to restore an earlier config", true
+	case strings.HasPrefix(key, featureflag.SettingPrefix):
+		return "use /api/flags to change feature flags", true
+	}
+	return "", false
+}
+
+// HandleSettings lists every stored setting as a key → value map.
+func (a *API) HandleSettings(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	settings, err := a.store.ListSettings(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(settings)
+}
+
+// HandleSettingByKey reads, writes or deletes a single setting.
+func (a *API) HandleSettingByKey(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	key := strings.TrimSpace(strings.TrimPrefix(r.URL.Path, "/api/settings/"))
+	if key == "" {
+		http.Error(w, "setting key is required", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		value, err := a.store.GetSetting(r.Context(), key)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if value == "" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"key": key, "value": value})
+
+	case http.MethodPut:
+		if hint, reserved := reservedSettingKey(key); reserved {
+			http.Error(w, hint, http.StatusBadRequest)
+			return
+		}
+		var req struct {
+			Value *string `json:"value"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Value == nil {
+			http.Error(w, "value is required", http.StatusBadRequest)
+			return
+		}
+		if err := a.store.SetSetting(r.Context(), key, *req.Value); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"key": key, "value": *req.Value})
+
+	case http.MethodDelete:
+		if hint, reserved := reservedSettingKey(key); reserved {
+			http.Error(w, hint, http.StatusBadRequest)
+			return
+		}
+		if err := a.store.DeleteSetting(r.Context(), key); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleFlags lists every registered feature flag with its effective value.
+func (a *API) HandleFlags(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	json.NewEncoder(w).Encode(a.flags.States())
+}
+
+// HandleFlagByName reads, overrides (PUT {"value": ...}) or resets (DELETE) a flag.
+func (a *API) HandleFlagByName(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	name := strings.TrimSpace(strings.TrimPrefix(r.URL.Path, "/api/flags/"))
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var req struct {
+			Value json.RawMessage `json:"value"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(req.Value) == 0 {
+			http.Error(w, "value is required", http.StatusBadRequest)
+			return
+		}
+		err = a.flags.Set(r.Context(), a.store, name, req.Value)
+	case http.MethodDelete:
+		err = a.flags.Reset(r.Context(), a.store, name)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, featureflag.ErrUnknownFlag):
+			status = http.StatusNotFound
+		case errors.Is(err, featureflag.ErrInvalidValue):
+			status = http.StatusBadRequest
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	for _, st := range a.flags.States() {
+		if st.Name == name {
+			json.NewEncoder(w).Encode(st)
+			return
+		}
+	}
+	http.Error(w, "unknown feature flag: "+name, http.StatusNotFound)
+}
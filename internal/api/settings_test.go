@@ -0,0 +1,79 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/goccy/go-json"
+
+	"orchids-api/internal/featureflag"
+)
+
+func TestSettingsCRUD(t *testing.T) {
+	a := newTransferAPI(t)
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if path == "/api/settings" {
+			a.HandleSettings(rec, req)
+		} else {
+			a.HandleSettingByKey(rec, req)
+		}
+		return rec
+	}
+
+	if rec := do(http.MethodPut, "/api/settings/banner", `{"value":"maintenance at 02:00"}`); rec.Code != http.StatusOK {
+		t.Fatalf("PUT: %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := do(http.MethodGet, "/api/settings/banner", ""); !strings.Contains(rec.Body.String(), "maintenance") {
+		t.Fatalf("GET: %d %s", rec.Code, rec.Body.String())
+	}
+	rec := do(http.MethodGet, "/api/settings", "")
+	var all map[string]string
+	if err := json.Unmarshal(rec.Body.Bytes(), &all); err != nil || all["banner"] != "maintenance at 02:00" {
+		t.Fatalf("list: %v %s", err, rec.Body.String())
+	}
+	for _, key := range []string{"config", "flag:handler.x"} {
+		if rec := do(http.MethodPut, "/api/settings/"+key, `{"value":"x"}`); rec.Code != http.StatusBadRequest {
+			t.Fatalf("PUT %s should be rejected, got %d", key, rec.Code)
+		}
+	}
+	if rec := do(http.MethodDelete, "/api/settings/banner", ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("DELETE: %d", rec.Code)
+	}
+	if rec := do(http.MethodGet, "/api/settings/banner", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("GET after delete: %d", rec.Code)
+	}
+}
+
+func TestFlagsAPI(t *testing.T) {
+	a := newTransferAPI(t)
+	a.flags = featureflag.NewRegistry()
+	flag := a.flags.Int("test.limit", 2, "test flag")
+	do := func(method, name, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		a.HandleFlagByName(rec, httptest.NewRequest(method, "/api/flags/"+name, strings.NewReader(body)))
+		return rec
+	}
+
+	if rec := do(http.MethodPut, "test.limit", `{"value":9}`); rec.Code != http.StatusOK || flag.Value() != 9 {
+		t.Fatalf("PUT: %d %s value=%d", rec.Code, rec.Body.String(), flag.Value())
+	}
+	if rec := do(http.MethodPut, "test.limit", `{"value":true}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("wrong kind should be 400, got %d", rec.Code)
+	}
+	if rec := do(http.MethodGet, "missing", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("unknown flag should be 404, got %d", rec.Code)
+	}
+	if rec := do(http.MethodDelete, "test.limit", ""); rec.Code != http.StatusOK || flag.Value() != 2 {
+		t.Fatalf("DELETE: %d value=%d", rec.Code, flag.Value())
+	}
+
+	rec := httptest.NewRecorder()
+	a.HandleFlags(rec, httptest.NewRequest(http.MethodGet, "/api/flags", nil))
+	if !strings.Contains(rec.Body.String(), `"name":"test.limit"`) {
+		t.Fatalf("list: %s", rec.Body.String())
+	}
+}
@@ -0,0 +1,327 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/goccy/go-json"
+
+	"orchids-api/internal/auth"
+	"orchids-api/internal/store"
+)
+
+type tenantRequest struct {
+	Name              *string             `json:"name"`
+	Description       *string             `json:"description"`
+	Enabled           *bool               `json:"enabled"`
+	AdminUser         *string             `json:"admin_user"`
+	AdminPassword     *string             `json:"admin_password"`
+	MonthlyTokenQuota *int                `json:"monthly_token_quota"`
+	Models            *[]string           `json:"models"`
+	LogResidency      *store.LogResidency `json:"log_residency"`
+}
+
+// apply copies the set fields of req onto t.
+func (req *tenantRequest) apply(t *store.Tenant) error {
+	if req.Name != nil {
+		t.Name = strings.TrimSpace(*req.Name)
+	}
+	if req.Description != nil {
+		t.Description = strings.TrimSpace(*req.Description)
+	}
+	if req.Enabled != nil {
+		t.Enabled = *req.Enabled
+	}
+	if req.AdminUser != nil {
+		t.AdminUser = strings.TrimSpace(*req.AdminUser)
+	}
+	if req.AdminPassword != nil {
+		if *req.AdminPassword == "" {
+			t.AdminPassHash = ""
+		} else {
+			hash, err := auth.HashPassword(*req.AdminPassword)
+			if err != nil {
+				return err
+			}
+			t.AdminPassHash = hash
+		}
+	}
+	if req.MonthlyTokenQuota != nil {
+		t.MonthlyTokenQuota = max(*req.MonthlyTokenQuota, 0)
+	}
+	if req.Models != nil {
+		models := make([]string, 0, len(*req.Models))
+		for _, m := range *req.Models {
+			if m = strings.TrimSpace(m); m != "" {
+				models = append(models, m)
+			}
+		}
+		t.Models = models
+	}
+	if req.LogResidency != nil {
+		t.LogResidency = req.LogResidency
+	}
+	return nil
+}
+
+// validateTenant checks t before it is saved: names are required, log
+// residency is normalized and admin users must be unique and must not shadow
+// the global admin.
+func (a *API) validateTenant(r *http.Request, t *store.Tenant) (int, error) {
+	if t.Name == "" {
+		return http.StatusBadRequest, errors.New("name is required")
+	}
+	if status, err := normalizeLogResidency(r.Context(), t.LogResidency); err != nil {
+		return status, err
+	}
+	if t.LogResidency.IsZero() {
+		t.LogResidency = nil
+	}
+	if t.AdminUser == "" {
+		return 0, nil
+	}
+	if t.AdminPassHash == "" {
+		return http.StatusBadRequest, errors.New("admin_password is required with admin_user")
+	}
+	if secureCompare(t.AdminUser, a.adminUser) {
+		return http.StatusConflict, errors.New("admin_user is taken by the global admin")
+	}
+	tenants, err := a.store.ListTenants(r.Context())
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	for _, other := range tenants {
+		if other.ID != t.ID && strings.EqualFold(other.AdminUser, t.AdminUser) {
+			return http.StatusConflict, errors.New("admin_user is taken by tenant " + other.Name)
+		}
+	}
+	return 0, nil
+}
+
+// tenantExists reports an error unless id is 0 (the shared pool) or an
+// existing tenant.
+func (a *API) tenantExists(r *http.Request, id int64) error {
+	if id == 0 {
+		return nil
+	}
+	if _, err := a.store.GetTenant(r.Context(), id); err != nil {
+		if errors.Is(err, store.ErrNoRows) {
+			return errors.New("tenant " + strconv.FormatInt(id, 10) + " does not exist")
+		}
+		return err
+	}
+	return nil
+}
+
+// HandleTenants serves GET and POST /api/tenants.
+func (a *API) HandleTenants(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodGet:
+		tenants, err := a.store.ListTenants(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeList(w, r, tenants)
+
+	case http.MethodPost:
+		var req tenantRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		t := store.Tenant{Enabled: true}
+		if err := req.apply(&t); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if status, err := a.validateTenant(r, &t); err != nil {
+			http.Error(w, err.Error(), status)
+			return
+		}
+		if err := a.store.CreateTenant(r.Context(), &t); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(t)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleTenantByID serves GET, PATCH and DELETE /api/tenants/{id} and
+// POST /api/tenants/{id}/assign.
+func (a *API) HandleTenantByID(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	idStr, sub, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/tenants/"), "/")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+	switch sub {
+	case "":
+	case "assign":
+		a.handleTenantAssign(w, r, id)
+		return
+	default:
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	t, err := a.store.GetTenant(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, store.ErrNoRows) {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		json.NewEncoder(w).Encode(t)
+
+	case http.MethodPatch:
+		var req tenantRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		wasEnabled, oldUser, oldHash := t.Enabled, t.AdminUser, t.AdminPassHash
+		if err := req.apply(t); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if status, err := a.validateTenant(r, t); err != nil {
+			http.Error(w, err.Error(), status)
+			return
+		}
+		if err := a.store.UpdateTenant(r.Context(), t); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// Disabling a tenant or changing its admin credentials logs its
+		// admin out.
+		if (wasEnabled && !t.Enabled) || oldUser != t.AdminUser || oldHash != t.AdminPassHash {
+			auth.InvalidateTenantSessions(id)
+		}
+		json.NewEncoder(w).Encode(t)
+
+	case http.MethodDelete:
+		inUse, err := a.tenantInUse(r, id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if inUse {
+			http.Error(w, "tenant still has accounts or API keys; reassign them first", http.StatusConflict)
+			return
+		}
+		if err := a.store.DeleteTenant(r.Context(), id); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		auth.InvalidateTenantSessions(id)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// tenantInUse reports whether any account or API key belongs to tenant id.
+func (a *API) tenantInUse(r *http.Request, id int64) (bool, error) {
+	accounts, err := a.store.ListAccounts(r.Context())
+	if err != nil {
+		return false, err
+	}
+	for _, acc := range accounts {
+		if acc.TenantID == id {
+			return true, nil
+		}
+	}
+	keys, err := a.store.ListApiKeys(r.Context())
+	if err != nil {
+		return false, err
+	}
+	for _, key := range keys {
+		if key.TenantID == id {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+type tenantAssignRequest struct {
+	Accounts []int64 `json:"accounts"`
+	Keys     []int64 `json:"keys"`
+}
+
+// handleTenantAssign moves accounts and API keys to tenant id; id 0 returns
+// them to the shared pool.
+func (a *API) handleTenantAssign(w http.ResponseWriter, r *http.Request, id int64) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := a.tenantExists(r, id); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	var req tenantAssignRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, accID := range req.Accounts {
+		if err := a.store.SetAccountTenant(r.Context(), accID, id); err != nil {
+			writeAssignError(w, "account", accID, err)
+			return
+		}
+	}
+	for _, keyID := range req.Keys {
+		if err := a.store.SetApiKeyTenant(r.Context(), keyID, id); err != nil {
+			writeAssignError(w, "API key", keyID, err)
+			return
+		}
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"tenant_id": id,
+		"accounts":  len(req.Accounts),
+		"keys":      len(req.Keys),
+	})
+}
+
+func writeAssignError(w http.ResponseWriter, kind string, id int64, err error) {
+	status := http.StatusInternalServerError
+	if errors.Is(err, store.ErrNoRows) {
+		status = http.StatusNotFound
+	}
+	http.Error(w, kind+" "+strconv.FormatInt(id, 10)+": "+err.Error(), status)
+}
+
+// tenantLogin finds the enabled tenant whose admin credentials match.
+func (a *API) tenantLogin(r *http.Request, username, password string) *store.Tenant {
+	if a.store == nil || username == "" {
+		return nil
+	}
+	tenants, err := a.store.ListTenants(r.Context())
+	if err != nil {
+		return nil
+	}
+	for _, t := range tenants {
+		if t.Enabled && t.AdminUser != "" && secureCompare(username, t.AdminUser) && auth.CheckPassword(t.AdminPassHash, password) {
+			return t
+		}
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/goccy/go-json"
+
+	"orchids-api/internal/auth"
+	"orchids-api/internal/store"
+)
+
+func TestTenants(t *testing.T) {
+	a := newTransferAPI(t)
+	ctx := context.Background()
+
+	do := func(h http.HandlerFunc, ctx context.Context, method, path, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h(rec, httptest.NewRequest(method, path, bytes.NewReader([]byte(body))).WithContext(ctx))
+		return rec
+	}
+
+	rec := do(a.HandleTenants, ctx, http.MethodPost, "/api/tenants", `{"name":"team","admin_user":"lead","admin_password":"secret","models":["claude-sonnet-4-5"]}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create tenant: status=%d body=%s", rec.Code, rec.Body.String())
+	}
+	var tenant store.Tenant
+	json.Unmarshal(rec.Body.Bytes(), &tenant)
+	if bytes.Contains(rec.Body.Bytes(), []byte("secret")) || bytes.Contains(rec.Body.Bytes(), []byte("pbkdf2")) {
+		t.Fatalf("tenant response leaks the admin password: %s", rec.Body.String())
+	}
+	if rec := do(a.HandleTenants, ctx, http.MethodPost, "/api/tenants", `{"name":"other","admin_user":"admin","admin_password":"x"}`); rec.Code != http.StatusConflict {
+		t.Fatalf("tenant admin shadowing the global admin: status=%d", rec.Code)
+	}
+
+	shared := &store.Account{Name: "shared", Enabled: true}
+	moved := &store.Account{Name: "moved", Enabled: true}
+	for _, acc := range []*store.Account{shared, moved} {
+		if err := a.store.CreateAccount(ctx, acc); err != nil {
+			t.Fatalf("CreateAccount: %v", err)
+		}
+	}
+	path := "/api/tenants/" + strconv.FormatInt(tenant.ID, 10)
+	if rec := do(a.HandleTenantByID, ctx, http.MethodPost, path+"/assign", `{"accounts":[`+strconv.FormatInt(moved.ID, 10)+`]}`); rec.Code != http.StatusOK {
+		t.Fatalf("assign: status=%d body=%s", rec.Code, rec.Body.String())
+	}
+
+	scoped := store.WithTenant(ctx, tenant.ID)
+	accounts, err := a.store.ListAccounts(scoped)
+	if err != nil || len(accounts) != 1 || accounts[0].ID != moved.ID {
+		t.Fatalf("tenant accounts=%+v err=%v", accounts, err)
+	}
+	if _, err := a.store.GetAccount(scoped, shared.ID); err == nil {
+		t.Fatal("tenant read a shared account")
+	}
+	if err := a.store.DeleteAccount(scoped, shared.ID); err == nil {
+		t.Fatal("tenant deleted a shared account")
+	}
+	models, err := a.store.ListModels(scoped)
+	if err != nil || len(models) != 1 || models[0].ModelID != "claude-sonnet-4-5" {
+		t.Fatalf("tenant models=%+v err=%v", models, err)
+	}
+	if err := a.store.CreateModel(scoped, &store.Model{ModelID: "x"}); err == nil {
+		t.Fatal("tenant changed the global model list")
+	}
+
+	rec = do(a.HandleKeys, scoped, http.MethodPost, "/api/keys", `{"name":"k"}`)
+	var key CreateKeyResponse
+	json.Unmarshal(rec.Body.Bytes(), &key)
+	if rec.Code != http.StatusCreated || key.TenantID != tenant.ID {
+		t.Fatalf("tenant key: status=%d body=%s", rec.Code, rec.Body.String())
+	}
+	if keys, _ := a.store.ListApiKeys(store.WithTenant(ctx, 0)); len(keys) != 0 {
+		t.Fatalf("tenant key visible to the shared pool: %+v", keys)
+	}
+
+	if rec := do(a.HandleTenantByID, ctx, http.MethodDelete, path, ""); rec.Code != http.StatusConflict {
+		t.Fatalf("delete tenant in use: status=%d", rec.Code)
+	}
+
+	rec = do(a.HandleLogin, ctx, http.MethodPost, "/api/login", `{"username":"lead","password":"secret"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("tenant login: status=%d", rec.Code)
+	}
+	cookie := rec.Result().Cookies()[0]
+	if id, ok := auth.SessionTenant(cookie.Value); !ok || id != tenant.ID {
+		t.Fatalf("session tenant=%d ok=%v", id, ok)
+	}
+	if rec := do(a.HandleLogin, ctx, http.MethodPost, "/api/login", `{"username":"lead","password":"wrong"}`); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong tenant password: status=%d", rec.Code)
+	}
+
+	if rec := do(a.HandleTenantByID, ctx, http.MethodPatch, path, `{"enabled":false}`); rec.Code != http.StatusOK {
+		t.Fatalf("disable tenant: status=%d", rec.Code)
+	}
+	if _, ok := auth.SessionTenant(cookie.Value); ok {
+		t.Fatal("disabling the tenant kept its admin session")
+	}
+}
@@ -0,0 +1,559 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-json"
+
+	"orchids-api/internal/clerk"
+	"orchids-api/internal/config"
+	"orchids-api/internal/store"
+)
+
+// Export/import scopes.
+const (
+	transferAccounts = "accounts"
+	transferKeys     = "keys"
+	transferModels   = "models"
+	transferSettings = "settings"
+)
+
+var transferScopeNames = []string{transferAccounts, transferKeys, transferModels, transferSettings}
+
+// Import merge strategies. Records are matched by natural key: account type +
+// name for accounts, key hash for API keys, model_id for models.
+const (
+	// importStrategySkip creates missing records and leaves existing ones alone.
+	importStrategySkip = "skip"
+	// importStrategyUpsert creates missing records and updates existing ones.
+	importStrategyUpsert = "upsert"
+	// importStrategyOverwrite replaces every record in the scope with the import.
+	importStrategyOverwrite = "overwrite"
+)
+
+var errInvalidImport = errors.New("invalid import")
+
+func validImportStrategy(s string) bool {
+	switch s {
+	case importStrategySkip, importStrategyUpsert, importStrategyOverwrite:
+		return true
+	}
+	return false
+}
+
+// parseTransferScopes parses a comma-separated scope list ("all" selects every
+// scope). An empty value returns an empty set so callers can apply a default.
+func parseTransferScopes(raw string) (map[string]bool, error) {
+	scopes := make(map[string]bool)
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.ToLower(strings.TrimSpace(part))
+		switch {
+		case part == "":
+		case part == "all":
+			for _, name := range transferScopeNames {
+				scopes[name] = true
+			}
+		case slices.Contains(transferScopeNames, part):
+			scopes[part] = true
+		default:
+			return nil, fmt.Errorf("invalid scope: %s", part)
+		}
+	}
+	return scopes, nil
+}
+
+func (d *ExportData) presentScopes() map[string]bool {
+	scopes := make(map[string]bool)
+	if len(d.Accounts) > 0 {
+		scopes[transferAccounts] = true
+	}
+	if len(d.Keys) > 0 {
+		scopes[transferKeys] = true
+	}
+	if len(d.Models) > 0 {
+		scopes[transferModels] = true
+	}
+	if len(d.Settings) > 0 {
+		scopes[transferSettings] = true
+	}
+	return scopes
+}
+
+func (a *API) buildExport(ctx context.Context, scopes map[string]bool) (*ExportData, error) {
+	out := &ExportData{Version: 2, ExportAt: time.Now()}
+	if scopes[transferAccounts] {
+		accounts, err := a.store.ListAccounts(ctx)
+		if err != nil {
+			return nil, err
+		}
+		out.Accounts = make([]store.Account, 0, len(accounts))
+		for _, acc := range accounts {
+			out.Accounts = append(out.Accounts, *exportAccount(acc))
+		}
+	}
+	if scopes[transferKeys] {
+		keys, err := a.store.ListApiKeys(ctx)
+		if err != nil {
+			return nil, err
+		}
+		out.Keys = make([]ExportedKey, 0, len(keys))
+		for _, key := range keys {
+			out.Keys = append(out.Keys, exportKey(key))
+		}
+	}
+	if scopes[transferModels] {
+		models, err := a.store.ListModels(ctx)
+		if err != nil {
+			return nil, err
+		}
+		out.Models = make([]store.Model, 0, len(models))
+		for _, m := range models {
+			exported := *m
+			exported.ID = ""
+			out.Models = append(out.Models, exported)
+		}
+	}
+	if scopes[transferSettings] {
+		data, err := json.Marshal(a.config.Load())
+		if err != nil {
+			return nil, err
+		}
+		out.Settings = data
+	}
+	return out, nil
+}
+
+func exportAccount(acc *store.Account) *store.Account {
+	out := normalizeAccountOutput(acc)
+	out.ID = 0
+	out.RequestCount = 0
+	out.Draining = false
+	return out
+}
+
+func exportKey(key *store.ApiKey) ExportedKey {
+	out := ExportedKey{ApiKey: *key, KeyHash: key.KeyHash, KeyFull: key.KeyFull}
+	out.ID = 0
+	out.LastUsedAt = nil
+	return out
+}
+
+func accountNaturalKey(acc *store.Account) string {
+	accountType := strings.ToLower(strings.TrimSpace(acc.AccountType))
+	if accountType == "" {
+		accountType = "orchids"
+	}
+	return accountType + "/" + strings.ToLower(strings.TrimSpace(acc.Name))
+}
+
+func modelNaturalKey(m *store.Model) string {
+	return strings.ToLower(strings.TrimSpace(m.ModelID))
+}
+
+func keyLabel(key *store.ApiKey) string {
+	return key.Name + " (" + key.KeyPrefix + "..." + key.KeySuffix + ")"
+}
+
+// importer applies an ExportData document with one merge strategy. In dry-run
+// mode it only records what it would do.
+type importer struct {
+	a        *API
+	ctx      context.Context
+	strategy string
+	dryRun   bool
+	// editor is recorded in the config history for imported settings.
+	editor configVersion
+
+	res ImportResult
+}
+
+func (im *importer) result() ImportResult {
+	im.res.Strategy = im.strategy
+	im.res.DryRun = im.dryRun
+	if im.res.Scopes == nil {
+		im.res.Scopes = map[string]*ImportScopeResult{}
+	}
+	return im.res
+}
+
+func (im *importer) run(data *ExportData, scopes map[string]bool) error {
+	im.res.Scopes = make(map[string]*ImportScopeResult, len(scopes))
+	for _, name := range transferScopeNames {
+		if !scopes[name] {
+			continue
+		}
+		im.res.Scopes[name] = &ImportScopeResult{}
+		var err error
+		switch name {
+		case transferAccounts:
+			err = im.importAccounts(data.Accounts)
+		case transferKeys:
+			err = im.importKeys(data.Keys)
+		case transferModels:
+			err = im.importModels(data.Models)
+		case transferSettings:
+			err = im.importSettings(data.Settings)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (im *importer) incoming(scope string, n int) {
+	im.res.Total += n
+	im.res.Scopes[scope].Total += n
+}
+
+func (im *importer) record(scope, action, key string, fields []string) {
+	sr := im.res.Scopes[scope]
+	switch action {
+	case "create":
+		im.res.Imported++
+		sr.Imported++
+	case "update":
+		im.res.Updated++
+		sr.Updated++
+	case "delete":
+		im.res.Deleted++
+		sr.Deleted++
+	default:
+		im.res.Skipped++
+		sr.Skipped++
+	}
+	if im.dryRun {
+		im.res.Changes = append(im.res.Changes, ImportChange{Scope: scope, Action: action, Key: key, Fields: fields})
+	}
+}
+
+// apply runs fn unless this is a dry run; failures are logged and counted as
+// skipped, as a single bad record shouldn't abort the whole import.
+func (im *importer) apply(scope, action, key string, fields []string, fn func() error) {
+	if !im.dryRun {
+		if err := fn(); err != nil {
+			slog.Warn("Import failed", "scope", scope, "action", action, "key", key, "error", err)
+			im.record(scope, "skip", key, nil)
+			return
+		}
+	}
+	im.record(scope, action, key, fields)
+}
+
+func (im *importer) importAccounts(items []store.Account) error {
+	s := im.a.store
+	existing, err := s.ListAccounts(im.ctx)
+	if err != nil {
+		return err
+	}
+	byKey := make(map[string]*store.Account, len(existing))
+	for _, acc := range existing {
+		if _, ok := byKey[accountNaturalKey(acc)]; !ok {
+			byKey[accountNaturalKey(acc)] = acc
+		}
+	}
+	if im.strategy == importStrategyOverwrite {
+		for _, acc := range existing {
+			id := acc.ID
+			im.apply(transferAccounts, "delete", accountNaturalKey(acc), nil, func() error {
+				if err := s.DeleteAccount(im.ctx, id); err != nil {
+					return err
+				}
+				im.a.forgetAccount(id)
+				return nil
+			})
+		}
+		clear(byKey)
+	}
+
+	im.incoming(transferAccounts, len(items))
+	for i := range items {
+		acc := items[i]
+		acc.ID = 0
+		acc.RequestCount = 0
+		key := accountNaturalKey(&acc)
+		if err := normalizeImportedAccount(&acc); err != nil {
+			slog.Warn("Invalid account in import", "name", acc.Name, "error", err)
+			im.record(transferAccounts, "skip", key, nil)
+			continue
+		}
+		cur, ok := byKey[key]
+		switch {
+		case !ok:
+			im.apply(transferAccounts, "create", key, nil, func() error {
+				return s.CreateAccount(im.ctx, &acc)
+			})
+		case im.strategy == importStrategySkip:
+			im.record(transferAccounts, "skip", key, nil)
+		default:
+			fields := changedFields(exportAccount(cur), &acc, "id", "request_count", "last_used_at", "created_at", "updated_at")
+			if len(fields) == 0 {
+				im.record(transferAccounts, "unchanged", key, nil)
+				continue
+			}
+			acc.ID = cur.ID
+			im.apply(transferAccounts, "update", key, fields, func() error {
+				return s.UpdateAccount(im.ctx, &acc)
+			})
+		}
+	}
+	return nil
+}
+
+func (im *importer) importKeys(items []ExportedKey) error {
+	s := im.a.store
+	existing, err := s.ListApiKeys(im.ctx)
+	if err != nil {
+		return err
+	}
+	byHash := make(map[string]*store.ApiKey, len(existing))
+	for _, key := range existing {
+		byHash[key.KeyHash] = key
+	}
+	if im.strategy == importStrategyOverwrite {
+		for _, key := range existing {
+			id := key.ID
+			im.apply(transferKeys, "delete", keyLabel(key), nil, func() error {
+				return s.DeleteApiKey(im.ctx, id)
+			})
+		}
+		clear(byHash)
+	}
+
+	im.incoming(transferKeys, len(items))
+	for _, item := range items {
+		key := item.ApiKey
+		key.ID = 0
+		key.KeyHash = strings.TrimSpace(item.KeyHash)
+		key.KeyFull = item.KeyFull
+		key.LastUsedAt = nil
+		label := keyLabel(&key)
+		if key.KeyHash == "" {
+			slog.Warn("API key without key_hash in import", "name", key.Name)
+			im.record(transferKeys, "skip", label, nil)
+			continue
+		}
+		cur, ok := byHash[key.KeyHash]
+		switch {
+		case !ok:
+			im.apply(transferKeys, "create", label, nil, func() error {
+				return s.CreateApiKey(im.ctx, &key)
+			})
+			byHash[key.KeyHash] = &key
+		case im.strategy == importStrategySkip:
+			im.record(transferKeys, "skip", label, nil)
+		default:
+			// Only the mutable settings of a key can be updated in place;
+			// metadata set at creation is kept.
+			fields := changedFields(cur, &key, "id", "name", "key_prefix", "key_suffix", "last_used_at", "created_at",
+				"description", "owner", "monthly_token_quota", "expires_at", "allowed_ips", "tenant_id")
+			if len(fields) == 0 {
+				im.record(transferKeys, "unchanged", label, nil)
+				continue
+			}
+			id := cur.ID
+			im.apply(transferKeys, "update", label, fields, func() error {
+				return s.UpdateApiKey(im.ctx, id, func(k *store.ApiKey) {
+					k.Enabled = key.Enabled
+					k.SystemPrompt = key.SystemPrompt
+					k.AllowPinning = key.AllowPinning
+					k.TPMLimit = key.TPMLimit
+					k.ToolGateMaxChars = key.ToolGateMaxChars
+					k.SkipPromptHygiene = key.SkipPromptHygiene
+					k.StrictModels = key.StrictModels
+					k.LogResidency = key.LogResidency
+				})
+			})
+		}
+	}
+	return nil
+}
+
+func (im *importer) importModels(items []store.Model) error {
+	s := im.a.store
+	existing, err := s.ListModels(im.ctx)
+	if err != nil {
+		return err
+	}
+	byKey := make(map[string]*store.Model, len(existing))
+	for _, m := range existing {
+		byKey[modelNaturalKey(m)] = m
+	}
+	if im.strategy == importStrategyOverwrite {
+		for _, m := range existing {
+			id := m.ID
+			im.apply(transferModels, "delete", m.ModelID, nil, func() error {
+				return s.DeleteModel(im.ctx, id)
+			})
+		}
+		clear(byKey)
+	}
+
+	im.incoming(transferModels, len(items))
+	for i := range items {
+		m := items[i]
+		m.ID = ""
+		key := modelNaturalKey(&m)
+		if key == "" {
+			im.record(transferModels, "skip", m.Name, nil)
+			continue
+		}
+		cur, ok := byKey[key]
+		switch {
+		case !ok:
+			im.apply(transferModels, "create", m.ModelID, nil, func() error {
+				return s.CreateModel(im.ctx, &m)
+			})
+			byKey[key] = &m
+		case im.strategy == importStrategySkip:
+			im.record(transferModels, "skip", m.ModelID, nil)
+		default:
+			fields := changedFields(cur, &m, "id")
+			if len(fields) == 0 {
+				im.record(transferModels, "unchanged", m.ModelID, nil)
+				continue
+			}
+			m.ID = cur.ID
+			im.apply(transferModels, "update", m.ModelID, fields, func() error {
+				return s.UpdateModel(im.ctx, &m)
+			})
+		}
+	}
+	return nil
+}
+
+// importSettings merges the imported config onto the running one, as
+// POST /api/config does. Overwrite and upsert behave the same here; skip
+// leaves an instance that already has saved settings untouched.
+func (im *importer) importSettings(raw json.RawMessage) error {
+	if len(raw) == 0 {
+		return nil
+	}
+	const key = "config"
+	im.incoming(transferSettings, 1)
+	current := im.a.config.Load()
+	if current == nil {
+		return errors.New("config not loaded")
+	}
+	if im.strategy == importStrategySkip {
+		if saved, err := im.a.store.GetSetting(im.ctx, key); err == nil && saved != "" {
+			im.record(transferSettings, "skip", key, nil)
+			return nil
+		}
+	}
+	next := *current
+	if err := json.Unmarshal(raw, &next); err != nil {
+		return fmt.Errorf("%w: settings: %v", errInvalidImport, err)
+	}
+	config.ApplyHardcoded(&next)
+	fields := changedFields(current, &next)
+	if len(fields) == 0 {
+		im.record(transferSettings, "unchanged", key, nil)
+		return nil
+	}
+	im.apply(transferSettings, "update", key, fields, func() error {
+		_, err := im.a.saveConfig(im.ctx, current, &next, im.editor)
+		return err
+	})
+	return nil
+}
+
+// changedFields returns the sorted JSON field names whose values differ
+// between old and new, ignoring the listed fields.
+func changedFields(old, new interface{}, ignore ...string) []string {
+	oldFields, err := jsonFields(old)
+	if err != nil {
+		return nil
+	}
+	newFields, err := jsonFields(new)
+	if err != nil {
+		return nil
+	}
+	var changed []string
+	for name, v := range newFields {
+		if !slices.Contains(ignore, name) && !bytes.Equal(v, oldFields[name]) {
+			changed = append(changed, name)
+		}
+	}
+	for name := range oldFields {
+		if _, ok := newFields[name]; !ok && !slices.Contains(ignore, name) {
+			changed = append(changed, name)
+		}
+	}
+	slices.Sort(changed)
+	return changed
+}
+
+func jsonFields(v interface{}) (map[string]json.RawMessage, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	err = json.Unmarshal(data, &fields)
+	return fields, err
+}
+
+// normalizeImportedAccount applies the same token normalization as account
+// creation. It fails when an Orchids client cookie can't be parsed or the
+// base_url is invalid.
+func normalizeImportedAccount(acc *store.Account) error {
+	if strings.TrimSpace(acc.AccountType) == "" {
+		acc.AccountType = "orchids"
+	}
+	normalizeAccountHeaders(acc)
+	normalizeAccountCaps(acc)
+	baseURL, err := normalizeBaseURL(acc.BaseURL, acc.AccountType)
+	if err != nil {
+		return err
+	}
+	acc.BaseURL = baseURL
+	if strings.EqualFold(acc.AccountType, "warp") {
+		normalizeWarpTokenInput(acc)
+	} else if strings.EqualFold(acc.AccountType, "kiro") {
+		normalizeKiroTokenInput(acc)
+	} else if isAPIKeyAccountType(acc.AccountType) {
+		normalizeAPIKeyInput(acc)
+	} else if strings.EqualFold(acc.AccountType, "grok") {
+		normalizeGrokTokenInput(acc)
+	} else if acc.ClientCookie != "" {
+		acc.ClientCookie = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(acc.ClientCookie), "Bearer "))
+		clientJWT, sessionJWT, err := clerk.ParseClientCookies(acc.ClientCookie)
+		if err != nil {
+			if !isLikelyJWT(acc.ClientCookie) {
+				return err
+			}
+			if jwtHasRotatingToken(acc.ClientCookie) {
+				acc.SessionCookie = ""
+				acc.SessionID = ""
+				acc.Token = ""
+			} else {
+				acc.Token = strings.TrimSpace(acc.ClientCookie)
+				acc.ClientCookie = ""
+				acc.SessionCookie = ""
+				acc.SessionID = ""
+			}
+			return nil
+		}
+		acc.ClientCookie = clientJWT
+		if sessionJWT != "" {
+			acc.SessionCookie = sessionJWT
+			if acc.SessionID == "" {
+				if sid, sub := clerk.ParseSessionInfoFromJWT(sessionJWT); sid != "" {
+					acc.SessionID = sid
+					if acc.UserID == "" {
+						acc.UserID = sub
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/goccy/go-json"
+
+	"orchids-api/internal/config"
+	"orchids-api/internal/store"
+)
+
+func newTransferAPI(t *testing.T) *API {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	s, err := store.New(store.Options{RedisAddr: mr.Addr(), RedisPrefix: "test:"})
+	if err != nil {
+		t.Fatalf("store.New: %v", err)
+	}
+	cfg := &config.Config{}
+	config.ApplyDefaults(cfg)
+	return New(s, "admin", "pass", cfg)
+}
+
+func doImport(t *testing.T, a *API, query string, body []byte) ImportResult {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	a.HandleImport(rec, httptest.NewRequest(http.MethodPost, "/api/import?"+query, bytes.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("import %s: status=%d body=%s", query, rec.Code, rec.Body.String())
+	}
+	var res ImportResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatalf("decode result: %v", err)
+	}
+	return res
+}
+
+func TestExportImportScopesAndStrategies(t *testing.T) {
+	ctx := context.Background()
+	src, dst := newTransferAPI(t), newTransferAPI(t)
+
+	if err := src.store.CreateAccount(ctx, &store.Account{Name: "router", AccountType: "openai-compatible", APIKey: "sk-up", BaseURL: "https://example.com/v1", Weight: 2, Enabled: true}); err != nil {
+		t.Fatalf("CreateAccount: %v", err)
+	}
+	if err := src.store.CreateApiKey(ctx, &store.ApiKey{Name: "ci", KeyHash: "hash-1", KeyPrefix: "sk-", KeySuffix: "abcd", Enabled: true, TPMLimit: 500}); err != nil {
+		t.Fatalf("CreateApiKey: %v", err)
+	}
+	if err := dst.store.CreateAccount(ctx, &store.Account{Name: "Router", AccountType: "openai-compatible", APIKey: "sk-old", Weight: 1, Enabled: true}); err != nil {
+		t.Fatalf("CreateAccount: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	src.HandleExport(rec, httptest.NewRequest(http.MethodGet, "/api/export?scope=accounts,keys", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("export: status=%d body=%s", rec.Code, rec.Body.String())
+	}
+	dump := rec.Body.Bytes()
+	var data ExportData
+	if err := json.Unmarshal(dump, &data); err != nil {
+		t.Fatalf("decode export: %v", err)
+	}
+	if len(data.Accounts) != 1 || len(data.Keys) != 1 || len(data.Models) != 0 || len(data.Settings) != 0 {
+		t.Fatalf("unexpected export scopes: %s", dump)
+	}
+	if data.Keys[0].KeyHash != "hash-1" || data.Keys[0].ID != 0 {
+		t.Fatalf("exported key should carry its hash and no ID: %+v", data.Keys[0])
+	}
+
+	res := doImport(t, dst, "strategy=upsert&dry_run=true", dump)
+	if !res.DryRun || res.Updated != 1 || res.Imported != 1 || len(res.Changes) != 2 {
+		t.Fatalf("unexpected dry run: %+v", res)
+	}
+	if fields := res.Changes[0].Fields; len(fields) == 0 {
+		t.Fatalf("dry run should list changed account fields: %+v", res.Changes[0])
+	}
+	if acc, _ := dst.store.ListAccounts(ctx); len(acc) != 1 || acc[0].APIKey != "sk-old" {
+		t.Fatalf("dry run must not write: %+v", acc)
+	}
+
+	res = doImport(t, dst, "", dump)
+	if res.Strategy != importStrategySkip || res.Skipped != 1 || res.Imported != 1 {
+		t.Fatalf("unexpected skip import: %+v", res)
+	}
+	if key, err := dst.store.GetApiKeyByHash(ctx, "hash-1"); err != nil || key.TPMLimit != 500 {
+		t.Fatalf("imported key not found by hash: %+v %v", key, err)
+	}
+
+	res = doImport(t, dst, "strategy=upsert", dump)
+	if res.Updated != 1 || res.Skipped != 1 || res.Scopes[transferKeys].Skipped != 1 {
+		t.Fatalf("unexpected upsert: %+v", res)
+	}
+	accounts, _ := dst.store.ListAccounts(ctx)
+	if len(accounts) != 1 || accounts[0].APIKey != "sk-up" || accounts[0].BaseURL != "https://example.com/v1" || accounts[0].Weight != 2 {
+		t.Fatalf("account not upserted: %+v", accounts)
+	}
+
+	if err := dst.store.CreateAccount(ctx, &store.Account{Name: "extra", AccountType: "anthropic", APIKey: "sk-x", Enabled: true}); err != nil {
+		t.Fatalf("CreateAccount: %v", err)
+	}
+	res = doImport(t, dst, "scope=accounts&strategy=overwrite", dump)
+	if res.Deleted != 2 || res.Imported != 1 || res.Scopes[transferKeys] != nil {
+		t.Fatalf("unexpected overwrite: %+v", res)
+	}
+	if accounts, _ := dst.store.ListAccounts(ctx); len(accounts) != 1 || accounts[0].Name != "router" {
+		t.Fatalf("overwrite should leave only imported accounts: %+v", accounts)
+	}
+}
+
+func TestImportRejectsBadParameters(t *testing.T) {
+	a := newTransferAPI(t)
+	for _, query := range []string{"strategy=merge", "scope=users"} {
+		rec := httptest.NewRecorder()
+		a.HandleImport(rec, httptest.NewRequest(http.MethodPost, "/api/import?"+query, bytes.NewReader([]byte(`{}`))))
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("%s: status=%d want 400", query, rec.Code)
+		}
+	}
+}
+
+func TestImportSettingsMergesConfig(t *testing.T) {
+	a := newTransferAPI(t)
+	body := []byte(`{"version":2,"settings":{"key_tpm_limit":1234}}`)
+
+	res := doImport(t, a, "dry_run=true&strategy=upsert", body)
+	if res.Updated != 1 || len(res.Changes) != 1 || len(res.Changes[0].Fields) != 1 || res.Changes[0].Fields[0] != "key_tpm_limit" {
+		t.Fatalf("unexpected settings dry run: %+v", res)
+	}
+	if a.config.Load().KeyTPMLimit != 0 {
+		t.Fatal("dry run must not change the config")
+	}
+
+	res = doImport(t, a, "", body)
+	if res.Updated != 1 || a.config.Load().KeyTPMLimit != 1234 {
+		t.Fatalf("settings not applied on a fresh instance: %+v", res)
+	}
+	saved, err := a.store.GetSetting(context.Background(), "config")
+	if err != nil || saved == "" {
+		t.Fatalf("settings not persisted: %v", err)
+	}
+
+	res = doImport(t, a, "", []byte(`{"settings":{"key_tpm_limit":1}}`))
+	if res.Skipped != 1 || a.config.Load().KeyTPMLimit != 1234 {
+		t.Fatalf("skip must keep saved settings: %+v", res)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"github.com/goccy/go-json"
 	"log/slog"
+	"strconv"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -14,6 +15,7 @@ type Event struct {
 	Timestamp time.Time              `json:"timestamp"`
 	Action    string                 `json:"action"`
 	AccountID int64                  `json:"account_id,omitempty"`
+	KeyID     int64                  `json:"key_id,omitempty"`
 	Model     string                 `json:"model,omitempty"`
 	Channel   string                 `json:"channel,omitempty"`
 	ClientIP  string                 `json:"client_ip,omitempty"`
@@ -22,16 +24,27 @@ type Event struct {
 	Status    string                 `json:"status"`
 	Error     string                 `json:"error,omitempty"`
 	Metadata  map[string]interface{} `json:"metadata,omitempty"`
+
+	// Prefix, when set, replaces the logger's Redis key prefix so the event
+	// lands in a separate stream (per-tenant / per-key data residency).
+	Prefix string `json:"-"`
 }
 
-// QueryOpts controls audit log queries.
+// QueryOpts controls audit log queries. Action and KeyID, when set, only
+// return matching events; Limit counts matching events. Prefix reads the
+// stream events with that Event.Prefix were written to.
 type QueryOpts struct {
 	Start  time.Time
 	End    time.Time
 	Action string
+	KeyID  int64
 	Limit  int64
+	Prefix string
 }
 
+// queryPageSize is how many stream entries a filtered query reads per round trip.
+const queryPageSize = 500
+
 // Logger is the audit logging interface.
 type Logger interface {
 	Log(ctx context.Context, event Event)
@@ -44,6 +57,7 @@ type Logger interface {
 // RedisLogger writes audit events to a Redis Stream with async buffering.
 type RedisLogger struct {
 	client    *redis.Client
+	prefix    string
 	streamKey string
 	maxLen    int64
 	eventCh   chan Event
@@ -57,7 +71,8 @@ func NewRedisLogger(client *redis.Client, prefix string, maxLen int64) *RedisLog
 	}
 	l := &RedisLogger{
 		client:    client,
-		streamKey: prefix + "audit:log",
+		prefix:    prefix,
+		streamKey: streamKey(prefix),
 		maxLen:    maxLen,
 		eventCh:   make(chan Event, 256),
 		done:      make(chan struct{}),
@@ -66,6 +81,18 @@ func NewRedisLogger(client *redis.Client, prefix string, maxLen int64) *RedisLog
 	return l
 }
 
+func streamKey(prefix string) string {
+	return prefix + "audit:log"
+}
+
+// stream returns the stream key for an Event.Prefix or QueryOpts.Prefix.
+func (l *RedisLogger) stream(prefix string) string {
+	if prefix == "" || prefix == l.prefix {
+		return l.streamKey
+	}
+	return streamKey(prefix)
+}
+
 func (l *RedisLogger) Log(_ context.Context, event Event) {
 	if event.Timestamp.IsZero() {
 		event.Timestamp = time.Now()
@@ -79,38 +106,56 @@ func (l *RedisLogger) Log(_ context.Context, event Event) {
 }
 
 func (l *RedisLogger) Query(ctx context.Context, opts QueryOpts) ([]Event, error) {
+	// Stream IDs start with the entry's unix milliseconds.
 	start := "-"
 	end := "+"
 	if !opts.Start.IsZero() {
-		start = opts.Start.Format(time.RFC3339Nano)
+		start = strconv.FormatInt(opts.Start.UnixMilli(), 10)
 	}
 	if !opts.End.IsZero() {
-		end = opts.End.Format(time.RFC3339Nano)
+		end = strconv.FormatInt(opts.End.UnixMilli(), 10)
 	}
 
-	count := opts.Limit
-	if count <= 0 {
-		count = 100
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = 100
 	}
-
-	msgs, err := l.client.XRevRangeN(ctx, l.streamKey, end, start, count).Result()
-	if err != nil {
-		return nil, err
+	count := limit
+	if opts.Action != "" || opts.KeyID != 0 {
+		count = max(limit, queryPageSize)
 	}
 
-	events := make([]Event, 0, len(msgs))
-	for _, msg := range msgs {
-		data, ok := msg.Values["data"].(string)
-		if !ok {
-			continue
+	events := make([]Event, 0, min(limit, queryPageSize))
+	for {
+		msgs, err := l.client.XRevRangeN(ctx, l.stream(opts.Prefix), end, start, count).Result()
+		if err != nil {
+			return nil, err
+		}
+		for _, msg := range msgs {
+			if opts.Action != "" && msg.Values["action"] != opts.Action {
+				continue
+			}
+			data, ok := msg.Values["data"].(string)
+			if !ok {
+				continue
+			}
+			var ev Event
+			if err := json.Unmarshal([]byte(data), &ev); err != nil {
+				continue
+			}
+			if opts.KeyID != 0 && ev.KeyID != opts.KeyID {
+				continue
+			}
+			events = append(events, ev)
+			if int64(len(events)) >= limit {
+				return events, nil
+			}
 		}
-		var ev Event
-		if err := json.Unmarshal([]byte(data), &ev); err != nil {
-			continue
+		if int64(len(msgs)) < count {
+			return events, nil
 		}
-		events = append(events, ev)
+		end = "(" + msgs[len(msgs)-1].ID
 	}
-	return events, nil
 }
 
 func (l *RedisLogger) Close() {
@@ -121,25 +166,29 @@ func (l *RedisLogger) Close() {
 func (l *RedisLogger) writeLoop() {
 	defer close(l.done)
 	for event := range l.eventCh {
-		data, err := json.Marshal(event)
-		if err != nil {
-			continue
-		}
 		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
-		l.client.XAdd(ctx, &redis.XAddArgs{
-			Stream: l.streamKey,
-			MaxLen: l.maxLen,
-			Approx: true,
-			Values: map[string]interface{}{
-				"data":   string(data),
-				"action": event.Action,
-				"status": event.Status,
-			},
-		}).Err()
+		l.write(ctx, event)
 		cancel()
 	}
 }
 
+func (l *RedisLogger) write(ctx context.Context, event Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return l.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: l.stream(event.Prefix),
+		MaxLen: l.maxLen,
+		Approx: true,
+		Values: map[string]interface{}{
+			"data":   string(data),
+			"action": event.Action,
+			"status": event.Status,
+		},
+	}).Err()
+}
+
 // --- Nop Implementation ---
 
 // NopLogger discards all audit events.
@@ -75,6 +75,50 @@ func TestRedisLoggerTimestamp(t *testing.T) {
 	}
 }
 
+func TestRedisLoggerQueryFilters(t *testing.T) {
+	logger, _ := setupRedisLogger(t)
+	defer logger.Close()
+	ctx := context.Background()
+
+	// More events than the Log buffer holds, so write them directly.
+	for i := 0; i < queryPageSize+20; i++ {
+		if err := logger.write(ctx, Event{Timestamp: time.Now(), Action: "chat_request", KeyID: int64(i%2 + 1), Status: "success"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := logger.write(ctx, Event{Timestamp: time.Now(), Action: "image_generate", KeyID: 1, Status: "success"}); err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := logger.Query(ctx, QueryOpts{Action: "chat_request", KeyID: 2, Limit: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != (queryPageSize+20)/2 {
+		t.Fatalf("expected %d events, got %d", (queryPageSize+20)/2, len(events))
+	}
+	for _, ev := range events {
+		if ev.Action != "chat_request" || ev.KeyID != 2 {
+			t.Fatalf("unexpected event: %+v", ev)
+		}
+	}
+
+	events, err = logger.Query(ctx, QueryOpts{Start: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 0 {
+		t.Fatalf("expected no events after start, got %d", len(events))
+	}
+	events, err = logger.Query(ctx, QueryOpts{Start: time.Now().Add(-time.Hour), Limit: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 5 || events[0].Action != "image_generate" {
+		t.Fatalf("expected the 5 newest events, got %d", len(events))
+	}
+}
+
 func TestNopLogger(t *testing.T) {
 	logger := NewNopLogger()
 	ctx := context.Background()
@@ -90,3 +134,31 @@ func TestNopLogger(t *testing.T) {
 	}
 	logger.Close()
 }
+
+func TestRedisLoggerPrefix(t *testing.T) {
+	logger, s := setupRedisLogger(t)
+	defer logger.Close()
+	ctx := context.Background()
+
+	logger.Log(ctx, Event{Action: "chat_request", KeyID: 1, Status: "success"})
+	logger.Log(ctx, Event{Action: "chat_request", KeyID: 2, Status: "success", Prefix: "tenant-a:"})
+	time.Sleep(100 * time.Millisecond)
+
+	if !s.Exists("tenant-a:audit:log") {
+		t.Fatal("expected the prefixed event in its own stream")
+	}
+	shared, err := logger.Query(ctx, QueryOpts{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shared) != 1 || shared[0].KeyID != 1 {
+		t.Fatalf("shared stream=%+v", shared)
+	}
+	separate, err := logger.Query(ctx, QueryOpts{Limit: 10, Prefix: "tenant-a:"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(separate) != 1 || separate[0].KeyID != 2 {
+		t.Fatalf("prefixed stream=%+v", separate)
+	}
+}
@@ -13,13 +13,18 @@ const (
 	sessionTTL         = 7 * 24 * time.Hour
 )
 
+type session struct {
+	expires  time.Time
+	tenantID int64 // 0 for the global admin
+}
+
 type SessionStore struct {
 	mu       sync.RWMutex
-	sessions map[string]time.Time
+	sessions map[string]session
 }
 
 var globalSessionStore = &SessionStore{
-	sessions: make(map[string]time.Time),
+	sessions: make(map[string]session),
 }
 
 func init() {
@@ -33,6 +38,15 @@ func init() {
 }
 
 func GenerateSessionToken() (string, error) {
+	return newSession(0)
+}
+
+// GenerateTenantSessionToken issues a session for the admin user of a tenant.
+func GenerateTenantSessionToken(tenantID int64) (string, error) {
+	return newSession(tenantID)
+}
+
+func newSession(tenantID int64) (string, error) {
 	bytes := make([]byte, sessionTokenLength)
 	if _, err := rand.Read(bytes); err != nil {
 		return "", fmt.Errorf("failed to generate session token: %w", err)
@@ -40,29 +54,36 @@ func GenerateSessionToken() (string, error) {
 	token := hex.EncodeToString(bytes)
 
 	globalSessionStore.mu.Lock()
-	globalSessionStore.sessions[token] = time.Now().Add(sessionTTL)
+	globalSessionStore.sessions[token] = session{expires: time.Now().Add(sessionTTL), tenantID: tenantID}
 	globalSessionStore.mu.Unlock()
 
 	return token, nil
 }
 
 func ValidateSessionToken(token string) bool {
+	_, ok := SessionTenant(token)
+	return ok
+}
+
+// SessionTenant validates token and returns the tenant its session belongs
+// to (0 for the global admin).
+func SessionTenant(token string) (int64, bool) {
 	globalSessionStore.mu.RLock()
-	expiry, exists := globalSessionStore.sessions[token]
+	sess, exists := globalSessionStore.sessions[token]
 	globalSessionStore.mu.RUnlock()
 
 	if !exists {
-		return false
+		return 0, false
 	}
 
-	if time.Now().After(expiry) {
+	if time.Now().After(sess.expires) {
 		globalSessionStore.mu.Lock()
 		delete(globalSessionStore.sessions, token)
 		globalSessionStore.mu.Unlock()
-		return false
+		return 0, false
 	}
 
-	return true
+	return sess.tenantID, true
 }
 
 func InvalidateSessionToken(token string) {
@@ -71,13 +92,25 @@ func InvalidateSessionToken(token string) {
 	globalSessionStore.mu.Unlock()
 }
 
+// InvalidateTenantSessions logs out every session of a tenant's admin.
+func InvalidateTenantSessions(tenantID int64) {
+	globalSessionStore.mu.Lock()
+	defer globalSessionStore.mu.Unlock()
+
+	for token, sess := range globalSessionStore.sessions {
+		if sess.tenantID == tenantID {
+			delete(globalSessionStore.sessions, token)
+		}
+	}
+}
+
 func CleanupExpiredSessions() {
 	globalSessionStore.mu.Lock()
 	defer globalSessionStore.mu.Unlock()
 
 	now := time.Now()
-	for token, expiry := range globalSessionStore.sessions {
-		if now.After(expiry) {
+	for token, sess := range globalSessionStore.sessions {
+		if now.After(sess.expires) {
 			delete(globalSessionStore.sessions, token)
 		}
 	}
@@ -0,0 +1,87 @@
+package clerk
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ErrReloginRequired means the __client cookie is no longer accepted by Clerk
+// and the account has to be signed in again manually.
+var ErrReloginRequired = errors.New("clerk client cookie expired, re-login required")
+
+// clerkEndpoint is the Clerk frontend API used by the session keep-alive.
+// Tests point it at a local server.
+var clerkEndpoint = ClerkBaseURL
+
+// TouchSessionWithProxy calls the Clerk touch endpoint for sessionID, which
+// extends the session and the __client cookie lifetime. It returns the rotated
+// __client value when Clerk issues one, or "" when the cookie is unchanged.
+func TouchSessionWithProxy(clientCookie, sessionCookie, sessionID string, proxyFunc func(*http.Request) (*url.URL, error)) (string, error) {
+	sessionID = strings.TrimSpace(sessionID)
+	if strings.TrimSpace(clientCookie) == "" || sessionID == "" {
+		return "", fmt.Errorf("client cookie and session id are required")
+	}
+	endpoint := fmt.Sprintf("%s/v1/client/sessions/%s/touch?__clerk_api_version=%s&_clerk_js_version=%s",
+		clerkEndpoint, url.PathEscape(sessionID), ClerkAPIVersion, ClerkJSVersion)
+
+	req, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(""))
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("User-Agent", clerkUserAgent)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Origin", "https://www.orchids.app")
+	req.Header.Set("Referer", "https://www.orchids.app/")
+	req.AddCookie(&http.Cookie{Name: "__client", Value: clientCookie})
+	if strings.TrimSpace(sessionCookie) != "" {
+		req.AddCookie(&http.Cookie{Name: "__session", Value: sessionCookie})
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	if proxyFunc != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.Proxy = proxyFunc
+		client.Transport = transport
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to touch session: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized, http.StatusNotFound:
+		// 401: cookie rejected; 404: the session no longer exists on the client.
+		body, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf("%w: status %d: %s", ErrReloginRequired, resp.StatusCode, string(body))
+	default:
+		body, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, string(body))
+	}
+
+	for _, c := range resp.Cookies() {
+		if c.Name == "__client" && strings.TrimSpace(c.Value) != "" && c.Value != clientCookie {
+			return c.Value, nil
+		}
+	}
+	return "", nil
+}
+
+// IsReloginRequired reports whether err from a Clerk call means the stored
+// cookie is dead rather than a transient failure. A bare 401 from other Clerk
+// calls does not count: it may be transient and gets the usual cooldown.
+func IsReloginRequired(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, ErrReloginRequired) {
+		return true
+	}
+	return strings.Contains(strings.ToLower(err.Error()), "no active sessions")
+}
@@ -0,0 +1,62 @@
+package clerk
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func withClerkEndpoint(t *testing.T, handler http.HandlerFunc) {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	prev := clerkEndpoint
+	clerkEndpoint = srv.URL
+	t.Cleanup(func() { clerkEndpoint = prev })
+}
+
+func TestTouchSessionRotatesCookie(t *testing.T) {
+	withClerkEndpoint(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || !strings.HasPrefix(r.URL.Path, "/v1/client/sessions/sess_1/touch") {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		if c, err := r.Cookie("__client"); err != nil || c.Value != "old" {
+			t.Errorf("missing __client cookie: %v", err)
+		}
+		http.SetCookie(w, &http.Cookie{Name: "__client", Value: "new"})
+		w.Write([]byte(`{}`))
+	})
+
+	rotated, err := TouchSessionWithProxy("old", "", "sess_1", nil)
+	if err != nil {
+		t.Fatalf("TouchSessionWithProxy: %v", err)
+	}
+	if rotated != "new" {
+		t.Fatalf("rotated = %q, want new", rotated)
+	}
+}
+
+func TestTouchSessionReloginRequired(t *testing.T) {
+	withClerkEndpoint(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"errors":[{"code":"authentication_invalid"}]}`, http.StatusUnauthorized)
+	})
+
+	_, err := TouchSessionWithProxy("dead", "", "sess_1", nil)
+	if !errors.Is(err, ErrReloginRequired) || !IsReloginRequired(err) {
+		t.Fatalf("expected relogin error, got %v", err)
+	}
+}
+
+func TestIsReloginRequired(t *testing.T) {
+	if IsReloginRequired(errors.New("unexpected status code 500: oops")) {
+		t.Fatal("5xx must be treated as transient")
+	}
+	if !IsReloginRequired(errors.New("no active sessions found")) {
+		t.Fatal("no active sessions should require re-login")
+	}
+	if IsReloginRequired(errors.New("unexpected status code 401: try again")) {
+		t.Fatal("a bare 401 must be treated as transient")
+	}
+}
@@ -30,8 +30,267 @@ type Config struct {
 	RedisPrefix     string `json:"redis_prefix"`
 	CacheTokenCount bool   `json:"cache_token_count"`
 	CacheTTL        int    `json:"cache_ttl"`
+	CacheMaxBytes   int64  `json:"cache_max_bytes"` // memory token cache byte cap (0 = entry cap only)
 	CacheStrategy   string `json:"cache_strategy"`
 
+	// Per-end-user limits keyed by metadata.user_id / OpenAI user (0 = unlimited)
+	EndUserRPM         int `json:"end_user_rpm"`
+	EndUserDailyTokens int `json:"end_user_daily_tokens"`
+
+	// Concurrency caps on top of concurrency_limit: default per-account cap
+	// (accounts may override it with max_concurrency), per-model caps keyed by
+	// model ID, and how long a request queues for a slot before a 429 (0 = reject
+	// immediately). 0 caps mean unlimited.
+	AccountMaxConcurrency   int            `json:"account_max_concurrency"`
+	ModelMaxConcurrency     map[string]int `json:"model_max_concurrency,omitempty"`
+	ConcurrencyQueueTimeout int            `json:"concurrency_queue_timeout"`
+
+	// Default per-account request pace in requests per minute; requests to an
+	// account are spaced evenly instead of bursting (accounts may override it
+	// with max_rpm, 0 = unpaced)
+	AccountMaxRPM int `json:"account_max_rpm"`
+
+	// Tokens-per-minute caps over a sliding one-minute window; API keys and
+	// accounts may override them with their own tpm_limit (0 = unlimited)
+	KeyTPMLimit     int `json:"key_tpm_limit"`
+	AccountTPMLimit int `json:"account_tpm_limit"`
+
+	// Reverse proxies (addresses or CIDR prefixes) whose X-Forwarded-For is
+	// believed when checking API key allowed_ips; empty = the connection
+	// address only
+	TrustedProxies []string `json:"trusted_proxies"`
+
+	// Reasoning (thinking) tokens are counted within output_tokens.
+	// UsageReasoningTokens also reports them as usage.reasoning_output_tokens
+	// (OpenAI format: completion_tokens_details.reasoning_tokens);
+	// QuotaExcludeReasoningTokens leaves them out of the API key and tenant
+	// monthly quotas and the key TPM cap
+	UsageReasoningTokens        bool `json:"usage_reasoning_tokens"`
+	QuotaExcludeReasoningTokens bool `json:"quota_exclude_reasoning_tokens"`
+
+	// Claude Code meta-requests answered locally: command_prefix, topic, suggestion,
+	// title, compact, or "all" / "none". Unset keeps command_prefix and topic local.
+	LocalMetaRequests []string `json:"local_meta_requests"`
+
+	// Declarative request/response transformation hooks, matched by route prefix
+	Hooks []HookRule `json:"hooks,omitempty"`
+
+	// Builtin secret/PII patterns masked in streamed output (e.g. "aws_access_key", "email", "all")
+	OutputRedaction []string `json:"output_redaction,omitempty"`
+
+	// HTTP server timeouts in seconds. Read/write default to 0 (off) because a
+	// whole-request deadline would cut long SSE responses; the per-request
+	// deadlines below cover those instead.
+	ServerReadHeaderTimeout int `json:"server_read_header_timeout"`
+	ServerReadTimeout       int `json:"server_read_timeout"`
+	ServerWriteTimeout      int `json:"server_write_timeout"`
+	ServerIdleTimeout       int `json:"server_idle_timeout"`
+
+	// Per-request deadlines in seconds: reading the request body, each streamed
+	// write, the total duration of a /messages request, and the time a stream
+	// may stay blocked on a slow client in one stall (0 = unlimited)
+	RequestBodyTimeout int `json:"request_body_timeout"`
+	StreamWriteTimeout int `json:"stream_write_timeout"`
+	MaxStreamDuration  int `json:"max_stream_duration"`
+	MaxClientStall     int `json:"max_client_stall"`
+
+	// Bytes a /messages request may receive from upstream across all its
+	// attempts before the stream is aborted (0 = unlimited)
+	MaxUpstreamResponseBytes int64 `json:"max_upstream_response_bytes"`
+
+	// Flush coalescing for streamed responses: frames are flushed at most
+	// every StreamFlushIntervalMs or once StreamFlushBytes are pending
+	// (0 = flush every frame). Keep-alives and final events flush at once
+	StreamFlushIntervalMs int `json:"stream_flush_interval_ms"`
+	StreamFlushBytes      int `json:"stream_flush_bytes"`
+
+	// How a non-stream response whose upstream failed after partial output is
+	// returned: "end_turn" (default) as if it were complete, "mark" with
+	// stop_reason "upstream_error", an error object and X-Partial-Response
+	PartialResponseMode string `json:"partial_response_mode"`
+
+	// Response headers naming what served a /messages request:
+	// X-Served-By-Channel and X-Served-By-Account, the account as "hash" (a
+	// short HMAC of its ID keyed by ServedBySalt, which "hash" requires) or
+	// "name". Empty disables them
+	ServedByHeaders string `json:"served_by_headers"`
+	ServedBySalt    string `json:"served_by_salt"`
+
+	// Follow-up rounds requested when upstream stops on its output limit
+	// (finish reason length/limit); the continued text is appended to the
+	// same response. 0 disables continuation
+	MaxContinuations int `json:"max_continuations"`
+
+	// Built-in web_search server tool: search API provider ("searxng",
+	// "brave" or "bing"; empty disables), its endpoint (required for
+	// searxng), API key, and results returned per search
+	WebSearchProvider   string `json:"web_search_provider"`
+	WebSearchEndpoint   string `json:"web_search_endpoint"`
+	WebSearchAPIKey     string `json:"web_search_api_key"`
+	WebSearchMaxResults int    `json:"web_search_max_results"`
+
+	// /v1/embeddings backend: provider ("openai" for any OpenAI-compatible
+	// embeddings API, "tei" for a text-embeddings-inference server running a
+	// local ONNX model; empty disables), its endpoint, API key, the model
+	// used when the request names none, and the inputs allowed per request
+	EmbeddingsProvider  string `json:"embeddings_provider"`
+	EmbeddingsEndpoint  string `json:"embeddings_endpoint"`
+	EmbeddingsAPIKey    string `json:"embeddings_api_key"`
+	EmbeddingsModel     string `json:"embeddings_model"`
+	EmbeddingsMaxInputs int    `json:"embeddings_max_inputs"`
+
+	// Built-in code_execution server tool: sandbox runtime ("docker" or
+	// "firejail"; empty disables), per-run time limit in seconds, memory and
+	// CPU limits, network access, the container images for docker, and how
+	// many snippets may run at once (0 means 2)
+	CodeExecutionRuntime     string  `json:"code_execution_runtime"`
+	CodeExecutionTimeout     int     `json:"code_execution_timeout"`
+	CodeExecutionMemoryMB    int     `json:"code_execution_memory_mb"`
+	CodeExecutionCPUs        float64 `json:"code_execution_cpus"`
+	CodeExecutionNetwork     bool    `json:"code_execution_network"`
+	CodeExecutionPythonImage string  `json:"code_execution_python_image"`
+	CodeExecutionNodeImage   string  `json:"code_execution_node_image"`
+	CodeExecutionConcurrency int     `json:"code_execution_concurrency"`
+
+	// Attach a transcript of the gateway-executed tool rounds (inputs and
+	// truncated results) as a "transcript" field of the final response
+	ToolTranscript bool `json:"tool_transcript"`
+
+	// Inject a cached snapshot of the local workdir (file tree, sizes, git
+	// status) into the prompt; snapshots older than the TTL (seconds) are
+	// refreshed in the background. Only workdirs under one of the roots are
+	// snapshotted
+	WorkspaceSnapshot           bool     `json:"workspace_snapshot"`
+	WorkspaceSnapshotTTL        int      `json:"workspace_snapshot_ttl"`
+	WorkspaceSnapshotMaxEntries int      `json:"workspace_snapshot_max_entries"`
+	WorkspaceSnapshotMaxDepth   int      `json:"workspace_snapshot_max_depth"`
+	WorkspaceSnapshotRoots      []string `json:"workspace_snapshot_roots"`
+
+	// Tool gating: requests whose last user turn is at most ToolGateMaxChars
+	// characters (0 disables; API keys may override) and matches none of
+	// ToolGateCodePatterns (regexes; empty = built-in code heuristics) are
+	// answered without tools except ToolGateAllowTools. ToolGateToolResults
+	// (default true) also drops tools on tool_result-only follow-ups
+	ToolGateMaxChars     int      `json:"tool_gate_max_chars"`
+	ToolGateCodePatterns []string `json:"tool_gate_code_patterns,omitempty"`
+	ToolGateAllowTools   []string `json:"tool_gate_allow_tools,omitempty"`
+	ToolGateToolResults  *bool    `json:"tool_gate_tool_results,omitempty"`
+
+	// Prompt hygiene applied to the history before it is sent upstream (API
+	// keys may opt out with skip_prompt_hygiene). <system-reminder> blocks and
+	// IDE context dumps (<ide_opened_file>, <ide_selection>, <ide_diagnostics>)
+	// outside the latest user turn are kept as is ("keep", default), cut to a
+	// one-line stub ("compress") or removed ("strip"). PromptHygieneDedup
+	// replaces text repeated verbatim in a later turn with a short marker;
+	// PromptHygieneSystemDedup replaces system blocks an upstream-kept
+	// conversation already received with a reference to them
+	PromptHygieneSystemReminders string `json:"prompt_hygiene_system_reminders"`
+	PromptHygieneIDEContext      string `json:"prompt_hygiene_ide_context"`
+	PromptHygieneDedup           bool   `json:"prompt_hygiene_dedup"`
+	PromptHygieneSystemDedup     bool   `json:"prompt_hygiene_system_dedup"`
+
+	// Reject requests for unrecognized models with 404 model_not_found
+	// instead of answering them with the default model (API keys may turn
+	// this on individually with strict_models)
+	StrictModels bool `json:"strict_models"`
+
+	// Who may request a debug capture of a single request with
+	// X-Debug-Capture: on while debug_enabled is off: "off" (default),
+	// "admin" (X-Admin-Token must match admin_token/admin_pass) or "keys"
+	// (any authenticated client)
+	DebugCapture string `json:"debug_capture"`
+
+	// Keep each request's debug log in memory while debug_enabled is off and
+	// write it to FailureSnapshotDir when the request ends in an upstream
+	// error or an empty response; the newest FailureSnapshotMax snapshots
+	// younger than FailureSnapshotRetentionDays are kept
+	FailureSnapshots             bool   `json:"failure_snapshots"`
+	FailureSnapshotDir           string `json:"failure_snapshot_dir"`
+	FailureSnapshotMax           int    `json:"failure_snapshot_max"`
+	FailureSnapshotRetentionDays int    `json:"failure_snapshot_retention_days"`
+
+	// Per-account and per-channel anomaly detection over the last
+	// AnomalyWindow seconds: once AnomalyMinRequests requests were served, a
+	// rate of requests slower than SlowRequestMs, of empty responses, or of
+	// requests running ToolLoopRounds or more tool rounds that reaches its
+	// threshold (0 = off) is logged and posted to AnomalyWebhookURL, at most
+	// once per AnomalyCooldown seconds per metric; /api/accounts reports
+	// each account's health score
+	AnomalyWebhookURL  string  `json:"anomaly_webhook_url"`
+	AnomalyWindow      int     `json:"anomaly_window"`
+	AnomalyMinRequests int     `json:"anomaly_min_requests"`
+	AnomalyCooldown    int     `json:"anomaly_cooldown"`
+	SlowRequestMs      int     `json:"slow_request_ms"`
+	SlowRequestRate    float64 `json:"slow_request_rate"`
+	EmptyResponseRate  float64 `json:"empty_response_rate"`
+	ToolLoopRounds     int     `json:"tool_loop_rounds"`
+	ToolLoopRate       float64 `json:"tool_loop_rate"`
+
+	// Scale the load balancer weight of accounts whose health score (recent
+	// errors, latency and quota) falls below HealthWeightLow down to score/100
+	// of it, but no less than HealthWeightMin, until it is back at
+	// HealthWeightHigh
+	HealthWeighting  bool    `json:"health_weighting"`
+	HealthWeightLow  int     `json:"health_weight_low"`
+	HealthWeightHigh int     `json:"health_weight_high"`
+	HealthWeightMin  float64 `json:"health_weight_min"`
+
+	// Requests sent with "Prefer: respond-async" run on AsyncWorkers
+	// background workers (0 = async mode off) with up to AsyncQueueSize
+	// waiting; results are kept for AsyncResultTTL seconds. A key may have
+	// AsyncMaxPerKey operations queued or running (0 means 10). Webhooks
+	// go to public addresses only, or only to AsyncWebhookAllowedHosts
+	// when it is set.
+	AsyncWorkers             int      `json:"async_workers"`
+	AsyncQueueSize           int      `json:"async_queue_size"`
+	AsyncResultTTL           int      `json:"async_result_ttl"`
+	AsyncMaxPerKey           int      `json:"async_max_per_key"`
+	AsyncWebhookAllowedHosts []string `json:"async_webhook_allowed_hosts"`
+
+	// Seconds completed non-stream responses stay retrievable through
+	// GET /v1/messages/{id} (0 = not kept)
+	ResultCacheTTL int `json:"result_cache_ttl"`
+
+	// Hours per-conversation token usage (X-Conversation-* headers,
+	// /api/conversations/{id}/usage) is kept after the conversation's last
+	// request (0 = 24)
+	ConversationUsageTTL int `json:"conversation_usage_ttl"`
+
+	// Optional gRPC listener (h2c), e.g. ":9090"; empty disables it
+	GRPCAddr string `json:"grpc_addr"`
+
+	// Hours cached grok images/videos are served before being pruned (0 = keep forever)
+	GrokMediaCacheTTL int `json:"grok_media_cache_ttl"`
+
+	// Accounts whose JWT access token expires within TokenExpiryWindow
+	// seconds are refreshed ahead of time by the token_expiry job, or flagged
+	// when they can't be; /api/accounts shows the countdown
+	TokenExpiryWindow int `json:"token_expiry_window"`
+
+	// Seconds between runs of the scheduled jobs listed at /api/jobs, by job
+	// name, overriding their built-in intervals (<0 = only when triggered)
+	JobIntervals map[string]int `json:"job_intervals"`
+
+	// Grok /images/generations fan-out: parallel upstream calls, per-account
+	// images-per-minute cap and attempt budget (0 = 1 worker / unlimited / max(4, n*4))
+	GrokImageWorkers     int `json:"grok_image_workers"`
+	GrokImagesPerMinute  int `json:"grok_images_per_minute"`
+	GrokImageMaxAttempts int `json:"grok_image_max_attempts"`
+
+	// Tool result compression: rules match a channel and/or tool name (empty
+	// = any; channel+tool beats tool beats channel) and pick a strategy:
+	// "truncate" (default), "head_tail" (keep the start and the end, where
+	// errors usually are), "json_prune" (shorten long strings and arrays in
+	// JSON results) or "summarize" (ask the current upstream to condense the
+	// result, head_tail if that fails). Warp always compresses (1800 bytes by
+	// default); other channels only when some rule applies to them
+	ToolResultCompression []ToolResultCompressionRule `json:"tool_result_compression,omitempty"`
+
+	// Orchids project pool per account (0 = always use the account's own project).
+	// Inert until a project creation endpoint is known, see orchids.ProjectCreator
+	OrchidsProjectPoolSize    int `json:"orchids_project_pool_size"`
+	OrchidsProjectMaxRequests int `json:"orchids_project_max_requests"`
+
 	// ── Per-client state (used by orchids client, not configurable) ──
 	SessionID     string `json:"-"`
 	ClientCookie  string `json:"-"`
@@ -100,6 +359,34 @@ type Config struct {
 	PublicEnabled             *bool    `json:"-"`
 }
 
+// HookRule configures a declarative transformation hook for routes starting with Route.
+type HookRule struct {
+	Name         string       `json:"name"`
+	Route        string       `json:"route"`
+	InjectSystem string       `json:"inject_system,omitempty"`
+	StripFields  []string     `json:"strip_fields,omitempty"`
+	Redact       []RedactRule `json:"redact,omitempty"`
+	// Lua script defining transform_request and/or transform_output, inline
+	// or read from ScriptFile at startup.
+	Script     string `json:"script,omitempty"`
+	ScriptFile string `json:"script_file,omitempty"`
+}
+
+// ToolResultCompressionRule selects how tool_result blocks are compressed
+// for a channel and/or tool name and the size they are compressed to.
+type ToolResultCompressionRule struct {
+	Channel  string `json:"channel,omitempty"`
+	Tool     string `json:"tool,omitempty"`
+	Strategy string `json:"strategy"`
+	MaxBytes int    `json:"max_bytes,omitempty"`
+}
+
+// RedactRule replaces every match of Pattern in outgoing text with Replacement.
+type RedactRule struct {
+	Pattern     string `json:"pattern"`
+	Replacement string `json:"replacement"`
+}
+
 func Load(path string) (*Config, string, error) {
 	resolvedPath, err := resolveConfigPath(path)
 	if err != nil {
@@ -173,6 +460,9 @@ func ApplyDefaults(cfg *Config) {
 	if cfg.AdminPath == "" {
 		cfg.AdminPath = "/admin"
 	}
+	if strings.EqualFold(strings.TrimSpace(cfg.ServedByHeaders), "hash") && cfg.ServedBySalt == "" {
+		slog.Warn("served_by_headers 为 hash 但未设置 served_by_salt，已关闭 X-Served-By 响应头")
+	}
 	if cfg.StoreMode == "" {
 		cfg.StoreMode = "redis"
 	}
@@ -185,6 +475,69 @@ func ApplyDefaults(cfg *Config) {
 	if strings.TrimSpace(cfg.CacheStrategy) == "" {
 		cfg.CacheStrategy = "mix"
 	}
+	if cfg.OrchidsProjectMaxRequests <= 0 {
+		cfg.OrchidsProjectMaxRequests = 50
+	}
+	if cfg.ServerReadHeaderTimeout <= 0 {
+		cfg.ServerReadHeaderTimeout = 10
+	}
+	if cfg.ServerIdleTimeout <= 0 {
+		cfg.ServerIdleTimeout = 60
+	}
+	if cfg.RequestBodyTimeout <= 0 {
+		cfg.RequestBodyTimeout = 30
+	}
+	if cfg.StreamWriteTimeout <= 0 {
+		cfg.StreamWriteTimeout = 60
+	}
+	if cfg.WebSearchMaxResults <= 0 {
+		cfg.WebSearchMaxResults = 5
+	}
+	if cfg.EmbeddingsMaxInputs <= 0 {
+		cfg.EmbeddingsMaxInputs = 256
+	}
+	if strings.TrimSpace(cfg.FailureSnapshotDir) == "" {
+		cfg.FailureSnapshotDir = "debug-failures"
+	}
+	if cfg.FailureSnapshotMax <= 0 {
+		cfg.FailureSnapshotMax = 100
+	}
+	if cfg.FailureSnapshotRetentionDays <= 0 {
+		cfg.FailureSnapshotRetentionDays = 7
+	}
+	if cfg.TokenExpiryWindow <= 0 {
+		cfg.TokenExpiryWindow = 600
+	}
+	if cfg.AnomalyWindow <= 0 {
+		cfg.AnomalyWindow = 300
+	}
+	if cfg.AnomalyMinRequests <= 0 {
+		cfg.AnomalyMinRequests = 10
+	}
+	if cfg.AnomalyCooldown <= 0 {
+		cfg.AnomalyCooldown = 900
+	}
+	if cfg.SlowRequestMs <= 0 {
+		cfg.SlowRequestMs = 120000
+	}
+	if cfg.ToolLoopRounds <= 0 {
+		cfg.ToolLoopRounds = 25
+	}
+	if cfg.HealthWeightLow <= 0 {
+		cfg.HealthWeightLow = 50
+	}
+	if cfg.HealthWeightHigh <= 0 {
+		cfg.HealthWeightHigh = 80
+	}
+	if cfg.HealthWeightMin <= 0 {
+		cfg.HealthWeightMin = 0.1
+	}
+	if cfg.AsyncQueueSize <= 0 {
+		cfg.AsyncQueueSize = 100
+	}
+	if cfg.AsyncResultTTL <= 0 {
+		cfg.AsyncResultTTL = 3600
+	}
 	// Always apply hardcoded values
 	ApplyHardcoded(cfg)
 }
@@ -263,6 +616,123 @@ func (c *Config) PublicImagineMediumMinBytes() int {
 	return c.ImageMediumMinBytes
 }
 
+// MarkPartialResponses reports whether truncated non-stream responses are
+// flagged rather than returned as complete.
+func (c *Config) MarkPartialResponses() bool {
+	return c != nil && strings.EqualFold(strings.TrimSpace(c.PartialResponseMode), "mark")
+}
+
+// ServedByMode returns served_by_headers normalized to "hash", "name" or ""
+// (off); unknown values count as off, and so does "hash" without a
+// served_by_salt, since an unkeyed hash of a small account ID is reversible.
+func (c *Config) ServedByMode() string {
+	if c == nil {
+		return ""
+	}
+	switch mode := strings.ToLower(strings.TrimSpace(c.ServedByHeaders)); mode {
+	case "hash":
+		if c.ServedBySalt == "" {
+			return ""
+		}
+		return mode
+	case "name":
+		return mode
+	}
+	return ""
+}
+
+// GateToolResultFollowups reports whether tools are dropped on follow-ups
+// that only carry tool results.
+func (c *Config) GateToolResultFollowups() bool {
+	if c == nil || c.ToolGateToolResults == nil {
+		return true
+	}
+	return *c.ToolGateToolResults
+}
+
+// LocalMetaRequestEnabled reports whether the given meta-request kind should be
+// answered locally instead of being forwarded upstream.
+func (c *Config) LocalMetaRequestEnabled(kind string) bool {
+	if c == nil || c.LocalMetaRequests == nil {
+		return kind == "command_prefix" || kind == "topic"
+	}
+	for _, k := range c.LocalMetaRequests {
+		k = strings.ToLower(strings.TrimSpace(k))
+		if k == "all" || k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// ModelConcurrencyLimit returns the model_max_concurrency cap for model
+// (matched case-insensitively), or 0 when the model is uncapped.
+func (c *Config) ModelConcurrencyLimit(model string) int {
+	if c == nil || len(c.ModelMaxConcurrency) == 0 {
+		return 0
+	}
+	model = strings.TrimSpace(model)
+	if limit, ok := c.ModelMaxConcurrency[model]; ok {
+		return limit
+	}
+	for k, limit := range c.ModelMaxConcurrency {
+		if strings.EqualFold(strings.TrimSpace(k), model) {
+			return limit
+		}
+	}
+	return 0
+}
+
+// ToolResultCompressionFor returns the most specific tool_result_compression
+// rule for channel and tool (matched case-insensitively). Rules naming both
+// win over rules naming only the tool, which win over rules naming only the
+// channel; a rule naming neither applies everywhere.
+func (c *Config) ToolResultCompressionFor(channel, tool string) (ToolResultCompressionRule, bool) {
+	if c == nil || len(c.ToolResultCompression) == 0 {
+		return ToolResultCompressionRule{}, false
+	}
+	channel = strings.TrimSpace(channel)
+	tool = strings.TrimSpace(tool)
+	best, bestScore := ToolResultCompressionRule{}, -1
+	for _, rule := range c.ToolResultCompression {
+		ruleChannel := strings.TrimSpace(rule.Channel)
+		ruleTool := strings.TrimSpace(rule.Tool)
+		if ruleChannel != "" && !strings.EqualFold(ruleChannel, channel) {
+			continue
+		}
+		if ruleTool != "" && !strings.EqualFold(ruleTool, tool) {
+			continue
+		}
+		score := 0
+		if ruleTool != "" {
+			score += 2
+		}
+		if ruleChannel != "" {
+			score++
+		}
+		if score > bestScore {
+			best, bestScore = rule, score
+		}
+	}
+	return best, bestScore >= 0
+}
+
+// CompressesToolResults reports whether any tool_result_compression rule
+// applies to channel.
+func (c *Config) CompressesToolResults(channel string) bool {
+	if c == nil {
+		return false
+	}
+	channel = strings.TrimSpace(channel)
+	for _, rule := range c.ToolResultCompression {
+		ruleChannel := strings.TrimSpace(rule.Channel)
+		if ruleChannel == "" || strings.EqualFold(ruleChannel, channel) {
+			return true
+		}
+	}
+	return false
+}
+
 func (c *Config) PublicAPIKey() string {
 	if c == nil {
 		return ""
@@ -95,3 +95,56 @@ func TestApplyDefaultsPreservesConfigurableFields(t *testing.T) {
 		t.Fatalf("RedisAddr=%q want=redis:6380", cfg.RedisAddr)
 	}
 }
+
+func TestLocalMetaRequestEnabled(t *testing.T) {
+	var cfg Config
+	if !cfg.LocalMetaRequestEnabled("topic") || !cfg.LocalMetaRequestEnabled("command_prefix") {
+		t.Fatal("topic and command_prefix should be local by default")
+	}
+	if cfg.LocalMetaRequestEnabled("compact") {
+		t.Fatal("compact should not be local by default")
+	}
+	cfg.LocalMetaRequests = []string{"none"}
+	if cfg.LocalMetaRequestEnabled("topic") {
+		t.Fatal("none should disable local handling")
+	}
+	cfg.LocalMetaRequests = []string{"All"}
+	if !cfg.LocalMetaRequestEnabled("compact") {
+		t.Fatal("all should enable every kind")
+	}
+}
+
+func TestToolResultCompressionFor(t *testing.T) {
+	cfg := Config{ToolResultCompression: []ToolResultCompressionRule{
+		{Strategy: "truncate"},
+		{Channel: "warp", Strategy: "head_tail"},
+		{Tool: "Bash", Strategy: "summarize"},
+		{Channel: "warp", Tool: "Read", Strategy: "json_prune"},
+	}}
+	cases := []struct {
+		channel, tool, want string
+	}{
+		{"orchids", "Grep", "truncate"},
+		{"Warp", "Grep", "head_tail"},
+		{"warp", "bash", "summarize"},
+		{"warp", "Read", "json_prune"},
+		{"orchids", "Read", "truncate"},
+	}
+	for _, tc := range cases {
+		rule, ok := cfg.ToolResultCompressionFor(tc.channel, tc.tool)
+		if !ok || rule.Strategy != tc.want {
+			t.Fatalf("ToolResultCompressionFor(%q, %q)=%q,%v want %q", tc.channel, tc.tool, rule.Strategy, ok, tc.want)
+		}
+	}
+
+	if !cfg.CompressesToolResults("orchids") {
+		t.Fatal("rules without a channel should apply to every channel")
+	}
+	cfg.ToolResultCompression = []ToolResultCompressionRule{cfg.ToolResultCompression[1], cfg.ToolResultCompression[3]}
+	if cfg.CompressesToolResults("orchids") {
+		t.Fatal("warp-only rules should not apply to orchids")
+	}
+	if !cfg.CompressesToolResults("warp") {
+		t.Fatal("warp rules should apply")
+	}
+}
@@ -0,0 +1,220 @@
+// Package conformance replays recorded upstream event streams through the
+// real Messages handler and compares the client-facing output with golden
+// files, so changes to the Anthropic/OpenAI event mapping show up as diffs.
+//
+// A fixture (testdata/fixtures/<name>.json) holds the client request and the
+// upstream events it was answered with; its golden file
+// (testdata/golden/<name>.golden) holds the normalized response body.
+// cmd/conformance records new fixtures; `go test ./internal/conformance
+// -update` rewrites the golden files after an intended change.
+package conformance
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/goccy/go-json"
+
+	"orchids-api/internal/config"
+	"orchids-api/internal/debug"
+	"orchids-api/internal/handler"
+	"orchids-api/internal/upstream"
+)
+
+// Fixture is one recorded exchange.
+type Fixture struct {
+	// Name is the file name without extension; it is not stored.
+	Name string `json:"-"`
+	// Description says what the fixture covers.
+	Description string `json:"description,omitempty"`
+	// Path is the route the request is sent to (default /v1/messages).
+	Path string `json:"path,omitempty"`
+	// Request is the client request body.
+	Request json.RawMessage `json:"request"`
+	// Upstream are the upstream events replayed for every upstream call.
+	Upstream []upstream.SSEMessage `json:"upstream"`
+}
+
+// LoadFixture reads a fixture file.
+func LoadFixture(path string) (*Fixture, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f Fixture
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	f.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	return &f, nil
+}
+
+// LoadFixtures reads every fixture in dir, in file name order.
+func LoadFixtures(dir string) ([]*Fixture, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	fixtures := make([]*Fixture, 0, len(paths))
+	for _, path := range paths {
+		f, err := LoadFixture(path)
+		if err != nil {
+			return nil, err
+		}
+		fixtures = append(fixtures, f)
+	}
+	return fixtures, nil
+}
+
+// Save writes f to dir/<f.Name>.json.
+func (f *Fixture) Save(dir string) error {
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, f.Name+".json"), append(data, '\n'), 0o644)
+}
+
+// Replay is an upstream client that answers every call with the same
+// recorded events.
+type Replay struct {
+	Events []upstream.SSEMessage
+}
+
+func (r *Replay) SendRequest(ctx context.Context, prompt string, chatHistory []interface{}, model string, onMessage func(upstream.SSEMessage), logger *debug.Logger) error {
+	return r.replay(ctx, onMessage)
+}
+
+func (r *Replay) SendRequestWithPayload(ctx context.Context, req upstream.UpstreamRequest, onMessage func(upstream.SSEMessage), logger *debug.Logger) error {
+	return r.replay(ctx, onMessage)
+}
+
+func (r *Replay) replay(ctx context.Context, onMessage func(upstream.SSEMessage)) error {
+	for _, msg := range r.Events {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		onMessage(msg)
+	}
+	return nil
+}
+
+// Recorder wraps an upstream client and keeps the events of its first call.
+type Recorder struct {
+	Client handler.UpstreamClient
+
+	mu     sync.Mutex
+	calls  int
+	events []upstream.SSEMessage
+}
+
+func (r *Recorder) SendRequest(ctx context.Context, prompt string, chatHistory []interface{}, model string, onMessage func(upstream.SSEMessage), logger *debug.Logger) error {
+	return r.Client.SendRequest(ctx, prompt, chatHistory, model, r.record(onMessage), logger)
+}
+
+func (r *Recorder) SendRequestWithPayload(ctx context.Context, req upstream.UpstreamRequest, onMessage func(upstream.SSEMessage), logger *debug.Logger) error {
+	if pc, ok := r.Client.(handler.UpstreamPayloadClient); ok {
+		return pc.SendRequestWithPayload(ctx, req, r.record(onMessage), logger)
+	}
+	return r.Client.SendRequest(ctx, req.Prompt, req.ChatHistory, req.Model, r.record(onMessage), logger)
+}
+
+func (r *Recorder) record(onMessage func(upstream.SSEMessage)) func(upstream.SSEMessage) {
+	r.mu.Lock()
+	r.calls++
+	first := r.calls == 1
+	r.mu.Unlock()
+	return func(msg upstream.SSEMessage) {
+		if first {
+			r.mu.Lock()
+			r.events = append(r.events, upstream.SSEMessage{Type: msg.Type, Event: msg.Event})
+			r.mu.Unlock()
+		}
+		onMessage(msg)
+	}
+}
+
+// Events returns the events recorded so far.
+func (r *Recorder) Events() []upstream.SSEMessage {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]upstream.SSEMessage(nil), r.events...)
+}
+
+// Result is the normalized response to a fixture's request.
+type Result struct {
+	Status int
+	Body   []byte
+}
+
+// Run sends the fixture's request through a fresh handler backed by client
+// and returns the normalized response.
+func Run(f *Fixture, client handler.UpstreamClient) *Result {
+	cfg := &config.Config{AdminPass: "conformance"}
+	config.ApplyDefaults(cfg)
+
+	h := handler.NewWithLoadBalancer(cfg, nil)
+	h.SetUpstreamClient(client)
+
+	path := f.Path
+	if path == "" {
+		path = "/v1/messages"
+	}
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "http://conformance"+path, bytes.NewReader(f.Request))
+	req.Header.Set("Content-Type", "application/json")
+	h.HandleMessages(rec, req)
+	return &Result{Status: rec.Code, Body: Normalize(rec.Body.Bytes())}
+}
+
+// RunFixture replays f's recorded upstream events.
+func RunFixture(f *Fixture) *Result {
+	return Run(f, &Replay{Events: f.Upstream})
+}
+
+// Golden renders r in the golden file format: the status line followed by
+// the body.
+func (r *Result) Golden() []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "status: %d\n\n", r.Status)
+	buf.Write(r.Body)
+	if r.Body != nil && !bytes.HasSuffix(r.Body, []byte("\n")) {
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+// GoldenPath returns the golden file of f under dir.
+func GoldenPath(dir string, f *Fixture) string {
+	return filepath.Join(dir, f.Name+".golden")
+}
+
+// volatile matches the parts of a response that change between runs:
+// generated IDs and timestamps.
+var volatile = []struct {
+	re   *regexp.Regexp
+	repl string
+}{
+	{regexp.MustCompile(`"(msg|toolu|srvtoolu|chatcmpl|call)([_-])[A-Za-z0-9_-]+"`), `"${1}${2}X"`},
+	{regexp.MustCompile(`"(created|created_at)":\d+`), `"${1}":0`},
+}
+
+// Normalize replaces generated IDs and timestamps in body with fixed
+// placeholders.
+func Normalize(body []byte) []byte {
+	for _, v := range volatile {
+		body = v.re.ReplaceAll(body, []byte(v.repl))
+	}
+	return body
+}
@@ -0,0 +1,53 @@
+package conformance
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files")
+
+func TestGoldenStreams(t *testing.T) {
+	fixtures, err := LoadFixtures(filepath.Join("testdata", "fixtures"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fixtures) == 0 {
+		t.Fatal("no fixtures")
+	}
+	goldenDir := filepath.Join("testdata", "golden")
+	for _, f := range fixtures {
+		t.Run(f.Name, func(t *testing.T) {
+			got := RunFixture(f).Golden()
+			path := GoldenPath(goldenDir, f)
+			if *update {
+				if err := os.WriteFile(path, got, 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("%v (run with -update to create it)", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("output differs from %s (run with -update if intended)\n--- got ---\n%s\n--- want ---\n%s", path, got, want)
+			}
+		})
+	}
+}
+
+func TestReplayIsDeterministic(t *testing.T) {
+	fixtures, err := LoadFixtures(filepath.Join("testdata", "fixtures"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range fixtures {
+		if a, b := RunFixture(f).Golden(), RunFixture(f).Golden(); !bytes.Equal(a, b) {
+			t.Errorf("%s: two replays differ\n%s\n---\n%s", f.Name, a, b)
+		}
+	}
+}
@@ -1,16 +1,21 @@
 package debug
 
 import (
+	"bytes"
 	"crypto/rand"
 	"encoding/hex"
 	"fmt"
 	"github.com/goccy/go-json"
 	"os"
 	"path/filepath"
+	"sort"
 	"sync"
 	"time"
 )
 
+// maxBufferedBytes 限制缓冲模式下单个请求在内存中保留的日志大小
+const maxBufferedBytes = 4 << 20
+
 // Logger 调试日志记录器
 type Logger struct {
 	enabled    bool
@@ -20,22 +25,30 @@ type Logger struct {
 	outFile    *os.File
 	mu         sync.Mutex
 	startTime  time.Time
+
+	// 缓冲模式：日志先保存在内存中，只有 Persist 时才落盘
+	buffered        bool
+	files           map[string]*bytes.Buffer
+	fileOrder       []string
+	bufferedBytes   int
+	bufferTruncated bool
 }
 
+// DefaultDir 是调试日志的默认根目录
+const DefaultDir = "debug-logs"
+
 // New 创建新的调试日志记录器
 func New(enabled bool, sseEnabled bool) *Logger {
+	return NewIn(DefaultDir, enabled, sseEnabled)
+}
+
+// NewIn 与 New 相同，但日志写入 root 下（用于按租户 / API Key 隔离存储）
+func NewIn(root string, enabled bool, sseEnabled bool) *Logger {
 	if !enabled {
 		return &Logger{enabled: false}
 	}
 
-	now := time.Now()
-	timestamp := now.Format("2006-01-02_15-04-05.000")
-	suffix := "0000"
-	var randBytes [2]byte
-	if _, err := rand.Read(randBytes[:]); err == nil {
-		suffix = hex.EncodeToString(randBytes[:])
-	}
-	dir := filepath.Join("debug-logs", fmt.Sprintf("%s_%s", timestamp, suffix))
+	dir := filepath.Join(root, snapshotName(time.Now()))
 	if err := os.MkdirAll(dir, 0755); err != nil {
 		return &Logger{enabled: false}
 	}
@@ -48,17 +61,37 @@ func New(enabled bool, sseEnabled bool) *Logger {
 	}
 }
 
+// NewBuffered 创建只在内存中记录的调试日志记录器，请求失败时由 Persist 落盘
+func NewBuffered(sseEnabled bool) *Logger {
+	return &Logger{
+		enabled:    true,
+		sseEnabled: sseEnabled,
+		buffered:   true,
+		files:      make(map[string]*bytes.Buffer),
+		startTime:  time.Now(),
+	}
+}
+
+func snapshotName(now time.Time) string {
+	suffix := "0000"
+	var randBytes [2]byte
+	if _, err := rand.Read(randBytes[:]); err == nil {
+		suffix = hex.EncodeToString(randBytes[:])
+	}
+	return fmt.Sprintf("%s_%s", now.Format("2006-01-02_15-04-05.000"), suffix)
+}
+
 // CleanupAllLogs 清空所有调试日志（启动时调用）
 func CleanupAllLogs() error {
-	if err := os.RemoveAll("debug-logs"); err != nil {
+	if err := os.RemoveAll(DefaultDir); err != nil {
 		return err
 	}
-	return os.MkdirAll("debug-logs", 0755)
+	return os.MkdirAll(DefaultDir, 0755)
 }
 
-// Dir 返回日志目录
+// Dir 返回日志目录（缓冲模式下为空）
 func (l *Logger) Dir() string {
-	if !l.enabled {
+	if !l.enabled || l.buffered {
 		return ""
 	}
 	return l.dir
@@ -135,6 +168,11 @@ func (l *Logger) LogUpstreamSSE(eventType string, data string) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
+	elapsed := time.Since(l.startTime).Milliseconds()
+	if l.buffered {
+		l.appendLocked("4_upstream_sse.jsonl", fmt.Sprintf("[%dms] %s: %s\n", elapsed, eventType, data), false)
+		return
+	}
 	if l.rawFile == nil {
 		f, err := os.OpenFile(filepath.Join(l.dir, "4_upstream_sse.jsonl"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 		if err != nil {
@@ -143,10 +181,14 @@ func (l *Logger) LogUpstreamSSE(eventType string, data string) {
 		l.rawFile = f
 	}
 
-	elapsed := time.Since(l.startTime).Milliseconds()
 	fmt.Fprintf(l.rawFile, "[%dms] %s: %s\n", elapsed, eventType, data)
 }
 
+// OutputSSEEnabled 报告 LogOutputSSE 是否会实际写入，便于调用方跳过无用的格式化。
+func (l *Logger) OutputSSEEnabled() bool {
+	return l != nil && l.enabled && l.sseEnabled
+}
+
 // LogOutputSSE 记录 5. 转换给客户端的 SSE（追加写入）
 func (l *Logger) LogOutputSSE(event string, data string) {
 	if !l.enabled || !l.sseEnabled {
@@ -156,6 +198,11 @@ func (l *Logger) LogOutputSSE(event string, data string) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
+	elapsed := time.Since(l.startTime).Milliseconds()
+	if l.buffered {
+		l.appendLocked("5_client_sse.jsonl", fmt.Sprintf("[%dms] event: %s\ndata: %s\n\n", elapsed, event, data), false)
+		return
+	}
 	if l.outFile == nil {
 		f, err := os.OpenFile(filepath.Join(l.dir, "5_client_sse.jsonl"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 		if err != nil {
@@ -164,7 +211,6 @@ func (l *Logger) LogOutputSSE(event string, data string) {
 		l.outFile = f
 	}
 
-	elapsed := time.Since(l.startTime).Milliseconds()
 	fmt.Fprintf(l.outFile, "[%dms] event: %s\ndata: %s\n\n", elapsed, event, data)
 }
 
@@ -211,12 +257,111 @@ func (l *Logger) writeJSON(filename string, data interface{}) {
 	if err != nil {
 		return
 	}
-	os.WriteFile(filepath.Join(l.dir, filename), jsonData, 0644)
+	l.writeFile(filename, string(jsonData))
 }
 
 func (l *Logger) writeFile(filename string, content string) {
 	if !l.enabled {
 		return
 	}
+	if l.buffered {
+		l.mu.Lock()
+		l.appendLocked(filename, content, true)
+		l.mu.Unlock()
+		return
+	}
 	os.WriteFile(filepath.Join(l.dir, filename), []byte(content), 0644)
 }
+
+// appendLocked 在缓冲模式下写入内存文件；replace 为 true 时覆盖原内容
+func (l *Logger) appendLocked(filename, content string, replace bool) {
+	buf, ok := l.files[filename]
+	if !ok {
+		buf = &bytes.Buffer{}
+		l.files[filename] = buf
+		l.fileOrder = append(l.fileOrder, filename)
+	}
+	if replace {
+		l.bufferedBytes -= buf.Len()
+		buf.Reset()
+	}
+	if l.bufferedBytes+len(content) > maxBufferedBytes {
+		l.bufferTruncated = true
+		return
+	}
+	buf.WriteString(content)
+	l.bufferedBytes += len(content)
+}
+
+// Persist 把缓冲的日志连同失败原因写入 root 下的新目录并返回该目录；
+// 非缓冲模式下不做任何事
+func (l *Logger) Persist(root, reason string, details map[string]interface{}) (string, error) {
+	if l == nil || !l.buffered {
+		return "", nil
+	}
+	dir := filepath.Join(root, snapshotName(time.Now()))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	failure := map[string]interface{}{
+		"reason":     reason,
+		"elapsed_ms": time.Since(l.startTime).Milliseconds(),
+		"truncated":  l.bufferTruncated,
+	}
+	if details != nil {
+		failure["details"] = details
+	}
+	data, err := json.MarshalIndent(failure, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "0_failure.json"), data, 0644); err != nil {
+		return "", err
+	}
+	for _, name := range l.fileOrder {
+		if err := os.WriteFile(filepath.Join(dir, name), l.files[name].Bytes(), 0644); err != nil {
+			return "", err
+		}
+	}
+	return dir, nil
+}
+
+// PruneSnapshots 删除 root 下超过 maxAge 的快照目录，并只保留最新的 maxCount 个
+// （0 表示不限制）
+func PruneSnapshots(root string, maxCount int, maxAge time.Duration) error {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	type snapshot struct {
+		name    string
+		modTime time.Time
+	}
+	var snaps []snapshot
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		snaps = append(snaps, snapshot{name: e.Name(), modTime: info.ModTime()})
+	}
+	sort.Slice(snaps, func(i, j int) bool { return snaps[i].modTime.After(snaps[j].modTime) })
+	cutoff := time.Now().Add(-maxAge)
+	for i, s := range snaps {
+		if (maxCount > 0 && i >= maxCount) || (maxAge > 0 && s.modTime.Before(cutoff)) {
+			if err := os.RemoveAll(filepath.Join(root, s.name)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
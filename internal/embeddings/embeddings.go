@@ -0,0 +1,185 @@
+// Package embeddings computes text embeddings through a configurable backend:
+// an OpenAI-compatible embeddings API (OpenAI, or a local server such as
+// Ollama or llama.cpp) or a text-embeddings-inference server running a local
+// ONNX model.
+package embeddings
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-json"
+
+	"orchids-api/internal/util"
+)
+
+const (
+	ProviderOpenAI = "openai"
+	ProviderTEI    = "tei"
+
+	defaultOpenAIEndpoint = "https://api.openai.com/v1"
+	requestTimeout        = 60 * time.Second
+	maxResponseBytes      = 64 << 20
+)
+
+// Request is one embeddings call.
+type Request struct {
+	Model  string
+	Inputs []string
+	// Dimensions asks for shortened vectors; 0 keeps the model's size.
+	Dimensions int
+}
+
+// Result holds one vector per input, in input order.
+type Result struct {
+	Vectors [][]float32
+	// Model is the model that produced the vectors, when the backend reports it.
+	Model string
+	// PromptTokens is the backend's token count, or 0 when it reports none.
+	PromptTokens int
+}
+
+// Embedder computes embeddings for a batch of inputs.
+type Embedder interface {
+	Embed(ctx context.Context, req Request) (*Result, error)
+}
+
+// Options configures the embeddings backend.
+type Options struct {
+	Provider string
+	Endpoint string
+	APIKey   string
+}
+
+// New returns an Embedder for the configured provider, or nil when Provider
+// is empty.
+func New(opts Options) (Embedder, error) {
+	provider := strings.ToLower(strings.TrimSpace(opts.Provider))
+	endpoint := strings.TrimRight(strings.TrimSpace(opts.Endpoint), "/")
+	apiKey := strings.TrimSpace(opts.APIKey)
+	client := util.GetSharedHTTPClient("direct", requestTimeout, nil)
+
+	switch provider {
+	case "":
+		return nil, nil
+	case ProviderOpenAI:
+		if endpoint == "" {
+			if apiKey == "" {
+				return nil, errors.New("embeddings: openai requires an api key or an endpoint")
+			}
+			endpoint = defaultOpenAIEndpoint
+		}
+		return &openAI{client: client, endpoint: endpoint + "/embeddings", apiKey: apiKey}, nil
+	case ProviderTEI:
+		if endpoint == "" {
+			return nil, errors.New("embeddings: tei requires an endpoint")
+		}
+		return &tei{client: client, endpoint: endpoint + "/embed", apiKey: apiKey}, nil
+	default:
+		return nil, fmt.Errorf("embeddings: unknown provider %q", opts.Provider)
+	}
+}
+
+// postJSON sends body as JSON and decodes the JSON response into v.
+func postJSON(ctx context.Context, client *http.Client, endpoint, apiKey string, body, v interface{}) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseBytes))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("embeddings: backend returned %d: %s", resp.StatusCode, strings.TrimSpace(string(data[:min(len(data), 200)])))
+	}
+	return json.Unmarshal(data, v)
+}
+
+type openAI struct {
+	client   *http.Client
+	endpoint string
+	apiKey   string
+}
+
+func (o *openAI) Embed(ctx context.Context, req Request) (*Result, error) {
+	body := map[string]interface{}{
+		"model":           req.Model,
+		"input":           req.Inputs,
+		"encoding_format": "float",
+	}
+	if req.Dimensions > 0 {
+		body["dimensions"] = req.Dimensions
+	}
+	var out struct {
+		Data []struct {
+			Index     int       `json:"index"`
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+		Model string `json:"model"`
+		Usage struct {
+			PromptTokens int `json:"prompt_tokens"`
+		} `json:"usage"`
+	}
+	if err := postJSON(ctx, o.client, o.endpoint, o.apiKey, body, &out); err != nil {
+		return nil, err
+	}
+	if len(out.Data) != len(req.Inputs) {
+		return nil, fmt.Errorf("embeddings: backend returned %d vectors for %d inputs", len(out.Data), len(req.Inputs))
+	}
+	vectors := make([][]float32, len(req.Inputs))
+	for _, d := range out.Data {
+		if d.Index < 0 || d.Index >= len(vectors) {
+			return nil, fmt.Errorf("embeddings: backend returned vector index %d out of range", d.Index)
+		}
+		vectors[d.Index] = d.Embedding
+	}
+	return &Result{Vectors: vectors, Model: out.Model, PromptTokens: out.Usage.PromptTokens}, nil
+}
+
+// tei talks to a HuggingFace text-embeddings-inference server, which serves
+// one model (typically ONNX) per instance; the requested model name is not
+// sent.
+type tei struct {
+	client   *http.Client
+	endpoint string
+	apiKey   string
+}
+
+func (t *tei) Embed(ctx context.Context, req Request) (*Result, error) {
+	body := map[string]interface{}{
+		"inputs":   req.Inputs,
+		"truncate": true,
+	}
+	if req.Dimensions > 0 {
+		body["dimensions"] = req.Dimensions
+	}
+	var vectors [][]float32
+	if err := postJSON(ctx, t.client, t.endpoint, t.apiKey, body, &vectors); err != nil {
+		return nil, err
+	}
+	if len(vectors) != len(req.Inputs) {
+		return nil, fmt.Errorf("embeddings: backend returned %d vectors for %d inputs", len(vectors), len(req.Inputs))
+	}
+	return &Result{Vectors: vectors}, nil
+}
@@ -0,0 +1,85 @@
+package embeddings
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/goccy/go-json"
+)
+
+func TestProviders(t *testing.T) {
+	cases := []struct {
+		provider string
+		path     string
+		body     string
+		tokens   int
+	}{
+		{ProviderOpenAI, "/embeddings", `{"data":[{"index":1,"embedding":[0.3,0.4]},{"index":0,"embedding":[0.1,0.2]}],"model":"text-embedding-3-small","usage":{"prompt_tokens":7}}`, 7},
+		{ProviderTEI, "/embed", `[[0.1,0.2],[0.3,0.4]]`, 0},
+	}
+	for _, tc := range cases {
+		t.Run(tc.provider, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != tc.path {
+					t.Errorf("unexpected path %s", r.URL.Path)
+				}
+				if r.Header.Get("Authorization") != "Bearer secret" {
+					t.Errorf("missing Authorization header")
+				}
+				var body map[string]interface{}
+				json.NewDecoder(r.Body).Decode(&body)
+				inputs := body["input"]
+				if tc.provider == ProviderTEI {
+					inputs = body["inputs"]
+				}
+				if !reflect.DeepEqual(inputs, []interface{}{"alpha", "beta"}) {
+					t.Errorf("inputs=%v", inputs)
+				}
+				w.Write([]byte(tc.body))
+			}))
+			defer srv.Close()
+
+			e, err := New(Options{Provider: tc.provider, Endpoint: srv.URL + "/", APIKey: "secret"})
+			if err != nil {
+				t.Fatalf("New: %v", err)
+			}
+			res, err := e.Embed(context.Background(), Request{Model: "text-embedding-3-small", Inputs: []string{"alpha", "beta"}})
+			if err != nil {
+				t.Fatalf("Embed: %v", err)
+			}
+			want := [][]float32{{0.1, 0.2}, {0.3, 0.4}}
+			if !reflect.DeepEqual(res.Vectors, want) || res.PromptTokens != tc.tokens {
+				t.Fatalf("result=%+v", res)
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	if e, err := New(Options{}); e != nil || err != nil {
+		t.Fatalf("empty provider: e=%v err=%v", e, err)
+	}
+	for _, opts := range []Options{
+		{Provider: "openai"},
+		{Provider: "tei"},
+		{Provider: "onnx", Endpoint: "http://localhost"},
+	} {
+		if _, err := New(opts); err == nil {
+			t.Fatalf("expected an error for %+v", opts)
+		}
+	}
+}
+
+func TestEmbedVectorCountMismatch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[[0.1,0.2]]`))
+	}))
+	defer srv.Close()
+	e, _ := New(Options{Provider: ProviderTEI, Endpoint: srv.URL})
+	if _, err := e.Embed(context.Background(), Request{Inputs: []string{"a", "b"}}); err == nil {
+		t.Fatal("expected an error when the backend drops inputs")
+	}
+}
@@ -86,6 +86,8 @@ func ClassifyUpstreamError(errStr string) UpstreamErrorClass {
 		return UpstreamErrorClass{Category: "auth_blocked", Retryable: true, SwitchAccount: true}
 	case HasExplicitHTTPStatus(lower, "404"):
 		return UpstreamErrorClass{Category: "auth_blocked", Retryable: false, SwitchAccount: false}
+	case strings.Contains(lower, "upstream response too large"):
+		return UpstreamErrorClass{Category: "oversize", Retryable: false, SwitchAccount: false}
 	case strings.Contains(lower, "input is too long") || HasExplicitHTTPStatus(lower, "400"):
 		return UpstreamErrorClass{Category: "client", Retryable: false, SwitchAccount: false}
 	case HasExplicitHTTPStatus(lower, "429") ||
@@ -0,0 +1,120 @@
+// Package events is an in-process bus for admin-facing notifications (an
+// account taken out of rotation, a circuit breaker opening, an import or a
+// background job finishing) that /api/events streams to the web UI.
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// Event types.
+const (
+	AccountDisabled      = "account.disabled"       // account marked with a failure status and taken out of rotation
+	AccountRecovered     = "account.recovered"      // account status cleared and back in rotation
+	AccountTokenExpiring = "account.token_expiring" // stored access token expires soon and could not be refreshed
+	BreakerOpened        = "breaker.opened"         // upstream circuit breaker tripped
+	BreakerClosed        = "breaker.closed"         // upstream circuit breaker recovered
+	ImportFinished       = "import.finished"        // /api/import applied a backup
+	JobFailed            = "job.failed"             // scheduled job returned an error or panicked
+)
+
+// Event levels, for the UI to pick a toast style.
+const (
+	LevelInfo    = "info"
+	LevelWarning = "warning"
+	LevelError   = "error"
+)
+
+// Event is one notification.
+type Event struct {
+	// ID increases by one per published event; clients resume with it.
+	ID      uint64                 `json:"id"`
+	Type    string                 `json:"type"`
+	Level   string                 `json:"level"`
+	Message string                 `json:"message"`
+	Data    map[string]interface{} `json:"data,omitempty"`
+	Time    time.Time              `json:"time"`
+}
+
+// Bus fans events out to subscribers and keeps the most recent ones so a
+// reconnecting client can catch up.
+type Bus struct {
+	mu      sync.Mutex
+	nextID  uint64
+	history []Event
+	size    int
+	subs    map[chan Event]struct{}
+}
+
+// Default is the bus used by the package-level Publish.
+var Default = NewBus(200)
+
+// NewBus returns a bus that keeps the last history events.
+func NewBus(history int) *Bus {
+	return &Bus{size: history, subs: make(map[chan Event]struct{})}
+}
+
+// Publish assigns e an ID and timestamp and delivers it to every subscriber.
+// Subscribers that are not keeping up miss the event rather than blocking the
+// publisher.
+func (b *Bus) Publish(e Event) Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.nextID++
+	e.ID = b.nextID
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	if e.Level == "" {
+		e.Level = LevelInfo
+	}
+	if b.size > 0 {
+		if len(b.history) >= b.size {
+			b.history = append(b.history[:0], b.history[1:]...)
+		}
+		b.history = append(b.history, e)
+	}
+	for ch := range b.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+	return e
+}
+
+// Subscribe returns a channel receiving every event published from now on,
+// and a function that ends the subscription.
+func (b *Bus) Subscribe(buffer int) (<-chan Event, func()) {
+	ch := make(chan Event, buffer)
+	b.mu.Lock()
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subs, ch)
+			b.mu.Unlock()
+		})
+	}
+}
+
+// Since returns the kept events with an ID above id, oldest first.
+func (b *Bus) Since(id uint64) []Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var out []Event
+	for _, e := range b.history {
+		if e.ID > id {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// Publish sends an event on the Default bus.
+func Publish(typ, level, message string, data map[string]interface{}) {
+	Default.Publish(Event{Type: typ, Level: level, Message: message, Data: data})
+}
@@ -0,0 +1,65 @@
+package events
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBusPublishSubscribe(t *testing.T) {
+	b := NewBus(2)
+	ch, cancel := b.Subscribe(4)
+
+	b.Publish(Event{Type: AccountDisabled, Message: "one"})
+	b.Publish(Event{Type: JobFailed, Level: LevelError, Message: "two"})
+	b.Publish(Event{Type: BreakerOpened, Message: "three"})
+
+	for want := uint64(1); want <= 3; want++ {
+		select {
+		case e := <-ch:
+			if e.ID != want || e.Time.IsZero() || e.Level == "" {
+				t.Fatalf("event %d: %+v", want, e)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("event %d not delivered", want)
+		}
+	}
+
+	// Only the last two events are kept.
+	if got := b.Since(0); len(got) != 2 || got[0].ID != 2 || got[1].ID != 3 {
+		t.Fatalf("Since(0)=%+v", got)
+	}
+	if got := b.Since(2); len(got) != 1 || got[0].Message != "three" {
+		t.Fatalf("Since(2)=%+v", got)
+	}
+
+	cancel()
+	cancel()
+	b.Publish(Event{Type: ImportFinished})
+	select {
+	case e := <-ch:
+		t.Fatalf("unsubscribed channel received %+v", e)
+	default:
+	}
+}
+
+func TestBusSlowSubscriberDoesNotBlock(t *testing.T) {
+	b := NewBus(0)
+	_, cancel := b.Subscribe(1)
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 10; i++ {
+			b.Publish(Event{Type: JobFailed})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Publish blocked on a full subscriber")
+	}
+	if got := b.Since(0); len(got) != 0 {
+		t.Fatalf("bus without history kept %d events", len(got))
+	}
+}
@@ -0,0 +1,317 @@
+// Package featureflag provides typed runtime flags backed by the settings
+// store. Flags are declared next to the code that reads them, with a default
+// that applies until an admin overrides it through /api/flags.
+package featureflag
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+// Kind is the value type of a flag.
+type Kind string
+
+const (
+	KindBool Kind = "bool"
+	KindInt  Kind = "int"
+	KindJSON Kind = "json"
+)
+
+// SettingPrefix namespaces flag overrides in the settings store.
+const SettingPrefix = "flag:"
+
+// Store is the subset of the settings store the registry needs.
+type Store interface {
+	GetSetting(ctx context.Context, key string) (string, error)
+	SetSetting(ctx context.Context, key, value string) error
+	DeleteSetting(ctx context.Context, key string) error
+}
+
+// Definition describes a registered flag.
+type Definition struct {
+	Name        string          `json:"name"`
+	Kind        Kind            `json:"kind"`
+	Default     json.RawMessage `json:"default"`
+	Description string          `json:"description"`
+}
+
+// State is a flag's definition plus its effective value.
+type State struct {
+	Definition
+	Value      json.RawMessage `json:"value"`
+	Overridden bool            `json:"overridden"`
+}
+
+// Registry holds flag definitions and the current overrides.
+type Registry struct {
+	mu   sync.RWMutex
+	defs map[string]Definition
+	// overrides maps flag name to the parsed value (bool, int or json.RawMessage).
+	overrides atomic.Pointer[map[string]any]
+}
+
+// Default is the registry used by the package-level constructors.
+var Default = NewRegistry()
+
+func NewRegistry() *Registry {
+	r := &Registry{defs: make(map[string]Definition)}
+	empty := map[string]any{}
+	r.overrides.Store(&empty)
+	return r
+}
+
+func (r *Registry) register(name string, kind Kind, def any, desc string) {
+	raw, err := json.Marshal(def)
+	if err != nil {
+		panic(fmt.Sprintf("featureflag: default for %s: %v", name, err))
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.defs[name]; ok {
+		panic("featureflag: duplicate flag " + name)
+	}
+	r.defs[name] = Definition{Name: name, Kind: kind, Default: raw, Description: desc}
+}
+
+func (r *Registry) override(name string) (any, bool) {
+	v, ok := (*r.overrides.Load())[name]
+	return v, ok
+}
+
+func (r *Registry) definition(name string) (Definition, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	def, ok := r.defs[name]
+	return def, ok
+}
+
+// parse validates raw against kind and returns the value stored in overrides.
+func parse(kind Kind, raw []byte) (any, error) {
+	switch kind {
+	case KindBool:
+		var v bool
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return nil, fmt.Errorf("expected a boolean")
+		}
+		return v, nil
+	case KindInt:
+		var v int
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return nil, fmt.Errorf("expected an integer")
+		}
+		return v, nil
+	default:
+		if !json.Valid(raw) {
+			return nil, fmt.Errorf("expected JSON")
+		}
+		return json.RawMessage(append([]byte(nil), raw...)), nil
+	}
+}
+
+func (r *Registry) setOverride(name string, value any, ok bool) {
+	for {
+		cur := r.overrides.Load()
+		next := make(map[string]any, len(*cur)+1)
+		for k, v := range *cur {
+			next[k] = v
+		}
+		if ok {
+			next[name] = value
+		} else {
+			delete(next, name)
+		}
+		if r.overrides.CompareAndSwap(cur, &next) {
+			return
+		}
+	}
+}
+
+var (
+	// ErrUnknownFlag is returned for names that were never registered.
+	ErrUnknownFlag = errors.New("unknown feature flag")
+	// ErrInvalidValue is returned when a value doesn't match the flag's kind.
+	ErrInvalidValue = errors.New("invalid feature flag value")
+)
+
+// Set validates and persists an override, then applies it locally.
+func (r *Registry) Set(ctx context.Context, s Store, name string, raw json.RawMessage) error {
+	def, ok := r.definition(name)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownFlag, name)
+	}
+	value, err := parse(def.Kind, raw)
+	if err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrInvalidValue, name, err)
+	}
+	if err := s.SetSetting(ctx, SettingPrefix+name, string(raw)); err != nil {
+		return err
+	}
+	r.setOverride(name, value, true)
+	return nil
+}
+
+// Reset removes an override so the flag returns to its default.
+func (r *Registry) Reset(ctx context.Context, s Store, name string) error {
+	if _, ok := r.definition(name); !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownFlag, name)
+	}
+	if err := s.DeleteSetting(ctx, SettingPrefix+name); err != nil {
+		return err
+	}
+	r.setOverride(name, nil, false)
+	return nil
+}
+
+// Load replaces the overrides with what the store holds. Invalid stored values
+// are logged and ignored.
+func (r *Registry) Load(ctx context.Context, s Store) error {
+	r.mu.RLock()
+	defs := make([]Definition, 0, len(r.defs))
+	for _, def := range r.defs {
+		defs = append(defs, def)
+	}
+	r.mu.RUnlock()
+
+	next := make(map[string]any, len(defs))
+	for _, def := range defs {
+		raw, err := s.GetSetting(ctx, SettingPrefix+def.Name)
+		if err != nil {
+			return err
+		}
+		if strings.TrimSpace(raw) == "" {
+			continue
+		}
+		value, err := parse(def.Kind, []byte(raw))
+		if err != nil {
+			slog.Warn("Ignoring invalid feature flag override", "flag", def.Name, "error", err)
+			continue
+		}
+		next[def.Name] = value
+	}
+	r.overrides.Store(&next)
+	return nil
+}
+
+// StartRefresh reloads overrides every interval until ctx is done, so changes
+// made on another instance take effect here too.
+func (r *Registry) StartRefresh(ctx context.Context, s Store, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := r.Load(ctx, s); err != nil {
+					slog.Warn("Feature flag refresh failed", "error", err)
+				}
+			}
+		}
+	}()
+}
+
+// States returns every flag with its effective value, sorted by name.
+func (r *Registry) States() []State {
+	r.mu.RLock()
+	out := make([]State, 0, len(r.defs))
+	for _, def := range r.defs {
+		st := State{Definition: def, Value: def.Default}
+		if v, ok := r.override(def.Name); ok {
+			st.Overridden = true
+			st.Value, _ = json.Marshal(v)
+		}
+		out = append(out, st)
+	}
+	r.mu.RUnlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// Bool is a boolean flag.
+type Bool struct {
+	r    *Registry
+	name string
+	def  bool
+}
+
+// NewBool registers a boolean flag on the Default registry.
+func NewBool(name string, def bool, desc string) *Bool {
+	return Default.Bool(name, def, desc)
+}
+
+func (r *Registry) Bool(name string, def bool, desc string) *Bool {
+	r.register(name, KindBool, def, desc)
+	return &Bool{r: r, name: name, def: def}
+}
+
+// Enabled returns the override if one is set, else the default.
+func (f *Bool) Enabled() bool {
+	if v, ok := f.r.override(f.name); ok {
+		return v.(bool)
+	}
+	return f.def
+}
+
+// Int is an integer flag.
+type Int struct {
+	r    *Registry
+	name string
+	def  int
+}
+
+// NewInt registers an integer flag on the Default registry.
+func NewInt(name string, def int, desc string) *Int {
+	return Default.Int(name, def, desc)
+}
+
+func (r *Registry) Int(name string, def int, desc string) *Int {
+	r.register(name, KindInt, def, desc)
+	return &Int{r: r, name: name, def: def}
+}
+
+func (f *Int) Value() int {
+	if v, ok := f.r.override(f.name); ok {
+		return v.(int)
+	}
+	return f.def
+}
+
+// JSON is a flag holding an arbitrary JSON document.
+type JSON struct {
+	r    *Registry
+	name string
+	def  json.RawMessage
+}
+
+// NewJSON registers a JSON flag on the Default registry.
+func NewJSON(name string, def any, desc string) *JSON {
+	return Default.JSON(name, def, desc)
+}
+
+func (r *Registry) JSON(name string, def any, desc string) *JSON {
+	r.register(name, KindJSON, def, desc)
+	d, _ := r.definition(name)
+	return &JSON{r: r, name: name, def: d.Default}
+}
+
+// Decode unmarshals the effective value into v.
+func (f *JSON) Decode(v any) error {
+	raw := f.def
+	if o, ok := f.r.override(f.name); ok {
+		raw = o.(json.RawMessage)
+	}
+	return json.Unmarshal(raw, v)
+}
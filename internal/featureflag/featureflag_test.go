@@ -0,0 +1,82 @@
+package featureflag
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+type mapStore map[string]string
+
+func (m mapStore) GetSetting(_ context.Context, key string) (string, error) { return m[key], nil }
+func (m mapStore) SetSetting(_ context.Context, key, value string) error {
+	m[key] = value
+	return nil
+}
+func (m mapStore) DeleteSetting(_ context.Context, key string) error {
+	delete(m, key)
+	return nil
+}
+
+func TestTypedFlags(t *testing.T) {
+	ctx := context.Background()
+	r := NewRegistry()
+	s := mapStore{}
+	on := r.Bool("test.on", true, "")
+	limit := r.Int("test.limit", 3, "")
+	weights := r.JSON("test.weights", map[string]int{"a": 1}, "")
+
+	if !on.Enabled() || limit.Value() != 3 {
+		t.Fatal("defaults should apply before any override")
+	}
+	var w map[string]int
+	if err := weights.Decode(&w); err != nil || w["a"] != 1 {
+		t.Fatalf("json default: %v %v", w, err)
+	}
+
+	if err := r.Set(ctx, s, "test.on", []byte("false")); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if err := r.Set(ctx, s, "test.weights", []byte(`{"a":5}`)); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if on.Enabled() || s[SettingPrefix+"test.on"] != "false" {
+		t.Fatal("override should apply and persist")
+	}
+	if err := weights.Decode(&w); err != nil || w["a"] != 5 {
+		t.Fatalf("json override: %v %v", w, err)
+	}
+
+	if err := r.Set(ctx, s, "test.limit", []byte(`"x"`)); !errors.Is(err, ErrInvalidValue) {
+		t.Fatalf("kind mismatch err=%v", err)
+	}
+	if err := r.Set(ctx, s, "test.missing", []byte("1")); !errors.Is(err, ErrUnknownFlag) {
+		t.Fatalf("unknown flag err=%v", err)
+	}
+
+	if err := r.Reset(ctx, s, "test.on"); err != nil || !on.Enabled() {
+		t.Fatalf("reset should restore the default: %v", err)
+	}
+}
+
+func TestLoadPicksUpStoredOverrides(t *testing.T) {
+	r := NewRegistry()
+	limit := r.Int("test.limit", 3, "")
+	on := r.Bool("test.on", false, "")
+	s := mapStore{SettingPrefix + "test.limit": "7", SettingPrefix + "test.on": "not-a-bool"}
+
+	if err := r.Load(context.Background(), s); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if limit.Value() != 7 {
+		t.Fatalf("limit=%d want 7", limit.Value())
+	}
+	if on.Enabled() {
+		t.Fatal("invalid stored values should be ignored")
+	}
+
+	states := r.States()
+	if len(states) != 2 || states[0].Name != "test.limit" || !states[0].Overridden || string(states[0].Value) != "7" {
+		t.Fatalf("unexpected states: %+v", states)
+	}
+}
@@ -19,10 +19,11 @@ const maxEditImageBytes = 50 * 1024 * 1024
 var cacheBaseDir = filepath.Join("data", "tmp")
 
 type Handler struct {
-	base   *handler.BaseHandler
-	cfg    *config.Config
-	lb     *loadbalancer.LoadBalancer
-	client *Client
+	base    *handler.BaseHandler
+	cfg     *config.Config
+	lb      *loadbalancer.LoadBalancer
+	client  *Client
+	uploads *uploadCache
 }
 
 type chatAccountSession struct {
@@ -38,10 +39,11 @@ type imageEditUploadInput struct {
 
 func NewHandler(cfg *config.Config, lb *loadbalancer.LoadBalancer) *Handler {
 	return &Handler{
-		base:   handler.NewBaseHandler(lb),
-		cfg:    cfg,
-		lb:     lb,
-		client: New(cfg),
+		base:    handler.NewBaseHandler(lb),
+		cfg:     cfg,
+		lb:      lb,
+		client:  New(cfg),
+		uploads: newUploadCache(),
 	}
 }
 
@@ -53,6 +55,9 @@ func (h *Handler) selectAccount(ctx context.Context) (*store.Account, string, er
 	if err != nil {
 		return nil, "", err
 	}
+	if err := h.lb.Pace(ctx, acc); err != nil {
+		return nil, "", err
+	}
 	raw := strings.TrimSpace(acc.ClientCookie)
 	if raw == "" {
 		raw = strings.TrimSpace(acc.RefreshToken)
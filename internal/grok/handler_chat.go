@@ -375,6 +375,10 @@ func (h *Handler) uploadAttachmentInputs(ctx context.Context, token string, inpu
 	}
 	out := make([]string, 0, len(inputs))
 	for _, item := range inputs {
+		if id, ok := h.uploads.get(token, item.Data); ok {
+			out = append(out, id)
+			continue
+		}
 		fileID, fileURI, err := h.uploadSingleInput(ctx, token, item.Data)
 		if err != nil {
 			return nil, err
@@ -384,6 +388,7 @@ func (h *Handler) uploadAttachmentInputs(ctx context.Context, token string, inpu
 			id = strings.TrimSpace(fileURI)
 		}
 		if id != "" {
+			h.uploads.put(token, item.Data, id)
 			out = append(out, id)
 		}
 	}
@@ -1,11 +1,13 @@
 package grok
 
 import (
+	"fmt"
 	"mime"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 func sanitizeCachedFilename(raw string) string {
@@ -66,6 +68,15 @@ func (h *Handler) HandleFiles(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "file not found", http.StatusNotFound)
 		return
 	}
+	maxAge := 365 * 24 * time.Hour
+	if ttl := h.mediaCacheTTL(); ttl > 0 {
+		maxAge = time.Until(info.ModTime().Add(ttl))
+		if maxAge <= 0 {
+			_ = os.Remove(fullPath)
+			http.Error(w, "file not found", http.StatusNotFound)
+			return
+		}
+	}
 
 	ctype := mime.TypeByExtension(strings.ToLower(filepath.Ext(fileName)))
 	if ctype == "" {
@@ -76,6 +87,6 @@ func (h *Handler) HandleFiles(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 	w.Header().Set("Content-Type", ctype)
-	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d, immutable", int(maxAge.Seconds())))
 	http.ServeFile(w, r, fullPath)
 }
@@ -12,6 +12,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 
@@ -164,6 +165,9 @@ func (h *Handler) cacheMediaBytes(rawURL, mediaType string, data []byte, mimeTyp
 	fullPath := filepath.Join(dir, name)
 
 	if info, statErr := os.Stat(fullPath); statErr == nil && info.Mode().IsRegular() && info.Size() > 0 {
+		// Re-caching the same media restarts its TTL.
+		now := time.Now()
+		_ = os.Chtimes(fullPath, now, now)
 		return name, nil
 	}
 
@@ -155,56 +155,21 @@ func (h *Handler) serveImagesGenerations(ctx context.Context, w http.ResponseWri
 		return
 	}
 
-	var urls []string
-	var debugHTTP []string
-	var debugAsset []string
-
 	// Grok upstream may return only 2 images per call and may repeat.
 	// To reach N, request 1 image per call without rewriting the user's prompt.
-	maxAttempts := req.N * 4
-	if maxAttempts < 4 {
-		maxAttempts = 4
-	}
-	deadline := time.Now().Add(60 * time.Second)
-	for i := 0; i < maxAttempts; i++ {
-		cur := normalizeGeneratedImageURLs(urls, 0)
-		if len(cur) >= req.N {
-			urls = cur
-			break
-		}
-		if time.Now().After(deadline) {
-			break
-		}
+	gen, err := h.generateUniqueImages(ctx, sess, req.N, time.Now().Add(60*time.Second), func() map[string]interface{} {
 		payload := h.client.chatPayload(spec, strings.TrimSpace(req.Prompt), true, 1)
 		ensureImageAspectRatio(payload, resolveAspectRatio(req.Size))
 		ensureImageNSFW(payload, nsfw)
-		resp, err := h.doChatWithAutoSwitch(ctx, sess, payload)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadGateway)
-			return
-		}
-		h.syncGrokQuota(sess.acc, resp.Header)
-		err = parseUpstreamLines(resp.Body, func(line map[string]interface{}) error {
-			if mr, ok := line["modelResponse"].(map[string]interface{}); ok {
-				urls = append(urls, extractImageURLs(mr)...)
-				debugHTTP = append(debugHTTP, collectHTTPStrings(mr, 50)...)
-				debugAsset = append(debugAsset, collectAssetLikeStrings(mr, 100)...)
-			}
-			urls = append(urls, extractImageURLs(line)...)
-			debugHTTP = append(debugHTTP, collectHTTPStrings(line, 50)...)
-			debugAsset = append(debugAsset, collectAssetLikeStrings(line, 100)...)
-			return nil
-		})
-		resp.Body.Close()
-		if err != nil {
-			http.Error(w, "stream parse error: "+err.Error(), http.StatusBadGateway)
-			return
-		}
-		urls = normalizeGeneratedImageURLs(urls, 0)
+		return payload
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
 	}
-	urls = normalizeGeneratedImageURLs(urls, req.N)
+	urls := gen.urls
 	if len(urls) == 0 {
-		urls = appendImageCandidates(urls, uniqueStrings(debugHTTP), uniqueStrings(debugAsset), req.N)
+		urls = appendImageCandidates(urls, uniqueStrings(gen.debugHTTP), uniqueStrings(gen.debugAsset), req.N)
 	}
 	if len(urls) == 0 {
 		http.Error(w, "no image generated", http.StatusBadGateway)
@@ -234,6 +199,10 @@ func (h *Handler) serveImagesGenerations(ctx context.Context, w http.ResponseWri
 		"data":    data,
 		"usage":   imageUsagePayload(),
 	}
+	if h.cfg != nil && h.cfg.DebugEnabled {
+		out["debug"] = gen.stats
+		slog.Debug("grok image generation", "requested", req.N, "unique", gen.stats.Unique, "attempts", gen.stats.Attempts, "duplicates", gen.stats.Duplicates, "throttled_ms", gen.stats.ThrottledMS)
+	}
 	w.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(w).Encode(out)
 }
@@ -0,0 +1,170 @@
+package grok
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// imageGenStats summarizes one /images/generations fan-out for the debug block.
+type imageGenStats struct {
+	Workers     int   `json:"workers"`
+	Budget      int   `json:"attempt_budget"`
+	Attempts    int   `json:"attempts"`
+	Unique      int   `json:"unique"`
+	Duplicates  int   `json:"duplicates"`
+	ThrottledMS int64 `json:"throttled_ms"`
+}
+
+type imageGenResult struct {
+	urls       []string
+	debugHTTP  []string
+	debugAsset []string
+	stats      imageGenStats
+}
+
+// imageGenSettings resolves worker count, attempt budget and per-account
+// images-per-minute limit for a request of n images.
+func (h *Handler) imageGenSettings(n int) (workers, budget, perMinute int) {
+	workers, budget = 1, n*4
+	if budget < 4 {
+		budget = 4
+	}
+	if h != nil && h.cfg != nil {
+		if h.cfg.GrokImageWorkers > 0 {
+			workers = h.cfg.GrokImageWorkers
+		}
+		if h.cfg.GrokImageMaxAttempts > 0 {
+			budget = h.cfg.GrokImageMaxAttempts
+		}
+		perMinute = h.cfg.GrokImagesPerMinute
+	}
+	if workers > budget {
+		workers = budget
+	}
+	return workers, budget, perMinute
+}
+
+func imageLimiterKey(sess *chatAccountSession) string {
+	if sess.acc != nil && sess.acc.ID > 0 {
+		return strconv.FormatInt(sess.acc.ID, 10)
+	}
+	return sess.token
+}
+
+// generateUniqueImages requests one image per upstream call from a pool of
+// workers until n unique images were collected, the attempt budget is spent
+// or the deadline passes. Upstream may repeat images, so duplicates do not
+// count towards n but do consume the budget.
+func (h *Handler) generateUniqueImages(ctx context.Context, sess *chatAccountSession, n int, deadline time.Time, buildPayload func() map[string]interface{}) (imageGenResult, error) {
+	workers, budget, perMinute := h.imageGenSettings(n)
+	res := imageGenResult{stats: imageGenStats{Workers: workers, Budget: budget}}
+
+	// In-flight calls may finish past the deadline; it only stops new attempts.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mu       sync.Mutex
+		sessMu   sync.Mutex
+		firstErr error
+		wg       sync.WaitGroup
+	)
+
+	// claim reserves one attempt from the budget while more images are needed.
+	claim := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		if firstErr != nil || res.stats.Attempts >= budget || res.stats.Unique >= n || ctx.Err() != nil || time.Now().After(deadline) {
+			return false
+		}
+		res.stats.Attempts++
+		return true
+	}
+	fail := func(err error) {
+		mu.Lock()
+		if firstErr == nil {
+			firstErr = err
+		}
+		mu.Unlock()
+		cancel()
+	}
+
+	attempt := func() error {
+		sessMu.Lock()
+		key := imageLimiterKey(sess)
+		sessMu.Unlock()
+		waitCtx, waitCancel := context.WithDeadline(ctx, deadline)
+		waited, err := grokImageLimiter.wait(waitCtx, key, perMinute)
+		waitCancel()
+		mu.Lock()
+		res.stats.ThrottledMS += waited.Milliseconds()
+		mu.Unlock()
+		if err != nil {
+			// Out of time (or cancelled) while throttled: give up quietly.
+			return nil
+		}
+
+		sessMu.Lock()
+		resp, err := h.doChatWithAutoSwitch(ctx, sess, buildPayload())
+		if err == nil {
+			h.syncGrokQuota(sess.acc, resp.Header)
+		}
+		sessMu.Unlock()
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		var urls, debugHTTP, debugAsset []string
+		err = parseUpstreamLines(resp.Body, func(line map[string]interface{}) error {
+			if mr, ok := line["modelResponse"].(map[string]interface{}); ok {
+				urls = append(urls, extractImageURLs(mr)...)
+				debugHTTP = append(debugHTTP, collectHTTPStrings(mr, 50)...)
+				debugAsset = append(debugAsset, collectAssetLikeStrings(mr, 100)...)
+			}
+			urls = append(urls, extractImageURLs(line)...)
+			debugHTTP = append(debugHTTP, collectHTTPStrings(line, 50)...)
+			debugAsset = append(debugAsset, collectAssetLikeStrings(line, 100)...)
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("stream parse error: %w", err)
+		}
+
+		got := len(normalizeGeneratedImageURLs(urls, 0))
+		mu.Lock()
+		defer mu.Unlock()
+		before := res.stats.Unique
+		res.urls = normalizeGeneratedImageURLs(append(res.urls, urls...), 0)
+		res.debugHTTP = append(res.debugHTTP, debugHTTP...)
+		res.debugAsset = append(res.debugAsset, debugAsset...)
+		res.stats.Unique = len(res.urls)
+		if dup := got - (res.stats.Unique - before); dup > 0 {
+			res.stats.Duplicates += dup
+		}
+		return nil
+	}
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for claim() {
+				if err := attempt(); err != nil {
+					fail(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return res, firstErr
+	}
+	res.urls = normalizeGeneratedImageURLs(res.urls, n)
+	return res, nil
+}
@@ -0,0 +1,94 @@
+package grok
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"orchids-api/internal/config"
+)
+
+func TestGenerateUniqueImagesSkipsDuplicates(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		// Every other call repeats the first image.
+		id := n
+		if n%2 == 0 {
+			id = 1
+		}
+		fmt.Fprintf(w, `{"result":{"response":{"modelResponse":{"generatedImageUrls":["https://assets.grok.com/users/u/generated/%d/image.jpg"]}}}}`+"\n", id)
+	}))
+	defer srv.Close()
+
+	h := &Handler{
+		cfg:    &config.Config{GrokImageWorkers: 3, GrokImageMaxAttempts: 10},
+		client: New(&config.Config{GrokAPIBaseURL: srv.URL}),
+	}
+	sess := &chatAccountSession{token: "tok"}
+	res, err := h.generateUniqueImages(context.Background(), sess, 3, time.Now().Add(10*time.Second), func() map[string]interface{} {
+		return map[string]interface{}{"message": "cat"}
+	})
+	if err != nil {
+		t.Fatalf("generateUniqueImages: %v", err)
+	}
+	if len(res.urls) != 3 {
+		t.Fatalf("expected 3 unique images, got %v", res.urls)
+	}
+	if res.stats.Duplicates == 0 {
+		t.Fatalf("expected duplicates to be counted, stats=%+v", res.stats)
+	}
+	if res.stats.Attempts > 10 || int(calls.Load()) != res.stats.Attempts {
+		t.Fatalf("attempts=%d upstream calls=%d", res.stats.Attempts, calls.Load())
+	}
+}
+
+func TestGenerateUniqueImagesStopsAtBudget(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Write([]byte(`{"result":{"response":{"modelResponse":{"generatedImageUrls":["https://assets.grok.com/users/u/generated/same/image.jpg"]}}}}` + "\n"))
+	}))
+	defer srv.Close()
+
+	h := &Handler{
+		cfg:    &config.Config{GrokImageWorkers: 2, GrokImageMaxAttempts: 5},
+		client: New(&config.Config{GrokAPIBaseURL: srv.URL}),
+	}
+	res, err := h.generateUniqueImages(context.Background(), &chatAccountSession{token: "tok"}, 4, time.Now().Add(10*time.Second), func() map[string]interface{} {
+		return map[string]interface{}{"message": "cat"}
+	})
+	if err != nil {
+		t.Fatalf("generateUniqueImages: %v", err)
+	}
+	if got := calls.Load(); got != 5 {
+		t.Fatalf("expected the 5-attempt budget to be spent, got %d calls", got)
+	}
+	if len(res.urls) != 1 || res.stats.Duplicates != 4 {
+		t.Fatalf("urls=%v stats=%+v", res.urls, res.stats)
+	}
+}
+
+func TestImageRateLimiterSlidingWindow(t *testing.T) {
+	l := &imageRateLimiter{calls: make(map[string][]time.Time)}
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.reserve("acc", 2, now); !ok {
+			t.Fatalf("call %d should be allowed", i)
+		}
+	}
+	ok, delay := l.reserve("acc", 2, now.Add(10*time.Second))
+	if ok || delay <= 0 || delay > imageRateWindow {
+		t.Fatalf("third call should wait, ok=%v delay=%v", ok, delay)
+	}
+	if ok, _ := l.reserve("other", 2, now); !ok {
+		t.Fatal("limits are per account")
+	}
+	if ok, _ := l.reserve("acc", 2, now.Add(imageRateWindow+time.Second)); !ok {
+		t.Fatal("window should have slid past the first calls")
+	}
+}
@@ -0,0 +1,62 @@
+package grok
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const imageRateWindow = time.Minute
+
+// imageRateLimiter caps image generation calls per account over a sliding
+// one-minute window. It is shared by all requests so parallel workers and
+// concurrent clients draw from the same per-account budget.
+type imageRateLimiter struct {
+	mu    sync.Mutex
+	calls map[string][]time.Time
+}
+
+var grokImageLimiter = &imageRateLimiter{calls: make(map[string][]time.Time)}
+
+// reserve records a call for key when fewer than limit calls happened in the
+// last window. Otherwise it returns how long to wait before trying again.
+func (l *imageRateLimiter) reserve(key string, limit int, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	cutoff := now.Add(-imageRateWindow)
+	recent := l.calls[key]
+	kept := recent[:0]
+	for _, ts := range recent {
+		if ts.After(cutoff) {
+			kept = append(kept, ts)
+		}
+	}
+	if len(kept) >= limit {
+		l.calls[key] = kept
+		return false, kept[0].Sub(cutoff)
+	}
+	l.calls[key] = append(kept, now)
+	return true, 0
+}
+
+// wait blocks until key may issue another call, returning the time spent
+// waiting. limit <= 0 disables the limiter.
+func (l *imageRateLimiter) wait(ctx context.Context, key string, limit int) (time.Duration, error) {
+	if l == nil || limit <= 0 {
+		return 0, nil
+	}
+	start := time.Now()
+	for {
+		ok, delay := l.reserve(key, limit, time.Now())
+		if ok {
+			return time.Since(start), nil
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return time.Since(start), ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
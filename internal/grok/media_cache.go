@@ -0,0 +1,49 @@
+package grok
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// PruneMediaCache deletes cached images and videos not written or re-cached
+// within maxAge and returns how many files were removed. maxAge <= 0 keeps
+// everything.
+func PruneMediaCache(maxAge time.Duration) int {
+	if maxAge <= 0 {
+		return 0
+	}
+	cutoff := time.Now().Add(-maxAge)
+	removed := 0
+	for _, mediaType := range []string{"image", "video"} {
+		dir := filepath.Join(cacheBaseDir, mediaType)
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			if !entry.Type().IsRegular() {
+				continue
+			}
+			info, err := entry.Info()
+			if err != nil || info.ModTime().After(cutoff) {
+				continue
+			}
+			if err := os.Remove(filepath.Join(dir, entry.Name())); err != nil {
+				slog.Warn("Failed to remove expired grok media", "file", entry.Name(), "error", err)
+				continue
+			}
+			removed++
+		}
+	}
+	return removed
+}
+
+// mediaCacheTTL returns how long cached media is kept, or 0 when it never expires.
+func (h *Handler) mediaCacheTTL() time.Duration {
+	if h == nil || h.cfg == nil || h.cfg.GrokMediaCacheTTL <= 0 {
+		return 0
+	}
+	return time.Duration(h.cfg.GrokMediaCacheTTL) * time.Hour
+}
@@ -0,0 +1,82 @@
+package grok
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"orchids-api/internal/config"
+)
+
+func writeCachedMedia(t *testing.T, mediaType, name string, age time.Duration) string {
+	t.Helper()
+	dir := filepath.Join(cacheBaseDir, mediaType)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	full := filepath.Join(dir, name)
+	if err := os.WriteFile(full, []byte("abc"), 0o644); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+	ts := time.Now().Add(-age)
+	if err := os.Chtimes(full, ts, ts); err != nil {
+		t.Fatalf("chtimes: %v", err)
+	}
+	return full
+}
+
+func TestPruneMediaCache(t *testing.T) {
+	oldBase := cacheBaseDir
+	cacheBaseDir = t.TempDir()
+	t.Cleanup(func() { cacheBaseDir = oldBase })
+
+	fresh := writeCachedMedia(t, "image", "fresh.jpg", time.Minute)
+	stale := writeCachedMedia(t, "image", "stale.jpg", 3*time.Hour)
+	staleVideo := writeCachedMedia(t, "video", "stale.mp4", 3*time.Hour)
+
+	if removed := PruneMediaCache(0); removed != 0 {
+		t.Fatalf("ttl 0 must keep everything, removed %d", removed)
+	}
+	if removed := PruneMediaCache(time.Hour); removed != 2 {
+		t.Fatalf("expected 2 files removed, got %d", removed)
+	}
+	if _, err := os.Stat(fresh); err != nil {
+		t.Fatalf("fresh file should remain: %v", err)
+	}
+	for _, p := range []string{stale, staleVideo} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Fatalf("expected %s to be pruned", p)
+		}
+	}
+}
+
+func TestHandleFilesHonorsTTL(t *testing.T) {
+	oldBase := cacheBaseDir
+	cacheBaseDir = t.TempDir()
+	t.Cleanup(func() { cacheBaseDir = oldBase })
+
+	writeCachedMedia(t, "image", "fresh.jpg", time.Minute)
+	expired := writeCachedMedia(t, "image", "old.jpg", 2*time.Hour)
+	h := &Handler{cfg: &config.Config{GrokMediaCacheTTL: 1}}
+
+	rec := httptest.NewRecorder()
+	h.HandleFiles(rec, httptest.NewRequest(http.MethodGet, "/grok/v1/files/image/fresh.jpg", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 for fresh file, got %d", rec.Code)
+	}
+	if cc := rec.Header().Get("Cache-Control"); cc == "public, max-age=31536000, immutable" {
+		t.Fatalf("expected max-age bounded by the TTL, got %q", cc)
+	}
+
+	rec = httptest.NewRecorder()
+	h.HandleFiles(rec, httptest.NewRequest(http.MethodGet, "/grok/v1/files/image/old.jpg", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for expired file, got %d", rec.Code)
+	}
+	if _, err := os.Stat(expired); !os.IsNotExist(err) {
+		t.Fatal("expired file should be removed on access")
+	}
+}
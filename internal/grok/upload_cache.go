@@ -0,0 +1,77 @@
+package grok
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+const (
+	uploadCacheTTL        = 30 * time.Minute
+	uploadCacheMaxEntries = 1024
+)
+
+// uploadCache remembers which attachments were already uploaded with a given
+// token, so multi-turn vision chats do not re-upload every image in the
+// history on each request. Uploaded files belong to the account, hence the
+// token is part of the key.
+type uploadCache struct {
+	mu      sync.Mutex
+	entries map[string]uploadCacheEntry
+}
+
+type uploadCacheEntry struct {
+	fileID    string
+	expiresAt time.Time
+}
+
+func newUploadCache() *uploadCache {
+	return &uploadCache{entries: make(map[string]uploadCacheEntry)}
+}
+
+func uploadCacheKey(token, input string) string {
+	sum := sha256.New()
+	sum.Write([]byte(token))
+	sum.Write([]byte{0})
+	sum.Write([]byte(input))
+	return hex.EncodeToString(sum.Sum(nil))
+}
+
+func (c *uploadCache) get(token, input string) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+	key := uploadCacheKey(token, input)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok {
+		return "", false
+	}
+	if time.Now().After(entry.expiresAt) {
+		delete(c.entries, key)
+		return "", false
+	}
+	return entry.fileID, true
+}
+
+func (c *uploadCache) put(token, input, fileID string) {
+	if c == nil || fileID == "" {
+		return
+	}
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= uploadCacheMaxEntries {
+		for k, e := range c.entries {
+			if now.After(e.expiresAt) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= uploadCacheMaxEntries {
+			c.entries = make(map[string]uploadCacheEntry)
+		}
+	}
+	c.entries[uploadCacheKey(token, input)] = uploadCacheEntry{fileID: fileID, expiresAt: now.Add(uploadCacheTTL)}
+}
@@ -0,0 +1,59 @@
+package grok
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"orchids-api/internal/config"
+)
+
+func TestUploadAttachmentInputsReusesUploads(t *testing.T) {
+	var uploads atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != defaultUploadFilePath {
+			http.NotFound(w, r)
+			return
+		}
+		n := uploads.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"fileMetadataId":"file-` + string(rune('0'+n)) + `"}`))
+	}))
+	defer srv.Close()
+
+	h := &Handler{
+		client:  New(&config.Config{GrokAPIBaseURL: srv.URL}),
+		uploads: newUploadCache(),
+	}
+	image := AttachmentInput{Type: "image", Data: "data:image/png;base64,iVBORw0KGgo="}
+	ctx := context.Background()
+
+	first, err := h.uploadAttachmentInputs(ctx, "tok-a", []AttachmentInput{image})
+	if err != nil || len(first) != 1 || first[0] != "file-1" {
+		t.Fatalf("first upload: ids=%v err=%v", first, err)
+	}
+	// A follow-up turn repeating the same image must not upload it again.
+	second, err := h.uploadAttachmentInputs(ctx, "tok-a", []AttachmentInput{image})
+	if err != nil || len(second) != 1 || second[0] != "file-1" {
+		t.Fatalf("cached upload: ids=%v err=%v", second, err)
+	}
+	if got := uploads.Load(); got != 1 {
+		t.Fatalf("expected 1 upstream upload, got %d", got)
+	}
+
+	// Files belong to the account, so another token uploads its own copy.
+	other, err := h.uploadAttachmentInputs(ctx, "tok-b", []AttachmentInput{image})
+	if err != nil || len(other) != 1 || other[0] != "file-2" {
+		t.Fatalf("other token: ids=%v err=%v", other, err)
+	}
+}
+
+func TestUploadCacheNilSafe(t *testing.T) {
+	var c *uploadCache
+	c.put("tok", "data", "id")
+	if _, ok := c.get("tok", "data"); ok {
+		t.Fatal("nil cache must never hit")
+	}
+}
@@ -0,0 +1,272 @@
+// Package grpcapi serves the messages pipeline and a few admin endpoints over
+// gRPC (see orchids.proto). It speaks the gRPC wire protocol directly on top of
+// net/http's HTTP/2 support and reuses the HTTP handlers for the actual work.
+package grpcapi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/goccy/go-json"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"orchids-api/internal/handler"
+)
+
+const (
+	messagesCreateMethod = "/orchids.v1.Messages/Create"
+	adminServicePrefix   = "/orchids.v1.Admin/"
+
+	maxMessageBytes = 32 << 20
+)
+
+// gRPC status codes used by this server.
+const (
+	codeOK              = 0
+	codeInvalidArgument = 3
+	codeNotFound        = 5
+	codePermission      = 7
+	codeResource        = 8
+	codeUnimplemented   = 12
+	codeInternal        = 13
+	codeUnavailable     = 14
+	codeUnauthenticated = 16
+)
+
+// Server implements http.Handler for the gRPC services. Mount it on an HTTP/2
+// listener (h2c or TLS); gRPC does not work over HTTP/1.1.
+type Server struct {
+	messages      http.HandlerFunc
+	messagesPath  string
+	adminHandlers map[string]http.HandlerFunc
+}
+
+// New returns a server that runs Messages.Create through messages and serves
+// each admin RPC (keyed by method name, e.g. "ListAccounts") with a GET on the
+// matching admin handler. Handlers are expected to do their own auth.
+func New(messages http.HandlerFunc, admin map[string]http.HandlerFunc) *Server {
+	return &Server{
+		messages:      messages,
+		messagesPath:  "/v1/messages",
+		adminHandlers: admin,
+	}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		http.Error(w, "gRPC requests only", http.StatusUnsupportedMediaType)
+		return
+	}
+	w.Header().Set("Content-Type", "application/grpc")
+	w.WriteHeader(http.StatusOK)
+
+	in, err := readMessage(r.Body)
+	if err != nil {
+		writeStatus(w, codeInvalidArgument, err.Error())
+		return
+	}
+
+	switch {
+	case r.URL.Path == messagesCreateMethod && s.messages != nil:
+		s.createMessage(w, r, in)
+	case strings.HasPrefix(r.URL.Path, adminServicePrefix):
+		h := s.adminHandlers[strings.TrimPrefix(r.URL.Path, adminServicePrefix)]
+		if h == nil {
+			writeStatus(w, codeUnimplemented, "unknown method "+r.URL.Path)
+			return
+		}
+		s.callAdmin(w, r, h)
+	default:
+		writeStatus(w, codeUnimplemented, "unknown method "+r.URL.Path)
+	}
+}
+
+func (s *Server) createMessage(w http.ResponseWriter, r *http.Request, in *structpb.Struct) {
+	body, err := protojson.Marshal(in)
+	if err != nil {
+		writeStatus(w, codeInvalidArgument, err.Error())
+		return
+	}
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodPost, s.messagesPath, bytes.NewReader(body))
+	if err != nil {
+		writeStatus(w, codeInternal, err.Error())
+		return
+	}
+	req.Header = metadataHeader(r.Header)
+	req.RemoteAddr = r.RemoteAddr
+
+	var sendErr error
+	send := func(event []byte) bool {
+		msg := &structpb.Struct{}
+		if err := protojson.Unmarshal(event, msg); err != nil {
+			// Events are always JSON objects; skip anything else.
+			return true
+		}
+		if sendErr = writeMessage(w, msg); sendErr != nil {
+			return false
+		}
+		return true
+	}
+	if err := handler.ServeMessageEvents(s.messages, req, send); err != nil {
+		writeStatus(w, codeInvalidArgument, err.Error())
+		return
+	}
+	if sendErr != nil {
+		return
+	}
+	if err := r.Context().Err(); err != nil {
+		writeStatus(w, codeUnavailable, err.Error())
+		return
+	}
+	writeStatus(w, codeOK, "")
+}
+
+func (s *Server) callAdmin(w http.ResponseWriter, r *http.Request, h http.HandlerFunc) {
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, "/", nil)
+	if err != nil {
+		writeStatus(w, codeInternal, err.Error())
+		return
+	}
+	req.Header = metadataHeader(r.Header)
+	req.RemoteAddr = r.RemoteAddr
+
+	rec := &responseBuffer{header: make(http.Header), status: http.StatusOK}
+	h(rec, req)
+	if rec.status >= http.StatusBadRequest {
+		writeStatus(w, statusFromHTTP(rec.status), strings.TrimSpace(rec.body.String()))
+		return
+	}
+
+	out, err := structFromJSON(rec.body.Bytes())
+	if err != nil {
+		writeStatus(w, codeInternal, err.Error())
+		return
+	}
+	if err := writeMessage(w, out); err != nil {
+		return
+	}
+	writeStatus(w, codeOK, "")
+}
+
+// structFromJSON converts an HTTP JSON response into a Struct, wrapping
+// top-level arrays as {"items": [...]}.
+func structFromJSON(data []byte) (*structpb.Struct, error) {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, fmt.Errorf("invalid admin response: %w", err)
+	}
+	if _, ok := v.(map[string]interface{}); !ok {
+		v = map[string]interface{}{"items": v}
+	}
+	normalized, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	out := &structpb.Struct{}
+	if err := protojson.Unmarshal(normalized, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// metadataHeader copies the call metadata (auth, API key, pin headers) for
+// the inner HTTP request, dropping the gRPC transport headers.
+func metadataHeader(src http.Header) http.Header {
+	header := make(http.Header, len(src))
+	for k, v := range src {
+		lower := strings.ToLower(k)
+		if lower == "content-type" || lower == "te" || strings.HasPrefix(lower, "grpc-") {
+			continue
+		}
+		header[k] = append([]string(nil), v...)
+	}
+	return header
+}
+
+// readMessage reads one length-prefixed gRPC message.
+func readMessage(r io.Reader) (*structpb.Struct, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return nil, fmt.Errorf("read message header: %w", err)
+	}
+	if prefix[0] != 0 {
+		return nil, errors.New("compressed messages are not supported")
+	}
+	size := binary.BigEndian.Uint32(prefix[1:])
+	if size > maxMessageBytes {
+		return nil, fmt.Errorf("message of %d bytes exceeds limit", size)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, fmt.Errorf("read message body: %w", err)
+	}
+	msg := &structpb.Struct{}
+	if err := proto.Unmarshal(data, msg); err != nil {
+		return nil, fmt.Errorf("decode message: %w", err)
+	}
+	return msg, nil
+}
+
+// writeMessage writes one length-prefixed gRPC message and flushes it.
+func writeMessage(w http.ResponseWriter, msg *structpb.Struct) error {
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	frame := make([]byte, 5+len(data))
+	binary.BigEndian.PutUint32(frame[1:5], uint32(len(data)))
+	copy(frame[5:], data)
+	if _, err := w.Write(frame); err != nil {
+		return err
+	}
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+func writeStatus(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(code))
+	if msg != "" {
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", url.PathEscape(msg))
+	}
+}
+
+func statusFromHTTP(status int) int {
+	switch status {
+	case http.StatusBadRequest:
+		return codeInvalidArgument
+	case http.StatusUnauthorized:
+		return codeUnauthenticated
+	case http.StatusForbidden:
+		return codePermission
+	case http.StatusNotFound:
+		return codeNotFound
+	case http.StatusTooManyRequests:
+		return codeResource
+	case http.StatusServiceUnavailable:
+		return codeUnavailable
+	default:
+		return codeInternal
+	}
+}
+
+// responseBuffer captures an admin handler's response.
+type responseBuffer struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *responseBuffer) Header() http.Header         { return b.header }
+func (b *responseBuffer) WriteHeader(status int)      { b.status = status }
+func (b *responseBuffer) Write(p []byte) (int, error) { return b.body.Write(p) }
@@ -0,0 +1,128 @@
+package grpcapi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/goccy/go-json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func newTestServer(t *testing.T, s *Server) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewUnstartedServer(s)
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func callGRPC(t *testing.T, srv *httptest.Server, method string, in map[string]interface{}, header http.Header) ([]*structpb.Struct, *http.Response) {
+	t.Helper()
+	msg, err := structpb.NewStruct(in)
+	if err != nil {
+		t.Fatalf("build request: %v", err)
+	}
+	data, _ := proto.Marshal(msg)
+	frame := make([]byte, 5+len(data))
+	binary.BigEndian.PutUint32(frame[1:5], uint32(len(data)))
+	copy(frame[5:], data)
+
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+method, bytes.NewReader(frame))
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("call %s: %v", method, err)
+	}
+	defer resp.Body.Close()
+	if resp.ProtoMajor != 2 {
+		t.Fatalf("expected HTTP/2, got %s", resp.Proto)
+	}
+
+	var out []*structpb.Struct
+	for {
+		m, err := readMessage(resp.Body)
+		if err != nil {
+			break
+		}
+		out = append(out, m)
+	}
+	io.Copy(io.Discard, resp.Body)
+	return out, resp
+}
+
+func TestMessagesCreateStreamsEvents(t *testing.T) {
+	s := New(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var req map[string]interface{}
+		_ = json.Unmarshal(body, &req)
+		if req["stream"] != true || req["model"] != "claude-sonnet-4-6" {
+			t.Errorf("unexpected inner request: %v", req)
+		}
+		if r.Header.Get("X-Api-Key") != "sk-test" {
+			t.Errorf("expected api key metadata to be forwarded, got %v", r.Header)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "event: message_start\ndata: {\"type\":\"message_start\"}\n\n")
+		fmt.Fprint(w, "event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n")
+	}, nil)
+	srv := newTestServer(t, s)
+
+	out, resp := callGRPC(t, srv, messagesCreateMethod, map[string]interface{}{
+		"model":    "claude-sonnet-4-6",
+		"messages": []interface{}{},
+	}, http.Header{"X-Api-Key": []string{"sk-test"}})
+
+	if len(out) != 2 {
+		t.Fatalf("expected 2 streamed events, got %d", len(out))
+	}
+	if out[0].Fields["type"].GetStringValue() != "message_start" || out[1].Fields["type"].GetStringValue() != "message_stop" {
+		t.Fatalf("unexpected events: %v", out)
+	}
+	if got := resp.Trailer.Get("Grpc-Status"); got != "0" {
+		t.Fatalf("expected grpc-status 0, got %q", got)
+	}
+}
+
+func TestAdminRPCs(t *testing.T) {
+	s := New(nil, map[string]http.HandlerFunc{
+		"ListAccounts": func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("X-Admin-Token") != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte("unauthorized"))
+				return
+			}
+			w.Write([]byte(`[{"id":1,"name":"a"}]`))
+		},
+	})
+	srv := newTestServer(t, s)
+
+	out, resp := callGRPC(t, srv, adminServicePrefix+"ListAccounts", nil, http.Header{"X-Admin-Token": []string{"secret"}})
+	if got := resp.Trailer.Get("Grpc-Status"); got != "0" || len(out) != 1 {
+		t.Fatalf("expected one message and OK status, got %d messages, status %q", len(out), got)
+	}
+	items := out[0].Fields["items"].GetListValue().GetValues()
+	if len(items) != 1 || items[0].GetStructValue().Fields["name"].GetStringValue() != "a" {
+		t.Fatalf("expected accounts wrapped in items, got %v", out[0])
+	}
+
+	_, resp = callGRPC(t, srv, adminServicePrefix+"ListAccounts", nil, nil)
+	if got := resp.Trailer.Get("Grpc-Status"); got != "16" {
+		t.Fatalf("expected UNAUTHENTICATED, got %q", got)
+	}
+
+	_, resp = callGRPC(t, srv, adminServicePrefix+"DropEverything", nil, nil)
+	if got := resp.Trailer.Get("Grpc-Status"); got != "12" {
+		t.Fatalf("expected UNIMPLEMENTED, got %q", got)
+	}
+}
@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"orchids-api/internal/store"
+)
+
+var errAccountsCapped = errors.New("all matching accounts have reached their daily or monthly caps")
+
+// accountUsageScope names an account's totals for its request and token caps.
+func accountUsageScope(accountID int64) string {
+	return "account:" + strconv.FormatInt(accountID, 10)
+}
+
+// accountCapDay names the daily cap period containing t, e.g. "2026-10-16".
+func accountCapDay(t time.Time) string {
+	return t.UTC().Format("2006-01-02")
+}
+
+// accountCapMonth names the monthly cap period containing t. With a reset day
+// the period runs from that day of one month to the day before it in the
+// next and is named after its first month, e.g. "2026-10@15".
+func accountCapMonth(t time.Time, resetDay int) string {
+	t = t.UTC()
+	if resetDay <= 1 {
+		return keyUsageMonth(t)
+	}
+	start := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	if t.Day() < resetDay {
+		start = start.AddDate(0, -1, 0)
+	}
+	return start.Format("2006-01") + "@" + strconv.Itoa(resetDay)
+}
+
+// hasAccountCaps reports whether acc sets any request or token cap.
+func hasAccountCaps(acc *store.Account) bool {
+	return acc.DailyRequestCap > 0 || acc.MonthlyRequestCap > 0 || acc.DailyTokenCap > 0 || acc.MonthlyTokenCap > 0
+}
+
+// accountCapReached names the cap acc has used up ("daily_requests",
+// "monthly_tokens", ...), or returns "" while it may still serve requests.
+func (h *Handler) accountCapReached(ctx context.Context, acc *store.Account) string {
+	if acc == nil || h.keyUsage == nil || !hasAccountCaps(acc) {
+		return ""
+	}
+	now := time.Now()
+	scope := accountUsageScope(acc.ID)
+	if acc.DailyRequestCap > 0 || acc.DailyTokenCap > 0 {
+		day := h.keyUsage.Get(ctx, scope, accountCapDay(now))
+		if acc.DailyRequestCap > 0 && day.Requests >= int64(acc.DailyRequestCap) {
+			return "daily_requests"
+		}
+		if acc.DailyTokenCap > 0 && day.Total() >= int64(acc.DailyTokenCap) {
+			return "daily_tokens"
+		}
+	}
+	if acc.MonthlyRequestCap > 0 || acc.MonthlyTokenCap > 0 {
+		month := h.keyUsage.Get(ctx, scope, accountCapMonth(now, acc.CapResetDay))
+		if acc.MonthlyRequestCap > 0 && month.Requests >= int64(acc.MonthlyRequestCap) {
+			return "monthly_requests"
+		}
+		if acc.MonthlyTokenCap > 0 && month.Total() >= int64(acc.MonthlyTokenCap) {
+			return "monthly_tokens"
+		}
+	}
+	return ""
+}
+
+// recordAccountUsage charges a finished request to the account's daily and
+// monthly cap periods. Only accounts with caps are counted.
+func (h *Handler) recordAccountUsage(ctx context.Context, acc *store.Account, inputTokens, outputTokens int) {
+	if acc == nil || h.keyUsage == nil || !hasAccountCaps(acc) {
+		return
+	}
+	now := time.Now()
+	scope := accountUsageScope(acc.ID)
+	h.keyUsage.Record(ctx, scope, accountCapDay(now), inputTokens, outputTokens)
+	h.keyUsage.Record(ctx, scope, accountCapMonth(now, acc.CapResetDay), inputTokens, outputTokens)
+}
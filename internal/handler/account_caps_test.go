@@ -0,0 +1,61 @@
+package handler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"orchids-api/internal/store"
+)
+
+func TestAccountCapMonth(t *testing.T) {
+	cases := []struct {
+		at       string
+		resetDay int
+		want     string
+	}{
+		{"2026-10-16", 0, "2026-10"},
+		{"2026-10-16", 1, "2026-10"},
+		{"2026-10-16", 15, "2026-10@15"},
+		{"2026-10-14", 15, "2026-09@15"},
+		{"2026-01-03", 15, "2025-12@15"},
+	}
+	for _, c := range cases {
+		at, _ := time.Parse("2006-01-02", c.at)
+		if got := accountCapMonth(at, c.resetDay); got != c.want {
+			t.Errorf("accountCapMonth(%s, %d)=%q want %q", c.at, c.resetDay, got, c.want)
+		}
+	}
+}
+
+func TestAccountCapReached(t *testing.T) {
+	usage := NewMemoryKeyUsageStore()
+	defer usage.Stop()
+	h := &Handler{keyUsage: usage}
+	ctx := context.Background()
+
+	acc := &store.Account{ID: 7, DailyRequestCap: 2, MonthlyTokenCap: 1000}
+	if got := h.accountCapReached(ctx, acc); got != "" {
+		t.Fatalf("fresh account capped: %q", got)
+	}
+	h.recordAccountUsage(ctx, acc, 100, 50)
+	if got := h.accountCapReached(ctx, acc); got != "" {
+		t.Fatalf("capped after one request: %q", got)
+	}
+	h.recordAccountUsage(ctx, acc, 100, 50)
+	if got := h.accountCapReached(ctx, acc); got != "daily_requests" {
+		t.Fatalf("cap=%q want daily_requests", got)
+	}
+
+	acc.DailyRequestCap = 0
+	h.recordAccountUsage(ctx, acc, 600, 100)
+	if got := h.accountCapReached(ctx, acc); got != "monthly_tokens" {
+		t.Fatalf("cap=%q want monthly_tokens", got)
+	}
+
+	uncapped := &store.Account{ID: 8}
+	h.recordAccountUsage(ctx, uncapped, 1, 1)
+	if u := usage.Get(ctx, accountUsageScope(8), accountCapDay(time.Now())); u.Requests != 0 {
+		t.Fatalf("usage recorded for an account without caps: %+v", u)
+	}
+}
@@ -0,0 +1,118 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"orchids-api/internal/loadbalancer"
+	"orchids-api/internal/orchids"
+	"orchids-api/internal/store"
+)
+
+// Request headers that bypass the load balancer for a single request. They are
+// honoured only for API keys created with allow_pinning.
+const (
+	headerAccountID = "X-Account-Id"
+	headerProjectID = "X-Orchids-Project-Id"
+	headerAgentMode = "X-Orchids-Agent-Mode"
+)
+
+var errPinnedAccountUnavailable = errors.New("pinned account unavailable")
+
+// accountPin carries per-request account / Orchids project overrides.
+type accountPin struct {
+	AccountID int64
+	ProjectID string
+	AgentMode string
+}
+
+// parseAccountPin reads the override headers. It returns nil when none are set.
+func parseAccountPin(r *http.Request) (*accountPin, error) {
+	pin := &accountPin{
+		ProjectID: strings.TrimSpace(r.Header.Get(headerProjectID)),
+		AgentMode: strings.TrimSpace(r.Header.Get(headerAgentMode)),
+	}
+	if raw := strings.TrimSpace(r.Header.Get(headerAccountID)); raw != "" {
+		id, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || id <= 0 {
+			return nil, fmt.Errorf("invalid %s header", headerAccountID)
+		}
+		pin.AccountID = id
+	}
+	if pin.AccountID == 0 && pin.ProjectID == "" && pin.AgentMode == "" {
+		return nil, nil
+	}
+	return pin, nil
+}
+
+// authorizeAccountPin reports whether key may use the override headers.
+func authorizeAccountPin(key *store.ApiKey) error {
+	if key == nil {
+		return errors.New("account pinning requires an API key")
+	}
+	if !key.AllowPinning {
+		return errors.New("API key is not allowed to pin accounts")
+	}
+	return nil
+}
+
+// selectPinnedAccount returns a client for the pinned account. There is no
+// failover: once the account has failed the request fails.
+func (h *Handler) selectPinnedAccount(ctx context.Context, accountID int64, forcedChannel string, failedAccountIDs []int64) (UpstreamClient, *store.Account, error) {
+	if h.loadBalancer == nil || h.loadBalancer.Store == nil {
+		return nil, nil, fmt.Errorf("%w: no account store configured", errPinnedAccountUnavailable)
+	}
+	for _, id := range failedAccountIDs {
+		if id == accountID {
+			return nil, nil, fmt.Errorf("%w: account %d failed", errPinnedAccountUnavailable, accountID)
+		}
+	}
+	account, err := h.loadBalancer.Store.GetAccount(ctx, accountID)
+	if err != nil || account == nil {
+		return nil, nil, fmt.Errorf("%w: account %d not found", errPinnedAccountUnavailable, accountID)
+	}
+	if !account.Enabled {
+		return nil, nil, fmt.Errorf("%w: account %d is disabled", errPinnedAccountUnavailable, accountID)
+	}
+	if account.Draining {
+		return nil, nil, fmt.Errorf("%w: account %d is draining", errPinnedAccountUnavailable, accountID)
+	}
+	if err := h.loadBalancer.CheckChannel(ctx, loadbalancer.AccountChannel(account)); err != nil {
+		return nil, nil, err
+	}
+	if forcedChannel != "" {
+		channel := strings.TrimSpace(account.AccountType)
+		if channel == "" {
+			channel = "orchids"
+		}
+		if !strings.EqualFold(channel, forcedChannel) {
+			return nil, nil, fmt.Errorf("%w: account %d does not serve channel %s", errPinnedAccountUnavailable, accountID, forcedChannel)
+		}
+	}
+	return h.clientForAccount(ctx, account), account, nil
+}
+
+// pooledProjectID picks the Orchids project for the current attempt: an explicit
+// client override wins, otherwise a project from the account's pool when enabled.
+func (h *Handler) pooledProjectID(client UpstreamClient, account *store.Account, pin *accountPin) string {
+	if pin != nil && pin.ProjectID != "" {
+		return pin.ProjectID
+	}
+	if account == nil || h.config == nil || h.config.OrchidsProjectPoolSize <= 0 {
+		return ""
+	}
+	creator, ok := client.(orchids.ProjectCreator)
+	if !ok {
+		return ""
+	}
+	return h.projectPool.Acquire(account.ID, creator, h.config.OrchidsProjectPoolSize, h.config.OrchidsProjectMaxRequests)
+}
+
+// ForgetAccount drops the Orchids projects pooled for a deleted account.
+func (h *Handler) ForgetAccount(accountID int64) {
+	h.projectPool.Forget(accountID)
+}
@@ -0,0 +1,87 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"orchids-api/internal/config"
+	"orchids-api/internal/loadbalancer"
+	"orchids-api/internal/store"
+)
+
+func TestParseAccountPin(t *testing.T) {
+	r := httptest.NewRequest("POST", "/v1/messages", nil)
+	if pin, err := parseAccountPin(r); err != nil || pin != nil {
+		t.Fatalf("no headers: pin=%+v err=%v", pin, err)
+	}
+
+	r.Header.Set(headerAccountID, "abc")
+	if _, err := parseAccountPin(r); err == nil {
+		t.Fatal("expected error for non-numeric account id")
+	}
+
+	r.Header.Set(headerAccountID, "42")
+	r.Header.Set(headerProjectID, "proj-1")
+	pin, err := parseAccountPin(r)
+	if err != nil || pin == nil || pin.AccountID != 42 || pin.ProjectID != "proj-1" {
+		t.Fatalf("unexpected pin=%+v err=%v", pin, err)
+	}
+}
+
+func TestAuthorizeAccountPin(t *testing.T) {
+	if authorizeAccountPin(nil) == nil {
+		t.Fatal("missing key should be rejected")
+	}
+	if authorizeAccountPin(&store.ApiKey{Enabled: true}) == nil {
+		t.Fatal("key without allow_pinning should be rejected")
+	}
+	if err := authorizeAccountPin(&store.ApiKey{Enabled: true, AllowPinning: true}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestSelectPinnedAccount(t *testing.T) {
+	mr := miniredis.RunT(t)
+	s, err := store.New(store.Options{RedisAddr: mr.Addr(), RedisPrefix: "test:"})
+	if err != nil {
+		t.Fatalf("store.New: %v", err)
+	}
+	ctx := context.Background()
+	enabled := &store.Account{Name: "good", AccountType: "orchids", Enabled: true}
+	disabled := &store.Account{Name: "off", AccountType: "orchids", Enabled: false}
+	for _, acc := range []*store.Account{enabled, disabled} {
+		if err := s.CreateAccount(ctx, acc); err != nil {
+			t.Fatalf("CreateAccount: %v", err)
+		}
+	}
+
+	var built *store.Account
+	h := &Handler{config: &config.Config{}, loadBalancer: loadbalancer.NewWithCacheTTL(s, 0)}
+	h.SetClientFactory(func(acc *store.Account, _ *config.Config) UpstreamClient {
+		built = acc
+		return nil
+	})
+
+	pin := &accountPin{AccountID: enabled.ID}
+	_, acc, err := h.selectAccount(ctx, "claude-sonnet-4-5", "", nil, pin)
+	if err != nil || acc == nil || acc.ID != enabled.ID || built == nil || built.ID != enabled.ID {
+		t.Fatalf("expected pinned account, got acc=%+v err=%v", acc, err)
+	}
+
+	if _, _, err := h.selectAccount(ctx, "claude-sonnet-4-5", "", []int64{enabled.ID}, pin); !errors.Is(err, errPinnedAccountUnavailable) {
+		t.Fatalf("failed pinned account must not fail over, got %v", err)
+	}
+	if _, _, err := h.selectAccount(ctx, "claude-sonnet-4-5", "warp", nil, pin); !errors.Is(err, errPinnedAccountUnavailable) {
+		t.Fatalf("expected channel mismatch error, got %v", err)
+	}
+	if _, _, err := h.selectAccount(ctx, "claude-sonnet-4-5", "", nil, &accountPin{AccountID: disabled.ID}); !errors.Is(err, errPinnedAccountUnavailable) {
+		t.Fatalf("expected disabled account error, got %v", err)
+	}
+	if _, _, err := h.selectAccount(ctx, "claude-sonnet-4-5", "", nil, &accountPin{AccountID: 9999}); !errors.Is(err, errPinnedAccountUnavailable) {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	apperrors "orchids-api/internal/errors"
+	"orchids-api/internal/loadbalancer"
 	"orchids-api/internal/store"
 )
 
@@ -35,4 +36,5 @@ func markAccountStatus(ctx context.Context, store *store.Store, acc *store.Accou
 		return
 	}
 	slog.Info("账号状态已标记", "account_id", acc.ID, "status", status)
+	loadbalancer.NotifyAccountStatus(acc, "upstream error")
 }
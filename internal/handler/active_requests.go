@@ -0,0 +1,275 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goccy/go-json"
+
+	apperrors "orchids-api/internal/errors"
+	"orchids-api/internal/store"
+)
+
+// activeRequestsInterval is how often the inspector stream pushes a snapshot.
+const activeRequestsInterval = time.Second
+
+// ActiveRequest is an in-flight messages request as shown by the admin
+// request inspector.
+type ActiveRequest struct {
+	ID           string    `json:"id"`
+	KeyScope     string    `json:"key_scope"`
+	AccountID    int64     `json:"account_id,omitempty"`
+	Account      string    `json:"account,omitempty"`
+	Model        string    `json:"model"`
+	Path         string    `json:"path"`
+	Stream       bool      `json:"stream"`
+	Stage        string    `json:"stage"`
+	StartedAt    time.Time `json:"started_at"`
+	ElapsedMs    int64     `json:"elapsed_ms"`
+	InputTokens  int       `json:"input_tokens"`
+	OutputTokens int       `json:"output_tokens"`
+	Cancelled    bool      `json:"cancelled,omitempty"`
+}
+
+// activeRequest is the live entry behind an ActiveRequest. The pipeline
+// updates it as the request moves through its stages.
+type activeRequest struct {
+	id        string
+	keyScope  string
+	model     string
+	path      string
+	stream    bool
+	startedAt time.Time
+	cancel    context.CancelFunc
+
+	mu          sync.Mutex
+	accountID   int64
+	account     string
+	stage       string
+	inputTokens int
+	sh          *streamHandler
+	cancelled   bool
+}
+
+func (a *activeRequest) setAccount(acc *store.Account) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if acc == nil {
+		a.accountID, a.account = 0, ""
+		return
+	}
+	a.accountID, a.account = acc.ID, acc.Name
+}
+
+// attach marks the request as streaming and lets snapshots read its output
+// so far. attach(nil, 0) detaches the stream handler before it is released.
+func (a *activeRequest) attach(sh *streamHandler, inputTokens int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.sh = sh
+	if sh != nil {
+		a.stage = "streaming"
+		a.inputTokens = inputTokens
+	}
+}
+
+func (a *activeRequest) isCancelled() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.cancelled
+}
+
+func (a *activeRequest) snapshot(now time.Time) ActiveRequest {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	out := ActiveRequest{
+		ID:          a.id,
+		KeyScope:    a.keyScope,
+		AccountID:   a.accountID,
+		Account:     a.account,
+		Model:       a.model,
+		Path:        a.path,
+		Stream:      a.stream,
+		Stage:       a.stage,
+		StartedAt:   a.startedAt,
+		ElapsedMs:   now.Sub(a.startedAt).Milliseconds(),
+		InputTokens: a.inputTokens,
+		Cancelled:   a.cancelled,
+	}
+	if a.sh != nil {
+		out.OutputTokens = a.sh.progressTokens()
+	}
+	return out
+}
+
+// activeRequests tracks in-flight messages requests for the admin inspector.
+type activeRequests struct {
+	reqs *ShardedMap[*activeRequest]
+}
+
+func newActiveRequests() *activeRequests {
+	return &activeRequests{reqs: NewShardedMap[*activeRequest]()}
+}
+
+func (t *activeRequests) add(a *activeRequest) {
+	if t == nil {
+		return
+	}
+	t.reqs.Set(a.id, a)
+}
+
+func (t *activeRequests) remove(id string) {
+	if t == nil {
+		return
+	}
+	t.reqs.Delete(id)
+}
+
+// List returns a snapshot of every in-flight request, oldest first.
+func (t *activeRequests) List() []ActiveRequest {
+	out := make([]ActiveRequest, 0)
+	if t == nil {
+		return out
+	}
+	now := time.Now()
+	t.reqs.Range(func(_ string, a *activeRequest) bool {
+		out = append(out, a.snapshot(now))
+		return true
+	})
+	sort.Slice(out, func(i, j int) bool { return out[i].StartedAt.Before(out[j].StartedAt) })
+	return out
+}
+
+// forAccount calls fn for every request currently served by accountID.
+func (t *activeRequests) forAccount(accountID int64, fn func(*activeRequest)) {
+	if t == nil || accountID == 0 {
+		return
+	}
+	var matched []*activeRequest
+	t.reqs.Range(func(_ string, a *activeRequest) bool {
+		a.mu.Lock()
+		if a.accountID == accountID {
+			matched = append(matched, a)
+		}
+		a.mu.Unlock()
+		return true
+	})
+	for _, a := range matched {
+		fn(a)
+	}
+}
+
+// Cancel aborts the request's upstream call. The client receives whatever was
+// streamed so far followed by a normal end of message.
+func (t *activeRequests) Cancel(id string) bool {
+	if t == nil {
+		return false
+	}
+	a, ok := t.reqs.Get(id)
+	if !ok {
+		return false
+	}
+	a.mu.Lock()
+	a.cancelled = true
+	a.mu.Unlock()
+	a.cancel()
+	return true
+}
+
+// progressTokens estimates the output tokens produced so far.
+func (h *streamHandler) progressTokens() int {
+	h.outputMu.Lock()
+	defer h.outputMu.Unlock()
+	if h.useUpstreamUsage {
+		return h.outputTokens
+	}
+	return h.outputEstimator.Tokens()
+}
+
+// HandleActiveRequests serves GET /api/requests/active. Clients that accept
+// text/event-stream receive a "requests" event with the full list every
+// second; others get a single JSON snapshot.
+func (h *Handler) HandleActiveRequests(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		apperrors.New("invalid_request_error", "Method not allowed", http.StatusMethodNotAllowed).WriteResponse(w)
+		return
+	}
+	flusher, canFlush := w.(http.Flusher)
+	if !canFlush || !strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		reqs := h.active.List()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"requests": reqs,
+			"total":    len(reqs),
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	// Like message streams, re-arm the write deadline per frame so the
+	// server-wide write timeout doesn't cut the inspector off.
+	rc := http.NewResponseController(w)
+	var writeTimeout time.Duration
+	if h.config != nil {
+		writeTimeout = time.Duration(h.config.StreamWriteTimeout) * time.Second
+	}
+	ticker := time.NewTicker(activeRequestsInterval)
+	defer ticker.Stop()
+	for {
+		if writeTimeout > 0 {
+			_ = rc.SetWriteDeadline(time.Now().Add(writeTimeout))
+		}
+		data, _ := json.Marshal(h.active.List())
+		if _, err := fmt.Fprintf(w, "event: requests\ndata: %s\n\n", data); err != nil {
+			return
+		}
+		flusher.Flush()
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// HandleActiveRequestByID serves DELETE /api/requests/active/{id}, which
+// cancels the request.
+func (h *Handler) HandleActiveRequestByID(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		apperrors.New("invalid_request_error", "Method not allowed", http.StatusMethodNotAllowed).WriteResponse(w)
+		return
+	}
+	id := strings.TrimSpace(strings.TrimPrefix(r.URL.Path, "/api/requests/active/"))
+	if id == "" || !h.active.Cancel(id) {
+		apperrors.New("not_found_error", "request not found", http.StatusNotFound).WriteResponse(w)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AccountRequestCount returns how many in-flight messages requests are
+// currently served by the account on this instance.
+func (h *Handler) AccountRequestCount(accountID int64) int {
+	n := 0
+	h.active.forAccount(accountID, func(*activeRequest) { n++ })
+	return n
+}
+
+// CancelAccountRequests cancels every in-flight messages request served by
+// the account on this instance and returns how many were cancelled.
+func (h *Handler) CancelAccountRequests(accountID int64) int {
+	n := 0
+	h.active.forAccount(accountID, func(a *activeRequest) {
+		if h.active.Cancel(a.id) {
+			n++
+		}
+	})
+	return n
+}
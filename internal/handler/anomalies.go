@@ -0,0 +1,108 @@
+package handler
+
+import (
+	"strings"
+	"time"
+
+	"orchids-api/internal/anomaly"
+	"orchids-api/internal/config"
+	"orchids-api/internal/orchids"
+	"orchids-api/internal/prompt"
+	"orchids-api/internal/store"
+)
+
+func anomalyOptions(cfg *config.Config) anomaly.Options {
+	return anomaly.Options{
+		WebhookURL:    cfg.AnomalyWebhookURL,
+		Window:        time.Duration(cfg.AnomalyWindow) * time.Second,
+		MinRequests:   cfg.AnomalyMinRequests,
+		Cooldown:      time.Duration(cfg.AnomalyCooldown) * time.Second,
+		SlowRequest:   time.Duration(cfg.SlowRequestMs) * time.Millisecond,
+		SlowRate:      cfg.SlowRequestRate,
+		EmptyRate:     cfg.EmptyResponseRate,
+		ToolLoopDepth: cfg.ToolLoopRounds,
+		ToolLoopRate:  cfg.ToolLoopRate,
+	}
+}
+
+// AccountHealth reports the health of an account from the requests it
+// served on this instance within anomaly_window, its quota usage and failed
+// token fetches, or false when there is none of them.
+func (h *Handler) AccountHealth(acc *store.Account) (anomaly.Health, bool) {
+	health, ok := h.anomalies.AccountHealth(acc.ID)
+	if !ok {
+		health = anomaly.Health{Score: 100}
+	}
+	if used := quotaUsed(acc); used > 0 {
+		health.ApplyQuota(used)
+		ok = true
+	}
+	if failures := orchids.TokenFetchFailures(acc.ID); failures > 0 {
+		health.ApplyTokenFailures(failures)
+		ok = true
+	}
+	return health, ok
+}
+
+// AccountHealthScore is AccountHealth's score, used by the load balancer to
+// scale account weights.
+func (h *Handler) AccountHealthScore(acc *store.Account) (int, bool) {
+	health, ok := h.AccountHealth(acc)
+	return health.Score, ok
+}
+
+// quotaUsed returns the share of its quota the account has used; a
+// rate-limited account counts as exhausted.
+func quotaUsed(acc *store.Account) float64 {
+	if strings.TrimSpace(acc.StatusCode) == "429" {
+		return 1
+	}
+	if acc.UsageLimit > 0 {
+		return acc.UsageCurrent / acc.UsageLimit
+	}
+	return 0
+}
+
+// clientToolRounds counts the assistant tool_use turns since the last user
+// message that carried text, i.e. how deep the client's agent loop is.
+func clientToolRounds(messages []prompt.Message) int {
+	rounds := 0
+	for i := len(messages) - 1; i >= 0; i-- {
+		msg := messages[i]
+		if msg.Content.IsString() {
+			if msg.Role == "user" {
+				break
+			}
+			continue
+		}
+		toolTurn := false
+		for _, block := range msg.Content.GetBlocks() {
+			if block.Type == "tool_use" || block.Type == "tool_result" {
+				toolTurn = true
+			}
+		}
+		if msg.Role == "user" && !toolTurn {
+			break
+		}
+		if msg.Role == "assistant" && toolTurn {
+			rounds++
+		}
+	}
+	return rounds
+}
+
+// recordAnomalySample feeds the finished request into anomaly detection.
+// Requests the client abandoned say nothing about the account.
+func (p *messagesPipeline) recordAnomalySample() {
+	if p.r.Context().Err() != nil || p.currentAccount == nil {
+		return
+	}
+	p.h.anomalies.Record(anomaly.Sample{
+		AccountID:  p.currentAccount.ID,
+		Channel:    p.currentAccount.AccountType,
+		Duration:   time.Since(p.startTime),
+		Error:      p.upstreamErr != nil,
+		Empty:      !p.sh.hasAnyOutput(),
+		ToolRounds: clientToolRounds(p.req.Messages) + p.serverToolRounds,
+	}, anomalyOptions(p.h.config))
+}
@@ -0,0 +1,21 @@
+package handler
+
+import (
+	"testing"
+
+	"orchids-api/internal/prompt"
+)
+
+func TestClientToolRounds(t *testing.T) {
+	toolUse := prompt.Message{Role: "assistant", Content: prompt.MessageContent{Blocks: []prompt.ContentBlock{{Type: "tool_use", ID: "t"}}}}
+	toolResult := prompt.Message{Role: "user", Content: prompt.MessageContent{Blocks: []prompt.ContentBlock{{Type: "tool_result", ToolUseID: "t"}}}}
+	text := prompt.Message{Role: "user", Content: prompt.MessageContent{Text: "fix the build"}}
+
+	messages := []prompt.Message{text, toolUse, toolResult, toolUse, toolResult}
+	if got := clientToolRounds(messages); got != 2 {
+		t.Fatalf("rounds=%d want 2", got)
+	}
+	if got := clientToolRounds(append(messages, text)); got != 0 {
+		t.Fatalf("rounds after a new user turn=%d want 0", got)
+	}
+}
@@ -0,0 +1,447 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/goccy/go-json"
+
+	"orchids-api/internal/config"
+	apperrors "orchids-api/internal/errors"
+)
+
+// operationWebhookTimeout bounds the POST announcing a finished operation.
+const operationWebhookTimeout = 10 * time.Second
+
+// defaultAsyncMaxPerKey is how many operations a key may have queued or
+// running when async_max_per_key is not set.
+const defaultAsyncMaxPerKey = 10
+
+var (
+	errAsyncQueueFull = errors.New("Async operation queue is full")
+	errAsyncKeyLimit  = errors.New("Too many async operations pending for this API key")
+	errWebhookAddress = errors.New("webhook address is not public")
+)
+
+// Operation states.
+const (
+	operationQueued    = "queued"
+	operationRunning   = "running"
+	operationSucceeded = "succeeded"
+	operationFailed    = "failed"
+)
+
+// Operation is a non-streaming Messages request accepted with
+// "Prefer: respond-async". Response holds the body the request would have
+// returned synchronously once Status is succeeded or failed.
+type Operation struct {
+	ID          string          `json:"id"`
+	Type        string          `json:"type"`
+	Status      string          `json:"status"`
+	StatusCode  int             `json:"status_code,omitempty"`
+	Response    json.RawMessage `json:"response,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+	CompletedAt *time.Time      `json:"completed_at,omitempty"`
+
+	scope      string // apiKeyScope of the submitter; only it may read the result
+	webhookURL string
+	expiresAt  time.Time
+}
+
+type asyncJob struct {
+	op   *Operation
+	req  *http.Request
+	next http.HandlerFunc
+}
+
+// asyncOperations runs accepted operations on a bounded worker pool and keeps
+// their results in memory until they expire.
+type asyncOperations struct {
+	workers int
+	ttl     time.Duration
+	perKey  int
+	queue   chan asyncJob
+	start   sync.Once
+	client  *http.Client
+	// webhookHosts, when set, are the only hosts webhooks may go to;
+	// otherwise they may only go to public addresses.
+	webhookHosts []string
+
+	mu  sync.Mutex
+	ops map[string]*Operation
+}
+
+func newAsyncOperations(cfg *config.Config) *asyncOperations {
+	var workers, queueSize, perKey int
+	var webhookHosts []string
+	ttl := time.Hour
+	if cfg != nil {
+		workers, queueSize, perKey = cfg.AsyncWorkers, cfg.AsyncQueueSize, cfg.AsyncMaxPerKey
+		ttl = time.Duration(cfg.AsyncResultTTL) * time.Second
+		for _, host := range cfg.AsyncWebhookAllowedHosts {
+			if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+				webhookHosts = append(webhookHosts, host)
+			}
+		}
+	}
+	if perKey <= 0 {
+		perKey = defaultAsyncMaxPerKey
+	}
+	return &asyncOperations{
+		workers:      workers,
+		ttl:          ttl,
+		perKey:       perKey,
+		queue:        make(chan asyncJob, max(queueSize, 0)),
+		client:       newWebhookClient(len(webhookHosts) == 0),
+		webhookHosts: webhookHosts,
+		ops:          make(map[string]*Operation),
+	}
+}
+
+// newWebhookClient returns the client webhooks are posted with. It ignores
+// proxy settings and redirects; with publicOnly it refuses to connect to
+// loopback, private and link-local addresses, whatever the host resolved to.
+func newWebhookClient(publicOnly bool) *http.Client {
+	dialer := &net.Dialer{Timeout: operationWebhookTimeout}
+	if publicOnly {
+		dialer.Control = func(_, address string, _ syscall.RawConn) error {
+			addr, err := netip.ParseAddrPort(address)
+			if err != nil || !publicAddr(addr.Addr()) {
+				return errWebhookAddress
+			}
+			return nil
+		}
+	}
+	return &http.Client{
+		Timeout:   operationWebhookTimeout,
+		Transport: &http.Transport{DialContext: dialer.DialContext},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// cgnatPrefix is the carrier-grade NAT range, private but not RFC 1918.
+var cgnatPrefix = netip.MustParsePrefix("100.64.0.0/10")
+
+// publicAddr reports whether addr is a public unicast address.
+func publicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsGlobalUnicast() && !addr.IsPrivate() && !cgnatPrefix.Contains(addr)
+}
+
+// checkWebhookURL validates an X-Webhook-Url value: an http(s) URL whose host
+// is on the allowlist or, without one, resolves to public addresses only.
+func (a *asyncOperations) checkWebhookURL(ctx context.Context, raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return errors.New("X-Webhook-Url must be an http(s) URL")
+	}
+	host := strings.ToLower(u.Hostname())
+	if len(a.webhookHosts) > 0 {
+		if !slices.Contains(a.webhookHosts, host) {
+			return errors.New("X-Webhook-Url host is not allowed")
+		}
+		return nil
+	}
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil || len(addrs) == 0 {
+		return errors.New("X-Webhook-Url host does not resolve")
+	}
+	for _, addr := range addrs {
+		if !publicAddr(addr) {
+			return errors.New("X-Webhook-Url must point to a public address")
+		}
+	}
+	return nil
+}
+
+// submit queues job, starting the workers on first use. It fails when the
+// queue is full or the submitting key has too many operations pending.
+func (a *asyncOperations) submit(job asyncJob) error {
+	a.start.Do(func() {
+		for i := 0; i < a.workers; i++ {
+			go a.work()
+		}
+	})
+	now := time.Now()
+	a.mu.Lock()
+	pending := 0
+	for id, op := range a.ops {
+		if !op.expiresAt.IsZero() && now.After(op.expiresAt) {
+			delete(a.ops, id)
+			continue
+		}
+		if op.scope == job.op.scope && (op.Status == operationQueued || op.Status == operationRunning) {
+			pending++
+		}
+	}
+	if pending >= a.perKey {
+		a.mu.Unlock()
+		return errAsyncKeyLimit
+	}
+	a.ops[job.op.ID] = job.op
+	a.mu.Unlock()
+
+	select {
+	case a.queue <- job:
+		return nil
+	default:
+		a.mu.Lock()
+		delete(a.ops, job.op.ID)
+		a.mu.Unlock()
+		return errAsyncQueueFull
+	}
+}
+
+func (a *asyncOperations) work() {
+	for job := range a.queue {
+		a.run(job)
+	}
+}
+
+func (a *asyncOperations) run(job asyncJob) {
+	a.update(job.op, func(op *Operation) { op.Status = operationRunning })
+
+	rec := &operationRecorder{header: make(http.Header)}
+	func() {
+		defer func() {
+			if rec := recover(); rec != nil {
+				slog.Error("Async operation panicked", "operation", job.op.ID, "panic", rec)
+			}
+		}()
+		job.next(rec, job.req)
+	}()
+
+	status := rec.status
+	if status == 0 {
+		status = http.StatusInternalServerError
+		rec.body.Reset()
+		rec.body.Write(apperrors.New("server_error", "Internal Server Error", status).ToJSON())
+	}
+	body := rec.body.Bytes()
+	if !json.Valid(body) {
+		body, _ = json.Marshal(string(body))
+	}
+	var snapshot Operation
+	a.update(job.op, func(op *Operation) {
+		completed := time.Now()
+		op.StatusCode = status
+		op.Response = body
+		op.CompletedAt = &completed
+		op.expiresAt = completed.Add(a.ttl)
+		op.Status = operationSucceeded
+		if status >= http.StatusBadRequest {
+			op.Status = operationFailed
+		}
+		snapshot = *op
+	})
+	slog.Info("Async operation finished", "operation", job.op.ID, "status", snapshot.Status, "status_code", status)
+	if snapshot.webhookURL != "" {
+		a.notify(snapshot)
+	}
+}
+
+func (a *asyncOperations) update(op *Operation, fn func(*Operation)) {
+	a.mu.Lock()
+	fn(op)
+	a.mu.Unlock()
+}
+
+// get returns a copy of the operation if scope submitted it and it has not
+// expired.
+func (a *asyncOperations) get(id, scope string) (Operation, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	op, ok := a.ops[id]
+	if !ok || op.scope != scope {
+		return Operation{}, false
+	}
+	if !op.expiresAt.IsZero() && time.Now().After(op.expiresAt) {
+		delete(a.ops, id)
+		return Operation{}, false
+	}
+	return *op, true
+}
+
+// notify posts the finished operation to its webhook.
+func (a *asyncOperations) notify(op Operation) {
+	body, err := json.Marshal(op)
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), operationWebhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, op.webhookURL, bytes.NewReader(body))
+	if err != nil {
+		slog.Warn("Async operation webhook: invalid URL", "operation", op.ID, "error", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := a.client.Do(req)
+	if err != nil {
+		slog.Warn("Async operation webhook failed", "operation", op.ID, "error", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		slog.Warn("Async operation webhook rejected", "operation", op.ID, "status", resp.StatusCode)
+	}
+}
+
+// operationRecorder collects the response of an async operation.
+type operationRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *operationRecorder) Header() http.Header {
+	return w.header
+}
+
+func (w *operationRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *operationRecorder) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(p)
+}
+
+func (w *operationRecorder) Flush() {}
+
+// wantsAsync reports whether the client sent "Prefer: respond-async".
+func wantsAsync(r *http.Request) bool {
+	for _, v := range r.Header.Values("Prefer") {
+		for _, pref := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(pref), "respond-async") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// forceNonStreamBody sets "stream": false on a Messages request body.
+func forceNonStreamBody(data []byte) ([]byte, error) {
+	var body map[string]json.RawMessage
+	if err := json.Unmarshal(data, &body); err != nil || body == nil {
+		return nil, errors.New("request body must be a JSON object")
+	}
+	body["stream"] = json.RawMessage("false")
+	return json.Marshal(body)
+}
+
+// AsyncMessagesHandler accepts POST requests carrying "Prefer: respond-async"
+// as operations: the request is answered with 202 and an operation ID right
+// away and run as a non-streaming request through next on the async worker
+// pool. Only valid API keys may submit operations. Other requests go
+// straight to next.
+func (h *Handler) AsyncMessagesHandler(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || !wantsAsync(r) {
+			next(w, r)
+			return
+		}
+		if h.asyncOps.workers <= 0 {
+			apperrors.New("invalid_request_error", "Async requests are disabled", http.StatusBadRequest).WriteResponse(w)
+			return
+		}
+		key := h.lookupApiKey(r.Context(), presentedKeyHash(r))
+		if key == nil {
+			apperrors.New("authentication_error", "Async requests require a valid API key", http.StatusUnauthorized).WriteResponse(w)
+			return
+		}
+		if err := authorizeKeyAccess(key, r, h.trustedProxies(), time.Now()); err != nil {
+			if errors.Is(err, errApiKeyExpired) {
+				apperrors.New("authentication_error", err.Error(), http.StatusUnauthorized).WriteResponse(w)
+				return
+			}
+			apperrors.New("permission_error", err.Error(), http.StatusForbidden).WriteResponse(w)
+			return
+		}
+		webhookURL := strings.TrimSpace(r.Header.Get("X-Webhook-Url"))
+		if webhookURL != "" {
+			if err := h.asyncOps.checkWebhookURL(r.Context(), webhookURL); err != nil {
+				apperrors.New("invalid_request_error", err.Error(), http.StatusBadRequest).WriteResponse(w)
+				return
+			}
+		}
+
+		data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBytes))
+		if err != nil {
+			apperrors.New("invalid_request_error", "Invalid request body", http.StatusBadRequest).WriteResponse(w)
+			return
+		}
+		body, err := forceNonStreamBody(data)
+		if err != nil {
+			apperrors.New("invalid_request_error", "Invalid request body", http.StatusBadRequest).WriteResponse(w)
+			return
+		}
+		req, err := http.NewRequestWithContext(context.WithoutCancel(r.Context()), http.MethodPost, r.URL.String(), bytes.NewReader(body))
+		if err != nil {
+			apperrors.New("server_error", "failed to build request", http.StatusInternalServerError).WriteResponse(w)
+			return
+		}
+		req.Header = r.Header.Clone()
+		req.Header.Del("Prefer")
+		req.Header.Del("X-Webhook-Url")
+		req.Header.Set("Content-Type", "application/json")
+		req.RemoteAddr = r.RemoteAddr
+
+		op := &Operation{
+			ID:         "op_" + randomSessionID() + randomSessionID(),
+			Type:       "operation",
+			Status:     operationQueued,
+			CreatedAt:  time.Now(),
+			scope:      apiKeyScope(r),
+			webhookURL: webhookURL,
+		}
+		if err := h.asyncOps.submit(asyncJob{op: op, req: req, next: next}); err != nil {
+			apperrors.New("rate_limit_error", err.Error(), http.StatusTooManyRequests).WriteResponse(w)
+			return
+		}
+		slog.Info("Async operation queued", "operation", op.ID, "path", r.URL.Path, "key_scope", op.scope)
+
+		snapshot, _ := h.asyncOps.get(op.ID, op.scope)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Location", "/v1/operations/"+op.ID)
+		w.Header().Set("Preference-Applied", "respond-async")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(snapshot)
+	}
+}
+
+// HandleOperation serves GET /v1/operations/{id} for the API key that
+// submitted the operation.
+func (h *Handler) HandleOperation(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		apperrors.New("invalid_request_error", "Method not allowed", http.StatusMethodNotAllowed).WriteResponse(w)
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path[strings.LastIndex(r.URL.Path, "/"):], "/")
+	op, ok := h.asyncOps.get(id, apiKeyScope(r))
+	if !ok {
+		apperrors.New("not_found_error", "Operation not found", http.StatusNotFound).WriteResponse(w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(op)
+}
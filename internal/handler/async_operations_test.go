@@ -0,0 +1,185 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/goccy/go-json"
+
+	"orchids-api/internal/config"
+	"orchids-api/internal/loadbalancer"
+	"orchids-api/internal/store"
+)
+
+// newAsyncTestHandler returns a handler with async operations enabled and the
+// API keys k1 and k2.
+func newAsyncTestHandler(t *testing.T, cfg *config.Config) *Handler {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	s, err := store.New(store.Options{RedisAddr: mr.Addr(), RedisPrefix: "test:"})
+	if err != nil {
+		t.Fatalf("store.New: %v", err)
+	}
+	for _, raw := range []string{"k1", "k2"} {
+		sum := sha256.Sum256([]byte(raw))
+		if err := s.CreateApiKey(context.Background(), &store.ApiKey{Name: raw, KeyHash: hex.EncodeToString(sum[:]), Enabled: true}); err != nil {
+			t.Fatalf("CreateApiKey: %v", err)
+		}
+	}
+	return &Handler{asyncOps: newAsyncOperations(cfg), loadBalancer: loadbalancer.NewWithCacheTTL(s, 0)}
+}
+
+func TestAsyncMessagesHandlerRunsOperation(t *testing.T) {
+	h := newAsyncTestHandler(t, &config.Config{AsyncWorkers: 1, AsyncQueueSize: 4, AsyncResultTTL: 60, AsyncWebhookAllowedHosts: []string{"127.0.0.1"}})
+	webhook := make(chan Operation, 1)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var op Operation
+		json.NewDecoder(r.Body).Decode(&op)
+		webhook <- op
+	}))
+	defer hook.Close()
+
+	next := func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if !strings.Contains(string(body), `"stream":false`) || r.Header.Get("Prefer") != "" {
+			http.Error(w, "unexpected request: "+string(body), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"type":"message","content":[]}`))
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(`{"model":"m","stream":true}`))
+	req.Header.Set("Prefer", "respond-async")
+	req.Header.Set("X-Api-Key", "k1")
+	req.Header.Set("X-Webhook-Url", hook.URL)
+	rec := httptest.NewRecorder()
+	h.AsyncMessagesHandler(next)(rec, req)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status=%d body=%s", rec.Code, rec.Body.String())
+	}
+	var accepted Operation
+	if err := json.Unmarshal(rec.Body.Bytes(), &accepted); err != nil || accepted.ID == "" {
+		t.Fatalf("bad operation: %s", rec.Body.String())
+	}
+	if loc := rec.Header().Get("Location"); loc != "/v1/operations/"+accepted.ID {
+		t.Fatalf("Location=%q", loc)
+	}
+
+	select {
+	case op := <-webhook:
+		if op.ID != accepted.ID || op.Status != operationSucceeded {
+			t.Fatalf("webhook got %+v", op)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("webhook not called")
+	}
+
+	get := func(key string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/v1/operations/"+accepted.ID, nil)
+		r.Header.Set("X-Api-Key", key)
+		w := httptest.NewRecorder()
+		h.HandleOperation(w, r)
+		return w
+	}
+	w := get("k1")
+	var op Operation
+	if err := json.Unmarshal(w.Body.Bytes(), &op); err != nil {
+		t.Fatalf("decode: %v (%s)", err, w.Body.String())
+	}
+	if op.Status != operationSucceeded || op.StatusCode != http.StatusOK || string(op.Response) != `{"type":"message","content":[]}` {
+		t.Fatalf("unexpected operation: %+v response=%s", op, op.Response)
+	}
+	if w := get("k2"); w.Code != http.StatusNotFound {
+		t.Fatalf("another key read the operation: %d", w.Code)
+	}
+}
+
+func TestAsyncMessagesHandlerPassesThroughWithoutPreference(t *testing.T) {
+	h := &Handler{asyncOps: newAsyncOperations(&config.Config{})}
+	called := false
+	rec := httptest.NewRecorder()
+	h.AsyncMessagesHandler(func(w http.ResponseWriter, r *http.Request) { called = true })(rec, httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(`{}`)))
+	if !called {
+		t.Fatal("request without Prefer: respond-async was not passed through")
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(`{}`))
+	req.Header.Set("Prefer", "respond-async")
+	rec = httptest.NewRecorder()
+	h.AsyncMessagesHandler(func(w http.ResponseWriter, r *http.Request) {})(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("async with no workers: status=%d", rec.Code)
+	}
+}
+
+func TestAsyncMessagesHandlerRejects(t *testing.T) {
+	h := newAsyncTestHandler(t, &config.Config{AsyncWorkers: 1, AsyncQueueSize: 8, AsyncResultTTL: 60, AsyncMaxPerKey: 1})
+	release := make(chan struct{})
+	defer close(release)
+	next := func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Write([]byte(`{}`))
+	}
+	submit := func(key, webhook string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(`{"model":"m"}`))
+		req.Header.Set("Prefer", "respond-async")
+		if key != "" {
+			req.Header.Set("X-Api-Key", key)
+		}
+		if webhook != "" {
+			req.Header.Set("X-Webhook-Url", webhook)
+		}
+		rec := httptest.NewRecorder()
+		h.AsyncMessagesHandler(next)(rec, req)
+		return rec
+	}
+
+	for _, tc := range []struct {
+		key, webhook string
+		want         int
+	}{
+		{"", "", http.StatusUnauthorized},
+		{"sk-unknown", "", http.StatusUnauthorized},
+		{"k1", "ftp://example.com/hook", http.StatusBadRequest},
+		{"k1", "http://127.0.0.1:8080/hook", http.StatusBadRequest},
+		{"k1", "http://169.254.169.254/latest/meta-data", http.StatusBadRequest},
+		{"k1", "http://[::1]/hook", http.StatusBadRequest},
+		{"k1", "", http.StatusAccepted},
+		{"k1", "", http.StatusTooManyRequests}, // k1 already has one pending
+		{"k2", "", http.StatusAccepted},
+	} {
+		if rec := submit(tc.key, tc.webhook); rec.Code != tc.want {
+			t.Fatalf("key=%q webhook=%q: status=%d want %d body=%s", tc.key, tc.webhook, rec.Code, tc.want, rec.Body.String())
+		}
+	}
+}
+
+func TestWebhookClientRefusesPrivateAddresses(t *testing.T) {
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer hook.Close()
+	if _, err := newWebhookClient(true).Post(hook.URL, "application/json", nil); !errors.Is(err, errWebhookAddress) {
+		t.Fatalf("loopback webhook: err=%v", err)
+	}
+	resp, err := newWebhookClient(false).Post(hook.URL, "application/json", nil)
+	if err != nil {
+		t.Fatalf("allowlisted webhook: %v", err)
+	}
+	resp.Body.Close()
+
+	for addr, want := range map[string]bool{"93.184.216.34": true, "2606:4700::1111": true, "10.1.2.3": false, "192.168.0.1": false, "100.64.0.1": false, "::ffff:127.0.0.1": false, "fe80::1": false, "0.0.0.0": false} {
+		if got := publicAddr(netip.MustParseAddr(addr)); got != want {
+			t.Errorf("publicAddr(%s) = %v, want %v", addr, got, want)
+		}
+	}
+}
@@ -72,7 +72,7 @@ func (b *BaseHandler) EnsureModelEnabled(ctx context.Context, modelID, channel s
 }
 
 // SelectAccountByChannel picks the next available account for the given channel
-// using the load balancer.
+// using the load balancer, waiting for the account's request pace.
 func (b *BaseHandler) SelectAccountByChannel(ctx context.Context, channel string, excludeIDs []int64) (*store.Account, error) {
 	if b == nil || b.LB == nil {
 		return nil, fmt.Errorf("load balancer not configured")
@@ -81,6 +81,9 @@ func (b *BaseHandler) SelectAccountByChannel(ctx context.Context, channel string
 	if err != nil {
 		return nil, err
 	}
+	if err := b.LB.Pace(ctx, acc); err != nil {
+		return nil, err
+	}
 	return acc, nil
 }
 
@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"context"
+	"time"
+
+	"orchids-api/internal/loadbalancer"
+	"orchids-api/internal/store"
+)
+
+// channelRecord returns the configured record of channel, or nil.
+func (h *Handler) channelRecord(ctx context.Context, channel string) *store.Channel {
+	if h.loadBalancer == nil || h.loadBalancer.Store == nil || channel == "" {
+		return nil
+	}
+	return h.loadBalancer.Channel(ctx, channel)
+}
+
+// channelClientType returns the client implementation serving accountType:
+// the built-in one, or the type of its channel record for channels defined
+// through /api/channels. Unknown types fall back to Orchids.
+func (h *Handler) channelClientType(ctx context.Context, accountType string) string {
+	name := store.NormalizeChannelName(accountType)
+	if name == "" || store.IsBuiltinChannel(name) {
+		return name
+	}
+	if ch := h.channelRecord(ctx, name); ch != nil {
+		return store.NormalizeChannelName(ch.Type)
+	}
+	return name
+}
+
+// withChannelBaseURL returns account with its channel's base URL filled in
+// when the account has none of its own.
+func (h *Handler) withChannelBaseURL(ctx context.Context, account *store.Account) *store.Account {
+	if account.BaseURL != "" {
+		return account
+	}
+	ch := h.channelRecord(ctx, loadbalancer.AccountChannel(account))
+	if ch == nil || ch.BaseURL == "" {
+		return account
+	}
+	withURL := *account
+	withURL.BaseURL = ch.BaseURL
+	return &withURL
+}
+
+// channelRetryPolicy returns the retry count and base delay for requests
+// served by channel.
+func (h *Handler) channelRetryPolicy(ctx context.Context, channel string) (int, time.Duration) {
+	maxRetries := h.config.MaxRetries
+	delay := time.Duration(h.config.RetryDelay) * time.Millisecond
+	if ch := h.channelRecord(ctx, channel); ch != nil && ch.Retry != nil {
+		maxRetries = ch.Retry.MaxRetries
+		if ch.Retry.DelayMs > 0 {
+			delay = time.Duration(ch.Retry.DelayMs) * time.Millisecond
+		}
+	}
+	return max(maxRetries, 0), delay
+}
+
+// channelTokenBudget returns the tokens-per-minute budget of the channel
+// serving acc (0 = unlimited) and its TPM limiter key.
+func (h *Handler) channelTokenBudget(ctx context.Context, acc *store.Account) (int, string) {
+	if acc == nil {
+		return 0, ""
+	}
+	channel := loadbalancer.AccountChannel(acc)
+	if ch := h.channelRecord(ctx, channel); ch != nil && ch.TokenBudget > 0 {
+		return ch.TokenBudget, tpmChannel(channel)
+	}
+	return 0, ""
+}
+
+// channelBudgetAllows reports whether acc's channel is within its token budget.
+func (h *Handler) channelBudgetAllows(ctx context.Context, acc *store.Account) bool {
+	budget, key := h.channelTokenBudget(ctx, acc)
+	return h.tpm.Allow(key, budget)
+}
+
+func tpmChannel(channel string) string {
+	return "channel:" + channel
+}
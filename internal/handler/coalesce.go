@@ -0,0 +1,206 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	apperrors "orchids-api/internal/errors"
+	"orchids-api/internal/featureflag"
+)
+
+// requestCoalescingFlag switches request coalescing off at runtime.
+var requestCoalescingFlag = featureflag.NewBool("handler.request_coalescing", true,
+	"Share one upstream call between identical in-flight requests")
+
+// maxCoalescedBytes bounds the response a leader keeps for its followers.
+// Past it the buffer is dropped: followers already streaming get an error
+// event, the others make their own upstream call.
+const maxCoalescedBytes = 4 << 20
+
+// requestCoalescer implements single-flight handling of identical concurrent
+// requests: the first caller (leader) talks to upstream while later callers with
+// the same fingerprint subscribe to the leader's response bytes and replay them.
+type requestCoalescer struct {
+	mu    sync.Mutex
+	calls map[string]*coalescedCall
+}
+
+type coalescedCall struct {
+	mu     sync.Mutex
+	header http.Header
+	status int
+	buf    []byte
+	done   bool
+	// overflow is set once the response outgrew maxCoalescedBytes.
+	overflow bool
+	notify   chan struct{}
+}
+
+func newRequestCoalescer() *requestCoalescer {
+	return &requestCoalescer{calls: make(map[string]*coalescedCall)}
+}
+
+// acquire returns the in-flight call for hash. leader is true when the caller
+// created the call and is responsible for producing the response and calling finish.
+func (c *requestCoalescer) acquire(hash string) (call *coalescedCall, leader bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if existing, ok := c.calls[hash]; ok {
+		return existing, false
+	}
+	call = &coalescedCall{notify: make(chan struct{})}
+	c.calls[hash] = call
+	return call, true
+}
+
+// finish marks the leader's response as complete and detaches it from the registry.
+func (c *requestCoalescer) finish(hash string, call *coalescedCall) {
+	c.mu.Lock()
+	if c.calls[hash] == call {
+		delete(c.calls, hash)
+	}
+	c.mu.Unlock()
+
+	call.mu.Lock()
+	call.done = true
+	call.signalLocked()
+	call.mu.Unlock()
+}
+
+func (call *coalescedCall) signalLocked() {
+	close(call.notify)
+	call.notify = make(chan struct{})
+}
+
+// followResult tells the follower what became of its request.
+type followResult int
+
+const (
+	// followServed: the leader's response was replayed, or the client left.
+	followServed followResult = iota
+	// followEmpty: the leader finished without writing anything.
+	followEmpty
+	// followTooLarge: the response outgrew maxCoalescedBytes before the
+	// follower sent anything, so the follower should make its own call.
+	followTooLarge
+)
+
+// follow replays the leader's response to w as it is produced. Event streams
+// are relayed as they arrive; other bodies only once complete, so that a
+// response too large to share is never cut off after a 200 was sent.
+func (call *coalescedCall) follow(ctx context.Context, w http.ResponseWriter) followResult {
+	flusher, _ := w.(http.Flusher)
+	offset := 0
+	headerSent := false
+	for {
+		call.mu.Lock()
+		header, status := call.header, call.status
+		overflow := call.overflow
+		var chunk []byte
+		if !overflow {
+			chunk = call.buf[offset:]
+		}
+		done := call.done
+		notify := call.notify
+		call.mu.Unlock()
+
+		if overflow && headerSent {
+			// The stream already started: end it with an explicit error
+			// rather than a body that silently stops.
+			fmt.Fprintf(w, "event: error\ndata: %s\n\n", apperrors.New("api_error", "Coalesced response exceeded the replay buffer; retry the request", http.StatusBadGateway).ToJSON())
+			if flusher != nil {
+				flusher.Flush()
+			}
+			return followServed
+		}
+		if overflow && done {
+			return followTooLarge
+		}
+
+		if !overflow && !headerSent && header != nil && (done || isEventStreamHeader(header)) {
+			for k, v := range header {
+				w.Header()[k] = append([]string(nil), v...)
+			}
+			w.Header().Set("X-Request-Coalesced", "true")
+			w.WriteHeader(status)
+			headerSent = true
+		}
+		if headerSent && len(chunk) > 0 {
+			if _, err := w.Write(chunk); err != nil {
+				return followServed
+			}
+			offset += len(chunk)
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if done {
+			if headerSent {
+				return followServed
+			}
+			return followEmpty
+		}
+
+		select {
+		case <-notify:
+		case <-ctx.Done():
+			return followServed
+		}
+	}
+}
+
+func isEventStreamHeader(header http.Header) bool {
+	return strings.HasPrefix(header.Get("Content-Type"), "text/event-stream")
+}
+
+// coalesceWriter tees everything the leader writes into the shared call so that
+// followers can replay it.
+type coalesceWriter struct {
+	http.ResponseWriter
+	call        *coalescedCall
+	wroteHeader bool
+}
+
+func (cw *coalesceWriter) WriteHeader(status int) {
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+	cw.call.mu.Lock()
+	cw.call.header = cw.ResponseWriter.Header().Clone()
+	cw.call.status = status
+	cw.call.signalLocked()
+	cw.call.mu.Unlock()
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+func (cw *coalesceWriter) Write(p []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	cw.call.mu.Lock()
+	if !cw.call.overflow {
+		if len(cw.call.buf)+len(p) > maxCoalescedBytes {
+			cw.call.overflow = true
+			cw.call.buf = nil
+		} else {
+			cw.call.buf = append(cw.call.buf, p...)
+		}
+		cw.call.signalLocked()
+	}
+	cw.call.mu.Unlock()
+	return cw.ResponseWriter.Write(p)
+}
+
+func (cw *coalesceWriter) Flush() {
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (cw *coalesceWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
@@ -0,0 +1,136 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequestCoalescerFollowerReplaysLeader(t *testing.T) {
+	c := newRequestCoalescer()
+	call, leader := c.acquire("h1")
+	if !leader {
+		t.Fatal("first acquire should be leader")
+	}
+	followerCall, leader := c.acquire("h1")
+	if leader || followerCall != call {
+		t.Fatal("second acquire should join the leader's call")
+	}
+
+	leaderRec := httptest.NewRecorder()
+	cw := &coalesceWriter{ResponseWriter: leaderRec, call: call}
+
+	followerRec := httptest.NewRecorder()
+	done := make(chan followResult)
+	go func() {
+		done <- followerCall.follow(context.Background(), followerRec)
+	}()
+
+	cw.Header().Set("Content-Type", "text/event-stream")
+	cw.WriteHeader(http.StatusOK)
+	cw.Write([]byte("event: a\n\n"))
+	cw.Flush()
+	cw.Write([]byte("event: b\n\n"))
+	c.finish("h1", call)
+
+	select {
+	case res := <-done:
+		if res != followServed {
+			t.Fatalf("follow = %v, want followServed", res)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("follower did not finish")
+	}
+
+	if got := followerRec.Body.String(); got != "event: a\n\nevent: b\n\n" {
+		t.Fatalf("follower body=%q", got)
+	}
+	if got := followerRec.Header().Get("Content-Type"); got != "text/event-stream" {
+		t.Fatalf("follower content-type=%q", got)
+	}
+	if followerRec.Header().Get("X-Request-Coalesced") != "true" {
+		t.Fatal("follower response should be marked as coalesced")
+	}
+
+	if _, leader := c.acquire("h1"); !leader {
+		t.Fatal("acquire after finish should start a new leader")
+	}
+}
+
+func TestRequestCoalescerEmptyLeader(t *testing.T) {
+	c := newRequestCoalescer()
+	call, _ := c.acquire("h2")
+	follower, _ := c.acquire("h2")
+	c.finish("h2", call)
+	if res := follower.follow(context.Background(), httptest.NewRecorder()); res != followEmpty {
+		t.Fatalf("follow = %v, want followEmpty when leader wrote nothing", res)
+	}
+}
+
+func TestRequestCoalescerDropsOversizedResponse(t *testing.T) {
+	c := newRequestCoalescer()
+	call, _ := c.acquire("h3")
+	follower, _ := c.acquire("h3")
+	leaderRec := httptest.NewRecorder()
+	cw := &coalesceWriter{ResponseWriter: leaderRec, call: call}
+	cw.Write(bytes.Repeat([]byte("x"), maxCoalescedBytes))
+	cw.Write([]byte("y"))
+	c.finish("h3", call)
+
+	if call.buf != nil || !call.overflow {
+		t.Fatalf("buffer kept after overflow: %d bytes", len(call.buf))
+	}
+	if leaderRec.Body.Len() != maxCoalescedBytes+1 {
+		t.Fatalf("leader body=%d bytes", leaderRec.Body.Len())
+	}
+	if res := follower.follow(context.Background(), httptest.NewRecorder()); res != followTooLarge {
+		t.Fatalf("follow = %v, want followTooLarge for an oversized response it has not started", res)
+	}
+}
+
+func TestRequestCoalescerOversizedResponseWithFollowers(t *testing.T) {
+	for _, tc := range []struct {
+		contentType string
+		want        followResult
+	}{
+		{contentType: "text/event-stream", want: followServed},
+		{contentType: "application/json", want: followTooLarge},
+	} {
+		c := newRequestCoalescer()
+		call, _ := c.acquire("h4")
+		follower, _ := c.acquire("h4")
+		cw := &coalesceWriter{ResponseWriter: httptest.NewRecorder(), call: call}
+		followerRec := httptest.NewRecorder()
+		done := make(chan followResult)
+		go func() { done <- follower.follow(context.Background(), followerRec) }()
+
+		cw.Header().Set("Content-Type", tc.contentType)
+		cw.Write([]byte("event: a\n\n"))
+		// Give the follower time to pick up the first write.
+		time.Sleep(50 * time.Millisecond)
+		cw.Write(bytes.Repeat([]byte("x"), maxCoalescedBytes))
+		c.finish("h4", call)
+
+		var res followResult
+		select {
+		case res = <-done:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("%s: follower did not finish", tc.contentType)
+		}
+		if res != tc.want {
+			t.Fatalf("%s: follow = %v, want %v", tc.contentType, res, tc.want)
+		}
+		body := followerRec.Body.String()
+		if tc.want == followServed {
+			if !strings.HasPrefix(body, "event: a\n\nevent: error\ndata: ") || !strings.Contains(body, `"api_error"`) {
+				t.Fatalf("%s: follower body=%q, want the stream ended by an error event", tc.contentType, body)
+			}
+		} else if body != "" || followerRec.Header().Get("X-Request-Coalesced") != "" {
+			t.Fatalf("%s: follower wrote %q before the response was complete", tc.contentType, body)
+		}
+	}
+}
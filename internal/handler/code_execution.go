@@ -0,0 +1,187 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-json"
+
+	"orchids-api/internal/config"
+	"orchids-api/internal/sandbox"
+)
+
+// codeExecutionToolName is the name the built-in code_execution executor is
+// advertised under upstream.
+const codeExecutionToolName = "server_code_execution"
+
+const defaultCodeExecutionConcurrency = 2
+
+// codeRunner runs a snippet in a sandbox; *sandbox.Runner implements it.
+type codeRunner interface {
+	Run(ctx context.Context, language, code string) (sandbox.Result, error)
+}
+
+// codeExecutionTool is a request's built-in code_execution server tool.
+type codeExecutionTool struct {
+	runner codeRunner
+	// slots is shared by all requests; a run that finds it full fails with
+	// too_many_requests instead of queueing.
+	slots chan struct{}
+}
+
+func codeExecutionConcurrency(cfg *config.Config) int {
+	if cfg == nil || cfg.CodeExecutionConcurrency <= 0 {
+		return defaultCodeExecutionConcurrency
+	}
+	return cfg.CodeExecutionConcurrency
+}
+
+// codeExecutor returns the configured sandbox, or nil when code_execution is
+// not enabled.
+func (h *Handler) codeExecutor() codeRunner {
+	if h.codeExec != nil {
+		return h.codeExec
+	}
+	if h.config == nil {
+		return nil
+	}
+	r, err := sandbox.New(sandbox.Options{
+		Runtime:     h.config.CodeExecutionRuntime,
+		Timeout:     time.Duration(h.config.CodeExecutionTimeout) * time.Second,
+		MemoryMB:    h.config.CodeExecutionMemoryMB,
+		CPUs:        h.config.CodeExecutionCPUs,
+		Network:     h.config.CodeExecutionNetwork,
+		PythonImage: h.config.CodeExecutionPythonImage,
+		NodeImage:   h.config.CodeExecutionNodeImage,
+		Blacklist:   []string{h.config.FailureSnapshotDir},
+	})
+	if err != nil {
+		slog.Warn("code_execution disabled: invalid sandbox config", "error", err)
+		return nil
+	}
+	if r == nil {
+		return nil
+	}
+	return r
+}
+
+// newCodeExecutionTool returns the code_execution executor, or nil when no
+// sandbox is configured.
+func (h *Handler) newCodeExecutionTool() *codeExecutionTool {
+	runner := h.codeExecutor()
+	if runner == nil {
+		return nil
+	}
+	return &codeExecutionTool{runner: runner, slots: h.codeSlots}
+}
+
+func (ce *codeExecutionTool) serverName() string { return "code_execution" }
+
+func (ce *codeExecutionTool) definition() map[string]interface{} {
+	return map[string]interface{}{
+		"name":        codeExecutionToolName,
+		"description": "Run a Python or Node.js program in an isolated sandbox without network access and return its exit code, stdout and stderr. Print the values you need; state is not kept between runs.",
+		"input_schema": map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"language": map[string]interface{}{
+					"type":        "string",
+					"enum":        []string{sandbox.LanguagePython, sandbox.LanguageNode},
+					"description": "Program language, python by default",
+				},
+				"code": map[string]interface{}{"type": "string", "description": "The program to run"},
+			},
+			"required": []string{"code"},
+		},
+	}
+}
+
+func (ce *codeExecutionTool) run(ctx context.Context, rawInput string) serverToolOutcome {
+	var input struct {
+		Language string `json:"language"`
+		Code     string `json:"code"`
+	}
+	_ = json.Unmarshal([]byte(rawInput), &input)
+	echo := map[string]interface{}{"code": input.Code}
+	if input.Language != "" {
+		echo["language"] = input.Language
+	}
+
+	res, errorCode := ce.execute(ctx, input.Language, input.Code)
+	slog.Info("code_execution executed", "language", input.Language, "return_code", res.ReturnCode, "error_code", errorCode)
+	return serverToolOutcome{
+		input:   echo,
+		result:  codeExecutionResultBlock(res, errorCode),
+		text:    codeExecutionToolResult(res, errorCode),
+		isError: errorCode != "",
+	}
+}
+
+// execute runs one snippet. errorCode follows the Anthropic code_execution
+// error codes and is empty on success, including non-zero exit statuses.
+func (ce *codeExecutionTool) execute(ctx context.Context, language, code string) (sandbox.Result, string) {
+	if strings.TrimSpace(code) == "" {
+		return sandbox.Result{}, "invalid_tool_input"
+	}
+	if ce.slots != nil {
+		select {
+		case ce.slots <- struct{}{}:
+			defer func() { <-ce.slots }()
+		default:
+			return sandbox.Result{}, "too_many_requests"
+		}
+	}
+	res, err := ce.runner.Run(ctx, language, code)
+	switch {
+	case err == nil:
+		return res, ""
+	case errors.Is(err, sandbox.ErrTimeout):
+		return sandbox.Result{}, "execution_time_exceeded"
+	case errors.Is(err, sandbox.ErrUnsupportedLanguage):
+		return sandbox.Result{}, "invalid_tool_input"
+	default:
+		slog.Warn("code_execution failed", "error", err)
+		return sandbox.Result{}, "unavailable"
+	}
+}
+
+// codeExecutionResultBlock renders the code_execution_tool_result block sent
+// to the client.
+func codeExecutionResultBlock(res sandbox.Result, errorCode string) map[string]interface{} {
+	block := map[string]interface{}{"type": "code_execution_tool_result"}
+	if errorCode != "" {
+		block["content"] = map[string]interface{}{
+			"type":       "code_execution_tool_result_error",
+			"error_code": errorCode,
+		}
+		return block
+	}
+	block["content"] = map[string]interface{}{
+		"type":        "code_execution_result",
+		"stdout":      res.Stdout,
+		"stderr":      res.Stderr,
+		"return_code": res.ReturnCode,
+		"content":     []interface{}{},
+	}
+	return block
+}
+
+// codeExecutionToolResult renders the run for upstream.
+func codeExecutionToolResult(res sandbox.Result, errorCode string) string {
+	if errorCode != "" {
+		return "Code execution failed: " + errorCode
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Exit code: %d\n", res.ReturnCode)
+	if res.Stdout != "" {
+		fmt.Fprintf(&sb, "\nstdout:\n%s\n", strings.TrimRight(res.Stdout, "\n"))
+	}
+	if res.Stderr != "" {
+		fmt.Fprintf(&sb, "\nstderr:\n%s\n", strings.TrimRight(res.Stderr, "\n"))
+	}
+	return strings.TrimRight(sb.String(), "\n")
+}
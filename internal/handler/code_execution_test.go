@@ -0,0 +1,171 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/goccy/go-json"
+
+	"orchids-api/internal/config"
+	"orchids-api/internal/sandbox"
+	"orchids-api/internal/upstream"
+)
+
+type fakeCodeRunner struct {
+	languages []string
+	result    sandbox.Result
+	err       error
+}
+
+func (f *fakeCodeRunner) Run(ctx context.Context, language, code string) (sandbox.Result, error) {
+	f.languages = append(f.languages, language)
+	return f.result, f.err
+}
+
+func TestHandleMessages_CodeExecutionServerTool(t *testing.T) {
+	cfg := &config.Config{DebugEnabled: false, RequestTimeout: 10, ContextMaxTokens: 1024, ContextSummaryMaxTokens: 256, ContextKeepTurns: 2}
+	h := NewWithLoadBalancer(cfg, nil)
+	runner := &fakeCodeRunner{result: sandbox.Result{Stdout: "4\n"}}
+	h.codeExec = runner
+	up := &roundsUpstream{rounds: [][]upstream.SSEMessage{
+		{
+			{Type: "model", Event: map[string]any{"type": "text-start"}},
+			{Type: "model", Event: map[string]any{"type": "text-delta", "delta": "Computing."}},
+			{Type: "model", Event: map[string]any{"type": "text-end"}},
+			{Type: "model", Event: map[string]any{"type": "tool-call", "toolCallId": "call_1", "toolName": codeExecutionToolName, "input": `{"language":"python","code":"print(2+2)"}`}},
+			{Type: "model", Event: map[string]any{"type": "finish", "finishReason": "tool-calls"}},
+		},
+		{
+			{Type: "model", Event: map[string]any{"type": "text-start"}},
+			{Type: "model", Event: map[string]any{"type": "text-delta", "delta": "The answer is 4."}},
+			{Type: "model", Event: map[string]any{"type": "text-end"}},
+			{Type: "model", Event: map[string]any{"type": "finish", "finishReason": "stop"}},
+		},
+	}}
+	h.client = up
+	b, _ := json.Marshal(map[string]any{
+		"model":    "claude-3-5-sonnet",
+		"messages": []map[string]any{{"role": "user", "content": "compute 2+2 with python"}},
+		"tools":    []map[string]any{{"type": "code_execution_20250522", "name": "code_execution"}},
+		"stream":   false,
+	})
+
+	rec := httptest.NewRecorder()
+	h.HandleMessages(rec, httptest.NewRequest(http.MethodPost, "http://x/orchids/v1/messages", bytes.NewReader(b)))
+	var resp struct {
+		Content []map[string]any `json:"content"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode: %v (%s)", err, rec.Body.String())
+	}
+
+	var types []string
+	for _, block := range resp.Content {
+		types = append(types, block["type"].(string))
+	}
+	if got := strings.Join(types, ","); got != "text,server_tool_use,code_execution_tool_result,text" {
+		t.Fatalf("content types=%s body=%s", got, rec.Body.String())
+	}
+	use, result := resp.Content[1], resp.Content[2]
+	if use["name"] != "code_execution" || result["tool_use_id"] != use["id"] {
+		t.Fatalf("server tool blocks mismatch: %v %v", use, result)
+	}
+	content, _ := result["content"].(map[string]any)
+	if content["type"] != "code_execution_result" || content["stdout"] != "4\n" || content["return_code"] != float64(0) {
+		t.Fatalf("result content=%v", content)
+	}
+	if len(runner.languages) != 1 || runner.languages[0] != "python" {
+		t.Fatalf("runs=%v", runner.languages)
+	}
+
+	if len(up.reqs) != 2 {
+		t.Fatalf("upstream rounds=%d", len(up.reqs))
+	}
+	last := up.reqs[1].Messages[len(up.reqs[1].Messages)-1]
+	if last.Role != "user" || len(last.Content.Blocks) != 1 || !strings.Contains(last.Content.Blocks[0].Content.(string), "Exit code: 0") {
+		t.Fatalf("follow-up round missing execution output: %+v", last)
+	}
+}
+
+func TestCodeExecutionTool_ErrorCodes(t *testing.T) {
+	cases := []struct {
+		err  error
+		code string
+		want string
+	}{
+		{nil, " ", "invalid_tool_input"},
+		{sandbox.ErrTimeout, "while True: pass", "execution_time_exceeded"},
+		{sandbox.ErrUnsupportedLanguage, "puts 1", "invalid_tool_input"},
+		{context.Canceled, "print(1)", "unavailable"},
+	}
+	for _, tc := range cases {
+		ce := &codeExecutionTool{runner: &fakeCodeRunner{err: tc.err}}
+		if _, code := ce.execute(context.Background(), "python", tc.code); code != tc.want {
+			t.Fatalf("err=%v code=%q: error_code=%q want %q", tc.err, tc.code, code, tc.want)
+		}
+	}
+
+	// All sandbox slots are taken by other requests.
+	ce := &codeExecutionTool{runner: &fakeCodeRunner{}, slots: make(chan struct{}, 1)}
+	ce.slots <- struct{}{}
+	if _, code := ce.execute(context.Background(), "python", "print(1)"); code != "too_many_requests" {
+		t.Fatalf("full slots: error_code=%q", code)
+	}
+	<-ce.slots
+	if _, code := ce.execute(context.Background(), "python", "print(1)"); code != "" || len(ce.slots) != 0 {
+		t.Fatalf("free slot: error_code=%q slots=%d", code, len(ce.slots))
+	}
+}
+
+func TestHandleMessages_ToolTranscript(t *testing.T) {
+	cfg := &config.Config{DebugEnabled: false, RequestTimeout: 10, ContextMaxTokens: 1024, ContextSummaryMaxTokens: 256, ContextKeepTurns: 2,
+		ToolTranscript: true}
+	h := NewWithLoadBalancer(cfg, nil)
+	h.codeExec = &fakeCodeRunner{result: sandbox.Result{Stdout: strings.Repeat("x", 3000), ReturnCode: 1}}
+	h.client = &roundsUpstream{rounds: [][]upstream.SSEMessage{
+		{
+			{Type: "model", Event: map[string]any{"type": "tool-call", "toolCallId": "call_1", "toolName": codeExecutionToolName, "input": `{"code":"print('x'*3000)"}`}},
+			{Type: "model", Event: map[string]any{"type": "finish", "finishReason": "tool-calls"}},
+		},
+		{
+			{Type: "model", Event: map[string]any{"type": "text-start"}},
+			{Type: "model", Event: map[string]any{"type": "text-delta", "delta": "Done."}},
+			{Type: "model", Event: map[string]any{"type": "text-end"}},
+			{Type: "model", Event: map[string]any{"type": "finish", "finishReason": "stop"}},
+		},
+	}}
+	b, _ := json.Marshal(map[string]any{
+		"model":    "claude-3-5-sonnet",
+		"messages": []map[string]any{{"role": "user", "content": "print a long line"}},
+		"tools":    []map[string]any{{"type": "code_execution_20250522", "name": "code_execution"}},
+		"stream":   true,
+	})
+
+	rec := httptest.NewRecorder()
+	h.HandleMessages(rec, httptest.NewRequest(http.MethodPost, "http://x/orchids/v1/messages", bytes.NewReader(b)))
+	var delta struct {
+		Transcript []map[string]any `json:"transcript"`
+	}
+	for _, line := range strings.Split(rec.Body.String(), "\n") {
+		if data, ok := strings.CutPrefix(line, "data: "); ok && strings.Contains(data, `"message_delta"`) {
+			if err := json.Unmarshal([]byte(data), &delta); err != nil {
+				t.Fatalf("decode message_delta: %v", err)
+			}
+		}
+	}
+	if len(delta.Transcript) != 1 {
+		t.Fatalf("transcript=%v body=%s", delta.Transcript, rec.Body.String())
+	}
+	entry := delta.Transcript[0]
+	if entry["round"] != float64(1) || entry["tool"] != "code_execution" || entry["is_error"] != false {
+		t.Fatalf("entry=%v", entry)
+	}
+	result, _ := entry["result"].(string)
+	if !strings.HasPrefix(result, "Exit code: 1") || !strings.HasSuffix(result, "…[truncated]") {
+		t.Fatalf("expected a truncated result, got %q", result)
+	}
+}
@@ -0,0 +1,59 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"orchids-api/internal/util"
+)
+
+// concurrencyPollInterval is how often a queued request re-checks for a free
+// account or model slot.
+const concurrencyPollInterval = 200 * time.Millisecond
+
+var errConcurrencyLimited = errors.New("all matching accounts are at their concurrency limit")
+
+// modelSlots counts in-flight requests per model for model_max_concurrency.
+// Counts are per process.
+type modelSlots struct {
+	mu     sync.Mutex
+	active map[string]int
+}
+
+func newModelSlots() *modelSlots {
+	return &modelSlots{active: make(map[string]int)}
+}
+
+func (s *modelSlots) tryAcquire(model string, limit int) bool {
+	key := strings.ToLower(strings.TrimSpace(model))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.active[key] >= limit {
+		return false
+	}
+	s.active[key]++
+	return true
+}
+
+func (s *modelSlots) release(model string) {
+	key := strings.ToLower(strings.TrimSpace(model))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.active[key] <= 1 {
+		delete(s.active, key)
+		return
+	}
+	s.active[key]--
+}
+
+// waitForSlot sleeps one poll interval unless the queue deadline has passed or
+// ctx is done, and reports whether the caller should try again.
+func waitForSlot(ctx context.Context, deadline time.Time) bool {
+	if !time.Now().Before(deadline) {
+		return false
+	}
+	return util.SleepWithContext(ctx, concurrencyPollInterval)
+}
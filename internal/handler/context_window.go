@@ -0,0 +1,43 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+)
+
+// modelContextWindow returns the context window configured for model, or 0
+// when the model is unknown or has none.
+func (h *Handler) modelContextWindow(ctx context.Context, model string) int {
+	if h.loadBalancer == nil || h.loadBalancer.Store == nil || model == "" {
+		return 0
+	}
+	m, err := h.loadBalancer.Store.GetModelByModelID(ctx, h.resolveModelAlias(ctx, model))
+	if err != nil || m == nil {
+		return 0
+	}
+	return m.ContextWindow
+}
+
+// contextOverflowMessage explains an oversized prompt. It starts like the
+// Anthropic API error so clients that compact on "prompt is too long" still
+// recognise it.
+func contextOverflowMessage(model string, bd inputTokenBreakdown, limit int) string {
+	return fmt.Sprintf(
+		"prompt is too long: estimated %d tokens > %d token context window of %s "+
+			"(history %d, tools %d, system %d). Remove earlier messages or large tool results, or start a new conversation.",
+		bd.Total, limit, model, bd.HistoryTokens, bd.ToolsTokens, bd.BasePromptTokens+bd.SystemContextTokens,
+	)
+}
+
+// checkContextWindow rejects the request when its estimated input exceeds
+// the model's context window, instead of letting the upstream fail.
+func (p *messagesPipeline) checkContextWindow(bd inputTokenBreakdown) bool {
+	limit := p.h.modelContextWindow(p.r.Context(), p.req.Model)
+	if limit <= 0 || bd.Total <= limit {
+		return true
+	}
+	slog.Warn("Rejected request over model context window", "model", p.req.Model, "estimated_tokens", bd.Total, "context_window", limit)
+	return p.fail("invalid_request_error", contextOverflowMessage(p.req.Model, bd, limit), http.StatusBadRequest)
+}
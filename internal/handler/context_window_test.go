@@ -0,0 +1,59 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"orchids-api/internal/loadbalancer"
+	"orchids-api/internal/store"
+)
+
+func TestCheckContextWindow(t *testing.T) {
+	mr := miniredis.RunT(t)
+	s, err := store.New(store.Options{RedisAddr: mr.Addr(), RedisPrefix: "test:"})
+	if err != nil {
+		t.Fatalf("store.New: %v", err)
+	}
+	ctx := context.Background()
+	m, err := s.GetModelByModelID(ctx, "claude-sonnet-4-5")
+	if err != nil {
+		t.Fatalf("GetModelByModelID: %v", err)
+	}
+	m.ContextWindow = 1000
+	if err := s.UpdateModel(ctx, m); err != nil {
+		t.Fatalf("UpdateModel: %v", err)
+	}
+	h := &Handler{loadBalancer: loadbalancer.NewWithCacheTTL(s, 0)}
+
+	check := func(model string, total int) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		p := newMessagesPipeline(h, rec, httptest.NewRequest(http.MethodPost, "/v1/messages", nil))
+		p.req.Model = model
+		if p.checkContextWindow(inputTokenBreakdown{HistoryTokens: total - 100, ToolsTokens: 100, Total: total}) != (rec.Body.Len() == 0) {
+			t.Fatalf("%s/%d: result does not match response", model, total)
+		}
+		return rec
+	}
+
+	if rec := check("claude-sonnet-4-5", 1000); rec.Body.Len() != 0 {
+		t.Fatalf("request at the limit should pass: %s", rec.Body.String())
+	}
+	rec := check("claude-sonnet-4-5", 1500)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status=%d want 400", rec.Code)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{"invalid_request_error", "prompt is too long: estimated 1500 tokens", "1000 token context window", "history 1400, tools 100"} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("body missing %q: %s", want, body)
+		}
+	}
+	if rec := check("claude-opus-4-5", 1_000_000); rec.Body.Len() != 0 {
+		t.Fatalf("models without a context window should not be checked: %s", rec.Body.String())
+	}
+}
@@ -0,0 +1,120 @@
+package handler
+
+import (
+	"log/slog"
+	"strings"
+
+	"orchids-api/internal/orchids"
+	"orchids-api/internal/prompt"
+	"orchids-api/internal/upstream"
+)
+
+// continuationPrompt asks upstream to pick up a response it cut short.
+const continuationPrompt = "Your previous response was cut off by the output limit. Continue exactly where it stopped, without repeating anything and without any preamble."
+
+// isTruncatedFinish reports whether an upstream finish reason means the
+// output hit a length limit rather than ending naturally.
+func isTruncatedFinish(reason string) bool {
+	switch strings.ToLower(strings.TrimSpace(reason)) {
+	case "length", "max_tokens", "max-tokens", "limit":
+		return true
+	}
+	return false
+}
+
+// takeTruncated reports whether the last round ended on a truncated finish
+// that should be continued, and returns the text that round produced.
+func (h *streamHandler) takeTruncated() (string, bool) {
+	h.mu.Lock()
+	if !h.truncated {
+		h.mu.Unlock()
+		return "", false
+	}
+	h.truncated = false
+	h.continuationsLeft--
+	text := h.roundTextLocked()
+	h.mu.Unlock()
+
+	h.outputMu.Lock()
+	if h.useUpstreamUsage {
+		h.continuedOutputTokens = h.outputTokens
+	}
+	h.outputMu.Unlock()
+	return text, true
+}
+
+// roundTextLocked returns the text produced since the previous follow-up
+// round started. Callers hold h.mu.
+func (h *streamHandler) roundTextLocked() string {
+	var sb strings.Builder
+	for i := range h.contentBlocks {
+		if b, ok := h.textBlockBuilders[i]; ok {
+			sb.WriteString(b.String())
+		}
+	}
+	full := sb.String()
+	text := full[min(h.continuedTextLen, len(full)):]
+	h.continuedTextLen = len(full)
+	return text
+}
+
+// continueTruncated prepares a follow-up round when upstream stopped on its
+// output limit: the partial answer is replayed as an assistant turn followed
+// by a request to continue, and the new text is appended to the same
+// response. It returns false when there is nothing to continue.
+func (p *messagesPipeline) continueTruncated(upstreamReq *upstream.UpstreamRequest) bool {
+	text, ok := p.sh.takeTruncated()
+	if !ok {
+		return false
+	}
+	cfg := p.h.config
+	slog.Info("Upstream output truncated, requesting continuation",
+		"continuation", cfg.MaxContinuations-p.sh.continuationsLeft,
+		"max", cfg.MaxContinuations,
+		"text_len", len(text),
+	)
+
+	if text != "" {
+		p.upstreamMessages = append(p.upstreamMessages, prompt.Message{
+			Role:    "assistant",
+			Content: prompt.MessageContent{Text: text},
+		})
+	}
+	p.upstreamMessages = append(p.upstreamMessages, prompt.Message{
+		Role:    "user",
+		Content: prompt.MessageContent{Text: continuationPrompt},
+	})
+
+	upstreamReq.NoThinking = true
+	p.rebuildPrompt(upstreamReq)
+	return true
+}
+
+// decoratePrompt adds the gateway's prompt sections (workspace snapshot, tool
+// gate) to a freshly built prompt.
+func (p *messagesPipeline) decoratePrompt(builtPrompt string) string {
+	if p.workspaceNote != "" {
+		builtPrompt = injectPromptSection(builtPrompt, "workspace", p.workspaceNote)
+	}
+	if p.toolGate != "" {
+		builtPrompt = injectToolGate(builtPrompt, p.toolGate)
+	}
+	return builtPrompt
+}
+
+// rebuildPrompt re-renders the upstream prompt after p.upstreamMessages grew
+// during a follow-up round.
+func (p *messagesPipeline) rebuildPrompt(upstreamReq *upstream.UpstreamRequest) {
+	builtPrompt, aiClientHistory, _ := orchids.BuildAIClientPromptAndHistoryWithMeta(p.upstreamMessages, p.promptSystem, p.mappedModel, upstreamReq.NoThinking, p.workdir, p.h.config.ContextMaxTokens)
+	if _, isOrchidsAIClient := p.apiClient.(*orchids.Client); isOrchidsAIClient {
+		p.chatHistory = make([]interface{}, 0, len(aiClientHistory))
+		for _, item := range aiClientHistory {
+			p.chatHistory = append(p.chatHistory, item)
+		}
+	}
+	p.builtPrompt = p.decoratePrompt(builtPrompt)
+
+	upstreamReq.Prompt = p.builtPrompt
+	upstreamReq.ChatHistory = p.chatHistory
+	upstreamReq.Messages = p.upstreamMessages
+}
@@ -0,0 +1,195 @@
+package handler
+
+import (
+	"context"
+	"github.com/goccy/go-json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	apperrors "orchids-api/internal/errors"
+)
+
+const defaultConversationUsageTTL = 24 * time.Hour
+
+// ConversationUsage is the cumulative token usage of one conversation.
+// LastInputTokens is the input size of its latest request, which tracks how
+// much of the channel's context window the conversation currently fills.
+type ConversationUsage struct {
+	ConversationID  string    `json:"conversation_id"`
+	Requests        int64     `json:"requests"`
+	InputTokens     int64     `json:"input_tokens"`
+	OutputTokens    int64     `json:"output_tokens"`
+	LastInputTokens int64     `json:"last_input_tokens"`
+	Model           string    `json:"model,omitempty"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+// ConversationUsageStore keeps per-conversation token totals. Records expire
+// after the store's TTL without activity.
+type ConversationUsageStore interface {
+	Get(ctx context.Context, conversationID string) (ConversationUsage, bool)
+	Record(ctx context.Context, conversationID, model string, inputTokens, outputTokens int)
+}
+
+// --- Redis Implementation ---
+
+// RedisConversationUsageStore stores usage as Redis HASHes with automatic TTL.
+type RedisConversationUsageStore struct {
+	client *redis.Client
+	prefix string
+	ttl    time.Duration
+}
+
+func NewRedisConversationUsageStore(client *redis.Client, prefix string, ttl time.Duration) *RedisConversationUsageStore {
+	if ttl <= 0 {
+		ttl = defaultConversationUsageTTL
+	}
+	return &RedisConversationUsageStore{
+		client: client,
+		prefix: prefix + "convusage:",
+		ttl:    ttl,
+	}
+}
+
+func (s *RedisConversationUsageStore) Get(ctx context.Context, conversationID string) (ConversationUsage, bool) {
+	vals, err := s.client.HGetAll(ctx, s.prefix+conversationID).Result()
+	if err != nil || len(vals) == 0 {
+		return ConversationUsage{}, false
+	}
+	parse := func(field string) int64 {
+		n, _ := strconv.ParseInt(vals[field], 10, 64)
+		return n
+	}
+	usage := ConversationUsage{
+		ConversationID:  conversationID,
+		Requests:        parse("requests"),
+		InputTokens:     parse("input_tokens"),
+		OutputTokens:    parse("output_tokens"),
+		LastInputTokens: parse("last_input_tokens"),
+		Model:           vals["model"],
+	}
+	if ms := parse("updated_at"); ms > 0 {
+		usage.UpdatedAt = time.UnixMilli(ms)
+	}
+	return usage, true
+}
+
+func (s *RedisConversationUsageStore) Record(_ context.Context, conversationID, model string, inputTokens, outputTokens int) {
+	// Recorded after the response, when the request context may be done.
+	ctx := context.Background()
+	key := s.prefix + conversationID
+	pipe := s.client.TxPipeline()
+	pipe.HIncrBy(ctx, key, "requests", 1)
+	pipe.HIncrBy(ctx, key, "input_tokens", int64(inputTokens))
+	pipe.HIncrBy(ctx, key, "output_tokens", int64(outputTokens))
+	pipe.HSet(ctx, key, "last_input_tokens", inputTokens, "model", model, "updated_at", time.Now().UnixMilli())
+	pipe.Expire(ctx, key, s.ttl)
+	pipe.Exec(ctx)
+}
+
+// --- Memory Implementation ---
+
+// MemoryConversationUsageStore keeps usage in process; idle records are
+// removed by a background cleaner.
+type MemoryConversationUsageStore struct {
+	usage   *ShardedMap[ConversationUsage]
+	ttl     time.Duration
+	cleaner *AsyncCleaner
+}
+
+func NewMemoryConversationUsageStore(ttl time.Duration) *MemoryConversationUsageStore {
+	if ttl <= 0 {
+		ttl = defaultConversationUsageTTL
+	}
+	s := &MemoryConversationUsageStore{
+		usage: NewShardedMap[ConversationUsage](),
+		ttl:   ttl,
+	}
+	s.cleaner = NewAsyncCleaner(10 * time.Minute)
+	s.cleaner.Start(func() {
+		now := time.Now()
+		s.usage.RangeDelete(func(_ string, u ConversationUsage) bool {
+			return now.Sub(u.UpdatedAt) > s.ttl
+		})
+	})
+	return s
+}
+
+func (s *MemoryConversationUsageStore) Get(_ context.Context, conversationID string) (ConversationUsage, bool) {
+	u, ok := s.usage.Get(conversationID)
+	if !ok || time.Since(u.UpdatedAt) > s.ttl {
+		return ConversationUsage{}, false
+	}
+	return u, true
+}
+
+func (s *MemoryConversationUsageStore) Record(_ context.Context, conversationID, model string, inputTokens, outputTokens int) {
+	now := time.Now()
+	s.usage.Compute(conversationID, func(cur ConversationUsage, exists bool) (ConversationUsage, bool) {
+		if !exists || now.Sub(cur.UpdatedAt) > s.ttl {
+			cur = ConversationUsage{ConversationID: conversationID}
+		}
+		cur.Requests++
+		cur.InputTokens += int64(inputTokens)
+		cur.OutputTokens += int64(outputTokens)
+		cur.LastInputTokens = int64(inputTokens)
+		cur.Model = model
+		cur.UpdatedAt = now
+		return cur, true
+	})
+}
+
+// Stop ends the background cleaner.
+func (s *MemoryConversationUsageStore) Stop() {
+	s.cleaner.Stop()
+}
+
+// setConversationUsageHeaders reports the conversation's totals so far plus
+// the estimated input of the request being answered.
+func (p *messagesPipeline) setConversationUsageHeaders() {
+	if p.conversationKey == "" || p.h.conversationUsage == nil {
+		return
+	}
+	usage, _ := p.h.conversationUsage.Get(p.r.Context(), p.conversationKey)
+	header := p.w.Header()
+	header.Set("X-Conversation-Requests", strconv.FormatInt(usage.Requests+1, 10))
+	header.Set("X-Conversation-Input-Tokens", strconv.FormatInt(usage.InputTokens+int64(p.inputTokens), 10))
+	header.Set("X-Conversation-Output-Tokens", strconv.FormatInt(usage.OutputTokens, 10))
+	if limit := p.h.config.ContextMaxTokens; limit > 0 {
+		header.Set("X-Conversation-Context-Limit", strconv.Itoa(limit))
+	}
+}
+
+// HandleConversationUsage serves GET /api/conversations/{id}/usage.
+func (h *Handler) HandleConversationUsage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		apperrors.New("invalid_request_error", "Method not allowed", http.StatusMethodNotAllowed).WriteResponse(w)
+		return
+	}
+	rest := strings.TrimPrefix(r.URL.Path, "/api/conversations/")
+	id, ok := strings.CutSuffix(rest, "/usage")
+	id = strings.TrimSpace(id)
+	if !ok || id == "" || strings.Contains(id, "/") {
+		apperrors.New("not_found_error", "not found", http.StatusNotFound).WriteResponse(w)
+		return
+	}
+	if h.conversationUsage == nil {
+		apperrors.New("not_found_error", "conversation not found", http.StatusNotFound).WriteResponse(w)
+		return
+	}
+	usage, found := h.conversationUsage.Get(r.Context(), id)
+	if !found {
+		apperrors.New("not_found_error", "conversation not found", http.StatusNotFound).WriteResponse(w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"usage":              usage,
+		"context_max_tokens": h.config.ContextMaxTokens,
+	})
+}
@@ -0,0 +1,79 @@
+package handler
+
+import (
+	"context"
+	"github.com/goccy/go-json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"orchids-api/internal/config"
+)
+
+func TestMemoryConversationUsageStore_Record(t *testing.T) {
+	t.Parallel()
+
+	s := NewMemoryConversationUsageStore(time.Hour)
+	defer s.Stop()
+	ctx := context.Background()
+
+	if _, ok := s.Get(ctx, "conv-1"); ok {
+		t.Fatal("expected no usage before the first request")
+	}
+	s.Record(ctx, "conv-1", "claude-sonnet-4-5", 1200, 300)
+	s.Record(ctx, "conv-1", "claude-sonnet-4-5", 1800, 200)
+
+	u, ok := s.Get(ctx, "conv-1")
+	if !ok {
+		t.Fatal("expected usage")
+	}
+	if u.Requests != 2 || u.InputTokens != 3000 || u.OutputTokens != 500 || u.LastInputTokens != 1800 {
+		t.Fatalf("unexpected usage: %+v", u)
+	}
+
+	s.usage.Compute("conv-1", func(cur ConversationUsage, _ bool) (ConversationUsage, bool) {
+		cur.UpdatedAt = time.Now().Add(-2 * time.Hour)
+		return cur, true
+	})
+	if _, ok := s.Get(ctx, "conv-1"); ok {
+		t.Fatal("expired usage should not be returned")
+	}
+	s.Record(ctx, "conv-1", "claude-sonnet-4-5", 100, 10)
+	if u, _ := s.Get(ctx, "conv-1"); u.Requests != 1 || u.InputTokens != 100 {
+		t.Fatalf("expired usage should restart, got %+v", u)
+	}
+}
+
+func TestHandleConversationUsage(t *testing.T) {
+	t.Parallel()
+
+	store := NewMemoryConversationUsageStore(time.Hour)
+	defer store.Stop()
+	store.Record(context.Background(), "conv-1", "claude-sonnet-4-5", 500, 50)
+	h := &Handler{config: &config.Config{ContextMaxTokens: 100000}, conversationUsage: store}
+
+	rec := httptest.NewRecorder()
+	h.HandleConversationUsage(rec, httptest.NewRequest(http.MethodGet, "/api/conversations/conv-1/usage", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status=%d body=%s", rec.Code, rec.Body.String())
+	}
+	var body struct {
+		Usage            ConversationUsage `json:"usage"`
+		ContextMaxTokens int               `json:"context_max_tokens"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if body.Usage.ConversationID != "conv-1" || body.Usage.InputTokens != 500 || body.ContextMaxTokens != 100000 {
+		t.Fatalf("unexpected body: %+v", body)
+	}
+
+	for _, path := range []string{"/api/conversations/missing/usage", "/api/conversations/conv-1"} {
+		rec = httptest.NewRecorder()
+		h.HandleConversationUsage(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusNotFound {
+			t.Fatalf("%s: status=%d want 404", path, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+
+	"orchids-api/internal/prompt"
+)
+
+// conversionWarningsHeader lists the codes of the conversion warnings known
+// when the response headers are written.
+const conversionWarningsHeader = "X-Conversion-Warnings"
+
+// Conversion warning codes.
+const (
+	warnContentBlockDropped = "content_block_dropped" // block type no channel converter understands
+	warnToolUnsupported     = "tool_unsupported"      // tool the channel cannot call, described in the prompt instead
+	warnImagesReplaced      = "images_replaced"       // history images replaced with placeholders for a text-only channel
+	warnToolResultCompacted = "tool_result_truncated" // tool_result content truncated, pruned or summarized
+	warnHistoryTrimmed      = "history_trimmed"       // older messages summarized or dropped to fit the token budget
+)
+
+// convertibleBlockTypes are the content block types the prompt builders
+// translate for at least one channel. Thinking blocks are replayed or
+// omitted by design and do not warrant a warning.
+var convertibleBlockTypes = map[string]bool{
+	"text":              true,
+	"image":             true,
+	"document":          true,
+	"tool_use":          true,
+	"tool_result":       true,
+	"thinking":          true,
+	"redacted_thinking": true,
+}
+
+// conversionWarning describes content the proxy dropped or changed while
+// converting a request for the upstream.
+type conversionWarning struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// conversionWarnings collects the warnings of one request. Warnings are
+// added from the build stage and from upstream attempts, and read when the
+// response is finished.
+type conversionWarnings struct {
+	mu   sync.Mutex
+	list []conversionWarning
+}
+
+// add records a warning; an identical warning is only recorded once.
+func (c *conversionWarnings) add(code, format string, args ...interface{}) {
+	if c == nil {
+		return
+	}
+	w := conversionWarning{Code: code, Message: fmt.Sprintf(format, args...)}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !slices.Contains(c.list, w) {
+		c.list = append(c.list, w)
+	}
+}
+
+// all returns a copy of the collected warnings.
+func (c *conversionWarnings) all() []conversionWarning {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Clone(c.list)
+}
+
+// setHeader writes the distinct warning codes collected so far to header.
+func (c *conversionWarnings) setHeader(header http.Header) {
+	var codes []string
+	for _, w := range c.all() {
+		if !slices.Contains(codes, w.Code) {
+			codes = append(codes, w.Code)
+		}
+	}
+	if len(codes) > 0 {
+		header.Set(conversionWarningsHeader, strings.Join(codes, ", "))
+	}
+}
+
+// warnDroppedBlocks records the content block types in messages that no
+// prompt builder converts.
+func (c *conversionWarnings) warnDroppedBlocks(messages []prompt.Message) {
+	counts := make(map[string]int)
+	var order []string
+	for _, msg := range messages {
+		for _, block := range msg.Content.Blocks {
+			if block.Type == "" || convertibleBlockTypes[block.Type] {
+				continue
+			}
+			if counts[block.Type] == 0 {
+				order = append(order, block.Type)
+			}
+			counts[block.Type]++
+		}
+	}
+	for _, typ := range order {
+		c.add(warnContentBlockDropped, "%d %q content block(s) are not supported and were dropped", counts[typ], typ)
+	}
+}
+
+// warnUnsupportedTools records the tools the channel cannot call; described
+// reports whether they were listed in the prompt instead.
+func (p *messagesPipeline) warnUnsupportedTools(unsupported []interface{}, described bool) {
+	channel := p.toolChannel()
+	if channel == "" {
+		channel = "orchids"
+	}
+	for _, tool := range unsupported {
+		name, serverType := toolIdentity(tool)
+		if name == "" {
+			name = serverType
+		}
+		if name == "" {
+			continue
+		}
+		if !described {
+			p.warnings.add(warnToolUnsupported, "tool %q is not supported by channel %s and was dropped", name, channel)
+			continue
+		}
+		p.warnings.add(warnToolUnsupported, "tool %q is not supported by channel %s; it is described in the prompt instead of being callable", name, channel)
+	}
+}
+
+// warnToolResultsCompressed records tool results shortened for the upstream.
+func (p *messagesPipeline) warnToolResultsCompressed(n int) {
+	if n > 0 {
+		p.warnings.add(warnToolResultCompacted, "%d tool result(s) shortened to fit the channel's size limit", n)
+	}
+}
@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/goccy/go-json"
+
+	"orchids-api/internal/config"
+	"orchids-api/internal/upstream"
+)
+
+func TestConversionWarningsHeader(t *testing.T) {
+	var c conversionWarnings
+	c.add(warnToolResultCompacted, "%d tool result(s) shortened", 2)
+	c.add(warnToolResultCompacted, "%d tool result(s) shortened", 2)
+	c.add(warnToolUnsupported, "tool %q", "a")
+	c.add(warnToolUnsupported, "tool %q", "b")
+	if got := len(c.all()); got != 3 {
+		t.Fatalf("warnings=%d want 3 (duplicates collapsed)", got)
+	}
+	header := http.Header{}
+	c.setHeader(header)
+	if got := header.Get(conversionWarningsHeader); got != "tool_result_truncated, tool_unsupported" {
+		t.Fatalf("header=%q", got)
+	}
+
+	var nilWarnings *conversionWarnings
+	nilWarnings.add(warnHistoryTrimmed, "ignored")
+	if nilWarnings.all() != nil {
+		t.Fatal("nil collector returned warnings")
+	}
+}
+
+func TestHandleMessages_ReportsDroppedBlocks(t *testing.T) {
+	cfg := &config.Config{RequestTimeout: 10, ContextMaxTokens: 1024, ContextSummaryMaxTokens: 256, ContextKeepTurns: 2}
+	h := NewWithLoadBalancer(cfg, nil)
+	h.client = &mockUpstream{events: []upstream.SSEMessage{
+		{Type: "model", Event: map[string]any{"type": "text-delta", "delta": "ok"}},
+		{Type: "model", Event: map[string]any{"type": "finish", "finishReason": "stop"}},
+	}}
+	body := `{"model":"claude-sonnet-4-5","stream":true,"messages":[{"role":"user","content":[
+		{"type":"text","text":"see results"},
+		{"type":"container_upload","file_id":"file_1"},
+		{"type":"container_upload","file_id":"file_2"}]}]}`
+
+	rec := httptest.NewRecorder()
+	h.HandleMessages(rec, httptest.NewRequest(http.MethodPost, "http://x/v1/messages", bytes.NewReader([]byte(body))))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status=%d body=%s", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get(conversionWarningsHeader); got != warnContentBlockDropped {
+		t.Fatalf("%s=%q", conversionWarningsHeader, got)
+	}
+	_, stop, ok := strings.Cut(rec.Body.String(), "event: message_stop\ndata: ")
+	if !ok {
+		t.Fatalf("no message_stop: %s", rec.Body.String())
+	}
+	var event struct {
+		Warnings []conversionWarning `json:"conversion_warnings"`
+	}
+	if err := json.Unmarshal([]byte(strings.TrimSpace(stop)), &event); err != nil {
+		t.Fatalf("decode message_stop: %v", err)
+	}
+	if len(event.Warnings) != 1 || !strings.Contains(event.Warnings[0].Message, `2 "container_upload"`) {
+		t.Fatalf("message_stop warnings=%+v", event.Warnings)
+	}
+}
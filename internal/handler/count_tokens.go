@@ -4,7 +4,6 @@ import (
 	"github.com/goccy/go-json"
 	"net/http"
 
-	"orchids-api/internal/debug"
 	"orchids-api/internal/orchids"
 )
 
@@ -21,7 +20,7 @@ func (h *Handler) HandleCountTokens(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	logger := debug.New(h.config.DebugEnabled, h.config.DebugLogSSE)
+	logger := h.newDebugLogger(h.requestLogResidency(r), h.config.DebugEnabled, h.config.DebugLogSSE)
 	defer logger.Close()
 	logger.LogIncomingRequest(req)
 
@@ -0,0 +1,43 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+	"time"
+
+	"orchids-api/internal/store"
+)
+
+const (
+	debugCaptureHeader = "X-Debug-Capture"
+	adminTokenHeader   = "X-Admin-Token"
+)
+
+// debugCaptureRequested reports whether the caller asked for, and may have, a
+// debug capture of this request while global debug logging is off. key is
+// the caller's API key, nil when it sent none or an unknown or disabled one.
+func (h *Handler) debugCaptureRequested(r *http.Request, key *store.ApiKey) bool {
+	switch strings.ToLower(strings.TrimSpace(r.Header.Get(debugCaptureHeader))) {
+	case "on", "1", "true", "yes":
+	default:
+		return false
+	}
+	switch strings.ToLower(strings.TrimSpace(h.config.DebugCapture)) {
+	case "keys":
+		// The gateway also serves requests without a key; only callers with
+		// a valid key may have their requests written to disk.
+		return key != nil && key.Enabled && authorizeKeyAccess(key, r, h.trustedProxies(), time.Now()) == nil
+	case "admin":
+		token := strings.TrimSpace(r.Header.Get(adminTokenHeader))
+		if token == "" {
+			return false
+		}
+		for _, secret := range []string{h.config.AdminToken, h.config.AdminPass} {
+			if secret != "" && subtle.ConstantTimeCompare([]byte(token), []byte(secret)) == 1 {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,157 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/goccy/go-json"
+
+	"orchids-api/internal/config"
+	"orchids-api/internal/loadbalancer"
+	"orchids-api/internal/store"
+	"orchids-api/internal/upstream"
+)
+
+func TestHandleMessages_DebugCaptureHeader(t *testing.T) {
+	t.Chdir(t.TempDir())
+	reply := []upstream.SSEMessage{
+		{Type: "model", Event: map[string]any{"type": "text-start"}},
+		{Type: "model", Event: map[string]any{"type": "text-delta", "delta": "ok"}},
+		{Type: "model", Event: map[string]any{"type": "text-end"}},
+		{Type: "model", Event: map[string]any{"type": "finish", "finishReason": "stop"}},
+	}
+	mr := miniredis.RunT(t)
+	s, err := store.New(store.Options{RedisAddr: mr.Addr(), RedisPrefix: "test:"})
+	if err != nil {
+		t.Fatalf("store.New: %v", err)
+	}
+	sum := sha256.Sum256([]byte("sk-capture"))
+	if err := s.CreateApiKey(context.Background(), &store.ApiKey{Name: "capture", KeyHash: hex.EncodeToString(sum[:]), Enabled: true}); err != nil {
+		t.Fatalf("CreateApiKey: %v", err)
+	}
+	cases := []struct {
+		name    string
+		mode    string
+		header  string
+		token   string
+		apiKey  string
+		capture bool
+	}{
+		{"disabled", "", "on", "secret", "", false},
+		{"keys", "keys", "on", "", "sk-capture", true},
+		{"keys without api key", "keys", "on", "", "", false},
+		{"keys with unknown api key", "keys", "on", "", "sk-unknown", false},
+		{"keys without header", "keys", "", "", "sk-capture", false},
+		{"admin with token", "admin", "1", "secret", "", true},
+		{"admin with wrong token", "admin", "on", "nope", "", false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := &config.Config{DebugEnabled: false, RequestTimeout: 10, ContextMaxTokens: 1024, ContextSummaryMaxTokens: 256, ContextKeepTurns: 2,
+				DebugCapture: tc.mode, AdminToken: "secret"}
+			h := NewWithLoadBalancer(cfg, loadbalancer.NewWithCacheTTL(s, 0))
+			h.client = &roundsUpstream{rounds: [][]upstream.SSEMessage{reply}}
+			b, _ := json.Marshal(map[string]any{
+				"model":    "claude-sonnet-4-5",
+				"messages": []map[string]any{{"role": "user", "content": "hi"}},
+				"stream":   false,
+			})
+			req := httptest.NewRequest(http.MethodPost, "http://x/v1/messages", bytes.NewReader(b))
+			if tc.header != "" {
+				req.Header.Set(debugCaptureHeader, tc.header)
+			}
+			if tc.token != "" {
+				req.Header.Set(adminTokenHeader, tc.token)
+			}
+			if tc.apiKey != "" {
+				req.Header.Set("X-Api-Key", tc.apiKey)
+			}
+			rec := httptest.NewRecorder()
+			h.HandleMessages(rec, req)
+			// An unknown key is rejected before anything could be captured.
+			wantStatus := http.StatusOK
+			if tc.apiKey == "sk-unknown" {
+				wantStatus = http.StatusUnauthorized
+			}
+			if rec.Code != wantStatus {
+				t.Fatalf("status=%d body=%s", rec.Code, rec.Body.String())
+			}
+
+			dir := rec.Header().Get(debugCaptureHeader)
+			if (dir != "") != tc.capture {
+				t.Fatalf("capture header=%q want capture=%v", dir, tc.capture)
+			}
+			if tc.capture {
+				if _, err := os.Stat(filepath.Join("debug-logs", dir, "1_claude_request.json")); err != nil {
+					t.Fatalf("request not captured: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestHandleMessages_FailureSnapshots(t *testing.T) {
+	ok := []upstream.SSEMessage{
+		{Type: "model", Event: map[string]any{"type": "text-start"}},
+		{Type: "model", Event: map[string]any{"type": "text-delta", "delta": "ok"}},
+		{Type: "model", Event: map[string]any{"type": "text-end"}},
+		{Type: "model", Event: map[string]any{"type": "finish", "finishReason": "stop"}},
+	}
+	cases := []struct {
+		name   string
+		client UpstreamClient
+		reason string
+	}{
+		{"success", &mockUpstreamEdge{events: ok}, ""},
+		{"empty response", &mockUpstreamEdge{events: []upstream.SSEMessage{
+			{Type: "model", Event: map[string]any{"type": "finish", "finishReason": "stop"}},
+		}}, "empty_response"},
+		{"upstream error", &failingUpstream{err: errors.New("upstream request failed with status 400: bad request")}, "upstream_error"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			cfg := &config.Config{DebugEnabled: false, RequestTimeout: 10, ContextMaxTokens: 1024, ContextSummaryMaxTokens: 256, ContextKeepTurns: 2,
+				FailureSnapshots: true, FailureSnapshotDir: dir, FailureSnapshotMax: 10}
+			h := NewWithLoadBalancer(cfg, nil)
+			h.client = tc.client
+			b, _ := json.Marshal(map[string]any{
+				"model":    "claude-3-5-sonnet",
+				"messages": []map[string]any{{"role": "user", "content": "hi"}},
+				"stream":   false,
+			})
+			h.HandleMessages(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "http://x/orchids/v1/messages", bytes.NewReader(b)))
+
+			entries, _ := os.ReadDir(dir)
+			if tc.reason == "" {
+				if len(entries) != 0 {
+					t.Fatalf("unexpected snapshots: %v", entries)
+				}
+				return
+			}
+			if len(entries) != 1 {
+				t.Fatalf("snapshots=%v", entries)
+			}
+			snapshot := filepath.Join(dir, entries[0].Name())
+			var failure struct {
+				Reason string `json:"reason"`
+			}
+			data, err := os.ReadFile(filepath.Join(snapshot, "0_failure.json"))
+			if err != nil || json.Unmarshal(data, &failure) != nil || failure.Reason != tc.reason {
+				t.Fatalf("failure=%s err=%v", data, err)
+			}
+			if _, err := os.Stat(filepath.Join(snapshot, "1_claude_request.json")); err != nil {
+				t.Fatalf("request log missing: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,194 @@
+package handler
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"log/slog"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-json"
+
+	"orchids-api/internal/embeddings"
+	apperrors "orchids-api/internal/errors"
+	"orchids-api/internal/tiktoken"
+)
+
+// embeddingsRequest is an OpenAI /v1/embeddings request body.
+type embeddingsRequest struct {
+	Model          string          `json:"model"`
+	Input          json.RawMessage `json:"input"`
+	EncodingFormat string          `json:"encoding_format"`
+	Dimensions     int             `json:"dimensions"`
+	User           string          `json:"user"`
+}
+
+// inputs returns the request's input as a list of strings. Token-array inputs
+// are not supported since the backends tokenize themselves.
+func (req *embeddingsRequest) inputs() ([]string, bool) {
+	var single string
+	if err := json.Unmarshal(req.Input, &single); err == nil {
+		return []string{single}, true
+	}
+	var list []string
+	if err := json.Unmarshal(req.Input, &list); err == nil {
+		return list, true
+	}
+	return nil, false
+}
+
+// embedder returns the configured embeddings backend, or nil when
+// /v1/embeddings is not enabled.
+func (h *Handler) embedder() embeddings.Embedder {
+	if h.embeddings != nil {
+		return h.embeddings
+	}
+	if h.config == nil {
+		return nil
+	}
+	e, err := embeddings.New(embeddings.Options{
+		Provider: h.config.EmbeddingsProvider,
+		Endpoint: h.config.EmbeddingsEndpoint,
+		APIKey:   h.config.EmbeddingsAPIKey,
+	})
+	if err != nil {
+		slog.Warn("Embeddings disabled: invalid backend config", "error", err)
+		return nil
+	}
+	return e
+}
+
+// HandleEmbeddings serves POST /v1/embeddings. Requests pass the same API key,
+// end-user, quota and TPM checks as /v1/messages, and their input tokens are
+// recorded in the same usage totals.
+func (h *Handler) HandleEmbeddings(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		apperrors.New("invalid_request_error", "Method not allowed", http.StatusMethodNotAllowed).WriteResponse(w)
+		return
+	}
+	embedder := h.embedder()
+	if embedder == nil {
+		apperrors.New("not_found_error", "embeddings are not enabled on this server", http.StatusNotFound).WriteResponse(w)
+		return
+	}
+
+	var req embeddingsRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBytes)).Decode(&req); err != nil {
+		apperrors.New("invalid_request_error", "Invalid request body", http.StatusBadRequest).WriteResponse(w)
+		return
+	}
+	inputs, ok := req.inputs()
+	if !ok {
+		apperrors.New("invalid_request_error", "input must be a string or an array of strings", http.StatusBadRequest).WriteResponse(w)
+		return
+	}
+	if len(inputs) == 0 {
+		apperrors.New("invalid_request_error", "input must not be empty", http.StatusBadRequest).WriteResponse(w)
+		return
+	}
+	if limit := h.config.EmbeddingsMaxInputs; limit > 0 && len(inputs) > limit {
+		apperrors.New("invalid_request_error", "input has more than the allowed number of entries", http.StatusBadRequest).WriteResponse(w)
+		return
+	}
+	for _, in := range inputs {
+		if strings.TrimSpace(in) == "" {
+			apperrors.New("invalid_request_error", "input must not contain empty strings", http.StatusBadRequest).WriteResponse(w)
+			return
+		}
+	}
+	switch req.EncodingFormat {
+	case "", "float", "base64":
+	default:
+		apperrors.New("invalid_request_error", "encoding_format must be float or base64", http.StatusBadRequest).WriteResponse(w)
+		return
+	}
+	model := strings.TrimSpace(req.Model)
+	if model == "" {
+		model = h.config.EmbeddingsModel
+	}
+
+	scope := apiKeyScope(r)
+	userID := strings.TrimSpace(req.User)
+	if ok, reason := h.endUsers.Allow(scope, userID, h.config.EndUserRPM, h.config.EndUserDailyTokens); !ok {
+		apperrors.New("rate_limit_error", reason, http.StatusTooManyRequests).WriteResponse(w)
+		return
+	}
+	apiKey := h.lookupApiKey(r.Context(), presentedKeyHash(r))
+	if err := h.authorizeRequestKey(apiKey, r); err != nil {
+		errType, status := keyAccessStatus(err)
+		apperrors.New(errType, err.Error(), status).WriteResponse(w)
+		return
+	}
+	if apiKey != nil && h.quotaExhausted(r.Context(), keyUsageScope(apiKey.ID), apiKey.MonthlyTokenQuota) {
+		apperrors.New("rate_limit_error", "API key monthly token quota exhausted", http.StatusTooManyRequests).WriteResponse(w)
+		return
+	}
+	tenant, err := h.keyTenant(r.Context(), apiKey)
+	if err != nil {
+		apperrors.New("permission_error", err.Error(), http.StatusForbidden).WriteResponse(w)
+		return
+	}
+	if tenant != nil && h.quotaExhausted(r.Context(), tenantUsageScope(tenant.ID), tenant.MonthlyTokenQuota) {
+		apperrors.New("rate_limit_error", "tenant monthly token quota exhausted", http.StatusTooManyRequests).WriteResponse(w)
+		return
+	}
+	tpmKey := tpmKeyScope(scope)
+	if !h.tpm.Allow(tpmKey, keyTPMLimit(apiKey, h.config)) {
+		apperrors.New("rate_limit_error", "API key tokens-per-minute limit exceeded", http.StatusTooManyRequests).WriteResponse(w)
+		return
+	}
+
+	res, err := embedder.Embed(r.Context(), embeddings.Request{Model: model, Inputs: inputs, Dimensions: req.Dimensions})
+	if err != nil {
+		slog.Warn("Embeddings backend failed", "model", model, "inputs", len(inputs), "error", err)
+		apperrors.New("api_error", err.Error(), http.StatusBadGateway).WriteResponse(w)
+		return
+	}
+
+	tokens := res.PromptTokens
+	if tokens <= 0 {
+		for _, in := range inputs {
+			tokens += tiktoken.EstimateTextTokens(in)
+		}
+	}
+	h.endUsers.Record(scope, userID, tokens, 0)
+	if apiKey != nil && h.keyUsage != nil {
+		month := keyUsageMonth(time.Now())
+		h.keyUsage.Record(r.Context(), keyUsageScope(apiKey.ID), month, tokens, 0)
+		if apiKey.TenantID != 0 {
+			h.keyUsage.Record(r.Context(), tenantUsageScope(apiKey.TenantID), month, tokens, 0)
+		}
+	}
+	h.tpm.Record(tpmKey, tokens)
+
+	if res.Model != "" {
+		model = res.Model
+	}
+	data := make([]map[string]interface{}, len(res.Vectors))
+	for i, vec := range res.Vectors {
+		var embedding interface{} = vec
+		if req.EncodingFormat == "base64" {
+			embedding = encodeEmbeddingBase64(vec)
+		}
+		data[i] = map[string]interface{}{"object": "embedding", "index": i, "embedding": embedding}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"object": "list",
+		"data":   data,
+		"model":  model,
+		"usage":  map[string]int{"prompt_tokens": tokens, "total_tokens": tokens},
+	})
+}
+
+// encodeEmbeddingBase64 packs vec as little-endian float32 values, the
+// encoding OpenAI uses for encoding_format "base64".
+func encodeEmbeddingBase64(vec []float32) string {
+	buf := make([]byte, 4*len(vec))
+	for i, v := range vec {
+		binary.LittleEndian.PutUint32(buf[4*i:], math.Float32bits(v))
+	}
+	return base64.StdEncoding.EncodeToString(buf)
+}
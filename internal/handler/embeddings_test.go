@@ -0,0 +1,140 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/goccy/go-json"
+
+	"orchids-api/internal/config"
+	"orchids-api/internal/embeddings"
+	"orchids-api/internal/loadbalancer"
+	"orchids-api/internal/store"
+)
+
+type fakeEmbedder struct {
+	calls []embeddings.Request
+	err   error
+}
+
+func (f *fakeEmbedder) Embed(ctx context.Context, req embeddings.Request) (*embeddings.Result, error) {
+	f.calls = append(f.calls, req)
+	if f.err != nil {
+		return nil, f.err
+	}
+	res := &embeddings.Result{PromptTokens: 4 * len(req.Inputs)}
+	for i := range req.Inputs {
+		res.Vectors = append(res.Vectors, []float32{float32(i), 0.5})
+	}
+	return res, nil
+}
+
+func TestHandleEmbeddings(t *testing.T) {
+	mr := miniredis.RunT(t)
+	s, err := store.New(store.Options{RedisAddr: mr.Addr(), RedisPrefix: "test:"})
+	if err != nil {
+		t.Fatalf("store.New: %v", err)
+	}
+	ctx := context.Background()
+	sum := sha256.Sum256([]byte("sk-rag"))
+	key := &store.ApiKey{Name: "rag", KeyHash: hex.EncodeToString(sum[:]), KeySuffix: "-rag", Enabled: true, MonthlyTokenQuota: 100}
+	if err := s.CreateApiKey(ctx, key); err != nil {
+		t.Fatalf("CreateApiKey: %v", err)
+	}
+	usage := NewMemoryKeyUsageStore()
+	defer usage.Stop()
+	fake := &fakeEmbedder{}
+	h := &Handler{
+		config:       &config.Config{EmbeddingsModel: "bge-small", EmbeddingsMaxInputs: 3},
+		loadBalancer: loadbalancer.NewWithCacheTTL(s, 0),
+		keyUsage:     usage,
+		tpm:          NewTPMLimiter(),
+		endUsers:     NewEndUserTracker(),
+		embeddings:   fake,
+	}
+
+	post := func(body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/v1/embeddings", strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer sk-rag")
+		rec := httptest.NewRecorder()
+		h.HandleEmbeddings(rec, r)
+		return rec
+	}
+
+	rec := post(`{"input":["alpha","beta"]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status=%d body=%s", rec.Code, rec.Body.String())
+	}
+	var resp struct {
+		Object string `json:"object"`
+		Data   []struct {
+			Index     int       `json:"index"`
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+		Model string         `json:"model"`
+		Usage map[string]int `json:"usage"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if resp.Object != "list" || len(resp.Data) != 2 || resp.Data[1].Index != 1 || resp.Data[1].Embedding[0] != 1 {
+		t.Fatalf("unexpected response: %s", rec.Body.String())
+	}
+	if resp.Model != "bge-small" || fake.calls[0].Model != "bge-small" {
+		t.Fatalf("default model not applied: %q / %q", resp.Model, fake.calls[0].Model)
+	}
+	if resp.Usage["prompt_tokens"] != 8 {
+		t.Fatalf("usage=%v", resp.Usage)
+	}
+	if u := usage.Get(ctx, keyUsageScope(key.ID), keyUsageMonth(time.Now())); u.InputTokens != 8 || u.OutputTokens != 0 || u.Requests != 1 {
+		t.Fatalf("key usage not recorded: %+v", u)
+	}
+
+	rec = post(`{"input":"gamma","encoding_format":"base64"}`)
+	var b64 struct {
+		Data []struct {
+			Embedding string `json:"embedding"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &b64); err != nil || len(b64.Data) != 1 {
+		t.Fatalf("base64 response: %s", rec.Body.String())
+	}
+	if raw, _ := base64.StdEncoding.DecodeString(b64.Data[0].Embedding); len(raw) != 8 {
+		t.Fatalf("base64 embedding has %d bytes, want 8", len(raw))
+	}
+
+	for _, body := range []string{`{"input":[]}`, `{"input":[1,2,3]}`, `{"input":["a",""]}`, `{"input":["a","b","c","d"]}`, `{"input":"a","encoding_format":"int8"}`} {
+		if rec := post(body); rec.Code != http.StatusBadRequest {
+			t.Fatalf("%s: status=%d want 400", body, rec.Code)
+		}
+	}
+
+	fake.err = errors.New("backend down")
+	if rec := post(`{"input":"a"}`); rec.Code != http.StatusBadGateway {
+		t.Fatalf("backend error: status=%d want 502", rec.Code)
+	}
+	fake.err = nil
+
+	usage.Record(ctx, keyUsageScope(key.ID), keyUsageMonth(time.Now()), 100, 0)
+	if rec := post(`{"input":"a"}`); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("exhausted quota: status=%d want 429", rec.Code)
+	}
+}
+
+func TestHandleEmbeddingsDisabled(t *testing.T) {
+	h := &Handler{config: &config.Config{}}
+	rec := httptest.NewRecorder()
+	h.HandleEmbeddings(rec, httptest.NewRequest(http.MethodPost, "/v1/embeddings", strings.NewReader(`{"input":"a"}`)))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("status=%d want 404", rec.Code)
+	}
+}
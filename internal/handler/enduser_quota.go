@@ -0,0 +1,205 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/goccy/go-json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	apperrors "orchids-api/internal/errors"
+)
+
+const endUserIdleTTL = 48 * time.Hour
+
+// EndUserUsage is the per-end-user accounting record exposed through the usage API.
+// Records are scoped by the API key the request was made with, so a key shared by a
+// multi-user application sees its own users only.
+type EndUserUsage struct {
+	KeyScope     string    `json:"key_scope"`
+	UserID       string    `json:"user_id"`
+	Requests     int64     `json:"requests"`
+	InputTokens  int64     `json:"input_tokens"`
+	OutputTokens int64     `json:"output_tokens"`
+	DailyTokens  int64     `json:"daily_tokens"`
+	Rejected     int64     `json:"rejected"`
+	LastSeen     time.Time `json:"last_seen"`
+
+	minuteStart time.Time
+	minuteCount int
+	day         string
+}
+
+// EndUserTracker records usage per (API key, end user) pair and enforces the optional
+// per-end-user request-per-minute and daily token limits.
+type EndUserTracker struct {
+	users   *ShardedMap[EndUserUsage]
+	cleaner *AsyncCleaner
+}
+
+func NewEndUserTracker() *EndUserTracker {
+	t := &EndUserTracker{
+		users: NewShardedMap[EndUserUsage](),
+	}
+	t.cleaner = NewAsyncCleaner(10 * time.Minute)
+	t.cleaner.Start(func() {
+		now := time.Now()
+		t.users.RangeDelete(func(_ string, u EndUserUsage) bool {
+			return now.Sub(u.LastSeen) > endUserIdleTTL
+		})
+	})
+	return t
+}
+
+func endUserKey(scope, userID string) string {
+	return scope + "|" + userID
+}
+
+func usageDay(t time.Time) string {
+	return t.UTC().Format("2006-01-02")
+}
+
+// Allow reports whether the end user may issue another request. A limit <= 0 disables
+// that check. The request is counted against the per-minute window when allowed.
+func (t *EndUserTracker) Allow(scope, userID string, rpm, dailyTokens int) (bool, string) {
+	if t == nil || userID == "" {
+		return true, ""
+	}
+	now := time.Now()
+	today := usageDay(now)
+	allowed, reason := true, ""
+	t.users.Compute(endUserKey(scope, userID), func(cur EndUserUsage, exists bool) (EndUserUsage, bool) {
+		if !exists {
+			cur = EndUserUsage{KeyScope: scope, UserID: userID}
+		}
+		if cur.day != today {
+			cur.day = today
+			cur.DailyTokens = 0
+		}
+		if now.Sub(cur.minuteStart) >= time.Minute {
+			cur.minuteStart = now
+			cur.minuteCount = 0
+		}
+		cur.LastSeen = now
+		switch {
+		case dailyTokens > 0 && cur.DailyTokens >= int64(dailyTokens):
+			allowed, reason = false, "end user daily token limit exceeded"
+		case rpm > 0 && cur.minuteCount >= rpm:
+			allowed, reason = false, "end user rate limit exceeded"
+		}
+		if !allowed {
+			cur.Rejected++
+			return cur, true
+		}
+		cur.minuteCount++
+		return cur, true
+	})
+	return allowed, reason
+}
+
+// Record adds a completed request's token usage to the end user's totals.
+func (t *EndUserTracker) Record(scope, userID string, inputTokens, outputTokens int) {
+	if t == nil || userID == "" {
+		return
+	}
+	now := time.Now()
+	today := usageDay(now)
+	t.users.Compute(endUserKey(scope, userID), func(cur EndUserUsage, exists bool) (EndUserUsage, bool) {
+		if !exists {
+			cur = EndUserUsage{KeyScope: scope, UserID: userID}
+		}
+		if cur.day != today {
+			cur.day = today
+			cur.DailyTokens = 0
+		}
+		cur.Requests++
+		cur.InputTokens += int64(inputTokens)
+		cur.OutputTokens += int64(outputTokens)
+		cur.DailyTokens += int64(inputTokens + outputTokens)
+		cur.LastSeen = now
+		return cur, true
+	})
+}
+
+// Snapshot returns usage records, optionally filtered to a single key scope,
+// sorted by most recent activity first.
+func (t *EndUserTracker) Snapshot(scope string) []EndUserUsage {
+	if t == nil {
+		return nil
+	}
+	today := usageDay(time.Now())
+	out := make([]EndUserUsage, 0)
+	t.users.Range(func(_ string, u EndUserUsage) bool {
+		if scope != "" && u.KeyScope != scope {
+			return true
+		}
+		if u.day != today {
+			u.DailyTokens = 0
+		}
+		out = append(out, u)
+		return true
+	})
+	sort.Slice(out, func(i, j int) bool { return out[i].LastSeen.After(out[j].LastSeen) })
+	return out
+}
+
+// endUserIDForRequest returns the caller-supplied end-user identifier: Anthropic
+// metadata.user_id, or the OpenAI top-level "user" field.
+func endUserIDForRequest(req ClaudeRequest) string {
+	if req.Metadata != nil {
+		if id := metadataString(req.Metadata, "user_id", "userId"); id != "" {
+			return id
+		}
+	}
+	return strings.TrimSpace(req.User)
+}
+
+// anonymousKeyScope is the apiKeyScope shared by all callers without an API key.
+const anonymousKeyScope = "anonymous"
+
+// apiKeyScope identifies the API key presented by the caller without retaining it.
+// The value matches the leading characters of the stored key hash.
+func apiKeyScope(r *http.Request) string {
+	hash := presentedKeyHash(r)
+	if hash == "" {
+		return anonymousKeyScope
+	}
+	return hash[:16]
+}
+
+// presentedKeyHash returns the sha256 hex digest of the API key sent via
+// X-Api-Key or a Bearer token, in the same form the key store indexes by.
+func presentedKeyHash(r *http.Request) string {
+	key := strings.TrimSpace(r.Header.Get("X-Api-Key"))
+	if key == "" {
+		key = strings.TrimSpace(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+	}
+	if key == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// HandleEndUserUsage serves GET /api/usage/users. The optional key_scope query
+// parameter restricts the listing to a single API key.
+func (h *Handler) HandleEndUserUsage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		apperrors.New("invalid_request_error", "Method not allowed", http.StatusMethodNotAllowed).WriteResponse(w)
+		return
+	}
+	scope := strings.TrimSpace(r.URL.Query().Get("key_scope"))
+	users := h.endUsers.Snapshot(scope)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"users": users,
+		"total": len(users),
+		"limits": map[string]int{
+			"rpm":          h.config.EndUserRPM,
+			"daily_tokens": h.config.EndUserDailyTokens,
+		},
+	})
+}
@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestEndUserTrackerRPMLimit(t *testing.T) {
+	tr := NewEndUserTracker()
+	for i := 0; i < 2; i++ {
+		if ok, _ := tr.Allow("k", "alice", 2, 0); !ok {
+			t.Fatalf("request %d should be allowed", i)
+		}
+	}
+	if ok, reason := tr.Allow("k", "alice", 2, 0); ok || reason == "" {
+		t.Fatalf("third request should be rejected, got ok=%v reason=%q", ok, reason)
+	}
+	if ok, _ := tr.Allow("k", "bob", 2, 0); !ok {
+		t.Fatal("other end users must not share the limit")
+	}
+	if ok, _ := tr.Allow("other", "alice", 2, 0); !ok {
+		t.Fatal("same user id under a different key must not share the limit")
+	}
+}
+
+func TestEndUserTrackerDailyTokens(t *testing.T) {
+	tr := NewEndUserTracker()
+	if ok, _ := tr.Allow("k", "alice", 0, 100); !ok {
+		t.Fatal("first request should be allowed")
+	}
+	tr.Record("k", "alice", 80, 30)
+	if ok, _ := tr.Allow("k", "alice", 0, 100); ok {
+		t.Fatal("request over daily token limit should be rejected")
+	}
+
+	users := tr.Snapshot("k")
+	if len(users) != 1 {
+		t.Fatalf("snapshot len=%d want 1", len(users))
+	}
+	u := users[0]
+	if u.Requests != 1 || u.InputTokens != 80 || u.OutputTokens != 30 || u.Rejected != 1 {
+		t.Fatalf("unexpected usage: %+v", u)
+	}
+	if got := tr.Snapshot("missing"); len(got) != 0 {
+		t.Fatalf("filtered snapshot len=%d want 0", len(got))
+	}
+}
+
+func TestEndUserIDForRequest(t *testing.T) {
+	req := ClaudeRequest{Metadata: map[string]interface{}{"user_id": " u-1 "}, User: "openai-user"}
+	if got := endUserIDForRequest(req); got != "u-1" {
+		t.Fatalf("metadata user_id=%q want u-1", got)
+	}
+	if got := endUserIDForRequest(ClaudeRequest{User: "openai-user"}); got != "openai-user" {
+		t.Fatalf("openai user=%q want openai-user", got)
+	}
+}
+
+func TestAPIKeyScope(t *testing.T) {
+	r1 := httptest.NewRequest("POST", "/v1/messages", nil)
+	r1.Header.Set("X-Api-Key", "sk-abc")
+	r2 := httptest.NewRequest("POST", "/v1/messages", nil)
+	r2.Header.Set("Authorization", "Bearer sk-abc")
+	if apiKeyScope(r1) != apiKeyScope(r2) {
+		t.Fatal("x-api-key and bearer token should map to the same scope")
+	}
+	if got := apiKeyScope(httptest.NewRequest("POST", "/v1/messages", nil)); got != "anonymous" {
+		t.Fatalf("scope without key=%q want anonymous", got)
+	}
+}
@@ -0,0 +1,136 @@
+package handler
+
+import (
+	"context"
+	"log/slog"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"orchids-api/internal/store"
+)
+
+// experimentsCacheTTL bounds how long a change made through /api/experiments
+// takes to reach the request path.
+const experimentsCacheTTL = 10 * time.Second
+
+// experimentCache holds the enabled experiments so requests do not read the
+// store each time.
+type experimentCache struct {
+	mu      sync.RWMutex
+	list    []*store.Experiment
+	expires time.Time
+}
+
+// experimentAssignment is the variant of an experiment a request runs under.
+type experimentAssignment struct {
+	experiment *store.Experiment
+	variant    int
+}
+
+func (a *experimentAssignment) variantName() string {
+	if name := a.experiment.Variants[a.variant].Name; name != "" {
+		return name
+	}
+	return string(rune('A' + a.variant))
+}
+
+// enabledExperiments returns the enabled experiments, cached for
+// experimentsCacheTTL.
+func (h *Handler) enabledExperiments(ctx context.Context) []*store.Experiment {
+	if h.loadBalancer == nil || h.loadBalancer.Store == nil {
+		return nil
+	}
+	c := &h.experiments
+	c.mu.RLock()
+	if time.Now().Before(c.expires) {
+		list := c.list
+		c.mu.RUnlock()
+		return list
+	}
+	c.mu.RUnlock()
+
+	all, err := h.loadBalancer.Store.ListExperiments(store.WithoutTenant(ctx))
+	if err != nil {
+		slog.Warn("Failed to load experiments", "error", err)
+	}
+	list := slices.DeleteFunc(all, func(e *store.Experiment) bool { return !e.Enabled })
+	c.mu.Lock()
+	c.list = list
+	c.expires = time.Now().Add(experimentsCacheTTL)
+	c.mu.Unlock()
+	return list
+}
+
+// experimentFor returns the first enabled experiment that applies to model.
+func (h *Handler) experimentFor(ctx context.Context, model string) *store.Experiment {
+	for _, e := range h.enabledExperiments(ctx) {
+		if len(e.Models) == 0 || slices.ContainsFunc(e.Models, func(m string) bool { return strings.EqualFold(m, model) }) {
+			return e
+		}
+	}
+	return nil
+}
+
+// assignExperiment picks the variant of e for a request. Conversations keep
+// the variant they were first given; requests without a conversation key are
+// assigned independently.
+func (h *Handler) assignExperiment(ctx context.Context, e *store.Experiment, conversationKey string) int {
+	if conversationKey != "" && h.sessionStore != nil {
+		if variant, ok := h.sessionStore.GetExperimentVariant(ctx, conversationKey, e.ID); ok && (variant == 0 || variant == 1) {
+			return variant
+		}
+	}
+	variant := 0
+	if rand.IntN(100) < e.Split {
+		variant = 1
+	}
+	if conversationKey != "" && h.sessionStore != nil {
+		h.sessionStore.SetExperimentVariant(ctx, conversationKey, e.ID, variant)
+	}
+	return variant
+}
+
+// applyExperiment assigns the request to a variant of the experiment matching
+// its model and applies the variant's prompt and routing.
+func (p *messagesPipeline) applyExperiment() {
+	h, r := p.h, p.r
+	e := h.experimentFor(r.Context(), p.req.Model)
+	if e == nil {
+		return
+	}
+	p.experiment = &experimentAssignment{experiment: e, variant: h.assignExperiment(r.Context(), e, p.conversationKey)}
+	p.onClose(p.recordExperiment)
+	v := e.Variants[p.experiment.variant]
+	if v.SystemPrompt != "" {
+		p.req.System = prependSystemPrompt(p.req.System, v.SystemPrompt)
+	}
+	if v.Model != "" {
+		p.req.Model = v.Model
+	}
+	if v.Channel != "" && p.forcedChannel == "" {
+		p.forcedChannel = v.Channel
+	}
+	p.w.Header().Set("X-Experiment", e.Name+"="+p.experiment.variantName())
+	slog.Debug("Assigned experiment variant", "experiment", e.ID, "variant", p.experiment.variantName(), "session", p.conversationKey)
+}
+
+// recordExperiment adds the finished request to its variant's results.
+// Requests rejected before they reached upstream count as errors.
+func (p *messagesPipeline) recordExperiment() {
+	if p.experiment == nil || p.h.loadBalancer == nil || p.h.loadBalancer.Store == nil {
+		return
+	}
+	res := store.ExperimentResult{Latency: time.Since(p.startTime), Error: true}
+	if sh := p.sh; sh != nil {
+		res.OutputTokens = sh.outputTokens
+		res.ToolCalls = sh.hasClientToolCalls()
+		res.Error = (sh.finalStopReason == "" && !sh.hasReturn) || sh.clientAbort != ""
+	}
+	ctx := store.WithoutTenant(context.WithoutCancel(p.r.Context()))
+	if err := p.h.loadBalancer.Store.RecordExperimentResult(ctx, p.experiment.experiment.ID, p.experiment.variant, res); err != nil {
+		slog.Warn("Failed to record experiment result", "experiment", p.experiment.experiment.ID, "error", err)
+	}
+}
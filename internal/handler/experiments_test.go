@@ -0,0 +1,34 @@
+package handler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"orchids-api/internal/store"
+)
+
+func TestAssignExperimentPersistsPerConversation(t *testing.T) {
+	h := &Handler{sessionStore: NewMemorySessionStore(time.Minute, 100)}
+	ctx := context.Background()
+	e := &store.Experiment{ID: 3, Split: 50}
+
+	first := h.assignExperiment(ctx, e, "conv-1")
+	for range 20 {
+		if got := h.assignExperiment(ctx, e, "conv-1"); got != first {
+			t.Fatalf("conversation switched from variant %d to %d", first, got)
+		}
+	}
+
+	e.Split = 0
+	if got := h.assignExperiment(ctx, e, ""); got != 0 {
+		t.Fatalf("split 0 assigned variant %d", got)
+	}
+	e.Split = 100
+	if got := h.assignExperiment(ctx, e, "conv-2"); got != 1 {
+		t.Fatalf("split 100 assigned variant %d", got)
+	}
+	if got, ok := h.sessionStore.GetExperimentVariant(ctx, "conv-2", e.ID); !ok || got != 1 {
+		t.Fatalf("stored variant=%d ok=%v", got, ok)
+	}
+}
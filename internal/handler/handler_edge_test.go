@@ -8,6 +8,7 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"orchids-api/internal/config"
 	"orchids-api/internal/debug"
@@ -134,3 +135,394 @@ func TestHandleMessages_Dedup_Stream(t *testing.T) {
 		t.Fatalf("expected minimal sse start/stop for duplicate, got: %s", out)
 	}
 }
+
+type blockingUpstream struct {
+	mockUpstreamEdge
+}
+
+func (m *blockingUpstream) SendRequestWithPayload(ctx context.Context, req upstream.UpstreamRequest, onMessage func(upstream.SSEMessage), logger *debug.Logger) error {
+	for _, e := range m.events {
+		onMessage(e)
+	}
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestHandleMessages_MaxStreamDuration_StopsWithReason(t *testing.T) {
+	cfg := &config.Config{DebugEnabled: false, RequestTimeout: 10, ContextMaxTokens: 1024, ContextSummaryMaxTokens: 256, ContextKeepTurns: 2, MaxStreamDuration: 1}
+	h := NewWithLoadBalancer(cfg, nil)
+	h.client = &blockingUpstream{mockUpstreamEdge{events: []upstream.SSEMessage{
+		{Type: "model", Event: map[string]any{"type": "text-start"}},
+		{Type: "model", Event: map[string]any{"type": "text-delta", "delta": "partial"}},
+	}}}
+
+	payload := map[string]any{
+		"model":    "claude-3-5-sonnet",
+		"messages": []map[string]any{{"role": "user", "content": "hi"}},
+		"system":   []any{},
+		"stream":   true,
+	}
+	b, _ := json.Marshal(payload)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "http://x/orchids/v1/messages", bytes.NewReader(b))
+	h.HandleMessages(rec, req)
+	out := rec.Body.String()
+	if !strings.Contains(out, "partial") || !strings.Contains(out, `"stop_reason":"max_tokens"`) {
+		t.Fatalf("expected partial output ending with max_tokens, got: %s", out)
+	}
+	if !strings.Contains(out, "event: message_stop") {
+		t.Fatalf("expected message_stop, got: %s", out)
+	}
+}
+
+func TestHandleMessages_ModelConcurrencyLimit(t *testing.T) {
+	cfg := &config.Config{DebugEnabled: false, RequestTimeout: 10, ContextMaxTokens: 1024, ContextSummaryMaxTokens: 256, ContextKeepTurns: 2,
+		ModelMaxConcurrency: map[string]int{"Claude-3-5-Sonnet": 1}}
+	h := NewWithLoadBalancer(cfg, nil)
+	h.client = &mockUpstreamEdge{events: []upstream.SSEMessage{
+		{Type: "model", Event: map[string]any{"type": "text-delta", "delta": "ok"}},
+		{Type: "model", Event: map[string]any{"type": "finish", "finishReason": "stop"}},
+	}}
+	payload := map[string]any{
+		"model":    "claude-3-5-sonnet",
+		"messages": []map[string]any{{"role": "user", "content": "hi"}},
+		"stream":   false,
+	}
+	send := func() *httptest.ResponseRecorder {
+		// Vary the body so the duplicate-request window doesn't answer the retry.
+		payload["max_tokens"] = time.Now().UnixNano()
+		b, _ := json.Marshal(payload)
+		rec := httptest.NewRecorder()
+		h.HandleMessages(rec, httptest.NewRequest(http.MethodPost, "http://x/orchids/v1/messages", bytes.NewReader(b)))
+		return rec
+	}
+
+	// Hold the only slot, as an in-flight request would.
+	if !h.modelSlots.tryAcquire("claude-3-5-sonnet", 1) {
+		t.Fatal("expected a free model slot")
+	}
+	if rec := send(); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429 while the model is saturated, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	h.modelSlots.release("claude-3-5-sonnet")
+	if rec := send(); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "ok") {
+		t.Fatalf("expected success after release, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if n := h.modelSlots.active["claude-3-5-sonnet"]; n != 0 {
+		t.Fatalf("model slot leaked: %d", n)
+	}
+}
+
+func TestHandleMessages_KeyTPMLimit(t *testing.T) {
+	cfg := &config.Config{DebugEnabled: false, RequestTimeout: 10, ContextMaxTokens: 1024, ContextSummaryMaxTokens: 256, ContextKeepTurns: 2,
+		KeyTPMLimit: 100}
+	h := NewWithLoadBalancer(cfg, nil)
+	h.client = &mockUpstreamEdge{events: []upstream.SSEMessage{
+		{Type: "model", Event: map[string]any{"type": "text-delta", "delta": "ok"}},
+		{Type: "model", Event: map[string]any{"type": "finish", "finishReason": "stop"}},
+	}}
+	payload := map[string]any{
+		"model":    "claude-3-5-sonnet",
+		"messages": []map[string]any{{"role": "user", "content": "hi"}},
+		"stream":   false,
+	}
+	send := func(apiKey string) *httptest.ResponseRecorder {
+		payload["max_tokens"] = time.Now().UnixNano()
+		b, _ := json.Marshal(payload)
+		req := httptest.NewRequest(http.MethodPost, "http://x/orchids/v1/messages", bytes.NewReader(b))
+		req.Header.Set("X-Api-Key", apiKey)
+		rec := httptest.NewRecorder()
+		h.HandleMessages(rec, req)
+		return rec
+	}
+
+	if rec := send("sk-bulk"); rec.Code != http.StatusOK {
+		t.Fatalf("expected first request to pass, got %d: %s", rec.Code, rec.Body.String())
+	}
+	bulkKey := tpmKeyScope(apiKeyScope(func() *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/", nil)
+		r.Header.Set("X-Api-Key", "sk-bulk")
+		return r
+	}()))
+	if used := h.tpm.Used(bulkKey); used < 100 {
+		t.Fatalf("expected the request's tokens to be recorded, got %d", used)
+	}
+	rec := send("sk-bulk")
+	if rec.Code != http.StatusTooManyRequests || !strings.Contains(rec.Body.String(), "tokens-per-minute") {
+		t.Fatalf("expected 429 over the key TPM limit, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := send("sk-other"); rec.Code != http.StatusOK {
+		t.Fatalf("other keys must not be throttled, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestHandleMessages_CancelFromInspector(t *testing.T) {
+	cfg := &config.Config{DebugEnabled: false, RequestTimeout: 10, ContextMaxTokens: 1024, ContextSummaryMaxTokens: 256, ContextKeepTurns: 2}
+	h := NewWithLoadBalancer(cfg, nil)
+	h.client = &blockingUpstream{mockUpstreamEdge{events: []upstream.SSEMessage{
+		{Type: "model", Event: map[string]any{"type": "text-start"}},
+		{Type: "model", Event: map[string]any{"type": "text-delta", "delta": "partial"}},
+	}}}
+	b, _ := json.Marshal(map[string]any{
+		"model":    "claude-3-5-sonnet",
+		"messages": []map[string]any{{"role": "user", "content": "hi"}},
+		"stream":   true,
+	})
+
+	rec := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h.HandleMessages(rec, httptest.NewRequest(http.MethodPost, "http://x/orchids/v1/messages", bytes.NewReader(b)))
+	}()
+
+	var active ActiveRequest
+	for deadline := time.Now().Add(2 * time.Second); ; {
+		if reqs := h.active.List(); len(reqs) == 1 && reqs[0].Stage == "streaming" && reqs[0].OutputTokens > 0 {
+			active = reqs[0]
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("request never showed up as streaming: %+v", h.active.List())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if active.Model != "claude-3-5-sonnet" || !active.Stream || active.InputTokens == 0 {
+		t.Fatalf("unexpected inspector entry: %+v", active)
+	}
+
+	cancelRec := httptest.NewRecorder()
+	h.HandleActiveRequestByID(cancelRec, httptest.NewRequest(http.MethodDelete, "/api/requests/active/"+active.ID, nil))
+	if cancelRec.Code != http.StatusNoContent {
+		t.Fatalf("cancel status = %d, want 204", cancelRec.Code)
+	}
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("request did not finish after cancel")
+	}
+	if out := rec.Body.String(); !strings.Contains(out, "partial") || !strings.Contains(out, "event: message_stop") {
+		t.Fatalf("expected partial output and message_stop, got: %s", out)
+	}
+	if reqs := h.active.List(); len(reqs) != 0 {
+		t.Fatalf("request still listed after finishing: %+v", reqs)
+	}
+
+	cancelRec = httptest.NewRecorder()
+	h.HandleActiveRequestByID(cancelRec, httptest.NewRequest(http.MethodDelete, "/api/requests/active/"+active.ID, nil))
+	if cancelRec.Code != http.StatusNotFound {
+		t.Fatalf("cancel of finished request = %d, want 404", cancelRec.Code)
+	}
+}
+
+type failingUpstream struct {
+	mockUpstreamEdge
+	err error
+}
+
+func (m *failingUpstream) SendRequestWithPayload(ctx context.Context, req upstream.UpstreamRequest, onMessage func(upstream.SSEMessage), logger *debug.Logger) error {
+	m.mockUpstreamEdge.SendRequestWithPayload(ctx, req, onMessage, logger)
+	return m.err
+}
+
+func TestHandleMessages_PartialNonStreamResponse(t *testing.T) {
+	for _, mode := range []string{"", "mark"} {
+		t.Run("mode="+mode, func(t *testing.T) {
+			cfg := &config.Config{DebugEnabled: false, RequestTimeout: 10, ContextMaxTokens: 1024, ContextSummaryMaxTokens: 256, ContextKeepTurns: 2,
+				PartialResponseMode: mode}
+			h := NewWithLoadBalancer(cfg, nil)
+			h.client = &failingUpstream{mockUpstreamEdge{events: []upstream.SSEMessage{
+				{Type: "model", Event: map[string]any{"type": "text-start"}},
+				{Type: "model", Event: map[string]any{"type": "text-delta", "delta": "half an answer"}},
+			}}, context.DeadlineExceeded}
+			b, _ := json.Marshal(map[string]any{
+				"model":    "claude-3-5-sonnet",
+				"messages": []map[string]any{{"role": "user", "content": "hi"}},
+				"stream":   false,
+			})
+
+			rec := httptest.NewRecorder()
+			h.HandleMessages(rec, httptest.NewRequest(http.MethodPost, "http://x/orchids/v1/messages", bytes.NewReader(b)))
+			var resp struct {
+				StopReason string         `json:"stop_reason"`
+				Error      map[string]any `json:"error"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("decode: %v (%s)", err, rec.Body.String())
+			}
+			if !strings.Contains(rec.Body.String(), "half an answer") {
+				t.Fatalf("partial text missing: %s", rec.Body.String())
+			}
+			marked := rec.Header().Get(partialResponseHeader) == "true"
+			if mode == "mark" {
+				if !marked || resp.StopReason != partialStopReason || resp.Error == nil {
+					t.Fatalf("expected a marked partial response, header=%v body=%s", marked, rec.Body.String())
+				}
+				return
+			}
+			if marked || resp.StopReason != "end_turn" || resp.Error != nil {
+				t.Fatalf("default mode must keep the legacy response, header=%v body=%s", marked, rec.Body.String())
+			}
+		})
+	}
+}
+
+// roundsUpstream replays one event list per call and records the requests.
+type roundsUpstream struct {
+	rounds [][]upstream.SSEMessage
+	reqs   []upstream.UpstreamRequest
+}
+
+func (m *roundsUpstream) SendRequest(ctx context.Context, prompt string, chatHistory []interface{}, model string, onMessage func(upstream.SSEMessage), logger *debug.Logger) error {
+	return nil
+}
+
+func (m *roundsUpstream) SendRequestWithPayload(ctx context.Context, req upstream.UpstreamRequest, onMessage func(upstream.SSEMessage), logger *debug.Logger) error {
+	i := len(m.reqs)
+	m.reqs = append(m.reqs, req)
+	if i < len(m.rounds) {
+		for _, e := range m.rounds[i] {
+			onMessage(e)
+		}
+	}
+	return nil
+}
+
+func TestHandleMessages_ContinuesTruncatedOutput(t *testing.T) {
+	truncated := []upstream.SSEMessage{
+		{Type: "model", Event: map[string]any{"type": "text-start"}},
+		{Type: "model", Event: map[string]any{"type": "text-delta", "delta": "first half, "}},
+		{Type: "model", Event: map[string]any{"type": "text-end"}},
+		{Type: "model", Event: map[string]any{"type": "finish", "finishReason": "length"}},
+	}
+	cases := []struct {
+		name       string
+		max        int
+		rounds     [][]upstream.SSEMessage
+		wantText   string
+		wantStop   string
+		wantRounds int
+	}{
+		{"disabled", 0, [][]upstream.SSEMessage{truncated}, "first half, ", "max_tokens", 1},
+		{"continued", 2, [][]upstream.SSEMessage{truncated, {
+			{Type: "model", Event: map[string]any{"type": "text-start"}},
+			{Type: "model", Event: map[string]any{"type": "text-delta", "delta": "second half"}},
+			{Type: "model", Event: map[string]any{"type": "text-end"}},
+			{Type: "model", Event: map[string]any{"type": "finish", "finishReason": "stop"}},
+		}}, "first half, second half", "end_turn", 2},
+		{"exhausted", 1, [][]upstream.SSEMessage{truncated, truncated}, "first half, first half, ", "max_tokens", 2},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := &config.Config{DebugEnabled: false, RequestTimeout: 10, ContextMaxTokens: 1024, ContextSummaryMaxTokens: 256, ContextKeepTurns: 2,
+				MaxContinuations: tc.max}
+			h := NewWithLoadBalancer(cfg, nil)
+			up := &roundsUpstream{rounds: tc.rounds}
+			h.client = up
+			b, _ := json.Marshal(map[string]any{
+				"model":    "claude-3-5-sonnet",
+				"messages": []map[string]any{{"role": "user", "content": "write a long story"}},
+				"stream":   false,
+			})
+
+			rec := httptest.NewRecorder()
+			h.HandleMessages(rec, httptest.NewRequest(http.MethodPost, "http://x/orchids/v1/messages", bytes.NewReader(b)))
+			var resp struct {
+				StopReason string `json:"stop_reason"`
+				Content    []struct {
+					Type string `json:"type"`
+					Text string `json:"text"`
+				} `json:"content"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("decode: %v (%s)", err, rec.Body.String())
+			}
+			if len(up.reqs) != tc.wantRounds {
+				t.Fatalf("upstream rounds=%d want=%d", len(up.reqs), tc.wantRounds)
+			}
+			if len(resp.Content) != 1 || resp.Content[0].Text != tc.wantText {
+				t.Fatalf("content=%+v want a single text block %q", resp.Content, tc.wantText)
+			}
+			if resp.StopReason != tc.wantStop {
+				t.Fatalf("stop_reason=%q want=%q", resp.StopReason, tc.wantStop)
+			}
+			if tc.wantRounds > 1 {
+				next := up.reqs[1].Messages
+				if len(next) < 2 || next[len(next)-2].Role != "assistant" || next[len(next)-2].Content.Text != "first half, " ||
+					next[len(next)-1].Content.Text != continuationPrompt {
+					t.Fatalf("continuation request messages=%+v", next)
+				}
+			}
+		})
+	}
+}
+
+func TestHandleMessages_ReasoningTokens(t *testing.T) {
+	cfg := &config.Config{DebugEnabled: false, RequestTimeout: 10, ContextMaxTokens: 1024, ContextSummaryMaxTokens: 256, ContextKeepTurns: 2,
+		UsageReasoningTokens: true, QuotaExcludeReasoningTokens: true}
+	h := NewWithLoadBalancer(cfg, nil)
+	h.client = &mockUpstreamEdge{events: []upstream.SSEMessage{
+		{Type: "model", Event: map[string]any{"type": "reasoning-delta", "delta": strings.Repeat("weigh the options ", 30)}},
+		{Type: "model", Event: map[string]any{"type": "text-delta", "delta": "ok"}},
+		{Type: "model", Event: map[string]any{"type": "finish", "finishReason": "stop"}},
+	}}
+	b, _ := json.Marshal(map[string]any{
+		"model":    "claude-3-5-sonnet",
+		"messages": []map[string]any{{"role": "user", "content": "hi"}},
+		"stream":   false,
+	})
+	req := httptest.NewRequest(http.MethodPost, "http://x/orchids/v1/messages", bytes.NewReader(b))
+	req.Header.Set("X-Api-Key", "sk-thinker")
+	rec := httptest.NewRecorder()
+	h.HandleMessages(rec, req)
+
+	var resp struct {
+		Usage struct {
+			InputTokens     int `json:"input_tokens"`
+			OutputTokens    int `json:"output_tokens"`
+			ReasoningTokens int `json:"reasoning_output_tokens"`
+		} `json:"usage"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode: %v: %s", err, rec.Body.String())
+	}
+	u := resp.Usage
+	if u.ReasoningTokens == 0 || u.ReasoningTokens >= u.OutputTokens {
+		t.Fatalf("usage = %+v, want reasoning within output tokens", u)
+	}
+	if used := h.tpm.Used(tpmKeyScope(apiKeyScope(req))); used != int64(u.InputTokens+u.OutputTokens-u.ReasoningTokens) {
+		t.Fatalf("key TPM = %d, want %d without reasoning", used, u.InputTokens+u.OutputTokens-u.ReasoningTokens)
+	}
+}
+
+func TestHandleMessages_PassesStopSequence(t *testing.T) {
+	cfg := &config.Config{DebugEnabled: false, RequestTimeout: 10, ContextMaxTokens: 1024, ContextSummaryMaxTokens: 256, ContextKeepTurns: 2}
+	h := NewWithLoadBalancer(cfg, nil)
+	h.client = &roundsUpstream{rounds: [][]upstream.SSEMessage{{
+		{Type: "model", Event: map[string]any{"type": "text-start"}},
+		{Type: "model", Event: map[string]any{"type": "text-delta", "delta": "1, 2, 3"}},
+		{Type: "model", Event: map[string]any{"type": "text-end"}},
+		{Type: "model", Event: map[string]any{"type": "finish", "finishReason": "stop_sequence", "stopSequence": "4"}},
+	}}}
+	b, _ := json.Marshal(map[string]any{
+		"model":          "claude-3-5-sonnet",
+		"messages":       []map[string]any{{"role": "user", "content": "count"}},
+		"stop_sequences": []string{"4"},
+		"stream":         false,
+	})
+
+	rec := httptest.NewRecorder()
+	h.HandleMessages(rec, httptest.NewRequest(http.MethodPost, "http://x/orchids/v1/messages", bytes.NewReader(b)))
+	var resp struct {
+		StopReason   string  `json:"stop_reason"`
+		StopSequence *string `json:"stop_sequence"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode: %v (%s)", err, rec.Body.String())
+	}
+	if resp.StopReason != "stop_sequence" || resp.StopSequence == nil || *resp.StopSequence != "4" {
+		t.Fatalf("stop_reason=%q stop_sequence=%v", resp.StopReason, resp.StopSequence)
+	}
+}
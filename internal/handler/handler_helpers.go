@@ -11,7 +11,11 @@ import (
 	"strings"
 	"time"
 
+	"orchids-api/internal/anthropic"
 	apperrors "orchids-api/internal/errors"
+	"orchids-api/internal/kiro"
+	"orchids-api/internal/loadbalancer"
+	"orchids-api/internal/openaicompat"
 	"orchids-api/internal/orchids"
 	"orchids-api/internal/store"
 	"orchids-api/internal/warp"
@@ -112,7 +116,10 @@ func (h *Handler) resolveWorkdir(r *http.Request, req ClaudeRequest, conversatio
 }
 
 // selectAccount logic extracted from HandleMessages
-func (h *Handler) selectAccount(ctx context.Context, model, forcedChannel string, failedAccountIDs []int64) (UpstreamClient, *store.Account, error) {
+func (h *Handler) selectAccount(ctx context.Context, model, forcedChannel string, failedAccountIDs []int64, pin *accountPin) (UpstreamClient, *store.Account, error) {
+	if pin != nil && pin.AccountID != 0 {
+		return h.selectPinnedAccount(ctx, pin.AccountID, forcedChannel, failedAccountIDs)
+	}
 	if h.loadBalancer != nil {
 		targetChannel := forcedChannel
 		if targetChannel == "" {
@@ -121,9 +128,10 @@ func (h *Handler) selectAccount(ctx context.Context, model, forcedChannel string
 		if targetChannel != "" {
 			slog.Info("Model recognition", "model", model, "channel", targetChannel)
 		}
-		account, err := h.loadBalancer.GetNextAccountExcludingByChannel(ctx, failedAccountIDs, targetChannel)
+		account, err := h.loadBalancer.GetNextAccountForModel(ctx, failedAccountIDs, targetChannel, model)
 		if err != nil {
-			if forcedChannel != "" {
+			// Busy accounts are worth waiting for rather than falling back.
+			if forcedChannel != "" || errors.Is(err, loadbalancer.ErrAccountsBusy) || errors.Is(err, loadbalancer.ErrChannelDisabled) {
 				return nil, nil, err
 			}
 			if h.client != nil {
@@ -132,21 +140,51 @@ func (h *Handler) selectAccount(ctx context.Context, model, forcedChannel string
 			}
 			return nil, nil, err
 		}
-		var client UpstreamClient
-		if h.clientFactory != nil {
-			client = h.clientFactory(account, h.config)
-		} else if strings.EqualFold(account.AccountType, "warp") {
-			client = warp.NewFromAccount(account, h.config)
-		} else {
-			client = orchids.NewFromAccount(account, h.config)
-		}
-		return client, account, nil
+		return h.clientForAccount(ctx, account), account, nil
 	} else if h.client != nil {
 		return h.client, nil, nil
 	}
 	return nil, nil, errors.New("no client configured")
 }
 
+func (h *Handler) clientForAccount(ctx context.Context, account *store.Account) UpstreamClient {
+	account = h.withChannelBaseURL(ctx, account)
+	if h.clientFactory != nil {
+		return h.clientFactory(account, h.config)
+	}
+	switch h.channelClientType(ctx, account.AccountType) {
+	case "warp":
+		return warp.NewFromAccount(account, h.config)
+	case "kiro":
+		return kiro.NewFromAccount(account, h.config)
+	case "openai-compatible":
+		return openaicompat.NewFromAccount(account, h.config)
+	case "anthropic":
+		return anthropic.NewFromAccount(account, h.config)
+	}
+	return orchids.NewFromAccount(account, h.config)
+}
+
+// passthroughModelChannel reports whether the channel receives the client's
+// model ID unchanged instead of the Orchids model mapping.
+func passthroughModelChannel(accountType string) bool {
+	switch strings.ToLower(strings.TrimSpace(accountType)) {
+	case "warp", "kiro", "openai-compatible", "anthropic":
+		return true
+	}
+	return false
+}
+
+// textOnlyChannel reports whether the client type cannot take image input,
+// so base64 images in the history are replaced with placeholders.
+func textOnlyChannel(clientType string) bool {
+	switch strings.ToLower(strings.TrimSpace(clientType)) {
+	case "orchids", "warp":
+		return true
+	}
+	return false
+}
+
 func (h *Handler) validateModelAvailability(ctx context.Context, modelID, forcedChannel string) error {
 	if h == nil || h.loadBalancer == nil || h.loadBalancer.Store == nil {
 		return nil
@@ -207,6 +245,8 @@ func (h *Handler) syncWarpState(account *store.Account, client UpstreamClient, s
 		if warpClient, ok := client.(*warp.Client); ok {
 			changed = warpClient.SyncAccountState()
 		}
+	} else if kiroClient, ok := client.(*kiro.Client); ok {
+		changed = kiroClient.SyncAccountState()
 	} else if _, ok := client.(*orchids.Client); ok {
 		// Orchids 账号：通过快照比较检测 forceRefreshToken 是否更新了账号信息
 		changed = account.SyncState(snapshot)
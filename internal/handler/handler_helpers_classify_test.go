@@ -16,3 +16,18 @@ func TestClassifyUpstreamErrorCreditsExhausted(t *testing.T) {
 		t.Fatal("expected credits exhausted to trigger account switch")
 	}
 }
+
+func TestClassifyUpstreamErrorResponseTooLarge(t *testing.T) {
+	t.Parallel()
+
+	errClass := classifyUpstreamError("upstream response too large: received 1048577 bytes, limit is 1048576")
+	if errClass.Category != "oversize" {
+		t.Fatalf("expected oversize category, got %q", errClass.Category)
+	}
+	if errClass.Retryable || errClass.SwitchAccount {
+		t.Fatal("expected an oversized response not to be retried")
+	}
+	if status := classifyAccountStatus("upstream response too large: received 1048577 bytes, limit is 1048576"); status != "" {
+		t.Fatalf("expected no account status, got %q", status)
+	}
+}
@@ -6,6 +6,8 @@ import (
 	"net/http"
 	"testing"
 	"time"
+
+	"orchids-api/internal/store"
 )
 
 func TestComputeRequestHash_ChangesWithAuthPathBody(t *testing.T) {
@@ -35,6 +37,20 @@ func TestComputeRequestHash_ChangesWithAuthPathBody(t *testing.T) {
 	if h1 == h.computeRequestHash(mkReq("/v1/messages", "Bearer x"), bodyB) {
 		t.Fatalf("expected body to affect hash")
 	}
+
+	withKey := mkReq("/v1/messages", "")
+	withKey.Header.Set("X-Api-Key", "y")
+	if h1 == h.computeRequestHash(withKey, bodyA) {
+		t.Fatalf("expected X-Api-Key to affect hash")
+	}
+	withKey.Header.Set("X-Api-Key", "x")
+	if h1 != h.computeRequestHash(withKey, bodyA) {
+		t.Fatalf("expected the same key to hash alike however it is sent")
+	}
+	tenant := mkReq("/v1/messages", "Bearer x")
+	if h1 == h.computeRequestHash(tenant.WithContext(store.WithTenant(tenant.Context(), 2)), bodyA) {
+		t.Fatalf("expected tenant to affect hash")
+	}
 }
 
 func TestRegisterRequest_DedupWindowAndInFlight(t *testing.T) {
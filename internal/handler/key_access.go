@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+
+	"orchids-api/internal/middleware"
+	"orchids-api/internal/store"
+)
+
+var (
+	errApiKeyExpired  = errors.New("API key has expired")
+	errApiKeyInvalid  = errors.New("invalid or disabled API key")
+	errApiKeyIPDenied = errors.New("API key is not allowed from this IP address")
+)
+
+// authorizeRequestKey checks the key resolved for r. Only callers that send no
+// key run anonymously: a presented key that is unknown or disabled is
+// rejected like an expired one, so revoking a key is at least as strong as
+// letting it expire.
+func (h *Handler) authorizeRequestKey(key *store.ApiKey, r *http.Request) error {
+	if key == nil && presentedKeyHash(r) != "" && h.loadBalancer != nil && h.loadBalancer.Store != nil {
+		return errApiKeyInvalid
+	}
+	return authorizeKeyAccess(key, r, h.trustedProxies(), time.Now())
+}
+
+// keyAccessStatus maps an authorizeRequestKey error to its error type and
+// HTTP status.
+func keyAccessStatus(err error) (string, int) {
+	if errors.Is(err, errApiKeyExpired) || errors.Is(err, errApiKeyInvalid) {
+		return "authentication_error", http.StatusUnauthorized
+	}
+	return "permission_error", http.StatusForbidden
+}
+
+// authorizeKeyAccess checks the key's expiry and allowed_ips against the
+// client address as resolved by keyClientIP.
+func authorizeKeyAccess(key *store.ApiKey, r *http.Request, trustedProxies []string, now time.Time) error {
+	if key == nil {
+		return nil
+	}
+	if key.ExpiresAt != nil && !now.Before(*key.ExpiresAt) {
+		return errApiKeyExpired
+	}
+	if len(key.AllowedIPs) == 0 {
+		return nil
+	}
+	if !ipAllowed(key.AllowedIPs, keyClientIP(r, trustedProxies)) {
+		return errApiKeyIPDenied
+	}
+	return nil
+}
+
+// trustedProxies returns the configured trusted_proxies.
+func (h *Handler) trustedProxies() []string {
+	if h.config == nil {
+		return nil
+	}
+	return h.config.TrustedProxies
+}
+
+// keyClientIP returns the connection address unless it is a trusted proxy.
+// Then X-Forwarded-For is walked from the right, since each proxy appends
+// the address it saw, and the first entry that is not itself a trusted proxy
+// is the client; anything further left is supplied by the client. A trusted
+// proxy that sends X-Real-IP instead is believed as well.
+func keyClientIP(r *http.Request, trustedProxies []string) string {
+	ip := middleware.ExtractIP(r.RemoteAddr, "", "")
+	if !ipAllowed(trustedProxies, ip) {
+		return ip
+	}
+	forwarded := strings.TrimSpace(r.Header.Get("X-Forwarded-For"))
+	if forwarded == "" {
+		if real := strings.TrimSpace(r.Header.Get("X-Real-IP")); real != "" {
+			return real
+		}
+		return ip
+	}
+	hops := strings.Split(forwarded, ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		ip = hop
+		if !ipAllowed(trustedProxies, hop) {
+			break
+		}
+	}
+	return ip
+}
+
+// ipAllowed reports whether ip matches one of the addresses or CIDR
+// prefixes in allowed.
+func ipAllowed(allowed []string, ip string) bool {
+	addr, err := netip.ParseAddr(strings.TrimSpace(ip))
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, entry := range allowed {
+		if strings.Contains(entry, "/") {
+			if prefix, err := netip.ParsePrefix(entry); err == nil && prefix.Contains(addr) {
+				return true
+			}
+			continue
+		}
+		if allowedAddr, err := netip.ParseAddr(entry); err == nil && allowedAddr.Unmap() == addr {
+			return true
+		}
+	}
+	return false
+}
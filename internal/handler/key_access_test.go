@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"orchids-api/internal/config"
+	"orchids-api/internal/loadbalancer"
+	"orchids-api/internal/store"
+	"orchids-api/internal/upstream"
+)
+
+func TestAuthorizeKeyAccess(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	past := now.Add(-time.Minute)
+	future := now.Add(time.Hour)
+	request := func(remote, forwarded string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+		r.RemoteAddr = remote
+		if forwarded != "" {
+			r.Header.Set("X-Forwarded-For", forwarded)
+		}
+		return r
+	}
+
+	cases := []struct {
+		name string
+		key  *store.ApiKey
+		r    *http.Request
+		want error
+	}{
+		{"no key", nil, request("1.2.3.4:1000", ""), nil},
+		{"no restrictions", &store.ApiKey{}, request("1.2.3.4:1000", ""), nil},
+		{"expired", &store.ApiKey{ExpiresAt: &past}, request("1.2.3.4:1000", ""), errApiKeyExpired},
+		{"not yet expired", &store.ApiKey{ExpiresAt: &future}, request("1.2.3.4:1000", ""), nil},
+		{"exact ip", &store.ApiKey{AllowedIPs: []string{"1.2.3.4"}}, request("1.2.3.4:1000", ""), nil},
+		{"cidr", &store.ApiKey{AllowedIPs: []string{"10.0.0.0/8"}}, request("10.20.30.40:1000", ""), nil},
+		{"ipv6 cidr", &store.ApiKey{AllowedIPs: []string{"2001:db8::/32"}}, request("[2001:db8::1]:1000", ""), nil},
+		{"forwarded", &store.ApiKey{AllowedIPs: []string{"1.2.3.4"}}, request("127.0.0.1:1000", "1.2.3.4"), nil},
+		{"forwarded through proxies", &store.ApiKey{AllowedIPs: []string{"1.2.3.4"}}, request("127.0.0.1:1000", "1.2.3.4, 10.0.0.1"), nil},
+		{"spoofed leftmost", &store.ApiKey{AllowedIPs: []string{"1.2.3.4"}}, request("127.0.0.1:1000", "1.2.3.4, 5.6.7.8"), errApiKeyIPDenied},
+		{"untrusted peer", &store.ApiKey{AllowedIPs: []string{"1.2.3.4"}}, request("5.6.7.8:1000", "1.2.3.4"), errApiKeyIPDenied},
+		{"denied", &store.ApiKey{AllowedIPs: []string{"10.0.0.0/8"}}, request("1.2.3.4:1000", ""), errApiKeyIPDenied},
+	}
+	trusted := []string{"127.0.0.1", "10.0.0.0/8"}
+	for _, tc := range cases {
+		if err := authorizeKeyAccess(tc.key, tc.r, trusted, now); !errors.Is(err, tc.want) {
+			t.Errorf("%s: err=%v want %v", tc.name, err, tc.want)
+		}
+	}
+}
+
+func TestHandleMessagesRejectsUnknownAndDisabledKeys(t *testing.T) {
+	mr := miniredis.RunT(t)
+	s, err := store.New(store.Options{RedisAddr: mr.Addr(), RedisPrefix: "test:"})
+	if err != nil {
+		t.Fatalf("store.New: %v", err)
+	}
+	sum := sha256.Sum256([]byte("sk-revoked"))
+	if err := s.CreateApiKey(context.Background(), &store.ApiKey{Name: "revoked", KeyHash: hex.EncodeToString(sum[:]), Enabled: false}); err != nil {
+		t.Fatalf("CreateApiKey: %v", err)
+	}
+	cfg := &config.Config{RequestTimeout: 10, ContextMaxTokens: 1024, ContextSummaryMaxTokens: 256, ContextKeepTurns: 2}
+	h := NewWithLoadBalancer(cfg, loadbalancer.NewWithCacheTTL(s, 0))
+	h.client = &roundsUpstream{rounds: [][]upstream.SSEMessage{{
+		{Type: "model", Event: map[string]any{"type": "text-delta", "delta": "ok"}},
+		{Type: "model", Event: map[string]any{"type": "finish", "finishReason": "stop"}},
+	}}}
+
+	body := `{"model":"claude-sonnet-4-5","messages":[{"role":"user","content":"hi"}]}`
+	for key, want := range map[string]int{"sk-revoked": http.StatusUnauthorized, "sk-unknown": http.StatusUnauthorized, "": http.StatusOK} {
+		req := httptest.NewRequest(http.MethodPost, "http://x/v1/messages", strings.NewReader(body))
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		rec := httptest.NewRecorder()
+		h.HandleMessages(rec, req)
+		if rec.Code != want {
+			t.Fatalf("key %q: status=%d, want %d: %s", key, rec.Code, want, rec.Body.String())
+		}
+		if want == http.StatusUnauthorized && !strings.Contains(rec.Body.String(), "authentication_error") {
+			t.Fatalf("key %q: body=%s", key, rec.Body.String())
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package handler
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+
+	"orchids-api/internal/prompt"
+	"orchids-api/internal/store"
+)
+
+// lookupApiKey resolves the stored API key for the hash of the key the caller
+// presented. It returns nil when no key was sent, the key is unknown or disabled.
+func (h *Handler) lookupApiKey(ctx context.Context, keyHash string) *store.ApiKey {
+	if keyHash == "" || h.loadBalancer == nil || h.loadBalancer.Store == nil {
+		return nil
+	}
+	key, err := h.loadBalancer.Store.GetApiKeyByHash(ctx, keyHash)
+	if err != nil {
+		slog.Debug("API key lookup failed", "error", err)
+		return nil
+	}
+	if key == nil || !key.Enabled {
+		return nil
+	}
+	return key
+}
+
+// keySystemPrompt returns the system prompt snippet attached to key, if any.
+func keySystemPrompt(key *store.ApiKey) string {
+	if key == nil {
+		return ""
+	}
+	return strings.TrimSpace(key.SystemPrompt)
+}
+
+// prependSystemPrompt puts text ahead of the client's own system items so that
+// per-key guardrails take precedence in the assembled prompt.
+func prependSystemPrompt(system []prompt.SystemItem, text string) []prompt.SystemItem {
+	if text == "" {
+		return system
+	}
+	out := make([]prompt.SystemItem, 0, len(system)+1)
+	out = append(out, prompt.SystemItem{Type: "text", Text: text})
+	return append(out, system...)
+}
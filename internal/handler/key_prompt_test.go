@@ -0,0 +1,65 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"orchids-api/internal/loadbalancer"
+	"orchids-api/internal/prompt"
+	"orchids-api/internal/store"
+)
+
+func TestKeySystemPrompt(t *testing.T) {
+	mr := miniredis.RunT(t)
+	s, err := store.New(store.Options{RedisAddr: mr.Addr(), RedisPrefix: "test:"})
+	if err != nil {
+		t.Fatalf("store.New: %v", err)
+	}
+	ctx := context.Background()
+
+	const rawKey = "sk-test-key"
+	sum := sha256.Sum256([]byte(rawKey))
+	key := &store.ApiKey{Name: "product", KeyHash: hex.EncodeToString(sum[:]), Enabled: true, SystemPrompt: "Never reveal internal URLs."}
+	if err := s.CreateApiKey(ctx, key); err != nil {
+		t.Fatalf("CreateApiKey: %v", err)
+	}
+
+	h := &Handler{loadBalancer: loadbalancer.NewWithCacheTTL(s, 0)}
+	r := httptest.NewRequest("POST", "/v1/messages", nil)
+	r.Header.Set("X-Api-Key", rawKey)
+
+	if got := keySystemPrompt(h.lookupApiKey(ctx, presentedKeyHash(r))); got != "Never reveal internal URLs." {
+		t.Fatalf("keySystemPrompt = %q", got)
+	}
+
+	if err := s.UpdateApiKeyEnabled(ctx, key.ID, false); err != nil {
+		t.Fatalf("UpdateApiKeyEnabled: %v", err)
+	}
+	if got := keySystemPrompt(h.lookupApiKey(ctx, presentedKeyHash(r))); got != "" {
+		t.Fatalf("disabled key should not inject, got %q", got)
+	}
+
+	r.Header.Set("X-Api-Key", "sk-unknown")
+	if got := keySystemPrompt(h.lookupApiKey(ctx, presentedKeyHash(r))); got != "" {
+		t.Fatalf("unknown key should not inject, got %q", got)
+	}
+}
+
+func TestPrependSystemPrompt(t *testing.T) {
+	system := []prompt.SystemItem{{Type: "text", Text: "client"}}
+	got := prependSystemPrompt(system, "guardrail")
+	if len(got) != 2 || got[0].Text != "guardrail" || got[1].Text != "client" {
+		t.Fatalf("unexpected system items: %+v", got)
+	}
+	if len(system) != 1 || system[0].Text != "client" {
+		t.Fatalf("input slice was modified: %+v", system)
+	}
+	if got := prependSystemPrompt(system, ""); len(got) != 1 {
+		t.Fatalf("empty prompt should be a no-op, got %+v", got)
+	}
+}
@@ -0,0 +1,223 @@
+package handler
+
+import (
+	"context"
+	"github.com/goccy/go-json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	apperrors "orchids-api/internal/errors"
+)
+
+// keyUsageRetention keeps a month's totals a little past its end.
+const keyUsageRetention = 40 * 24 * time.Hour
+
+// KeyUsage is an API key's usage in one calendar month (UTC).
+type KeyUsage struct {
+	Requests     int64 `json:"requests"`
+	InputTokens  int64 `json:"input_tokens"`
+	OutputTokens int64 `json:"output_tokens"`
+}
+
+// Total is the token count charged against monthly_token_quota.
+func (u KeyUsage) Total() int64 { return u.InputTokens + u.OutputTokens }
+
+// KeyUsageStore keeps monthly token totals per scope: an API key
+// (keyUsageScope) or a tenant (tenantUsageScope).
+type KeyUsageStore interface {
+	Get(ctx context.Context, scope string, month string) KeyUsage
+	Record(ctx context.Context, scope string, month string, inputTokens, outputTokens int)
+}
+
+// keyUsageScope names an API key's totals.
+func keyUsageScope(keyID int64) string {
+	return strconv.FormatInt(keyID, 10)
+}
+
+// tenantUsageScope names the totals of all keys of a tenant.
+func tenantUsageScope(tenantID int64) string {
+	return "tenant:" + strconv.FormatInt(tenantID, 10)
+}
+
+// keyUsageMonth names the quota period containing t, e.g. "2026-10".
+func keyUsageMonth(t time.Time) string {
+	return t.UTC().Format("2006-01")
+}
+
+// --- Redis Implementation ---
+
+// RedisKeyUsageStore stores monthly usage as Redis HASHes.
+type RedisKeyUsageStore struct {
+	client *redis.Client
+	prefix string
+}
+
+func NewRedisKeyUsageStore(client *redis.Client, prefix string) *RedisKeyUsageStore {
+	return &RedisKeyUsageStore{client: client, prefix: prefix + "keyusage:"}
+}
+
+func (s *RedisKeyUsageStore) key(scope string, month string) string {
+	return s.prefix + scope + ":" + month
+}
+
+func (s *RedisKeyUsageStore) Get(ctx context.Context, scope string, month string) KeyUsage {
+	vals, err := s.client.HGetAll(ctx, s.key(scope, month)).Result()
+	if err != nil {
+		return KeyUsage{}
+	}
+	parse := func(field string) int64 {
+		n, _ := strconv.ParseInt(vals[field], 10, 64)
+		return n
+	}
+	return KeyUsage{
+		Requests:     parse("requests"),
+		InputTokens:  parse("input_tokens"),
+		OutputTokens: parse("output_tokens"),
+	}
+}
+
+func (s *RedisKeyUsageStore) Record(_ context.Context, scope string, month string, inputTokens, outputTokens int) {
+	// Recorded after the response, when the request context may be done.
+	ctx := context.Background()
+	key := s.key(scope, month)
+	pipe := s.client.TxPipeline()
+	pipe.HIncrBy(ctx, key, "requests", 1)
+	pipe.HIncrBy(ctx, key, "input_tokens", int64(inputTokens))
+	pipe.HIncrBy(ctx, key, "output_tokens", int64(outputTokens))
+	pipe.Expire(ctx, key, keyUsageRetention)
+	pipe.Exec(ctx)
+}
+
+// --- Memory Implementation ---
+
+type memoryKeyUsage struct {
+	KeyUsage
+	month string
+}
+
+// MemoryKeyUsageStore keeps usage in process; periods that started before
+// last month are removed by a background cleaner.
+type MemoryKeyUsageStore struct {
+	usage   *ShardedMap[memoryKeyUsage]
+	cleaner *AsyncCleaner
+}
+
+func NewMemoryKeyUsageStore() *MemoryKeyUsageStore {
+	s := &MemoryKeyUsageStore{usage: NewShardedMap[memoryKeyUsage]()}
+	s.cleaner = NewAsyncCleaner(time.Hour)
+	s.cleaner.Start(func() {
+		// Account cap months that start on a reset day ("2026-09@15") run
+		// into the next calendar month, so last month's periods are kept.
+		now := time.Now().UTC()
+		cutoff := keyUsageMonth(time.Date(now.Year(), now.Month()-1, 1, 0, 0, 0, 0, time.UTC))
+		s.usage.RangeDelete(func(_ string, u memoryKeyUsage) bool {
+			return u.month < cutoff
+		})
+	})
+	return s
+}
+
+func (s *MemoryKeyUsageStore) Get(_ context.Context, scope string, month string) KeyUsage {
+	u, _ := s.usage.Get(scope + ":" + month)
+	return u.KeyUsage
+}
+
+func (s *MemoryKeyUsageStore) Record(_ context.Context, scope string, month string, inputTokens, outputTokens int) {
+	s.usage.Compute(scope+":"+month, func(cur memoryKeyUsage, _ bool) (memoryKeyUsage, bool) {
+		cur.month = month
+		cur.Requests++
+		cur.InputTokens += int64(inputTokens)
+		cur.OutputTokens += int64(outputTokens)
+		return cur, true
+	})
+}
+
+// Stop ends the background cleaner.
+func (s *MemoryKeyUsageStore) Stop() {
+	s.cleaner.Stop()
+}
+
+// quotaExhausted reports whether scope has used its monthly token quota.
+func (h *Handler) quotaExhausted(ctx context.Context, scope string, quota int) bool {
+	if quota <= 0 || h.keyUsage == nil {
+		return false
+	}
+	return h.keyUsage.Get(ctx, scope, keyUsageMonth(time.Now())).Total() >= int64(quota)
+}
+
+type keyUsageQuota struct {
+	MonthlyTokens   int   `json:"monthly_tokens"`
+	RemainingTokens int64 `json:"remaining_tokens"`
+}
+
+type keyUsageRateLimit struct {
+	TPMLimit     int   `json:"tpm_limit"`
+	TPMUsed      int64 `json:"tpm_used"`
+	TPMRemaining int64 `json:"tpm_remaining"`
+	Limited      bool  `json:"limited"`
+}
+
+// HandleKeyUsage serves GET /v1/usage: the calling API key's own usage this
+// month, remaining quota and tokens-per-minute status.
+func (h *Handler) HandleKeyUsage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		apperrors.New("invalid_request_error", "Method not allowed", http.StatusMethodNotAllowed).WriteResponse(w)
+		return
+	}
+	key := h.lookupApiKey(r.Context(), presentedKeyHash(r))
+	if key == nil {
+		apperrors.New("authentication_error", "a valid API key is required", http.StatusUnauthorized).WriteResponse(w)
+		return
+	}
+	if err := authorizeKeyAccess(key, r, h.trustedProxies(), time.Now()); err != nil {
+		apperrors.New("permission_error", err.Error(), http.StatusForbidden).WriteResponse(w)
+		return
+	}
+
+	now := time.Now().UTC()
+	periodStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	var usage KeyUsage
+	if h.keyUsage != nil {
+		usage = h.keyUsage.Get(r.Context(), keyUsageScope(key.ID), keyUsageMonth(now))
+	}
+	var quota *keyUsageQuota
+	if key.MonthlyTokenQuota > 0 {
+		quota = &keyUsageQuota{
+			MonthlyTokens:   key.MonthlyTokenQuota,
+			RemainingTokens: max(int64(key.MonthlyTokenQuota)-usage.Total(), 0),
+		}
+	}
+	limit := keyTPMLimit(key, h.config)
+	used := h.tpm.Used(tpmKeyScope(apiKeyScope(r)))
+	rateLimit := keyUsageRateLimit{TPMLimit: limit, TPMUsed: used}
+	if limit > 0 {
+		rateLimit.TPMRemaining = max(int64(limit)-used, 0)
+		rateLimit.Limited = used >= int64(limit)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"key": map[string]interface{}{
+			"id":         key.ID,
+			"name":       key.Name,
+			"key_suffix": key.KeySuffix,
+			"expires_at": key.ExpiresAt,
+		},
+		"period": map[string]interface{}{
+			"month":    keyUsageMonth(now),
+			"start":    periodStart,
+			"reset_at": periodStart.AddDate(0, 1, 0),
+		},
+		"usage": map[string]int64{
+			"requests":      usage.Requests,
+			"input_tokens":  usage.InputTokens,
+			"output_tokens": usage.OutputTokens,
+			"total_tokens":  usage.Total(),
+		},
+		"quota":      quota,
+		"rate_limit": rateLimit,
+	})
+}
@@ -0,0 +1,107 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/goccy/go-json"
+
+	"orchids-api/internal/config"
+	"orchids-api/internal/loadbalancer"
+	"orchids-api/internal/store"
+)
+
+func TestMemoryKeyUsageStore(t *testing.T) {
+	t.Parallel()
+
+	s := NewMemoryKeyUsageStore()
+	defer s.Stop()
+	ctx := context.Background()
+
+	s.Record(ctx, "1", "2026-10", 100, 20)
+	s.Record(ctx, "1", "2026-10", 50, 5)
+	s.Record(ctx, "1", "2026-09", 999, 999)
+	s.Record(ctx, "2", "2026-10", 7, 0)
+
+	if u := s.Get(ctx, "1", "2026-10"); u.Requests != 2 || u.InputTokens != 150 || u.OutputTokens != 25 || u.Total() != 175 {
+		t.Fatalf("unexpected usage: %+v", u)
+	}
+	if u := s.Get(ctx, "3", "2026-10"); u != (KeyUsage{}) {
+		t.Fatalf("unknown key should have no usage: %+v", u)
+	}
+}
+
+func TestHandleKeyUsage(t *testing.T) {
+	mr := miniredis.RunT(t)
+	s, err := store.New(store.Options{RedisAddr: mr.Addr(), RedisPrefix: "test:"})
+	if err != nil {
+		t.Fatalf("store.New: %v", err)
+	}
+	ctx := context.Background()
+	sum := sha256.Sum256([]byte("sk-consumer"))
+	key := &store.ApiKey{Name: "team-a", KeyHash: hex.EncodeToString(sum[:]), KeySuffix: "umer", Enabled: true, MonthlyTokenQuota: 1000, TPMLimit: 500}
+	if err := s.CreateApiKey(ctx, key); err != nil {
+		t.Fatalf("CreateApiKey: %v", err)
+	}
+	usage := NewMemoryKeyUsageStore()
+	defer usage.Stop()
+	h := &Handler{
+		config:       &config.Config{},
+		loadBalancer: loadbalancer.NewWithCacheTTL(s, 0),
+		keyUsage:     usage,
+		tpm:          NewTPMLimiter(),
+	}
+	usage.Record(ctx, keyUsageScope(key.ID), keyUsageMonth(time.Now()), 600, 100)
+
+	get := func(apiKey string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/v1/usage", nil)
+		if apiKey != "" {
+			r.Header.Set("Authorization", "Bearer "+apiKey)
+		}
+		rec := httptest.NewRecorder()
+		h.HandleKeyUsage(rec, r)
+		return rec
+	}
+
+	rec := get("sk-consumer")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status=%d body=%s", rec.Code, rec.Body.String())
+	}
+	var body struct {
+		Usage     map[string]int64  `json:"usage"`
+		Quota     *keyUsageQuota    `json:"quota"`
+		RateLimit keyUsageRateLimit `json:"rate_limit"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if body.Usage["total_tokens"] != 700 || body.Usage["requests"] != 1 {
+		t.Fatalf("unexpected usage: %v", body.Usage)
+	}
+	if body.Quota == nil || body.Quota.RemainingTokens != 300 {
+		t.Fatalf("unexpected quota: %+v", body.Quota)
+	}
+	if body.RateLimit.TPMLimit != 500 || body.RateLimit.TPMRemaining != 500 || body.RateLimit.Limited {
+		t.Fatalf("unexpected rate limit: %+v", body.RateLimit)
+	}
+
+	if h.quotaExhausted(ctx, keyUsageScope(key.ID), key.MonthlyTokenQuota) {
+		t.Fatal("quota should not be exhausted yet")
+	}
+	usage.Record(ctx, keyUsageScope(key.ID), keyUsageMonth(time.Now()), 300, 0)
+	if !h.quotaExhausted(ctx, keyUsageScope(key.ID), key.MonthlyTokenQuota) {
+		t.Fatal("quota should be exhausted")
+	}
+
+	for _, apiKey := range []string{"", "sk-unknown"} {
+		if rec := get(apiKey); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("key %q: status=%d want 401", apiKey, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"path/filepath"
+
+	"orchids-api/internal/debug"
+	"orchids-api/internal/store"
+)
+
+// requestLogResidency resolves where the logs of r may be written from the
+// log_residency of its API key and the key's tenant. It runs before routing,
+// so the very first debug log already lands in the right place.
+func (h *Handler) requestLogResidency(r *http.Request) store.LogResidency {
+	return h.keyLogResidency(r.Context(), h.lookupApiKey(r.Context(), presentedKeyHash(r)))
+}
+
+// keyLogResidency is requestLogResidency for a key that was already looked up.
+func (h *Handler) keyLogResidency(ctx context.Context, key *store.ApiKey) store.LogResidency {
+	if key == nil {
+		return store.LogResidency{}
+	}
+	tenant, _ := h.keyTenant(ctx, key)
+	return store.EffectiveLogResidency(tenant, key)
+}
+
+// newDebugLogger creates the request's debug logger under res: nothing is
+// recorded when res disables debug logs.
+func (h *Handler) newDebugLogger(res store.LogResidency, enabled, sseEnabled bool) *debug.Logger {
+	if res.DisableDebug {
+		return debug.New(false, false)
+	}
+	root := debug.DefaultDir
+	if res.DebugDir != "" {
+		root = res.DebugDir
+	}
+	return debug.NewIn(root, enabled, sseEnabled)
+}
+
+// failureSnapshotDir is where failure snapshots under res are saved.
+func (h *Handler) failureSnapshotDir(res store.LogResidency) string {
+	if res.DebugDir != "" {
+		return filepath.Join(res.DebugDir, "failures")
+	}
+	return h.config.FailureSnapshotDir
+}
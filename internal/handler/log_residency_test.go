@@ -0,0 +1,48 @@
+package handler
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"orchids-api/internal/config"
+	"orchids-api/internal/store"
+)
+
+func TestEffectiveLogResidency(t *testing.T) {
+	tenant := &store.Tenant{LogResidency: &store.LogResidency{DebugDir: "/srv/team-a", AuditPrefix: "team-a:", DisableAudit: true}}
+	key := &store.ApiKey{LogResidency: &store.LogResidency{DebugDir: "/srv/key-7"}}
+
+	res := store.EffectiveLogResidency(tenant, key)
+	if res.DebugDir != "/srv/key-7" || res.AuditPrefix != "team-a:" || !res.DisableAudit || res.DisableDebug {
+		t.Fatalf("residency=%+v", res)
+	}
+	if res := store.EffectiveLogResidency(nil, &store.ApiKey{}); res != (store.LogResidency{}) {
+		t.Fatalf("shared key residency=%+v", res)
+	}
+}
+
+func TestDebugLoggerResidency(t *testing.T) {
+	root := t.TempDir()
+	h := &Handler{config: &config.Config{FailureSnapshotDir: "debug-failures"}}
+
+	logger := h.newDebugLogger(store.LogResidency{DebugDir: root}, true, false)
+	logger.LogIncomingRequest(map[string]string{"model": "m"})
+	logger.Close()
+	if filepath.Dir(logger.Dir()) != root {
+		t.Fatalf("debug log dir=%q, want under %q", logger.Dir(), root)
+	}
+	if _, err := os.Stat(filepath.Join(logger.Dir(), "1_claude_request.json")); err != nil {
+		t.Fatalf("request log not written: %v", err)
+	}
+
+	if dir := h.newDebugLogger(store.LogResidency{DebugDir: root, DisableDebug: true}, true, true).Dir(); dir != "" {
+		t.Fatalf("disabled debug logs still wrote to %q", dir)
+	}
+	if got := h.failureSnapshotDir(store.LogResidency{DebugDir: root}); got != filepath.Join(root, "failures") {
+		t.Fatalf("failure snapshot dir=%q", got)
+	}
+	if got := h.failureSnapshotDir(store.LogResidency{}); got != "debug-failures" {
+		t.Fatalf("default failure snapshot dir=%q", got)
+	}
+}
@@ -0,0 +1,276 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/goccy/go-json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+
+	apperrors "orchids-api/internal/errors"
+)
+
+// messagesWSUpgrader keeps gorilla's default same-origin check: browsers
+// cannot read /v1/messages across sites, so neither may a WebSocket page.
+var messagesWSUpgrader = websocket.Upgrader{}
+
+var errEventStreamClosed = errors.New("event stream closed")
+
+// MessagesWSHandler serves /v1/messages/ws. Each text frame from the client
+// is an Anthropic Messages request (always run as a stream); every SSE event
+// of the reply is sent back as one frame holding the event's JSON data.
+// Control frames are {"type":"cancel"} to abort the in-flight request and
+// {"type":"ping"}, answered with {"type":"pong"}.
+//
+// next is the regular messages handler, so each request goes through the same
+// concurrency limit and pipeline as /v1/messages.
+func (h *Handler) MessagesWSHandler(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		conn, err := messagesWSUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.SetReadLimit(maxRequestBytes)
+
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		messagesPath := strings.TrimSuffix(r.URL.Path, "/ws")
+
+		var writeMu sync.Mutex
+		send := func(payload []byte) bool {
+			writeMu.Lock()
+			defer writeMu.Unlock()
+			return conn.WriteMessage(websocket.TextMessage, payload) == nil
+		}
+		sendError := func(errType, msg string) {
+			send(apperrors.New(errType, msg, http.StatusBadRequest).ToJSON())
+		}
+
+		var runMu sync.Mutex
+		var runCancel context.CancelFunc
+		var runDone chan struct{}
+
+		stopRun := func() {
+			runMu.Lock()
+			cancelFn, done := runCancel, runDone
+			runMu.Unlock()
+			if cancelFn != nil {
+				cancelFn()
+			}
+			if done != nil {
+				<-done
+			}
+		}
+		defer stopRun()
+
+		startRun := func(body []byte) bool {
+			runMu.Lock()
+			defer runMu.Unlock()
+			if runDone != nil {
+				return false
+			}
+			runCtx, cancelFn := context.WithCancel(ctx)
+			done := make(chan struct{})
+			runCancel, runDone = cancelFn, done
+
+			go func() {
+				defer func() {
+					cancelFn()
+					runMu.Lock()
+					runCancel, runDone = nil, nil
+					runMu.Unlock()
+					close(done)
+				}()
+
+				req, err := http.NewRequestWithContext(runCtx, http.MethodPost, messagesPath, bytes.NewReader(body))
+				if err != nil {
+					sendError("server_error", "failed to build request")
+					return
+				}
+				req.Header = messagesWSRequestHeader(r.Header)
+				req.RemoteAddr = r.RemoteAddr
+
+				if err := ServeMessageEvents(next, req, send); err != nil {
+					sendError("invalid_request_error", "Invalid request body")
+					return
+				}
+				if runCtx.Err() != nil && ctx.Err() == nil {
+					send([]byte(`{"type":"cancelled"}`))
+				}
+			}()
+			return true
+		}
+
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				break
+			}
+			var frame struct {
+				Type string `json:"type"`
+			}
+			_ = json.Unmarshal(data, &frame)
+
+			switch frame.Type {
+			case "cancel":
+				stopRun()
+			case "ping":
+				send([]byte(`{"type":"pong"}`))
+			default:
+				if !startRun(data) {
+					sendError("invalid_request_error", "A request is already in progress on this connection; send {\"type\":\"cancel\"} first")
+				}
+			}
+		}
+	}
+}
+
+// ServeMessageEvents runs req as a streaming Messages request through next and
+// hands the JSON data of every SSE event to send. Error bodies written before
+// the stream starts are passed on whole. It returns an error only when the
+// request body is not a JSON object.
+func ServeMessageEvents(next http.HandlerFunc, req *http.Request, send func([]byte) bool) error {
+	data, err := io.ReadAll(req.Body)
+	if err != nil {
+		return err
+	}
+	body, err := forceStreamBody(data)
+	if err != nil {
+		return err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	ew := &eventFrameWriter{header: make(http.Header), send: send}
+	next(ew, req)
+	ew.finish()
+	return nil
+}
+
+// forceStreamBody sets "stream": true on a Messages request body.
+func forceStreamBody(data []byte) ([]byte, error) {
+	var body map[string]json.RawMessage
+	if err := json.Unmarshal(data, &body); err != nil || body == nil {
+		return nil, errors.New("request body must be a JSON object")
+	}
+	body["stream"] = json.RawMessage("true")
+	return json.Marshal(body)
+}
+
+// messagesWSRequestHeader keeps the client's auth and metadata headers for the
+// inner request and drops the WebSocket handshake headers.
+func messagesWSRequestHeader(src http.Header) http.Header {
+	header := make(http.Header, len(src))
+	for k, v := range src {
+		switch {
+		case strings.EqualFold(k, "Upgrade"), strings.EqualFold(k, "Connection"),
+			strings.HasPrefix(http.CanonicalHeaderKey(k), "Sec-Websocket-"):
+			continue
+		}
+		header[k] = append([]string(nil), v...)
+	}
+	return header
+}
+
+// eventFrameWriter is the http.ResponseWriter handed to the messages pipeline.
+// It splits the SSE stream into events and forwards each event's data as one
+// frame. Non-streaming bodies (errors written before the stream starts) are
+// forwarded whole when the request finishes.
+type eventFrameWriter struct {
+	header http.Header
+	status int
+	buf    []byte
+	send   func([]byte) bool
+	closed bool
+}
+
+func (w *eventFrameWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *eventFrameWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *eventFrameWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errEventStreamClosed
+	}
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.buf = append(w.buf, p...)
+	if w.isEventStream() {
+		w.drainEvents()
+	}
+	if w.closed {
+		return 0, errEventStreamClosed
+	}
+	return len(p), nil
+}
+
+func (w *eventFrameWriter) Flush() {}
+
+func (w *eventFrameWriter) isEventStream() bool {
+	return strings.HasPrefix(w.header.Get("Content-Type"), "text/event-stream")
+}
+
+func (w *eventFrameWriter) drainEvents() {
+	for !w.closed {
+		idx := bytes.Index(w.buf, []byte("\n\n"))
+		if idx < 0 {
+			return
+		}
+		block := w.buf[:idx]
+		w.buf = w.buf[idx+2:]
+		if data := sseEventData(block); len(data) > 0 && json.Valid(data) {
+			w.closed = !w.send(data)
+		}
+	}
+}
+
+func (w *eventFrameWriter) finish() {
+	if w.closed {
+		return
+	}
+	if w.isEventStream() {
+		w.buf = append(w.buf, '\n', '\n')
+		w.drainEvents()
+		return
+	}
+	body := bytes.TrimSpace(w.buf)
+	if len(body) == 0 {
+		return
+	}
+	if !json.Valid(body) {
+		body = apperrors.New("api_error", string(body), w.status).ToJSON()
+	}
+	w.closed = !w.send(body)
+}
+
+// sseEventData joins the data lines of one SSE event; comments and the
+// event name are dropped since the JSON payload already carries its type.
+func sseEventData(block []byte) []byte {
+	var data [][]byte
+	for _, line := range bytes.Split(block, []byte("\n")) {
+		line = bytes.TrimSuffix(line, []byte("\r"))
+		if rest, ok := bytes.CutPrefix(line, []byte("data:")); ok {
+			data = append(data, bytes.TrimSpace(rest))
+		}
+	}
+	return bytes.Join(data, []byte("\n"))
+}
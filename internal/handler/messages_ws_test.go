@@ -0,0 +1,152 @@
+package handler
+
+import (
+	"fmt"
+	"github.com/goccy/go-json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func dialMessagesWS(t *testing.T, next http.HandlerFunc) *websocket.Conn {
+	t.Helper()
+	h := &Handler{}
+	srv := httptest.NewServer(h.MessagesWSHandler(next))
+	t.Cleanup(srv.Close)
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/v1/messages/ws"
+	conn, _, err := websocket.DefaultDialer.Dial(url, http.Header{"X-Api-Key": []string{"sk-test"}})
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	return conn
+}
+
+func readFrameType(t *testing.T, conn *websocket.Conn) (string, map[string]interface{}) {
+	t.Helper()
+	var frame map[string]interface{}
+	if err := conn.ReadJSON(&frame); err != nil {
+		t.Fatalf("read frame: %v", err)
+	}
+	typ, _ := frame["type"].(string)
+	return typ, frame
+}
+
+func TestMessagesWSStreamsEvents(t *testing.T) {
+	conn := dialMessagesWS(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var req map[string]interface{}
+		_ = json.Unmarshal(body, &req)
+		if req["stream"] != true {
+			t.Errorf("expected stream to be forced on, got %v", req["stream"])
+		}
+		if r.URL.Path != "/v1/messages" {
+			t.Errorf("expected inner path /v1/messages, got %s", r.URL.Path)
+		}
+		if r.Header.Get("X-Api-Key") != "sk-test" || r.Header.Get("Upgrade") != "" {
+			t.Errorf("unexpected inner headers: %v", r.Header)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		// Split an event across writes to exercise buffering.
+		fmt.Fprint(w, "event: message_start\ndata: {\"type\":\"message_start\"}\n\n: ping\n\nevent: content_block_delta\ndata: {\"type\":")
+		fmt.Fprint(w, "\"content_block_delta\"}\n\nevent: message_stop\ndata: {\"type\":\"message_stop\"}\n\n")
+	})
+
+	if err := conn.WriteJSON(map[string]interface{}{"model": "claude-sonnet-4-6", "messages": []interface{}{}}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	for _, want := range []string{"message_start", "content_block_delta", "message_stop"} {
+		if got, _ := readFrameType(t, conn); got != want {
+			t.Fatalf("expected %s frame, got %s", want, got)
+		}
+	}
+
+	if err := conn.WriteJSON(map[string]string{"type": "ping"}); err != nil {
+		t.Fatalf("write ping: %v", err)
+	}
+	if got, _ := readFrameType(t, conn); got != "pong" {
+		t.Fatalf("expected pong, got %s", got)
+	}
+}
+
+func TestMessagesWSForwardsErrorBody(t *testing.T) {
+	conn := dialMessagesWS(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"type":"error","error":{"type":"invalid_request_error","message":"bad"}}`))
+	})
+
+	if err := conn.WriteMessage(websocket.TextMessage, []byte("not json")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if got, _ := readFrameType(t, conn); got != "error" {
+		t.Fatalf("expected error frame for invalid body, got %s", got)
+	}
+
+	if err := conn.WriteJSON(map[string]interface{}{"messages": []interface{}{}}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	got, frame := readFrameType(t, conn)
+	errObj, _ := frame["error"].(map[string]interface{})
+	if got != "error" || errObj["message"] != "bad" {
+		t.Fatalf("expected upstream error body, got %v", frame)
+	}
+}
+
+func TestMessagesWSCancel(t *testing.T) {
+	started := make(chan struct{})
+	conn := dialMessagesWS(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"type\":\"message_start\"}\n\n")
+		close(started)
+		<-r.Context().Done()
+	})
+
+	if err := conn.WriteJSON(map[string]interface{}{"messages": []interface{}{}}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if got, _ := readFrameType(t, conn); got != "message_start" {
+		t.Fatalf("expected message_start, got %s", got)
+	}
+	<-started
+
+	if err := conn.WriteJSON(map[string]interface{}{"messages": []interface{}{}}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if got, _ := readFrameType(t, conn); got != "error" {
+		t.Fatalf("expected busy error while a request is running, got %s", got)
+	}
+
+	if err := conn.WriteJSON(map[string]string{"type": "cancel"}); err != nil {
+		t.Fatalf("write cancel: %v", err)
+	}
+	if got, _ := readFrameType(t, conn); got != "cancelled" {
+		t.Fatalf("expected cancelled frame, got %s", got)
+	}
+}
+
+func TestMessagesWSRejectsCrossOrigin(t *testing.T) {
+	h := &Handler{}
+	srv := httptest.NewServer(h.MessagesWSHandler(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("cross-origin request reached the pipeline")
+	}))
+	t.Cleanup(srv.Close)
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/v1/messages/ws"
+
+	_, resp, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": []string{"https://evil.example"}})
+	if err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("cross-origin dial: err=%v resp=%v", err, resp)
+	}
+	conn, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": []string{srv.URL}})
+	if err != nil {
+		t.Fatalf("same-origin dial: %v", err)
+	}
+	conn.Close()
+}
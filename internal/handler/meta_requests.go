@@ -0,0 +1,119 @@
+package handler
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Meta-request kinds that Claude Code sends alongside real work. These can be
+// answered locally so they never consume upstream quota.
+const (
+	metaCommandPrefix = "command_prefix"
+	metaTopic         = "topic"
+	metaSuggestion    = "suggestion"
+	metaTitle         = "title"
+	metaCompact       = "compact"
+)
+
+const compactMaxUserRequests = 20
+
+// detectMetaRequest returns the meta-request kind for req, or "" for a regular request.
+// For command prefix requests detail carries the extracted command.
+func detectMetaRequest(req ClaudeRequest) (kind string, detail string) {
+	if ok, command := isCommandPrefixRequest(req); ok {
+		return metaCommandPrefix, command
+	}
+	if isTopicClassifierRequest(req) {
+		return metaTopic, ""
+	}
+	if isTitleRequest(req) {
+		return metaTitle, ""
+	}
+	if isCompactRequest(req) {
+		return metaCompact, ""
+	}
+	if isSuggestionMode(req.Messages) {
+		return metaSuggestion, ""
+	}
+	return "", ""
+}
+
+// isTitleRequest matches Claude Code's "summarize this conversation as a short title" prompt.
+func isTitleRequest(req ClaudeRequest) bool {
+	texts := make([]string, 0, len(req.System)+1)
+	for _, item := range req.System {
+		if strings.EqualFold(strings.TrimSpace(item.Type), "text") {
+			texts = append(texts, item.Text)
+		}
+	}
+	texts = append(texts, extractUserText(req.Messages))
+	for _, text := range texts {
+		if containsTitlePrompt(text) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsTitlePrompt(text string) bool {
+	lower := strings.ToLower(stripSystemRemindersForMode(text))
+	return strings.Contains(lower, "summarize") && strings.Contains(lower, "conversation") &&
+		(strings.Contains(lower, "under 50 characters") || strings.Contains(lower, "word title"))
+}
+
+// isCompactRequest matches the summarization prompt issued by /compact.
+func isCompactRequest(req ClaudeRequest) bool {
+	lower := strings.ToLower(stripSystemRemindersForMode(extractUserText(req.Messages)))
+	return strings.Contains(lower, "create a detailed summary of the conversation so far") ||
+		(strings.Contains(lower, "<summary>") && strings.Contains(lower, "primary request and intent"))
+}
+
+// localMetaResponseText produces the synthetic answer for kinds that are not
+// handled by a dedicated writer.
+func localMetaResponseText(req ClaudeRequest, kind string) string {
+	switch kind {
+	case metaTitle:
+		texts := extractUserTexts(req.Messages)
+		for _, text := range texts {
+			if !containsTitlePrompt(text) {
+				return generateTopicTitle(text)
+			}
+		}
+		return "New Conversation"
+	case metaCompact:
+		return buildLocalCompactSummary(req)
+	default:
+		// Suggestion mode: an empty reply means "no suggestion".
+		return ""
+	}
+}
+
+// buildLocalCompactSummary produces an extractive summary listing the user's
+// requests, which is enough for the client to continue after compaction.
+func buildLocalCompactSummary(req ClaudeRequest) string {
+	texts := extractUserTexts(req.Messages)
+	if len(texts) > 0 && isCompactRequest(ClaudeRequest{Messages: req.Messages[len(req.Messages)-1:]}) {
+		texts = texts[:len(texts)-1]
+	}
+	if len(texts) > compactMaxUserRequests {
+		texts = texts[len(texts)-compactMaxUserRequests:]
+	}
+
+	var sb strings.Builder
+	sb.WriteString("<summary>\n1. Primary Request and Intent:\n")
+	if len(texts) == 0 {
+		sb.WriteString("   - (no prior user requests)\n")
+	}
+	for i, text := range texts {
+		runes := []rune(strings.Join(strings.Fields(text), " "))
+		if len(runes) > 300 {
+			runes = append(runes[:300], '…')
+		}
+		sb.WriteString(fmt.Sprintf("   %d. %s\n", i+1, string(runes)))
+	}
+	if workdir := extractWorkdirFromSystem(req.System); workdir != "" {
+		sb.WriteString("\n2. Working Directory:\n   - " + workdir + "\n")
+	}
+	sb.WriteString("</summary>")
+	return sb.String()
+}
@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"strings"
+	"testing"
+
+	"orchids-api/internal/prompt"
+)
+
+func userMsg(text string) prompt.Message {
+	return prompt.Message{Role: "user", Content: prompt.MessageContent{Text: text}}
+}
+
+func TestDetectMetaRequest(t *testing.T) {
+	tests := []struct {
+		name string
+		req  ClaudeRequest
+		want string
+	}{
+		{
+			name: "title",
+			req: ClaudeRequest{
+				System:   SystemItems{{Type: "text", Text: "Summarize this coding conversation in under 50 characters."}},
+				Messages: []prompt.Message{userMsg("fix the login bug in auth.go")},
+			},
+			want: metaTitle,
+		},
+		{
+			name: "compact",
+			req: ClaudeRequest{
+				Messages: []prompt.Message{
+					userMsg("add retries to the client"),
+					userMsg("Your task is to create a detailed summary of the conversation so far."),
+				},
+			},
+			want: metaCompact,
+		},
+		{
+			name: "suggestion",
+			req:  ClaudeRequest{Messages: []prompt.Message{userMsg("[SUGGESTION MODE: predict the next prompt]")}},
+			want: metaSuggestion,
+		},
+		{
+			name: "regular",
+			req:  ClaudeRequest{Messages: []prompt.Message{userMsg("refactor the parser")}},
+			want: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, _ := detectMetaRequest(tt.req); got != tt.want {
+				t.Fatalf("detectMetaRequest()=%q want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLocalMetaResponseText(t *testing.T) {
+	title := localMetaResponseText(ClaudeRequest{
+		System:   SystemItems{{Type: "text", Text: "Summarize this coding conversation in under 50 characters."}},
+		Messages: []prompt.Message{userMsg("fix the login bug in auth.go")},
+	}, metaTitle)
+	if title != "fix the login" {
+		t.Fatalf("title=%q", title)
+	}
+
+	summary := localMetaResponseText(ClaudeRequest{
+		Messages: []prompt.Message{
+			userMsg("add retries to the client"),
+			userMsg("Your task is to create a detailed summary of the conversation so far."),
+		},
+	}, metaCompact)
+	if !strings.HasPrefix(summary, "<summary>") || !strings.Contains(summary, "add retries to the client") {
+		t.Fatalf("unexpected summary: %q", summary)
+	}
+	if strings.Contains(summary, "detailed summary") {
+		t.Fatalf("summary should not echo the compact prompt: %q", summary)
+	}
+
+	if got := localMetaResponseText(ClaudeRequest{}, metaSuggestion); got != "" {
+		t.Fatalf("suggestion reply=%q want empty", got)
+	}
+}
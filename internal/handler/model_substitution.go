@@ -0,0 +1,57 @@
+package handler
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+
+	"orchids-api/internal/store"
+)
+
+const (
+	headerUpstreamModel     = "X-Upstream-Model"
+	headerModelSubstitution = "X-Model-Substitution"
+)
+
+// loggedModelSubstitutions holds "requested\x00served" pairs already logged,
+// so each substitution is logged once per process.
+var loggedModelSubstitutions sync.Map
+
+// modelSubstitution reports how mapModel changed requested into served:
+// "alias" for a mapped name, "fallback" for an unknown model answered by the
+// default. Spelling differences such as "4.5" for "4-5" are not reported.
+func modelSubstitution(requested, served string) (string, bool) {
+	normalized := normalizeOrchidsModelKey(requested)
+	if served == "" || served == requested || normalized == served {
+		return "", false
+	}
+	if _, ok := orchidsModelMap[normalized]; ok {
+		return "alias", true
+	}
+	return "fallback", true
+}
+
+// reportModelSubstitution tells the client which model actually answers the
+// request when it differs from the one requested.
+func reportModelSubstitution(header http.Header, requested, served string) {
+	reason, ok := modelSubstitution(requested, served)
+	if !ok {
+		return
+	}
+	header.Set(headerUpstreamModel, served)
+	header.Set(headerModelSubstitution, reason)
+	if _, seen := loggedModelSubstitutions.LoadOrStore(requested+"\x00"+served, struct{}{}); !seen {
+		slog.Warn("Requested model is served by a different upstream model", "requested", requested, "served", served, "reason", reason)
+	}
+}
+
+// strictModels reports whether requests made with key must name a
+// recognized model rather than fall back to the default.
+func (h *Handler) strictModels(key *store.ApiKey) bool {
+	return h.config.StrictModels || (key != nil && key.StrictModels)
+}
+
+func modelNotFoundMessage(model string) string {
+	return fmt.Sprintf("model_not_found: %q is not a recognized model", model)
+}
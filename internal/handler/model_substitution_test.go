@@ -0,0 +1,53 @@
+package handler
+
+import (
+	"net/http"
+	"testing"
+
+	"orchids-api/internal/config"
+	"orchids-api/internal/store"
+)
+
+func TestReportModelSubstitution(t *testing.T) {
+	cases := []struct {
+		requested, served string
+		reason            string
+	}{
+		{"claude-opus-4-5", "claude-opus-4-6", "alias"},
+		{"claude-opus-4.5", mapModel("claude-opus-4.5"), "alias"},
+		{"claude-opus-4.6", mapModel("claude-opus-4.6"), ""},
+		{"claude-sonnet-4-6", "claude-sonnet-4-6", ""},
+		{"my-custom-model", mapModel("my-custom-model"), "fallback"},
+		{"My-Passthrough-Model", "My-Passthrough-Model", ""},
+	}
+	for _, tc := range cases {
+		header := http.Header{}
+		reportModelSubstitution(header, tc.requested, tc.served)
+		if got := header.Get(headerModelSubstitution); got != tc.reason {
+			t.Errorf("%s -> %s: reason=%q want %q", tc.requested, tc.served, got, tc.reason)
+		}
+		if tc.reason != "" && header.Get(headerUpstreamModel) != tc.served {
+			t.Errorf("%s: upstream model=%q want %q", tc.requested, header.Get(headerUpstreamModel), tc.served)
+		}
+	}
+}
+
+func TestStrictModels(t *testing.T) {
+	cases := []struct {
+		global bool
+		key    *store.ApiKey
+		want   bool
+	}{
+		{false, nil, false},
+		{false, &store.ApiKey{}, false},
+		{false, &store.ApiKey{StrictModels: true}, true},
+		{true, nil, true},
+		{true, &store.ApiKey{}, true},
+	}
+	for i, tc := range cases {
+		h := &Handler{config: &config.Config{StrictModels: tc.global}}
+		if got := h.strictModels(tc.key); got != tc.want {
+			t.Errorf("case %d: strictModels=%v want %v", i, got, tc.want)
+		}
+	}
+}
@@ -1,11 +1,14 @@
 package handler
 
 import (
+	"context"
 	"github.com/goccy/go-json"
 	"net/http"
+	"sort"
 	"strings"
 
 	apperrors "orchids-api/internal/errors"
+	"orchids-api/internal/store"
 )
 
 type PublicModelResponse struct {
@@ -31,11 +34,11 @@ func (h *Handler) HandleModels(w http.ResponseWriter, r *http.Request) {
 	// Determine channel filter based on path prefix
 	filterChannel := channelFromPath(r.URL.Path)
 
-	ctx := r.Context()
 	if h.loadBalancer == nil || h.loadBalancer.Store == nil {
 		apperrors.New("api_error", "Model store not configured", http.StatusServiceUnavailable).WriteResponse(w)
 		return
 	}
+	ctx := h.modelViewContext(r)
 	allModels, err := h.loadBalancer.Store.ListModels(ctx)
 	if err != nil {
 		apperrors.New("api_error", "Failed to fetch models: "+err.Error(), http.StatusInternalServerError).WriteResponse(w)
@@ -88,13 +91,15 @@ func (h *Handler) HandleModelByID(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
 	// Extract ID from path
-	// Paths could be: /v1/models/{id}, /orchids/v1/models/{id}, /warp/v1/models/{id}, /grok/v1/models/{id}
+	// Paths could be: /v1/models/{id}, /orchids/v1/models/{id}, /warp/v1/models/{id}, /kiro/v1/models/{id}, /grok/v1/models/{id}
 	path := r.URL.Path
 	var id string
 	if strings.HasPrefix(path, "/orchids/v1/models/") {
 		id = strings.TrimPrefix(path, "/orchids/v1/models/")
 	} else if strings.HasPrefix(path, "/warp/v1/models/") {
 		id = strings.TrimPrefix(path, "/warp/v1/models/")
+	} else if strings.HasPrefix(path, "/kiro/v1/models/") {
+		id = strings.TrimPrefix(path, "/kiro/v1/models/")
 	} else if strings.HasPrefix(path, "/grok/v1/models/") {
 		id = strings.TrimPrefix(path, "/grok/v1/models/")
 	} else {
@@ -106,13 +111,12 @@ func (h *Handler) HandleModelByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ctx := r.Context()
 	if h.loadBalancer == nil || h.loadBalancer.Store == nil {
 		apperrors.New("api_error", "Model store not configured", http.StatusServiceUnavailable).WriteResponse(w)
 		return
 	}
 
-	m, err := h.loadBalancer.Store.GetModelByModelID(ctx, id)
+	m, err := h.loadBalancer.Store.GetModelByModelID(h.modelViewContext(r), id)
 	if err != nil {
 		apperrors.New("invalid_request_error", "Model not found", http.StatusNotFound).WriteResponse(w)
 		return
@@ -142,3 +146,41 @@ func (h *Handler) HandleModelByID(w http.ResponseWriter, r *http.Request) {
 		apperrors.New("api_error", "Failed to encode response", http.StatusInternalServerError).WriteResponse(w)
 	}
 }
+
+// channelDefaultModel picks the model for a channel-scoped route called without
+// one: the channel's enabled default model, otherwise its first enabled model
+// by sort order. It returns "" when the store has no usable model.
+func (h *Handler) channelDefaultModel(ctx context.Context, channel string) string {
+	if h.loadBalancer == nil || h.loadBalancer.Store == nil || channel == "" {
+		return ""
+	}
+	if ch := h.channelRecord(ctx, channel); ch != nil && ch.DefaultModel != "" {
+		return ch.DefaultModel
+	}
+	allModels, err := h.loadBalancer.Store.ListModels(ctx)
+	if err != nil {
+		return ""
+	}
+
+	var candidates []*store.Model
+	for _, m := range allModels {
+		mChannel := strings.TrimSpace(m.Channel)
+		if mChannel == "" {
+			mChannel = "orchids"
+		}
+		if !strings.EqualFold(mChannel, channel) || !m.Status.Enabled() {
+			continue
+		}
+		if m.IsDefault {
+			return m.ModelID
+		}
+		candidates = append(candidates, m)
+	}
+	if len(candidates) == 0 {
+		return ""
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].SortOrder < candidates[j].SortOrder
+	})
+	return candidates[0].ModelID
+}
@@ -0,0 +1,51 @@
+package handler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"orchids-api/internal/loadbalancer"
+	"orchids-api/internal/store"
+)
+
+func TestChannelDefaultModel(t *testing.T) {
+	mr := miniredis.RunT(t)
+	s, err := store.New(store.Options{RedisAddr: mr.Addr(), RedisPrefix: "test:"})
+	if err != nil {
+		t.Fatalf("store.New: %v", err)
+	}
+	ctx := context.Background()
+	h := &Handler{loadBalancer: loadbalancer.NewWithCacheTTL(s, 0)}
+
+	// Seeded defaults.
+	if got := h.channelDefaultModel(ctx, "warp"); got != "auto" {
+		t.Fatalf("expected warp default auto, got %q", got)
+	}
+	if got := h.channelDefaultModel(ctx, "orchids"); got != "claude-sonnet-4-5" {
+		t.Fatalf("expected orchids default claude-sonnet-4-5, got %q", got)
+	}
+
+	// Without a flagged default, the first enabled model by sort order wins.
+	models, err := s.ListModels(ctx)
+	if err != nil {
+		t.Fatalf("ListModels: %v", err)
+	}
+	for _, m := range models {
+		if m.Channel == "Warp" && m.IsDefault {
+			m.IsDefault = false
+			m.Status = store.ModelStatusOffline
+			if err := s.UpdateModel(ctx, m); err != nil {
+				t.Fatalf("UpdateModel: %v", err)
+			}
+		}
+	}
+	if got := h.channelDefaultModel(ctx, "warp"); got != "auto-efficient" {
+		t.Fatalf("expected first enabled warp model, got %q", got)
+	}
+
+	if got := h.channelDefaultModel(ctx, "nope"); got != "" {
+		t.Fatalf("expected no model for unknown channel, got %q", got)
+	}
+}
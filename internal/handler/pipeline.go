@@ -0,0 +1,1408 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-json"
+
+	"orchids-api/internal/adapter"
+	"orchids-api/internal/audit"
+	"orchids-api/internal/debug"
+	apperrors "orchids-api/internal/errors"
+	"orchids-api/internal/loadbalancer"
+	"orchids-api/internal/metrics"
+	"orchids-api/internal/middleware"
+	"orchids-api/internal/orchids"
+	"orchids-api/internal/prompt"
+	"orchids-api/internal/store"
+	"orchids-api/internal/upstream"
+	"orchids-api/internal/util"
+	"orchids-api/internal/warp"
+)
+
+// messagesPipeline carries the state of one /v1/messages request through its
+// stages:
+//
+//	parse    read and decode the body, apply request hooks and channel defaults
+//	route    dedup/coalescing, local meta replies, quotas, account selection
+//	build    tool gating, prompt/history construction, input token estimate
+//	stream   response headers, message_start, upstream calls with retries
+//	finalize non-stream body, account stats, audit log
+//
+// A stage returns false once it has written the response itself. Cleanup that
+// used to be deferred inside HandleMessages is registered with onClose and runs
+// in reverse order when the request ends.
+type messagesPipeline struct {
+	h         *Handler
+	w         http.ResponseWriter
+	r         *http.Request
+	startTime time.Time
+
+	streamingStarted bool
+	cleanups         []func()
+
+	req           ClaudeRequest
+	bodyBytes     []byte
+	forcedChannel string
+	logger        *debug.Logger
+	logResidency  store.LogResidency
+
+	endUserScope    string
+	endUserID       string
+	tpmKey          string
+	tpmAccountKey   string // account the pre-recorded input tokens were charged to
+	tpmChannelKey   string // channel token budget charged alongside the account
+	pin             *accountPin
+	priority        requestPriority
+	active          *activeRequest
+	conversationKey string
+	workdir         string
+	experiment      *experimentAssignment
+
+	apiClient        UpstreamClient
+	currentAccount   *store.Account
+	accountSnapshot  *store.Account
+	trackedAccountID int64
+	failedAccountIDs []int64
+	failedAccountSet map[int64]struct{}
+	isWarpRequest    bool
+
+	noThinking       bool
+	suppressThinking bool
+	gateNoTools      bool
+	apiKey           *store.ApiKey
+	effectiveTools   []interface{}
+	toolGate         string
+	workspaceNote    string
+	serverTools      map[string]serverTool
+	serverToolRounds int
+	warnings         conversionWarnings
+	mappedModel      string
+	builtPrompt      string
+	chatHistory      []interface{}
+	upstreamMessages []prompt.Message
+	inputTokens      int
+	// What build applied and estimated, reported by /api/prompt/preview.
+	hygiene        hygieneStats
+	promptProfile  string
+	inputBreakdown inputTokenBreakdown
+	// promptSystem is req.System as rendered into the prompt, with repeated
+	// blocks replaced by references; systemRefs are the blocks' references.
+	promptSystem  []prompt.SystemItem
+	systemRefs    []string
+	systemDeduped bool
+	// partialErr is the upstream error that cut a response short after it
+	// had already produced output.
+	partialErr error
+	// upstreamErr is the error of the last upstream attempt, nil once an
+	// attempt succeeds.
+	upstreamErr error
+
+	// dryRun builds the request without calling upstream, for previews.
+	dryRun bool
+
+	isStream       bool
+	responseFormat adapter.ResponseFormat
+	// ctx bounds the upstream calls; it carries the max_stream_duration deadline.
+	ctx context.Context
+	// meter counts the upstream traffic of all attempts and enforces
+	// max_upstream_response_bytes; it travels in ctx.
+	meter *upstream.Meter
+	sh    *streamHandler
+}
+
+func newMessagesPipeline(h *Handler, w http.ResponseWriter, r *http.Request) *messagesPipeline {
+	return &messagesPipeline{
+		h:                h,
+		w:                w,
+		r:                r,
+		startTime:        time.Now(),
+		failedAccountSet: make(map[int64]struct{}),
+	}
+}
+
+func (p *messagesPipeline) run() {
+	for _, stage := range []func() bool{p.parse, p.route, p.build, p.stream} {
+		if !stage() {
+			return
+		}
+	}
+	p.finalize()
+}
+
+func (p *messagesPipeline) onClose(fn func()) {
+	p.cleanups = append(p.cleanups, fn)
+}
+
+func (p *messagesPipeline) close() {
+	for i := len(p.cleanups) - 1; i >= 0; i-- {
+		p.cleanups[i]()
+	}
+}
+
+// recoverPanic must be deferred directly so recover() sees the panic.
+func (p *messagesPipeline) recoverPanic() {
+	err := recover()
+	if err == nil {
+		return
+	}
+	if err == http.ErrAbortHandler {
+		panic(err)
+	}
+	middleware.HandlePanic(p.w, p.r, err, p.streamingStarted)
+}
+
+func (p *messagesPipeline) fail(errType, message string, status int) bool {
+	apperrors.New(errType, message, status).WriteResponse(p.w)
+	return false
+}
+
+// parse reads, transforms and decodes the request body.
+func (p *messagesPipeline) parse() bool {
+	h, r := p.h, p.r
+	if r.Method != http.MethodPost {
+		return p.fail("invalid_request_error", "Method not allowed", http.StatusMethodNotAllowed)
+	}
+
+	if maxRequestBytes > 0 {
+		r.Body = http.MaxBytesReader(p.w, r.Body, maxRequestBytes)
+	}
+	bodyBytes, err := io.ReadAll(r.Body)
+	if err != nil {
+		if maxRequestBytes > 0 {
+			var maxErr *http.MaxBytesError
+			if errors.As(err, &maxErr) {
+				return p.fail("invalid_request_error", "Request body too large", http.StatusRequestEntityTooLarge)
+			}
+		}
+		return p.fail("invalid_request_error", "Invalid request body", http.StatusBadRequest)
+	}
+	if h.hooks != nil {
+		transformed, err := h.hooks.ApplyRequest(r.URL.Path, bodyBytes)
+		if err != nil {
+			slog.Warn("Request hook failed", "path", r.URL.Path, "error", err)
+			return p.fail("invalid_request_error", "Invalid request body", http.StatusBadRequest)
+		}
+		bodyBytes = transformed
+	}
+	if err := json.Unmarshal(bodyBytes, &p.req); err != nil {
+		return p.fail("invalid_request_error", "Invalid request body", http.StatusBadRequest)
+	}
+	p.bodyBytes = bodyBytes
+
+	// Channel-scoped routes (/orchids/v1/messages, /warp/v1/messages) pin the
+	// channel and fill in its default model; /v1/messages infers it from the model.
+	p.forcedChannel = channelFromPath(r.URL.Path)
+	if strings.TrimSpace(p.req.Model) == "" && p.forcedChannel != "" {
+		if model := h.channelDefaultModel(r.Context(), p.forcedChannel); model != "" {
+			p.req.Model = model
+			slog.Debug("Applied channel default model", "channel", p.forcedChannel, "model", model)
+		}
+	}
+
+	// 初始化调试日志
+	p.apiKey = h.lookupApiKey(r.Context(), presentedKeyHash(r))
+	p.logResidency = h.keyLogResidency(r.Context(), p.apiKey)
+	capture := !h.config.DebugEnabled && !p.logResidency.DisableDebug && h.debugCaptureRequested(r, p.apiKey)
+	if h.config.FailureSnapshots && !h.config.DebugEnabled && !capture && !p.logResidency.DisableDebug {
+		p.logger = debug.NewBuffered(h.config.DebugLogSSE)
+	} else {
+		p.logger = h.newDebugLogger(p.logResidency, h.config.DebugEnabled || capture, h.config.DebugLogSSE || capture)
+	}
+	p.onClose(p.logger.Close)
+	if capture {
+		if dir := p.logger.Dir(); dir != "" {
+			slog.Info("Debug capture enabled for request", "dir", dir)
+			p.w.Header().Set(debugCaptureHeader, filepath.Base(dir))
+		}
+	}
+
+	// 1. 记录进入的 Claude 请求
+	p.logger.LogIncomingRequest(p.req)
+	return true
+}
+
+// route settles everything that decides whether and where the request goes:
+// duplicate suppression, local meta replies, quotas, pinning and the account.
+func (p *messagesPipeline) route() bool {
+	p.track()
+	h, r, logger := p.h, p.r, p.logger
+
+	if kind, detail := detectMetaRequest(p.req); kind != "" && h.config.LocalMetaRequestEnabled(kind) {
+		p.writeMetaResponse(kind, detail)
+		return false
+	}
+
+	p.endUserScope = apiKeyScope(r)
+	p.endUserID = endUserIDForRequest(p.req)
+	if ok, reason := h.endUsers.Allow(p.endUserScope, p.endUserID, h.config.EndUserRPM, h.config.EndUserDailyTokens); !ok {
+		slog.Warn("End user quota exceeded", "key_scope", p.endUserScope, "user_id", p.endUserID, "reason", reason)
+		logger.LogEarlyExit("end_user_quota", map[string]interface{}{
+			"user_id": p.endUserID,
+			"reason":  reason,
+		})
+		return p.fail("rate_limit_error", reason, http.StatusTooManyRequests)
+	}
+
+	apiKey := p.apiKey
+	p.priority = parseRequestPriority(r.Header.Get(headerPriority))
+	if err := h.authorizeRequestKey(apiKey, r); err != nil {
+		slog.Warn("API key access rejected", "key_scope", p.endUserScope, "error", err)
+		errType, status := keyAccessStatus(err)
+		return p.fail(errType, err.Error(), status)
+	}
+	if apiKey != nil && h.quotaExhausted(r.Context(), keyUsageScope(apiKey.ID), apiKey.MonthlyTokenQuota) {
+		slog.Warn("API key monthly token quota exhausted", "key_scope", p.endUserScope, "quota", apiKey.MonthlyTokenQuota)
+		logger.LogEarlyExit("key_monthly_quota", map[string]interface{}{
+			"quota": apiKey.MonthlyTokenQuota,
+		})
+		return p.fail("rate_limit_error", "API key monthly token quota exhausted", http.StatusTooManyRequests)
+	}
+	tenant, err := h.keyTenant(r.Context(), apiKey)
+	if err != nil {
+		slog.Warn("API key tenant rejected", "key_scope", p.endUserScope, "tenant_id", apiKey.TenantID)
+		return p.fail("permission_error", err.Error(), http.StatusForbidden)
+	}
+	if tenant != nil && h.quotaExhausted(r.Context(), tenantUsageScope(tenant.ID), tenant.MonthlyTokenQuota) {
+		slog.Warn("Tenant monthly token quota exhausted", "tenant", tenant.Name, "quota", tenant.MonthlyTokenQuota)
+		logger.LogEarlyExit("tenant_monthly_quota", map[string]interface{}{
+			"tenant": tenant.Name,
+			"quota":  tenant.MonthlyTokenQuota,
+		})
+		return p.fail("rate_limit_error", "tenant monthly token quota exhausted", http.StatusTooManyRequests)
+	}
+	// From here on the store only serves the accounts and models of the
+	// key's tenant (or of the shared pool).
+	p.r = p.r.WithContext(store.WithTenant(p.r.Context(), keyTenantID(apiKey)))
+	r = p.r
+	p.tpmKey = tpmKeyScope(p.endUserScope)
+	if limit := keyTPMLimit(apiKey, h.config); !h.tpm.Allow(p.tpmKey, limit) {
+		slog.Warn("API key TPM limit reached", "key_scope", p.endUserScope, "limit", limit)
+		logger.LogEarlyExit("key_tpm_limit", map[string]interface{}{
+			"limit": limit,
+		})
+		return p.fail("rate_limit_error", "API key tokens-per-minute limit exceeded", http.StatusTooManyRequests)
+	}
+	// Only requests that passed the key and quota checks may share a call;
+	// the fingerprint covers the key and its tenant.
+	reqHash := h.computeRequestHash(r, p.bodyBytes)
+	slog.Debug("Request fingerprint", "hash", reqHash, "path", r.URL.Path, "content_length", len(p.bodyBytes), "retry", r.Header.Get("X-Stainless-Retry-Count"))
+	if h.coalescer != nil && requestCoalescingFlag.Enabled() {
+		call, leader := h.coalescer.acquire(reqHash)
+		if !leader {
+			slog.Info("Coalescing duplicate in-flight request", "hash", reqHash, "path", r.URL.Path)
+			logger.LogEarlyExit("coalesced_request", map[string]interface{}{
+				"hash": reqHash,
+				"path": r.URL.Path,
+			})
+			switch call.follow(r.Context(), p.w) {
+			case followServed:
+				return false
+			case followEmpty:
+				h.writeDuplicateResponse(p.w, p.req)
+				return false
+			}
+			// The leader's response was too large to replay; the leader has
+			// finished, so this request makes its own upstream call.
+			slog.Info("Coalesced response too large to replay, retrying uncoalesced", "hash", reqHash, "path", r.URL.Path)
+		} else {
+			p.onClose(func() { h.coalescer.finish(reqHash, call) })
+			p.w = &coalesceWriter{ResponseWriter: p.w, call: call}
+		}
+	}
+	if dup, inFlight := h.registerRequest(reqHash); dup {
+		slog.Warn("Duplicate request suppressed", "hash", reqHash, "in_flight", inFlight, "path", r.URL.Path, "user_agent", r.UserAgent())
+		logger.LogEarlyExit("duplicate_request", map[string]interface{}{
+			"hash":      reqHash,
+			"in_flight": inFlight,
+			"path":      r.URL.Path,
+		})
+		h.writeDuplicateResponse(p.w, p.req)
+		return false
+	}
+	p.onClose(func() { h.finishRequest(reqHash) })
+	pin, err := parseAccountPin(r)
+	if err != nil {
+		return p.fail("invalid_request_error", err.Error(), http.StatusBadRequest)
+	}
+	if pin != nil {
+		if err := authorizeAccountPin(apiKey); err != nil {
+			slog.Warn("Account pinning rejected", "key_scope", p.endUserScope, "error", err)
+			return p.fail("permission_error", err.Error(), http.StatusForbidden)
+		}
+		slog.Info("Request pinned by client", "account_id", pin.AccountID, "project_id", pin.ProjectID, "agent_mode", pin.AgentMode)
+	}
+	p.pin = pin
+	if keyPrompt := keySystemPrompt(apiKey); keyPrompt != "" {
+		p.req.System = prependSystemPrompt(p.req.System, keyPrompt)
+		slog.Debug("Injected API key system prompt", "key_scope", p.endUserScope, "length", len(keyPrompt))
+	}
+
+	cacheStrategy := h.config.CacheStrategy
+	if cacheStrategy != "" && cacheStrategy != "none" {
+		applyCacheStrategy(&p.req, cacheStrategy)
+	}
+
+	// Debug: log all headers
+	for k, v := range r.Header {
+		slog.Debug("Incoming header V2 CHECK", "key", k, "value", v)
+	}
+
+	// Context and Conversation Key
+	p.conversationKey = conversationKeyForRequest(r, p.req)
+	p.applyExperiment()
+
+	if err := h.validateModelAvailability(r.Context(), p.req.Model, p.forcedChannel); err != nil {
+		if h.strictModels(p.apiKey) && err.Error() == "model not found" {
+			return p.fail("not_found_error", modelNotFoundMessage(p.req.Model), http.StatusNotFound)
+		}
+		return p.fail("invalid_request_error", err.Error(), http.StatusBadRequest)
+	}
+	effectiveWorkdir, prevWorkdir, workdirChanged := h.resolveWorkdir(r, p.req, p.conversationKey)
+	if workdirChanged {
+		slog.Warn("检测到工作目录变化，已清空历史", "prev", prevWorkdir, "next", effectiveWorkdir, "session", p.conversationKey)
+		p.req.Messages = resetMessagesForNewWorkdir(p.req.Messages)
+		// 工作目录变化时清除上游会话ID，强制开启新对话
+		if p.conversationKey != "" {
+			h.sessionStore.DeleteSession(r.Context(), p.conversationKey)
+		}
+	}
+	p.workdir = effectiveWorkdir
+
+	return p.selectInitialAccount()
+}
+
+// track registers the request with the admin request inspector and derives a
+// request context the inspector can cancel.
+func (p *messagesPipeline) track() {
+	ctx, cancel := context.WithCancel(p.r.Context())
+	p.r = p.r.WithContext(ctx)
+	p.active = &activeRequest{
+		id:        "req_" + randomSessionID(),
+		keyScope:  apiKeyScope(p.r),
+		model:     p.req.Model,
+		path:      p.r.URL.Path,
+		stream:    p.req.Stream,
+		startedAt: p.startTime,
+		cancel:    cancel,
+		stage:     "routing",
+	}
+	p.h.active.add(p.active)
+	p.onClose(func() {
+		p.h.active.remove(p.active.id)
+		cancel()
+	})
+}
+
+func (p *messagesPipeline) writeMetaResponse(kind, detail string) {
+	slog.Debug("Handling meta request locally", "kind", kind)
+	switch kind {
+	case metaCommandPrefix:
+		prefix := detectCommandPrefix(detail)
+		p.logger.LogEarlyExit("command_prefix", map[string]interface{}{
+			"command": detail,
+			"prefix":  prefix,
+		})
+		writeCommandPrefixResponse(p.w, p.req, prefix, p.startTime, p.logger)
+	case metaTopic:
+		p.logger.LogEarlyExit("topic_classifier", map[string]interface{}{
+			"mode": "local",
+		})
+		writeTopicClassifierResponse(p.w, p.req, p.startTime, p.logger)
+	default:
+		p.logger.LogEarlyExit("meta_request", map[string]interface{}{
+			"kind": kind,
+			"mode": "local",
+		})
+		writeLocalTextResponse(p.w, p.req, localMetaResponseText(p.req, kind), p.startTime, p.logger)
+	}
+}
+
+func (p *messagesPipeline) selectInitialAccount() bool {
+	h := p.h
+	if !p.acquireModelSlot() {
+		return false
+	}
+
+	// 手动管理连接计数，账号切换时需要释放旧账号、获取新账号
+	p.onClose(func() {
+		if p.trackedAccountID != 0 && h.loadBalancer != nil {
+			h.loadBalancer.ReleaseConnection(p.trackedAccountID)
+		}
+	})
+	apiClient, currentAccount, err := p.acquireAccount()
+	if err != nil {
+		slog.Error("selectAccount failed", "error", err)
+		p.logger.LogEarlyExit("select_account_failed", map[string]interface{}{
+			"error":   err.Error(),
+			"model":   p.req.Model,
+			"channel": p.forcedChannel,
+		})
+		if errors.Is(err, errPinnedAccountUnavailable) {
+			return p.fail("invalid_request_error", err.Error(), http.StatusBadRequest)
+		}
+		if errors.Is(err, errConcurrencyLimited) || errors.Is(err, errAccountsCapped) {
+			return p.fail("rate_limit_error", err.Error(), http.StatusTooManyRequests)
+		}
+		return p.fail("overloaded_error", err.Error(), http.StatusServiceUnavailable)
+	}
+	slog.Debug("Checkpoint: selectAccount success")
+	p.apiClient, p.currentAccount = apiClient, currentAccount
+	p.active.setAccount(currentAccount)
+
+	// 捕获账号快照，用于请求结束后检测 forceRefreshToken 是否更新了账号信息
+	if currentAccount != nil {
+		snap := *currentAccount
+		p.accountSnapshot = &snap
+	}
+
+	p.applyChannelRules()
+	slog.Debug("Checkpoint: message processing done")
+	return true
+}
+
+// applyChannelRules adapts the request to the channel of the selected
+// account: Warp passes the history through, Orchids gets its system prompt
+// sanitized.
+func (p *messagesPipeline) applyChannelRules() {
+	h := p.h
+	p.isWarpRequest = strings.EqualFold(p.forcedChannel, "warp")
+	if p.currentAccount != nil && strings.EqualFold(p.currentAccount.AccountType, "warp") {
+		p.isWarpRequest = true
+	}
+	if p.isWarpRequest {
+		// Warp passthrough mode: do not trim history/tool results.
+		slog.Debug("Checkpoint: warp passthrough, skip trim/sanitize")
+	} else {
+		// Orchids: do not trim message/tool_result content to preserve full context.
+		slog.Debug("Checkpoint: orchids passthrough, skip context trimming")
+		if sanitized, changed := sanitizeSystemItems(p.req.System, false, h.config); changed {
+			p.req.System = sanitized
+			slog.Info("系统提示已移除 cc_entrypoint", "mode", h.config.OrchidsCCEntrypointMode, "warp", false)
+		}
+	}
+}
+
+// acquireModelSlot enforces model_max_concurrency, queueing for up to
+// concurrency_queue_timeout before rejecting with 429.
+func (p *messagesPipeline) acquireModelSlot() bool {
+	h, model := p.h, p.req.Model
+	limit := h.config.ModelConcurrencyLimit(model)
+	if limit <= 0 {
+		return true
+	}
+	deadline := time.Now().Add(time.Duration(h.config.ConcurrencyQueueTimeout) * time.Second)
+	for p.yieldsSlot() || !h.modelSlots.tryAcquire(model, limit) {
+		if !p.waitQueued(deadline) {
+			slog.Warn("Model concurrency limit reached", "model", model, "limit", limit)
+			p.logger.LogEarlyExit("model_concurrency_limit", map[string]interface{}{
+				"model": model,
+				"limit": limit,
+			})
+			return p.fail("rate_limit_error", fmt.Sprintf("model %s is at its concurrency limit", model), http.StatusTooManyRequests)
+		}
+	}
+	p.onClose(func() { h.modelSlots.release(model) })
+	return true
+}
+
+// acquireAccount selects an account and reserves a connection slot on it,
+// queueing for up to concurrency_queue_timeout while every candidate is at its
+// concurrency or tokens-per-minute limit, then waits for the account's request
+// pace. The slot is recorded in trackedAccountID.
+func (p *messagesPipeline) acquireAccount() (UpstreamClient, *store.Account, error) {
+	h, ctx := p.h, p.r.Context()
+	if p.priority == priorityInteractive {
+		ctx = loadbalancer.WithPreferFast(ctx)
+	}
+	deadline := time.Now().Add(time.Duration(h.config.ConcurrencyQueueTimeout) * time.Second)
+	var throttled, capped []int64
+	for {
+		if p.yieldsSlot() {
+			if !p.waitQueued(deadline) {
+				return nil, nil, errConcurrencyLimited
+			}
+			continue
+		}
+		excluded := p.failedAccountIDs
+		if len(throttled) > 0 || len(capped) > 0 {
+			excluded = append(append(slices.Clip(p.failedAccountIDs), capped...), throttled...)
+		}
+		apiClient, account, err := h.selectAccount(ctx, p.req.Model, p.forcedChannel, excluded, p.pin)
+		capReached := ""
+		if err == nil && account != nil {
+			capReached = h.accountCapReached(ctx, account)
+		}
+		switch {
+		case err != nil:
+			if !errors.Is(err, loadbalancer.ErrAccountsBusy) && len(throttled) == 0 {
+				if len(capped) > 0 {
+					return nil, nil, errAccountsCapped
+				}
+				return nil, nil, err
+			}
+		case account == nil || h.loadBalancer == nil:
+			// Falling back to the default client only makes sense when no
+			// account was skipped for its TPM limit.
+			if len(throttled) == 0 {
+				return apiClient, account, nil
+			}
+		case capReached != "":
+			// Caps last until the day or month ends, so the account is
+			// skipped for the rest of the request rather than waited for.
+			slog.Info("Account cap reached, skipping", "account", account.Name, "cap", capReached)
+			if p.pin != nil {
+				return nil, nil, errAccountsCapped
+			}
+			capped = append(capped, account.ID)
+			continue
+		case !h.tpm.Allow(tpmAccount(account.ID), accountTPMLimit(account, h.config)):
+			if p.pin == nil {
+				throttled = append(throttled, account.ID)
+				continue
+			}
+		case !h.channelBudgetAllows(ctx, account):
+			if p.pin == nil {
+				throttled = append(throttled, account.ID)
+				continue
+			}
+		case h.loadBalancer.TryAcquireConnection(account):
+			if err := h.loadBalancer.Pace(ctx, account); err != nil {
+				h.loadBalancer.ReleaseConnection(account.ID)
+				return nil, nil, err
+			}
+			p.trackedAccountID = account.ID
+			return apiClient, account, nil
+		}
+		throttled = throttled[:0]
+		if !p.waitQueued(deadline) {
+			return nil, nil, errConcurrencyLimited
+		}
+	}
+}
+
+// recordTPM charges tokens to the API key and to the account that served the
+// request. The estimated input is charged up front in stream so that long
+// responses count against the window immediately; finalize charges the rest.
+func (p *messagesPipeline) recordTPM(tokens int) {
+	p.h.tpm.Record(p.tpmKey, tokens)
+	p.h.tpm.Record(p.tpmAccountKey, tokens)
+	p.h.tpm.Record(p.tpmChannelKey, tokens)
+}
+
+// toolChannel names the channel that executes tool calls for this request.
+func (p *messagesPipeline) toolChannel() string {
+	if p.currentAccount != nil && strings.TrimSpace(p.currentAccount.AccountType) != "" {
+		return p.currentAccount.AccountType
+	}
+	return p.forcedChannel
+}
+
+// build decides tool/thinking gating and renders the upstream prompt.
+func (p *messagesPipeline) build() bool {
+	h, req := p.h, p.req
+
+	suggestionMode := isSuggestionMode(req.Messages)
+	p.noThinking = suggestionMode || h.config.SuppressThinking
+	p.suppressThinking = p.noThinking
+	if suggestionMode {
+		p.gateNoTools = true
+	}
+	if h.config.GateToolResultFollowups() && lastUserIsToolResultOnly(req.Messages) {
+		p.gateNoTools = true
+		if h.config.DebugEnabled {
+			slog.Debug("tool_gate: disabled tools for tool_result-only follow-up")
+		}
+	}
+	shortRequest := !p.gateNoTools && len(req.Tools) > 0 && h.isShortNonCodeRequest(req.Messages, p.apiKey)
+	if shortRequest {
+		p.gateNoTools = true
+	}
+	p.effectiveTools = req.Tools
+	if h.config.WarpDisableTools != nil && *h.config.WarpDisableTools {
+		p.effectiveTools = nil
+	}
+	if p.gateNoTools {
+		kept := allowedGateTools(p.effectiveTools, h.config.ToolGateAllowTools)
+		p.effectiveTools = nil
+		p.toolGate = shortRequestToolGate
+		if shortRequest && len(kept) > 0 {
+			p.gateNoTools = false
+			p.effectiveTools = kept
+			p.toolGate = restrictedToolGate(kept)
+		}
+		slog.Debug("tool_gate: restricted tools", "short_request", shortRequest, "kept", len(p.effectiveTools))
+	}
+	if len(p.effectiveTools) > 0 {
+		var unsupported []interface{}
+		caps := h.channelToolCapabilities(p.r.Context(), p.toolChannel(), req.Model)
+		p.effectiveTools, p.serverTools = h.extractServerTools(p.effectiveTools, caps)
+		p.effectiveTools, unsupported = filterChannelTools(p.effectiveTools, caps)
+		p.effectiveTools = append(p.effectiveTools, serverToolDefinitions(p.serverTools)...)
+		described := len(unsupported) > 0 && p.toolGate == ""
+		if described {
+			p.toolGate = unsupportedToolsInstruction(unsupported)
+			slog.Debug("tool_filter: converted unsupported tools to instructions", "channel", p.toolChannel(), "kept", len(p.effectiveTools), "converted", len(unsupported))
+		}
+		p.warnUnsupportedTools(unsupported, described)
+	}
+	p.warnings.warnDroppedBlocks(req.Messages)
+
+	// 构建 prompt（V2 Markdown 格式）
+	startBuild := time.Now()
+	slog.Debug("Starting prompt build...", "conversation_id", p.conversationKey)
+	// Orchids: always use AIClient mode (other implementations are deprecated/removed).
+	_, isOrchidsAIClient := p.apiClient.(*orchids.Client)
+
+	// 映射模型（用于上游请求与提示一致）
+	p.mappedModel = mapModel(req.Model)
+	if p.currentAccount != nil && passthroughModelChannel(h.channelClientType(p.r.Context(), p.currentAccount.AccountType)) {
+		p.mappedModel = req.Model
+	}
+	if reason, ok := modelSubstitution(req.Model, p.mappedModel); ok && reason == "fallback" && h.strictModels(p.apiKey) {
+		return p.fail("not_found_error", modelNotFoundMessage(req.Model), http.StatusNotFound)
+	}
+	reportModelSubstitution(p.w.Header(), req.Model, p.mappedModel)
+
+	messages := req.Messages
+	if textOnlyChannel(h.channelClientType(p.r.Context(), p.toolChannel())) {
+		if stripped, n := prompt.StripImages(messages); n > 0 {
+			messages = stripped
+			slog.Info("Replaced history images with placeholders for text-only channel", "channel", p.toolChannel(), "images", n)
+			p.warnings.add(warnImagesReplaced, "%d image(s) replaced with text placeholders; channel %s accepts text only", n, p.toolChannel())
+		}
+	}
+	ph, hygiene := h.promptHygiene(p.apiKey)
+	if hygiene {
+		cleaned, stats := ph.apply(messages)
+		p.hygiene = stats
+		if stats.total() > 0 {
+			messages = cleaned
+			slog.Debug("Prompt hygiene applied", "system_reminders", stats.reminders, "ide_context", stats.ideContext, "duplicates", stats.duplicates)
+		}
+	}
+	p.promptSystem = p.dedupSystem(ph)
+
+	builtPrompt, aiClientHistory, promptMeta := orchids.BuildAIClientPromptAndHistoryWithMeta(messages, p.promptSystem, p.mappedModel, p.noThinking, p.workdir, h.config.ContextMaxTokens)
+	buildDuration := time.Since(startBuild)
+	slog.Debug("Prompt build completed", "duration", buildDuration)
+	if h.config.DebugEnabled {
+		slog.Info("[Performance] BuildAIClientPromptAndHistory", "duration", buildDuration)
+	}
+	slog.Info("Model mapping", "original", req.Model, "mapped", p.mappedModel)
+
+	p.upstreamMessages = append([]prompt.Message(nil), messages...)
+
+	// Pre-allocate chatHistory
+	if isOrchidsAIClient {
+		p.chatHistory = make([]interface{}, 0, len(aiClientHistory))
+		for _, item := range aiClientHistory {
+			p.chatHistory = append(p.chatHistory, item)
+		}
+	} else {
+		p.chatHistory = make([]interface{}, 0, 10)
+	}
+
+	p.workspaceNote = h.workspaceSnapshot(p.workdir)
+	builtPrompt = p.decoratePrompt(builtPrompt)
+	p.builtPrompt = builtPrompt
+
+	// 2. 记录转换后的 prompt
+	slog.Debug("Checkpoint: LogConvertedPrompt")
+	p.logger.LogConvertedPrompt(builtPrompt)
+
+	breakdown := estimateInputTokenBreakdown(builtPrompt, aiClientHistory, p.effectiveTools)
+	slog.Info(
+		"Input token breakdown (estimated)",
+		"prompt_profile", promptMeta.Profile,
+		"base_prompt_tokens", breakdown.BasePromptTokens,
+		"system_context_tokens", breakdown.SystemContextTokens,
+		"history_tokens", breakdown.HistoryTokens,
+		"tools_tokens", breakdown.ToolsTokens,
+		"estimated_total_input_tokens", breakdown.Total,
+	)
+	p.promptProfile, p.inputBreakdown = promptMeta.Profile, breakdown
+	if !p.checkContextWindow(breakdown) {
+		return false
+	}
+
+	// Token 计数（用于前置 usage 展示）
+	p.inputTokens = breakdown.Total
+	if p.inputTokens <= 0 {
+		p.inputTokens = h.estimateInputTokens(p.r.Context(), req.Model, builtPrompt)
+	}
+	return true
+}
+
+// stream opens the response, sends message_start and drives the upstream.
+func (p *messagesPipeline) stream() bool {
+	h, r, w := p.h, p.r, p.w
+	p.isStream = p.req.Stream
+	p.ctx = r.Context()
+	if d := time.Duration(h.config.MaxStreamDuration) * time.Second; d > 0 {
+		ctx, cancel := context.WithTimeout(p.ctx, d)
+		p.onClose(cancel)
+		p.ctx = ctx
+	}
+	// A stalled client cancels the upstream call instead of holding the account.
+	upstreamCtx, cancelUpstream := context.WithCancel(p.ctx)
+	p.onClose(cancelUpstream)
+	p.meter = upstream.NewMeter(h.config.MaxUpstreamResponseBytes)
+	p.ctx = upstream.WithMeter(upstreamCtx, p.meter)
+	// 输出格式：chat/completions 固定为 OpenAI，其余按 Accept 协商（SSE / NDJSON）
+	p.responseFormat = adapter.NegotiateResponseFormat(r.URL.Path, r.Header.Get("Accept"))
+
+	if p.isStream {
+		// 设置流式响应头
+		w.Header().Set("Content-Type", p.responseFormat.StreamContentType())
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		p.streamingStarted = true
+
+		if _, ok := w.(http.Flusher); !ok {
+			return p.fail("api_error", "Streaming not supported by underlying connection", http.StatusInternalServerError)
+		}
+		if p.responseFormat != adapter.FormatNDJSON {
+			writeTraceComment(w, r.Context())
+		}
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	p.setConversationUsageHeaders()
+	p.setServedByHeaders()
+	p.warnings.setHeader(w.Header())
+	result := p.recordResult()
+	w = p.w
+
+	sh := newStreamHandler(
+		h.config, w, p.logger, p.suppressThinking, p.isStream, p.responseFormat, p.workdir,
+	)
+	if result != nil {
+		h.results.alias(result, apiKeyScope(r), sh.msgID)
+	}
+	if h.hooks != nil {
+		sh.outputFilter = h.hooks.OutputFilter(r.URL.Path)
+	}
+	sh.seedSideEffectDedupFromMessages(p.upstreamMessages)
+	for name := range p.serverTools {
+		if sh.serverTools == nil {
+			sh.serverTools = make(map[string]struct{}, len(p.serverTools))
+		}
+		sh.serverTools[name] = struct{}{}
+	}
+	sh.onClientAbort = cancelUpstream
+	sh.warnings = &p.warnings
+	sh.setUsageTokens(p.inputTokens, -1) // Correctly initialize input tokens
+	if p.currentAccount != nil {
+		p.tpmAccountKey = tpmAccount(p.currentAccount.ID)
+		_, p.tpmChannelKey = h.channelTokenBudget(r.Context(), p.currentAccount)
+	}
+	p.recordTPM(p.inputTokens)
+	// 捕获上游返回的 conversationID，持久化到 session 以便后续请求复用
+	conversationKey := p.conversationKey
+	sh.onConversationID = func(id string) {
+		if conversationKey == "" {
+			return
+		}
+		h.sessionStore.SetConvID(r.Context(), conversationKey, id)
+		h.sessionStore.Touch(r.Context(), conversationKey)
+		slog.Debug("Warp conversationID captured", "key", conversationKey, "id", id)
+	}
+	p.sh = sh
+	p.onClose(sh.release)
+	// Cleanups run in reverse, so the inspector lets go of sh before release.
+	p.active.attach(sh, p.inputTokens)
+	p.onClose(func() { p.active.attach(nil, 0) })
+
+	// 发送 message_start
+	startData, _ := json.Marshal(map[string]interface{}{
+		"type": "message_start",
+		"message": map[string]interface{}{
+			"id":      sh.msgID,
+			"type":    "message",
+			"role":    "assistant",
+			"content": []interface{}{},
+			"model":   p.req.Model,
+			"usage":   map[string]int{"input_tokens": p.inputTokens, "output_tokens": 0},
+		},
+	})
+	sh.writeSSE("message_start", string(startData))
+
+	slog.Debug("New request received")
+
+	if p.isStream {
+		p.startKeepAlive()
+	}
+
+	p.callUpstream()
+
+	// 确保有最终响应
+	if !sh.hasReturn {
+		sh.finishResponse("end_turn")
+	}
+	return true
+}
+
+func (p *messagesPipeline) startKeepAlive() {
+	sh, r := p.sh, p.r
+	keepAliveStop := make(chan struct{})
+	p.onClose(func() { close(keepAliveStop) })
+	ticker := time.NewTicker(keepAliveInterval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				sh.mu.Lock()
+				done := sh.hasReturn
+				sh.mu.Unlock()
+				if done {
+					return
+				}
+				sh.writeKeepAlive()
+			case <-keepAliveStop:
+				return
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}()
+}
+
+// callUpstream sends the request, retrying and switching accounts on
+// retryable failures until it succeeds or gives up.
+func (p *messagesPipeline) callUpstream() {
+	h, r, sh := p.h, p.r, p.sh
+
+	// 复用上游返回的 conversationID，保持会话连续性
+	chatSessionID := ""
+	if p.conversationKey != "" {
+		chatSessionID, _ = h.sessionStore.GetConvID(r.Context(), p.conversationKey)
+		h.sessionStore.Touch(r.Context(), p.conversationKey)
+	}
+	if chatSessionID == "" {
+		chatSessionID = "chat_" + randomSessionID()
+	}
+	maxRetries, retryDelay := h.channelRetryPolicy(r.Context(), p.toolChannel())
+	retryDelay = p.priority.retryDelay(retryDelay)
+	retriesRemaining := maxRetries
+
+	upstreamReq := upstream.UpstreamRequest{
+		Prompt:        p.builtPrompt,
+		ChatHistory:   p.chatHistory,
+		Workdir:       p.workdir,
+		Model:         p.mappedModel,
+		Messages:      p.upstreamMessages,
+		System:        p.req.System,
+		Tools:         p.effectiveTools,
+		NoTools:       p.gateNoTools,
+		NoThinking:    p.noThinking,
+		MaxTokens:     p.req.MaxTokens,
+		ToolChoice:    p.req.ToolChoice,
+		Temperature:   p.req.Temperature,
+		TopP:          p.req.TopP,
+		StopSequences: p.req.StopSequences,
+		Thinking:      p.req.Thinking,
+		ChatSessionID: chatSessionID,
+	}
+	if userID, ok := p.req.Metadata["user_id"].(string); ok {
+		upstreamReq.UserID = userID
+	}
+	upstreamReq.ProjectID = h.pooledProjectID(p.apiClient, p.currentAccount, p.pin)
+	if p.pin != nil {
+		upstreamReq.AgentMode = p.pin.AgentMode
+	}
+	sh.continuationsLeft = h.config.MaxContinuations
+	continuing := false
+	for {
+		if !continuing {
+			if retriesRemaining < maxRetries {
+				// 非首次尝试：向客户端发送重试提示，避免前一次不完整内容造成混淆
+				sh.emitTextBlock("\n\n[Retrying request...]\n\n")
+			}
+			sh.resetRoundState()
+		}
+		continuing = false
+		slog.Debug("Calling Upstream Client...", "attempt", maxRetries-retriesRemaining+1)
+
+		err := p.send(upstreamReq)
+		slog.Debug("Upstream Client Returned", "error", err)
+
+		if sh.clientAborted() {
+			slog.Warn("Client stalled, stream aborted and upstream cancelled", "trace_id", middleware.GetTraceID(r.Context()), "error", err)
+			return
+		}
+		if p.streamExpired() {
+			slog.Warn("Max stream duration reached, ending response", "limit_seconds", h.config.MaxStreamDuration)
+			sh.finishResponse("max_tokens")
+			return
+		}
+
+		if err == nil {
+			p.upstreamErr = nil
+			p.recordSystemRefs()
+			if p.runServerTools(&upstreamReq) || p.continueTruncated(&upstreamReq) {
+				continuing = true
+				continue
+			}
+			sh.forceFinishIfMissing()
+			return
+		}
+		if p.active.isCancelled() {
+			slog.Warn("Request cancelled from the request inspector", "id", p.active.id, "error", err)
+			sh.finishResponse("end_turn")
+			return
+		}
+		p.upstreamErr = err
+		if sh.hasAnyOutput() {
+			slog.Warn("Upstream failed after partial output, skip retry to avoid duplicated token billing", "error", err)
+			p.partialErr = err
+			sh.finishResponse("end_turn")
+			return
+		}
+
+		// Check for non-retriable errors
+		errStr := err.Error()
+		errClass := classifyUpstreamError(errStr)
+		slog.Error("Request error", "error", err, "category", errClass.Category, "retryable", errClass.Retryable)
+		// 标记账号状态（auth 类错误始终标记，无论是否可重试）
+		if p.currentAccount != nil && h.loadBalancer != nil && h.loadBalancer.Store != nil {
+			if status := classifyAccountStatus(errStr); status != "" {
+				// Mark status if it's auth-related OR if it's a 429 (rate limit)
+				// We want to rotate accounts on 429 even if we retry the request on a new account
+				if !errClass.Retryable || errClass.Category == "auth" || status == "429" {
+					slog.Info("标记账号状态", "account_id", p.currentAccount.ID, "status", status, "category", errClass.Category)
+					markAccountStatus(r.Context(), h.loadBalancer.Store, p.currentAccount, status)
+				}
+			}
+		}
+
+		if !errClass.Retryable {
+			slog.Error("Aborting retries for non-retriable error", "error", err, "category", errClass.Category)
+			if errClass.Category == "oversize" {
+				sh.InjectErrorText("Injecting upstream size error to client", "Request failed: "+errStr)
+			}
+			if errClass.Category == "auth_blocked" || errClass.Category == "auth" {
+				sh.InjectAuthError(errClass.Category, errStr)
+			}
+			sh.finishResponse("end_turn")
+			return
+		}
+
+		if p.ctx.Err() != nil {
+			sh.finishResponse("end_turn")
+			return
+		}
+		if retriesRemaining <= 0 {
+			if p.currentAccount != nil && h.loadBalancer != nil {
+				slog.Error("Account request failed, max retries reached", "account", p.currentAccount.Name)
+			}
+			if errClass.Category == "auth" || errClass.Category == "auth_blocked" {
+				sh.InjectAuthError(errClass.Category, errStr)
+			} else {
+				sh.InjectRetryExhaustedError(errStr)
+			}
+			sh.finishResponse("end_turn")
+			return
+		}
+		retriesRemaining--
+		if errClass.SwitchAccount && p.currentAccount != nil && h.loadBalancer != nil {
+			if !p.switchAccount(errStr, &upstreamReq) {
+				return
+			}
+		}
+		if retryDelay > 0 {
+			attempt := maxRetries - retriesRemaining + 1
+			delay := computeRetryDelay(retryDelay, attempt, errClass.Category)
+			if delay > 0 && !util.SleepWithContext(p.ctx, delay) {
+				sh.finishResponse("end_turn")
+				return
+			}
+		}
+	}
+}
+
+// streamExpired reports whether max_stream_duration cut the upstream call
+// short, as opposed to the client going away.
+func (p *messagesPipeline) streamExpired() bool {
+	return p.r.Context().Err() == nil && errors.Is(p.ctx.Err(), context.DeadlineExceeded)
+}
+
+// send performs one upstream attempt with the currently selected client.
+func (p *messagesPipeline) send(upstreamReq upstream.UpstreamRequest) error {
+	sh, ctx := p.sh, p.ctx
+	slog.Info("Interface check", "type", fmt.Sprintf("%T", p.apiClient))
+	sender, ok := p.apiClient.(UpstreamPayloadClient)
+	if !ok {
+		slog.Warn("Falling back to legacy SendRequest (Workdir lost!)", "type", fmt.Sprintf("%T", p.apiClient))
+		return p.apiClient.SendRequest(ctx, p.builtPrompt, p.chatHistory, p.mappedModel, sh.handleMessage, p.logger)
+	}
+
+	slog.Info("Using SendRequestWithPayload")
+	batches := p.upstreamBatches()
+	noopHandler := func(msg upstream.SSEMessage) {
+		if msg.Type == "error" {
+			slog.Warn("Warp intermediate batch error", "event", msg.Event)
+		}
+	}
+	for i, batch := range batches {
+		batchReq := upstreamReq
+		batchReq.Messages = batch
+		var err error
+		if i == len(batches)-1 {
+			err = sender.SendRequestWithPayload(ctx, batchReq, sh.handleMessage, p.logger)
+		} else {
+			err = sender.SendRequestWithPayload(ctx, batchReq, noopHandler, nil)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// upstreamBatches applies the channel's size limits to the upstream
+// messages and returns them in the batches they are sent in.
+func (p *messagesPipeline) upstreamBatches() [][]prompt.Message {
+	if p.isWarpRequest {
+		return p.warpBatches()
+	}
+	if p.h.config.CompressesToolResults(p.toolChannel()) {
+		var compressed int
+		p.upstreamMessages, compressed = compressToolResults(p.upstreamMessages, p.toolResultCompression())
+		p.warnToolResultsCompressed(compressed)
+	}
+	return [][]prompt.Message{p.upstreamMessages}
+}
+
+// warpBatches applies the Warp token budget and, when enabled, splits tool
+// results into sequential batches. Only the last batch is streamed back.
+func (p *messagesPipeline) warpBatches() [][]prompt.Message {
+	cfg := p.h.config
+	batches := [][]prompt.Message{p.upstreamMessages}
+	if _, isWarp := p.apiClient.(*warp.Client); !isWarp {
+		return batches
+	}
+
+	// Enforce hard token budget for Warp requests to avoid runaway context cost.
+	budget := cfg.ContextMaxTokens
+	if budget <= 0 || budget > 12000 {
+		budget = 12000
+	}
+	trimmed, before, after, compressed, summarized, dropped := enforceWarpBudget(p.builtPrompt, p.upstreamMessages, budget, p.toolResultCompression())
+	if before.Total != after.Total || compressed > 0 || summarized > 0 || dropped > 0 {
+		slog.Info(
+			"Warp budget applied",
+			"budget", budget,
+			"tokens_before", before.Total,
+			"tokens_after", after.Total,
+			"prompt_tokens", after.PromptTokens,
+			"messages_tokens", after.MessagesTokens,
+			"tool_tokens", after.ToolTokens,
+			"compressed_blocks", compressed,
+			"summarized_messages", summarized,
+			"dropped_messages", dropped,
+		)
+	}
+	p.upstreamMessages = trimmed
+	batches = [][]prompt.Message{trimmed}
+	p.warnToolResultsCompressed(compressed)
+	if summarized > 0 || dropped > 0 {
+		p.warnings.add(warnHistoryTrimmed, "%d older message(s) summarized and %d dropped to fit the %d token budget", summarized, dropped, budget)
+	}
+
+	if cfg.WarpSplitToolResults {
+		split, total := splitWarpToolResults(trimmed, 1)
+		if len(split) > 1 {
+			slog.Info("Warp 工具结果分批发送", "total_tool_results", total, "batches", len(split))
+		}
+		batches = split
+	}
+	return batches
+}
+
+// switchAccount marks the current account as failed and moves to the next
+// one. It returns false when no account is left and the response was closed.
+func (p *messagesPipeline) switchAccount(errStr string, upstreamReq *upstream.UpstreamRequest) bool {
+	h, sh := p.h, p.sh
+	if _, ok := p.failedAccountSet[p.currentAccount.ID]; !ok {
+		p.failedAccountSet[p.currentAccount.ID] = struct{}{}
+		p.failedAccountIDs = append(p.failedAccountIDs, p.currentAccount.ID)
+	}
+	slog.Warn("Account request failed, switching account", "account", p.currentAccount.Name, "unsuccessful_attempts", len(p.failedAccountIDs))
+
+	// 释放旧账号的连接计数
+	if p.trackedAccountID != 0 {
+		h.loadBalancer.ReleaseConnection(p.trackedAccountID)
+		p.trackedAccountID = 0
+	}
+
+	apiClient, currentAccount, retryErr := p.acquireAccount()
+	p.apiClient, p.currentAccount = apiClient, currentAccount
+	p.active.setAccount(currentAccount)
+	if retryErr != nil {
+		slog.Error("No more accounts available", "error", retryErr)
+		sh.InjectNoAvailableAccountError(errStr, retryErr)
+		sh.finishResponse("end_turn")
+		return false
+	}
+	if currentAccount != nil {
+		slog.Debug("Switched to account", "account", currentAccount.Name)
+		upstreamReq.ProjectID = h.pooledProjectID(apiClient, currentAccount, p.pin)
+	} else {
+		slog.Debug("Switched to default upstream config")
+	}
+	p.restoreSystem(upstreamReq)
+	return true
+}
+
+// finalize writes the non-stream body and records usage and audit data.
+func (p *messagesPipeline) finalize() {
+	h, r, sh := p.h, p.r, p.sh
+
+	if !p.isStream {
+		p.writeJSONResponse()
+	}
+
+	// Sync state and update stats using helpers
+	h.syncWarpState(p.currentAccount, p.apiClient, p.accountSnapshot)
+	h.updateAccountStats(p.currentAccount, sh.inputTokens, sh.outputTokens)
+	h.endUsers.Record(p.endUserScope, p.endUserID, sh.inputTokens, sh.outputTokens)
+	if p.conversationKey != "" && h.conversationUsage != nil {
+		h.conversationUsage.Record(r.Context(), p.conversationKey, p.req.Model, sh.inputTokens, sh.outputTokens)
+	}
+	// Output tokens charged to the key's quotas.
+	quotaOutput := sh.outputTokens
+	if h.config.QuotaExcludeReasoningTokens {
+		quotaOutput -= sh.reasoningTokens
+	}
+	if p.apiKey != nil && h.keyUsage != nil {
+		month := keyUsageMonth(time.Now())
+		h.keyUsage.Record(r.Context(), keyUsageScope(p.apiKey.ID), month, sh.inputTokens, quotaOutput)
+		if p.apiKey.TenantID != 0 {
+			h.keyUsage.Record(r.Context(), tenantUsageScope(p.apiKey.TenantID), month, sh.inputTokens, quotaOutput)
+		}
+	}
+	h.recordAccountUsage(r.Context(), p.currentAccount, sh.inputTokens, sh.outputTokens)
+	p.recordTPM(sh.inputTokens + sh.outputTokens - p.inputTokens)
+	h.tpm.Record(p.tpmKey, quotaOutput-sh.outputTokens)
+	if sent := p.meter.Sent(); sent > 0 {
+		channel := p.toolChannel()
+		metrics.UpstreamRequestBytes.WithLabelValues(channel).Observe(float64(sent))
+		metrics.UpstreamResponseBytes.WithLabelValues(channel).Observe(float64(p.meter.Received()))
+	}
+
+	// Audit log
+	if h.auditLogger != nil && !p.logResidency.DisableAudit {
+		accountID := int64(0)
+		channel := p.forcedChannel
+		if p.currentAccount != nil {
+			accountID = p.currentAccount.ID
+			if channel == "" {
+				channel = p.currentAccount.AccountType
+			}
+		}
+		status := "success"
+		if sh.finalStopReason == "" && !sh.hasReturn {
+			status = "error"
+		}
+		errMsg := ""
+		if sh.clientAbort != "" {
+			status = "client_abort"
+			errMsg = sh.clientAbort
+		}
+		var keyID int64
+		if p.apiKey != nil {
+			keyID = p.apiKey.ID
+		}
+		metadata := map[string]interface{}{
+			"input_tokens":            sh.inputTokens,
+			"output_tokens":           sh.outputTokens,
+			"reasoning_tokens":        sh.reasoningTokens,
+			"stream":                  p.isStream,
+			"upstream_request_bytes":  p.meter.Sent(),
+			"upstream_response_bytes": p.meter.Received(),
+		}
+		if servedBy := servedByAccount(h.config.ServedByMode(), h.config.ServedBySalt, p.currentAccount); servedBy != "" {
+			metadata["served_by_account"] = servedBy
+		}
+		h.auditLogger.Log(r.Context(), audit.Event{
+			Action:    "chat_request",
+			AccountID: accountID,
+			KeyID:     keyID,
+			Model:     p.req.Model,
+			Channel:   channel,
+			ClientIP:  r.RemoteAddr,
+			UserAgent: r.UserAgent(),
+			Duration:  time.Since(p.startTime).Milliseconds(),
+			Status:    status,
+			Error:     errMsg,
+			Metadata:  metadata,
+			Prefix:    p.logResidency.AuditPrefix,
+		})
+	}
+
+	p.recordAnomalySample()
+	p.snapshotFailure()
+}
+
+// snapshotFailure persists the buffered debug log of a request that ended in
+// an upstream error or an empty response.
+func (p *messagesPipeline) snapshotFailure() {
+	cfg := p.h.config
+	if !cfg.FailureSnapshots || p.r.Context().Err() != nil {
+		return
+	}
+	details := map[string]interface{}{
+		"model":  p.req.Model,
+		"stream": p.isStream,
+	}
+	var reason string
+	switch {
+	case p.upstreamErr != nil:
+		reason = "upstream_error"
+		details["error"] = p.upstreamErr.Error()
+	case !p.sh.hasAnyOutput():
+		reason = "empty_response"
+	default:
+		return
+	}
+	if p.currentAccount != nil {
+		details["account_id"] = p.currentAccount.ID
+		details["channel"] = p.currentAccount.AccountType
+	}
+	root := p.h.failureSnapshotDir(p.logResidency)
+	dir, err := p.logger.Persist(root, reason, details)
+	if err != nil {
+		slog.Warn("Failed to save failure snapshot", "error", err)
+		return
+	}
+	if dir == "" {
+		return
+	}
+	slog.Info("Saved failure snapshot", "dir", dir, "reason", reason)
+	maxAge := time.Duration(cfg.FailureSnapshotRetentionDays) * 24 * time.Hour
+	if err := debug.PruneSnapshots(root, cfg.FailureSnapshotMax, maxAge); err != nil {
+		slog.Warn("Failed to prune failure snapshots", "error", err)
+	}
+}
+
+// With partial_response_mode "mark", truncated non-stream responses carry
+// this stop reason and header.
+const (
+	partialStopReason     = "upstream_error"
+	partialResponseHeader = "X-Partial-Response"
+)
+
+func (p *messagesPipeline) writeJSONResponse() {
+	sh := p.sh
+	sh.armWriteDeadline()
+	stopReason := sh.finalStopReason
+	if stopReason == "" {
+		stopReason = "end_turn"
+	}
+	var partialErr map[string]interface{}
+	if p.partialErr != nil && p.h.config.MarkPartialResponses() {
+		stopReason = partialStopReason
+		partialErr = map[string]interface{}{
+			"type":    "api_error",
+			"message": "Upstream failed after partial output: " + p.partialErr.Error(),
+		}
+		p.w.Header().Set(partialResponseHeader, "true")
+	}
+	// Warnings added and accounts switched during the upstream calls were
+	// not known yet when the headers were prepared.
+	p.warnings.setHeader(p.w.Header())
+	p.setServedByHeaders()
+
+	for i := range sh.contentBlocks {
+		blockType, _ := sh.contentBlocks[i]["type"].(string)
+		switch blockType {
+		case "text":
+			if builder, ok := sh.textBlockBuilders[i]; ok {
+				sh.contentBlocks[i]["text"] = builder.String()
+			} else if _, ok := sh.contentBlocks[i]["text"]; !ok {
+				sh.contentBlocks[i]["text"] = ""
+			}
+		case "thinking":
+			if builder, ok := sh.thinkingBlockBuilders[i]; ok {
+				sh.contentBlocks[i]["thinking"] = builder.String()
+			} else if _, ok := sh.contentBlocks[i]["thinking"]; !ok {
+				sh.contentBlocks[i]["thinking"] = ""
+			}
+		}
+	}
+
+	if len(sh.contentBlocks) == 0 && sh.responseText.Len() > 0 {
+		sh.contentBlocks = append(sh.contentBlocks, map[string]interface{}{
+			"type": "text",
+			"text": sh.responseText.String(),
+		})
+	}
+
+	reasoningTokens := -1
+	if p.h.config.UsageReasoningTokens {
+		reasoningTokens = sh.reasoningTokens
+	}
+	if p.responseFormat == adapter.FormatOpenAI {
+		response := adapter.BuildOpenAICompletion(
+			sh.msgID, sh.startTime.Unix(), p.req.Model, sh.contentBlocks, stopReason, sh.inputTokens, sh.outputTokens, reasoningTokens,
+		)
+		if partialErr != nil {
+			response["error"] = partialErr
+		}
+		if len(sh.toolTranscript) > 0 {
+			response["transcript"] = sh.toolTranscript
+		}
+		if warnings := p.warnings.all(); len(warnings) > 0 {
+			response["conversion_warnings"] = warnings
+		}
+		if err := json.NewEncoder(p.w).Encode(response); err != nil {
+			slog.Error("Failed to write JSON response", "error", err)
+		}
+		return
+	}
+
+	usage := map[string]int{
+		"input_tokens":  sh.inputTokens,
+		"output_tokens": sh.outputTokens,
+	}
+	if reasoningTokens >= 0 {
+		usage["reasoning_output_tokens"] = reasoningTokens
+	}
+	response := map[string]interface{}{
+		"id":            sh.msgID,
+		"type":          "message",
+		"role":          "assistant",
+		"content":       sh.contentBlocks,
+		"model":         p.req.Model,
+		"stop_reason":   stopReason,
+		"stop_sequence": nil,
+		"usage":         usage,
+	}
+	if stopReason == "stop_sequence" && sh.stopSequence != "" {
+		response["stop_sequence"] = sh.stopSequence
+	}
+	if partialErr != nil {
+		response["error"] = partialErr
+	}
+	if len(sh.toolTranscript) > 0 {
+		response["transcript"] = sh.toolTranscript
+	}
+	if warnings := p.warnings.all(); len(warnings) > 0 {
+		response["conversion_warnings"] = warnings
+	}
+
+	if err := json.NewEncoder(p.w).Encode(response); err != nil {
+		slog.Error("Failed to write JSON response", "error", err)
+	}
+}
@@ -0,0 +1,81 @@
+package handler
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	"orchids-api/internal/anomaly"
+	"orchids-api/internal/store"
+)
+
+// headerPriority carries the client's QoS hint: "interactive" or "batch".
+const headerPriority = "X-Priority"
+
+type requestPriority int
+
+const (
+	priorityNormal requestPriority = iota
+	// priorityInteractive requests are queued ahead of batch requests, avoid
+	// slow accounts and retry sooner.
+	priorityInteractive
+	// priorityBatch requests leave free slots to queued interactive requests
+	// and back off longer between retries.
+	priorityBatch
+)
+
+// parseRequestPriority reads an X-Priority value; anything else is normal.
+func parseRequestPriority(v string) requestPriority {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "interactive":
+		return priorityInteractive
+	case "batch":
+		return priorityBatch
+	}
+	return priorityNormal
+}
+
+func (pr requestPriority) String() string {
+	switch pr {
+	case priorityInteractive:
+		return "interactive"
+	case priorityBatch:
+		return "batch"
+	}
+	return "normal"
+}
+
+// retryDelay scales the channel's base retry delay for the priority.
+func (pr requestPriority) retryDelay(base time.Duration) time.Duration {
+	switch pr {
+	case priorityInteractive:
+		return base / 2
+	case priorityBatch:
+		return base * 2
+	}
+	return base
+}
+
+// yieldsSlot reports whether a batch request must leave a free slot to the
+// interactive requests waiting in the queue.
+func (p *messagesPipeline) yieldsSlot() bool {
+	return p.priority == priorityBatch && p.h.interactiveQueued.Load() > 0
+}
+
+// waitQueued is waitForSlot that counts interactive requests as queued while
+// they wait.
+func (p *messagesPipeline) waitQueued(deadline time.Time) bool {
+	if p.priority == priorityInteractive {
+		p.h.interactiveQueued.Add(1)
+		defer p.h.interactiveQueued.Add(-1)
+	}
+	return waitForSlot(p.r.Context(), deadline)
+}
+
+// AccountSlow reports whether the share of slow requests an account served
+// within anomaly_window reached slow_request_rate. Interactive requests
+// avoid such accounts while others are available.
+func (h *Handler) AccountSlow(acc *store.Account) bool {
+	health, ok := h.anomalies.AccountHealth(acc.ID)
+	return ok && slices.Contains(health.Flags, anomaly.MetricSlowRequests)
+}
@@ -0,0 +1,62 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseRequestPriority(t *testing.T) {
+	cases := map[string]requestPriority{
+		"":             priorityNormal,
+		"interactive":  priorityInteractive,
+		" Interactive": priorityInteractive,
+		"BATCH":        priorityBatch,
+		"1":            priorityNormal,
+	}
+	for v, want := range cases {
+		if got := parseRequestPriority(v); got != want {
+			t.Errorf("%q: priority=%s want %s", v, got, want)
+		}
+	}
+	if d := priorityInteractive.retryDelay(time.Second); d != 500*time.Millisecond {
+		t.Errorf("interactive retry delay=%v", d)
+	}
+	if d := priorityBatch.retryDelay(time.Second); d != 2*time.Second {
+		t.Errorf("batch retry delay=%v", d)
+	}
+}
+
+func TestBatchYieldsToQueuedInteractive(t *testing.T) {
+	h := &Handler{}
+	newPipeline := func(pr requestPriority) *messagesPipeline {
+		p := newMessagesPipeline(h, httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/v1/messages", nil))
+		p.priority = pr
+		return p
+	}
+	interactive, batch, normal := newPipeline(priorityInteractive), newPipeline(priorityBatch), newPipeline(priorityNormal)
+
+	if batch.yieldsSlot() {
+		t.Fatal("batch should not yield with an empty queue")
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		interactive.waitQueued(time.Now().Add(time.Second))
+	}()
+	deadline := time.Now().Add(time.Second)
+	for !batch.yieldsSlot() {
+		if time.Now().After(deadline) {
+			t.Fatal("batch request did not yield to the queued interactive request")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if normal.yieldsSlot() || interactive.yieldsSlot() {
+		t.Fatal("only batch requests yield")
+	}
+	<-done
+	if batch.yieldsSlot() {
+		t.Fatal("batch should stop yielding once the interactive request left the queue")
+	}
+}
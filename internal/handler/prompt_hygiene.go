@@ -0,0 +1,246 @@
+package handler
+
+import (
+	"strings"
+
+	"orchids-api/internal/prompt"
+	"orchids-api/internal/store"
+)
+
+// Modes of prompt_hygiene_system_reminders and prompt_hygiene_ide_context.
+const (
+	hygieneKeep     = "keep"
+	hygieneCompress = "compress"
+	hygieneStrip    = "strip"
+)
+
+const (
+	// hygieneStubChars bounds the one-line stub left by "compress".
+	hygieneStubChars = 120
+	// hygieneDedupMinChars is the shortest text dedup replaces; shorter
+	// repeats ("ok", "continue") are not boilerplate.
+	hygieneDedupMinChars = 200
+	hygieneOmitted       = "[context omitted]"
+	hygieneRepeated      = "[repeated text omitted, see a later message]"
+)
+
+const systemReminderTag = "system-reminder"
+
+// ideContextTags are the IDE context dumps Claude Code adds to user turns.
+var ideContextTags = []string{"ide_opened_file", "ide_selection", "ide_diagnostics"}
+
+// promptHygiene rewrites noise in the history before it is sent upstream.
+// The latest user turn is never changed.
+type promptHygiene struct {
+	systemReminders string
+	ideContext      string
+	dedup           bool
+	// systemDedup replaces system blocks already sent in the upstream
+	// conversation, see dedupSystem.
+	systemDedup bool
+}
+
+// hygieneStats counts what one apply call rewrote.
+type hygieneStats struct {
+	reminders  int
+	ideContext int
+	duplicates int
+}
+
+func (s hygieneStats) total() int { return s.reminders + s.ideContext + s.duplicates }
+
+// promptHygiene returns the configured hygiene for requests made with key,
+// and false when it would leave the history unchanged or key opted out.
+func (h *Handler) promptHygiene(key *store.ApiKey) (promptHygiene, bool) {
+	if key != nil && key.SkipPromptHygiene {
+		return promptHygiene{}, false
+	}
+	ph := promptHygiene{
+		systemReminders: hygieneMode(h.config.PromptHygieneSystemReminders),
+		ideContext:      hygieneMode(h.config.PromptHygieneIDEContext),
+		dedup:           h.config.PromptHygieneDedup,
+		systemDedup:     h.config.PromptHygieneSystemDedup,
+	}
+	return ph, ph.systemReminders != hygieneKeep || ph.ideContext != hygieneKeep || ph.dedup || ph.systemDedup
+}
+
+func hygieneMode(mode string) string {
+	switch m := strings.ToLower(strings.TrimSpace(mode)); m {
+	case hygieneCompress, hygieneStrip:
+		return m
+	}
+	return hygieneKeep
+}
+
+// apply returns a rewritten copy of messages; messages is not modified.
+func (ph promptHygiene) apply(messages []prompt.Message) ([]prompt.Message, hygieneStats) {
+	var stats hygieneStats
+	latest := len(messages)
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == "user" {
+			latest = i
+			break
+		}
+	}
+	out := cloneMessages(messages)
+	for i := 0; i < latest; i++ {
+		msg := &out[i]
+		if msg.Content.IsString() {
+			text := ph.cleanText(msg.Content.Text, &stats)
+			if strings.TrimSpace(text) == "" && strings.TrimSpace(msg.Content.Text) != "" {
+				text = hygieneOmitted
+			}
+			msg.Content.Text = text
+			continue
+		}
+		if len(msg.Content.Blocks) == 0 {
+			continue
+		}
+		kept := make([]prompt.ContentBlock, 0, len(msg.Content.Blocks))
+		for _, block := range msg.Content.Blocks {
+			switch block.Type {
+			case "text":
+				block.Text = ph.cleanText(block.Text, &stats)
+				if strings.TrimSpace(block.Text) == "" {
+					continue
+				}
+			case "tool_result":
+				block.Content = ph.cleanToolResult(block.Content, &stats)
+			}
+			kept = append(kept, block)
+		}
+		if len(kept) == 0 {
+			kept = append(kept, prompt.ContentBlock{Type: "text", Text: hygieneOmitted})
+		}
+		msg.Content.Blocks = kept
+	}
+	if ph.dedup {
+		stats.duplicates = dedupRepeatedText(out, latest)
+	}
+	return out, stats
+}
+
+func (ph promptHygiene) cleanText(text string, stats *hygieneStats) string {
+	var n int
+	text, n = rewriteTagBlocks(text, systemReminderTag, ph.systemReminders)
+	stats.reminders += n
+	for _, tag := range ideContextTags {
+		text, n = rewriteTagBlocks(text, tag, ph.ideContext)
+		stats.ideContext += n
+	}
+	return text
+}
+
+// cleanToolResult cleans string results and the text items of block
+// results, copying items it changes.
+func (ph promptHygiene) cleanToolResult(content interface{}, stats *hygieneStats) interface{} {
+	switch v := content.(type) {
+	case string:
+		return ph.cleanText(v, stats)
+	case []interface{}:
+		var out []interface{}
+		for i, item := range v {
+			m, ok := item.(map[string]interface{})
+			if !ok || m["type"] != "text" {
+				continue
+			}
+			text, _ := m["text"].(string)
+			cleaned := ph.cleanText(text, stats)
+			if cleaned == text {
+				continue
+			}
+			if out == nil {
+				out = make([]interface{}, len(v))
+				copy(out, v)
+			}
+			copied := make(map[string]interface{}, len(m))
+			for k, val := range m {
+				copied[k] = val
+			}
+			copied["text"] = cleaned
+			out[i] = copied
+		}
+		if out != nil {
+			return out
+		}
+	}
+	return content
+}
+
+// rewriteTagBlocks compresses or strips every <tag>...</tag> block of text
+// and returns how many it changed. An unterminated block is kept.
+func rewriteTagBlocks(text, tag, mode string) (string, int) {
+	if mode == hygieneKeep {
+		return text, 0
+	}
+	startTag, endTag := "<"+tag+">", "</"+tag+">"
+	if !strings.Contains(text, startTag) {
+		return text, 0
+	}
+	var sb strings.Builder
+	sb.Grow(len(text))
+	changed := 0
+	i := 0
+	for i < len(text) {
+		start := strings.Index(text[i:], startTag)
+		if start == -1 {
+			sb.WriteString(text[i:])
+			break
+		}
+		blockStart := i + start
+		innerStart := blockStart + len(startTag)
+		end := strings.Index(text[innerStart:], endTag)
+		if end == -1 {
+			sb.WriteString(text[i:])
+			break
+		}
+		sb.WriteString(text[i:blockStart])
+		if mode == hygieneCompress {
+			sb.WriteString(startTag)
+			sb.WriteString(firstLine(text[innerStart:innerStart+end], hygieneStubChars))
+			sb.WriteString(endTag)
+		}
+		i = innerStart + end + len(endTag)
+		changed++
+	}
+	if mode == hygieneStrip && changed > 0 {
+		return strings.TrimSpace(sb.String()), changed
+	}
+	return sb.String(), changed
+}
+
+// dedupRepeatedText replaces text of messages before latest that appears
+// verbatim in a later message, keeping the newest copy.
+func dedupRepeatedText(messages []prompt.Message, latest int) int {
+	seen := make(map[string]struct{})
+	replaced := 0
+	check := func(text string, index int) bool {
+		key := strings.TrimSpace(text)
+		if len(key) < hygieneDedupMinChars {
+			return false
+		}
+		if _, ok := seen[key]; ok {
+			return index < latest
+		}
+		seen[key] = struct{}{}
+		return false
+	}
+	for i := len(messages) - 1; i >= 0; i-- {
+		msg := &messages[i]
+		if msg.Content.IsString() {
+			if check(msg.Content.Text, i) {
+				msg.Content.Text = hygieneRepeated
+				replaced++
+			}
+			continue
+		}
+		for j := range msg.Content.Blocks {
+			block := &msg.Content.Blocks[j]
+			if block.Type == "text" && check(block.Text, i) {
+				block.Text = hygieneRepeated
+				replaced++
+			}
+		}
+	}
+	return replaced
+}
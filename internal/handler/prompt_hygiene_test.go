@@ -0,0 +1,90 @@
+package handler
+
+import (
+	"strings"
+	"testing"
+
+	"orchids-api/internal/config"
+	"orchids-api/internal/prompt"
+	"orchids-api/internal/store"
+)
+
+func hygieneMessages(boilerplate string) []prompt.Message {
+	return []prompt.Message{
+		{Role: "user", Content: prompt.MessageContent{Blocks: []prompt.ContentBlock{
+			{Type: "text", Text: "<system-reminder>\nAs you answer, use this context.\nmore lines\n</system-reminder>"},
+			{Type: "text", Text: "<ide_opened_file>The user opened main.go</ide_opened_file>fix the build"},
+			{Type: "text", Text: boilerplate},
+		}}},
+		{Role: "assistant", Content: prompt.MessageContent{Blocks: []prompt.ContentBlock{
+			{Type: "tool_use", ID: "t1", Name: "Bash"},
+		}}},
+		{Role: "user", Content: prompt.MessageContent{Blocks: []prompt.ContentBlock{
+			{Type: "tool_result", ToolUseID: "t1", Content: "ok\n<system-reminder>todo list is empty</system-reminder>"},
+		}}},
+		{Role: "assistant", Content: prompt.MessageContent{Text: "done"}},
+		{Role: "user", Content: prompt.MessageContent{Blocks: []prompt.ContentBlock{
+			{Type: "text", Text: "<system-reminder>latest reminder</system-reminder>"},
+			{Type: "text", Text: boilerplate},
+		}}},
+	}
+}
+
+func TestPromptHygiene_StripAndDedup(t *testing.T) {
+	t.Parallel()
+
+	boilerplate := strings.Repeat("Follow the coding guidelines. ", 10)
+	messages := hygieneMessages(boilerplate)
+	ph := promptHygiene{systemReminders: hygieneStrip, ideContext: hygieneStrip, dedup: true}
+
+	out, stats := ph.apply(messages)
+	if stats.reminders != 2 || stats.ideContext != 1 || stats.duplicates != 1 {
+		t.Fatalf("stats=%+v", stats)
+	}
+	first := out[0].Content.Blocks
+	if len(first) != 2 || first[0].Text != "fix the build" || first[1].Text != hygieneRepeated {
+		t.Fatalf("unexpected first turn: %+v", first)
+	}
+	if got := out[2].Content.Blocks[0].Content; got != "ok" {
+		t.Fatalf("tool_result=%q want ok", got)
+	}
+	latest := out[4].Content.Blocks
+	if latest[0].Text != "<system-reminder>latest reminder</system-reminder>" || latest[1].Text != boilerplate {
+		t.Fatalf("latest user turn must be kept: %+v", latest)
+	}
+	if !strings.Contains(messages[0].Content.Blocks[0].Text, "<system-reminder>") {
+		t.Fatal("input messages must not be modified")
+	}
+}
+
+func TestPromptHygiene_Compress(t *testing.T) {
+	t.Parallel()
+
+	ph := promptHygiene{systemReminders: hygieneCompress, ideContext: hygieneKeep}
+	out, stats := ph.apply(hygieneMessages("short"))
+	if stats.reminders != 2 || stats.ideContext != 0 {
+		t.Fatalf("stats=%+v", stats)
+	}
+	if got := out[0].Content.Blocks[0].Text; got != "<system-reminder>As you answer, use this context.</system-reminder>" {
+		t.Fatalf("unexpected stub: %q", got)
+	}
+	if got := out[0].Content.Blocks[1].Text; !strings.HasPrefix(got, "<ide_opened_file>") {
+		t.Fatalf("ide context should be kept: %q", got)
+	}
+}
+
+func TestPromptHygiene_KeyOptOut(t *testing.T) {
+	t.Parallel()
+
+	h := &Handler{config: &config.Config{PromptHygieneSystemReminders: "Strip"}}
+	if ph, ok := h.promptHygiene(nil); !ok || ph.systemReminders != hygieneStrip {
+		t.Fatalf("expected strip hygiene, got %+v %v", ph, ok)
+	}
+	if _, ok := h.promptHygiene(&store.ApiKey{SkipPromptHygiene: true}); ok {
+		t.Fatal("key opt-out should disable hygiene")
+	}
+	h.config = &config.Config{}
+	if _, ok := h.promptHygiene(nil); ok {
+		t.Fatal("hygiene should be off by default")
+	}
+}
@@ -0,0 +1,181 @@
+package handler
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/goccy/go-json"
+
+	"orchids-api/internal/debug"
+	apperrors "orchids-api/internal/errors"
+	"orchids-api/internal/prompt"
+	"orchids-api/internal/store"
+)
+
+// promptPreview is the upstream request a /messages request would be turned
+// into.
+type promptPreview struct {
+	Model          string               `json:"model"`
+	MappedModel    string               `json:"mapped_model"`
+	Channel        string               `json:"channel"`
+	Account        *routePreviewAccount `json:"account"`
+	PromptTemplate string               `json:"prompt_template"`
+	PromptProfile  string               `json:"prompt_profile"`
+	Prompt         string               `json:"prompt"`
+	ChatHistory    []interface{}        `json:"chat_history,omitempty"`
+	// Messages are the upstream messages after the channel's size limits;
+	// Batches > 1 when Warp sends tool results in sequential requests.
+	Messages    []prompt.Message    `json:"messages"`
+	Batches     int                 `json:"batches"`
+	Tools       []string            `json:"tools"`
+	ToolGate    string              `json:"tool_gate,omitempty"`
+	InputTokens promptPreviewTokens `json:"input_tokens"`
+	Trims       promptPreviewTrims  `json:"trims"`
+	Warnings    []conversionWarning `json:"warnings"`
+}
+
+type promptPreviewTokens struct {
+	BasePrompt    int `json:"base_prompt"`
+	SystemContext int `json:"system_context"`
+	History       int `json:"history"`
+	Tools         int `json:"tools"`
+	Total         int `json:"total"`
+}
+
+// promptPreviewTrims counts what prompt hygiene removed from the history.
+// Images, tool results and history dropped for the channel are reported in
+// warnings.
+type promptPreviewTrims struct {
+	SystemReminders     int  `json:"system_reminders"`
+	IDEContext          int  `json:"ide_context"`
+	Duplicates          int  `json:"duplicates"`
+	SystemBlocksDeduped bool `json:"system_blocks_deduped"`
+}
+
+// HandlePromptPreview serves POST /api/prompt/preview: it takes a Claude
+// Messages request body, routes it like /api/route/preview and runs the build
+// stage of the pipeline, returning the prompt and messages that would be sent
+// upstream. The optional channel and key_id query parameters stand for a
+// channel-scoped route and the calling API key. Nothing is sent upstream;
+// tool results configured to be summarized are cut with head_tail instead.
+func (h *Handler) HandlePromptPreview(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		apperrors.New("invalid_request_error", "Method not allowed", http.StatusMethodNotAllowed).WriteResponse(w)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBytes))
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			apperrors.New("invalid_request_error", "Request body too large", http.StatusRequestEntityTooLarge).WriteResponse(w)
+			return
+		}
+		apperrors.New("invalid_request_error", "Invalid request body", http.StatusBadRequest).WriteResponse(w)
+		return
+	}
+	var req ClaudeRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		apperrors.New("invalid_request_error", "Invalid request body", http.StatusBadRequest).WriteResponse(w)
+		return
+	}
+	ctx := r.Context()
+	query := r.URL.Query()
+	channel := store.NormalizeChannelName(query.Get("channel"))
+	var key *store.ApiKey
+	if raw := strings.TrimSpace(query.Get("key_id")); raw != "" {
+		id, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || id <= 0 {
+			apperrors.New("invalid_request_error", "invalid key_id", http.StatusBadRequest).WriteResponse(w)
+			return
+		}
+		if key = h.previewKey(ctx, id); key == nil {
+			apperrors.New("not_found_error", "API key not found", http.StatusNotFound).WriteResponse(w)
+			return
+		}
+	}
+	req.Model = strings.TrimSpace(req.Model)
+	if req.Model == "" && channel != "" {
+		req.Model = h.channelDefaultModel(ctx, channel)
+	}
+	if req.Model == "" {
+		apperrors.New("invalid_request_error", "model is required", http.StatusBadRequest).WriteResponse(w)
+		return
+	}
+
+	conversationKey := conversationKeyForRequest(r, req)
+	route := h.previewRoute(ctx, routePreviewRequest{Model: req.Model, Channel: channel, ConversationID: conversationKey}, key)
+	if route.Error != "" {
+		apperrors.New("invalid_request_error", route.Error, http.StatusBadRequest).WriteResponse(w)
+		return
+	}
+
+	// The same system prompt injection as route and applyExperiment.
+	req.System = prependSystemPrompt(req.System, keySystemPrompt(key))
+	if route.variant != nil {
+		req.System = prependSystemPrompt(req.System, route.variant.SystemPrompt)
+	}
+	req.Model = route.Model
+
+	p := newMessagesPipeline(h, w, r.WithContext(store.WithTenant(ctx, route.TenantID)))
+	defer p.close()
+	p.dryRun = true
+	p.logger = debug.New(false, false)
+	p.req = req
+	p.apiKey = key
+	p.conversationKey = conversationKey
+	p.forcedChannel = route.forcedChannel
+	p.workdir, _ = extractWorkdirFromRequest(r, req)
+	p.currentAccount, p.apiClient = route.account, h.client
+	if route.account != nil {
+		p.apiClient = h.clientForAccount(ctx, route.account)
+	}
+	p.applyChannelRules()
+	if !p.build() {
+		return
+	}
+	batches := p.upstreamBatches()
+
+	out := promptPreview{
+		Model:          p.req.Model,
+		MappedModel:    p.mappedModel,
+		Channel:        route.Channel,
+		Account:        route.Account,
+		PromptTemplate: route.PromptTemplate,
+		PromptProfile:  p.promptProfile,
+		Prompt:         p.builtPrompt,
+		ChatHistory:    p.chatHistory,
+		Messages:       p.upstreamMessages,
+		Batches:        len(batches),
+		Tools:          []string{},
+		ToolGate:       p.toolGate,
+		InputTokens: promptPreviewTokens{
+			BasePrompt:    p.inputBreakdown.BasePromptTokens,
+			SystemContext: p.inputBreakdown.SystemContextTokens,
+			History:       p.inputBreakdown.HistoryTokens,
+			Tools:         p.inputBreakdown.ToolsTokens,
+			Total:         p.inputTokens,
+		},
+		Trims: promptPreviewTrims{
+			SystemReminders:     p.hygiene.reminders,
+			IDEContext:          p.hygiene.ideContext,
+			Duplicates:          p.hygiene.duplicates,
+			SystemBlocksDeduped: p.systemDeduped,
+		},
+		Warnings: p.warnings.all(),
+	}
+	for _, tool := range p.effectiveTools {
+		name, serverType := toolIdentity(tool)
+		if name == "" {
+			name = serverType
+		}
+		out.Tools = append(out.Tools, name)
+	}
+	if out.Warnings == nil {
+		out.Warnings = []conversionWarning{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/goccy/go-json"
+
+	"orchids-api/internal/config"
+	"orchids-api/internal/loadbalancer"
+	"orchids-api/internal/store"
+)
+
+func TestHandlePromptPreview(t *testing.T) {
+	mr := miniredis.RunT(t)
+	s, err := store.New(store.Options{RedisAddr: mr.Addr(), RedisPrefix: "test:"})
+	if err != nil {
+		t.Fatalf("store.New: %v", err)
+	}
+	ctx := context.Background()
+	acc := &store.Account{Name: "main", AccountType: "orchids", Enabled: true}
+	if err := s.CreateAccount(ctx, acc); err != nil {
+		t.Fatalf("CreateAccount: %v", err)
+	}
+	cfg := &config.Config{ContextMaxTokens: 8000, PromptHygieneSystemReminders: "strip"}
+	h := NewWithLoadBalancer(cfg, loadbalancer.NewWithCacheTTL(s, 0))
+	h.client = &mockUpstreamEdge{}
+
+	body := `{"model":"claude-sonnet-4-5","system":"You are terse.","messages":[
+		{"role":"user","content":"<system-reminder>todo list is empty</system-reminder>Where is the retry loop?"},
+		{"role":"assistant","content":"In client.go."},
+		{"role":"user","content":"Explain the retry loop"}]}`
+	rec := httptest.NewRecorder()
+	h.HandlePromptPreview(rec, httptest.NewRequest(http.MethodPost, "/api/prompt/preview", strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("code=%d body=%s", rec.Code, rec.Body.String())
+	}
+	var out promptPreview
+	if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if out.Account == nil || out.Account.ID != acc.ID || out.PromptTemplate != "aiclient" || out.MappedModel == "" {
+		t.Fatalf("route: account=%+v template=%q mapped=%q", out.Account, out.PromptTemplate, out.MappedModel)
+	}
+	if !strings.Contains(out.Prompt, "Explain the retry loop") || !strings.Contains(out.Prompt, "You are terse.") {
+		t.Fatalf("prompt missing request content:\n%s", out.Prompt)
+	}
+	if strings.Contains(out.Prompt, "todo list is empty") || out.Trims.SystemReminders != 1 {
+		t.Fatalf("system reminder not stripped: trims=%+v", out.Trims)
+	}
+	if out.InputTokens.Total <= 0 || len(out.Messages) != 3 || out.Batches != 1 {
+		t.Fatalf("tokens=%+v messages=%d batches=%d", out.InputTokens, len(out.Messages), out.Batches)
+	}
+	if got, _ := s.GetAccount(ctx, acc.ID); got.RequestCount != 0 {
+		t.Fatalf("preview counted %d requests", got.RequestCount)
+	}
+
+	rec = httptest.NewRecorder()
+	h.HandlePromptPreview(rec, httptest.NewRequest(http.MethodPost, "/api/prompt/preview?key_id=abc", strings.NewReader(body)))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("invalid key_id code=%d", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	h.HandlePromptPreview(rec, httptest.NewRequest(http.MethodPost, "/api/prompt/preview", strings.NewReader(`{"model":"no-such-model","messages":[]}`)))
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "model not found") {
+		t.Fatalf("unknown model code=%d body=%s", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,279 @@
+package handler
+
+import (
+	"bytes"
+	"container/list"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goccy/go-json"
+
+	apperrors "orchids-api/internal/errors"
+	"orchids-api/internal/middleware"
+)
+
+// maxResultWait caps the ?wait= long-poll of GET /v1/messages/{id}.
+const maxResultWait = 60 * time.Second
+
+// The result cache evicts the least recently used responses beyond these
+// limits, even before their TTL runs out.
+const (
+	maxResultEntries = 1024
+	maxResultBytes   = 64 << 20
+)
+
+// resultEntry is one non-stream response, in flight until done is closed.
+type resultEntry struct {
+	done    chan struct{}
+	status  int
+	header  http.Header
+	body    []byte
+	expires time.Time
+
+	keys []string      // cache keys that reach this entry
+	elem *list.Element // position in the LRU list, nil once removed
+}
+
+// resultCache keeps completed non-stream responses for a short TTL so a
+// client that timed out can fetch the result by message ID or by the
+// X-Request-ID it sent, instead of paying for the request again. Entries are
+// scoped to the API key that made the request and bounded by count and size.
+type resultCache struct {
+	ttl        time.Duration
+	maxEntries int
+	maxBytes   int
+
+	mu      sync.Mutex
+	entries map[string]*resultEntry
+	lru     *list.List // *resultEntry, most recently used first
+	bytes   int
+}
+
+func newResultCache(ttl time.Duration) *resultCache {
+	return &resultCache{
+		ttl:        ttl,
+		maxEntries: maxResultEntries,
+		maxBytes:   maxResultBytes,
+		entries:    make(map[string]*resultEntry),
+		lru:        list.New(),
+	}
+}
+
+func resultKey(scope, id string) string {
+	return scope + "\x00" + id
+}
+
+// begin registers an in-flight response under ids (empty ones are skipped).
+func (c *resultCache) begin(scope string, ids ...string) *resultEntry {
+	entry := &resultEntry{done: make(chan struct{})}
+	now := time.Now()
+	c.mu.Lock()
+	for el := c.lru.Front(); el != nil; {
+		next := el.Next()
+		if e := el.Value.(*resultEntry); !e.expires.IsZero() && now.After(e.expires) {
+			c.removeLocked(e)
+		}
+		el = next
+	}
+	entry.elem = c.lru.PushFront(entry)
+	for _, id := range ids {
+		if id != "" {
+			c.addKeyLocked(entry, resultKey(scope, id))
+		}
+	}
+	c.evictLocked()
+	c.mu.Unlock()
+	return entry
+}
+
+// alias makes entry reachable under one more id.
+func (c *resultCache) alias(entry *resultEntry, scope, id string) {
+	c.mu.Lock()
+	if entry.elem != nil {
+		c.addKeyLocked(entry, resultKey(scope, id))
+	}
+	c.mu.Unlock()
+}
+
+// finish stores the response of entry. Only successful responses are kept;
+// a failed request should be retried rather than replayed.
+func (c *resultCache) finish(entry *resultEntry, rw *resultWriter) {
+	c.mu.Lock()
+	entry.status = rw.status
+	entry.header = rw.Header().Clone()
+	entry.body = rw.buf.Bytes()
+	entry.expires = time.Now().Add(c.ttl)
+	if entry.elem != nil {
+		c.bytes += len(entry.body)
+		if entry.status < 200 || entry.status >= 300 {
+			c.removeLocked(entry)
+		} else {
+			c.evictLocked()
+		}
+	}
+	c.mu.Unlock()
+	close(entry.done)
+}
+
+// lookup returns the entry stored for id by scope.
+func (c *resultCache) lookup(scope, id string) *resultEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[resultKey(scope, id)]
+	if !ok || (!entry.expires.IsZero() && time.Now().After(entry.expires)) {
+		return nil
+	}
+	c.lru.MoveToFront(entry.elem)
+	return entry
+}
+
+// addKeyLocked points key at entry, dropping an older entry's claim on it.
+// Callers hold c.mu.
+func (c *resultCache) addKeyLocked(entry *resultEntry, key string) {
+	if old, ok := c.entries[key]; ok && old != entry {
+		old.keys = slices.DeleteFunc(old.keys, func(k string) bool { return k == key })
+		if len(old.keys) == 0 {
+			c.removeLocked(old)
+		}
+	}
+	c.entries[key] = entry
+	entry.keys = append(entry.keys, key)
+}
+
+// evictLocked drops the least recently used entries until the cache is
+// within its limits. Callers hold c.mu.
+func (c *resultCache) evictLocked() {
+	for c.lru.Len() > 0 && (c.lru.Len() > c.maxEntries || c.bytes > c.maxBytes) {
+		c.removeLocked(c.lru.Back().Value.(*resultEntry))
+	}
+}
+
+// removeLocked forgets entry under all of its keys. Callers hold c.mu.
+func (c *resultCache) removeLocked(entry *resultEntry) {
+	if entry.elem == nil {
+		return
+	}
+	for _, key := range entry.keys {
+		if c.entries[key] == entry {
+			delete(c.entries, key)
+		}
+	}
+	entry.keys = nil
+	c.lru.Remove(entry.elem)
+	entry.elem = nil
+	c.bytes -= len(entry.body)
+}
+
+// resultWriter tees a non-stream response into its result entry.
+type resultWriter struct {
+	http.ResponseWriter
+	status int
+	buf    bytes.Buffer
+}
+
+func (rw *resultWriter) WriteHeader(status int) {
+	if rw.status == 0 {
+		rw.status = status
+	}
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *resultWriter) Write(p []byte) (int, error) {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	rw.buf.Write(p)
+	return rw.ResponseWriter.Write(p)
+}
+
+func (rw *resultWriter) Flush() {
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (rw *resultWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// recordResult keeps this non-stream response in the result cache under the
+// request's trace ID; the message ID is added once the stream handler exists.
+// Callers without an API key all share one scope, so their results are only
+// reachable by IDs the gateway generated, never by one the client chose.
+func (p *messagesPipeline) recordResult() *resultEntry {
+	if p.h.results == nil || p.isStream {
+		return nil
+	}
+	scope := apiKeyScope(p.r)
+	traceID := middleware.GetTraceID(p.r.Context())
+	if scope == anonymousKeyScope && clientTraceID(p.r) {
+		traceID = ""
+	}
+	entry := p.h.results.begin(scope, traceID)
+	rw := &resultWriter{ResponseWriter: p.w}
+	p.w = rw
+	p.onClose(func() { p.h.results.finish(entry, rw) })
+	return entry
+}
+
+// clientTraceID reports whether the request's trace ID came from the client
+// (X-Trace-ID, X-Request-ID or traceparent) rather than being generated.
+func clientTraceID(r *http.Request) bool {
+	th := middleware.GetTraceHeaders(r.Context())
+	return strings.TrimSpace(r.Header.Get(middleware.TraceIDHeader)) != "" || th.RequestID != "" || th.Traceparent != ""
+}
+
+// HandleMessageResult serves GET /v1/messages/{id}: the stored response of a
+// recent non-stream request, looked up by message ID or X-Request-ID. With
+// ?wait=N (seconds, up to 60) it long-polls while the request is running.
+func (h *Handler) HandleMessageResult(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		apperrors.New("invalid_request_error", "Method not allowed", http.StatusMethodNotAllowed).WriteResponse(w)
+		return
+	}
+	id := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+	var entry *resultEntry
+	if h.results != nil && id != "" {
+		entry = h.results.lookup(apiKeyScope(r), id)
+	}
+	if entry == nil {
+		apperrors.New("not_found_error", "No stored result for "+id, http.StatusNotFound).WriteResponse(w)
+		return
+	}
+
+	wait, _ := strconv.Atoi(r.URL.Query().Get("wait"))
+	timer := time.NewTimer(min(time.Duration(max(wait, 0))*time.Second, maxResultWait))
+	defer timer.Stop()
+	select {
+	case <-entry.done:
+	case <-timer.C:
+	case <-r.Context().Done():
+		return
+	}
+
+	select {
+	case <-entry.done:
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Retry-After", "1")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]string{"id": id, "status": "in_progress"})
+		return
+	}
+	if entry.status < 200 || entry.status >= 300 {
+		apperrors.New("not_found_error", "No stored result for "+id, http.StatusNotFound).WriteResponse(w)
+		return
+	}
+	if ct := entry.header.Get("Content-Type"); ct != "" {
+		w.Header().Set("Content-Type", ct)
+	}
+	if v := entry.header.Get(partialResponseHeader); v != "" {
+		w.Header().Set(partialResponseHeader, v)
+	}
+	w.WriteHeader(entry.status)
+	w.Write(entry.body)
+}
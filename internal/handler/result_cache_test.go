@@ -0,0 +1,149 @@
+package handler
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/goccy/go-json"
+
+	"orchids-api/internal/config"
+	"orchids-api/internal/middleware"
+	"orchids-api/internal/upstream"
+)
+
+func TestHandleMessageResultReplaysNonStreamResponse(t *testing.T) {
+	reply := []upstream.SSEMessage{
+		{Type: "model", Event: map[string]any{"type": "text-start"}},
+		{Type: "model", Event: map[string]any{"type": "text-delta", "delta": "ok"}},
+		{Type: "model", Event: map[string]any{"type": "text-end"}},
+		{Type: "model", Event: map[string]any{"type": "finish", "finishReason": "stop"}},
+	}
+	cfg := &config.Config{RequestTimeout: 10, ContextMaxTokens: 1024, ContextSummaryMaxTokens: 256, ContextKeepTurns: 2, ResultCacheTTL: 60}
+	h := NewWithLoadBalancer(cfg, nil)
+	h.client = &roundsUpstream{rounds: [][]upstream.SSEMessage{reply}}
+
+	b, _ := json.Marshal(map[string]any{
+		"model":    "claude-3-5-sonnet",
+		"messages": []map[string]any{{"role": "user", "content": "hi"}},
+		"stream":   false,
+	})
+	req := httptest.NewRequest(http.MethodPost, "http://x/v1/messages", bytes.NewReader(b))
+	req.Header.Set("X-Api-Key", "k1")
+	req = req.WithContext(middleware.WithTraceID(req.Context(), "client-req-1"))
+	rec := httptest.NewRecorder()
+	h.HandleMessages(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status=%d body=%s", rec.Code, rec.Body.String())
+	}
+	var msg struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &msg); err != nil || msg.ID == "" {
+		t.Fatalf("bad response: %s", rec.Body.String())
+	}
+
+	get := func(id, key string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "http://x/v1/messages/"+id, nil)
+		r.Header.Set("X-Api-Key", key)
+		w := httptest.NewRecorder()
+		h.HandleMessageResult(w, r)
+		return w
+	}
+	for _, id := range []string{msg.ID, "client-req-1"} {
+		w := get(id, "k1")
+		if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), rec.Body.Bytes()) {
+			t.Fatalf("GET %s: status=%d body=%s", id, w.Code, w.Body.String())
+		}
+	}
+	if w := get(msg.ID, "k2"); w.Code != http.StatusNotFound {
+		t.Fatalf("another key read the result: %d", w.Code)
+	}
+}
+
+func TestHandleMessageResultLongPollsInFlightRequest(t *testing.T) {
+	h := &Handler{results: newResultCache(time.Minute)}
+	entry := h.results.begin(apiKeyScope(httptest.NewRequest(http.MethodGet, "/", nil)), "req-1")
+
+	w := httptest.NewRecorder()
+	h.HandleMessageResult(w, httptest.NewRequest(http.MethodGet, "/v1/messages/req-1", nil))
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("in-flight status=%d", w.Code)
+	}
+
+	rw := &resultWriter{ResponseWriter: httptest.NewRecorder()}
+	go func() {
+		rw.Write([]byte(`{"id":"msg_1"}`))
+		h.results.finish(entry, rw)
+	}()
+	w = httptest.NewRecorder()
+	h.HandleMessageResult(w, httptest.NewRequest(http.MethodGet, "/v1/messages/req-1?wait=5", nil))
+	if w.Code != http.StatusOK || w.Body.String() != `{"id":"msg_1"}` {
+		t.Fatalf("long poll: status=%d body=%s", w.Code, w.Body.String())
+	}
+}
+
+func TestHandleMessageResultIgnoresAnonymousClientID(t *testing.T) {
+	reply := []upstream.SSEMessage{
+		{Type: "model", Event: map[string]any{"type": "text-delta", "delta": "ok"}},
+		{Type: "model", Event: map[string]any{"type": "finish", "finishReason": "stop"}},
+	}
+	cfg := &config.Config{RequestTimeout: 10, ContextMaxTokens: 1024, ContextSummaryMaxTokens: 256, ContextKeepTurns: 2, ResultCacheTTL: 60}
+	h := NewWithLoadBalancer(cfg, nil)
+	h.client = &roundsUpstream{rounds: [][]upstream.SSEMessage{reply}}
+
+	b, _ := json.Marshal(map[string]any{
+		"model":    "claude-3-5-sonnet",
+		"messages": []map[string]any{{"role": "user", "content": "hi"}},
+	})
+	req := httptest.NewRequest(http.MethodPost, "http://x/v1/messages", bytes.NewReader(b))
+	req.Header.Set(middleware.RequestIDHeader, "shared-id")
+	ctx := middleware.WithTraceHeaders(req.Context(), middleware.TraceHeaders{RequestID: "shared-id"})
+	req = req.WithContext(middleware.WithTraceID(ctx, "shared-id"))
+	rec := httptest.NewRecorder()
+	h.HandleMessages(rec, req)
+	var msg struct {
+		ID string `json:"id"`
+	}
+	if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &msg) != nil || msg.ID == "" {
+		t.Fatalf("status=%d body=%s", rec.Code, rec.Body.String())
+	}
+
+	for id, want := range map[string]int{msg.ID: http.StatusOK, "shared-id": http.StatusNotFound} {
+		w := httptest.NewRecorder()
+		h.HandleMessageResult(w, httptest.NewRequest(http.MethodGet, "http://x/v1/messages/"+id, nil))
+		if w.Code != want {
+			t.Fatalf("GET %s: status=%d, want %d", id, w.Code, want)
+		}
+	}
+}
+
+func TestResultCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := newResultCache(time.Minute)
+	c.maxEntries = 2
+	c.maxBytes = 10
+	store := func(id, body string) {
+		entry := c.begin("s", id)
+		rw := &resultWriter{ResponseWriter: httptest.NewRecorder()}
+		rw.Write([]byte(body))
+		c.finish(entry, rw)
+	}
+
+	store("a", "1")
+	store("b", "2")
+	c.lookup("s", "a")
+	store("c", "3")
+	if c.lookup("s", "b") != nil || c.lookup("s", "a") == nil || c.lookup("s", "c") == nil {
+		t.Fatal("count limit did not evict the least recently used entry")
+	}
+
+	store("d", "0123456789")
+	if c.lookup("s", "a") != nil || c.lookup("s", "c") != nil || c.lookup("s", "d") == nil {
+		t.Fatal("size limit did not evict older entries")
+	}
+	if c.bytes != 10 || len(c.entries) != 1 {
+		t.Fatalf("bytes=%d entries=%d", c.bytes, len(c.entries))
+	}
+}
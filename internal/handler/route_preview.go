@@ -0,0 +1,246 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/goccy/go-json"
+
+	apperrors "orchids-api/internal/errors"
+	"orchids-api/internal/loadbalancer"
+	"orchids-api/internal/store"
+)
+
+// routePreviewRequest is the body of POST /api/route/preview. Channel stands
+// for a channel-scoped route such as /warp/v1/messages.
+type routePreviewRequest struct {
+	Model          string `json:"model"`
+	Channel        string `json:"channel,omitempty"`
+	KeyID          int64  `json:"key_id,omitempty"`
+	ConversationID string `json:"conversation_id,omitempty"`
+}
+
+type routePreviewAccount struct {
+	ID      int64  `json:"id"`
+	Name    string `json:"name"`
+	Channel string `json:"channel"`
+	Reason  string `json:"reason,omitempty"` // why the account was skipped
+}
+
+type routePreviewExperiment struct {
+	Name    string `json:"name"`
+	Variant string `json:"variant"`
+	// Sticky is set when the conversation already runs under the variant;
+	// otherwise the variant is one possible draw.
+	Sticky bool `json:"sticky"`
+}
+
+// routePreview is how a /messages request would be routed.
+type routePreview struct {
+	Model             string                  `json:"model"`
+	Channel           string                  `json:"channel"`
+	ChannelSource     string                  `json:"channel_source"` // route, experiment, model or default
+	Account           *routePreviewAccount    `json:"account"`
+	DefaultClient     bool                    `json:"default_client,omitempty"`
+	Skipped           []routePreviewAccount   `json:"skipped,omitempty"`
+	MappedModel       string                  `json:"mapped_model"`
+	ModelSubstitution string                  `json:"model_substitution,omitempty"`
+	ClientType        string                  `json:"client_type"`
+	PromptTemplate    string                  `json:"prompt_template"` // aiclient or messages
+	SystemPrompts     []string                `json:"system_prompts"`
+	Experiment        *routePreviewExperiment `json:"experiment,omitempty"`
+	TenantID          int64                   `json:"tenant_id,omitempty"`
+	Error             string                  `json:"error,omitempty"`
+}
+
+// routeDecision is a previewed route with what it resolved to.
+type routeDecision struct {
+	routePreview
+	account       *store.Account           // nil for the default client
+	variant       *store.ExperimentVariant // experiment variant applied, if any
+	forcedChannel string                   // channel pinned by the route or the variant
+}
+
+// HandleRoutePreview serves POST /api/route/preview: it resolves the channel,
+// account, mapped model and prompt template a request would get, without
+// taking a connection slot, counting a request or calling upstream.
+func (h *Handler) HandleRoutePreview(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		apperrors.New("invalid_request_error", "Method not allowed", http.StatusMethodNotAllowed).WriteResponse(w)
+		return
+	}
+	var req routePreviewRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&req); err != nil {
+		apperrors.New("invalid_request_error", "Invalid request body", http.StatusBadRequest).WriteResponse(w)
+		return
+	}
+	req.Model = strings.TrimSpace(req.Model)
+	req.Channel = store.NormalizeChannelName(req.Channel)
+	ctx := r.Context()
+
+	var key *store.ApiKey
+	if req.KeyID != 0 {
+		if key = h.previewKey(ctx, req.KeyID); key == nil {
+			apperrors.New("not_found_error", "API key not found", http.StatusNotFound).WriteResponse(w)
+			return
+		}
+	}
+	if req.Model == "" && req.Channel != "" {
+		req.Model = h.channelDefaultModel(ctx, req.Channel)
+	}
+	if req.Model == "" {
+		apperrors.New("invalid_request_error", "model is required", http.StatusBadRequest).WriteResponse(w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.previewRoute(ctx, req, key).routePreview)
+}
+
+// previewKey returns the API key a preview runs as, or nil if there is none
+// with that ID.
+func (h *Handler) previewKey(ctx context.Context, id int64) *store.ApiKey {
+	if h.loadBalancer == nil || h.loadBalancer.Store == nil {
+		return nil
+	}
+	key, err := h.loadBalancer.Store.GetApiKeyByID(ctx, id)
+	if err != nil {
+		return nil
+	}
+	return key
+}
+
+// previewRoute mirrors the routing of messagesPipeline.route, selectAccount
+// and build for req without their side effects.
+func (h *Handler) previewRoute(ctx context.Context, req routePreviewRequest, key *store.ApiKey) *routeDecision {
+	d := &routeDecision{routePreview: routePreview{Model: req.Model, Channel: req.Channel, ChannelSource: "route", SystemPrompts: []string{}}}
+	out := &d.routePreview
+	if keySystemPrompt(key) != "" {
+		out.SystemPrompts = append(out.SystemPrompts, "api_key")
+	}
+	if _, err := h.keyTenant(ctx, key); err != nil {
+		out.Error = err.Error()
+		return d
+	}
+	out.TenantID = keyTenantID(key)
+	ctx = store.WithTenant(ctx, out.TenantID)
+
+	if e := h.experimentFor(ctx, out.Model); e != nil {
+		variant, sticky := 0, false
+		if req.ConversationID != "" && h.sessionStore != nil {
+			if v, ok := h.sessionStore.GetExperimentVariant(ctx, req.ConversationID, e.ID); ok && (v == 0 || v == 1) {
+				variant, sticky = v, true
+			}
+		}
+		a := experimentAssignment{experiment: e, variant: variant}
+		out.Experiment = &routePreviewExperiment{Name: e.Name, Variant: a.variantName(), Sticky: sticky}
+		v := &e.Variants[variant]
+		d.variant = v
+		if v.SystemPrompt != "" {
+			out.SystemPrompts = append(out.SystemPrompts, "experiment")
+		}
+		if v.Model != "" {
+			out.Model = v.Model
+		}
+		if v.Channel != "" && out.Channel == "" {
+			out.Channel, out.ChannelSource = v.Channel, "experiment"
+		}
+	}
+	if err := h.validateModelAvailability(ctx, out.Model, out.Channel); err != nil {
+		if h.strictModels(key) && err.Error() == "model not found" {
+			out.Error = modelNotFoundMessage(out.Model)
+		} else {
+			out.Error = err.Error()
+		}
+		return d
+	}
+	if out.Channel == "" && h.loadBalancer != nil {
+		if ch := h.loadBalancer.GetModelChannel(ctx, out.Model); ch != "" {
+			out.Channel, out.ChannelSource = ch, "model"
+		}
+	}
+	if out.ChannelSource == "route" || out.ChannelSource == "experiment" {
+		d.forcedChannel = out.Channel
+	}
+
+	account, err := h.previewAccount(ctx, out.Model, out.Channel, d.forcedChannel, out)
+	if err != nil {
+		out.Error = err.Error()
+		return d
+	}
+	d.account = account
+	if account != nil {
+		channel := loadbalancer.AccountChannel(account)
+		out.Account = &routePreviewAccount{ID: account.ID, Name: account.Name, Channel: channel}
+		out.Channel = channel
+	} else {
+		out.DefaultClient = true
+	}
+	if out.Channel == "" {
+		out.Channel, out.ChannelSource = "orchids", "default"
+	}
+
+	out.ClientType = h.channelClientType(ctx, out.Channel)
+	out.MappedModel = mapModel(out.Model)
+	out.PromptTemplate = "aiclient"
+	if account != nil && passthroughModelChannel(h.channelClientType(ctx, account.AccountType)) {
+		out.MappedModel = out.Model
+		out.PromptTemplate = "messages"
+	}
+	out.ModelSubstitution, _ = modelSubstitution(out.Model, out.MappedModel)
+	if out.ModelSubstitution == "fallback" && h.strictModels(key) {
+		out.Error = modelNotFoundMessage(out.Model)
+	}
+	return d
+}
+
+// previewAccount picks the account acquireAccount would start with, skipping
+// the accounts at their caps, TPM limit or channel budget like it does, and
+// records the skipped ones in out. It returns nil for the default client.
+func (h *Handler) previewAccount(ctx context.Context, model, channel, forcedChannel string, out *routePreview) (*store.Account, error) {
+	if h.loadBalancer == nil {
+		if h.client != nil {
+			return nil, nil
+		}
+		return nil, errors.New("no client configured")
+	}
+	var excluded []int64
+	for {
+		account, err := h.loadBalancer.PreviewAccountForModel(ctx, excluded, channel, model)
+		if err != nil {
+			// Like acquireAccount: throttled accounts are queued for, capped
+			// ones are given up on.
+			for _, s := range out.Skipped {
+				if s.Reason == "tpm_limit" || s.Reason == "channel_budget" {
+					return nil, errConcurrencyLimited
+				}
+			}
+			if len(out.Skipped) > 0 {
+				return nil, errAccountsCapped
+			}
+			if forcedChannel != "" || errors.Is(err, loadbalancer.ErrAccountsBusy) || errors.Is(err, loadbalancer.ErrChannelDisabled) || h.client == nil {
+				return nil, err
+			}
+			return nil, nil
+		}
+		reason := h.accountCapReached(ctx, account)
+		switch {
+		case reason != "":
+		case !h.tpm.Allow(tpmAccount(account.ID), accountTPMLimit(account, h.config)):
+			reason = "tpm_limit"
+		case !h.channelBudgetAllows(ctx, account):
+			reason = "channel_budget"
+		default:
+			return account, nil
+		}
+		out.Skipped = append(out.Skipped, routePreviewAccount{
+			ID:      account.ID,
+			Name:    account.Name,
+			Channel: loadbalancer.AccountChannel(account),
+			Reason:  reason,
+		})
+		excluded = append(excluded, account.ID)
+	}
+}
@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/goccy/go-json"
+
+	"orchids-api/internal/config"
+	"orchids-api/internal/loadbalancer"
+	"orchids-api/internal/store"
+)
+
+func TestHandleRoutePreview(t *testing.T) {
+	mr := miniredis.RunT(t)
+	s, err := store.New(store.Options{RedisAddr: mr.Addr(), RedisPrefix: "test:"})
+	if err != nil {
+		t.Fatalf("store.New: %v", err)
+	}
+	ctx := context.Background()
+	throttled := &store.Account{Name: "busy", AccountType: "orchids", Enabled: true, TPMLimit: 10}
+	free := &store.Account{Name: "free", AccountType: "orchids", Enabled: true}
+	for _, acc := range []*store.Account{throttled, free} {
+		if err := s.CreateAccount(ctx, acc); err != nil {
+			t.Fatalf("CreateAccount: %v", err)
+		}
+	}
+	key := &store.ApiKey{Name: "ci", Enabled: true, SystemPrompt: "Be brief."}
+	if err := s.CreateApiKey(ctx, key); err != nil {
+		t.Fatalf("CreateApiKey: %v", err)
+	}
+	h := NewWithLoadBalancer(&config.Config{}, loadbalancer.NewWithCacheTTL(s, 0))
+	h.tpm.Record(tpmAccount(throttled.ID), 100)
+	// The idle throttled account is the load balancer's first pick.
+	h.loadBalancer.AcquireConnection(free.ID)
+
+	preview := func(body string) (int, routePreview) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h.HandleRoutePreview(rec, httptest.NewRequest(http.MethodPost, "/api/route/preview", strings.NewReader(body)))
+		var out routePreview
+		if rec.Code == http.StatusOK {
+			if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+		}
+		return rec.Code, out
+	}
+
+	code, out := preview(`{"model":"claude-sonnet-4-5","key_id":` + strconv.FormatInt(key.ID, 10) + `}`)
+	if code != http.StatusOK || out.Error != "" {
+		t.Fatalf("code=%d out=%+v", code, out)
+	}
+	if out.Account == nil || out.Account.ID != free.ID || out.Channel != "orchids" || out.ChannelSource != "model" {
+		t.Fatalf("account=%+v channel=%q source=%q", out.Account, out.Channel, out.ChannelSource)
+	}
+	if len(out.Skipped) != 1 || out.Skipped[0].ID != throttled.ID || out.Skipped[0].Reason != "tpm_limit" {
+		t.Fatalf("skipped=%+v", out.Skipped)
+	}
+	if out.PromptTemplate != "aiclient" || out.MappedModel == "" || len(out.SystemPrompts) != 1 || out.SystemPrompts[0] != "api_key" {
+		t.Fatalf("template=%q mapped=%q system=%v", out.PromptTemplate, out.MappedModel, out.SystemPrompts)
+	}
+	if acc, _ := s.GetAccount(ctx, free.ID); acc.RequestCount != 0 {
+		t.Fatalf("preview counted %d requests", acc.RequestCount)
+	}
+
+	// A channel route without accounts fails like the real request would.
+	if code, out = preview(`{"channel":"warp"}`); code != http.StatusOK || out.Model != "auto" || out.Error == "" {
+		t.Fatalf("warp: code=%d out=%+v", code, out)
+	}
+	if _, out = preview(`{"model":"no-such-model"}`); out.Error != "model not found" {
+		t.Fatalf("unknown model error=%q", out.Error)
+	}
+	if code, _ = preview(`{"model":"claude-sonnet-4-5","key_id":999}`); code != http.StatusNotFound {
+		t.Fatalf("unknown key code=%d", code)
+	}
+	if code, _ = preview(`{}`); code != http.StatusBadRequest {
+		t.Fatalf("missing model code=%d", code)
+	}
+}
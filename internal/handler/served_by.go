@@ -0,0 +1,56 @@
+package handler
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+
+	"orchids-api/internal/store"
+)
+
+// Response headers naming the channel and account that served a request, see
+// served_by_headers.
+const (
+	servedByChannelHeader = "X-Served-By-Channel"
+	servedByAccountHeader = "X-Served-By-Account"
+)
+
+// servedByAccount identifies account the way served_by_headers mode exposes
+// it: its name, or a short HMAC of its ID that operators can match against
+// the served_by_account field of the audit log. It returns "" with the
+// headers off or without an account.
+func servedByAccount(mode, salt string, account *store.Account) string {
+	if account == nil {
+		return ""
+	}
+	switch mode {
+	case "name":
+		return account.Name
+	case "hash":
+		mac := hmac.New(sha256.New, []byte(salt))
+		mac.Write([]byte(strconv.FormatInt(account.ID, 10)))
+		return hex.EncodeToString(mac.Sum(nil))[:12]
+	}
+	return ""
+}
+
+// setServedByHeaders names the current account and its channel. Streams send
+// their headers before upstream answers, so they report the first account;
+// non-stream responses set them again with the account that finished.
+func (p *messagesPipeline) setServedByHeaders() {
+	cfg := p.h.config
+	mode := cfg.ServedByMode()
+	if mode == "" {
+		return
+	}
+	header := p.w.Header()
+	if channel := p.toolChannel(); channel != "" {
+		header.Set(servedByChannelHeader, channel)
+	}
+	if account := servedByAccount(mode, cfg.ServedBySalt, p.currentAccount); account != "" {
+		header.Set(servedByAccountHeader, account)
+	} else {
+		header.Del(servedByAccountHeader)
+	}
+}
@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"orchids-api/internal/config"
+	"orchids-api/internal/store"
+)
+
+func TestServedByHeaders(t *testing.T) {
+	t.Parallel()
+
+	account := &store.Account{ID: 42, Name: "warp-pool-3", AccountType: "warp"}
+	hashed := servedByAccount("hash", "s3cret", account)
+	if len(hashed) != 12 || hashed == servedByAccount("hash", "other", account) {
+		t.Fatalf("hash = %q, want 12 hex digits that depend on the salt", hashed)
+	}
+	if hashed != servedByAccount("hash", "s3cret", &store.Account{ID: 42}) {
+		t.Fatal("hash must only depend on the account ID")
+	}
+
+	tests := []struct {
+		mode        string
+		wantChannel string
+		wantAccount string
+	}{
+		{mode: "", wantChannel: "", wantAccount: ""},
+		{mode: "bogus", wantChannel: "", wantAccount: ""},
+		{mode: "name", wantChannel: "warp", wantAccount: "warp-pool-3"},
+		{mode: " Hash ", wantChannel: "warp", wantAccount: hashed},
+	}
+	for _, tc := range tests {
+		rec := httptest.NewRecorder()
+		p := &messagesPipeline{
+			h:              &Handler{config: &config.Config{ServedByHeaders: tc.mode, ServedBySalt: "s3cret"}},
+			w:              rec,
+			currentAccount: account,
+		}
+		p.setServedByHeaders()
+		if got := rec.Header().Get(servedByChannelHeader); got != tc.wantChannel {
+			t.Errorf("mode %q: channel = %q, want %q", tc.mode, got, tc.wantChannel)
+		}
+		if got := rec.Header().Get(servedByAccountHeader); got != tc.wantAccount {
+			t.Errorf("mode %q: account = %q, want %q", tc.mode, got, tc.wantAccount)
+		}
+	}
+
+	// An unkeyed hash of the account ID is reversible, so hash mode needs a salt.
+	rec := httptest.NewRecorder()
+	p := &messagesPipeline{
+		h:              &Handler{config: &config.Config{ServedByHeaders: "hash"}},
+		w:              rec,
+		currentAccount: account,
+	}
+	p.setServedByHeaders()
+	if len(rec.Header()) != 0 {
+		t.Fatalf("headers without salt = %v", rec.Header())
+	}
+
+	// The default upstream config has no account to name.
+	rec = httptest.NewRecorder()
+	p = &messagesPipeline{
+		h:             &Handler{config: &config.Config{ServedByHeaders: "name"}},
+		w:             rec,
+		forcedChannel: "orchids",
+	}
+	p.setServedByHeaders()
+	if rec.Header().Get(servedByChannelHeader) != "orchids" || rec.Header().Get(servedByAccountHeader) != "" {
+		t.Fatalf("headers without account = %v", rec.Header())
+	}
+}
@@ -0,0 +1,249 @@
+package handler
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-json"
+
+	"orchids-api/internal/perf"
+	"orchids-api/internal/prompt"
+	"orchids-api/internal/upstream"
+)
+
+// maxServerToolRounds bounds the follow-up rounds a request may spend on
+// server tool calls.
+const maxServerToolRounds = 8
+
+// transcriptResultChars caps each tool result kept in the transcript.
+const transcriptResultChars = 2000
+
+// serverTool is an Anthropic server tool (web_search, code_execution) that the
+// gateway runs itself when the serving channel cannot. Upstream sees it as an
+// ordinary client tool; the client sees server_tool_use and result blocks.
+type serverTool interface {
+	// serverName is the tool name shown to the client, e.g. "web_search".
+	serverName() string
+	// definition is the client tool advertised upstream.
+	definition() map[string]interface{}
+	// run executes one call with the tool input upstream produced.
+	run(ctx context.Context, input string) serverToolOutcome
+}
+
+// serverToolOutcome is the result of one server tool call.
+type serverToolOutcome struct {
+	input   map[string]interface{} // echoed in the server_tool_use block
+	result  map[string]interface{} // client result block, without tool_use_id
+	text    string                 // tool result handed back to upstream
+	isError bool
+}
+
+// newServerTool returns the executor for a server tool definition, or nil
+// when the gateway cannot run that tool type.
+func (h *Handler) newServerTool(serverType string, spec map[string]interface{}) serverTool {
+	switch serverType {
+	case "web_search":
+		if ws := h.newWebSearchTool(spec); ws != nil {
+			return ws
+		}
+	case "code_execution":
+		if ce := h.newCodeExecutionTool(); ce != nil {
+			return ce
+		}
+	}
+	return nil
+}
+
+// extractServerTools takes the server tools the channel cannot run itself
+// out of tools and returns the executors that replace them, keyed by the
+// lower-cased name advertised upstream.
+func (h *Handler) extractServerTools(tools []interface{}, caps toolCapabilities) ([]interface{}, map[string]serverTool) {
+	var executors map[string]serverTool
+	kept := make([]interface{}, 0, len(tools))
+	for _, tool := range tools {
+		_, serverType := toolIdentity(tool)
+		if serverType != "" && !caps.has(serverType) {
+			spec, _ := tool.(map[string]interface{})
+			if st := h.newServerTool(serverType, spec); st != nil {
+				name, _ := st.definition()["name"].(string)
+				if executors == nil {
+					executors = make(map[string]serverTool)
+				}
+				if _, dup := executors[strings.ToLower(name)]; !dup {
+					executors[strings.ToLower(name)] = st
+				}
+				continue
+			}
+		}
+		kept = append(kept, tool)
+	}
+	return kept, executors
+}
+
+// serverToolDefinitions returns the upstream definitions in a stable order.
+func serverToolDefinitions(executors map[string]serverTool) []interface{} {
+	names := make([]string, 0, len(executors))
+	for name := range executors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	defs := make([]interface{}, 0, len(names))
+	for _, name := range names {
+		defs = append(defs, executors[name].definition())
+	}
+	return defs
+}
+
+// recordToolRound adds one gateway-executed tool call to the response
+// transcript.
+func (h *streamHandler) recordToolRound(round int, tool string, out serverToolOutcome, took time.Duration) {
+	entry := map[string]interface{}{
+		"round":       round,
+		"tool":        tool,
+		"input":       out.input,
+		"result":      truncateWarpTextWithEllipsis(out.text, transcriptResultChars),
+		"is_error":    out.isError,
+		"duration_ms": took.Milliseconds(),
+	}
+	h.mu.Lock()
+	h.toolTranscript = append(h.toolTranscript, entry)
+	h.mu.Unlock()
+}
+
+// interceptServerTool holds back calls to a built-in server tool so the
+// pipeline can run them instead of forwarding them to the client.
+func (h *streamHandler) interceptServerTool(call toolCall) bool {
+	if _, ok := h.serverTools[strings.ToLower(call.name)]; !ok {
+		return false
+	}
+	h.mu.Lock()
+	h.serverToolCalls = append(h.serverToolCalls, call)
+	h.mu.Unlock()
+	return true
+}
+
+// takeServerToolCalls returns the server tool calls of the last round and the
+// text produced before them.
+func (h *streamHandler) takeServerToolCalls() ([]toolCall, string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	calls := h.serverToolCalls
+	h.serverToolCalls = nil
+	if len(calls) == 0 {
+		return nil, ""
+	}
+	return calls, h.roundTextLocked()
+}
+
+// hasClientToolCalls reports whether the response carries tool calls the
+// client has to answer.
+func (h *streamHandler) hasClientToolCalls() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.toolCallCount > 0 || len(h.pendingToolCalls) > 0 || len(h.toolCallEmitted) > 0
+}
+
+// emitServerBlock writes a complete server tool block (server_tool_use or a
+// tool result). The block is sent whole in content_block_start.
+func (h *streamHandler) emitServerBlock(block map[string]interface{}) {
+	h.closeActiveBlock()
+	if !h.isStream {
+		h.mu.Lock()
+		h.contentBlocks = append(h.contentBlocks, block)
+		h.mu.Unlock()
+		return
+	}
+
+	h.mu.Lock()
+	h.blockIndex++
+	idx := h.blockIndex
+	h.mu.Unlock()
+
+	startMap := perf.AcquireMap()
+	startMap["type"] = "content_block_start"
+	startMap["index"] = idx
+	startMap["content_block"] = block
+	startData, _ := json.Marshal(startMap)
+	perf.ReleaseMap(startMap)
+	h.writeSSE("content_block_start", string(startData))
+
+	stopMap := perf.AcquireMap()
+	stopMap["type"] = "content_block_stop"
+	stopMap["index"] = idx
+	stopData, _ := json.Marshal(stopMap)
+	perf.ReleaseMap(stopMap)
+	h.writeSSE("content_block_stop", string(stopData))
+}
+
+// runServerTools executes the server tool calls of the last round, shows the
+// calls and results to the client, and prepares a follow-up round that hands
+// the results to upstream. It returns false when there is nothing to run or
+// the response has to end here.
+func (p *messagesPipeline) runServerTools(upstreamReq *upstream.UpstreamRequest) bool {
+	if len(p.serverTools) == 0 {
+		return false
+	}
+	calls, text := p.sh.takeServerToolCalls()
+	if len(calls) == 0 {
+		return false
+	}
+	p.serverToolRounds++
+
+	var assistant, results []prompt.ContentBlock
+	if text != "" {
+		assistant = append(assistant, prompt.ContentBlock{Type: "text", Text: text})
+	}
+	for _, call := range calls {
+		st := p.serverTools[strings.ToLower(call.name)]
+		started := time.Now()
+		out := st.run(p.ctx, call.input)
+		if p.h.config.ToolTranscript {
+			p.sh.recordToolRound(p.serverToolRounds, st.serverName(), out, time.Since(started))
+		}
+
+		id := "srvtoolu_" + randomSessionID()
+		p.sh.addOutputTokens(call.input)
+		p.sh.emitServerBlock(map[string]interface{}{
+			"type":  "server_tool_use",
+			"id":    id,
+			"name":  st.serverName(),
+			"input": out.input,
+		})
+		out.result["tool_use_id"] = id
+		p.sh.emitServerBlock(out.result)
+
+		assistant = append(assistant, prompt.ContentBlock{
+			Type:  "tool_use",
+			ID:    call.id,
+			Name:  call.name,
+			Input: out.input,
+		})
+		results = append(results, prompt.ContentBlock{
+			Type:      "tool_result",
+			ToolUseID: call.id,
+			Content:   out.text,
+			IsError:   out.isError,
+		})
+	}
+
+	// Client tool calls in the same turn end it; the client answers them and
+	// the server tool results travel back in the conversation history.
+	if p.sh.hasClientToolCalls() {
+		p.sh.finishResponse("tool_use")
+		return false
+	}
+	if p.serverToolRounds > maxServerToolRounds {
+		slog.Warn("Server tool round limit reached, ending response", "rounds", p.serverToolRounds)
+		return false
+	}
+
+	p.upstreamMessages = append(p.upstreamMessages,
+		prompt.Message{Role: "assistant", Content: prompt.MessageContent{Blocks: assistant}},
+		prompt.Message{Role: "user", Content: prompt.MessageContent{Blocks: results}},
+	)
+	p.rebuildPrompt(upstreamReq)
+	return true
+}
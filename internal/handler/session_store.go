@@ -2,6 +2,9 @@ package handler
 
 import (
 	"context"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -14,6 +17,14 @@ type SessionStore interface {
 	SetWorkdir(ctx context.Context, key, workdir string)
 	GetConvID(ctx context.Context, key string) (string, bool)
 	SetConvID(ctx context.Context, key, convID string)
+	// GetExperimentVariant returns the A/B variant (0 or 1) the session was
+	// assigned in experiment id.
+	GetExperimentVariant(ctx context.Context, key string, id int64) (int, bool)
+	SetExperimentVariant(ctx context.Context, key string, id int64, variant int)
+	// GetSystemRefs returns the references of the system blocks upstream
+	// conversation convID has received; SetSystemRefs replaces them.
+	GetSystemRefs(ctx context.Context, key, convID string) []string
+	SetSystemRefs(ctx context.Context, key, convID string, refs []string)
 	DeleteSession(ctx context.Context, key string)
 	// Touch refreshes the session TTL. For Redis this issues EXPIRE; for memory it updates lastAccess.
 	Touch(ctx context.Context, key string)
@@ -76,6 +87,50 @@ func (s *RedisSessionStore) SetConvID(_ context.Context, key, convID string) {
 	pipe.Exec(ctx)
 }
 
+func experimentField(id int64) string {
+	return "exp:" + strconv.FormatInt(id, 10)
+}
+
+func (s *RedisSessionStore) GetExperimentVariant(_ context.Context, key string, id int64) (int, bool) {
+	ctx := context.Background()
+	val, err := s.client.HGet(ctx, s.key(key), experimentField(id)).Int()
+	if err != nil {
+		return 0, false
+	}
+	return val, true
+}
+
+func (s *RedisSessionStore) SetExperimentVariant(_ context.Context, key string, id int64, variant int) {
+	ctx := context.Background()
+	pipe := s.client.Pipeline()
+	pipe.HSet(ctx, s.key(key), experimentField(id), variant)
+	pipe.Expire(ctx, s.key(key), s.ttl)
+	pipe.Exec(ctx)
+}
+
+// System refs are stored as "<convID>|<ref>,<ref>,..." so a new upstream
+// conversation starts without any.
+func (s *RedisSessionStore) GetSystemRefs(_ context.Context, key, convID string) []string {
+	ctx := context.Background()
+	val, err := s.client.HGet(ctx, s.key(key), "sys_refs").Result()
+	if err != nil {
+		return nil
+	}
+	id, refs, ok := strings.Cut(val, "|")
+	if !ok || id != convID || refs == "" {
+		return nil
+	}
+	return strings.Split(refs, ",")
+}
+
+func (s *RedisSessionStore) SetSystemRefs(_ context.Context, key, convID string, refs []string) {
+	ctx := context.Background()
+	pipe := s.client.Pipeline()
+	pipe.HSet(ctx, s.key(key), "sys_refs", convID+"|"+strings.Join(refs, ","))
+	pipe.Expire(ctx, s.key(key), s.ttl)
+	pipe.Exec(ctx)
+}
+
 func (s *RedisSessionStore) DeleteSession(_ context.Context, key string) {
 	ctx := context.Background()
 	s.client.Del(ctx, s.key(key))
@@ -95,6 +150,9 @@ func (s *RedisSessionStore) Cleanup(_ context.Context) {
 type memorySession struct {
 	workdir    string
 	convID     string
+	variants   map[int64]int
+	sysConvID  string // upstream conversation sysRefs belong to
+	sysRefs    []string
 	lastAccess time.Time
 }
 
@@ -159,6 +217,47 @@ func (s *MemorySessionStore) SetConvID(_ context.Context, key, convID string) {
 	sess.lastAccess = time.Now()
 }
 
+func (s *MemorySessionStore) GetExperimentVariant(_ context.Context, key string, id int64) (int, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	sess, ok := s.sessions[key]
+	if !ok {
+		return 0, false
+	}
+	variant, ok := sess.variants[id]
+	return variant, ok
+}
+
+func (s *MemorySessionStore) SetExperimentVariant(_ context.Context, key string, id int64, variant int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess := s.getOrCreate(key)
+	if sess.variants == nil {
+		sess.variants = make(map[int64]int)
+	}
+	sess.variants[id] = variant
+	sess.lastAccess = time.Now()
+}
+
+func (s *MemorySessionStore) GetSystemRefs(_ context.Context, key, convID string) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	sess, ok := s.sessions[key]
+	if !ok || sess.sysConvID != convID {
+		return nil
+	}
+	return slices.Clone(sess.sysRefs)
+}
+
+func (s *MemorySessionStore) SetSystemRefs(_ context.Context, key, convID string, refs []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess := s.getOrCreate(key)
+	sess.sysConvID = convID
+	sess.sysRefs = slices.Clone(refs)
+	sess.lastAccess = time.Now()
+}
+
 func (s *MemorySessionStore) DeleteSession(_ context.Context, key string) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -139,3 +139,24 @@ func TestMemorySessionStoreCleanup(t *testing.T) {
 		t.Fatal("session should have been cleaned up")
 	}
 }
+
+func TestSessionStoreSystemRefs(t *testing.T) {
+	redisStore, _ := setupRedisSessionStore(t)
+	stores := map[string]SessionStore{
+		"redis":  redisStore,
+		"memory": NewMemorySessionStore(time.Minute, 10),
+	}
+	ctx := context.Background()
+	for name, store := range stores {
+		if refs := store.GetSystemRefs(ctx, "session1", "conv_a"); refs != nil {
+			t.Fatalf("%s: expected no refs, got %v", name, refs)
+		}
+		store.SetSystemRefs(ctx, "session1", "conv_a", []string{"abc", "def"})
+		if refs := store.GetSystemRefs(ctx, "session1", "conv_a"); len(refs) != 2 || refs[0] != "abc" || refs[1] != "def" {
+			t.Fatalf("%s: refs=%v", name, refs)
+		}
+		if refs := store.GetSystemRefs(ctx, "session1", "conv_b"); refs != nil {
+			t.Fatalf("%s: refs of another conversation: %v", name, refs)
+		}
+	}
+}
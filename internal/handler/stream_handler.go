@@ -13,6 +13,8 @@ import (
 	"orchids-api/internal/adapter"
 	"orchids-api/internal/config"
 	"orchids-api/internal/debug"
+	"orchids-api/internal/hooks"
+	"orchids-api/internal/metrics"
 	"orchids-api/internal/orchids"
 	"orchids-api/internal/perf"
 	"orchids-api/internal/prompt"
@@ -41,10 +43,30 @@ type streamHandler struct {
 	useUpstreamUsage bool
 	outputTokenMode  string
 	responseFormat   adapter.ResponseFormat
+	encoder          adapter.StreamEncoder
 
 	// HTTP Response
-	w       http.ResponseWriter
-	flusher http.Flusher
+	w            http.ResponseWriter
+	flusher      http.Flusher
+	rc           *http.ResponseController
+	writeTimeout time.Duration // per-write deadline, re-armed before every frame
+	stallLimit   time.Duration // time writes may block on the client in one stall (0 = unlimited)
+	stalled      time.Duration // time spent in slow writes since the last fast one
+
+	// Flush coalescing (stream_flush_interval_ms / stream_flush_bytes): bytes
+	// written since the last flush and a timer that flushes them at the end
+	// of the window.
+	flushInterval time.Duration
+	flushBytes    int
+	unflushed     int
+	lastFlush     time.Time
+	flushTimer    *time.Timer
+	closed        bool
+
+	// clientAbort is why the stream gave up on the client ("write_error" or
+	// "stall_limit"); onClientAbort cancels the upstream call.
+	clientAbort   string
+	onClientAbort func()
 
 	// State
 	mu                       sync.Mutex
@@ -54,7 +76,9 @@ type streamHandler struct {
 	startTime                time.Time
 	hasReturn                bool
 	finalStopReason          string
+	stopSequence             string // matched stop sequence when finalStopReason is "stop_sequence"
 	outputTokens             int
+	reasoningTokens          int // the part of outputTokens spent on thinking
 	inputTokens              int
 	activeThinkingBlockIndex int
 	activeThinkingSSEIndex   int
@@ -64,7 +88,8 @@ type streamHandler struct {
 
 	// Buffers and Builders
 	responseText          *strings.Builder
-	outputBuilder         *strings.Builder
+	outputEstimator       tiktoken.Estimator
+	reasoningEstimator    tiktoken.Estimator
 	writeChunkBuffer      *strings.Builder
 	textBlockBuilders     map[int]*strings.Builder
 	thinkingBlockBuilders map[int]*strings.Builder
@@ -90,12 +115,29 @@ type streamHandler struct {
 	toolDedupKeys      map[string]int
 	introDedup         map[string]struct{}
 
+	// Continuation of truncated upstream output (max_continuations)
+	continuationsLeft     int
+	truncated             bool
+	continuedTextLen      int
+	continuedOutputTokens int
+
+	// Built-in server tools the pipeline executes itself, by upstream name
+	serverTools     map[string]struct{}
+	serverToolCalls []toolCall
+	toolTranscript  []map[string]interface{}
+
+	// Conversion warnings of the request, reported on message_stop
+	warnings *conversionWarnings
+
 	// Throttling
 	lastScanTime time.Time
 
 	// Callbacks
 	onConversationID func(string) // 上游返回 conversationID 时回调
 
+	// Output transformation (hooks / redaction); nil when not configured
+	outputFilter hooks.OutputFilter
+
 	// Logger
 	logger *debug.Logger
 }
@@ -126,6 +168,11 @@ func newStreamHandler(
 		workdir:          workdir,
 		w:                w,
 		flusher:          flusher,
+		rc:               http.NewResponseController(w),
+		writeTimeout:     time.Duration(cfg.StreamWriteTimeout) * time.Second,
+		stallLimit:       time.Duration(cfg.MaxClientStall) * time.Second,
+		flushInterval:    time.Duration(cfg.StreamFlushIntervalMs) * time.Millisecond,
+		flushBytes:       cfg.StreamFlushBytes,
 		isStream:         isStream,
 		logger:           logger,
 		suppressThinking: suppressThinking,
@@ -135,7 +182,6 @@ func newStreamHandler(
 		blockIndex:               -1,
 		toolBlocks:               make(map[string]int),
 		responseText:             perf.AcquireStringBuilder(),
-		outputBuilder:            perf.AcquireStringBuilder(),
 		writeChunkBuffer:         perf.AcquireStringBuilder(),
 		textBlockBuilders:        make(map[int]*strings.Builder),
 		thinkingBlockBuilders:    make(map[int]*strings.Builder),
@@ -149,7 +195,7 @@ func newStreamHandler(
 		seedToolDedup:            make(map[string]struct{}),
 		toolDedupKeys:            make(map[string]int),
 		introDedup:               make(map[string]struct{}),
-		msgID:                    fmt.Sprintf("msg_%d", time.Now().UnixMilli()),
+		msgID:                    "msg_" + randomSessionID() + randomSessionID(),
 		startTime:                time.Now(),
 		currentTextIndex:         -1,
 		activeThinkingBlockIndex: -1,
@@ -158,12 +204,19 @@ func newStreamHandler(
 		activeTextSSEIndex:       -1,
 		activeBlockType:          "",
 	}
+	h.encoder = adapter.NewStreamEncoder(responseFormat, h.msgID, h.startTime.Unix())
 	return h
 }
 
 func (h *streamHandler) release() {
+	h.mu.Lock()
+	h.closed = true
+	if h.flushTimer != nil {
+		h.flushTimer.Stop()
+		h.flushTimer = nil
+	}
+	h.mu.Unlock()
 	perf.ReleaseStringBuilder(h.responseText)
-	perf.ReleaseStringBuilder(h.outputBuilder)
 	perf.ReleaseStringBuilder(h.writeChunkBuffer)
 	for _, sb := range h.textBlockBuilders {
 		perf.ReleaseStringBuilder(sb)
@@ -185,72 +238,168 @@ func (h *streamHandler) writeSSE(event, data string) {
 	if h.hasReturn {
 		return
 	}
-	if h.responseFormat == adapter.FormatOpenAI {
-		if err := h.writeOpenAISSE(event, data); err != nil {
-			h.markWriteErrorLocked(event, err)
-		}
+	if h.writeEncodedLocked(event, []byte(data)) {
+		h.logger.LogOutputSSE(event, data)
+	}
+}
+
+// writeSSEBytes is the allocation-light variant of writeSSE for hot events:
+// data is written straight from the caller's (pooled) buffer and only
+// converted to a string when output SSE logging is on.
+func (h *streamHandler) writeSSEBytes(event string, data []byte) {
+	if !h.isStream {
 		return
 	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.hasReturn {
+		return
+	}
+	if h.writeEncodedLocked(event, data) && h.logger.OutputSSEEnabled() {
+		h.logger.LogOutputSSE(event, string(data))
+	}
+}
+
+// writeEncodedLocked renders one internal event through the negotiated
+// encoder and flushes it. It reports whether a frame was written.
+func (h *streamHandler) writeEncodedLocked(event string, data []byte) bool {
+	frame := perf.AcquireByteBuffer()
+	defer perf.ReleaseByteBuffer(frame)
+	if !h.encoder.Encode(frame, event, data) {
+		return false
+	}
+	return h.writeFrameLocked(event, frame.Bytes())
+}
 
-	if _, err := fmt.Fprintf(h.w, "event: %s\ndata: %s\n\n", event, data); err != nil {
+// writeFrameLocked writes one frame under the write deadline, flushes it now
+// or within the coalescing window, and charges the time it took to the stall
+// budget. A failed write, or one that uses up the budget, aborts the stream.
+func (h *streamHandler) writeFrameLocked(event string, frame []byte) bool {
+	h.armWriteDeadline()
+	start := time.Now()
+	_, err := h.w.Write(frame)
+	if err == nil {
+		h.unflushed += len(frame)
+		if h.flushDueLocked(event) {
+			h.flushLocked()
+		} else {
+			h.scheduleFlushLocked()
+		}
+	}
+	h.chargeStallLocked(time.Since(start))
+	if err != nil {
 		h.markWriteErrorLocked(event, err)
-		return
+		return false
 	}
-	if h.flusher != nil {
-		h.flusher.Flush()
+	if h.stallLimit > 0 && h.stalled >= h.stallLimit && !h.hasReturn {
+		h.abortClientLocked("stall_limit", event, nil)
 	}
+	return true
+}
 
-	h.logger.LogOutputSSE(event, data)
+// immediateFlushEvents are flushed at once even when coalescing is on: they
+// open or close the response, or keep an idle connection alive.
+var immediateFlushEvents = map[string]bool{
+	"keep-alive":    true,
+	"message_start": true,
+	"message_delta": true,
+	"message_stop":  true,
+	"error":         true,
 }
 
-func (h *streamHandler) writeOpenAISSE(event, data string) error {
-	bytes, ok := adapter.BuildOpenAIChunk(h.msgID, h.startTime.Unix(), event, []byte(data))
-	if !ok {
-		return nil
+// flushDueLocked reports whether the frame just written for event must be
+// flushed now rather than coalesced with the ones that follow.
+func (h *streamHandler) flushDueLocked(event string) bool {
+	if h.flushInterval <= 0 || immediateFlushEvents[event] {
+		return true
 	}
-	if _, err := fmt.Fprintf(h.w, "data: %s\n\n", string(bytes)); err != nil {
-		return err
+	if h.flushBytes > 0 && h.unflushed >= h.flushBytes {
+		return true
 	}
+	return time.Since(h.lastFlush) >= h.flushInterval
+}
+
+func (h *streamHandler) flushLocked() {
+	h.unflushed = 0
+	h.lastFlush = time.Now()
 	if h.flusher != nil {
 		h.flusher.Flush()
 	}
-	return nil
 }
 
-func (h *streamHandler) writeFinalSSE(event, data string) {
-	if !h.isStream {
+// scheduleFlushLocked makes sure pending frames are flushed when the current
+// window ends, even if no further frame arrives to trigger it.
+func (h *streamHandler) scheduleFlushLocked() {
+	if h.flushTimer != nil {
 		return
 	}
-	h.mu.Lock()
-	defer h.mu.Unlock()
-
-	if h.responseFormat == adapter.FormatOpenAI {
-		if err := h.writeOpenAISSE(event, data); err != nil {
-			h.markWriteErrorLocked(event, err)
+	h.flushTimer = time.AfterFunc(h.flushInterval-time.Since(h.lastFlush), func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		h.flushTimer = nil
+		if h.closed || h.unflushed == 0 || h.clientAbort != "" {
 			return
 		}
-		// Send [DONE] at the very end
-		if event == "message_stop" {
-			if _, err := fmt.Fprintf(h.w, "data: [DONE]\n\n"); err != nil {
-				h.markWriteErrorLocked(event, err)
-				return
-			}
-			if h.flusher != nil {
-				h.flusher.Flush()
-			}
-		}
+		h.armWriteDeadline()
+		start := time.Now()
+		h.flushLocked()
+		h.chargeStallLocked(time.Since(start))
+	})
+}
+
+// stallWriteThreshold is how long a write may take before it counts as the
+// client blocking; a faster write ends the stall.
+const stallWriteThreshold = 50 * time.Millisecond
+
+// chargeStallLocked adds a write that took d to the current stall, or ends
+// the stall when the client accepted the write promptly, so the stall limit
+// applies to each episode of back-pressure rather than to the sum of every
+// write over a long stream.
+func (h *streamHandler) chargeStallLocked(d time.Duration) {
+	if d < stallWriteThreshold {
+		h.stalled = 0
 		return
 	}
+	h.stalled += d
+}
 
-	if _, err := fmt.Fprintf(h.w, "event: %s\ndata: %s\n\n", event, data); err != nil {
-		h.markWriteErrorLocked(event, err)
+// armWriteDeadline pushes the connection write deadline forward so that a
+// stalled client fails the next write instead of blocking the stream forever,
+// while an active stream of any length keeps going. The deadline never
+// reaches past what is left of the stall budget.
+func (h *streamHandler) armWriteDeadline() {
+	if h.rc == nil {
 		return
 	}
-	if h.flusher != nil {
-		h.flusher.Flush()
+	timeout := h.writeTimeout
+	if h.stallLimit > 0 {
+		left := max(h.stallLimit-h.stalled, time.Millisecond)
+		if timeout <= 0 || left < timeout {
+			timeout = left
+		}
+	}
+	if timeout <= 0 {
+		return
 	}
+	_ = h.rc.SetWriteDeadline(time.Now().Add(timeout))
+}
 
-	h.logger.LogOutputSSE(event, data)
+// clientAborted reports whether the stream gave up on a slow or gone client.
+func (h *streamHandler) clientAborted() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.clientAbort != ""
+}
+
+func (h *streamHandler) writeFinalSSE(event, data string) {
+	if !h.isStream {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.writeEncodedLocked(event, []byte(data)) {
+		h.logger.LogOutputSSE(event, data)
+	}
 }
 
 func (h *streamHandler) writeKeepAlive() {
@@ -262,23 +411,31 @@ func (h *streamHandler) writeKeepAlive() {
 	if h.hasReturn {
 		return
 	}
-	if _, err := fmt.Fprintf(h.w, ": keep-alive\n\n"); err != nil {
-		h.markWriteErrorLocked("keep-alive", err)
+	h.writeFrameLocked("keep-alive", h.encoder.KeepAlive())
+}
+
+func (h *streamHandler) addOutputTokens(text string) {
+	if text == "" {
 		return
 	}
-	if h.flusher != nil {
-		h.flusher.Flush()
+	h.outputMu.Lock()
+	if !h.useUpstreamUsage {
+		h.outputEstimator.WriteString(text)
 	}
+	h.outputMu.Unlock()
 }
 
-func (h *streamHandler) addOutputTokens(text string) {
+// addReasoningTokens counts thinking text towards the output tokens and,
+// separately, the reasoning tokens.
+func (h *streamHandler) addReasoningTokens(text string) {
 	if text == "" {
 		return
 	}
 	h.outputMu.Lock()
 	if !h.useUpstreamUsage {
-		h.outputBuilder.WriteString(text)
+		h.outputEstimator.WriteString(text)
 	}
+	h.reasoningEstimator.WriteString(text)
 	h.outputMu.Unlock()
 }
 
@@ -286,12 +443,12 @@ func (h *streamHandler) finalizeOutputTokens() {
 	h.outputMu.Lock()
 	defer h.outputMu.Unlock()
 
-	if h.useUpstreamUsage {
-		return
+	if !h.useUpstreamUsage {
+		h.outputTokens = h.outputEstimator.Tokens()
 	}
-
-	text := h.outputBuilder.String()
-	h.outputTokens = tiktoken.EstimateTextTokens(text)
+	// Upstreams report no reasoning split, so it is always estimated from
+	// the thinking text, within the reported total.
+	h.reasoningTokens = min(h.reasoningEstimator.Tokens(), h.outputTokens)
 }
 
 func (h *streamHandler) setUsageTokens(input, output int) {
@@ -300,12 +457,27 @@ func (h *streamHandler) setUsageTokens(input, output int) {
 		h.inputTokens = input
 	}
 	if output >= 0 {
-		h.outputTokens = output
+		h.outputTokens = h.continuedOutputTokens + output
 		h.useUpstreamUsage = true
 	}
 	h.outputMu.Unlock()
 }
 
+// applyUpstreamUsage records whichever token counts the upstream reported.
+func (h *streamHandler) applyUpstreamUsage(usage upstream.Usage) {
+	if !usage.HasInput && !usage.HasOutput {
+		return
+	}
+	in, out := -1, -1
+	if usage.HasInput {
+		in = usage.InputTokens
+	}
+	if usage.HasOutput {
+		out = usage.OutputTokens
+	}
+	h.setUsageTokens(in, out)
+}
+
 func (h *streamHandler) resetRoundState() {
 	h.mu.Lock()
 	defer h.mu.Unlock()
@@ -358,11 +530,19 @@ func (h *streamHandler) resetRoundState() {
 	h.currentToolInputID = ""
 	h.toolCallCount = 0
 	h.outputTokens = 0
-	h.outputBuilder.Reset()
+	h.outputEstimator.Reset()
+	h.reasoningTokens = 0
+	h.reasoningEstimator.Reset()
 	h.writeChunkBuffer.Reset()
 	h.useUpstreamUsage = false
 	h.finalStopReason = ""
+	h.stopSequence = ""
 	h.hasTextOutput = false
+	h.truncated = false
+	h.continuedTextLen = 0
+	h.continuedOutputTokens = 0
+	h.serverToolCalls = nil
+	h.toolTranscript = nil
 }
 
 func (h *streamHandler) shouldEmitToolCalls(stopReason string) bool {
@@ -669,6 +849,7 @@ func (h *streamHandler) finishResponse(stopReason string) {
 			stopReason = "end_turn"
 		}
 	}
+	h.flushOutputFilter()
 	h.mu.Lock()
 	if h.hasReturn {
 		h.mu.Unlock()
@@ -676,6 +857,7 @@ func (h *streamHandler) finishResponse(stopReason string) {
 	}
 	h.hasReturn = true
 	h.finalStopReason = stopReason
+	stopSequence := h.stopSequence
 	h.mu.Unlock()
 
 	if h.isStream {
@@ -697,10 +879,21 @@ func (h *streamHandler) finishResponse(stopReason string) {
 		deltaMap["type"] = "message_delta"
 		deltaDelta := perf.AcquireMap()
 		deltaDelta["stop_reason"] = stopReason
+		if stopReason == "stop_sequence" && stopSequence != "" {
+			deltaDelta["stop_sequence"] = stopSequence
+		}
 		deltaUsage := perf.AcquireMap()
 		deltaUsage["output_tokens"] = h.outputTokens
+		if h.config != nil && h.config.UsageReasoningTokens {
+			deltaUsage["reasoning_output_tokens"] = h.reasoningTokens
+		}
 		deltaMap["delta"] = deltaDelta
 		deltaMap["usage"] = deltaUsage
+		h.mu.Lock()
+		if len(h.toolTranscript) > 0 {
+			deltaMap["transcript"] = h.toolTranscript
+		}
+		h.mu.Unlock()
 		deltaData, err := json.Marshal(deltaMap)
 		if err != nil {
 			slog.Error("Failed to marshal message_delta", "error", err)
@@ -713,6 +906,9 @@ func (h *streamHandler) finishResponse(stopReason string) {
 
 		stopMap := perf.AcquireMap()
 		stopMap["type"] = "message_stop"
+		if warnings := h.warnings.all(); len(warnings) > 0 {
+			stopMap["conversion_warnings"] = warnings
+		}
 		stopData, err := json.Marshal(stopMap)
 		if err != nil {
 			slog.Error("Failed to marshal message_stop", "error", err)
@@ -747,6 +943,9 @@ func (h *streamHandler) ensureBlock(blockType string) int {
 	if blockType == "thinking" && h.suppressThinking {
 		return -1
 	}
+	if blockType != "text" {
+		h.flushOutputFilter()
+	}
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
@@ -886,19 +1085,9 @@ func (h *streamHandler) writeSSELocked(event, data string) {
 	if h.hasReturn {
 		return
 	}
-	if h.responseFormat == adapter.FormatOpenAI {
-		if err := h.writeOpenAISSE(event, data); err != nil {
-			h.markWriteErrorLocked(event, err)
-		}
-		return
-	}
-	if _, err := fmt.Fprintf(h.w, "event: %s\ndata: %s\n\n", event, data); err != nil {
-		h.markWriteErrorLocked(event, err)
+	if !h.writeEncodedLocked(event, []byte(data)) {
 		return
 	}
-	if h.flusher != nil {
-		h.flusher.Flush()
-	}
 	h.logger.LogOutputSSE(event, data)
 	// Log to slog only when debug enabled
 	if h.config != nil && h.config.DebugEnabled {
@@ -1173,9 +1362,24 @@ func (h *streamHandler) markWriteErrorLocked(event string, err error) {
 	if h.hasReturn {
 		return
 	}
+	reason := "write_error"
+	if h.stallLimit > 0 && h.stalled >= h.stallLimit {
+		reason = "stall_limit"
+	}
+	h.abortClientLocked(reason, event, err)
+}
+
+// abortClientLocked stops writing to the client, records why and cancels the
+// upstream call so the account is not held by a reader that stopped reading.
+func (h *streamHandler) abortClientLocked(reason, event string, err error) {
 	h.hasReturn = true
 	h.finalStopReason = "write_error"
-	slog.Warn("SSE 写入失败，已终止输出", "event", event, "error", err)
+	h.clientAbort = reason
+	metrics.ClientAbortsTotal.WithLabelValues(reason).Inc()
+	slog.Warn("客户端写入失败或阻塞超限，已终止输出", "event", event, "reason", reason, "stalled", h.stalled, "error", err)
+	if h.onClientAbort != nil {
+		h.onClientAbort()
+	}
 }
 
 func (h *streamHandler) forceFinishIfMissing() {
@@ -1187,7 +1391,7 @@ func (h *streamHandler) forceFinishIfMissing() {
 	hasToolCalls := h.toolCallCount > 0 ||
 		len(h.pendingToolCalls) > 0 ||
 		len(h.toolCallEmitted) > 0
-	hasOutput := h.outputBuilder.Len() > 0 || h.responseText.Len() > 0 || len(h.contentBlocks) > 0
+	hasOutput := h.outputEstimator.Len() > 0 || h.responseText.Len() > 0 || len(h.contentBlocks) > 0
 	h.mu.Unlock()
 
 	// 上游无任何有效输出时，注入空响应提示避免客户端收到完全空的回复
@@ -1241,7 +1445,7 @@ func (h *streamHandler) hasAnyOutput() bool {
 	}
 
 	h.outputMu.Lock()
-	has = h.outputBuilder.Len() > 0 || h.outputTokens > 0
+	has = h.outputEstimator.Len() > 0 || h.outputTokens > 0
 	h.outputMu.Unlock()
 	return has
 }
@@ -1343,12 +1547,7 @@ func (h *streamHandler) handleMessage(msg upstream.SSEMessage) {
 		return
 	}
 
-	eventKey := msg.Type
-	if msg.Type == "model" && msg.Event != nil {
-		if evtType, ok := msg.Event["type"].(string); ok {
-			eventKey = "model." + evtType
-		}
-	}
+	eventKey := msg.EventKey()
 
 	// Instrument: Log detailed error info
 	if strings.HasSuffix(eventKey, ".error") || strings.Contains(eventKey, "error") {
@@ -1367,25 +1566,6 @@ func (h *streamHandler) handleMessage(msg upstream.SSEMessage) {
 		}
 	}
 
-	getUsageInt := func(usage map[string]interface{}, key string) (int, bool) {
-		if usage == nil {
-			return 0, false
-		}
-		if raw, ok := usage[key]; ok {
-			switch v := raw.(type) {
-			case float64:
-				return int(v), true
-			case int:
-				return v, true
-			case json.Number:
-				if n, err := v.Int64(); err == nil {
-					return int(n), true
-				}
-			}
-		}
-		return 0, false
-	}
-
 	switch eventKey {
 	case "model.conversation_id":
 		if msg.Event != nil {
@@ -1411,27 +1591,27 @@ func (h *streamHandler) handleMessage(msg upstream.SSEMessage) {
 		} else {
 			sig = h.pendingThinkingSig
 		}
-		delta := ""
-		if msg.Type == "model" {
-			delta, _ = msg.Event["delta"].(string)
-		} else {
-			// coding_agent.reasoning.chunk
-			if data, ok := msg.Event["data"].(map[string]interface{}); ok {
-				delta, _ = data["text"].(string)
-			}
-		}
+		delta := msg.AsReasoningDelta().Delta
 		if delta == "" {
 			if sig != "" {
 				h.ensureBlock("thinking")
 				h.mu.Lock()
 				internalIdx := h.activeThinkingBlockIndex
+				sseIdx := -1
 				if internalIdx >= 0 && internalIdx < len(h.contentBlocks) {
 					if existing, ok := h.thinkingBlockSigs[internalIdx]; ok && existing == "" {
 						h.thinkingBlockSigs[internalIdx] = sig
 						h.contentBlocks[internalIdx]["signature"] = sig
+						h.pendingThinkingSig = ""
+						sseIdx = h.activeThinkingSSEIndex
 					}
 				}
 				h.mu.Unlock()
+				// A signature that arrives after the thinking text (Anthropic
+				// sends it last) reaches streaming clients as signature_delta.
+				if sseIdx >= 0 {
+					h.writeBlockDelta(signatureDeltaEvent{Type: "content_block_delta", Index: sseIdx, Delta: signatureDelta{Type: "signature_delta", Signature: sig}})
+				}
 			}
 			return
 		}
@@ -1453,9 +1633,7 @@ func (h *streamHandler) handleMessage(msg upstream.SSEMessage) {
 			internalIdx = h.activeThinkingBlockIndex
 			h.mu.Unlock()
 		}
-		if h.isStream {
-			h.addOutputTokens(delta)
-		}
+		h.addReasoningTokens(delta)
 		// Always update internal state for history
 		h.mu.Lock()
 		if internalIdx >= 0 && internalIdx < len(h.contentBlocks) {
@@ -1467,17 +1645,7 @@ func (h *streamHandler) handleMessage(msg upstream.SSEMessage) {
 			builder.WriteString(delta)
 		}
 		h.mu.Unlock()
-		m := perf.AcquireMap()
-		m["type"] = "content_block_delta"
-		m["index"] = sseIdx
-		deltaMap := perf.AcquireMap()
-		deltaMap["type"] = "thinking_delta"
-		deltaMap["thinking"] = delta
-		m["delta"] = deltaMap
-		data, _ := json.Marshal(m)
-		h.writeSSE("content_block_delta", string(data))
-		perf.ReleaseMap(deltaMap)
-		perf.ReleaseMap(m)
+		h.writeBlockDelta(thinkingDeltaEvent{Type: "content_block_delta", Index: sseIdx, Delta: thinkingDelta{Type: "thinking_delta", Thinking: delta}})
 
 	case "model.reasoning-end":
 		h.closeActiveBlock()
@@ -1486,61 +1654,22 @@ func (h *streamHandler) handleMessage(msg upstream.SSEMessage) {
 		h.ensureBlock("text")
 
 	case "model.text-delta", "coding_agent.output_text.delta":
-		delta := ""
-		if msg.Type == "model" {
-			delta, _ = msg.Event["delta"].(string)
-		} else {
-			// coding_agent.output_text.delta
-			delta, _ = msg.Event["delta"].(string)
-		}
+		delta := msg.AsTextDelta().Delta
 		if delta == "" {
 			return
 		}
 		if h.shouldSkipIntroDelta(delta) {
 			return
 		}
-		h.markTextOutput()
-
-		h.mu.Lock()
-		sseIdx := h.activeTextSSEIndex
-		internalIdx := h.activeTextBlockIndex
-		h.mu.Unlock()
-		if sseIdx < 0 {
-			// If we get delta but no text block is active, try to ensure one
-			sseIdx = h.ensureBlock("text")
-			h.mu.Lock()
-			internalIdx = h.activeTextBlockIndex
-			h.mu.Unlock()
-		}
-		h.addOutputTokens(delta)
-		if !h.isStream {
-			h.responseText.WriteString(delta)
-		}
-		// Always update internal state for history
-		h.mu.Lock()
-		if internalIdx >= 0 && internalIdx < len(h.contentBlocks) {
-			builder, ok := h.textBlockBuilders[internalIdx]
-			if !ok {
-				builder = perf.AcquireStringBuilder()
-				h.textBlockBuilders[internalIdx] = builder
-			}
-			builder.WriteString(delta)
-		}
-		h.mu.Unlock()
-		m := perf.AcquireMap()
-		m["type"] = "content_block_delta"
-		m["index"] = sseIdx
-		deltaMap := perf.AcquireMap()
-		deltaMap["type"] = "text_delta"
-		deltaMap["text"] = delta
-		m["delta"] = deltaMap
-		data, _ := json.Marshal(m)
-		h.writeSSE("content_block_delta", string(data))
-		perf.ReleaseMap(deltaMap)
-		perf.ReleaseMap(m)
+		h.emitTextDelta(delta)
 
 	case "model.text-end":
-		h.closeActiveBlock()
+		h.flushOutputFilter()
+		// Keep the text block open while a continuation may follow, so the
+		// continued text lands in the same block.
+		if h.continuationsLeft <= 0 {
+			h.closeActiveBlock()
+		}
 
 	case "coding_agent.start", "coding_agent.initializing", "init":
 		// Ensure a thinking block is open for these status updates when we already have signature or block
@@ -1644,9 +1773,9 @@ func (h *streamHandler) handleMessage(msg upstream.SSEMessage) {
 
 	case "model.tool-input-start":
 		h.closeActiveBlock() // Tool input starts a separate block mechanism
-		toolID, _ := msg.Event["id"].(string)
-		toolName, _ := msg.Event["toolName"].(string)
-		toolName = normalizeUpstreamToolName(toolName)
+		start := msg.AsToolInputStart()
+		toolID := start.ID
+		toolName := normalizeUpstreamToolName(start.ToolName)
 		if toolID == "" || toolName == "" {
 			return
 		}
@@ -1658,8 +1787,8 @@ func (h *streamHandler) handleMessage(msg upstream.SSEMessage) {
 		return
 
 	case "model.tool-input-delta":
-		toolID, _ := msg.Event["id"].(string)
-		delta, _ := msg.Event["delta"].(string)
+		ev := msg.AsToolInputDelta()
+		toolID, delta := ev.ID, ev.Delta
 		if toolID == "" {
 			return
 		}
@@ -1672,7 +1801,7 @@ func (h *streamHandler) handleMessage(msg upstream.SSEMessage) {
 		return
 
 	case "model.tool-input-end":
-		toolID, _ := msg.Event["id"].(string)
+		toolID := msg.AsToolInputEnd().ID
 		if toolID == "" {
 			return
 		}
@@ -1706,6 +1835,9 @@ func (h *streamHandler) handleMessage(msg upstream.SSEMessage) {
 			return
 		}
 		h.toolCallHandled[toolID] = true
+		if h.interceptServerTool(call) {
+			return
+		}
 		if h.isStream {
 			if inputStr != "" {
 				h.addOutputTokens(inputStr)
@@ -1716,11 +1848,10 @@ func (h *streamHandler) handleMessage(msg upstream.SSEMessage) {
 		h.handleToolCallAfterChecks(call)
 
 	case "model.tool-call":
-		toolID, _ := msg.Event["toolCallId"].(string)
-		toolName, _ := msg.Event["toolName"].(string)
-		toolName = normalizeUpstreamToolName(toolName)
-		inputStr, _ := msg.Event["input"].(string)
-		inputStr = sanitizeToolInput(toolName, inputStr)
+		tc := msg.AsToolCall()
+		toolID := tc.ID
+		toolName := normalizeUpstreamToolName(tc.Name)
+		inputStr := sanitizeToolInput(toolName, tc.Input)
 		if toolID == "" {
 			toolID = fallbackToolCallID(toolName, inputStr)
 			if toolID == "" {
@@ -1744,6 +1875,9 @@ func (h *streamHandler) handleMessage(msg upstream.SSEMessage) {
 		delete(h.toolInputHadDelta, toolID)
 		delete(h.toolInputNames, toolID)
 		h.toolCallHandled[toolID] = true
+		if h.interceptServerTool(call) {
+			return
+		}
 		if h.isStream {
 			h.emitToolUseFromInput(toolID, toolName, inputStr)
 			return
@@ -1751,62 +1885,44 @@ func (h *streamHandler) handleMessage(msg upstream.SSEMessage) {
 		h.handleToolCallAfterChecks(call)
 
 	case "model.tokens-used":
-		usage := msg.Event
-		inputTokens, hasIn := getUsageInt(usage, "inputTokens")
-		outputTokens, hasOut := getUsageInt(usage, "outputTokens")
-		if !hasIn {
-			inputTokens, hasIn = getUsageInt(usage, "input_tokens")
-		}
-		if !hasOut {
-			outputTokens, hasOut = getUsageInt(usage, "output_tokens")
-		}
-		if hasIn || hasOut {
-			in := -1
-			out := -1
-			if hasIn {
-				in = inputTokens
-			}
-			if hasOut {
-				out = outputTokens
-			}
-			h.setUsageTokens(in, out)
-		}
+		h.applyUpstreamUsage(msg.AsTokensUsed().Usage)
 		return
 
 	case "model.finish":
 		stopReason := "end_turn"
-		if usage, ok := msg.Event["usage"].(map[string]interface{}); ok {
-			inputTokens, hasIn := getUsageInt(usage, "inputTokens")
-			outputTokens, hasOut := getUsageInt(usage, "outputTokens")
-			if !hasIn {
-				inputTokens, hasIn = getUsageInt(usage, "input_tokens")
-			}
-			if !hasOut {
-				outputTokens, hasOut = getUsageInt(usage, "output_tokens")
-			}
-			if hasIn || hasOut {
-				in := -1
-				out := -1
-				if hasIn {
-					in = inputTokens
-				}
-				if hasOut {
-					out = outputTokens
-				}
-				h.setUsageTokens(in, out)
-			}
-		}
-		if finishReason, ok := msg.Event["finishReason"].(string); ok {
-			switch finishReason {
-			case "tool-calls", "tool_use":
-				stopReason = "tool_use"
-			case "stop", "end_turn":
-				stopReason = "end_turn"
-			}
+		finish := msg.AsFinish()
+		h.applyUpstreamUsage(finish.Usage)
+		switch finish.Reason {
+		case "tool-calls", "tool_use":
+			stopReason = "tool_use"
+		case "stop", "end_turn":
+			stopReason = "end_turn"
+		case "max_tokens", "length":
+			stopReason = "max_tokens"
+		case "stop_sequence":
+			stopReason = "stop_sequence"
+			h.mu.Lock()
+			h.stopSequence = finish.StopSequence
+			h.mu.Unlock()
 		}
 
 		h.mu.Lock()
 		toolUseEmitted := len(h.toolCallEmitted) > 0
+		if len(h.serverToolCalls) > 0 {
+			// The pipeline runs the server tool calls before the response ends.
+			h.mu.Unlock()
+			h.closeActiveBlock()
+			return
+		}
+		if isTruncatedFinish(finish.Reason) && !toolUseEmitted && h.config.MaxContinuations > 0 {
+			if h.continuationsLeft > 0 {
+				// The pipeline issues a follow-up round; leave the response open.
+				h.truncated = true
+				h.mu.Unlock()
+				return
+			}
+			stopReason = "max_tokens"
+		}
 		hadToolCalls := h.toolCallCount > 0 ||
 			len(h.pendingToolCalls) > 0 ||
 			toolUseEmitted
@@ -1843,7 +1959,7 @@ func (h *streamHandler) emitThinkingDelta(delta string) {
 		h.mu.Unlock()
 	}
 
-	h.addOutputTokens(delta)
+	h.addReasoningTokens(delta)
 
 	h.mu.Lock()
 	if internalIdx >= 0 && internalIdx < len(h.contentBlocks) {
@@ -1856,20 +1972,70 @@ func (h *streamHandler) emitThinkingDelta(delta string) {
 	}
 	h.mu.Unlock()
 
-	m := perf.AcquireMap()
-	m["type"] = "content_block_delta"
-	m["index"] = sseIdx
-	deltaMap := perf.AcquireMap()
-	deltaMap["type"] = "thinking_delta"
-	deltaMap["thinking"] = delta
-	m["delta"] = deltaMap
-	data, _ := json.Marshal(m)
-	h.writeSSE("content_block_delta", string(data))
-	perf.ReleaseMap(deltaMap)
-	perf.ReleaseMap(m)
+	h.writeBlockDelta(thinkingDeltaEvent{Type: "content_block_delta", Index: sseIdx, Delta: thinkingDelta{Type: "thinking_delta", Thinking: delta}})
+}
+
+// Typed content_block_delta payloads for the per-token fast path; they encode
+// without building the nested maps the other events use.
+type textDeltaEvent struct {
+	Type  string    `json:"type"`
+	Index int       `json:"index"`
+	Delta textDelta `json:"delta"`
+}
+
+type textDelta struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type thinkingDeltaEvent struct {
+	Type  string        `json:"type"`
+	Index int           `json:"index"`
+	Delta thinkingDelta `json:"delta"`
+}
+
+type thinkingDelta struct {
+	Type     string `json:"type"`
+	Thinking string `json:"thinking"`
+}
+
+type signatureDeltaEvent struct {
+	Type  string         `json:"type"`
+	Index int            `json:"index"`
+	Delta signatureDelta `json:"delta"`
+}
+
+type signatureDelta struct {
+	Type      string `json:"type"`
+	Signature string `json:"signature"`
+}
+
+func (h *streamHandler) writeBlockDelta(event interface{}) {
+	enc := perf.AcquireJSONEncoder()
+	defer perf.ReleaseJSONEncoder(enc)
+	data, err := enc.Marshal(event)
+	if err != nil {
+		return
+	}
+	h.writeSSEBytes("content_block_delta", data)
 }
 
 func (h *streamHandler) emitTextDelta(delta string) {
+	if h.outputFilter != nil {
+		delta = h.outputFilter.Filter(delta)
+	}
+	h.writeTextDelta(delta)
+}
+
+// flushOutputFilter emits any text the output filter is still holding back.
+func (h *streamHandler) flushOutputFilter() {
+	if h.outputFilter == nil {
+		return
+	}
+	h.writeTextDelta(h.outputFilter.Flush())
+}
+
+func (h *streamHandler) writeTextDelta(delta string) {
 	if delta == "" {
 		return
 	}
@@ -1888,6 +2054,9 @@ func (h *streamHandler) emitTextDelta(delta string) {
 	}
 
 	h.addOutputTokens(delta)
+	if !h.isStream {
+		h.responseText.WriteString(delta)
+	}
 
 	h.mu.Lock()
 	if internalIdx >= 0 && internalIdx < len(h.contentBlocks) {
@@ -1900,17 +2069,7 @@ func (h *streamHandler) emitTextDelta(delta string) {
 	}
 	h.mu.Unlock()
 
-	m := perf.AcquireMap()
-	m["type"] = "content_block_delta"
-	m["index"] = sseIdx
-	deltaMap := perf.AcquireMap()
-	deltaMap["type"] = "text_delta"
-	deltaMap["text"] = delta
-	m["delta"] = deltaMap
-	data, _ := json.Marshal(m)
-	h.writeSSE("content_block_delta", string(data))
-	perf.ReleaseMap(deltaMap)
-	perf.ReleaseMap(m)
+	h.writeBlockDelta(textDeltaEvent{Type: "content_block_delta", Index: sseIdx, Delta: textDelta{Type: "text_delta", Text: delta}})
 }
 
 // InjectErrorText injects an error message as a text delta into the stream or buffer.
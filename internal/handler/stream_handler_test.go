@@ -2,6 +2,7 @@ package handler
 
 import (
 	"bytes"
+	"fmt"
 	"github.com/goccy/go-json"
 	"net/http"
 	"strings"
@@ -111,6 +112,106 @@ func TestStreamHandler_TextFlow_AnthropicSSE(t *testing.T) {
 	}
 }
 
+func TestStreamHandler_DeltaFastPathFrames(t *testing.T) {
+	cfg := &config.Config{DebugEnabled: false}
+	rec := newFlushRecorder()
+	logger := debug.New(false, false)
+	defer logger.Close()
+	sh := newStreamHandler(cfg, rec, logger, false, true, adapter.FormatAnthropic, "")
+	defer sh.release()
+
+	sh.emitThinkingDelta("plan")
+	sh.emitTextDelta("a \"quoted\" <tag>\n")
+
+	var deltas []map[string]any
+	for _, frame := range strings.Split(strings.TrimSpace(rec.buf.String()), "\n\n") {
+		lines := strings.SplitN(frame, "\n", 2)
+		if len(lines) != 2 || lines[0] != "event: content_block_delta" {
+			continue
+		}
+		var payload map[string]any
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(lines[1], "data: ")), &payload); err != nil {
+			t.Fatalf("invalid delta frame %q: %v", frame, err)
+		}
+		deltas = append(deltas, payload)
+	}
+	if len(deltas) != 2 {
+		t.Fatalf("expected 2 delta frames, got %d: %s", len(deltas), rec.buf.String())
+	}
+	thinking := deltas[0]["delta"].(map[string]any)
+	if deltas[0]["type"] != "content_block_delta" || thinking["type"] != "thinking_delta" || thinking["thinking"] != "plan" {
+		t.Fatalf("unexpected thinking delta: %v", deltas[0])
+	}
+	text := deltas[1]["delta"].(map[string]any)
+	if text["type"] != "text_delta" || text["text"] != "a \"quoted\" <tag>\n" {
+		t.Fatalf("unexpected text delta: %v", deltas[1])
+	}
+	if deltas[1]["index"].(float64) <= deltas[0]["index"].(float64) {
+		t.Fatalf("text block should follow thinking block: %v", deltas)
+	}
+
+	openaiRec := newFlushRecorder()
+	osh := newStreamHandler(cfg, openaiRec, logger, false, true, adapter.FormatOpenAI, "")
+	defer osh.release()
+	osh.emitTextDelta("hi")
+	if !strings.Contains(openaiRec.buf.String(), `"content":"hi"`) {
+		t.Fatalf("expected openai chunk with content, got: %s", openaiRec.buf.String())
+	}
+}
+
+func TestStreamHandler_FlatReasoningDelta(t *testing.T) {
+	cfg := &config.Config{DebugEnabled: false}
+	rec := newFlushRecorder()
+	logger := debug.New(false, false)
+	defer logger.Close()
+	sh := newStreamHandler(cfg, rec, logger, false, true, adapter.FormatAnthropic, "")
+	defer sh.release()
+
+	// Passthrough channels emit "model.reasoning-delta" with the text in "delta".
+	sh.handleMessage(upstream.SSEMessage{Type: "model.reasoning-delta", Event: map[string]any{"delta": "step one"}})
+	sh.handleMessage(upstream.SSEMessage{Type: "model.finish", Event: map[string]any{"finishReason": "end_turn", "usage": map[string]any{"inputTokens": 3, "outputTokens": 4}}})
+
+	if out := rec.buf.String(); !strings.Contains(out, `"thinking":"step one"`) {
+		t.Fatalf("expected thinking delta, got: %s", out)
+	}
+	if sh.inputTokens != 3 || sh.outputTokens != 4 {
+		t.Fatalf("usage = %d/%d, want 3/4", sh.inputTokens, sh.outputTokens)
+	}
+}
+
+func TestStreamHandler_ReasoningTokens(t *testing.T) {
+	logger := debug.New(false, false)
+	defer logger.Close()
+	thinking := strings.Repeat("consider the options ", 20)
+	run := func(cfg *config.Config, isStream bool) (*streamHandler, string) {
+		rec := newFlushRecorder()
+		sh := newStreamHandler(cfg, rec, logger, false, isStream, adapter.FormatAnthropic, "")
+		t.Cleanup(sh.release)
+		sh.handleMessage(upstream.SSEMessage{Type: "model.reasoning-delta", Event: map[string]any{"delta": thinking}})
+		sh.handleMessage(upstream.SSEMessage{Type: "model.text-delta", Event: map[string]any{"delta": "done"}})
+		sh.finishResponse("end_turn")
+		return sh, rec.buf.String()
+	}
+
+	// Thinking counts towards output tokens in both modes.
+	stream, out := run(&config.Config{}, true)
+	plain, _ := run(&config.Config{}, false)
+	if stream.outputTokens != plain.outputTokens || stream.reasoningTokens != plain.reasoningTokens {
+		t.Fatalf("stream %d/%d != non-stream %d/%d", stream.outputTokens, stream.reasoningTokens, plain.outputTokens, plain.reasoningTokens)
+	}
+	if stream.reasoningTokens == 0 || stream.reasoningTokens >= stream.outputTokens {
+		t.Fatalf("reasoning=%d output=%d", stream.reasoningTokens, stream.outputTokens)
+	}
+	if strings.Contains(out, "reasoning_output_tokens") {
+		t.Fatalf("reasoning usage reported without usage_reasoning_tokens: %s", out)
+	}
+
+	_, out = run(&config.Config{UsageReasoningTokens: true}, true)
+	if !strings.Contains(out, fmt.Sprintf(`"reasoning_output_tokens":%d`, stream.reasoningTokens)) {
+		t.Fatalf("expected reasoning_output_tokens in message_delta, got: %s", out)
+	}
+}
+
 func TestStreamHandler_ToolInput_EndEmitsToolUse(t *testing.T) {
 	cfg := &config.Config{DebugEnabled: false}
 	rec := newFlushRecorder()
@@ -241,3 +342,119 @@ func TestStreamHandler_EventThrottle_fs_operation(t *testing.T) {
 		t.Fatalf("expected third fs_operation to be written after throttle window")
 	}
 }
+
+// slowRecorder is a client that takes delay to accept every write.
+type slowRecorder struct {
+	*flushRecorder
+	delay time.Duration
+}
+
+func (r *slowRecorder) Write(b []byte) (int, error) {
+	time.Sleep(r.delay)
+	return r.flushRecorder.Write(b)
+}
+
+func TestStreamHandler_ClientStallAbortsStream(t *testing.T) {
+	cfg := &config.Config{MaxClientStall: 1}
+	rec := &slowRecorder{flushRecorder: newFlushRecorder(), delay: 400 * time.Millisecond}
+	logger := debug.New(false, false)
+	defer logger.Close()
+	sh := newStreamHandler(cfg, rec, logger, false, true, adapter.FormatAnthropic, "")
+	defer sh.release()
+	cancelled := false
+	sh.onClientAbort = func() { cancelled = true }
+
+	for i := 0; i < 5; i++ {
+		sh.writeSSE("ping", `{"type":"ping"}`)
+	}
+	if !sh.clientAborted() || sh.clientAbort != "stall_limit" || !cancelled {
+		t.Fatalf("abort=%q cancelled=%v", sh.clientAbort, cancelled)
+	}
+	if n := strings.Count(rec.buf.String(), "event: ping"); n != 3 {
+		t.Fatalf("frames written after the stall budget ran out: %d", n)
+	}
+}
+
+// burstyRecorder is a client that stalls on every other write.
+type burstyRecorder struct {
+	*flushRecorder
+	delay  time.Duration
+	writes int
+}
+
+func (r *burstyRecorder) Write(b []byte) (int, error) {
+	r.writes++
+	if r.writes%2 == 1 {
+		time.Sleep(r.delay)
+	}
+	return r.flushRecorder.Write(b)
+}
+
+func TestStreamHandler_FastWriteEndsStall(t *testing.T) {
+	cfg := &config.Config{MaxClientStall: 1}
+	rec := &burstyRecorder{flushRecorder: newFlushRecorder(), delay: 300 * time.Millisecond}
+	logger := debug.New(false, false)
+	defer logger.Close()
+	sh := newStreamHandler(cfg, rec, logger, false, true, adapter.FormatAnthropic, "")
+	defer sh.release()
+
+	// Five short stalls add up to more than the limit, but each one ends
+	// with a prompt write.
+	for i := 0; i < 10; i++ {
+		sh.writeSSE("ping", `{"type":"ping"}`)
+	}
+	if sh.clientAborted() {
+		t.Fatalf("aborted after short stalls: %q", sh.clientAbort)
+	}
+	if n := strings.Count(rec.buf.String(), "event: ping"); n != 10 {
+		t.Fatalf("frames written: %d", n)
+	}
+}
+
+// countingRecorder counts flushes.
+type countingRecorder struct {
+	*flushRecorder
+	flushes int
+}
+
+func (r *countingRecorder) Flush() { r.flushes++ }
+
+func TestStreamHandler_FlushCoalescing(t *testing.T) {
+	cfg := &config.Config{StreamFlushIntervalMs: 200}
+	rec := &countingRecorder{flushRecorder: newFlushRecorder()}
+	logger := debug.New(false, false)
+	defer logger.Close()
+	sh := newStreamHandler(cfg, rec, logger, false, true, adapter.FormatAnthropic, "")
+	defer sh.release()
+
+	sh.writeSSE("message_start", `{"type":"message_start"}`)
+	for i := 0; i < 10; i++ {
+		sh.writeSSE("content_block_delta", `{"type":"content_block_delta"}`)
+	}
+	sh.mu.Lock()
+	flushes := rec.flushes
+	sh.mu.Unlock()
+	if flushes != 1 {
+		t.Fatalf("deltas inside the window flushed %d times, want only message_start", flushes)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		sh.mu.Lock()
+		flushes = rec.flushes
+		sh.mu.Unlock()
+		if flushes == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("pending deltas were not flushed at the end of the window: flushes=%d", flushes)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	sh.writeKeepAlive()
+	sh.writeSSE("message_stop", `{"type":"message_stop"}`)
+	if rec.flushes != 4 {
+		t.Fatalf("keep-alive and message_stop must flush at once: flushes=%d", rec.flushes)
+	}
+}
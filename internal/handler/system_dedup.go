@@ -0,0 +1,103 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"orchids-api/internal/prompt"
+	"orchids-api/internal/upstream"
+)
+
+// systemRefLen is the number of hex digits of a system block's hash used as
+// its reference.
+const systemRefLen = 12
+
+// systemRef identifies a system block by content.
+func systemRef(text string) string {
+	sum := sha256.Sum256([]byte(strings.TrimSpace(text)))
+	return hex.EncodeToString(sum[:])[:systemRefLen]
+}
+
+// upstreamConversation reports whether convID was issued by the upstream, i.e.
+// the upstream keeps the conversation and has seen its earlier prompts.
+// Locally generated session IDs start with "chat_".
+func upstreamConversation(convID string) bool {
+	return convID != "" && !strings.HasPrefix(convID, "chat_")
+}
+
+// dedupSystemItems replaces the system blocks whose reference is in delivered
+// with a short reference line. It returns the rewritten copy, the references
+// of all blocks long enough to dedup and how many blocks it replaced.
+func dedupSystemItems(system []prompt.SystemItem, delivered []string) ([]prompt.SystemItem, []string, int) {
+	var refs []string
+	var out []prompt.SystemItem
+	replaced := 0
+	for i, item := range system {
+		if len(strings.TrimSpace(item.Text)) < hygieneDedupMinChars {
+			continue
+		}
+		ref := systemRef(item.Text)
+		refs = append(refs, ref)
+		if !slices.Contains(delivered, ref) {
+			continue
+		}
+		if out == nil {
+			out = slices.Clone(system)
+		}
+		out[i].Text = fmt.Sprintf("[system block %s unchanged, sent earlier in this conversation]", ref)
+		replaced++
+	}
+	if out == nil {
+		out = system
+	}
+	return out, refs, replaced
+}
+
+// dedupSystem returns the system blocks to build the prompt from. With
+// prompt_hygiene_system_dedup on, blocks the upstream conversation already
+// received are replaced by references; p.systemRefs keeps the references to
+// record once the upstream accepts this turn.
+func (p *messagesPipeline) dedupSystem(ph promptHygiene) []prompt.SystemItem {
+	system := p.req.System
+	if !ph.systemDedup || p.conversationKey == "" {
+		return system
+	}
+	var delivered []string
+	ctx, ss := p.r.Context(), p.h.sessionStore
+	if convID, ok := ss.GetConvID(ctx, p.conversationKey); ok && upstreamConversation(convID) {
+		delivered = ss.GetSystemRefs(ctx, p.conversationKey, convID)
+	}
+	var replaced int
+	system, p.systemRefs, replaced = dedupSystemItems(system, delivered)
+	if replaced > 0 {
+		p.systemDeduped = true
+		slog.Debug("Prompt hygiene replaced repeated system blocks", "blocks", replaced)
+	}
+	return system
+}
+
+// restoreSystem rebuilds the prompt with the full system blocks after a
+// switch to another account, whose upstream never saw the conversation.
+func (p *messagesPipeline) restoreSystem(upstreamReq *upstream.UpstreamRequest) {
+	if !p.systemDeduped {
+		return
+	}
+	p.promptSystem, p.systemDeduped = p.req.System, false
+	p.rebuildPrompt(upstreamReq)
+}
+
+// recordSystemRefs remembers that the session's upstream conversation has
+// received this request's system blocks.
+func (p *messagesPipeline) recordSystemRefs() {
+	if len(p.systemRefs) == 0 {
+		return
+	}
+	ctx, ss := p.r.Context(), p.h.sessionStore
+	if convID, ok := ss.GetConvID(ctx, p.conversationKey); ok && upstreamConversation(convID) {
+		ss.SetSystemRefs(ctx, p.conversationKey, convID, p.systemRefs)
+	}
+}
@@ -0,0 +1,82 @@
+package handler
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"orchids-api/internal/prompt"
+)
+
+func TestDedupSystemItems(t *testing.T) {
+	t.Parallel()
+
+	long := strings.Repeat("You are a coding agent. ", 20)
+	system := []prompt.SystemItem{
+		{Type: "text", Text: "short header"},
+		{Type: "text", Text: long},
+	}
+
+	out, refs, replaced := dedupSystemItems(system, nil)
+	if replaced != 0 || len(refs) != 1 || refs[0] != systemRef(long) {
+		t.Fatalf("first turn: refs=%v replaced=%d", refs, replaced)
+	}
+	if out[1].Text != long {
+		t.Fatal("undelivered block must be kept")
+	}
+
+	out, _, replaced = dedupSystemItems(system, refs)
+	if replaced != 1 || out[0].Text != "short header" || !strings.Contains(out[1].Text, refs[0]) || len(out[1].Text) >= 100 {
+		t.Fatalf("repeated block: replaced=%d out=%+v", replaced, out)
+	}
+	if system[1].Text != long {
+		t.Fatal("input system must not be modified")
+	}
+}
+
+func TestPipelineDedupSystem(t *testing.T) {
+	t.Parallel()
+
+	long := strings.Repeat("Project rules: run the tests. ", 20)
+	h := &Handler{sessionStore: NewMemorySessionStore(time.Minute, 10)}
+	ph := promptHygiene{systemDedup: true}
+	turn := func() *messagesPipeline {
+		return &messagesPipeline{
+			h:               h,
+			r:               httptest.NewRequest("POST", "/v1/messages", nil),
+			conversationKey: "conv-key",
+			req:             ClaudeRequest{System: SystemItems{{Type: "text", Text: long}}},
+		}
+	}
+	ctx := context.Background()
+
+	// A locally generated session ID means upstream keeps no history.
+	h.sessionStore.SetConvID(ctx, "conv-key", "chat_123")
+	p := turn()
+	if got := p.dedupSystem(ph); got[0].Text != long {
+		t.Fatal("first turn must send the full system")
+	}
+	p.recordSystemRefs()
+	if got := turn().dedupSystem(ph); got[0].Text != long {
+		t.Fatal("system must not be deduped without an upstream conversation")
+	}
+
+	// The upstream returned its conversation ID during the turn.
+	h.sessionStore.SetConvID(ctx, "conv-key", "warp-conv-1")
+	p.recordSystemRefs()
+	next := turn()
+	if got := next.dedupSystem(ph); got[0].Text == long || !next.systemDeduped {
+		t.Fatalf("repeated system block was not replaced: %q", got[0].Text)
+	}
+
+	// A new upstream conversation has not seen the block.
+	h.sessionStore.SetConvID(ctx, "conv-key", "warp-conv-2")
+	if got := turn().dedupSystem(ph); got[0].Text != long {
+		t.Fatal("system refs must not carry over to another upstream conversation")
+	}
+	if got := turn().dedupSystem(promptHygiene{}); got[0].Text != long {
+		t.Fatal("dedup must be off unless configured")
+	}
+}
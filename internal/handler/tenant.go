@@ -0,0 +1,38 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"orchids-api/internal/store"
+)
+
+var errTenantDisabled = errors.New("the API key's tenant is disabled")
+
+// keyTenant loads the tenant of key, or nil for keys of the shared pool. A
+// disabled or deleted tenant rejects its keys.
+func (h *Handler) keyTenant(ctx context.Context, key *store.ApiKey) (*store.Tenant, error) {
+	if key == nil || key.TenantID == 0 || h.loadBalancer == nil || h.loadBalancer.Store == nil {
+		return nil, nil
+	}
+	tenant, err := h.loadBalancer.Store.GetTenant(store.WithoutTenant(ctx), key.TenantID)
+	if err != nil || tenant == nil || !tenant.Enabled {
+		return nil, errTenantDisabled
+	}
+	return tenant, nil
+}
+
+// keyTenantID is the tenant whose accounts and models serve key.
+func keyTenantID(key *store.ApiKey) int64 {
+	if key == nil {
+		return 0
+	}
+	return key.TenantID
+}
+
+// modelViewContext scopes model lookups of r to the model view of the
+// calling key's tenant.
+func (h *Handler) modelViewContext(r *http.Request) context.Context {
+	return store.WithTenant(r.Context(), keyTenantID(h.lookupApiKey(r.Context(), presentedKeyHash(r))))
+}
@@ -17,12 +17,20 @@ type inputTokenBreakdown struct {
 
 func estimateInputTokenBreakdown(promptText string, history []map[string]string, tools []interface{}) inputTokenBreakdown {
 	var bd inputTokenBreakdown
-	promptTokens := tiktoken.EstimateTextTokens(promptText)
-	sysText := extractTaggedContent(promptText, "sys")
-	if sysText == "" {
-		sysText = extractTaggedContent(promptText, "system_context")
+	// The system context is resent unchanged every turn, so it is estimated
+	// as a cached block. The tags around it end any word, which makes the
+	// sum equal to estimating the prompt in one pass.
+	before, sysText, after, ok := cutTaggedContent(promptText, "sys")
+	if !ok {
+		before, sysText, after, ok = cutTaggedContent(promptText, "system_context")
+	}
+	promptTokens, sysTokens := 0, 0
+	if ok {
+		sysTokens = estimateBlockTokens(sysText)
+		promptTokens = tiktoken.EstimateTextTokens(before) + sysTokens + tiktoken.EstimateTextTokens(after)
+	} else {
+		promptTokens = tiktoken.EstimateTextTokens(promptText)
 	}
-	sysTokens := tiktoken.EstimateTextTokens(sysText)
 	if sysTokens > promptTokens {
 		sysTokens = promptTokens
 	}
@@ -35,7 +43,7 @@ func estimateInputTokenBreakdown(promptText string, history []map[string]string,
 		if content == "" {
 			continue
 		}
-		bd.HistoryTokens += tiktoken.EstimateTextTokens(content) + 15
+		bd.HistoryTokens += estimateBlockTokens(content) + 15
 	}
 
 	bd.ToolsTokens = orchids.EstimateCompactedToolsTokens(tools)
@@ -44,21 +52,28 @@ func estimateInputTokenBreakdown(promptText string, history []map[string]string,
 	return bd
 }
 
-func extractTaggedContent(text string, tag string) string {
+// cutTaggedContent splits text around the first <tag>...</tag> and returns
+// the trimmed content between the tags; ok is false when the tags are
+// missing or enclose only whitespace.
+func cutTaggedContent(text string, tag string) (before, inner, after string, ok bool) {
 	if text == "" || tag == "" {
-		return ""
+		return "", "", "", false
 	}
 	startTag := "<" + tag + ">"
 	endTag := "</" + tag + ">"
 
 	start := strings.Index(text, startTag)
 	if start == -1 {
-		return ""
+		return "", "", "", false
 	}
 	start += len(startTag)
 	end := strings.Index(text[start:], endTag)
 	if end == -1 {
-		return ""
+		return "", "", "", false
+	}
+	inner = strings.TrimSpace(text[start : start+end])
+	if inner == "" {
+		return "", "", "", false
 	}
-	return strings.TrimSpace(text[start : start+end])
+	return text[:start], inner, text[start+end:], true
 }
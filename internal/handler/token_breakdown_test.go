@@ -5,9 +5,11 @@ import (
 	"github.com/goccy/go-json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"orchids-api/internal/config"
+	"orchids-api/internal/tiktoken"
 )
 
 func TestEstimateInputTokenBreakdown_SplitsSystemContext(t *testing.T) {
@@ -45,6 +47,40 @@ func TestEstimateInputTokenBreakdown_SplitsSystemContext(t *testing.T) {
 	if bd.Total != bd.BasePromptTokens+bd.SystemContextTokens+bd.HistoryTokens+bd.ToolsTokens {
 		t.Fatalf("unexpected total=%d", bd.Total)
 	}
+	if got, want := bd.BasePromptTokens+bd.SystemContextTokens, tiktoken.EstimateTextTokens(prompt); got != want {
+		t.Fatalf("prompt tokens = %d, want %d as estimated in one pass", got, want)
+	}
+}
+
+func TestEstimateBlockTokensCachesLargeBlocks(t *testing.T) {
+	block := strings.Repeat("cached history block 缓存 ", minCachedBlockBytes/20)
+	want := tiktoken.EstimateTextTokens(block)
+
+	hits, _ := blockTokenCache.HitStats()
+	if got := estimateBlockTokens(block); got != want {
+		t.Fatalf("estimateBlockTokens = %d, want %d", got, want)
+	}
+	if got := estimateBlockTokens(block); got != want {
+		t.Fatalf("cached estimateBlockTokens = %d, want %d", got, want)
+	}
+	if after, _ := blockTokenCache.HitStats(); after <= hits {
+		t.Fatalf("second estimate of a large block should hit the cache")
+	}
+}
+
+// BenchmarkEstimateInputTokenBreakdown estimates a ~100k-token conversation
+// whose system context and history repeat across requests.
+func BenchmarkEstimateInputTokenBreakdown(b *testing.B) {
+	turn := strings.Repeat("The quick brown fox jumps over the lazy dog. 你好世界，func main() { return x+1 }\n", 250)
+	prompt := "<env>\ndate: 2026-02-12\n</env>\n<sys>\n" + turn + turn + "\n</sys>\n<user>\nhello\n</user>"
+	history := make([]map[string]string, 8)
+	for i := range history {
+		history[i] = map[string]string{"role": "user", "content": turn}
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		estimateInputTokenBreakdown(prompt, history, nil)
+	}
 }
 
 func TestHandleCountTokens_ReturnsBreakdown(t *testing.T) {
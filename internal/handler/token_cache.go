@@ -10,6 +10,31 @@ import (
 
 const defaultTokenCacheTTL = 5 * time.Minute
 
+// minCachedBlockBytes is the smallest block whose estimate goes through
+// blockTokenCache; below about 1KB a lookup costs as much as a scan.
+const minCachedBlockBytes = 2 << 10
+
+// blockTokenCache memoizes estimates of prompt blocks (system context,
+// history turns, tool results) that clients resend unchanged on every turn.
+// Keys are content hashes, so one cache serves every handler.
+var blockTokenCache = tokencache.NewMemoryCacheWithLimits(10*time.Minute, 16384, 0)
+
+// estimateBlockTokens is tiktoken.EstimateTextTokens, cached by content hash
+// for large blocks.
+func estimateBlockTokens(text string) int {
+	if len(text) < minCachedBlockBytes {
+		return tiktoken.EstimateTextTokens(text)
+	}
+	ctx := context.Background()
+	key := tokencache.CacheKey("", "", text)
+	if tokens, ok := blockTokenCache.Get(ctx, key); ok {
+		return tokens
+	}
+	tokens := tiktoken.EstimateTextTokens(text)
+	blockTokenCache.Put(ctx, key, tokens)
+	return tokens
+}
+
 func (h *Handler) estimateInputTokens(ctx context.Context, model, prompt string) int {
 	if prompt == "" {
 		return 0
@@ -0,0 +1,175 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"orchids-api/internal/orchids"
+)
+
+// allChannelTools is the capability entry that admits every client tool.
+const allChannelTools = "*"
+
+// defaultChannelTools lists what each channel can execute when the model has
+// no tools of its own configured. Entries are tool names (matched after
+// Claude Code → Orchids name mapping) or server tool types such as
+// "web_search". Channels not listed accept every client tool.
+var defaultChannelTools = map[string][]string{
+	"orchids":           {"Read", "Write", "Edit", "Bash", "Glob", "Grep", "TodoWrite"},
+	"warp":              {allChannelTools},
+	"kiro":              {allChannelTools},
+	"openai-compatible": {allChannelTools},
+	"anthropic":         {allChannelTools, "web_search", "web_fetch", "code_execution", "computer", "bash", "text_editor"},
+}
+
+// serverToolVersion matches the date suffix of versioned server tool types,
+// e.g. "web_search_20250305".
+var serverToolVersion = regexp.MustCompile(`_\d{8}$`)
+
+// toolCapabilities is the set of tools a channel can execute.
+type toolCapabilities struct {
+	any   bool
+	names map[string]struct{}
+}
+
+func newToolCapabilities(entries []string) toolCapabilities {
+	c := toolCapabilities{names: make(map[string]struct{}, len(entries))}
+	for _, e := range entries {
+		e = strings.ToLower(strings.TrimSpace(e))
+		switch e {
+		case "":
+		case allChannelTools:
+			c.any = true
+		default:
+			c.names[e] = struct{}{}
+		}
+	}
+	return c
+}
+
+func (c toolCapabilities) has(name string) bool {
+	_, ok := c.names[strings.ToLower(strings.TrimSpace(name))]
+	return ok
+}
+
+// toolIdentity returns a tool definition's name and, for server tools, its
+// unversioned type. Client tools (Anthropic custom or OpenAI function tools)
+// have an empty server type.
+func toolIdentity(tool interface{}) (name, serverType string) {
+	m, ok := tool.(map[string]interface{})
+	if !ok {
+		return "", ""
+	}
+	typ, _ := m["type"].(string)
+	typ = strings.ToLower(strings.TrimSpace(typ))
+	if fn, ok := m["function"].(map[string]interface{}); ok {
+		name, _ = fn["name"].(string)
+		return strings.TrimSpace(name), ""
+	}
+	name, _ = m["name"].(string)
+	if typ == "" || typ == "custom" || typ == "function" {
+		return strings.TrimSpace(name), ""
+	}
+	return strings.TrimSpace(name), serverToolVersion.ReplaceAllString(typ, "")
+}
+
+// supports reports whether the channel can execute the tool.
+func (c toolCapabilities) supports(tool interface{}) bool {
+	name, serverType := toolIdentity(tool)
+	if serverType != "" {
+		return c.has(serverType) || c.has(name)
+	}
+	if name == "" {
+		return false
+	}
+	return c.any || c.has(name) || c.has(orchids.DefaultToolMapper.ToOrchids(name))
+}
+
+// filterChannelTools splits tools into those the channel can execute and
+// those it cannot.
+func filterChannelTools(tools []interface{}, caps toolCapabilities) (kept, dropped []interface{}) {
+	for _, tool := range tools {
+		if caps.supports(tool) {
+			kept = append(kept, tool)
+		} else {
+			dropped = append(dropped, tool)
+		}
+	}
+	return kept, dropped
+}
+
+// channelToolCapabilities resolves what the serving channel can execute for
+// model: the model's own tools list when set, else the channel default.
+func (h *Handler) channelToolCapabilities(ctx context.Context, channel, model string) toolCapabilities {
+	channel = strings.ToLower(strings.TrimSpace(channel))
+	if channel == "" {
+		channel = "orchids"
+	}
+	if h.loadBalancer != nil && h.loadBalancer.Store != nil && model != "" {
+		m, err := h.loadBalancer.Store.GetModelByModelID(ctx, h.resolveModelAlias(ctx, model))
+		if err == nil && m != nil && len(m.Tools) > 0 {
+			modelChannel := strings.TrimSpace(m.Channel)
+			if modelChannel == "" {
+				modelChannel = "orchids"
+			}
+			if strings.EqualFold(modelChannel, channel) {
+				return newToolCapabilities(m.Tools)
+			}
+		}
+	}
+	if entries, ok := defaultChannelTools[channel]; ok {
+		return newToolCapabilities(entries)
+	}
+	return newToolCapabilities([]string{allChannelTools})
+}
+
+// unsupportedToolsInstruction describes tools the channel cannot execute so
+// the model can still account for them in its answer.
+func unsupportedToolsInstruction(dropped []interface{}) string {
+	var sb strings.Builder
+	seen := make(map[string]struct{}, len(dropped))
+	for _, tool := range dropped {
+		name, serverType := toolIdentity(tool)
+		if name == "" {
+			name = serverType
+		}
+		if name == "" {
+			continue
+		}
+		if _, ok := seen[name]; ok {
+			continue
+		}
+		seen[name] = struct{}{}
+		desc := ""
+		if m, ok := tool.(map[string]interface{}); ok {
+			desc, _ = m["description"].(string)
+			if fn, ok := m["function"].(map[string]interface{}); ok {
+				desc, _ = fn["description"].(string)
+			}
+		}
+		if desc = firstLine(desc, 120); desc != "" {
+			fmt.Fprintf(&sb, "- %s: %s\n", name, desc)
+		} else {
+			fmt.Fprintf(&sb, "- %s\n", name)
+		}
+	}
+	if sb.Len() == 0 {
+		return ""
+	}
+	return "The client offered these tools, but they cannot be called here:\n" + sb.String() +
+		"Do not call them. If the task needs one, use the available tools instead or tell the user what to run."
+}
+
+// firstLine returns the first line of s, cut to at most max runes.
+func firstLine(s string, max int) string {
+	s = strings.TrimSpace(s)
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		s = strings.TrimSpace(s[:i])
+	}
+	if r := []rune(s); len(r) > max {
+		s = string(r[:max]) + "..."
+	}
+	return s
+}
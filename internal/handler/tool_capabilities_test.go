@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"orchids-api/internal/loadbalancer"
+	"orchids-api/internal/store"
+)
+
+func testTools() []interface{} {
+	return []interface{}{
+		map[string]interface{}{"name": "Read", "description": "Reads a file"},
+		map[string]interface{}{"name": "view"},
+		map[string]interface{}{"name": "WebFetch", "description": "Fetches a URL\nand more"},
+		map[string]interface{}{"type": "web_search_20250305", "name": "web_search"},
+		map[string]interface{}{"type": "function", "function": map[string]interface{}{"name": "Bash", "description": "Runs a command"}},
+	}
+}
+
+func toolNames(tools []interface{}) []string {
+	var out []string
+	for _, tool := range tools {
+		name, _ := toolIdentity(tool)
+		out = append(out, name)
+	}
+	return out
+}
+
+func TestFilterChannelTools(t *testing.T) {
+	cases := []struct {
+		channel string
+		kept    string
+		dropped string
+	}{
+		{"orchids", "Read,view,Bash", "WebFetch,web_search"},
+		{"warp", "Read,view,WebFetch,Bash", "web_search"},
+		{"anthropic", "Read,view,WebFetch,web_search,Bash", ""},
+		{"unknown", "Read,view,WebFetch,Bash", "web_search"},
+	}
+	h := &Handler{}
+	for _, tc := range cases {
+		caps := h.channelToolCapabilities(context.Background(), tc.channel, "")
+		kept, dropped := filterChannelTools(testTools(), caps)
+		if got := strings.Join(toolNames(kept), ","); got != tc.kept {
+			t.Errorf("%s: kept=%s want=%s", tc.channel, got, tc.kept)
+		}
+		if got := strings.Join(toolNames(dropped), ","); got != tc.dropped {
+			t.Errorf("%s: dropped=%s want=%s", tc.channel, got, tc.dropped)
+		}
+	}
+}
+
+func TestChannelToolCapabilities_ModelOverride(t *testing.T) {
+	mr := miniredis.RunT(t)
+	s, err := store.New(store.Options{RedisAddr: mr.Addr(), RedisPrefix: "test:"})
+	if err != nil {
+		t.Fatalf("store.New: %v", err)
+	}
+	ctx := context.Background()
+	m, err := s.GetModelByModelID(ctx, "claude-sonnet-4-5")
+	if err != nil {
+		t.Fatalf("GetModelByModelID: %v", err)
+	}
+	m.Tools = []string{"Read", "web_search"}
+	if err := s.UpdateModel(ctx, m); err != nil {
+		t.Fatalf("UpdateModel: %v", err)
+	}
+	h := &Handler{loadBalancer: loadbalancer.NewWithCacheTTL(s, 0)}
+
+	kept, _ := filterChannelTools(testTools(), h.channelToolCapabilities(ctx, "orchids", "claude-sonnet-4-5"))
+	if got := strings.Join(toolNames(kept), ","); got != "Read,view,web_search" {
+		t.Fatalf("kept=%s", got)
+	}
+	// The override belongs to the Orchids model; another channel keeps its default.
+	kept, _ = filterChannelTools(testTools(), h.channelToolCapabilities(ctx, "warp", "claude-sonnet-4-5"))
+	if got := strings.Join(toolNames(kept), ","); got != "Read,view,WebFetch,Bash" {
+		t.Fatalf("warp kept=%s", got)
+	}
+}
+
+func TestUnsupportedToolsInstruction(t *testing.T) {
+	_, dropped := filterChannelTools(testTools(), newToolCapabilities(defaultChannelTools["orchids"]))
+	note := unsupportedToolsInstruction(dropped)
+	for _, want := range []string{"- WebFetch: Fetches a URL\n", "- web_search\n", "Do not call them"} {
+		if !strings.Contains(note, want) {
+			t.Fatalf("instruction missing %q:\n%s", want, note)
+		}
+	}
+	if unsupportedToolsInstruction(nil) != "" {
+		t.Fatal("expected no instruction without dropped tools")
+	}
+}
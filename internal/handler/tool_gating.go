@@ -0,0 +1,123 @@
+package handler
+
+import (
+	"log/slog"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"orchids-api/internal/prompt"
+	"orchids-api/internal/store"
+)
+
+// defaultToolGateCodePatterns mark a short request as code-related, so it
+// keeps its tools.
+var defaultToolGateCodePatterns = []string{
+	"```",
+	`(?i)\b(func|def|class|import|package|const|var|let|return)\s`,
+	`=>|::|</|\$\s`,
+	`(?i)\.(go|py|js|jsx|ts|tsx|java|kt|rs|c|cc|cpp|h|hpp|cs|rb|php|swift|sh|sql|json|ya?ml|toml|md|html|css|vue)\b`,
+	`[\w.-]+/[\w.-]+/`,
+	`(?i)\b(npm|pnpm|yarn|go|pytest|cargo|mvn|gradle|docker|kubectl|git|make)\s+\w`,
+	`(?i)\b(file|folder|directory|repo|project|code|function|bug|error|exception|stack\s*trace|compile|build|test|refactor|debug|commit|branch|run|install|deploy|fix|edit|implement)`,
+	`代码|文件|目录|项目|仓库|函数|报错|错误|异常|编译|构建|测试|重构|调试|提交|分支|运行|安装|部署|修复|修改|实现`,
+}
+
+var toolGatePatternCache sync.Map // joined patterns -> []*regexp.Regexp
+
+// toolGateCodePatterns compiles the configured code patterns once per
+// distinct configuration. Invalid patterns are logged and skipped.
+func toolGateCodePatterns(patterns []string) []*regexp.Regexp {
+	if len(patterns) == 0 {
+		patterns = defaultToolGateCodePatterns
+	}
+	cacheKey := strings.Join(patterns, "\x00")
+	if cached, ok := toolGatePatternCache.Load(cacheKey); ok {
+		return cached.([]*regexp.Regexp)
+	}
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			slog.Warn("tool_gate: ignoring invalid code pattern", "pattern", p, "error", err)
+			continue
+		}
+		compiled = append(compiled, re)
+	}
+	toolGatePatternCache.Store(cacheKey, compiled)
+	return compiled
+}
+
+// toolGateMaxChars returns the short-request threshold for key: its own
+// tool_gate_max_chars, else the configured one. Zero or less disables the gate.
+func (h *Handler) toolGateMaxChars(key *store.ApiKey) int {
+	if key != nil && key.ToolGateMaxChars != 0 {
+		return key.ToolGateMaxChars
+	}
+	return h.config.ToolGateMaxChars
+}
+
+// isShortNonCodeRequest reports whether the last user turn is a short
+// message with no code signal in a conversation that has not used tools yet.
+func (h *Handler) isShortNonCodeRequest(messages []prompt.Message, key *store.ApiKey) bool {
+	maxChars := h.toolGateMaxChars(key)
+	if maxChars <= 0 {
+		return false
+	}
+	text := ""
+	for i := len(messages) - 1; i >= 0; i-- {
+		msg := messages[i]
+		if msg.Role == "user" && text == "" {
+			text = strings.TrimSpace(stripSystemRemindersForMode(msg.ExtractText()))
+			if text == "" {
+				return false
+			}
+		}
+		if msg.Content.IsString() {
+			continue
+		}
+		for _, block := range msg.Content.GetBlocks() {
+			if block.Type == "tool_use" || block.Type == "tool_result" {
+				return false
+			}
+		}
+	}
+	if text == "" || utf8.RuneCountInString(text) > maxChars {
+		return false
+	}
+	for _, re := range toolGateCodePatterns(h.config.ToolGateCodePatterns) {
+		if re.MatchString(text) {
+			return false
+		}
+	}
+	return true
+}
+
+// allowedGateTools returns the tools that stay available on a gated request.
+func allowedGateTools(tools []interface{}, allow []string) []interface{} {
+	if len(allow) == 0 {
+		return nil
+	}
+	var kept []interface{}
+	for _, tool := range tools {
+		name, _ := toolIdentity(tool)
+		for _, a := range allow {
+			if strings.EqualFold(strings.TrimSpace(a), name) {
+				kept = append(kept, tool)
+				break
+			}
+		}
+	}
+	return kept
+}
+
+// restrictedToolGate is the gate note when only allowlisted tools remain.
+func restrictedToolGate(tools []interface{}) string {
+	names := make([]string, 0, len(tools))
+	for _, tool := range tools {
+		name, _ := toolIdentity(tool)
+		names = append(names, name)
+	}
+	return "This is a short, non-code request. Do NOT perform file operations; answer directly unless it needs " + strings.Join(names, ", ") + "."
+}
@@ -0,0 +1,106 @@
+package handler
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/goccy/go-json"
+
+	"orchids-api/internal/config"
+	"orchids-api/internal/prompt"
+	"orchids-api/internal/store"
+	"orchids-api/internal/upstream"
+)
+
+func TestIsShortNonCodeRequest(t *testing.T) {
+	user := func(text string) []prompt.Message {
+		return []prompt.Message{{Role: "user", Content: prompt.MessageContent{Text: text}}}
+	}
+	toolHistory := []prompt.Message{
+		{Role: "user", Content: prompt.MessageContent{Text: "list files"}},
+		{Role: "assistant", Content: prompt.MessageContent{Blocks: []prompt.ContentBlock{{Type: "tool_use", ID: "t1", Name: "Bash"}}}},
+		{Role: "user", Content: prompt.MessageContent{Blocks: []prompt.ContentBlock{{Type: "tool_result", ToolUseID: "t1", Content: "a.txt"}}}},
+		{Role: "assistant", Content: prompt.MessageContent{Text: "done"}},
+		{Role: "user", Content: prompt.MessageContent{Text: "thanks!"}},
+	}
+	cases := []struct {
+		name     string
+		cfg      config.Config
+		key      *store.ApiKey
+		messages []prompt.Message
+		want     bool
+	}{
+		{"disabled by default", config.Config{}, nil, user("hello there"), false},
+		{"short chat", config.Config{ToolGateMaxChars: 40}, nil, user("hello there"), true},
+		{"system reminders ignored", config.Config{ToolGateMaxChars: 20}, nil, user("<system-reminder>" + strings.Repeat("x", 100) + "</system-reminder>hi"), true},
+		{"too long", config.Config{ToolGateMaxChars: 5}, nil, user("hello there"), false},
+		{"code signal", config.Config{ToolGateMaxChars: 40}, nil, user("fix the bug in main.go"), false},
+		{"chinese code signal", config.Config{ToolGateMaxChars: 40}, nil, user("帮我看看这个报错"), false},
+		{"custom patterns", config.Config{ToolGateMaxChars: 40, ToolGateCodePatterns: []string{`(?i)weather`}}, nil, user("fix the bug"), true},
+		{"agentic conversation", config.Config{ToolGateMaxChars: 40}, nil, toolHistory, false},
+		{"key override enables", config.Config{}, &store.ApiKey{ToolGateMaxChars: 40}, user("hello there"), true},
+		{"key override disables", config.Config{ToolGateMaxChars: 40}, &store.ApiKey{ToolGateMaxChars: -1}, user("hello there"), false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := &Handler{config: &tc.cfg}
+			if got := h.isShortNonCodeRequest(tc.messages, tc.key); got != tc.want {
+				t.Fatalf("got %v want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestHandleMessages_ShortRequestToolGate(t *testing.T) {
+	reply := [][]upstream.SSEMessage{{
+		{Type: "model", Event: map[string]any{"type": "text-start"}},
+		{Type: "model", Event: map[string]any{"type": "text-delta", "delta": "hi"}},
+		{Type: "model", Event: map[string]any{"type": "text-end"}},
+		{Type: "model", Event: map[string]any{"type": "finish", "finishReason": "stop"}},
+	}}
+	tools := []map[string]any{
+		{"name": "Bash", "input_schema": map[string]any{"type": "object"}},
+		{"name": "WebFetch", "input_schema": map[string]any{"type": "object"}},
+	}
+	cases := []struct {
+		name    string
+		allow   []string
+		noTools bool
+		kept    []string
+	}{
+		{"all tools dropped", nil, true, nil},
+		{"allowlisted tool kept", []string{"webfetch"}, false, []string{"WebFetch"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := &config.Config{DebugEnabled: false, RequestTimeout: 10, ContextMaxTokens: 1024, ContextSummaryMaxTokens: 256, ContextKeepTurns: 2,
+				ToolGateMaxChars: 40, ToolGateAllowTools: tc.allow}
+			h := NewWithLoadBalancer(cfg, nil)
+			up := &roundsUpstream{rounds: reply}
+			h.client = up
+			b, _ := json.Marshal(map[string]any{
+				"model":    "claude-3-5-sonnet",
+				"messages": []map[string]any{{"role": "user", "content": "hello, how are you?"}},
+				"tools":    tools,
+				"stream":   false,
+			})
+			h.HandleMessages(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "http://x/warp/v1/messages", bytes.NewReader(b)))
+
+			if len(up.reqs) != 1 {
+				t.Fatalf("upstream calls=%d", len(up.reqs))
+			}
+			req := up.reqs[0]
+			var kept []string
+			for _, tool := range req.Tools {
+				name, _ := toolIdentity(tool)
+				kept = append(kept, name)
+			}
+			if req.NoTools != tc.noTools || len(kept) != len(tc.kept) || (len(kept) > 0 && kept[0] != tc.kept[0]) {
+				t.Fatalf("no_tools=%v tools=%v", req.NoTools, kept)
+			}
+		})
+	}
+}
@@ -0,0 +1,342 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/goccy/go-json"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"orchids-api/internal/config"
+	"orchids-api/internal/perf"
+	"orchids-api/internal/prompt"
+	"orchids-api/internal/tokencache"
+	"orchids-api/internal/upstream"
+)
+
+const (
+	defaultToolResultMaxBytes = 1800
+	// Results are cut down with head_tail before being sent for summarizing.
+	toolResultSummaryInputMax = 64 * 1024
+	toolResultSummaryTimeout  = 30 * time.Second
+	// Upstream summaries per request; further results fall back to head_tail.
+	maxToolResultSummaries = 4
+)
+
+// toolResultSummaryPrompt asks upstream to condense one tool result.
+const toolResultSummaryPrompt = "Condense the following output of the %q tool to at most %d characters. Keep error messages, failing commands, file paths, line numbers and identifiers verbatim; drop repetitive or irrelevant lines. Reply with the condensed output only.\n\n"
+
+// jsonPruneSteps are the string and array lengths json_prune tries in turn
+// until the pruned result fits.
+var jsonPruneSteps = []struct{ strLen, arrLen int }{
+	{400, 20},
+	{200, 10},
+	{80, 5},
+	{40, 3},
+}
+
+// toolResultSummaryCache keeps upstream summaries by content, since the same
+// tool result is resent with every later turn of the conversation.
+var toolResultSummaryCache = perf.NewTTLCache(30*time.Minute, 1024)
+
+// toolResultSummarizer condenses a tool result to about maxLen bytes.
+type toolResultSummarizer func(toolName, content string, maxLen int) (string, error)
+
+// toolResultCompression picks how tool_result blocks of one request are
+// compressed: the tool_result_compression rule for the channel and tool,
+// else truncation to maxLen.
+type toolResultCompression struct {
+	cfg       *config.Config
+	channel   string
+	maxLen    int                  // used when no rule sets max_bytes
+	summarize toolResultSummarizer // nil makes "summarize" fall back to head_tail
+}
+
+// ruleFor returns the strategy and size limit for results of tool.
+func (c toolResultCompression) ruleFor(tool string) (string, int) {
+	strategy, maxLen := "truncate", c.maxLen
+	if rule, ok := c.cfg.ToolResultCompressionFor(c.channel, tool); ok {
+		if s := strings.ToLower(strings.TrimSpace(rule.Strategy)); s != "" {
+			strategy = s
+		}
+		if rule.MaxBytes > 0 {
+			maxLen = rule.MaxBytes
+		}
+	}
+	if maxLen <= 0 {
+		maxLen = defaultToolResultMaxBytes
+	}
+	return strategy, maxLen
+}
+
+func compressToolResults(messages []prompt.Message, c toolResultCompression) ([]prompt.Message, int) {
+	compressed := cloneMessages(messages)
+	compressedCount := 0
+	summaries := 0
+	names := toolUseNames(messages)
+
+	for i := range compressed {
+		msg := &compressed[i]
+		if msg.Role != "user" || msg.Content.Blocks == nil {
+			continue
+		}
+
+		for j := range msg.Content.Blocks {
+			block := &msg.Content.Blocks[j]
+			if block.Type != "tool_result" {
+				continue
+			}
+			tool := names[block.ToolUseID]
+			strategy, maxLen := c.ruleFor(tool)
+			if out, ok := c.compressContent(block.Content, tool, strategy, maxLen, &summaries); ok {
+				block.Content = out
+				compressedCount++
+			}
+		}
+	}
+
+	if compressedCount > 0 {
+		slog.Info("Context compressed", "channel", c.channel, "compressed_blocks", compressedCount, "summaries", summaries)
+	}
+
+	return compressed, compressedCount
+}
+
+// compressContent returns the compressed form of one tool_result content, or
+// false when it already fits maxLen.
+func (c toolResultCompression) compressContent(content interface{}, tool, strategy string, maxLen int, summaries *int) (string, bool) {
+	var raw string
+	switch v := content.(type) {
+	case string:
+		raw = v
+	case []interface{}:
+		// tool_result content can be []ContentBlock (decoded as []interface{})
+		// Serialize to measure total size
+		b, err := json.Marshal(v)
+		if err != nil {
+			return "", false
+		}
+		raw = string(b)
+	default:
+		return "", false
+	}
+	if len(raw) <= maxLen {
+		return "", false
+	}
+
+	switch strategy {
+	case "head_tail":
+		return headTailToolResult(toolResultText(content, raw), maxLen), true
+	case "json_prune":
+		return pruneToolResultJSON(toolResultText(content, raw), maxLen), true
+	case "summarize":
+		text := toolResultText(content, raw)
+		if out, ok := c.summarizeContent(tool, text, maxLen, summaries); ok {
+			return out, true
+		}
+		return headTailToolResult(text, maxLen), true
+	default:
+		cutPoint := truncateUTF8(raw, maxLen)
+		return raw[:cutPoint] + fmt.Sprintf("\n... [truncated %d bytes]", len(raw)-cutPoint), true
+	}
+}
+
+func (c toolResultCompression) summarizeContent(tool, text string, maxLen int, summaries *int) (string, bool) {
+	if c.summarize == nil {
+		return "", false
+	}
+	key := tokencache.CacheKey("", tool, text) + ":" + strconv.Itoa(maxLen)
+	if v, _, ok := toolResultSummaryCache.Get(key); ok {
+		return v.(string), true
+	}
+	if *summaries >= maxToolResultSummaries {
+		return "", false
+	}
+	*summaries++
+	summary, err := c.summarize(tool, headTailToolResult(text, toolResultSummaryInputMax), maxLen)
+	summary = strings.TrimSpace(summary)
+	if err != nil || summary == "" {
+		slog.Warn("Tool result summary failed, using head_tail", "channel", c.channel, "tool", tool, "error", err)
+		return "", false
+	}
+	out := fmt.Sprintf("[summarized %d bytes] %s", len(text), summary)
+	if len(out) > maxLen {
+		out = headTailToolResult(out, maxLen)
+	}
+	toolResultSummaryCache.Set(key, out)
+	return out, true
+}
+
+// toolUseNames maps tool_use IDs to tool names so results can be matched
+// against per-tool rules.
+func toolUseNames(messages []prompt.Message) map[string]string {
+	names := make(map[string]string)
+	for _, msg := range messages {
+		for _, block := range msg.Content.Blocks {
+			if block.Type == "tool_use" && block.ID != "" {
+				names[block.ID] = block.Name
+			}
+		}
+	}
+	return names
+}
+
+// toolResultText flattens block content to its text blocks; raw (the
+// serialized content) is used when there are none.
+func toolResultText(content interface{}, raw string) string {
+	blocks, ok := content.([]interface{})
+	if !ok {
+		return raw
+	}
+	parts := make([]string, 0, len(blocks))
+	for _, item := range blocks {
+		m, ok := item.(map[string]interface{})
+		if !ok || m["type"] != "text" {
+			continue
+		}
+		if text, ok := m["text"].(string); ok {
+			parts = append(parts, text)
+		}
+	}
+	if len(parts) == 0 {
+		return raw
+	}
+	return strings.Join(parts, "\n")
+}
+
+// headTailToolResult keeps the first 40% and the last 60% of text, cut at
+// line breaks where possible, so trailing error messages survive.
+func headTailToolResult(text string, maxLen int) string {
+	if len(text) <= maxLen {
+		return text
+	}
+	const markerReserve = 40
+	budget := maxLen - markerReserve
+	if budget <= 0 {
+		return text[:truncateUTF8(text, maxLen)]
+	}
+	headLen := truncateUTF8(text, budget*2/5)
+	if nl := strings.LastIndexByte(text[:headLen], '\n'); nl > headLen*4/5 {
+		headLen = nl + 1
+	}
+	tailStart := len(text) - (budget - headLen)
+	for tailStart < len(text) && !utf8.RuneStart(text[tailStart]) {
+		tailStart++
+	}
+	if nl := strings.IndexByte(text[tailStart:], '\n'); nl >= 0 && nl < (len(text)-tailStart)/5 {
+		tailStart += nl + 1
+	}
+	return text[:headLen] + fmt.Sprintf("\n... [omitted %d bytes] ...\n", tailStart-headLen) + text[tailStart:]
+}
+
+// pruneToolResultJSON shortens long strings and arrays of a JSON result,
+// tightening the limits until it fits maxLen. Non-JSON text and results that
+// do not fit even at the tightest limits fall back to head_tail.
+func pruneToolResultJSON(text string, maxLen int) string {
+	var v interface{}
+	if err := json.Unmarshal([]byte(text), &v); err != nil {
+		return headTailToolResult(text, maxLen)
+	}
+	pruned := text
+	for _, step := range jsonPruneSteps {
+		raw, err := json.Marshal(pruneJSONValue(v, step.strLen, step.arrLen))
+		if err != nil {
+			break
+		}
+		pruned = string(raw)
+		if len(pruned) <= maxLen {
+			return pruned
+		}
+	}
+	return headTailToolResult(pruned, maxLen)
+}
+
+func pruneJSONValue(v interface{}, strLen, arrLen int) interface{} {
+	switch val := v.(type) {
+	case string:
+		if n := utf8.RuneCountInString(val); n > strLen {
+			return string([]rune(val)[:strLen]) + fmt.Sprintf("…[+%d chars]", n-strLen)
+		}
+		return val
+	case []interface{}:
+		keep := val
+		if len(val) > arrLen {
+			keep = val[:arrLen]
+		}
+		out := make([]interface{}, 0, len(keep)+1)
+		for _, item := range keep {
+			out = append(out, pruneJSONValue(item, strLen, arrLen))
+		}
+		if len(val) > arrLen {
+			out = append(out, fmt.Sprintf("… %d more items", len(val)-arrLen))
+		}
+		return out
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(val))
+		for k, item := range val {
+			if item == nil {
+				continue
+			}
+			out[k] = pruneJSONValue(item, strLen, arrLen)
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+// toolResultCompression returns the compression settings for this request
+// on its current channel.
+func (p *messagesPipeline) toolResultCompression() toolResultCompression {
+	c := toolResultCompression{
+		cfg:     p.h.config,
+		channel: p.toolChannel(),
+	}
+	// A dry run must not call upstream; "summarize" falls back to head_tail.
+	if !p.dryRun {
+		c.summarize = p.summarizeToolResult
+	}
+	return c
+}
+
+// summarizeToolResult asks the current upstream client to condense a tool
+// result, collecting the streamed text of a tool-less request.
+func (p *messagesPipeline) summarizeToolResult(toolName, content string, maxLen int) (string, error) {
+	sender, ok := p.apiClient.(UpstreamPayloadClient)
+	if !ok {
+		return "", errors.New("upstream client cannot summarize tool results")
+	}
+	if toolName == "" {
+		toolName = "unknown_tool"
+	}
+	ctx, cancel := context.WithTimeout(p.ctx, toolResultSummaryTimeout)
+	defer cancel()
+
+	instruction := fmt.Sprintf(toolResultSummaryPrompt, toolName, maxLen)
+	req := upstream.UpstreamRequest{
+		Prompt: instruction,
+		Model:  p.mappedModel,
+		Messages: []prompt.Message{{
+			Role:    "user",
+			Content: prompt.MessageContent{Text: instruction + content},
+		}},
+		NoTools:    true,
+		NoThinking: true,
+		Workdir:    p.workdir,
+	}
+	var sb strings.Builder
+	err := sender.SendRequestWithPayload(ctx, req, func(msg upstream.SSEMessage) {
+		switch msg.EventKey() {
+		case "model.text-delta", "coding_agent.output_text.delta":
+			sb.WriteString(msg.AsTextDelta().Delta)
+		}
+	}, nil)
+	if err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
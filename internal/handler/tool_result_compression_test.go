@@ -0,0 +1,139 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"orchids-api/internal/config"
+	"orchids-api/internal/prompt"
+)
+
+func toolResultMessages(tool, result string) []prompt.Message {
+	return []prompt.Message{
+		{Role: "assistant", Content: prompt.MessageContent{Blocks: []prompt.ContentBlock{
+			{Type: "tool_use", ID: "toolu_1", Name: tool},
+		}}},
+		{Role: "user", Content: prompt.MessageContent{Blocks: []prompt.ContentBlock{
+			{Type: "tool_result", ToolUseID: "toolu_1", Content: result},
+		}}},
+	}
+}
+
+func compressedResult(t *testing.T, messages []prompt.Message) string {
+	t.Helper()
+	s, ok := messages[1].Content.Blocks[0].Content.(string)
+	if !ok {
+		t.Fatalf("expected string tool_result, got %T", messages[1].Content.Blocks[0].Content)
+	}
+	return s
+}
+
+func TestCompressToolResults_DefaultTruncates(t *testing.T) {
+	t.Parallel()
+
+	messages := toolResultMessages("Bash", strings.Repeat("x", 3000))
+	out, count := compressToolResults(messages, toolResultCompression{channel: "warp"})
+	if count != 1 {
+		t.Fatalf("count=%d want 1", count)
+	}
+	got := compressedResult(t, out)
+	if !strings.HasPrefix(got, strings.Repeat("x", defaultToolResultMaxBytes)) || !strings.Contains(got, "[truncated 1200 bytes]") {
+		t.Fatalf("unexpected truncation: %q", got[len(got)-40:])
+	}
+	if compressedResult(t, messages) != strings.Repeat("x", 3000) {
+		t.Fatal("input messages must not be modified")
+	}
+}
+
+func TestCompressToolResults_HeadTailKeepsTrailingError(t *testing.T) {
+	t.Parallel()
+
+	var sb strings.Builder
+	for i := 0; i < 200; i++ {
+		fmt.Fprintf(&sb, "compiling module %03d\n", i)
+	}
+	sb.WriteString("error: undefined reference to `main'")
+	cfg := &config.Config{ToolResultCompression: []config.ToolResultCompressionRule{
+		{Channel: "warp", Tool: "bash", Strategy: "head_tail", MaxBytes: 600},
+	}}
+
+	out, count := compressToolResults(toolResultMessages("Bash", sb.String()), toolResultCompression{cfg: cfg, channel: "warp"})
+	got := compressedResult(t, out)
+	if count != 1 || len(got) > 600 {
+		t.Fatalf("count=%d len=%d", count, len(got))
+	}
+	if !strings.HasPrefix(got, "compiling module 000") || !strings.HasSuffix(got, "undefined reference to `main'") {
+		t.Fatalf("head or tail missing: %q", got)
+	}
+	if !strings.Contains(got, "omitted") {
+		t.Fatalf("expected omission marker: %q", got)
+	}
+}
+
+func TestCompressToolResults_JSONPrune(t *testing.T) {
+	t.Parallel()
+
+	items := make([]string, 0, 50)
+	for i := 0; i < 50; i++ {
+		items = append(items, fmt.Sprintf(`{"id":%d,"body":%q,"extra":null}`, i, strings.Repeat("b", 300)))
+	}
+	result := `{"status":"error","message":"rate limited","items":[` + strings.Join(items, ",") + `]}`
+	cfg := &config.Config{ToolResultCompression: []config.ToolResultCompressionRule{
+		{Tool: "WebFetch", Strategy: "json_prune", MaxBytes: 1500},
+	}}
+
+	out, _ := compressToolResults(toolResultMessages("WebFetch", result), toolResultCompression{cfg: cfg, channel: "orchids"})
+	got := compressedResult(t, out)
+	if len(got) > 1500 {
+		t.Fatalf("len=%d want <= 1500", len(got))
+	}
+	if !strings.Contains(got, `"message":"rate limited"`) || !strings.Contains(got, "more items") {
+		t.Fatalf("unexpected pruned JSON: %s", got)
+	}
+	if strings.Contains(got, "extra") {
+		t.Fatalf("null fields should be dropped: %s", got)
+	}
+}
+
+func TestCompressToolResults_SummarizeFallsBackToHeadTail(t *testing.T) {
+	t.Parallel()
+
+	cfg := &config.Config{ToolResultCompression: []config.ToolResultCompressionRule{
+		{Strategy: "summarize", MaxBytes: 500},
+	}}
+	result := strings.Repeat("line\n", 400) + "FATAL: disk full"
+	calls := 0
+	summarize := func(tool, content string, maxLen int) (string, error) {
+		calls++
+		if tool != "Bash" || maxLen != 500 {
+			t.Errorf("tool=%q maxLen=%d", tool, maxLen)
+		}
+		return "", errors.New("upstream unavailable")
+	}
+
+	out, _ := compressToolResults(toolResultMessages("Bash", result), toolResultCompression{cfg: cfg, channel: "warp", summarize: summarize})
+	got := compressedResult(t, out)
+	if calls != 1 {
+		t.Fatalf("calls=%d want 1", calls)
+	}
+	if !strings.HasSuffix(got, "FATAL: disk full") {
+		t.Fatalf("expected head_tail fallback, got %q", got)
+	}
+
+	summarize = func(tool, content string, maxLen int) (string, error) {
+		calls++
+		return "400 blank lines, then FATAL: disk full", nil
+	}
+	c := toolResultCompression{cfg: cfg, channel: "warp", summarize: summarize}
+	for i := 0; i < 2; i++ {
+		out, _ = compressToolResults(toolResultMessages("Bash", result+" (2)"), c)
+	}
+	if got := compressedResult(t, out); !strings.HasPrefix(got, "[summarized") || !strings.Contains(got, "FATAL: disk full") {
+		t.Fatalf("unexpected summary: %q", got)
+	}
+	if calls != 2 {
+		t.Fatalf("calls=%d want 2 (second summary served from cache)", calls)
+	}
+}
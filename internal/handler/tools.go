@@ -6,12 +6,21 @@ import (
 	"orchids-api/internal/perf"
 )
 
+// shortRequestToolGate is injected into the prompt of short, non-code requests.
+const shortRequestToolGate = "This is a short, non-code request. Do NOT call tools or perform any file operations. Answer directly."
+
 func injectToolGate(promptText string, message string) string {
+	return injectPromptSection(promptText, "tool_gate", message)
+}
+
+// injectPromptSection inserts message wrapped in <tag> before the user
+// request, or appends it when the prompt has no user marker.
+func injectPromptSection(promptText, tag, message string) string {
 	message = strings.TrimSpace(message)
 	if message == "" {
 		return promptText
 	}
-	section := "<tool_gate>\n" + message + "\n</tool_gate>\n\n"
+	section := "<" + tag + ">\n" + message + "\n</" + tag + ">\n\n"
 	_, idx := findUserMarker(promptText)
 
 	sb := perf.AcquireStringBuilder()
@@ -0,0 +1,130 @@
+package handler
+
+import (
+	"strconv"
+	"time"
+
+	"orchids-api/internal/config"
+	"orchids-api/internal/store"
+)
+
+const (
+	tpmWindowSeconds = 60
+	tpmIdleTTL       = 10 * time.Minute
+)
+
+// tpmWindow is a one-minute sliding window of token usage in one-second buckets.
+type tpmWindow struct {
+	buckets  [tpmWindowSeconds]int64
+	seconds  [tpmWindowSeconds]int64 // unix second each bucket currently holds
+	lastSeen int64
+}
+
+func (w *tpmWindow) add(now, tokens int64) {
+	i := now % tpmWindowSeconds
+	if w.seconds[i] != now {
+		w.seconds[i] = now
+		w.buckets[i] = 0
+	}
+	w.buckets[i] += tokens
+	w.lastSeen = now
+}
+
+func (w *tpmWindow) sum(now int64) int64 {
+	var total int64
+	for i := range w.buckets {
+		if now-w.seconds[i] < tpmWindowSeconds {
+			total += w.buckets[i]
+		}
+	}
+	if total < 0 {
+		return 0
+	}
+	return total
+}
+
+// TPMLimiter tracks tokens per minute for API keys and accounts. Usage is fed
+// from the handler's own token accounting: the estimated input tokens when a
+// request is sent and the remainder once the response completes.
+type TPMLimiter struct {
+	windows *ShardedMap[tpmWindow]
+	cleaner *AsyncCleaner
+}
+
+func NewTPMLimiter() *TPMLimiter {
+	l := &TPMLimiter{windows: NewShardedMap[tpmWindow]()}
+	l.cleaner = NewAsyncCleaner(tpmIdleTTL)
+	l.cleaner.Start(func() {
+		now := time.Now().Unix()
+		l.windows.RangeDelete(func(_ string, w tpmWindow) bool {
+			return now-w.lastSeen > int64(tpmIdleTTL/time.Second)
+		})
+	})
+	return l
+}
+
+// Used returns the tokens recorded for key during the last minute.
+func (l *TPMLimiter) Used(key string) int64 {
+	if l == nil || key == "" {
+		return 0
+	}
+	w, ok := l.windows.Get(key)
+	if !ok {
+		return 0
+	}
+	return w.sum(time.Now().Unix())
+}
+
+// Allow reports whether key is still under limit; limit <= 0 disables the check.
+func (l *TPMLimiter) Allow(key string, limit int) bool {
+	return limit <= 0 || l.Used(key) < int64(limit)
+}
+
+// Record adds tokens to key's window. Negative values correct an earlier
+// over-estimate.
+func (l *TPMLimiter) Record(key string, tokens int) {
+	if l == nil || key == "" || tokens == 0 {
+		return
+	}
+	now := time.Now().Unix()
+	l.windows.Compute(key, func(w tpmWindow, _ bool) (tpmWindow, bool) {
+		w.add(now, int64(tokens))
+		return w, true
+	})
+}
+
+func tpmKeyScope(scope string) string {
+	if scope == "" || scope == anonymousKeyScope {
+		return ""
+	}
+	return "key:" + scope
+}
+
+func tpmAccount(accountID int64) string {
+	if accountID == 0 {
+		return ""
+	}
+	return "account:" + strconv.FormatInt(accountID, 10)
+}
+
+// keyTPMLimit returns the TPM cap for an API key: its own tpm_limit, else key_tpm_limit.
+func keyTPMLimit(key *store.ApiKey, cfg *config.Config) int {
+	if key != nil && key.TPMLimit > 0 {
+		return key.TPMLimit
+	}
+	if cfg == nil {
+		return 0
+	}
+	return cfg.KeyTPMLimit
+}
+
+// accountTPMLimit returns the TPM cap for an account: its own tpm_limit, else account_tpm_limit.
+func accountTPMLimit(acc *store.Account, cfg *config.Config) int {
+	if acc != nil && acc.TPMLimit > 0 {
+		return acc.TPMLimit
+	}
+	if cfg == nil {
+		return 0
+	}
+	return cfg.AccountTPMLimit
+}
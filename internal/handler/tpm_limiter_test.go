@@ -0,0 +1,66 @@
+package handler
+
+import (
+	"testing"
+
+	"orchids-api/internal/config"
+	"orchids-api/internal/store"
+)
+
+func TestTPMWindowExpiresOldBuckets(t *testing.T) {
+	var w tpmWindow
+	w.add(1000, 40)
+	w.add(1030, 20)
+	if got := w.sum(1030); got != 60 {
+		t.Fatalf("sum=%d want 60", got)
+	}
+	if got := w.sum(1060); got != 20 {
+		t.Fatalf("sum after first bucket expired=%d want 20", got)
+	}
+	// The same slot reused a minute later must start from zero.
+	w.add(1090, 5)
+	if got := w.sum(1090); got != 5 {
+		t.Fatalf("sum after slot reuse=%d want 5", got)
+	}
+}
+
+func TestTPMLimiterAllowAndCorrection(t *testing.T) {
+	l := NewTPMLimiter()
+	if !l.Allow("key:a", 100) {
+		t.Fatal("empty window should be allowed")
+	}
+	l.Record("key:a", 120)
+	if l.Allow("key:a", 100) {
+		t.Fatal("key over its limit should be rejected")
+	}
+	if !l.Allow("key:b", 100) {
+		t.Fatal("other keys must not share the window")
+	}
+	if !l.Allow("key:a", 0) {
+		t.Fatal("limit 0 disables the check")
+	}
+	l.Record("key:a", -30)
+	if got := l.Used("key:a"); got != 90 {
+		t.Fatalf("used after correction=%d want 90", got)
+	}
+	l.Record("", 1000)
+	if got := l.Used(""); got != 0 {
+		t.Fatalf("anonymous usage must not be tracked, got %d", got)
+	}
+}
+
+func TestTPMLimitOverrides(t *testing.T) {
+	cfg := &config.Config{KeyTPMLimit: 1000, AccountTPMLimit: 5000}
+	if got := keyTPMLimit(nil, cfg); got != 1000 {
+		t.Fatalf("key default=%d want 1000", got)
+	}
+	if got := keyTPMLimit(&store.ApiKey{TPMLimit: 50}, cfg); got != 50 {
+		t.Fatalf("key override=%d want 50", got)
+	}
+	if got := accountTPMLimit(&store.Account{}, cfg); got != 5000 {
+		t.Fatalf("account default=%d want 5000", got)
+	}
+	if got := accountTPMLimit(&store.Account{TPMLimit: 7}, cfg); got != 7 {
+		t.Fatalf("account override=%d want 7", got)
+	}
+}
@@ -54,6 +54,9 @@ func channelFromPath(path string) string {
 	if strings.HasPrefix(path, "/warp/") {
 		return "warp"
 	}
+	if strings.HasPrefix(path, "/kiro/") {
+		return "kiro"
+	}
 	if strings.HasPrefix(path, "/grok/v1/") {
 		return "grok"
 	}
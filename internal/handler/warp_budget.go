@@ -3,10 +3,14 @@ package handler
 import (
 	"fmt"
 	"github.com/goccy/go-json"
+	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
 
+	"orchids-api/internal/perf"
 	"orchids-api/internal/prompt"
-	"orchids-api/internal/tiktoken"
+	"orchids-api/internal/tokencache"
 )
 
 const (
@@ -30,7 +34,7 @@ type warpTokenBreakdown struct {
 	Total          int
 }
 
-func enforceWarpBudget(builtPrompt string, messages []prompt.Message, maxTokens int) (trimmed []prompt.Message, before warpTokenBreakdown, after warpTokenBreakdown, compressedBlocks int, summarizedMessages int, droppedMessages int) {
+func enforceWarpBudget(builtPrompt string, messages []prompt.Message, maxTokens int, compression toolResultCompression) (trimmed []prompt.Message, before warpTokenBreakdown, after warpTokenBreakdown, compressedBlocks int, summarizedMessages int, droppedMessages int) {
 	budget := maxTokens
 	if budget <= 0 {
 		budget = 12000
@@ -38,13 +42,20 @@ func enforceWarpBudget(builtPrompt string, messages []prompt.Message, maxTokens
 	if budget > 12000 {
 		budget = 12000
 	}
+	// The prompt is fixed for the whole call and each message part is
+	// estimated through the block cache, so the repeated breakdowns below
+	// only scan parts that compression or summarizing actually changed.
+	promptTokens := estimateBlockTokens(builtPrompt)
 	if len(messages) == 0 {
-		empty := estimateWarpTokensBreakdown(builtPrompt, nil)
+		empty := estimateWarpTokensBreakdown(promptTokens, nil)
 		return nil, empty, empty, 0, 0, 0
 	}
 
 	// Stage 1: tool_result compression.
-	compressed, compressedCount := compressToolResults(messages, 1800, "warp")
+	if compression.channel == "" {
+		compression.channel = "warp"
+	}
+	compressed, compressedCount := compressToolResults(messages, compression)
 	working := compressed
 
 	// Stage 2: compress long text blocks/messages.
@@ -53,7 +64,7 @@ func enforceWarpBudget(builtPrompt string, messages []prompt.Message, maxTokens
 		compressedCount += count
 	}
 
-	beforeBD := estimateWarpTokensBreakdown(builtPrompt, working)
+	beforeBD := estimateWarpTokensBreakdown(promptTokens, working)
 	if beforeBD.Total <= budget {
 		return working, beforeBD, beforeBD, compressedCount, 0, 0
 	}
@@ -77,7 +88,7 @@ func enforceWarpBudget(builtPrompt string, messages []prompt.Message, maxTokens
 		}
 		working = next
 		summarizedMessages += merged
-		beforeBD = estimateWarpTokensBreakdown(builtPrompt, working)
+		beforeBD = estimateWarpTokensBreakdown(promptTokens, working)
 		if beforeBD.Total <= budget {
 			return working, beforeBD, beforeBD, compressedCount, summarizedMessages, 0
 		}
@@ -90,7 +101,7 @@ func enforceWarpBudget(builtPrompt string, messages []prompt.Message, maxTokens
 	if harder, count := compressWarpMessages(working, warpMessageHardLimit); count > 0 {
 		working = harder
 		compressedCount += count
-		beforeBD = estimateWarpTokensBreakdown(builtPrompt, working)
+		beforeBD = estimateWarpTokensBreakdown(promptTokens, working)
 		if beforeBD.Total <= budget {
 			return working, beforeBD, beforeBD, compressedCount, summarizedMessages, 0
 		}
@@ -115,7 +126,7 @@ func enforceWarpBudget(builtPrompt string, messages []prompt.Message, maxTokens
 	start := 0
 	for start < lastUser && len(work[start:]) > 1 {
 		testMsgs := work[start+1:]
-		bd := estimateWarpTokensBreakdown(builtPrompt, testMsgs)
+		bd := estimateWarpTokensBreakdown(promptTokens, testMsgs)
 		if bd.Total <= budget {
 			start++
 			break
@@ -126,28 +137,28 @@ func enforceWarpBudget(builtPrompt string, messages []prompt.Message, maxTokens
 	if len(trimmed) == 0 {
 		trimmed = work[len(work)-1:]
 	}
-	afterTokens := estimateWarpTokensBreakdown(builtPrompt, trimmed)
+	afterTokens := estimateWarpTokensBreakdown(promptTokens, trimmed)
 	return trimmed, beforeTokens, afterTokens, compressedCount, summarizedMessages, start
 }
 
-func estimateWarpTokensBreakdown(builtPrompt string, messages []prompt.Message) warpTokenBreakdown {
+func estimateWarpTokensBreakdown(promptTokens int, messages []prompt.Message) warpTokenBreakdown {
 	bd := warpTokenBreakdown{}
-	bd.PromptTokens = tiktoken.EstimateTextTokens(builtPrompt)
+	bd.PromptTokens = promptTokens
 	// Conservative wrapper overhead.
 	overhead := 200
 
 	for _, m := range messages {
 		if m.Content.IsString() {
-			bd.MessagesTokens += tiktoken.EstimateTextTokens(strings.TrimSpace(m.Content.GetText())) + 15
+			bd.MessagesTokens += estimateBlockTokens(strings.TrimSpace(m.Content.GetText())) + 15
 			continue
 		}
 		for _, b := range m.Content.GetBlocks() {
 			switch b.Type {
 			case "text":
-				bd.MessagesTokens += tiktoken.EstimateTextTokens(strings.TrimSpace(b.Text)) + 10
+				bd.MessagesTokens += estimateBlockTokens(strings.TrimSpace(b.Text)) + 10
 			case "tool_result":
 				if s, ok := b.Content.(string); ok {
-					bd.ToolTokens += tiktoken.EstimateTextTokens(s) + 10
+					bd.ToolTokens += estimateBlockTokens(s) + 10
 				} else {
 					bd.ToolTokens += 200
 				}
@@ -339,6 +350,11 @@ func summarizeWarpMessage(msg prompt.Message, targetChars int) string {
 	return compactWarpText(strings.Join(parts, " | "), targetChars)
 }
 
+// warpCompactCache memoizes compactWarpText for long texts. Older turns of a
+// conversation are summarized again on every request that is over budget, so
+// only turns that are new since the last request are actually compacted.
+var warpCompactCache = perf.NewTTLCache(10*time.Minute, 4096)
+
 func compactWarpText(text string, targetChars int) string {
 	text = strings.TrimSpace(text)
 	if text == "" {
@@ -347,6 +363,19 @@ func compactWarpText(text string, targetChars int) string {
 	if targetChars <= 0 || warpRuneLen(text) <= targetChars {
 		return text
 	}
+	if len(text) < minCachedBlockBytes {
+		return compactWarpTextUncached(text, targetChars)
+	}
+	key := tokencache.CacheKey("", "", text) + ":" + strconv.Itoa(targetChars)
+	if v, _, ok := warpCompactCache.Get(key); ok {
+		return v.(string)
+	}
+	out := compactWarpTextUncached(text, targetChars)
+	warpCompactCache.Set(key, out)
+	return out
+}
+
+func compactWarpTextUncached(text string, targetChars int) string {
 
 	lines := strings.Split(text, "\n")
 	keywords := []string{
@@ -402,16 +431,16 @@ func compactWarpText(text string, targetChars int) string {
 }
 
 func warpRuneLen(text string) int {
-	return len([]rune(text))
+	return utf8.RuneCountInString(text)
 }
 
 func truncateWarpTextWithEllipsis(text string, maxLen int) string {
 	if maxLen <= 0 {
 		return ""
 	}
-	runes := []rune(text)
-	if len(runes) <= maxLen {
+	if warpRuneLen(text) <= maxLen {
 		return text
 	}
+	runes := []rune(text)
 	return string(runes[:maxLen]) + "…[truncated]"
 }
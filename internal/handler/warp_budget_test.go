@@ -6,6 +6,7 @@ import (
 	"testing"
 
 	"orchids-api/internal/prompt"
+	"orchids-api/internal/tiktoken"
 )
 
 func TestEnforceWarpBudget_SummarizesOlderMessagesBeforeDropping(t *testing.T) {
@@ -25,7 +26,7 @@ func TestEnforceWarpBudget_SummarizesOlderMessagesBeforeDropping(t *testing.T) {
 		})
 	}
 
-	trimmed, _, after, _, summarized, dropped := enforceWarpBudget("prompt", messages, 2600)
+	trimmed, _, after, _, summarized, dropped := enforceWarpBudget("prompt", messages, 2600, toolResultCompression{})
 	if len(trimmed) == 0 {
 		t.Fatalf("expected non-empty trimmed messages")
 	}
@@ -52,7 +53,7 @@ func TestEnforceWarpBudget_FallsBackToWindowWhenStillOverBudget(t *testing.T) {
 		{Role: "user", Content: prompt.MessageContent{Text: "u2 " + strings.Repeat("x", 4000)}},
 	}
 
-	trimmed, _, after, compressed, summarized, dropped := enforceWarpBudget("prompt", messages, 350)
+	trimmed, _, after, compressed, summarized, dropped := enforceWarpBudget("prompt", messages, 350, toolResultCompression{})
 	if len(trimmed) == 0 {
 		t.Fatalf("expected at least one message")
 	}
@@ -72,7 +73,7 @@ func TestEnforceWarpBudget_NoChangeWhenUnderBudget(t *testing.T) {
 		{Role: "assistant", Content: prompt.MessageContent{Text: "world"}},
 	}
 
-	trimmed, before, after, compressed, summarized, dropped := enforceWarpBudget("prompt", messages, 12000)
+	trimmed, before, after, compressed, summarized, dropped := enforceWarpBudget("prompt", messages, 12000, toolResultCompression{})
 	if len(trimmed) != len(messages) {
 		t.Fatalf("expected message count unchanged, got %d", len(trimmed))
 	}
@@ -83,3 +84,65 @@ func TestEnforceWarpBudget_NoChangeWhenUnderBudget(t *testing.T) {
 		t.Fatalf("expected tokens unchanged under budget")
 	}
 }
+
+func TestEstimateWarpTokensBreakdown_CachedBlocksMatchScan(t *testing.T) {
+	t.Parallel()
+
+	long := strings.Repeat("tool output line 输出\n", minCachedBlockBytes/10)
+	messages := []prompt.Message{
+		{Role: "user", Content: prompt.MessageContent{Text: " short question "}},
+		{Role: "assistant", Content: prompt.MessageContent{Blocks: []prompt.ContentBlock{
+			{Type: "text", Text: long},
+			{Type: "tool_result", Content: long},
+		}}},
+	}
+	want := warpTokenBreakdown{
+		PromptTokens:   7,
+		MessagesTokens: tiktoken.EstimateTextTokens("short question") + 15 + tiktoken.EstimateTextTokens(strings.TrimSpace(long)) + 10,
+		ToolTokens:     tiktoken.EstimateTextTokens(long) + 10,
+	}
+	want.Total = want.PromptTokens + want.MessagesTokens + want.ToolTokens + 200
+	for i := 0; i < 2; i++ {
+		if got := estimateWarpTokensBreakdown(7, messages); got != want {
+			t.Fatalf("pass %d: breakdown = %+v, want %+v", i, got, want)
+		}
+	}
+}
+
+func TestCompactWarpText_CachedMatchesUncached(t *testing.T) {
+	t.Parallel()
+
+	text := strings.Repeat("plain line\nerror: build failed in path/to/file.go\n", minCachedBlockBytes/40)
+	want := compactWarpTextUncached(strings.TrimSpace(text), 300)
+	for i := 0; i < 2; i++ {
+		if got := compactWarpText(text, 300); got != want {
+			t.Fatalf("pass %d: compactWarpText = %q, want %q", i, got, want)
+		}
+	}
+	short := compactWarpTextUncached(strings.TrimSpace(text), 60)
+	if got := compactWarpText(text, 60); got != short || got == want {
+		t.Fatalf("a different target must not reuse the cached result: %q", got)
+	}
+}
+
+// BenchmarkEnforceWarpBudget_LongConversation replays a long conversation
+// that stays over budget, as every new turn of it does. Messages are under
+// the soft limit so the cost is estimation rather than text compression.
+func BenchmarkEnforceWarpBudget_LongConversation(b *testing.B) {
+	messages := make([]prompt.Message, 0, 120)
+	for i := 0; i < 120; i++ {
+		role := "user"
+		if i%2 == 1 {
+			role = "assistant"
+		}
+		messages = append(messages, prompt.Message{
+			Role:    role,
+			Content: prompt.MessageContent{Text: fmt.Sprintf("turn-%02d %s", i, strings.Repeat("some words 一些字 ", 130))},
+		})
+	}
+	sys := strings.Repeat("system context rule. ", 2000)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		enforceWarpBudget(sys, messages, 12000, toolResultCompression{})
+	}
+}
@@ -0,0 +1,222 @@
+package handler
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-json"
+
+	"orchids-api/internal/websearch"
+)
+
+// webSearchToolName is the name the built-in web_search executor is advertised
+// under upstream; channels reject tools literally named "web_search".
+const webSearchToolName = "server_web_search"
+
+const webSearchTimeout = 20 * time.Second
+
+// webSearchTool is a request's built-in web_search server tool, configured
+// from the client's tool definition.
+type webSearchTool struct {
+	searcher       websearch.Searcher
+	maxResults     int
+	maxUses        int
+	uses           int
+	allowedDomains []string
+	blockedDomains []string
+}
+
+// webSearcher returns the configured search API, or nil when web_search is
+// not enabled.
+func (h *Handler) webSearcher() websearch.Searcher {
+	if h.webSearch != nil {
+		return h.webSearch
+	}
+	if h.config == nil {
+		return nil
+	}
+	s, err := websearch.New(websearch.Options{
+		Provider: h.config.WebSearchProvider,
+		Endpoint: h.config.WebSearchEndpoint,
+		APIKey:   h.config.WebSearchAPIKey,
+	})
+	if err != nil {
+		slog.Warn("web_search disabled: invalid search API config", "error", err)
+		return nil
+	}
+	return s
+}
+
+// newWebSearchTool returns the executor for a web_search tool definition, or
+// nil when no search API is configured.
+func (h *Handler) newWebSearchTool(spec map[string]interface{}) *webSearchTool {
+	searcher := h.webSearcher()
+	if searcher == nil {
+		return nil
+	}
+	return &webSearchTool{
+		searcher:       searcher,
+		maxResults:     h.config.WebSearchMaxResults,
+		maxUses:        intFromAny(spec["max_uses"]),
+		allowedDomains: stringsFromAny(spec["allowed_domains"]),
+		blockedDomains: stringsFromAny(spec["blocked_domains"]),
+	}
+}
+
+func (ws *webSearchTool) serverName() string { return "web_search" }
+
+func (ws *webSearchTool) definition() map[string]interface{} {
+	return map[string]interface{}{
+		"name":        webSearchToolName,
+		"description": "Search the web for current information. Returns the title, URL and a snippet of each matching page. Cite the URLs of the results you rely on.",
+		"input_schema": map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"query": map[string]interface{}{"type": "string", "description": "The search query"},
+			},
+			"required": []string{"query"},
+		},
+	}
+}
+
+func (ws *webSearchTool) run(ctx context.Context, rawInput string) serverToolOutcome {
+	var input struct {
+		Query string `json:"query"`
+	}
+	_ = json.Unmarshal([]byte(rawInput), &input)
+	hits, errorCode := ws.search(ctx, input.Query)
+	slog.Info("web_search executed", "query", input.Query, "results", len(hits), "error_code", errorCode)
+	return serverToolOutcome{
+		input:   map[string]interface{}{"query": input.Query},
+		result:  webSearchResultBlock(hits, errorCode),
+		text:    webSearchToolResult(input.Query, hits, errorCode),
+		isError: errorCode != "",
+	}
+}
+
+// search executes one search. errorCode follows the Anthropic web_search
+// error codes and is empty on success.
+func (ws *webSearchTool) search(ctx context.Context, query string) (results []websearch.Result, errorCode string) {
+	if strings.TrimSpace(query) == "" {
+		return nil, "invalid_tool_input"
+	}
+	if ws.maxUses > 0 && ws.uses >= ws.maxUses {
+		return nil, "max_uses_exceeded"
+	}
+	ws.uses++
+	ctx, cancel := context.WithTimeout(ctx, webSearchTimeout)
+	defer cancel()
+	hits, err := ws.searcher.Search(ctx, query, ws.maxResults)
+	if err != nil {
+		slog.Warn("web_search failed", "query", query, "error", err)
+		return nil, "unavailable"
+	}
+	for _, hit := range hits {
+		if ws.domainAllowed(hit.URL) {
+			results = append(results, hit)
+		}
+	}
+	return results, ""
+}
+
+func (ws *webSearchTool) domainAllowed(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Hostname() == "" {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	matches := func(domains []string) bool {
+		for _, d := range domains {
+			d = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(d), "."))
+			if d != "" && (host == d || strings.HasSuffix(host, "."+d)) {
+				return true
+			}
+		}
+		return false
+	}
+	if matches(ws.blockedDomains) {
+		return false
+	}
+	return len(ws.allowedDomains) == 0 || matches(ws.allowedDomains)
+}
+
+// webSearchResultBlock renders the web_search_tool_result block sent to the client.
+func webSearchResultBlock(results []websearch.Result, errorCode string) map[string]interface{} {
+	block := map[string]interface{}{"type": "web_search_tool_result"}
+	if errorCode != "" {
+		block["content"] = map[string]interface{}{
+			"type":       "web_search_tool_result_error",
+			"error_code": errorCode,
+		}
+		return block
+	}
+	content := make([]map[string]interface{}, 0, len(results))
+	for _, r := range results {
+		item := map[string]interface{}{
+			"type":              "web_search_result",
+			"url":               r.URL,
+			"title":             r.Title,
+			"encrypted_content": base64.StdEncoding.EncodeToString([]byte(r.Snippet)),
+		}
+		if r.PageAge != "" {
+			item["page_age"] = r.PageAge
+		}
+		content = append(content, item)
+	}
+	block["content"] = content
+	return block
+}
+
+// webSearchToolResult renders the results for upstream as a numbered,
+// citable list.
+func webSearchToolResult(query string, results []websearch.Result, errorCode string) string {
+	if errorCode != "" {
+		return fmt.Sprintf("Web search for %q failed: %s", query, errorCode)
+	}
+	if len(results) == 0 {
+		return fmt.Sprintf("Web search for %q returned no results.", query)
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Web search results for %q:\n", query)
+	for i, r := range results {
+		fmt.Fprintf(&sb, "\n[%d] %s\nURL: %s\n", i+1, r.Title, r.URL)
+		if r.PageAge != "" {
+			fmt.Fprintf(&sb, "Published: %s\n", r.PageAge)
+		}
+		if snippet := strings.TrimSpace(r.Snippet); snippet != "" {
+			sb.WriteString(snippet)
+			sb.WriteByte('\n')
+		}
+	}
+	sb.WriteString("\nCite the URLs of the results you use.")
+	return sb.String()
+}
+
+func intFromAny(v interface{}) int {
+	switch n := v.(type) {
+	case float64:
+		return int(n)
+	case int:
+		return n
+	case json.Number:
+		i, _ := n.Int64()
+		return int(i)
+	}
+	return 0
+}
+
+func stringsFromAny(v interface{}) []string {
+	items, _ := v.([]interface{})
+	out := make([]string, 0, len(items))
+	for _, item := range items {
+		if s, ok := item.(string); ok && strings.TrimSpace(s) != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
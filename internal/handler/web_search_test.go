@@ -0,0 +1,121 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/goccy/go-json"
+
+	"orchids-api/internal/config"
+	"orchids-api/internal/upstream"
+	"orchids-api/internal/websearch"
+)
+
+type fakeSearcher struct {
+	queries []string
+	results []websearch.Result
+}
+
+func (f *fakeSearcher) Search(ctx context.Context, query string, limit int) ([]websearch.Result, error) {
+	f.queries = append(f.queries, query)
+	return f.results, nil
+}
+
+func TestHandleMessages_WebSearchServerTool(t *testing.T) {
+	cfg := &config.Config{DebugEnabled: false, RequestTimeout: 10, ContextMaxTokens: 1024, ContextSummaryMaxTokens: 256, ContextKeepTurns: 2,
+		WebSearchMaxResults: 5}
+	h := NewWithLoadBalancer(cfg, nil)
+	searcher := &fakeSearcher{results: []websearch.Result{
+		{Title: "Go 1.24 Release Notes", URL: "https://go.dev/doc/go1.24", Snippet: "Go 1.24 arrives six months after Go 1.23."},
+		{Title: "Blocked", URL: "https://spam.example/go", Snippet: "spam"},
+	}}
+	h.webSearch = searcher
+	up := &roundsUpstream{rounds: [][]upstream.SSEMessage{
+		{
+			{Type: "model", Event: map[string]any{"type": "text-start"}},
+			{Type: "model", Event: map[string]any{"type": "text-delta", "delta": "Let me search."}},
+			{Type: "model", Event: map[string]any{"type": "text-end"}},
+			{Type: "model", Event: map[string]any{"type": "tool-call", "toolCallId": "call_1", "toolName": webSearchToolName, "input": `{"query":"go 1.24 release"}`}},
+			{Type: "model", Event: map[string]any{"type": "finish", "finishReason": "tool-calls"}},
+		},
+		{
+			{Type: "model", Event: map[string]any{"type": "text-start"}},
+			{Type: "model", Event: map[string]any{"type": "text-delta", "delta": "Go 1.24 is out [1]."}},
+			{Type: "model", Event: map[string]any{"type": "text-end"}},
+			{Type: "model", Event: map[string]any{"type": "finish", "finishReason": "stop"}},
+		},
+	}}
+	h.client = up
+	b, _ := json.Marshal(map[string]any{
+		"model":    "claude-3-5-sonnet",
+		"messages": []map[string]any{{"role": "user", "content": "what's new in go 1.24?"}},
+		"tools":    []map[string]any{{"type": "web_search_20250305", "name": "web_search", "max_uses": 3, "blocked_domains": []string{"spam.example"}}},
+		"stream":   false,
+	})
+
+	rec := httptest.NewRecorder()
+	h.HandleMessages(rec, httptest.NewRequest(http.MethodPost, "http://x/orchids/v1/messages", bytes.NewReader(b)))
+	var resp struct {
+		StopReason string           `json:"stop_reason"`
+		Content    []map[string]any `json:"content"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode: %v (%s)", err, rec.Body.String())
+	}
+
+	var types []string
+	for _, block := range resp.Content {
+		types = append(types, block["type"].(string))
+	}
+	if got := strings.Join(types, ","); got != "text,server_tool_use,web_search_tool_result,text" {
+		t.Fatalf("content types=%s body=%s", got, rec.Body.String())
+	}
+	if resp.StopReason != "end_turn" {
+		t.Fatalf("stop_reason=%q", resp.StopReason)
+	}
+	use, result := resp.Content[1], resp.Content[2]
+	if use["name"] != "web_search" || result["tool_use_id"] != use["id"] {
+		t.Fatalf("server tool blocks mismatch: %v %v", use, result)
+	}
+	hits, _ := result["content"].([]any)
+	if len(hits) != 1 || hits[0].(map[string]any)["url"] != "https://go.dev/doc/go1.24" {
+		t.Fatalf("expected the blocked domain to be filtered: %v", hits)
+	}
+
+	if len(searcher.queries) != 1 || searcher.queries[0] != "go 1.24 release" {
+		t.Fatalf("queries=%v", searcher.queries)
+	}
+	if len(up.reqs) != 2 {
+		t.Fatalf("upstream rounds=%d", len(up.reqs))
+	}
+	advertised := false
+	for _, tool := range up.reqs[0].Tools {
+		if name, serverType := toolIdentity(tool); name == webSearchToolName && serverType == "" {
+			advertised = true
+		}
+	}
+	if !advertised {
+		t.Fatalf("web search tool not advertised upstream: %v", up.reqs[0].Tools)
+	}
+	last := up.reqs[1].Messages[len(up.reqs[1].Messages)-1]
+	if last.Role != "user" || len(last.Content.Blocks) != 1 || !strings.Contains(last.Content.Blocks[0].Content.(string), "URL: https://go.dev/doc/go1.24") {
+		t.Fatalf("follow-up round missing search results: %+v", last)
+	}
+}
+
+func TestWebSearchTool_MaxUses(t *testing.T) {
+	ws := &webSearchTool{searcher: &fakeSearcher{}, maxUses: 1}
+	if _, code := ws.search(context.Background(), "a"); code != "" {
+		t.Fatalf("first search failed: %s", code)
+	}
+	if _, code := ws.search(context.Background(), "b"); code != "max_uses_exceeded" {
+		t.Fatalf("code=%q want max_uses_exceeded", code)
+	}
+	if _, code := ws.search(context.Background(), " "); code != "invalid_tool_input" {
+		t.Fatalf("code=%q want invalid_tool_input", code)
+	}
+}
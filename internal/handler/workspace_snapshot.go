@@ -0,0 +1,11 @@
+package handler
+
+// workspaceSnapshot returns the cached snapshot of workdir rendered for the
+// prompt, or "" when snapshots are disabled or workdir is not a local
+// directory.
+func (h *Handler) workspaceSnapshot(workdir string) string {
+	if h.workspace == nil || h.config == nil || !h.config.WorkspaceSnapshot || workdir == "" {
+		return ""
+	}
+	return h.workspace.Get(workdir).Render()
+}
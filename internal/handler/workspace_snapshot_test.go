@@ -0,0 +1,53 @@
+package handler
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/goccy/go-json"
+
+	"orchids-api/internal/config"
+	"orchids-api/internal/upstream"
+)
+
+func TestHandleMessages_InjectsWorkspaceSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	reply := [][]upstream.SSEMessage{{
+		{Type: "model", Event: map[string]any{"type": "text-start"}},
+		{Type: "model", Event: map[string]any{"type": "text-delta", "delta": "ok"}},
+		{Type: "model", Event: map[string]any{"type": "text-end"}},
+		{Type: "model", Event: map[string]any{"type": "finish", "finishReason": "stop"}},
+	}}
+	for _, enabled := range []bool{true, false} {
+		cfg := &config.Config{DebugEnabled: false, RequestTimeout: 10, ContextMaxTokens: 1024, ContextSummaryMaxTokens: 256, ContextKeepTurns: 2,
+			WorkspaceSnapshot: enabled, WorkspaceSnapshotRoots: []string{dir}}
+		h := NewWithLoadBalancer(cfg, nil)
+		up := &roundsUpstream{rounds: reply}
+		h.client = up
+		b, _ := json.Marshal(map[string]any{
+			"model":    "claude-3-5-sonnet",
+			"messages": []map[string]any{{"role": "user", "content": "refactor main.go to use flags"}},
+			"stream":   false,
+		})
+		req := httptest.NewRequest(http.MethodPost, "http://x/orchids/v1/messages", bytes.NewReader(b))
+		req.Header.Set("X-Workdir", dir)
+		h.HandleMessages(httptest.NewRecorder(), req)
+
+		if len(up.reqs) != 1 {
+			t.Fatalf("enabled=%v: upstream calls=%d", enabled, len(up.reqs))
+		}
+		got := up.reqs[0].Prompt
+		injected := strings.Contains(got, "<workspace>\nroot: "+dir+"\nfiles:\nmain.go (13B)\n</workspace>")
+		if injected != enabled {
+			t.Fatalf("enabled=%v: workspace section injected=%v prompt=%s", enabled, injected, got)
+		}
+	}
+}
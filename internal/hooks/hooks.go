@@ -0,0 +1,136 @@
+// Package hooks provides request/response transformation hooks that let operators
+// customize proxy behavior per route without modifying the handler. Hooks are
+// either config rules (RuleHook, declarative fields plus an optional Lua
+// script) or Go implementations of RequestHook / OutputHook.
+package hooks
+
+import (
+	"fmt"
+	"github.com/goccy/go-json"
+	"strings"
+	"sync"
+)
+
+// Hook is implemented by every transformation hook.
+type Hook interface {
+	Name() string
+}
+
+// RequestHook rewrites the decoded JSON body of an incoming request in place.
+type RequestHook interface {
+	Hook
+	TransformRequest(route string, body map[string]interface{}) error
+}
+
+// OutputHook creates a per-request filter applied to outgoing text deltas.
+type OutputHook interface {
+	Hook
+	NewOutputFilter(route string) OutputFilter
+}
+
+// OutputFilter transforms streamed text. Filter may hold back text (e.g. a
+// partial match spanning deltas); Flush returns whatever is still held back.
+type OutputFilter interface {
+	Filter(delta string) string
+	Flush() string
+}
+
+type registration struct {
+	route string
+	hook  Hook
+}
+
+// Registry holds hooks keyed by route prefix. An empty prefix matches every route.
+type Registry struct {
+	mu    sync.RWMutex
+	hooks []registration
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register adds a hook for requests whose path starts with route.
+func (r *Registry) Register(route string, h Hook) {
+	if r == nil || h == nil {
+		return
+	}
+	r.mu.Lock()
+	r.hooks = append(r.hooks, registration{route: strings.TrimSpace(route), hook: h})
+	r.mu.Unlock()
+}
+
+func (r *Registry) matching(route string) []Hook {
+	if r == nil {
+		return nil
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var out []Hook
+	for _, reg := range r.hooks {
+		if reg.route == "" || strings.HasPrefix(route, reg.route) {
+			out = append(out, reg.hook)
+		}
+	}
+	return out
+}
+
+// ApplyRequest runs every matching RequestHook over body. The body is returned
+// unchanged when no request hook matches.
+func (r *Registry) ApplyRequest(route string, body []byte) ([]byte, error) {
+	var reqHooks []RequestHook
+	for _, h := range r.matching(route) {
+		if rh, ok := h.(RequestHook); ok {
+			reqHooks = append(reqHooks, rh)
+		}
+	}
+	if len(reqHooks) == 0 {
+		return body, nil
+	}
+
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(body, &decoded); err != nil {
+		return nil, fmt.Errorf("decode request for hooks: %w", err)
+	}
+	for _, rh := range reqHooks {
+		if err := rh.TransformRequest(route, decoded); err != nil {
+			return nil, fmt.Errorf("hook %s: %w", rh.Name(), err)
+		}
+	}
+	return json.Marshal(decoded)
+}
+
+// OutputFilter returns the chained output filter for route, or nil when no
+// output hook matches.
+func (r *Registry) OutputFilter(route string) OutputFilter {
+	var filters chain
+	for _, h := range r.matching(route) {
+		if oh, ok := h.(OutputHook); ok {
+			if f := oh.NewOutputFilter(route); f != nil {
+				filters = append(filters, f)
+			}
+		}
+	}
+	if len(filters) == 0 {
+		return nil
+	}
+	return filters
+}
+
+// chain feeds the output of each filter into the next.
+type chain []OutputFilter
+
+func (c chain) Filter(delta string) string {
+	for _, f := range c {
+		delta = f.Filter(delta)
+	}
+	return delta
+}
+
+func (c chain) Flush() string {
+	var out string
+	for _, f := range c {
+		out = f.Filter(out) + f.Flush()
+	}
+	return out
+}
@@ -0,0 +1,76 @@
+package hooks
+
+import (
+	"github.com/goccy/go-json"
+	"strings"
+	"testing"
+
+	"orchids-api/internal/config"
+)
+
+func TestRuleHookTransformsRequest(t *testing.T) {
+	r := NewRegistry()
+	errs := RegisterRules(r, []config.HookRule{{
+		Route:        "/orchids/",
+		InjectSystem: "Follow the org policy.",
+		StripFields:  []string{"metadata.user_id", "temperature"},
+	}})
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	body := []byte(`{"system":"You are helpful","temperature":1,"metadata":{"user_id":"u1","keep":"x"}}`)
+	out, err := r.ApplyRequest("/orchids/v1/messages", body)
+	if err != nil {
+		t.Fatalf("ApplyRequest: %v", err)
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(out, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := decoded["temperature"]; ok {
+		t.Fatal("temperature should be stripped")
+	}
+	meta := decoded["metadata"].(map[string]interface{})
+	if _, ok := meta["user_id"]; ok || meta["keep"] != "x" {
+		t.Fatalf("unexpected metadata: %v", meta)
+	}
+	system := decoded["system"].([]interface{})
+	if len(system) != 2 || system[0].(map[string]interface{})["text"] != "Follow the org policy." {
+		t.Fatalf("unexpected system: %v", system)
+	}
+
+	untouched, err := r.ApplyRequest("/warp/v1/messages", body)
+	if err != nil || string(untouched) != string(body) {
+		t.Fatalf("non-matching route should be untouched, got %s err=%v", untouched, err)
+	}
+}
+
+func TestRuleHookOutputRedaction(t *testing.T) {
+	r := NewRegistry()
+	RegisterRules(r, []config.HookRule{
+		{Name: "a", Redact: []config.RedactRule{{Pattern: `secret-\d+`, Replacement: "[x]"}}},
+		{Name: "b", Route: "/v1/", Redact: []config.RedactRule{{Pattern: `\[x\]`, Replacement: "<redacted>"}}},
+	})
+	f := r.OutputFilter("/v1/messages")
+	if f == nil {
+		t.Fatal("expected output filter")
+	}
+	if got := f.Filter("token secret-42 here") + f.Flush(); got != "token <redacted> here" {
+		t.Fatalf("filtered output=%q", got)
+	}
+	if r.OutputFilter("/other") == nil {
+		t.Fatal("catch-all rule should still match")
+	}
+}
+
+func TestRegisterRulesReportsInvalidPattern(t *testing.T) {
+	r := NewRegistry()
+	errs := RegisterRules(r, []config.HookRule{{Name: "bad", Redact: []config.RedactRule{{Pattern: "("}}}})
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "bad") {
+		t.Fatalf("expected one error naming the rule, got %v", errs)
+	}
+	if r.OutputFilter("/v1/messages") != nil {
+		t.Fatal("invalid rule must not be registered")
+	}
+}
@@ -0,0 +1,131 @@
+package hooks
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// redactHoldBytes is how much trailing text a redactor holds back so that a
+// match split across two deltas is still caught. Matches that reach the end of
+// the buffered text are held in full until they are complete.
+const redactHoldBytes = 128
+
+// builtinRedactions are the secret/PII patterns selectable by name through
+// the output_redaction config field.
+var builtinRedactions = map[string]string{
+	"aws_access_key": `\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`,
+	"aws_secret_key": `(?i)aws_secret_access_key["']?\s*[:=]\s*["']?[A-Za-z0-9/+=]{40}`,
+	"private_key":    `-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?(?:-----END [A-Z ]*PRIVATE KEY-----|$)`,
+	"github_token":   `\bgh[pousr]_[A-Za-z0-9]{36,}\b`,
+	"api_key":        `\bsk-[A-Za-z0-9_\-]{20,}`,
+	"jwt":            `\beyJ[A-Za-z0-9_\-]+\.eyJ[A-Za-z0-9_\-]+\.[A-Za-z0-9_\-]+`,
+	"email":          `[A-Za-z0-9._%+\-]+@[A-Za-z0-9\-]+(?:\.[A-Za-z0-9\-]+)*\.[A-Za-z]{2,}`,
+}
+
+// BuiltinRedactionNames lists the names accepted by NewSecretRedactionHook.
+func BuiltinRedactionNames() []string {
+	return []string{"aws_access_key", "aws_secret_key", "private_key", "github_token", "api_key", "jwt", "email"}
+}
+
+// SecretRedactionHook masks well-known secrets and PII in streamed output.
+type SecretRedactionHook struct {
+	patterns []redactPattern
+}
+
+// NewSecretRedactionHook builds a hook for the named builtin patterns; "all"
+// selects every builtin. It returns nil when names is empty.
+func NewSecretRedactionHook(names []string) (*SecretRedactionHook, error) {
+	selected := make([]string, 0, len(names))
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if name == "all" {
+			selected = BuiltinRedactionNames()
+			break
+		}
+		if _, ok := builtinRedactions[name]; !ok {
+			return nil, fmt.Errorf("unknown redaction pattern %q", name)
+		}
+		selected = append(selected, name)
+	}
+	if len(selected) == 0 {
+		return nil, nil
+	}
+	h := &SecretRedactionHook{}
+	for _, name := range selected {
+		h.patterns = append(h.patterns, redactPattern{
+			re:          regexp.MustCompile(builtinRedactions[name]),
+			replacement: "[REDACTED:" + name + "]",
+		})
+	}
+	return h, nil
+}
+
+func (h *SecretRedactionHook) Name() string { return "secret_redaction" }
+
+func (h *SecretRedactionHook) NewOutputFilter(_ string) OutputFilter {
+	return newStreamRedactor(h.patterns)
+}
+
+// streamRedactor applies regex redaction to a stream of deltas, holding back
+// the tail of the text until it can no longer be part of a match.
+type streamRedactor struct {
+	patterns []redactPattern
+	buf      strings.Builder
+}
+
+func newStreamRedactor(patterns []redactPattern) *streamRedactor {
+	return &streamRedactor{patterns: patterns}
+}
+
+func (f *streamRedactor) Filter(delta string) string {
+	if delta == "" {
+		return ""
+	}
+	f.buf.WriteString(delta)
+	text := f.buf.String()
+
+	cut := len(text) - redactHoldBytes
+	if cut <= 0 {
+		return ""
+	}
+	for _, p := range f.patterns {
+		for _, loc := range p.re.FindAllStringIndex(text, -1) {
+			if loc[1] > cut && loc[0] < cut {
+				cut = loc[0]
+			}
+		}
+	}
+	for cut > 0 && !utf8.RuneStart(text[cut]) {
+		cut--
+	}
+	if cut <= 0 {
+		return ""
+	}
+
+	out := f.redact(text[:cut])
+	rest := text[cut:]
+	f.buf.Reset()
+	f.buf.WriteString(rest)
+	return out
+}
+
+func (f *streamRedactor) Flush() string {
+	text := f.buf.String()
+	f.buf.Reset()
+	if text == "" {
+		return ""
+	}
+	return f.redact(text)
+}
+
+func (f *streamRedactor) redact(text string) string {
+	for _, p := range f.patterns {
+		text = p.re.ReplaceAllString(text, p.replacement)
+	}
+	return text
+}
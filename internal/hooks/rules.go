@@ -0,0 +1,149 @@
+package hooks
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"orchids-api/internal/config"
+)
+
+// RuleHook is a hook built from a config.HookRule. It can prepend a system
+// prompt, strip request fields and regex-redact outgoing text, then hand the
+// request and the text to the rule's Lua script.
+type RuleHook struct {
+	name         string
+	injectSystem string
+	stripFields  [][]string
+	redact       []redactPattern
+	script       *luaScript
+}
+
+type redactPattern struct {
+	re          *regexp.Regexp
+	replacement string
+}
+
+// NewRuleHook compiles a config rule into a hook.
+func NewRuleHook(rule config.HookRule) (*RuleHook, error) {
+	h := &RuleHook{
+		name:         strings.TrimSpace(rule.Name),
+		injectSystem: strings.TrimSpace(rule.InjectSystem),
+	}
+	if h.name == "" {
+		h.name = "rule:" + rule.Route
+	}
+	for _, field := range rule.StripFields {
+		field = strings.TrimSpace(field)
+		if field != "" {
+			h.stripFields = append(h.stripFields, strings.Split(field, "."))
+		}
+	}
+	for _, rr := range rule.Redact {
+		re, err := regexp.Compile(rr.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("hook %s: invalid redact pattern %q: %w", h.name, rr.Pattern, err)
+		}
+		h.redact = append(h.redact, redactPattern{re: re, replacement: rr.Replacement})
+	}
+	source := rule.Script
+	if path := strings.TrimSpace(rule.ScriptFile); path != "" {
+		if source != "" {
+			return nil, fmt.Errorf("hook %s: script and script_file are mutually exclusive", h.name)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("hook %s: read script_file: %w", h.name, err)
+		}
+		source = string(data)
+	}
+	if strings.TrimSpace(source) != "" {
+		script, err := compileScript(h.name, source)
+		if err != nil {
+			return nil, err
+		}
+		h.script = script
+	}
+	return h, nil
+}
+
+// RegisterRules compiles and registers every rule. Invalid rules are reported
+// and skipped so one bad rule does not disable the others.
+func RegisterRules(r *Registry, rules []config.HookRule) []error {
+	var errs []error
+	for _, rule := range rules {
+		h, err := NewRuleHook(rule)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		r.Register(rule.Route, h)
+	}
+	return errs
+}
+
+func (h *RuleHook) Name() string { return h.name }
+
+func (h *RuleHook) TransformRequest(_ string, body map[string]interface{}) error {
+	for _, path := range h.stripFields {
+		deletePath(body, path)
+	}
+	if h.injectSystem != "" {
+		body["system"] = prependSystem(body["system"], h.injectSystem)
+	}
+	if h.script != nil && h.script.hasRequest {
+		return h.script.transformRequest(body)
+	}
+	return nil
+}
+
+func (h *RuleHook) NewOutputFilter(_ string) OutputFilter {
+	var filters chain
+	if len(h.redact) > 0 {
+		filters = append(filters, newStreamRedactor(h.redact))
+	}
+	if h.script != nil && h.script.hasOutput {
+		filters = append(filters, scriptFilter{script: h.script})
+	}
+	switch len(filters) {
+	case 0:
+		return nil
+	case 1:
+		return filters[0]
+	}
+	return filters
+}
+
+func deletePath(m map[string]interface{}, path []string) {
+	for i, key := range path {
+		if i == len(path)-1 {
+			delete(m, key)
+			return
+		}
+		next, ok := m[key].(map[string]interface{})
+		if !ok {
+			return
+		}
+		m = next
+	}
+}
+
+// prependSystem adds text before the existing Anthropic "system" value, which
+// may be absent, a string, or an array of content blocks.
+func prependSystem(existing interface{}, text string) interface{} {
+	block := map[string]interface{}{"type": "text", "text": text}
+	switch v := existing.(type) {
+	case nil:
+		return []interface{}{block}
+	case string:
+		if strings.TrimSpace(v) == "" {
+			return []interface{}{block}
+		}
+		return []interface{}{block, map[string]interface{}{"type": "text", "text": v}}
+	case []interface{}:
+		return append([]interface{}{block}, v...)
+	default:
+		return existing
+	}
+}
@@ -0,0 +1,277 @@
+package hooks
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+// scriptTimeout bounds one call into a hook script.
+const scriptTimeout = 200 * time.Millisecond
+
+// Functions a hook script may define; both are optional.
+const (
+	scriptRequestFunc = "transform_request"
+	scriptOutputFunc  = "transform_output"
+)
+
+// scriptDisabledGlobals are base library functions that would let a script
+// load code from disk or outside the compiled chunk.
+var scriptDisabledGlobals = []string{"dofile", "loadfile", "load", "loadstring"}
+
+// luaScript is a compiled Lua hook script. A script may define
+// transform_request(req), which receives the decoded request body as a table
+// and returns the new body (or nil to keep its changes to req), and
+// transform_output(text), which receives each outgoing text delta and returns
+// the text to send. Only the base, table, string and math libraries are
+// available, and each call is cut off after scriptTimeout.
+type luaScript struct {
+	name       string
+	proto      *lua.FunctionProto
+	hasRequest bool
+	hasOutput  bool
+	// states holds ready *lua.LState values with the script loaded; an
+	// LState is not safe for concurrent use.
+	states sync.Pool
+}
+
+// arrayMetaKey marks tables converted from JSON arrays so that empty arrays
+// survive the round trip; other tables become objects unless they are
+// non-empty sequences.
+var arrayMetaKey = lua.LString("__orchids_array")
+
+func compileScript(name, source string) (*luaScript, error) {
+	chunk, err := parse.Parse(strings.NewReader(source), name)
+	if err != nil {
+		return nil, fmt.Errorf("hook %s: parse script: %w", name, err)
+	}
+	proto, err := lua.Compile(chunk, name)
+	if err != nil {
+		return nil, fmt.Errorf("hook %s: compile script: %w", name, err)
+	}
+	s := &luaScript{name: name, proto: proto}
+	L, err := s.newState()
+	if err != nil {
+		return nil, fmt.Errorf("hook %s: %w", name, err)
+	}
+	s.hasRequest = L.GetGlobal(scriptRequestFunc).Type() == lua.LTFunction
+	s.hasOutput = L.GetGlobal(scriptOutputFunc).Type() == lua.LTFunction
+	if !s.hasRequest && !s.hasOutput {
+		L.Close()
+		return nil, fmt.Errorf("hook %s: script defines neither %s nor %s", name, scriptRequestFunc, scriptOutputFunc)
+	}
+	s.states.Put(L)
+	return s, nil
+}
+
+// newState creates a sandboxed interpreter and runs the script's top level.
+func (s *luaScript) newState() (*lua.LState, error) {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true, CallStackSize: 256, RegistryMaxSize: 1 << 20})
+	for _, lib := range []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+	for _, name := range scriptDisabledGlobals {
+		L.SetGlobal(name, lua.LNil)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), scriptTimeout)
+	defer cancel()
+	L.SetContext(ctx)
+	defer L.RemoveContext()
+	L.Push(L.NewFunctionFromProto(s.proto))
+	if err := L.PCall(0, 0, nil); err != nil {
+		L.Close()
+		return nil, fmt.Errorf("run script: %w", err)
+	}
+	return L, nil
+}
+
+// call runs fn with arg on a pooled state and returns its first result.
+func (s *luaScript) call(fn string, arg func(L *lua.LState) lua.LValue) (lua.LValue, *lua.LState, error) {
+	L, _ := s.states.Get().(*lua.LState)
+	if L == nil {
+		var err error
+		if L, err = s.newState(); err != nil {
+			return nil, nil, err
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), scriptTimeout)
+	defer cancel()
+	L.SetContext(ctx)
+	defer L.RemoveContext()
+
+	err := L.CallByParam(lua.P{Fn: L.GetGlobal(fn), NRet: 1, Protect: true}, arg(L))
+	if err != nil {
+		// A failed call may leave the state half-modified; drop it.
+		L.Close()
+		return nil, nil, err
+	}
+	ret := L.Get(-1)
+	L.Pop(1)
+	return ret, L, nil
+}
+
+func (s *luaScript) release(L *lua.LState) {
+	if L != nil {
+		s.states.Put(L)
+	}
+}
+
+func (s *luaScript) transformRequest(body map[string]interface{}) error {
+	var in *lua.LTable
+	ret, L, err := s.call(scriptRequestFunc, func(L *lua.LState) lua.LValue {
+		in = toLua(L, body).(*lua.LTable)
+		return in
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", scriptRequestFunc, err)
+	}
+	defer s.release(L)
+
+	out := in
+	switch v := ret.(type) {
+	case *lua.LTable:
+		out = v
+	case *lua.LNilType:
+	default:
+		return fmt.Errorf("%s returned %s, want a table or nil", scriptRequestFunc, ret.Type())
+	}
+	next, ok := fromLua(out, 0).(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("%s returned an array, want an object", scriptRequestFunc)
+	}
+	clear(body)
+	for k, v := range next {
+		body[k] = v
+	}
+	return nil
+}
+
+// transformOutput runs transform_output over one delta. On error the delta is
+// passed through unchanged so a broken script does not cut off responses.
+func (s *luaScript) transformOutput(delta string) string {
+	if delta == "" {
+		return delta
+	}
+	ret, L, err := s.call(scriptOutputFunc, func(*lua.LState) lua.LValue { return lua.LString(delta) })
+	if err != nil {
+		slog.Warn("Hook script failed, output passed through", "hook", s.name, "error", err)
+		return delta
+	}
+	defer s.release(L)
+	switch v := ret.(type) {
+	case lua.LString:
+		return string(v)
+	case *lua.LNilType:
+		return ""
+	}
+	slog.Warn("Hook script returned a non-string, output passed through", "hook", s.name, "type", ret.Type().String())
+	return delta
+}
+
+// scriptFilter applies transform_output to each delta; it holds nothing back.
+type scriptFilter struct {
+	script *luaScript
+}
+
+func (f scriptFilter) Filter(delta string) string { return f.script.transformOutput(delta) }
+func (f scriptFilter) Flush() string              { return "" }
+
+// toLua converts a decoded JSON value into a Lua value.
+func toLua(L *lua.LState, v interface{}) lua.LValue {
+	switch val := v.(type) {
+	case nil:
+		return lua.LNil
+	case bool:
+		return lua.LBool(val)
+	case float64:
+		return lua.LNumber(val)
+	case string:
+		return lua.LString(val)
+	case map[string]interface{}:
+		t := L.CreateTable(0, len(val))
+		for k, item := range val {
+			t.RawSetString(k, toLua(L, item))
+		}
+		return t
+	case []interface{}:
+		t := L.CreateTable(len(val), 0)
+		for _, item := range val {
+			t.Append(toLua(L, item))
+		}
+		meta := L.CreateTable(0, 1)
+		meta.RawSet(arrayMetaKey, lua.LTrue)
+		L.SetMetatable(t, meta)
+		return t
+	default:
+		return lua.LString(fmt.Sprint(val))
+	}
+}
+
+// maxScriptDepth bounds the nesting fromLua follows, so a table that refers
+// to itself cannot recurse forever.
+const maxScriptDepth = 64
+
+// fromLua converts a Lua value back into a JSON-compatible value. Tables
+// become arrays when they came from one or are non-empty sequences; tables
+// nested deeper than maxScriptDepth become null.
+func fromLua(v lua.LValue, depth int) interface{} {
+	switch val := v.(type) {
+	case *lua.LNilType:
+		return nil
+	case lua.LBool:
+		return bool(val)
+	case lua.LNumber:
+		return float64(val)
+	case lua.LString:
+		return string(val)
+	case *lua.LTable:
+		if depth >= maxScriptDepth {
+			return nil
+		}
+		if isLuaArray(val) {
+			out := make([]interface{}, 0, val.Len())
+			for i := 1; i <= val.Len(); i++ {
+				out = append(out, fromLua(val.RawGetInt(i), depth+1))
+			}
+			return out
+		}
+		out := make(map[string]interface{})
+		val.ForEach(func(k, item lua.LValue) {
+			out[k.String()] = fromLua(item, depth+1)
+		})
+		return out
+	default:
+		return nil
+	}
+}
+
+func isLuaArray(t *lua.LTable) bool {
+	if meta, ok := t.Metatable.(*lua.LTable); ok && meta.RawGet(arrayMetaKey) == lua.LTrue {
+		return true
+	}
+	n := t.Len()
+	if n == 0 {
+		return false
+	}
+	// A sequence has exactly the keys 1..n.
+	count := 0
+	t.ForEach(func(lua.LValue, lua.LValue) { count++ })
+	return count == n
+}
@@ -0,0 +1,103 @@
+package hooks
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/goccy/go-json"
+
+	"orchids-api/internal/config"
+)
+
+func TestScriptHookTransformsRequest(t *testing.T) {
+	r := NewRegistry()
+	errs := RegisterRules(r, []config.HookRule{{
+		Name:  "script",
+		Route: "/v1/",
+		Script: `
+function transform_request(req)
+  if req.model == "claude-opus-4-6" then
+    req.max_tokens = math.min(req.max_tokens, 1024)
+  end
+  req.metadata = nil
+  table.insert(req.messages, 1, {role = "user", content = "policy: " .. #req.messages})
+end`,
+	}})
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	body := []byte(`{"model":"claude-opus-4-6","max_tokens":4096,"metadata":{"user_id":"u1"},"tools":[],"messages":[{"role":"user","content":"hi"}]}`)
+	out, err := r.ApplyRequest("/v1/messages", body)
+	if err != nil {
+		t.Fatalf("ApplyRequest: %v", err)
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(out, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded["max_tokens"] != float64(1024) {
+		t.Fatalf("max_tokens = %v", decoded["max_tokens"])
+	}
+	if _, ok := decoded["metadata"]; ok {
+		t.Fatal("metadata should be removed")
+	}
+	if tools, ok := decoded["tools"].([]interface{}); !ok || len(tools) != 0 {
+		t.Fatalf("empty tools array not preserved: %v", decoded["tools"])
+	}
+	messages := decoded["messages"].([]interface{})
+	if len(messages) != 2 || messages[0].(map[string]interface{})["content"] != "policy: 1" {
+		t.Fatalf("messages = %v", messages)
+	}
+}
+
+func TestScriptHookReplacesRequestAndOutput(t *testing.T) {
+	r := NewRegistry()
+	RegisterRules(r, []config.HookRule{{
+		Redact: []config.RedactRule{{Pattern: `secret`, Replacement: "[x]"}},
+		Script: `
+function transform_request(req)
+  return {model = req.model, messages = req.messages}
+end
+function transform_output(text)
+  return (string.gsub(text, "%[x%]", "<hidden>"))
+end`,
+	}})
+
+	out, err := r.ApplyRequest("/v1/messages", []byte(`{"model":"m","stream":true,"messages":[]}`))
+	if err != nil || string(out) != `{"messages":[],"model":"m"}` {
+		t.Fatalf("ApplyRequest = %s, %v", out, err)
+	}
+	f := r.OutputFilter("/v1/messages")
+	if got := f.Filter("a secret b") + f.Flush(); got != "a <hidden> b" {
+		t.Fatalf("filtered output = %q", got)
+	}
+}
+
+func TestScriptHookErrors(t *testing.T) {
+	for name, script := range map[string]string{
+		"syntax":      `function transform_request(req`,
+		"no function": `x = 1`,
+		"load":        `load("return 1")()`,
+	} {
+		if _, err := NewRuleHook(config.HookRule{Name: name, Script: script}); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+
+	h, err := NewRuleHook(config.HookRule{Script: `function transform_request(req) while true do end end`})
+	if err != nil {
+		t.Fatalf("NewRuleHook: %v", err)
+	}
+	if err := h.TransformRequest("/v1/messages", map[string]interface{}{}); err == nil || !strings.Contains(err.Error(), "transform_request") {
+		t.Fatalf("runaway script error = %v", err)
+	}
+
+	h, err = NewRuleHook(config.HookRule{Script: `function transform_output(text) error("boom") end`})
+	if err != nil {
+		t.Fatalf("NewRuleHook: %v", err)
+	}
+	if got := h.NewOutputFilter("/v1/messages").Filter("keep"); got != "keep" {
+		t.Fatalf("failed output script should pass text through, got %q", got)
+	}
+}
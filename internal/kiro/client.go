@@ -0,0 +1,352 @@
+package kiro
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/goccy/go-json"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"orchids-api/internal/config"
+	"orchids-api/internal/debug"
+	"orchids-api/internal/prompt"
+	"orchids-api/internal/store"
+	"orchids-api/internal/upstream"
+	"orchids-api/internal/util"
+)
+
+const defaultRequestTimeout = 120 * time.Second
+
+type Client struct {
+	config     *config.Config
+	account    *store.Account
+	httpClient *http.Client
+	session    *session
+}
+
+func NewFromAccount(acc *store.Account, cfg *config.Config) *Client {
+	timeout := defaultRequestTimeout
+	if cfg != nil && cfg.RequestTimeout > 0 {
+		timeout = time.Duration(cfg.RequestTimeout) * time.Second
+	}
+	proxyFunc := http.ProxyFromEnvironment
+	if cfg != nil {
+		proxyFunc = util.ProxyFunc(cfg.ProxyHTTP, cfg.ProxyHTTPS, cfg.ProxyUser, cfg.ProxyPass, cfg.ProxyBypass)
+	}
+	client := &http.Client{
+		Timeout:   timeout,
+		Transport: &http.Transport{Proxy: proxyFunc, ForceAttemptHTTP2: true},
+	}
+	if acc == nil {
+		return &Client{config: cfg, httpClient: client}
+	}
+
+	sess := getSession(acc.ID, strings.TrimSpace(acc.RefreshToken))
+	// Reuse a still-valid access token persisted from an earlier refresh.
+	if token := strings.TrimSpace(acc.Token); token != "" {
+		sess.mu.Lock()
+		if sess.accessToken == "" {
+			sess.accessToken = token
+		}
+		sess.mu.Unlock()
+	}
+	return &Client{
+		config:     cfg,
+		account:    acc,
+		httpClient: client,
+		session:    sess,
+	}
+}
+
+func (c *Client) SendRequest(ctx context.Context, prompt string, chatHistory []interface{}, model string, onMessage func(upstream.SSEMessage), logger *debug.Logger) error {
+	req := upstream.UpstreamRequest{
+		Prompt:      prompt,
+		ChatHistory: chatHistory,
+		Model:       model,
+	}
+	return c.SendRequestWithPayload(ctx, req, onMessage, logger)
+}
+
+func (c *Client) SendRequestWithPayload(ctx context.Context, req upstream.UpstreamRequest, onMessage func(upstream.SSEMessage), logger *debug.Logger) error {
+	if c.session == nil {
+		return fmt.Errorf("kiro session not initialized")
+	}
+	if len(req.Messages) == 0 && strings.TrimSpace(req.Prompt) != "" {
+		req.Messages = []prompt.Message{{Role: "user", Content: prompt.MessageContent{Text: req.Prompt}}}
+	}
+
+	resp, err := c.doGenerate(ctx, req, logger)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	ctxDone := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = resp.Body.Close()
+		case <-ctxDone:
+		}
+	}()
+	defer close(ctxDone)
+
+	err = c.consumeStream(upstream.MeterBody(ctx, resp.Body), onMessage, logger)
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// GenerateURL returns the generateAssistantResponse endpoint for a configured
+// base URL, e.g. a CodeWhisperer endpoint in another region. An empty base
+// URL means the default endpoint; the full endpoint is also accepted.
+func GenerateURL(baseURL string) string {
+	base := strings.TrimRight(strings.TrimSpace(baseURL), "/")
+	if base == "" {
+		return apiURL
+	}
+	if strings.HasSuffix(base, generatePath) {
+		return base
+	}
+	return base + generatePath
+}
+
+// doGenerate sends the request, refreshing the access token and retrying
+// once when Kiro rejects it as expired.
+func (c *Client) doGenerate(ctx context.Context, req upstream.UpstreamRequest, logger *debug.Logger) (*http.Response, error) {
+	endpoint := apiURL
+	if c.account != nil {
+		endpoint = GenerateURL(c.account.BaseURL)
+	}
+	for attempt := 0; ; attempt++ {
+		token, err := c.session.token(ctx, c.httpClient)
+		if err != nil {
+			return nil, err
+		}
+		_, _, profileArn := c.session.snapshot()
+		payload, err := buildRequest(req, profileArn)
+		if err != nil {
+			return nil, err
+		}
+
+		request, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(payload))
+		if err != nil {
+			return nil, err
+		}
+		request.Header.Set("Content-Type", "application/json")
+		request.Header.Set("Accept", "application/vnd.amazon.eventstream")
+		request.Header.Set("Authorization", "Bearer "+token)
+		request.Header.Set("User-Agent", userAgent)
+		request.Header.Set("X-Amz-User-Agent", amzUserAgent)
+		request.Header.Set("X-Amzn-Kiro-Agent-Mode", agentMode)
+		request.Header.Set("Amz-Sdk-Request", fmt.Sprintf("attempt=%d; max=2", attempt+1))
+		upstream.ApplyAccountHeaders(request.Header, c.account)
+		upstream.ApplyTraceHeaders(ctx, request.Header)
+		upstream.MeterSent(ctx, len(payload))
+
+		if logger != nil {
+			logger.LogUpstreamRequest(endpoint, map[string]string{"content-type": "application/json"}, payload)
+		}
+		if c.config != nil && c.config.DebugEnabled {
+			slog.Debug("Kiro: Dispatching request", "model", ResolveModel(req.Model), "body_size", len(payload), "attempt", attempt+1)
+		}
+
+		result, err := upstream.GetAccountBreaker(c.breakerKey()).Execute(func() (interface{}, error) {
+			return c.httpClient.Do(request)
+		})
+		if err != nil {
+			return nil, err
+		}
+		resp, ok := result.(*http.Response)
+		if !ok || resp == nil {
+			return nil, fmt.Errorf("kiro api error: unexpected response type")
+		}
+		if resp.StatusCode == http.StatusOK {
+			return resp, nil
+		}
+
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		resp.Body.Close()
+		if logger != nil {
+			logger.LogUpstreamHTTPError(endpoint, resp.StatusCode, string(body), nil)
+		}
+		if (resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden) && attempt == 0 {
+			slog.Info("Kiro: access token rejected, refreshing", "status", resp.StatusCode)
+			c.session.invalidate(token)
+			continue
+		}
+		slog.Warn("Kiro request failed", "status", resp.StatusCode, "body", string(body))
+		return nil, fmt.Errorf("kiro api error: HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+}
+
+type pendingToolUse struct {
+	name  string
+	input strings.Builder
+}
+
+// consumeStream maps Kiro stream events onto the shared model.* messages.
+// Kiro sends no stop reason; a tool call cut off without its stop marker, or
+// a full context window, means the output hit its limit and finishes as
+// max_tokens.
+func (c *Client) consumeStream(body io.Reader, onMessage func(upstream.SSEMessage), logger *debug.Logger) error {
+	reader := newEventStreamReader(body)
+	tools := make(map[string]*pendingToolUse)
+	emitted := make(map[string]bool)
+	var toolOrder []string
+	toolCallSeen, truncated := false, false
+	inputTokens := 0
+	credits := 0.0
+
+	emitTool := func(id string) {
+		pending, ok := tools[id]
+		if !ok {
+			return
+		}
+		delete(tools, id)
+		emitted[id] = true
+		input := strings.TrimSpace(pending.input.String())
+		if input == "" {
+			input = "{}"
+		}
+		toolCallSeen = true
+		onMessage(upstream.SSEMessage{Type: "model.tool-call", Event: map[string]interface{}{"toolCallId": id, "toolName": pending.name, "input": input}})
+	}
+
+	for {
+		ev, err := reader.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if logger != nil {
+			logger.LogUpstreamSSE("kiro_"+ev.eventType(), string(ev.payload))
+		}
+		if ev.isException() {
+			var exc struct {
+				Message string `json:"message"`
+			}
+			_ = json.Unmarshal(ev.payload, &exc)
+			if exc.Message == "" {
+				exc.Message = strings.TrimSpace(string(ev.payload))
+			}
+			return fmt.Errorf("kiro stream error: %s: %s", ev.eventType(), exc.Message)
+		}
+
+		var data map[string]interface{}
+		if err := json.Unmarshal(ev.payload, &data); err != nil {
+			continue
+		}
+		switch ev.eventType() {
+		case "assistantResponseEvent":
+			if text, _ := data["content"].(string); text != "" {
+				onMessage(upstream.SSEMessage{Type: "model.text-delta", Event: map[string]interface{}{"delta": text}})
+			}
+		case "toolUseEvent":
+			id, _ := data["toolUseId"].(string)
+			if id == "" || emitted[id] {
+				continue
+			}
+			pending, ok := tools[id]
+			if !ok {
+				name, _ := data["name"].(string)
+				pending = &pendingToolUse{name: name}
+				tools[id] = pending
+				toolOrder = append(toolOrder, id)
+			}
+			switch input := data["input"].(type) {
+			case string:
+				pending.input.WriteString(input)
+			case map[string]interface{}:
+				raw, _ := json.Marshal(input)
+				pending.input.Reset()
+				pending.input.Write(raw)
+			}
+			if stop, _ := data["stop"].(bool); stop {
+				emitTool(id)
+			}
+		case "contextUsageEvent":
+			if pct, ok := data["contextUsagePercentage"].(float64); ok && pct > 0 {
+				inputTokens = int(pct / 100 * contextWindow)
+				truncated = truncated || pct >= 100
+			}
+		case "meteringEvent":
+			if usage, ok := data["usage"].(float64); ok {
+				credits += usage
+			}
+		}
+	}
+
+	// Tool calls cut off without a stop marker are still delivered in order.
+	for _, id := range toolOrder {
+		if _, pending := tools[id]; pending {
+			truncated = true
+		}
+		emitTool(id)
+	}
+	if credits > 0 && c.config != nil && c.config.DebugEnabled {
+		slog.Debug("Kiro: request metered", "credits", credits, "account", c.breakerKey())
+	}
+
+	finish := map[string]interface{}{"finishReason": "end_turn"}
+	switch {
+	case truncated:
+		finish["finishReason"] = "max_tokens"
+	case toolCallSeen:
+		finish["finishReason"] = "tool_use"
+	}
+	if inputTokens > 0 {
+		finish["usage"] = map[string]interface{}{"inputTokens": inputTokens}
+	}
+	onMessage(upstream.SSEMessage{Type: "model.finish", Event: finish})
+	return nil
+}
+
+func (c *Client) breakerKey() string {
+	if c == nil || c.account == nil {
+		return "kiro:default"
+	}
+	if name := strings.TrimSpace(c.account.Name); name != "" {
+		return "kiro:" + name
+	}
+	if c.account.ID > 0 {
+		return fmt.Sprintf("kiro:%d", c.account.ID)
+	}
+	return "kiro:default"
+}
+
+// RefreshAccount forces a token refresh and returns the new access token.
+func (c *Client) RefreshAccount(ctx context.Context) (string, error) {
+	if c.session == nil {
+		return "", fmt.Errorf("kiro session not initialized")
+	}
+	c.session.mu.Lock()
+	defer c.session.mu.Unlock()
+	return c.session.refreshLocked(ctx, c.httpClient)
+}
+
+// SyncAccountState copies the in-memory access token and rotated refresh
+// token back onto the account and reports whether anything changed.
+func (c *Client) SyncAccountState() bool {
+	if c == nil || c.session == nil || c.account == nil {
+		return false
+	}
+	accessToken, refreshToken, _ := c.session.snapshot()
+	changed := false
+	if accessToken != "" && accessToken != c.account.Token {
+		c.account.Token = accessToken
+		changed = true
+	}
+	if refreshToken != "" && refreshToken != c.account.RefreshToken {
+		c.account.RefreshToken = refreshToken
+		changed = true
+	}
+	return changed
+}
@@ -0,0 +1,232 @@
+package kiro
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/goccy/go-json"
+
+	"orchids-api/internal/prompt"
+	"orchids-api/internal/store"
+	"orchids-api/internal/upstream"
+)
+
+func TestGenerateURL(t *testing.T) {
+	cases := map[string]string{
+		"": apiURL,
+		"https://codewhisperer.eu-central-1.amazonaws.com":                           "https://codewhisperer.eu-central-1.amazonaws.com/generateAssistantResponse",
+		"https://codewhisperer.eu-central-1.amazonaws.com/":                          "https://codewhisperer.eu-central-1.amazonaws.com/generateAssistantResponse",
+		"https://codewhisperer.eu-central-1.amazonaws.com/generateAssistantResponse": "https://codewhisperer.eu-central-1.amazonaws.com/generateAssistantResponse",
+	}
+	for in, want := range cases {
+		if got := GenerateURL(in); got != want {
+			t.Fatalf("GenerateURL(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+// encodeEvent builds one AWS event stream frame with string headers.
+func encodeEvent(headers map[string]string, payload string) []byte {
+	var hb bytes.Buffer
+	for name, value := range headers {
+		hb.WriteByte(byte(len(name)))
+		hb.WriteString(name)
+		hb.WriteByte(7)
+		binary.Write(&hb, binary.BigEndian, uint16(len(value)))
+		hb.WriteString(value)
+	}
+	total := uint32(eventPreludeLen + hb.Len() + len(payload) + eventCRCLen)
+	var frame bytes.Buffer
+	binary.Write(&frame, binary.BigEndian, total)
+	binary.Write(&frame, binary.BigEndian, uint32(hb.Len()))
+	binary.Write(&frame, binary.BigEndian, crc32.ChecksumIEEE(frame.Bytes()))
+	frame.Write(hb.Bytes())
+	frame.WriteString(payload)
+	binary.Write(&frame, binary.BigEndian, crc32.ChecksumIEEE(frame.Bytes()))
+	return frame.Bytes()
+}
+
+func event(eventType, payload string) []byte {
+	return encodeEvent(map[string]string{":message-type": "event", ":event-type": eventType}, payload)
+}
+
+func TestEventStreamReaderRejectsCorruptFrame(t *testing.T) {
+	frame := event("assistantResponseEvent", `{"content":"hi"}`)
+	frame[len(frame)-6] ^= 0xff
+	if _, err := newEventStreamReader(bytes.NewReader(frame)).next(); err == nil {
+		t.Fatal("expected checksum error")
+	}
+}
+
+func TestSendRequestWithPayload(t *testing.T) {
+	var refreshes, generates atomic.Int32
+	var lastBody []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/refreshToken":
+			refreshes.Add(1)
+			w.Write([]byte(`{"accessToken":"fresh","refreshToken":"rt-2","profileArn":"arn:test","expiresIn":3600}`))
+		case "/generateAssistantResponse":
+			generates.Add(1)
+			if r.Header.Get("Authorization") != "Bearer fresh" {
+				w.WriteHeader(http.StatusForbidden)
+				w.Write([]byte(`{"message":"token expired"}`))
+				return
+			}
+			lastBody, _ = io.ReadAll(r.Body)
+			w.Write(event("assistantResponseEvent", `{"content":"Reading "}`))
+			w.Write(event("assistantResponseEvent", `{"content":"file"}`))
+			w.Write(event("toolUseEvent", `{"name":"Read","toolUseId":"tu-1","input":"{\"path\":"}`))
+			w.Write(event("toolUseEvent", `{"name":"Read","toolUseId":"tu-1","input":"\"a.go\"}"}`))
+			w.Write(event("toolUseEvent", `{"name":"Read","toolUseId":"tu-1","stop":true}`))
+			w.Write(event("meteringEvent", `{"unit":"credit","usage":0.25}`))
+			w.Write(event("contextUsageEvent", `{"contextUsagePercentage":1.5}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	oldRefresh, oldAPI := refreshURL, apiURL
+	refreshURL, apiURL = srv.URL+"/refreshToken", srv.URL+"/generateAssistantResponse"
+	t.Cleanup(func() { refreshURL, apiURL = oldRefresh, oldAPI })
+
+	acc := &store.Account{ID: 9001, Name: "kiro-test", AccountType: "kiro", RefreshToken: "rt-1", Token: "stale"}
+	client := NewFromAccount(acc, nil)
+
+	var messages []upstream.SSEMessage
+	req := upstream.UpstreamRequest{
+		Model:  "kiro-claude-sonnet-4-5",
+		System: []prompt.SystemItem{{Type: "text", Text: "Be brief."}},
+		Messages: []prompt.Message{
+			{Role: "user", Content: prompt.MessageContent{Text: "open main.go"}},
+			{Role: "assistant", Content: prompt.MessageContent{Blocks: []prompt.ContentBlock{
+				{Type: "tool_use", ID: "tu-0", Name: "Read", Input: map[string]interface{}{"path": "main.go"}},
+			}}},
+			{Role: "user", Content: prompt.MessageContent{Blocks: []prompt.ContentBlock{
+				{Type: "tool_result", ToolUseID: "tu-0", Content: "package main"},
+			}}},
+		},
+		Tools: []interface{}{map[string]interface{}{
+			"name":         "Read",
+			"description":  "Read a file",
+			"input_schema": map[string]interface{}{"type": "object"},
+		}},
+	}
+	err := client.SendRequestWithPayload(context.Background(), req, func(msg upstream.SSEMessage) {
+		messages = append(messages, msg)
+	}, nil)
+	if err != nil {
+		t.Fatalf("SendRequestWithPayload: %v", err)
+	}
+	if refreshes.Load() != 1 || generates.Load() != 2 {
+		t.Fatalf("expected one refresh after the stale token was rejected, refreshes=%d generates=%d", refreshes.Load(), generates.Load())
+	}
+
+	var text strings.Builder
+	var toolInput string
+	var finish map[string]interface{}
+	for _, msg := range messages {
+		switch msg.Type {
+		case "model.text-delta":
+			text.WriteString(msg.Event["delta"].(string))
+		case "model.tool-call":
+			toolInput, _ = msg.Event["input"].(string)
+		case "model.finish":
+			finish = msg.Event
+		}
+	}
+	if text.String() != "Reading file" {
+		t.Fatalf("text = %q", text.String())
+	}
+	if toolInput != `{"path":"a.go"}` {
+		t.Fatalf("tool input = %q", toolInput)
+	}
+	if finish["finishReason"] != "tool_use" {
+		t.Fatalf("finish = %v", finish)
+	}
+	if usage, _ := finish["usage"].(map[string]interface{}); usage["inputTokens"] != 3000 {
+		t.Fatalf("usage = %v", finish["usage"])
+	}
+
+	var sent generateRequest
+	if err := json.Unmarshal(lastBody, &sent); err != nil {
+		t.Fatalf("decode request: %v", err)
+	}
+	state := sent.ConversationState
+	if sent.ProfileArn != "arn:test" || len(state.History) != 2 {
+		t.Fatalf("unexpected request: %s", lastBody)
+	}
+	if got := state.History[0].UserInputMessage.Content; got != "Be brief.\n\nopen main.go" {
+		t.Fatalf("system prompt not folded into first turn: %q", got)
+	}
+	if uses := state.History[1].AssistantResponseMessage.ToolUses; len(uses) != 1 || uses[0].ToolUseID != "tu-0" {
+		t.Fatalf("tool uses = %+v", uses)
+	}
+	current := state.CurrentMessage.UserInputMessage
+	if current.ModelID != "claude-sonnet-4.5" || current.Content != toolResultPlaceholder {
+		t.Fatalf("current message = %+v", current)
+	}
+	if current.Context == nil || len(current.Context.ToolResults) != 1 || len(current.Context.Tools) != 1 {
+		t.Fatalf("current context = %+v", current.Context)
+	}
+
+	if !client.SyncAccountState() || acc.Token != "fresh" || acc.RefreshToken != "rt-2" {
+		t.Fatalf("account not synced: token=%q refresh=%q", acc.Token, acc.RefreshToken)
+	}
+}
+
+func TestBuildRequestInlinesToolsWithoutDefinitions(t *testing.T) {
+	req := upstream.UpstreamRequest{
+		NoTools: true,
+		Messages: []prompt.Message{
+			{Role: "user", Content: prompt.MessageContent{Text: "hi"}},
+			{Role: "assistant", Content: prompt.MessageContent{Blocks: []prompt.ContentBlock{
+				{Type: "tool_use", ID: "tu-0", Name: "Bash", Input: map[string]interface{}{"cmd": "ls"}},
+			}}},
+			{Role: "user", Content: prompt.MessageContent{Blocks: []prompt.ContentBlock{
+				{Type: "tool_result", ToolUseID: "tu-0", Content: "a.go"},
+			}}},
+		},
+	}
+	raw, err := buildRequest(req, "")
+	if err != nil {
+		t.Fatalf("buildRequest: %v", err)
+	}
+	if bytes.Contains(raw, []byte("toolUses")) || bytes.Contains(raw, []byte("toolResults")) {
+		t.Fatalf("tool blocks must be inlined when no tools are sent: %s", raw)
+	}
+	if !bytes.Contains(raw, []byte("a.go")) {
+		t.Fatalf("tool result text missing: %s", raw)
+	}
+}
+
+func TestConsumeStreamTruncated(t *testing.T) {
+	cases := map[string][]byte{
+		"tool cut off": append(event("assistantResponseEvent", `{"content":"Writing"}`),
+			event("toolUseEvent", `{"name":"Write","toolUseId":"tu-1","input":"{\"path\":"}`)...),
+		"context full": append(event("assistantResponseEvent", `{"content":"Writing"}`),
+			event("contextUsageEvent", `{"contextUsagePercentage":100}`)...),
+	}
+	for name, body := range cases {
+		var finish map[string]interface{}
+		err := NewFromAccount(&store.Account{ID: 9002, Name: "kiro-truncated"}, nil).consumeStream(bytes.NewReader(body), func(msg upstream.SSEMessage) {
+			if msg.Type == "model.finish" {
+				finish = msg.Event
+			}
+		}, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if finish["finishReason"] != "max_tokens" {
+			t.Fatalf("%s: finish = %v", name, finish)
+		}
+	}
+}
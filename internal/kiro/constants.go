@@ -0,0 +1,47 @@
+package kiro
+
+// Upstream endpoints are variables so tests can point them at a local server.
+var (
+	refreshURL = "https://prod.us-east-1.auth.desktop.kiro.dev/refreshToken"
+	apiURL     = "https://codewhisperer.us-east-1.amazonaws.com/generateAssistantResponse"
+)
+
+const generatePath = "/generateAssistantResponse"
+
+const (
+	kiroVersion   = "0.2.13"
+	userAgent     = "aws-sdk-js/1.0.18 ua/2.1 os/darwin lang/js md/nodejs api/codewhispererstreaming#1.0.18 m/E KiroIDE-" + kiroVersion
+	amzUserAgent  = "aws-sdk-js/1.0.18 KiroIDE-" + kiroVersion
+	agentMode     = "vibe"
+	chatOrigin    = "AI_EDITOR"
+	chatTrigger   = "MANUAL"
+	defaultModel  = "claude-sonnet-4.5"
+	contextWindow = 200000
+
+	// Kiro rejects user turns with empty content, e.g. tool-result-only turns.
+	toolResultPlaceholder = "Tool results provided."
+	continuePlaceholder   = "Continue"
+	maxToolDescription    = 10000
+)
+
+// modelMap maps the model IDs exposed on the kiro channel to upstream model IDs.
+var modelMap = map[string]string{
+	"kiro-auto":              "auto",
+	"kiro-claude-sonnet-4-5": "claude-sonnet-4.5",
+	"kiro-claude-sonnet-4":   "claude-sonnet-4",
+	"kiro-claude-haiku-4-5":  "claude-haiku-4.5",
+	"kiro-claude-opus-4-5":   "claude-opus-4.5",
+}
+
+// ResolveModel returns the upstream Kiro model ID for a channel model ID.
+// Unknown IDs are passed through so newly released upstream models work
+// without a code change.
+func ResolveModel(model string) string {
+	if mapped, ok := modelMap[model]; ok {
+		return mapped
+	}
+	if model == "" {
+		return defaultModel
+	}
+	return model
+}
@@ -0,0 +1,138 @@
+package kiro
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// Kiro streams responses in the AWS event stream encoding
+// (application/vnd.amazon.eventstream): length-prefixed binary frames with
+// typed headers and a JSON payload, each protected by CRC32 checksums.
+
+const (
+	eventPreludeLen = 12
+	eventCRCLen     = 4
+	maxEventLen     = 16 << 20
+)
+
+type streamEvent struct {
+	headers map[string]string
+	payload []byte
+}
+
+// eventType returns the ":event-type" header, or ":exception-type" for
+// exception frames.
+func (e streamEvent) eventType() string {
+	if e.headers[":message-type"] == "exception" || e.headers[":message-type"] == "error" {
+		if t := e.headers[":exception-type"]; t != "" {
+			return t
+		}
+		return e.headers[":error-code"]
+	}
+	return e.headers[":event-type"]
+}
+
+func (e streamEvent) isException() bool {
+	t := e.headers[":message-type"]
+	return t == "exception" || t == "error"
+}
+
+type eventStreamReader struct {
+	r io.Reader
+}
+
+func newEventStreamReader(r io.Reader) *eventStreamReader {
+	return &eventStreamReader{r: r}
+}
+
+// next reads one frame. It returns io.EOF at a clean end of stream.
+func (er *eventStreamReader) next() (streamEvent, error) {
+	var prelude [eventPreludeLen]byte
+	if _, err := io.ReadFull(er.r, prelude[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return streamEvent{}, fmt.Errorf("kiro event stream: truncated prelude")
+		}
+		return streamEvent{}, err
+	}
+	totalLen := binary.BigEndian.Uint32(prelude[0:4])
+	headersLen := binary.BigEndian.Uint32(prelude[4:8])
+	if crc32.ChecksumIEEE(prelude[:8]) != binary.BigEndian.Uint32(prelude[8:12]) {
+		return streamEvent{}, fmt.Errorf("kiro event stream: prelude checksum mismatch")
+	}
+	if totalLen < eventPreludeLen+eventCRCLen || totalLen > maxEventLen || headersLen > totalLen-eventPreludeLen-eventCRCLen {
+		return streamEvent{}, fmt.Errorf("kiro event stream: invalid frame length %d", totalLen)
+	}
+
+	frame := make([]byte, totalLen)
+	copy(frame, prelude[:])
+	if _, err := io.ReadFull(er.r, frame[eventPreludeLen:]); err != nil {
+		return streamEvent{}, fmt.Errorf("kiro event stream: truncated frame: %w", err)
+	}
+	msgCRC := binary.BigEndian.Uint32(frame[totalLen-eventCRCLen:])
+	if crc32.ChecksumIEEE(frame[:totalLen-eventCRCLen]) != msgCRC {
+		return streamEvent{}, fmt.Errorf("kiro event stream: message checksum mismatch")
+	}
+
+	headers, err := parseEventHeaders(frame[eventPreludeLen : eventPreludeLen+headersLen])
+	if err != nil {
+		return streamEvent{}, err
+	}
+	return streamEvent{
+		headers: headers,
+		payload: frame[eventPreludeLen+headersLen : totalLen-eventCRCLen],
+	}, nil
+}
+
+// parseEventHeaders decodes the header block. Only string headers are kept;
+// the other value types are skipped.
+func parseEventHeaders(b []byte) (map[string]string, error) {
+	headers := make(map[string]string)
+	for len(b) > 0 {
+		nameLen := int(b[0])
+		if len(b) < 1+nameLen+1 {
+			return nil, fmt.Errorf("kiro event stream: truncated header name")
+		}
+		name := string(b[1 : 1+nameLen])
+		valueType := b[1+nameLen]
+		b = b[2+nameLen:]
+
+		var size int
+		switch valueType {
+		case 0, 1: // bool true / false
+			size = 0
+		case 2: // byte
+			size = 1
+		case 3: // short
+			size = 2
+		case 4: // int
+			size = 4
+		case 5, 8: // long, timestamp
+			size = 8
+		case 9: // uuid
+			size = 16
+		case 6, 7: // bytes, string
+			if len(b) < 2 {
+				return nil, fmt.Errorf("kiro event stream: truncated header value")
+			}
+			n := int(binary.BigEndian.Uint16(b[:2]))
+			b = b[2:]
+			if len(b) < n {
+				return nil, fmt.Errorf("kiro event stream: truncated header value")
+			}
+			if valueType == 7 {
+				headers[name] = string(b[:n])
+			}
+			b = b[n:]
+			continue
+		default:
+			return nil, fmt.Errorf("kiro event stream: unknown header type %d", valueType)
+		}
+		if len(b) < size {
+			return nil, fmt.Errorf("kiro event stream: truncated header value")
+		}
+		b = b[size:]
+	}
+	return headers, nil
+}
@@ -0,0 +1,325 @@
+package kiro
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/goccy/go-json"
+	"strings"
+
+	"orchids-api/internal/prompt"
+	"orchids-api/internal/upstream"
+)
+
+type generateRequest struct {
+	ConversationState conversationState `json:"conversationState"`
+	ProfileArn        string            `json:"profileArn,omitempty"`
+}
+
+type conversationState struct {
+	ChatTriggerType string        `json:"chatTriggerType"`
+	ConversationID  string        `json:"conversationId"`
+	CurrentMessage  currentTurn   `json:"currentMessage"`
+	History         []historyTurn `json:"history,omitempty"`
+}
+
+type currentTurn struct {
+	UserInputMessage userMessage `json:"userInputMessage"`
+}
+
+type historyTurn struct {
+	UserInputMessage         *userMessage      `json:"userInputMessage,omitempty"`
+	AssistantResponseMessage *assistantMessage `json:"assistantResponseMessage,omitempty"`
+}
+
+type userMessage struct {
+	Content string       `json:"content"`
+	ModelID string       `json:"modelId"`
+	Origin  string       `json:"origin"`
+	Images  []imageBlock `json:"images,omitempty"`
+	Context *userContext `json:"userInputMessageContext,omitempty"`
+}
+
+type userContext struct {
+	ToolResults []toolResult `json:"toolResults,omitempty"`
+	Tools       []toolSpec   `json:"tools,omitempty"`
+}
+
+type imageBlock struct {
+	Format string `json:"format"`
+	Source struct {
+		Bytes string `json:"bytes"`
+	} `json:"source"`
+}
+
+type toolResult struct {
+	ToolUseID string              `json:"toolUseId"`
+	Content   []map[string]string `json:"content"`
+	Status    string              `json:"status"`
+}
+
+type toolSpec struct {
+	ToolSpecification struct {
+		Name        string                 `json:"name"`
+		Description string                 `json:"description"`
+		InputSchema map[string]interface{} `json:"inputSchema"`
+	} `json:"toolSpecification"`
+}
+
+type assistantMessage struct {
+	Content  string    `json:"content"`
+	ToolUses []toolUse `json:"toolUses,omitempty"`
+}
+
+type toolUse struct {
+	ToolUseID string      `json:"toolUseId"`
+	Name      string      `json:"name"`
+	Input     interface{} `json:"input"`
+}
+
+// turn is one merged Anthropic message before it is split into Kiro's
+// history / current message shape.
+type turn struct {
+	role        string
+	text        []string
+	images      []imageBlock
+	toolUses    []toolUse
+	toolResults []toolResult
+}
+
+// buildRequest converts an Anthropic-style upstream request into a Kiro
+// generateAssistantResponse body.
+func buildRequest(req upstream.UpstreamRequest, profileArn string) ([]byte, error) {
+	modelID := ResolveModel(req.Model)
+	var tools []toolSpec
+	if !req.NoTools {
+		tools = convertTools(req.Tools)
+	}
+	// Without tool definitions Kiro rejects histories that reference tools,
+	// so tool traffic is inlined as text instead.
+	inlineTools := len(tools) == 0
+
+	turns := mergeTurns(req.Messages, inlineTools)
+	if len(turns) == 0 || turns[len(turns)-1].role != "user" {
+		turns = append(turns, &turn{role: "user", text: []string{continuePlaceholder}})
+	}
+	if system := systemText(req.System); system != "" {
+		turns[0].text = append([]string{system}, turns[0].text...)
+	}
+
+	history := make([]historyTurn, 0, len(turns)-1)
+	for _, t := range turns[:len(turns)-1] {
+		if t.role == "user" {
+			history = append(history, historyTurn{UserInputMessage: t.userMessage(modelID)})
+			continue
+		}
+		msg := &assistantMessage{Content: strings.Join(t.text, "\n\n"), ToolUses: t.toolUses}
+		if msg.Content == "" && len(msg.ToolUses) == 0 {
+			msg.Content = continuePlaceholder
+		}
+		history = append(history, historyTurn{AssistantResponseMessage: msg})
+	}
+
+	current := turns[len(turns)-1].userMessage(modelID)
+	if len(tools) > 0 {
+		if current.Context == nil {
+			current.Context = &userContext{}
+		}
+		current.Context.Tools = tools
+	}
+
+	body := generateRequest{
+		ConversationState: conversationState{
+			ChatTriggerType: chatTrigger,
+			ConversationID:  conversationID(req.ChatSessionID),
+			CurrentMessage:  currentTurn{UserInputMessage: *current},
+			History:         history,
+		},
+		ProfileArn: profileArn,
+	}
+	return json.Marshal(body)
+}
+
+func (t *turn) userMessage(modelID string) *userMessage {
+	msg := &userMessage{
+		Content: strings.Join(t.text, "\n\n"),
+		ModelID: modelID,
+		Origin:  chatOrigin,
+		Images:  t.images,
+	}
+	if len(t.toolResults) > 0 {
+		msg.Context = &userContext{ToolResults: t.toolResults}
+	}
+	if msg.Content == "" {
+		if len(t.toolResults) > 0 {
+			msg.Content = toolResultPlaceholder
+		} else {
+			msg.Content = continuePlaceholder
+		}
+	}
+	return msg
+}
+
+// mergeTurns flattens messages into strictly alternating user/assistant
+// turns starting with a user turn, merging consecutive same-role messages.
+func mergeTurns(messages []prompt.Message, inlineTools bool) []*turn {
+	var turns []*turn
+	for _, m := range messages {
+		role := "user"
+		if m.Role == "assistant" {
+			role = "assistant"
+		}
+		if len(turns) == 0 && role == "assistant" {
+			turns = append(turns, &turn{role: "user", text: []string{continuePlaceholder}})
+		}
+		var t *turn
+		if len(turns) > 0 && turns[len(turns)-1].role == role {
+			t = turns[len(turns)-1]
+		} else {
+			t = &turn{role: role}
+			turns = append(turns, t)
+		}
+		if m.Content.IsString() {
+			if text := strings.TrimSpace(m.Content.GetText()); text != "" {
+				t.text = append(t.text, text)
+			}
+			continue
+		}
+		for _, block := range m.Content.GetBlocks() {
+			t.addBlock(block, inlineTools)
+		}
+	}
+	return turns
+}
+
+func (t *turn) addBlock(block prompt.ContentBlock, inlineTools bool) {
+	switch block.Type {
+	case "text":
+		if text := strings.TrimSpace(block.Text); text != "" {
+			t.text = append(t.text, text)
+		}
+	case "image":
+		if t.role == "user" && block.Source != nil && block.Source.Type == "base64" && block.Source.Data != "" {
+			img := imageBlock{Format: imageFormat(block.Source.MediaType)}
+			img.Source.Bytes = block.Source.Data
+			t.images = append(t.images, img)
+		}
+	case "tool_use":
+		if t.role != "assistant" {
+			return
+		}
+		input := block.Input
+		if input == nil {
+			input = map[string]interface{}{}
+		}
+		if inlineTools {
+			raw, _ := json.Marshal(input)
+			t.text = append(t.text, fmt.Sprintf("[Called tool %s with input: %s]", block.Name, raw))
+			return
+		}
+		t.toolUses = append(t.toolUses, toolUse{ToolUseID: block.ID, Name: block.Name, Input: input})
+	case "tool_result":
+		if t.role != "user" {
+			return
+		}
+		content := toolResultText(block.Content)
+		if inlineTools {
+			t.text = append(t.text, fmt.Sprintf("[Tool result for %s]\n%s", block.ToolUseID, content))
+			return
+		}
+		status := "success"
+		if block.IsError {
+			status = "error"
+		}
+		t.toolResults = append(t.toolResults, toolResult{
+			ToolUseID: block.ToolUseID,
+			Content:   []map[string]string{{"text": content}},
+			Status:    status,
+		})
+	}
+}
+
+func toolResultText(content interface{}) string {
+	switch v := content.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []interface{}:
+		var parts []string
+		for _, item := range v {
+			if m, ok := item.(map[string]interface{}); ok {
+				if text, ok := m["text"].(string); ok {
+					parts = append(parts, text)
+				}
+			}
+		}
+		return strings.Join(parts, "\n")
+	default:
+		raw, _ := json.Marshal(v)
+		return string(raw)
+	}
+}
+
+func imageFormat(mediaType string) string {
+	switch strings.ToLower(strings.TrimSpace(mediaType)) {
+	case "image/jpeg", "image/jpg":
+		return "jpeg"
+	case "image/gif":
+		return "gif"
+	case "image/webp":
+		return "webp"
+	default:
+		return "png"
+	}
+}
+
+func systemText(items []prompt.SystemItem) string {
+	var parts []string
+	for _, item := range items {
+		if text := strings.TrimSpace(item.Text); text != "" {
+			parts = append(parts, text)
+		}
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// convertTools maps Anthropic tool definitions to Kiro tool specifications.
+func convertTools(tools []interface{}) []toolSpec {
+	out := make([]toolSpec, 0, len(tools))
+	for _, raw := range tools {
+		tool, ok := raw.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		name, _ := tool["name"].(string)
+		if strings.TrimSpace(name) == "" {
+			continue
+		}
+		description, _ := tool["description"].(string)
+		if len(description) > maxToolDescription {
+			description = description[:maxToolDescription]
+		}
+		if description == "" {
+			description = name
+		}
+		schema, _ := tool["input_schema"].(map[string]interface{})
+		if schema == nil {
+			schema = map[string]interface{}{"type": "object", "properties": map[string]interface{}{}}
+		}
+		var spec toolSpec
+		spec.ToolSpecification.Name = name
+		spec.ToolSpecification.Description = description
+		spec.ToolSpecification.InputSchema = map[string]interface{}{"json": schema}
+		out = append(out, spec)
+	}
+	return out
+}
+
+// conversationID derives a stable UUID-shaped conversation ID from the
+// proxy's chat session ID.
+func conversationID(chatSessionID string) string {
+	sum := sha256.Sum256([]byte("kiro:" + chatSessionID))
+	h := hex.EncodeToString(sum[:16])
+	return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:32]
+}
@@ -0,0 +1,126 @@
+package kiro
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/goccy/go-json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// session holds the short-lived access token of one Kiro account. Sessions
+// are shared across requests so concurrent calls refresh at most once.
+type session struct {
+	mu           sync.Mutex
+	accessToken  string
+	expiresAt    time.Time
+	refreshToken string
+	profileArn   string
+}
+
+type refreshResponse struct {
+	AccessToken  string `json:"accessToken"`
+	RefreshToken string `json:"refreshToken"`
+	ProfileArn   string `json:"profileArn"`
+	ExpiresIn    int64  `json:"expiresIn"`
+}
+
+var sessionCache sync.Map
+
+func sessionKey(accountID int64, refreshToken string) string {
+	if accountID > 0 {
+		return fmt.Sprintf("kiro:%d", accountID)
+	}
+	if len(refreshToken) > 16 {
+		return "kiro:tok:" + refreshToken[:16]
+	}
+	return "kiro:tok:" + refreshToken
+}
+
+func getSession(accountID int64, refreshToken string) *session {
+	key := sessionKey(accountID, refreshToken)
+	val, _ := sessionCache.LoadOrStore(key, &session{refreshToken: refreshToken})
+	sess := val.(*session)
+	sess.mu.Lock()
+	if refreshToken != "" && sess.refreshToken != refreshToken {
+		// The account was re-imported with a new refresh token: drop the old access token.
+		sess.refreshToken = refreshToken
+		sess.accessToken = ""
+		sess.expiresAt = time.Time{}
+	}
+	sess.mu.Unlock()
+	return sess
+}
+
+// token returns a valid access token, refreshing it when it is missing or
+// about to expire.
+func (s *session) token(ctx context.Context, client *http.Client) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.accessToken != "" && (s.expiresAt.IsZero() || time.Now().Add(5*time.Minute).Before(s.expiresAt)) {
+		return s.accessToken, nil
+	}
+	return s.refreshLocked(ctx, client)
+}
+
+// invalidate forgets the access token so the next call refreshes it.
+func (s *session) invalidate(stale string) {
+	s.mu.Lock()
+	if s.accessToken == stale {
+		s.accessToken = ""
+		s.expiresAt = time.Time{}
+	}
+	s.mu.Unlock()
+}
+
+func (s *session) refreshLocked(ctx context.Context, client *http.Client) (string, error) {
+	if strings.TrimSpace(s.refreshToken) == "" {
+		return "", fmt.Errorf("kiro refresh token missing")
+	}
+	body, _ := json.Marshal(map[string]string{"refreshToken": s.refreshToken})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, refreshURL, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "KiroIDE-"+kiroVersion)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("kiro token refresh: %w", err)
+	}
+	defer resp.Body.Close()
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("kiro token refresh failed: HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(raw)))
+	}
+	var out refreshResponse
+	if err := json.Unmarshal(raw, &out); err != nil {
+		return "", fmt.Errorf("kiro token refresh: decode response: %w", err)
+	}
+	if out.AccessToken == "" {
+		return "", fmt.Errorf("kiro token refresh: empty access token")
+	}
+	s.accessToken = out.AccessToken
+	if out.RefreshToken != "" {
+		s.refreshToken = out.RefreshToken
+	}
+	if out.ProfileArn != "" {
+		s.profileArn = out.ProfileArn
+	}
+	s.expiresAt = time.Time{}
+	if out.ExpiresIn > 0 {
+		s.expiresAt = time.Now().Add(time.Duration(out.ExpiresIn) * time.Second)
+	}
+	return s.accessToken, nil
+}
+
+func (s *session) snapshot() (accessToken, refreshToken, profileArn string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.accessToken, s.refreshToken, s.profileArn
+}
@@ -0,0 +1,106 @@
+package loadbalancer
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"orchids-api/internal/featureflag"
+	"orchids-api/internal/store"
+)
+
+// ErrChannelDisabled means the requested channel is in maintenance mode.
+var ErrChannelDisabled = errors.New("channel is disabled for maintenance")
+
+// disabledChannelsFlag takes whole channels out of routing, e.g. during an
+// upstream outage, without disabling each of their accounts.
+var disabledChannelsFlag = featureflag.NewJSON("loadbalancer.disabled_channels", []string{},
+	"Channels in maintenance mode: their accounts are not selected and requests pinned to them fail with overloaded_error")
+
+// AccountChannel returns the channel an account serves; accounts without a
+// type are Orchids accounts.
+func AccountChannel(acc *store.Account) string {
+	channel := store.NormalizeChannelName(acc.AccountType)
+	if channel == "" {
+		return "orchids"
+	}
+	return channel
+}
+
+// Channels returns the configured channel records by name, cached like the
+// account list.
+func (lb *LoadBalancer) Channels(ctx context.Context) map[string]*store.Channel {
+	if lb.Store == nil {
+		return nil
+	}
+	lb.mu.RLock()
+	if lb.cachedChannels != nil && time.Now().Before(lb.channelsExpires) {
+		channels := lb.cachedChannels
+		lb.mu.RUnlock()
+		return channels
+	}
+	lb.mu.RUnlock()
+
+	val, _, _ := lb.sfGroup.Do("getChannels", func() (interface{}, error) {
+		list, err := lb.Store.ListChannels(ctx)
+		if err != nil {
+			slog.Warn("Failed to load channels", "error", err)
+		}
+		channels := make(map[string]*store.Channel, len(list))
+		for _, ch := range list {
+			channels[ch.Name] = ch
+		}
+		lb.mu.Lock()
+		lb.cachedChannels = channels
+		lb.channelsExpires = time.Now().Add(lb.cacheTTL)
+		lb.mu.Unlock()
+		return channels, nil
+	})
+	return val.(map[string]*store.Channel)
+}
+
+// Channel returns the record of the named channel, or nil when it has none.
+func (lb *LoadBalancer) Channel(ctx context.Context, name string) *store.Channel {
+	return lb.Channels(ctx)[store.NormalizeChannelName(name)]
+}
+
+// disabledChannels returns the channels currently in maintenance mode: those
+// listed in loadbalancer.disabled_channels and those whose record is disabled.
+func (lb *LoadBalancer) disabledChannels(ctx context.Context) map[string]bool {
+	var names []string
+	if err := disabledChannelsFlag.Decode(&names); err != nil {
+		slog.Warn("Ignoring invalid loadbalancer.disabled_channels", "error", err)
+	}
+	disabled := make(map[string]bool, len(names))
+	for _, name := range names {
+		if name = store.NormalizeChannelName(name); name != "" {
+			disabled[name] = true
+		}
+	}
+	for name, ch := range lb.Channels(ctx) {
+		if !ch.Enabled {
+			disabled[name] = true
+		}
+	}
+	return disabled
+}
+
+// CheckChannel returns ErrChannelDisabled when channel is in maintenance mode.
+func (lb *LoadBalancer) CheckChannel(ctx context.Context, channel string) error {
+	channel = store.NormalizeChannelName(channel)
+	if channel != "" && lb.disabledChannels(ctx)[channel] {
+		return &channelDisabledError{channel: channel}
+	}
+	return nil
+}
+
+type channelDisabledError struct {
+	channel string
+}
+
+func (e *channelDisabledError) Error() string {
+	return "channel " + e.channel + " is disabled for maintenance"
+}
+
+func (e *channelDisabledError) Unwrap() error { return ErrChannelDisabled }
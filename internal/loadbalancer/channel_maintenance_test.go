@@ -0,0 +1,49 @@
+package loadbalancer
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"orchids-api/internal/featureflag"
+	"orchids-api/internal/store"
+)
+
+func TestChannelMaintenance(t *testing.T) {
+	mr := miniredis.RunT(t)
+	s, err := store.New(store.Options{RedisAddr: mr.Addr(), RedisPrefix: "test:"})
+	if err != nil {
+		t.Fatalf("store.New: %v", err)
+	}
+	ctx := context.Background()
+	warpAcc := &store.Account{Name: "warp", AccountType: "warp", RefreshToken: "rt", Enabled: true, Weight: 1}
+	orchidsAcc := &store.Account{Name: "orchids", Enabled: true, Weight: 1}
+	for _, acc := range []*store.Account{warpAcc, orchidsAcc} {
+		if err := s.CreateAccount(ctx, acc); err != nil {
+			t.Fatalf("CreateAccount: %v", err)
+		}
+	}
+	lb := NewWithCacheTTL(s, 0)
+
+	if err := featureflag.Default.Set(ctx, s, "loadbalancer.disabled_channels", []byte(`["Warp"]`)); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	t.Cleanup(func() { featureflag.Default.Reset(ctx, s, "loadbalancer.disabled_channels") })
+
+	if _, err := lb.GetNextAccountExcludingByChannel(ctx, nil, "warp"); !errors.Is(err, ErrChannelDisabled) {
+		t.Fatalf("pinned to a disabled channel: err=%v", err)
+	}
+	for i := 0; i < 10; i++ {
+		acc, err := lb.GetNextAccountExcludingByChannel(ctx, nil, "")
+		if err != nil || acc.ID != orchidsAcc.ID {
+			t.Fatalf("expected only the orchids account, got %+v err=%v", acc, err)
+		}
+	}
+
+	featureflag.Default.Reset(ctx, s, "loadbalancer.disabled_channels")
+	if acc, err := lb.GetNextAccountExcludingByChannel(ctx, nil, "warp"); err != nil || acc.ID != warpAcc.ID {
+		t.Fatalf("re-enabled channel: acc=%+v err=%v", acc, err)
+	}
+}
@@ -14,6 +14,9 @@ import (
 // ConnTracker tracks active connections per account for weighted least-connections selection.
 type ConnTracker interface {
 	Acquire(accountID int64)
+	// TryAcquire acquires only while the account has fewer than limit active
+	// connections, and reports whether it did.
+	TryAcquire(accountID int64, limit int64) bool
 	Release(accountID int64)
 	GetCount(accountID int64) int64
 	GetCounts(accountIDs []int64) map[int64]int64
@@ -35,6 +38,20 @@ func (t *MemoryConnTracker) Acquire(accountID int64) {
 	val.(*atomic.Int64).Add(1)
 }
 
+func (t *MemoryConnTracker) TryAcquire(accountID int64, limit int64) bool {
+	val, _ := t.conns.LoadOrStore(accountID, &atomic.Int64{})
+	counter := val.(*atomic.Int64)
+	for {
+		current := counter.Load()
+		if current >= limit {
+			return false
+		}
+		if counter.CompareAndSwap(current, current+1) {
+			return true
+		}
+	}
+}
+
 func (t *MemoryConnTracker) Release(accountID int64) {
 	if val, ok := t.conns.Load(accountID); ok {
 		counter := val.(*atomic.Int64)
@@ -69,9 +86,10 @@ func (t *MemoryConnTracker) GetCounts(accountIDs []int64) map[int64]int64 {
 
 // RedisConnTracker uses Redis INCR/DECR for distributed connection counting.
 type RedisConnTracker struct {
-	client        *redis.Client
-	prefix        string
-	releaseScript *redis.Script
+	client           *redis.Client
+	prefix           string
+	releaseScript    *redis.Script
+	tryAcquireScript *redis.Script
 }
 
 func NewRedisConnTracker(client *redis.Client, prefix string) *RedisConnTracker {
@@ -88,6 +106,16 @@ func NewRedisConnTracker(client *redis.Client, prefix string) *RedisConnTracker
 		end
 		return 0
 	`)
+	// Lua script to increment only while below the limit
+	t.tryAcquireScript = redis.NewScript(`
+		local key = KEYS[1]
+		local val = tonumber(redis.call("GET", key) or "0")
+		if val >= tonumber(ARGV[1]) then
+			return 0
+		end
+		redis.call("INCR", key)
+		return 1
+	`)
 
 	// Clear stale counters on startup
 	t.clearAll()
@@ -103,6 +131,18 @@ func (t *RedisConnTracker) Acquire(accountID int64) {
 	t.client.Incr(ctx, t.key(accountID))
 }
 
+func (t *RedisConnTracker) TryAcquire(accountID int64, limit int64) bool {
+	ctx := context.Background()
+	n, err := t.tryAcquireScript.Run(ctx, t.client, []string{t.key(accountID)}, limit).Int()
+	if err != nil {
+		// Fail open: a Redis hiccup should not block all traffic.
+		slog.Warn("Connection tracker TryAcquire failed", "account_id", accountID, "error", err)
+		t.Acquire(accountID)
+		return true
+	}
+	return n == 1
+}
+
 func (t *RedisConnTracker) Release(accountID int64) {
 	ctx := context.Background()
 	t.releaseScript.Run(ctx, t.client, []string{t.key(accountID)})
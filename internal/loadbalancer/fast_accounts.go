@@ -0,0 +1,50 @@
+package loadbalancer
+
+import (
+	"context"
+
+	"orchids-api/internal/store"
+)
+
+// SlowAccountChecker reports accounts that recently served slow requests.
+// The messages handler implements it from its anomaly windows.
+type SlowAccountChecker interface {
+	AccountSlow(acc *store.Account) bool
+}
+
+type preferFastKey struct{}
+
+// WithPreferFast marks account selection under ctx as latency sensitive:
+// accounts reported slow are only used when no other account can serve.
+func WithPreferFast(ctx context.Context) context.Context {
+	return context.WithValue(ctx, preferFastKey{}, true)
+}
+
+func preferFast(ctx context.Context) bool {
+	v, _ := ctx.Value(preferFastKey{}).(bool)
+	return v
+}
+
+// SetSlowAccountChecker sets how WithPreferFast requests recognise slow
+// accounts; nil turns the preference off.
+func (lb *LoadBalancer) SetSlowAccountChecker(c SlowAccountChecker) {
+	lb.slowAccounts = c
+}
+
+// fastAccounts returns the accounts not reported slow when ctx prefers fast
+// accounts and some but not all of them are slow, and nil otherwise.
+func (lb *LoadBalancer) fastAccounts(ctx context.Context, accounts []*store.Account) []*store.Account {
+	if lb.slowAccounts == nil || !preferFast(ctx) {
+		return nil
+	}
+	var fast []*store.Account
+	for _, acc := range accounts {
+		if !lb.slowAccounts.AccountSlow(acc) {
+			fast = append(fast, acc)
+		}
+	}
+	if len(fast) == len(accounts) {
+		return nil
+	}
+	return fast
+}
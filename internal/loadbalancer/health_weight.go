@@ -0,0 +1,81 @@
+package loadbalancer
+
+import (
+	"log/slog"
+	"sync"
+
+	"orchids-api/internal/store"
+)
+
+// HealthScorer rates an account from 0 (unusable) to 100 (healthy). The
+// messages handler implements it from recent errors, latency and quota.
+type HealthScorer interface {
+	AccountHealthScore(acc *store.Account) (int, bool)
+}
+
+// healthWeights scales the weight of unhealthy accounts. An account is
+// marked degraded when its score drops below low and only recovers once it
+// climbs back to high, so a score hovering around one threshold does not
+// flip its weight on every request.
+type healthWeights struct {
+	scorer    HealthScorer
+	low       int
+	high      int
+	minFactor float64
+
+	mu       sync.Mutex
+	degraded map[int64]bool
+}
+
+// SetHealthWeighting scales the weight of accounts scoring below low down to
+// score/100 of their weight (but no less than minFactor) until they score
+// high again. A nil scorer turns it off.
+func (lb *LoadBalancer) SetHealthWeighting(scorer HealthScorer, low, high int, minFactor float64) {
+	if scorer == nil {
+		lb.healthWeights = nil
+		return
+	}
+	if high < low {
+		high = low
+	}
+	if minFactor <= 0 || minFactor > 1 {
+		minFactor = 0.1
+	}
+	lb.healthWeights = &healthWeights{
+		scorer:    scorer,
+		low:       low,
+		high:      high,
+		minFactor: minFactor,
+		degraded:  make(map[int64]bool),
+	}
+}
+
+// factor returns the multiplier for acc's weight; it is below 1 only while
+// the account is degraded.
+func (hw *healthWeights) factor(acc *store.Account) float64 {
+	score, ok := hw.scorer.AccountHealthScore(acc)
+
+	hw.mu.Lock()
+	degraded := hw.degraded[acc.ID]
+	switch {
+	case !ok:
+		degraded = false
+	case !degraded && score < hw.low:
+		degraded = true
+		slog.Warn("Account health degraded, reducing weight", "account_id", acc.ID, "account", acc.Name, "score", score)
+	case degraded && score >= hw.high:
+		degraded = false
+		slog.Info("Account health recovered, restoring weight", "account_id", acc.ID, "account", acc.Name, "score", score)
+	}
+	if degraded {
+		hw.degraded[acc.ID] = true
+	} else {
+		delete(hw.degraded, acc.ID)
+	}
+	hw.mu.Unlock()
+
+	if !degraded {
+		return 1
+	}
+	return max(hw.minFactor, float64(score)/100)
+}
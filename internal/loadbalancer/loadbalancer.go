@@ -2,6 +2,7 @@ package loadbalancer
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"math/rand/v2"
@@ -10,6 +11,8 @@ import (
 	"time"
 
 	"orchids-api/internal/auth"
+	"orchids-api/internal/events"
+	"orchids-api/internal/featureflag"
 	"orchids-api/internal/orchids"
 	"orchids-api/internal/store"
 	"orchids-api/internal/warp"
@@ -19,14 +22,37 @@ import (
 
 const defaultCacheTTL = 5 * time.Second
 
+// ErrAccountsBusy means matching accounts exist but every one of them is at
+// its concurrency limit; callers may wait and retry.
+var ErrAccountsBusy = errors.New("all accounts are at their concurrency limit")
+
+// accountConcurrencyFlag overrides account_max_concurrency at runtime.
+var accountConcurrencyFlag = featureflag.NewInt("loadbalancer.account_max_concurrency", 0,
+	"Default per-account concurrency cap; 0 falls back to account_max_concurrency")
+
 type LoadBalancer struct {
 	Store          *store.Store
 	mu             sync.RWMutex
 	cachedAccounts []*store.Account
 	cacheExpires   time.Time
 	cacheTTL       time.Duration
-	connTracker    ConnTracker
-	sfGroup        singleflight.Group
+	// cachedChannels holds the channel records (nil = not loaded).
+	cachedChannels  map[string]*store.Channel
+	channelsExpires time.Time
+	connTracker     ConnTracker
+	sfGroup         singleflight.Group
+	// accountConcurrency caps concurrent upstream requests for accounts that
+	// don't set their own MaxConcurrency (0 = unlimited).
+	accountConcurrency int
+	// accountRPM paces requests to accounts that don't set their own MaxRPM
+	// (0 = unpaced).
+	accountRPM int
+	pacer      *accountPacer
+	// healthWeights scales down the weight of unhealthy accounts (nil = off).
+	healthWeights *healthWeights
+	// slowAccounts identifies accounts that latency-sensitive requests
+	// avoid (nil = off).
+	slowAccounts SlowAccountChecker
 }
 
 func NewWithCacheTTL(s *store.Store, cacheTTL time.Duration) *LoadBalancer {
@@ -37,6 +63,7 @@ func NewWithCacheTTL(s *store.Store, cacheTTL time.Duration) *LoadBalancer {
 		Store:       s,
 		cacheTTL:    cacheTTL,
 		connTracker: NewMemoryConnTracker(),
+		pacer:       newAccountPacer(),
 	}
 }
 
@@ -45,6 +72,25 @@ func (lb *LoadBalancer) SetConnTracker(ct ConnTracker) {
 	lb.connTracker = ct
 }
 
+// SetAccountConcurrencyLimit sets the default per-account concurrency cap.
+func (lb *LoadBalancer) SetAccountConcurrencyLimit(n int) {
+	lb.accountConcurrency = n
+}
+
+// connectionLimit returns the concurrency cap for acc (0 = unlimited).
+func (lb *LoadBalancer) connectionLimit(acc *store.Account) int64 {
+	if acc.MaxConcurrency > 0 {
+		return int64(acc.MaxConcurrency)
+	}
+	if n := accountConcurrencyFlag.Value(); n > 0 {
+		return int64(n)
+	}
+	if lb.accountConcurrency > 0 {
+		return int64(lb.accountConcurrency)
+	}
+	return 0
+}
+
 func (lb *LoadBalancer) GetModelChannel(ctx context.Context, modelID string) string {
 	if lb.Store == nil {
 		return ""
@@ -57,6 +103,34 @@ func (lb *LoadBalancer) GetModelChannel(ctx context.Context, modelID string) str
 }
 
 func (lb *LoadBalancer) GetNextAccountExcludingByChannel(ctx context.Context, excludeIDs []int64, channel string) (*store.Account, error) {
+	return lb.GetNextAccountForModel(ctx, excludeIDs, channel, "")
+}
+
+// GetNextAccountForModel is GetNextAccountExcludingByChannel for a request to
+// model, honouring the loadbalancer.tier_routing subscription policy. Only
+// accounts of the tenant ctx is scoped to (store.WithTenant) are candidates;
+// an unscoped ctx uses the shared pool.
+func (lb *LoadBalancer) GetNextAccountForModel(ctx context.Context, excludeIDs []int64, channel, model string) (*store.Account, error) {
+	account, err := lb.PreviewAccountForModel(ctx, excludeIDs, channel, model)
+	if err != nil {
+		return nil, err
+	}
+
+	slog.Info("Selected account", "name", account.Name, "email", account.Email, "subscription", account.Subscription, "session", auth.MaskSensitive(account.SessionID))
+
+	if err := lb.Store.IncrementRequestCount(ctx, account.ID); err != nil {
+		return nil, err
+	}
+
+	return account, nil
+}
+
+// PreviewAccountForModel picks the account GetNextAccountForModel would pick
+// without counting a request against it, for dry runs of the routing.
+func (lb *LoadBalancer) PreviewAccountForModel(ctx context.Context, excludeIDs []int64, channel, model string) (*store.Account, error) {
+	if err := lb.CheckChannel(ctx, channel); err != nil {
+		return nil, err
+	}
 	accounts, err := lb.getEnabledAccounts(ctx)
 	if err != nil {
 		return nil, err
@@ -67,19 +141,21 @@ func (lb *LoadBalancer) GetNextAccountExcludingByChannel(ctx context.Context, ex
 	for _, id := range excludeIDs {
 		excludeSet[id] = true
 	}
+	disabled := lb.disabledChannels(ctx)
+	tenantID, _ := store.TenantFromContext(ctx)
 
 	for _, acc := range accounts {
-		if excludeSet[acc.ID] {
+		if excludeSet[acc.ID] || acc.TenantID != tenantID {
 			continue
 		}
 		if !lb.isAccountAvailable(ctx, acc) {
 			continue
 		}
+		accType := AccountChannel(acc)
+		if disabled[accType] {
+			continue
+		}
 		if channel != "" {
-			accType := acc.AccountType
-			if strings.TrimSpace(accType) == "" {
-				accType = "orchids"
-			}
 			if !strings.EqualFold(accType, channel) && !strings.EqualFold(acc.AgentMode, channel) {
 				continue
 			}
@@ -92,14 +168,23 @@ func (lb *LoadBalancer) GetNextAccountExcludingByChannel(ctx context.Context, ex
 		return nil, fmt.Errorf("no enabled accounts available for channel: %s", channel)
 	}
 
-	account := lb.selectAccount(accounts)
-
-	slog.Info("Selected account", "name", account.Name, "email", account.Email, "session", auth.MaskSensitive(account.SessionID))
-
-	if err := lb.Store.IncrementRequestCount(ctx, account.ID); err != nil {
-		return nil, err
+	var account *store.Account
+	for _, group := range currentTierPolicy().tierGroups(accounts, model) {
+		// Latency-sensitive requests try the accounts that are not slow
+		// first.
+		if fast := lb.fastAccounts(ctx, group); len(fast) > 0 {
+			account = lb.selectPaced(fast)
+		}
+		if account == nil {
+			account = lb.selectPaced(group)
+		}
+		if account != nil {
+			break
+		}
+	}
+	if account == nil {
+		return nil, fmt.Errorf("%w (channel: %s)", ErrAccountsBusy, channel)
 	}
-
 	return account, nil
 }
 
@@ -108,6 +193,14 @@ func deepCopyAccounts(src []*store.Account) []*store.Account {
 	dst := make([]*store.Account, len(src))
 	for i, acc := range src {
 		copied := *acc
+		if acc.Headers != nil {
+			copied.Headers = make(map[string]string, len(acc.Headers))
+			for k, v := range acc.Headers {
+				copied.Headers[k] = v
+			}
+		}
+		copied.UserAgents = append([]string(nil), acc.UserAgents...)
+		copied.Tags = append([]string(nil), acc.Tags...)
 		dst[i] = &copied
 	}
 	return dst
@@ -126,7 +219,8 @@ func (lb *LoadBalancer) getEnabledAccounts(ctx context.Context) ([]*store.Accoun
 		}
 		lb.mu.RUnlock()
 
-		accounts, err := lb.Store.GetEnabledAccounts(ctx)
+		// The cache holds every tenant's accounts.
+		accounts, err := lb.Store.GetEnabledAccounts(store.WithoutTenant(ctx))
 		if err != nil {
 			return nil, err
 		}
@@ -145,11 +239,20 @@ func (lb *LoadBalancer) getEnabledAccounts(ctx context.Context) ([]*store.Accoun
 	return val.([]*store.Account), nil
 }
 
+// selectPaced picks among the accounts within their request pace first and
+// the rest only when those are at their concurrency limit.
+func (lb *LoadBalancer) selectPaced(accounts []*store.Account) *store.Account {
+	if account := lb.selectAccount(lb.readyAccounts(accounts)); account != nil {
+		return account
+	}
+	return lb.selectAccount(accounts)
+}
+
 func (lb *LoadBalancer) selectAccount(accounts []*store.Account) *store.Account {
 	if len(accounts) == 0 {
 		return nil
 	}
-	if len(accounts) == 1 {
+	if len(accounts) == 1 && lb.connectionLimit(accounts[0]) <= 0 {
 		return accounts[0]
 	}
 
@@ -170,7 +273,18 @@ func (lb *LoadBalancer) selectAccount(accounts []*store.Account) *store.Account
 		}
 
 		conns := connCounts[acc.ID]
+		if limit := lb.connectionLimit(acc); limit > 0 && conns >= limit {
+			continue
+		}
 		score := float64(conns) / float64(weight)
+		if lb.healthWeights != nil {
+			// A degraded account counts the request it would take, so idle
+			// healthy accounts are preferred and it only shares load in
+			// proportion to its reduced weight.
+			if f := lb.healthWeights.factor(acc); f < 1 {
+				score = float64(conns+1) / (float64(weight) * f)
+			}
+		}
 
 		if bestAccounts == nil || score < minScore {
 			bestAccounts = []*store.Account{acc}
@@ -184,13 +298,25 @@ func (lb *LoadBalancer) selectAccount(accounts []*store.Account) *store.Account
 		// Randomly select one from the best accounts to ensure load balancing
 		return bestAccounts[rand.IntN(len(bestAccounts))]
 	}
-	return accounts[0]
+	// Every account is at its concurrency limit.
+	return nil
 }
 
 func (lb *LoadBalancer) AcquireConnection(accountID int64) {
 	lb.connTracker.Acquire(accountID)
 }
 
+// TryAcquireConnection acquires a connection slot on acc unless it is already
+// at its concurrency limit. Release it with ReleaseConnection.
+func (lb *LoadBalancer) TryAcquireConnection(acc *store.Account) bool {
+	limit := lb.connectionLimit(acc)
+	if limit <= 0 {
+		lb.connTracker.Acquire(acc.ID)
+		return true
+	}
+	return lb.connTracker.TryAcquire(acc.ID, limit)
+}
+
 func (lb *LoadBalancer) ReleaseConnection(accountID int64) {
 	lb.connTracker.Release(accountID)
 }
@@ -204,7 +330,14 @@ const (
 	retry403Grok = 10 * time.Minute
 )
 
+// StatusReloginRequired marks an account whose Clerk cookie has expired. It has
+// no cooldown: the account stays out of rotation until it is signed in again.
+const StatusReloginRequired = "relogin"
+
 func (lb *LoadBalancer) isAccountAvailable(ctx context.Context, acc *store.Account) bool {
+	if acc.Draining {
+		return false
+	}
 	status := strings.TrimSpace(acc.StatusCode)
 	if status == "" {
 		return true
@@ -212,6 +345,8 @@ func (lb *LoadBalancer) isAccountAvailable(ctx context.Context, acc *store.Accou
 
 	now := time.Now()
 	switch status {
+	case StatusReloginRequired:
+		return false
 	case "401":
 		// 401 表示 token 过期或会话失效，短时间冷却后自动恢复尝试
 		if acc.LastAttempt.IsZero() {
@@ -284,6 +419,15 @@ func (lb *LoadBalancer) MarkAccountStatus(ctx context.Context, acc *store.Accoun
 	lb.persistAccountStatus(ctx, acc, "后台刷新失败: "+status)
 }
 
+// ClearReloginStatus puts an account back into rotation after its cookie was
+// refreshed successfully.
+func (lb *LoadBalancer) ClearReloginStatus(ctx context.Context, acc *store.Account) {
+	if acc == nil || lb.Store == nil || acc.StatusCode != StatusReloginRequired {
+		return
+	}
+	lb.clearAccountStatus(ctx, acc, "Clerk 会话已恢复")
+}
+
 func (lb *LoadBalancer) persistAccountStatus(ctx context.Context, acc *store.Account, reason string) {
 	if lb.Store == nil {
 		return
@@ -293,4 +437,23 @@ func (lb *LoadBalancer) persistAccountStatus(ctx context.Context, acc *store.Acc
 		return
 	}
 	slog.Info("账号状态已更新", "account_id", acc.ID, "status", acc.StatusCode, "reason", reason)
+	NotifyAccountStatus(acc, reason)
+}
+
+// NotifyAccountStatus publishes an admin event for an account whose status
+// was just persisted: disabled while it carries a status code, recovered once
+// the code is cleared.
+func NotifyAccountStatus(acc *store.Account, reason string) {
+	data := map[string]interface{}{
+		"account_id":   acc.ID,
+		"account_name": acc.Name,
+		"account_type": acc.AccountType,
+		"status":       acc.StatusCode,
+		"reason":       reason,
+	}
+	if acc.StatusCode == "" {
+		events.Publish(events.AccountRecovered, events.LevelInfo, fmt.Sprintf("account %d (%s) is back in rotation", acc.ID, acc.Name), data)
+		return
+	}
+	events.Publish(events.AccountDisabled, events.LevelWarning, fmt.Sprintf("account %d (%s) disabled: status %s", acc.ID, acc.Name, acc.StatusCode), data)
 }
@@ -1,8 +1,11 @@
 package loadbalancer
 
 import (
+	"context"
 	"testing"
 
+	"github.com/alicebob/miniredis/v2"
+
 	"orchids-api/internal/store"
 )
 
@@ -80,3 +83,157 @@ func TestSelectAccount_ActiveConnections(t *testing.T) {
 		}
 	}
 }
+
+func TestSelectAccount_SkipsSaturatedAccounts(t *testing.T) {
+	lb := &LoadBalancer{connTracker: NewMemoryConnTracker(), accountConcurrency: 2}
+	capped := &store.Account{ID: 1, Name: "Capped", Weight: 10, MaxConcurrency: 1}
+	other := &store.Account{ID: 2, Name: "Other", Weight: 1}
+	accounts := []*store.Account{capped, other}
+
+	if !lb.TryAcquireConnection(capped) {
+		t.Fatal("first slot on capped account should be free")
+	}
+	if lb.TryAcquireConnection(capped) {
+		t.Fatal("capped account should reject a second connection")
+	}
+	for i := 0; i < 20; i++ {
+		if acc := lb.selectAccount(accounts); acc == nil || acc.ID != other.ID {
+			t.Fatalf("expected the unsaturated account, got %+v", acc)
+		}
+	}
+
+	// The default cap applies to accounts without their own MaxConcurrency.
+	lb.TryAcquireConnection(other)
+	lb.TryAcquireConnection(other)
+	if acc := lb.selectAccount(accounts); acc != nil {
+		t.Fatalf("all accounts saturated, got %+v", acc)
+	}
+	if acc := lb.selectAccount([]*store.Account{other}); acc != nil {
+		t.Fatalf("single saturated account should not be selected, got %+v", acc)
+	}
+
+	lb.ReleaseConnection(capped.ID)
+	if acc := lb.selectAccount(accounts); acc == nil || acc.ID != capped.ID {
+		t.Fatalf("released account should be selectable again, got %+v", acc)
+	}
+}
+
+func TestIsAccountAvailable_Draining(t *testing.T) {
+	lb := &LoadBalancer{connTracker: NewMemoryConnTracker()}
+	acc := &store.Account{ID: 1, Name: "Draining", Enabled: true, Draining: true}
+	if lb.isAccountAvailable(context.Background(), acc) {
+		t.Fatal("draining account must not be available")
+	}
+	acc.Draining = false
+	if !lb.isAccountAvailable(context.Background(), acc) {
+		t.Fatal("account should be available once undrained")
+	}
+}
+
+type fakeHealthScorer map[int64]int
+
+func (f fakeHealthScorer) AccountHealthScore(acc *store.Account) (int, bool) {
+	score, ok := f[acc.ID]
+	return score, ok
+}
+
+func TestSelectAccount_HealthWeighting(t *testing.T) {
+	lb := &LoadBalancer{connTracker: NewMemoryConnTracker()}
+	scores := fakeHealthScorer{1: 40, 2: 100}
+	lb.SetHealthWeighting(scores, 50, 80, 0.1)
+	sick := &store.Account{ID: 1, Name: "Sick", Weight: 1}
+	healthy := &store.Account{ID: 2, Name: "Healthy", Weight: 1}
+	accounts := []*store.Account{sick, healthy}
+
+	for i := 0; i < 20; i++ {
+		if acc := lb.selectAccount(accounts); acc.ID != healthy.ID {
+			t.Fatalf("expected the healthy account while idle, got %s", acc.Name)
+		}
+	}
+	// At 0.4 of its weight the sick account takes over once the healthy
+	// one carries three requests.
+	for i := 0; i < 3; i++ {
+		lb.AcquireConnection(healthy.ID)
+	}
+	if acc := lb.selectAccount(accounts); acc.ID != sick.ID {
+		t.Fatalf("expected the degraded account to share load, got %s", acc.Name)
+	}
+
+	// Hysteresis: recovering above low is not enough, it must reach high.
+	scores[1] = 60
+	if f := lb.healthWeights.factor(sick); f != 0.6 {
+		t.Fatalf("factor=%v want 0.6 while still degraded", f)
+	}
+	scores[1] = 85
+	if f := lb.healthWeights.factor(sick); f != 1 {
+		t.Fatalf("factor=%v want 1 after recovery", f)
+	}
+	scores[1] = 60
+	if f := lb.healthWeights.factor(sick); f != 1 {
+		t.Fatalf("factor=%v want 1 above the low threshold", f)
+	}
+}
+
+type fakeSlowAccounts map[int64]bool
+
+func (f fakeSlowAccounts) AccountSlow(acc *store.Account) bool { return f[acc.ID] }
+
+func TestFastAccounts(t *testing.T) {
+	lb := &LoadBalancer{connTracker: NewMemoryConnTracker()}
+	slow := &store.Account{ID: 1}
+	fast := &store.Account{ID: 2}
+	accounts := []*store.Account{slow, fast}
+	ctx := WithPreferFast(context.Background())
+
+	if got := lb.fastAccounts(ctx, accounts); got != nil {
+		t.Fatalf("without a checker got %v", got)
+	}
+	lb.SetSlowAccountChecker(fakeSlowAccounts{1: true})
+	if got := lb.fastAccounts(context.Background(), accounts); got != nil {
+		t.Fatalf("requests without the hint should not filter, got %v", got)
+	}
+	if got := lb.fastAccounts(ctx, accounts); len(got) != 1 || got[0].ID != fast.ID {
+		t.Fatalf("fastAccounts=%v want only the fast account", got)
+	}
+	lb.SetSlowAccountChecker(fakeSlowAccounts{1: true, 2: true})
+	if got := lb.fastAccounts(ctx, accounts); len(got) != 0 {
+		t.Fatalf("all slow: fastAccounts=%v want none", got)
+	}
+}
+
+func TestTenantAccountPools(t *testing.T) {
+	mr := miniredis.RunT(t)
+	s, err := store.New(store.Options{RedisAddr: mr.Addr(), RedisPrefix: "test:"})
+	if err != nil {
+		t.Fatalf("store.New: %v", err)
+	}
+	ctx := context.Background()
+	shared := &store.Account{Name: "shared", Enabled: true, Weight: 1}
+	if err := s.CreateAccount(ctx, shared); err != nil {
+		t.Fatalf("CreateAccount: %v", err)
+	}
+	team := &store.Account{Name: "team", Enabled: true, Weight: 1}
+	if err := s.CreateAccount(store.WithTenant(ctx, 7), team); err != nil {
+		t.Fatalf("CreateAccount: %v", err)
+	}
+	lb := NewWithCacheTTL(s, 0)
+
+	for _, tc := range []struct {
+		ctx  context.Context
+		want int64
+	}{
+		{ctx, shared.ID},
+		{store.WithTenant(ctx, 0), shared.ID},
+		{store.WithTenant(ctx, 7), team.ID},
+	} {
+		for i := 0; i < 5; i++ {
+			acc, err := lb.GetNextAccountForModel(tc.ctx, nil, "", "")
+			if err != nil || acc.ID != tc.want {
+				t.Fatalf("got %+v err=%v, want account %d", acc, err, tc.want)
+			}
+		}
+	}
+	if _, err := lb.GetNextAccountForModel(store.WithTenant(ctx, 8), nil, "", ""); err == nil {
+		t.Fatal("a tenant without accounts must not borrow from other pools")
+	}
+}
@@ -0,0 +1,130 @@
+package loadbalancer
+
+import (
+	"context"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"orchids-api/internal/store"
+)
+
+// pacingJitter is the largest random delay added to a paced request, as a
+// fraction of the account's request interval, so queued requests do not all
+// fire on the same tick.
+const pacingJitter = 0.25
+
+// pacingBucket is a token bucket holding at most one request: an account
+// limited to N requests per minute sends one every 60/N seconds. tokens goes
+// negative while requests wait for future releases.
+type pacingBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// accountPacer smooths the request rate of each account in this process.
+type accountPacer struct {
+	mu      sync.Mutex
+	buckets map[int64]*pacingBucket
+}
+
+func newAccountPacer() *accountPacer {
+	return &accountPacer{buckets: make(map[int64]*pacingBucket)}
+}
+
+// refill brings acc's bucket up to now and returns it.
+func (p *accountPacer) refill(accountID int64, rate float64, now time.Time) *pacingBucket {
+	b, ok := p.buckets[accountID]
+	if !ok {
+		b = &pacingBucket{tokens: 1, last: now}
+		p.buckets[accountID] = b
+	}
+	b.tokens = min(1, b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+	return b
+}
+
+// ready reports whether a request to the account would be sent without
+// waiting.
+func (p *accountPacer) ready(accountID int64, rpm int) bool {
+	if rpm <= 0 {
+		return true
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.refill(accountID, float64(rpm)/60, time.Now()).tokens >= 1
+}
+
+// reserve takes a release from the account's bucket and returns how long the
+// request must wait for it, jitter included.
+func (p *accountPacer) reserve(accountID int64, rpm int) time.Duration {
+	if rpm <= 0 {
+		return 0
+	}
+	rate := float64(rpm) / 60
+	p.mu.Lock()
+	b := p.refill(accountID, rate, time.Now())
+	b.tokens--
+	deficit := -b.tokens
+	p.mu.Unlock()
+	if deficit <= 0 {
+		return 0
+	}
+	interval := 1 / rate
+	return time.Duration((deficit + rand.Float64()*pacingJitter) * interval * float64(time.Second))
+}
+
+// cancel returns a release taken by reserve for a request that gave up.
+func (p *accountPacer) cancel(accountID int64) {
+	p.mu.Lock()
+	if b, ok := p.buckets[accountID]; ok {
+		b.tokens = min(1, b.tokens+1)
+	}
+	p.mu.Unlock()
+}
+
+// SetAccountRPMLimit sets the default per-account requests-per-minute pace.
+func (lb *LoadBalancer) SetAccountRPMLimit(n int) {
+	lb.accountRPM = n
+}
+
+// rpmLimit returns the requests-per-minute pace for acc (0 = unpaced).
+func (lb *LoadBalancer) rpmLimit(acc *store.Account) int {
+	if acc.MaxRPM > 0 {
+		return acc.MaxRPM
+	}
+	return max(lb.accountRPM, 0)
+}
+
+// readyAccounts returns the accounts that can take a request without
+// waiting for their pace, or all of them when none can.
+func (lb *LoadBalancer) readyAccounts(accounts []*store.Account) []*store.Account {
+	var ready []*store.Account
+	for _, acc := range accounts {
+		if lb.pacer.ready(acc.ID, lb.rpmLimit(acc)) {
+			ready = append(ready, acc)
+		}
+	}
+	if len(ready) == 0 {
+		return accounts
+	}
+	return ready
+}
+
+// Pace blocks until acc may send its next upstream request under its
+// requests-per-minute limit, or ctx is done.
+func (lb *LoadBalancer) Pace(ctx context.Context, acc *store.Account) error {
+	wait := lb.pacer.reserve(acc.ID, lb.rpmLimit(acc))
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		lb.pacer.cancel(acc.ID)
+		return ctx.Err()
+	}
+}
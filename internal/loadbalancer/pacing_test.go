@@ -0,0 +1,36 @@
+package loadbalancer
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"orchids-api/internal/store"
+)
+
+func TestPaceSpacesRequests(t *testing.T) {
+	lb := &LoadBalancer{connTracker: NewMemoryConnTracker(), pacer: newAccountPacer()}
+	acc := &store.Account{ID: 1, MaxRPM: 600} // one request every 100ms
+	ctx := context.Background()
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := lb.Pace(ctx, acc); err != nil {
+			t.Fatalf("Pace: %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond || elapsed > 400*time.Millisecond {
+		t.Fatalf("three paced requests took %v, want ~200ms", elapsed)
+	}
+
+	if got := lb.readyAccounts([]*store.Account{acc, {ID: 2}}); len(got) != 1 || got[0].ID != 2 {
+		t.Fatalf("expected only the unpaced account to be ready, got %+v", got)
+	}
+
+	short, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if err := lb.Pace(short, acc); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the wait to be cancelled, got %v", err)
+	}
+}
@@ -0,0 +1,85 @@
+package loadbalancer
+
+import (
+	"log/slog"
+	"slices"
+	"strings"
+
+	"orchids-api/internal/featureflag"
+	"orchids-api/internal/store"
+)
+
+// TierRule ties models to account subscription tiers. Models match by
+// case-insensitive substring of the model ID.
+type TierRule struct {
+	Models []string `json:"models"`
+	Tiers  []string `json:"tiers"`
+}
+
+// TierPolicy steers requests by account subscription. Accounts whose tier a
+// Prefer rule lists are tried first for its models; accounts whose tier a
+// Reserve rule lists are kept for its models and only serve others when
+// nothing else is available.
+type TierPolicy struct {
+	Prefer  []TierRule `json:"prefer"`
+	Reserve []TierRule `json:"reserve"`
+}
+
+var tierRoutingFlag = featureflag.NewJSON("loadbalancer.tier_routing", TierPolicy{
+	Prefer:  []TierRule{{Models: []string{"opus", "thinking"}, Tiers: []string{"pro"}}},
+	Reserve: []TierRule{{Models: []string{"haiku"}, Tiers: []string{"free"}}},
+}, "Subscription tier routing: prefer tiers for matching models and reserve tiers for them")
+
+func (r TierRule) matchesModel(model string) bool {
+	model = strings.ToLower(model)
+	for _, m := range r.Models {
+		if m = strings.ToLower(strings.TrimSpace(m)); m != "" && strings.Contains(model, m) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r TierRule) hasTier(tier string) bool {
+	return tier != "" && slices.ContainsFunc(r.Tiers, func(t string) bool {
+		return strings.EqualFold(strings.TrimSpace(t), tier)
+	})
+}
+
+// tierGroups splits candidates for model into the order they should be
+// tried: preferred tiers, then the rest, then accounts reserved for other
+// models. Empty groups are dropped.
+func (p TierPolicy) tierGroups(accounts []*store.Account, model string) [][]*store.Account {
+	if model == "" || (len(p.Prefer) == 0 && len(p.Reserve) == 0) {
+		return [][]*store.Account{accounts}
+	}
+	var preferred, normal, reserved []*store.Account
+	for _, acc := range accounts {
+		tier := strings.ToLower(strings.TrimSpace(acc.Subscription))
+		switch {
+		case slices.ContainsFunc(p.Prefer, func(r TierRule) bool { return r.hasTier(tier) && r.matchesModel(model) }):
+			preferred = append(preferred, acc)
+		case slices.ContainsFunc(p.Reserve, func(r TierRule) bool { return r.hasTier(tier) && !r.matchesModel(model) }):
+			reserved = append(reserved, acc)
+		default:
+			normal = append(normal, acc)
+		}
+	}
+	groups := make([][]*store.Account, 0, 3)
+	for _, g := range [][]*store.Account{preferred, normal, reserved} {
+		if len(g) > 0 {
+			groups = append(groups, g)
+		}
+	}
+	return groups
+}
+
+// currentTierPolicy returns the loadbalancer.tier_routing policy.
+func currentTierPolicy() TierPolicy {
+	var policy TierPolicy
+	if err := tierRoutingFlag.Decode(&policy); err != nil {
+		slog.Warn("Ignoring invalid loadbalancer.tier_routing", "error", err)
+		return TierPolicy{}
+	}
+	return policy
+}
@@ -0,0 +1,54 @@
+package loadbalancer
+
+import (
+	"testing"
+
+	"orchids-api/internal/store"
+)
+
+func TestTierGroups(t *testing.T) {
+	pro := &store.Account{ID: 1, Subscription: "Pro"}
+	free := &store.Account{ID: 2, Subscription: "free"}
+	plain := &store.Account{ID: 3}
+	accounts := []*store.Account{pro, free, plain}
+	policy := TierPolicy{
+		Prefer:  []TierRule{{Models: []string{"opus", "thinking"}, Tiers: []string{"pro"}}},
+		Reserve: []TierRule{{Models: []string{"haiku"}, Tiers: []string{"free"}}},
+	}
+
+	ids := func(groups [][]*store.Account) [][]int64 {
+		out := make([][]int64, len(groups))
+		for i, g := range groups {
+			for _, acc := range g {
+				out[i] = append(out[i], acc.ID)
+			}
+		}
+		return out
+	}
+	cases := []struct {
+		model string
+		want  [][]int64
+	}{
+		{"claude-opus-4-6", [][]int64{{1}, {3}, {2}}},
+		{"claude-sonnet-4-5-thinking", [][]int64{{1}, {3}, {2}}},
+		{"claude-sonnet-4-5", [][]int64{{1, 3}, {2}}},
+		{"claude-haiku-4-5", [][]int64{{1, 2, 3}}},
+		{"", [][]int64{{1, 2, 3}}},
+	}
+	for _, tc := range cases {
+		got := ids(policy.tierGroups(accounts, tc.model))
+		if len(got) != len(tc.want) {
+			t.Fatalf("%q: groups=%v want %v", tc.model, got, tc.want)
+		}
+		for i := range got {
+			if len(got[i]) != len(tc.want[i]) {
+				t.Fatalf("%q: groups=%v want %v", tc.model, got, tc.want)
+			}
+			for j := range got[i] {
+				if got[i][j] != tc.want[i][j] {
+					t.Fatalf("%q: groups=%v want %v", tc.model, got, tc.want)
+				}
+			}
+		}
+	}
+}
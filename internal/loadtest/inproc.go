@@ -0,0 +1,81 @@
+package loadtest
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"orchids-api/internal/config"
+	"orchids-api/internal/debug"
+	"orchids-api/internal/handler"
+	"orchids-api/internal/middleware"
+	"orchids-api/internal/upstream"
+)
+
+// MockUpstream answers every upstream call with a short text reply, so the
+// in-process server exercises the real handler, prompt builder and stream
+// conversion without network or accounts.
+type MockUpstream struct {
+	// Latency is waited before the first event.
+	Latency time.Duration
+	// Chunks is the number of text deltas per reply (default 8).
+	Chunks int
+}
+
+func (m *MockUpstream) SendRequest(ctx context.Context, prompt string, chatHistory []interface{}, model string, onMessage func(upstream.SSEMessage), logger *debug.Logger) error {
+	return m.reply(ctx, onMessage)
+}
+
+func (m *MockUpstream) SendRequestWithPayload(ctx context.Context, req upstream.UpstreamRequest, onMessage func(upstream.SSEMessage), logger *debug.Logger) error {
+	return m.reply(ctx, onMessage)
+}
+
+func (m *MockUpstream) reply(ctx context.Context, onMessage func(upstream.SSEMessage)) error {
+	if m.Latency > 0 {
+		timer := time.NewTimer(m.Latency)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	chunks := m.Chunks
+	if chunks <= 0 {
+		chunks = 8
+	}
+	onMessage(upstream.SSEMessage{Type: "model", Event: map[string]any{"type": "text-start"}})
+	for i := 0; i < chunks; i++ {
+		onMessage(upstream.SSEMessage{Type: "model", Event: map[string]any{"type": "text-delta", "delta": "ok "}})
+	}
+	onMessage(upstream.SSEMessage{Type: "model", Event: map[string]any{"type": "text-end"}})
+	onMessage(upstream.SSEMessage{Type: "model", Event: map[string]any{"type": "finish", "finishReason": "stop"}})
+	return nil
+}
+
+// StartInProcServer starts the real Messages handler in this process, backed
+// by mock. cfg may be nil for defaults; its admin/auth settings are ignored
+// since only the public message routes are mounted.
+func StartInProcServer(cfg *config.Config, mock *MockUpstream) *SelfServer {
+	if cfg == nil {
+		cfg = &config.Config{AdminPass: "loadtest"}
+		config.ApplyDefaults(cfg)
+	}
+	if mock == nil {
+		mock = &MockUpstream{}
+	}
+	h := handler.NewWithLoadBalancer(cfg, nil)
+	h.SetUpstreamClient(mock)
+
+	mux := http.NewServeMux()
+	for _, path := range []string{
+		"/orchids/v1/messages", "/warp/v1/messages", "/kiro/v1/messages", "/v1/messages",
+		"/orchids/v1/chat/completions", "/warp/v1/chat/completions",
+	} {
+		mux.HandleFunc(path, h.HandleMessages)
+	}
+
+	s := httptest.NewServer(middleware.TraceMiddleware(mux))
+	return &SelfServer{Server: s, BaseURL: s.URL}
+}
@@ -1,6 +1,7 @@
 package loadtest
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"errors"
@@ -11,6 +12,7 @@ import (
 	"math/rand"
 	"net/http"
 	"os"
+	"runtime"
 	"sort"
 	"strings"
 	"sync"
@@ -23,6 +25,8 @@ type Mode string
 const (
 	ModeExternal Mode = "external"
 	ModeSelf     Mode = "self"
+	// ModeInProc runs the real handler in this process against MockUpstream.
+	ModeInProc Mode = "inproc"
 )
 
 type Channel string
@@ -41,8 +45,19 @@ const (
 	ScenarioLargeFile      Scenario = "large_file"
 	ScenarioComplexHistory Scenario = "complex_history"
 	ScenarioWithTools      Scenario = "with_tools"
+	// ScenarioReplay covers requests taken from Config.Replay.
+	ScenarioReplay Scenario = "replay"
 )
 
+// RecordedRequest is one entry of a recorded request mix. Path defaults to
+// the channel's messages endpoint; Weight (default 1) sets how often it is
+// replayed relative to the other entries.
+type RecordedRequest struct {
+	Path   string          `json:"path,omitempty"`
+	Body   json.RawMessage `json:"body"`
+	Weight float64         `json:"weight,omitempty"`
+}
+
 type Config struct {
 	BaseURL        string
 	Mode           Mode
@@ -60,6 +75,10 @@ type Config struct {
 
 	// Scenario weights
 	Weights map[Scenario]float64
+
+	// Replay, when set, replaces the synthetic scenarios with recorded
+	// requests.
+	Replay []RecordedRequest
 }
 
 type Result struct {
@@ -77,6 +96,11 @@ type Result struct {
 
 	LatenciesMs []int64
 
+	// AllocBytes and Mallocs are heap allocations of this whole process
+	// during the run; with ModeInProc they include the server.
+	AllocBytes uint64
+	Mallocs    uint64
+
 	ByScenario map[Scenario]*ScenarioResult
 }
 
@@ -162,10 +186,18 @@ func Run(ctx context.Context, cfg Config) (*Result, error) {
 		rng: rand.New(rand.NewSource(cfg.Seed)),
 		res: &Result{StartedAt: time.Now(), ByScenario: make(map[Scenario]*ScenarioResult)},
 	}
-	for sc := range cfg.Weights {
-		r.res.ByScenario[sc] = &ScenarioResult{}
+	if len(cfg.Replay) > 0 {
+		r.res.ByScenario[ScenarioReplay] = &ScenarioResult{}
+	} else {
+		for sc := range cfg.Weights {
+			r.res.ByScenario[sc] = &ScenarioResult{}
+		}
 	}
 
+	runtime.GC()
+	var before runtime.MemStats
+	runtime.ReadMemStats(&before)
+
 	endCtx, cancel := context.WithTimeout(ctx, cfg.Duration)
 	defer cancel()
 
@@ -210,15 +242,33 @@ func Run(ctx context.Context, cfg Config) (*Result, error) {
 
 	wg.Wait()
 	r.res.EndedAt = time.Now()
+	var after runtime.MemStats
+	runtime.ReadMemStats(&after)
+	r.res.AllocBytes = after.TotalAlloc - before.TotalAlloc
+	r.res.Mallocs = after.Mallocs - before.Mallocs
 	return r.res, nil
 }
 
 func (r *runner) doOne(ctx context.Context) {
-	sc := r.pickScenario()
-	stream := r.pickStream()
-	path := r.pickPath()
-
-	body := r.buildRequest(sc, stream)
+	var (
+		sc     Scenario
+		stream bool
+		path   string
+		body   []byte
+	)
+	if len(r.cfg.Replay) > 0 {
+		sc = ScenarioReplay
+		rec := r.pickRecorded()
+		body, stream = r.replayBody(rec)
+		if path = rec.Path; path == "" {
+			path = r.pickPath()
+		}
+	} else {
+		sc = r.pickScenario()
+		stream = r.pickStream()
+		path = r.pickPath()
+		body = r.buildRequest(sc, stream)
+	}
 	start := time.Now()
 
 	atomic.AddInt64(&r.res.Total, 1)
@@ -327,6 +377,94 @@ func (r *runner) pickScenario() Scenario {
 	return ScenarioSimple
 }
 
+func (r *runner) pickRecorded() RecordedRequest {
+	total := 0.0
+	for _, rec := range r.cfg.Replay {
+		total += recordWeight(rec)
+	}
+	x := r.randFloat() * total
+	acc := 0.0
+	for _, rec := range r.cfg.Replay {
+		acc += recordWeight(rec)
+		if x <= acc {
+			return rec
+		}
+	}
+	return r.cfg.Replay[len(r.cfg.Replay)-1]
+}
+
+func recordWeight(rec RecordedRequest) float64 {
+	if rec.Weight > 0 {
+		return rec.Weight
+	}
+	return 1
+}
+
+// replayBody returns rec's body with a nonce added to the last user message,
+// so the server's duplicate suppression does not collapse replays, and
+// whether the request streams.
+func (r *runner) replayBody(rec RecordedRequest) ([]byte, bool) {
+	var payload map[string]any
+	if err := json.Unmarshal(rec.Body, &payload); err != nil || payload == nil {
+		return rec.Body, false
+	}
+	stream, _ := payload["stream"].(bool)
+	if raw, ok := payload["messages"].([]any); ok {
+		messages := make([]map[string]any, 0, len(raw))
+		for _, m := range raw {
+			if mm, ok := m.(map[string]any); ok {
+				messages = append(messages, mm)
+			}
+		}
+		payload["messages"] = addNonceToLastUser(messages, r.nextNonce())
+	}
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return rec.Body, stream
+	}
+	return b, stream
+}
+
+// LoadRecording reads a request mix from a JSONL file. Each line is either a
+// RecordedRequest or a bare Messages request body; blank lines are skipped.
+func LoadRecording(path string) ([]RecordedRequest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var out []RecordedRequest
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for line := 1; sc.Scan(); line++ {
+		text := bytes.TrimSpace(sc.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+		var probe map[string]json.RawMessage
+		if err := json.Unmarshal(text, &probe); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		var rec RecordedRequest
+		if _, ok := probe["body"]; ok {
+			if err := json.Unmarshal(text, &rec); err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+			}
+		} else {
+			rec.Body = append(json.RawMessage(nil), text...)
+		}
+		out = append(out, rec)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("%s: no requests", path)
+	}
+	return out, nil
+}
+
 func (r *runner) randFloat() float64 {
 	r.rngMu.Lock()
 	defer r.rngMu.Unlock()
@@ -407,6 +545,10 @@ func addNonceToLastUser(messages []map[string]any, nonce string) []map[string]an
 			}
 			return messages
 		}
+		if blocks, ok := messages[i]["content"].([]any); ok {
+			messages[i]["content"] = append(blocks, map[string]any{"type": "text", "text": nonce})
+			return messages
+		}
 		messages[i]["content"] = fmt.Sprintf("%v\n\n%s", messages[i]["content"], nonce)
 		return messages
 	}
@@ -471,17 +613,19 @@ func DetectBaseURL() string {
 }
 
 type Summary struct {
-	Duration      time.Duration
-	Total         int64
-	RPS           float64
-	AvgMs         float64
-	P50Ms         int64
-	P90Ms         int64
-	P99Ms         int64
-	Success       int64
-	Empty         int64
-	Errors        int64
-	ByScenarioOut map[Scenario]ScenarioResult
+	Duration         time.Duration               `json:"duration"`
+	Total            int64                       `json:"total"`
+	RPS              float64                     `json:"rps"`
+	AvgMs            float64                     `json:"avg_ms"`
+	P50Ms            int64                       `json:"p50_ms"`
+	P90Ms            int64                       `json:"p90_ms"`
+	P99Ms            int64                       `json:"p99_ms"`
+	Success          int64                       `json:"success"`
+	Empty            int64                       `json:"empty"`
+	Errors           int64                       `json:"errors"`
+	AllocBytesPerReq float64                     `json:"alloc_bytes_per_req"`
+	AllocsPerReq     float64                     `json:"allocs_per_req"`
+	ByScenarioOut    map[Scenario]ScenarioResult `json:"by_scenario"`
 }
 
 func Summarize(r *Result) Summary {
@@ -517,6 +661,12 @@ func Summarize(r *Result) Summary {
 		rps = float64(r.Total) / dur.Seconds()
 	}
 
+	var bytesPerReq, allocsPerReq float64
+	if r.Total > 0 {
+		bytesPerReq = float64(r.AllocBytes) / float64(r.Total)
+		allocsPerReq = float64(r.Mallocs) / float64(r.Total)
+	}
+
 	by := make(map[Scenario]ScenarioResult, len(r.ByScenario))
 	for sc, sr := range r.ByScenario {
 		by[sc] = *sr
@@ -534,5 +684,29 @@ func Summarize(r *Result) Summary {
 		Empty:         r.Empty,
 		Errors:        r.Errors,
 		ByScenarioOut: by,
+
+		AllocBytesPerReq: bytesPerReq,
+		AllocsPerReq:     allocsPerReq,
+	}
+}
+
+// Compare reports the metrics of cur that are worse than base by more than
+// tolerance (0.1 = 10%): P99 and average latency, allocations per request and
+// throughput. Metrics the baseline did not record are skipped.
+func Compare(base, cur Summary, tolerance float64) []string {
+	var out []string
+	worse := func(name string, b, c float64, higherIsWorse bool) {
+		if b <= 0 {
+			return
+		}
+		if higherIsWorse && c > b*(1+tolerance) || !higherIsWorse && c < b*(1-tolerance) {
+			out = append(out, fmt.Sprintf("%s: %.1f -> %.1f (%+.1f%%)", name, b, c, (c-b)/b*100))
+		}
 	}
+	worse("p99_ms", float64(base.P99Ms), float64(cur.P99Ms), true)
+	worse("avg_ms", base.AvgMs, cur.AvgMs, true)
+	worse("alloc_bytes_per_req", base.AllocBytesPerReq, cur.AllocBytesPerReq, true)
+	worse("allocs_per_req", base.AllocsPerReq, cur.AllocsPerReq, true)
+	worse("rps", base.RPS, cur.RPS, false)
+	return out
 }
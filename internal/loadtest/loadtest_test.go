@@ -0,0 +1,69 @@
+package loadtest
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunInProcReplay(t *testing.T) {
+	self := StartInProcServer(nil, nil)
+	defer self.Close()
+
+	cfg := DefaultConfig()
+	cfg.BaseURL = self.BaseURL
+	cfg.Duration = 500 * time.Millisecond
+	cfg.Concurrency = 2
+	cfg.TargetRPM = 1200
+	cfg.Replay = []RecordedRequest{
+		{Body: []byte(`{"model":"claude-sonnet-4-6","messages":[{"role":"user","content":"hi"}],"stream":true}`)},
+		{Path: "/v1/messages", Body: []byte(`{"model":"claude-sonnet-4-6","messages":[{"role":"user","content":[{"type":"text","text":"hi"}]}]}`)},
+	}
+
+	res, err := Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	s := Summarize(res)
+	if s.Success == 0 || s.ByScenarioOut[ScenarioReplay].Total != s.Total {
+		t.Fatalf("summary = %+v", s)
+	}
+	if s.AllocsPerReq <= 0 {
+		t.Fatalf("allocations not recorded: %+v", s)
+	}
+}
+
+func TestLoadRecording(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mix.jsonl")
+	data := `{"model":"m","messages":[{"role":"user","content":"hi"}]}
+
+{"path":"/warp/v1/messages","weight":2,"body":{"model":"m","messages":[]}}
+`
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	recs, err := LoadRecording(path)
+	if err != nil {
+		t.Fatalf("LoadRecording: %v", err)
+	}
+	if len(recs) != 2 || recs[0].Path != "" || !strings.Contains(string(recs[0].Body), `"hi"`) {
+		t.Fatalf("recs[0] = %+v", recs)
+	}
+	if recs[1].Path != "/warp/v1/messages" || recs[1].Weight != 2 || !strings.Contains(string(recs[1].Body), `"messages"`) {
+		t.Fatalf("recs[1] = %+v", recs[1])
+	}
+}
+
+func TestCompare(t *testing.T) {
+	base := Summary{P99Ms: 100, AvgMs: 20, RPS: 50, AllocsPerReq: 400}
+	if got := Compare(base, Summary{P99Ms: 105, AvgMs: 20, RPS: 48, AllocsPerReq: 420}, 0.1); len(got) != 0 {
+		t.Fatalf("within tolerance, got %v", got)
+	}
+	got := Compare(base, Summary{P99Ms: 150, AvgMs: 20, RPS: 30, AllocsPerReq: 400, AllocBytesPerReq: 1e6}, 0.1)
+	if len(got) != 2 || !strings.HasPrefix(got[0], "p99_ms") || !strings.HasPrefix(got[1], "rps") {
+		t.Fatalf("regressions = %v", got)
+	}
+}
@@ -100,6 +100,72 @@ var (
 		[]string{"type"},
 	)
 
+	// ClientAbortsTotal counts streams aborted because the client stopped
+	// reading: a failed write or the max_client_stall budget running out.
+	ClientAbortsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "client_aborts_total",
+			Help:      "Total streams aborted because of a slow or stalled client.",
+		},
+		[]string{"reason"}, // "write_error" or "stall_limit"
+	)
+
+	// PanicsTotal counts panics recovered while serving HTTP requests.
+	PanicsTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "panics_total",
+			Help:      "Total panics recovered from HTTP handlers.",
+		},
+	)
+
+	// TokenFetchDuration measures how long minting a Clerk session token
+	// takes, on demand (the request waits) or in the background.
+	TokenFetchDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "token_fetch_duration_seconds",
+			Help:      "Clerk session token fetch duration in seconds.",
+			Buckets:   []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+		},
+		[]string{"trigger"}, // "on_demand" or "refresh_ahead"
+	)
+
+	// TokenFetchesTotal counts Clerk session token fetches by outcome.
+	TokenFetchesTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "token_fetches_total",
+			Help:      "Total Clerk session token fetches.",
+		},
+		[]string{"trigger", "result"}, // result: "ok" or "error"
+	)
+
+	// UpstreamRequestBytes measures the payload bytes a request sent upstream,
+	// summed over its retries and continuations.
+	UpstreamRequestBytes = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "upstream_request_bytes",
+			Help:      "Upstream request payload size in bytes per client request.",
+			Buckets:   prometheus.ExponentialBuckets(1024, 4, 10), // 1KiB .. 256MiB
+		},
+		[]string{"channel"},
+	)
+
+	// UpstreamResponseBytes measures the bytes a request received from
+	// upstream (SSE, event stream or WebSocket messages).
+	UpstreamResponseBytes = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "upstream_response_bytes",
+			Help:      "Upstream response size in bytes per client request.",
+			Buckets:   prometheus.ExponentialBuckets(1024, 4, 10),
+		},
+		[]string{"channel"},
+	)
+
 	// AccountConnections tracks connections per account.
 	AccountConnections = promauto.NewGaugeVec(
 		prometheus.GaugeOpts{
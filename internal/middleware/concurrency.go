@@ -10,6 +10,8 @@ import (
 	"time"
 
 	"golang.org/x/sync/semaphore"
+
+	apperrors "orchids-api/internal/errors"
 )
 
 // ConcurrencyLimiter limits concurrent request processing using a weighted semaphore.
@@ -83,7 +85,7 @@ func (cl *ConcurrencyLimiter) Limit(next http.HandlerFunc) http.HandlerFunc {
 		if err := cl.sem.Acquire(waitCtx, 1); err != nil {
 			atomic.AddInt64(&cl.rejectedReqs, 1)
 			slog.Warn("Concurrency limit: Wait timeout", "duration", time.Since(acquireStart), "total_rejected", atomic.LoadInt64(&cl.rejectedReqs), "wait_timeout", waitTimeout)
-			http.Error(w, "Request timed out while waiting for a worker slot or server busy", http.StatusServiceUnavailable)
+			apperrors.New(apperrors.CodeOverloaded, "Request timed out while waiting for a worker slot or server busy", http.StatusServiceUnavailable).WriteResponse(w)
 			return
 		}
 
@@ -3,6 +3,7 @@ package middleware
 import (
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -60,6 +61,9 @@ func TestLimiter_RejectsWhenBusy(t *testing.T) {
 	if rec2.Code != http.StatusServiceUnavailable {
 		t.Fatalf("expected 503, got %d", rec2.Code)
 	}
+	if body := rec2.Body.String(); !strings.Contains(body, `"type":"error"`) || !strings.Contains(body, `"overloaded_error"`) {
+		t.Fatalf("expected an overloaded_error body, got %s", body)
+	}
 
 	close(block)
 	wg.Wait()
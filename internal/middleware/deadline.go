@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// BodyReadDeadline 为请求体读取设置单独的读超时，替代 http.Server.ReadTimeout。
+// ReadTimeout 从连接读到请求头起计时，排队等待并发名额的请求在真正读取请求体前
+// 就可能超时；这里在第一次读取请求体时才开始计时，读到 EOF（或出错、关闭）后
+// 立即清除，之后的流式响应不受影响。
+func BodyReadDeadline(timeout time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if timeout <= 0 || r.Body == nil || r.Body == http.NoBody {
+				next.ServeHTTP(w, r)
+				return
+			}
+			body := &deadlineBody{ReadCloser: r.Body, rc: http.NewResponseController(w), timeout: timeout}
+			r.Body = body
+			defer body.clear()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+type deadlineBody struct {
+	io.ReadCloser
+	rc      *http.ResponseController
+	timeout time.Duration
+	arm     sync.Once
+	done    sync.Once
+}
+
+func (b *deadlineBody) Read(p []byte) (int, error) {
+	b.arm.Do(func() {
+		_ = b.rc.SetReadDeadline(time.Now().Add(b.timeout))
+	})
+	n, err := b.ReadCloser.Read(p)
+	if err != nil {
+		b.clear()
+	}
+	return n, err
+}
+
+func (b *deadlineBody) Close() error {
+	b.clear()
+	return b.ReadCloser.Close()
+}
+
+func (b *deadlineBody) clear() {
+	b.done.Do(func() {
+		b.arm.Do(func() {}) // never arm after the body is finished
+		_ = b.rc.SetReadDeadline(time.Time{})
+	})
+}
@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestBodyReadDeadlineStartsOnFirstRead(t *testing.T) {
+	handler := BodyReadDeadline(50 * time.Millisecond)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Simulate waiting for a concurrency slot longer than the body timeout.
+		time.Sleep(150 * time.Millisecond)
+		if _, err := io.ReadAll(r.Body); err != nil {
+			t.Errorf("read body: %v", err)
+			return
+		}
+		// Outlive the body deadline again: the request must stay usable.
+		time.Sleep(150 * time.Millisecond)
+		if err := r.Context().Err(); err != nil {
+			t.Errorf("request context cancelled after body read: %v", err)
+		}
+		io.WriteString(w, "ok")
+	}))
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	// Send the body after the headers so it is read from the connection,
+	// not from the header buffer.
+	pr, pw := io.Pipe()
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		pw.Write([]byte(`{"a":1}`))
+		pw.Close()
+	}()
+	resp, err := http.Post(srv.URL, "application/json", pr)
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "ok" {
+		t.Fatalf("body = %q", body)
+	}
+}
+
+func TestBodyReadDeadlineSlowBody(t *testing.T) {
+	readErr := make(chan error, 1)
+	handler := BodyReadDeadline(50 * time.Millisecond)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := io.ReadAll(r.Body)
+		readErr <- err
+	}))
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	go func() {
+		pw.Write([]byte(`{"a":`))
+	}()
+	go func() {
+		resp, err := http.Post(srv.URL, "application/json", pr)
+		if err == nil {
+			resp.Body.Close()
+		}
+	}()
+
+	select {
+	case err := <-readErr:
+		if err == nil {
+			t.Fatalf("expected slow body read to fail")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("body read deadline not applied")
+	}
+}
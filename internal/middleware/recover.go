@@ -0,0 +1,118 @@
+package middleware
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"strings"
+
+	"github.com/goccy/go-json"
+
+	apperrors "orchids-api/internal/errors"
+	"orchids-api/internal/metrics"
+)
+
+// Recover 捕获处理器中的 panic：记录带 trace ID 的堆栈、计入 panics_total，
+// 并返回 api_error；若已开始输出 SSE，则追加一个 error 事件后正常结束流，
+// 避免客户端把单个异常事件当成网络故障
+func Recover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rw := &recoverWriter{ResponseWriter: w}
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+			streaming := rw.wroteHeader && strings.HasPrefix(rw.Header().Get("Content-Type"), "text/event-stream")
+			if rw.wroteHeader && !streaming {
+				// 已写出部分非流式响应，无法再改写状态码，只记录
+				LogPanic(r, v, debug.Stack())
+				return
+			}
+			HandlePanic(rw, r, v, streaming)
+		}()
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// HandlePanic 记录 panic 并向客户端写出 api_error；streaming 为 true 时以 SSE
+// error 事件写出。供自行 recover 的处理器（如 /v1/messages）复用
+func HandlePanic(w http.ResponseWriter, r *http.Request, v any, streaming bool) {
+	LogPanic(r, v, debug.Stack())
+	message := "Internal server error"
+	if traceID := GetTraceID(r.Context()); traceID != "" {
+		message += " (request " + traceID + ")"
+	}
+	if !streaming {
+		apperrors.New("api_error", message, http.StatusInternalServerError).WriteResponse(w)
+		return
+	}
+	data, _ := json.Marshal(map[string]interface{}{
+		"type": "error",
+		"error": map[string]interface{}{
+			"type":    "api_error",
+			"message": message,
+		},
+	})
+	fmt.Fprintf(w, "event: error\ndata: %s\n\n", data)
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// LogPanic 记录 panic 的值与堆栈，并计入 panics_total
+func LogPanic(r *http.Request, v any, stack []byte) {
+	metrics.PanicsTotal.Inc()
+	slog.Error("Panic while serving request",
+		"trace_id", GetTraceID(r.Context()),
+		"method", r.Method,
+		"path", r.URL.Path,
+		"error", v,
+		"stack", string(stack),
+	)
+}
+
+// recoverWriter 记录响应头是否已写出
+type recoverWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (w *recoverWriter) WriteHeader(code int) {
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *recoverWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *recoverWriter) Flush() {
+	w.wroteHeader = true
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap 供 http.ResponseController 访问底层连接
+func (w *recoverWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Hijack 实现 http.Hijacker，保证 WebSocket 升级可用
+func (w *recoverWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+	w.wroteHeader = true
+	return hj.Hijack()
+}
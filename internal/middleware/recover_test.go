@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRecover(t *testing.T) {
+	t.Run("returns api_error before the response starts", func(t *testing.T) {
+		h := TraceMiddleware(Recover(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			panic("boom")
+		})))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/messages", nil))
+		if rec.Code != http.StatusInternalServerError {
+			t.Fatalf("status=%d", rec.Code)
+		}
+		body := rec.Body.String()
+		if !strings.Contains(body, `"type":"api_error"`) || !strings.Contains(body, rec.Header().Get("X-Trace-ID")) {
+			t.Fatalf("body=%s", body)
+		}
+	})
+
+	t.Run("ends a started stream with an error event", func(t *testing.T) {
+		h := Recover(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("event: message_start\ndata: {}\n\n"))
+			panic("bad event")
+		}))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/messages", nil))
+		body := rec.Body.String()
+		if rec.Code != http.StatusOK || !strings.HasSuffix(body, "\n\n") || !strings.Contains(body, "event: error\ndata: ") || !strings.Contains(body, `"api_error"`) {
+			t.Fatalf("status=%d body=%q", rec.Code, body)
+		}
+	})
+
+	t.Run("re-panics ErrAbortHandler", func(t *testing.T) {
+		defer func() {
+			if v := recover(); v != http.ErrAbortHandler {
+				t.Fatalf("recovered %v", v)
+			}
+		}()
+		Recover(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			panic(http.ErrAbortHandler)
+		})).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	})
+}
@@ -2,11 +2,12 @@ package middleware
 
 import (
 	"crypto/subtle"
-	"github.com/goccy/go-json"
 	"net/http"
 	"strings"
 
 	"orchids-api/internal/auth"
+	apperrors "orchids-api/internal/errors"
+	"orchids-api/internal/store"
 )
 
 func secureCompare(a, b string) bool {
@@ -27,20 +28,48 @@ func bearerToken(r *http.Request) string {
 
 func writeBearerUnauthorized(w http.ResponseWriter, message string) {
 	w.Header().Set("WWW-Authenticate", "Bearer")
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusUnauthorized)
-	_ = json.NewEncoder(w).Encode(map[string]interface{}{
-		"detail": message,
-	})
+	apperrors.New(apperrors.CodeAuthError, message, http.StatusUnauthorized).WriteResponse(w)
 }
 
+// SessionAuth admits the global admin only; tenant admin sessions are
+// rejected with 403.
 func SessionAuth(adminPass, adminToken string, next http.HandlerFunc) http.HandlerFunc {
+	return sessionAuth(adminPass, adminToken, false, next)
+}
+
+// TenantSessionAuth also admits tenant admin sessions, with the request
+// context scoped to their tenant so the store only shows its records.
+func TenantSessionAuth(adminPass, adminToken string, next http.HandlerFunc) http.HandlerFunc {
+	return sessionAuth(adminPass, adminToken, true, next)
+}
+
+// TenantReadOnly only lets tenant-scoped requests read.
+func TenantReadOnly(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		cookie, err := r.Cookie("session_token")
-		if err == nil && auth.ValidateSessionToken(cookie.Value) {
-			next(w, r)
+		if _, scoped := store.TenantFromContext(r.Context()); scoped && r.Method != http.MethodGet && r.Method != http.MethodHead {
+			apperrors.New(apperrors.CodePermissionDenied, "Tenant admins may not modify this resource", http.StatusForbidden).WriteResponse(w)
 			return
 		}
+		next(w, r)
+	}
+}
+
+func sessionAuth(adminPass, adminToken string, allowTenants bool, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie("session_token")
+		if err == nil {
+			if tenantID, ok := auth.SessionTenant(cookie.Value); ok {
+				switch {
+				case tenantID == 0:
+					next(w, r)
+				case allowTenants:
+					next(w, r.WithContext(store.WithTenant(r.Context(), tenantID)))
+				default:
+					apperrors.New(apperrors.CodePermissionDenied, "Tenant admins may not access this resource", http.StatusForbidden).WriteResponse(w)
+				}
+				return
+			}
+		}
 
 		authHeader := r.Header.Get("Authorization")
 		if adminToken != "" {
@@ -88,7 +117,7 @@ func SessionAuth(adminPass, adminToken string, next http.HandlerFunc) http.Handl
 			return
 		}
 
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		apperrors.New(apperrors.CodeAuthError, "Unauthorized", http.StatusUnauthorized).WriteResponse(w)
 	}
 }
 
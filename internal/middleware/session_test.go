@@ -5,6 +5,9 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+
+	"orchids-api/internal/auth"
+	"orchids-api/internal/store"
 )
 
 func TestSessionAuth_AdminPassBearer(t *testing.T) {
@@ -82,6 +85,9 @@ func TestSessionAuth_Unauthorized(t *testing.T) {
 	if rec.Code != http.StatusUnauthorized {
 		t.Fatalf("status=%d want=%d", rec.Code, http.StatusUnauthorized)
 	}
+	if body := rec.Body.String(); !strings.Contains(body, `"type":"error"`) || !strings.Contains(body, `"authentication_error"`) {
+		t.Fatalf("expected an authentication_error body, got %s", body)
+	}
 }
 
 func TestPublicKeyAuth_ValidBearer(t *testing.T) {
@@ -248,3 +254,35 @@ func TestPublicImagineStreamAuth_AllowsWhenNoKey(t *testing.T) {
 		t.Fatalf("status=%d want=%d", rec.Code, http.StatusOK)
 	}
 }
+
+func TestSessionAuth_TenantSessions(t *testing.T) {
+	token, err := auth.GenerateTenantSessionToken(3)
+	if err != nil {
+		t.Fatalf("GenerateTenantSessionToken: %v", err)
+	}
+	t.Cleanup(func() { auth.InvalidateTenantSessions(3) })
+
+	var gotTenant int64
+	var scoped bool
+	next := func(w http.ResponseWriter, r *http.Request) {
+		gotTenant, scoped = store.TenantFromContext(r.Context())
+		w.WriteHeader(http.StatusOK)
+	}
+	serve := func(h http.HandlerFunc, method string) int {
+		req := httptest.NewRequest(method, "/api/keys", nil)
+		req.AddCookie(&http.Cookie{Name: "session_token", Value: token})
+		rec := httptest.NewRecorder()
+		h(rec, req)
+		return rec.Code
+	}
+
+	if code := serve(SessionAuth("admin123", "", next), http.MethodGet); code != http.StatusForbidden {
+		t.Fatalf("global admin route: status=%d want=%d", code, http.StatusForbidden)
+	}
+	if code := serve(TenantSessionAuth("admin123", "", next), http.MethodGet); code != http.StatusOK || !scoped || gotTenant != 3 {
+		t.Fatalf("tenant route: status=%d tenant=%d scoped=%v", code, gotTenant, scoped)
+	}
+	if code := serve(TenantSessionAuth("admin123", "", TenantReadOnly(next)), http.MethodPost); code != http.StatusForbidden {
+		t.Fatalf("read-only route: status=%d want=%d", code, http.StatusForbidden)
+	}
+}
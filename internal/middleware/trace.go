@@ -10,6 +10,7 @@ import (
 	"log/slog"
 	"net"
 	"net/http"
+	"strings"
 	"time"
 )
 
@@ -19,9 +20,42 @@ const TraceIDHeader = "X-Trace-ID"
 // RequestIDHeader 是请求 ID 的 HTTP 头名称（别名）
 const RequestIDHeader = "X-Request-ID"
 
+// TraceparentHeader / TracestateHeader 是 W3C Trace Context 的 HTTP 头名称
+const (
+	TraceparentHeader = "traceparent"
+	TracestateHeader  = "tracestate"
+)
+
 // traceIDKey 是 context 中存储 trace ID 的 key
 type traceIDKey struct{}
 
+// traceHeadersKey 是 context 中存储客户端传入的追踪头的 key
+type traceHeadersKey struct{}
+
+// TraceHeaders 是客户端传入、需要透传给上游并回显的追踪头
+type TraceHeaders struct {
+	Traceparent string
+	Tracestate  string
+	RequestID   string
+}
+
+// validTraceparent 校验 W3C traceparent 格式：version-traceid-parentid-flags
+func validTraceparent(v string) bool {
+	parts := strings.Split(v, "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return false
+	}
+	if parts[0] == "ff" || strings.Trim(parts[1], "0") == "" || strings.Trim(parts[2], "0") == "" {
+		return false
+	}
+	for _, p := range parts[:4] {
+		if _, err := hex.DecodeString(p); err != nil || strings.ToLower(p) != p {
+			return false
+		}
+	}
+	return true
+}
+
 // GenerateTraceID 生成一个新的 trace ID
 func GenerateTraceID() string {
 	b := make([]byte, 16)
@@ -36,20 +70,40 @@ func GenerateTraceID() string {
 // 从请求头获取 trace ID，如果没有则生成新的
 func TraceMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// 尝试从请求头获取 trace ID
+		// 客户端传入的追踪头：透传给上游并回显，便于外部系统端到端关联
+		var th TraceHeaders
+		if tp := strings.TrimSpace(r.Header.Get(TraceparentHeader)); validTraceparent(tp) {
+			th.Traceparent = tp
+			th.Tracestate = strings.TrimSpace(r.Header.Get(TracestateHeader))
+		}
+		th.RequestID = strings.TrimSpace(r.Header.Get(RequestIDHeader))
+
+		// 尝试从请求头获取 trace ID，其次沿用 traceparent 中的 trace-id
 		traceID := r.Header.Get(TraceIDHeader)
 		if traceID == "" {
-			traceID = r.Header.Get(RequestIDHeader)
+			traceID = th.RequestID
+		}
+		if traceID == "" && th.Traceparent != "" {
+			traceID = strings.Split(th.Traceparent, "-")[1]
 		}
 		if traceID == "" {
 			traceID = GenerateTraceID()
 		}
 
-		// 将 trace ID 添加到响应头
+		// 将 trace ID 及传入的追踪头添加到响应头
 		w.Header().Set(TraceIDHeader, traceID)
+		if th.RequestID != "" {
+			w.Header().Set(RequestIDHeader, th.RequestID)
+		}
+		if th.Traceparent != "" {
+			w.Header().Set(TraceparentHeader, th.Traceparent)
+		}
 
 		// 将 trace ID 添加到 context
 		ctx := context.WithValue(r.Context(), traceIDKey{}, traceID)
+		if th != (TraceHeaders{}) {
+			ctx = context.WithValue(ctx, traceHeadersKey{}, th)
+		}
 
 		// 继续处理请求
 		next.ServeHTTP(w, r.WithContext(ctx))
@@ -77,6 +131,20 @@ func WithTraceID(ctx context.Context, traceID string) context.Context {
 	return context.WithValue(ctx, traceIDKey{}, traceID)
 }
 
+// GetTraceHeaders 从 context 获取客户端传入的追踪头
+func GetTraceHeaders(ctx context.Context) TraceHeaders {
+	if ctx == nil {
+		return TraceHeaders{}
+	}
+	th, _ := ctx.Value(traceHeadersKey{}).(TraceHeaders)
+	return th
+}
+
+// WithTraceHeaders 创建带有追踪头的新 context
+func WithTraceHeaders(ctx context.Context, th TraceHeaders) context.Context {
+	return context.WithValue(ctx, traceHeadersKey{}, th)
+}
+
 // LogWithTrace 返回带有 trace ID 的 logger
 func LogWithTrace(ctx context.Context) *slog.Logger {
 	traceID := GetTraceID(ctx)
@@ -121,6 +189,11 @@ func (w *TracedResponseWriter) Flush() {
 	}
 }
 
+// Unwrap 供 http.ResponseController 访问底层连接（读写截止时间等）。
+func (w *TracedResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
 // Hijack 实现 http.Hijacker，保证 WebSocket 升级等场景可用。
 func (w *TracedResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 	hj, ok := w.ResponseWriter.(http.Hijacker)
@@ -368,3 +368,45 @@ func TestChainFunc(t *testing.T) {
 		}
 	}
 }
+
+func TestTraceMiddlewarePropagatesTraceContext(t *testing.T) {
+	const tp = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	var got TraceHeaders
+	var traceID string
+	handler := TraceMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = GetTraceHeaders(r.Context())
+		traceID = GetTraceID(r.Context())
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+	req.Header.Set(TraceparentHeader, tp)
+	req.Header.Set(TracestateHeader, "vendor=abc")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if got.Traceparent != tp || got.Tracestate != "vendor=abc" || got.RequestID != "" {
+		t.Fatalf("trace headers = %+v", got)
+	}
+	if traceID != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Fatalf("trace ID = %q, want the traceparent trace-id", traceID)
+	}
+	if rec.Header().Get(TraceparentHeader) != tp {
+		t.Fatalf("traceparent not echoed: %v", rec.Header())
+	}
+	if rec.Header().Get(RequestIDHeader) != "" {
+		t.Fatalf("X-Request-ID echoed without being sent: %v", rec.Header())
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+	req.Header.Set(TraceparentHeader, "00-00000000000000000000000000000000-00f067aa0ba902b7-01")
+	req.Header.Set(RequestIDHeader, "req-1")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if got.Traceparent != "" || got.RequestID != "req-1" {
+		t.Fatalf("invalid traceparent should be dropped: %+v", got)
+	}
+	if rec.Header().Get(RequestIDHeader) != "req-1" || rec.Header().Get(TraceparentHeader) != "" {
+		t.Fatalf("response headers = %v", rec.Header())
+	}
+}
@@ -0,0 +1,281 @@
+// Package openaicompat implements an upstream client for any OpenAI-style
+// chat.completions backend (OpenRouter, vLLM, llama.cpp server, ...),
+// configured per account with a base URL and API key.
+package openaicompat
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/goccy/go-json"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"orchids-api/internal/config"
+	"orchids-api/internal/debug"
+	"orchids-api/internal/prompt"
+	"orchids-api/internal/store"
+	"orchids-api/internal/upstream"
+	"orchids-api/internal/util"
+)
+
+// AccountType is the account_type / model channel handled by this client.
+const AccountType = "openai-compatible"
+
+const defaultRequestTimeout = 120 * time.Second
+
+type Client struct {
+	config     *config.Config
+	account    *store.Account
+	httpClient *http.Client
+}
+
+func NewFromAccount(acc *store.Account, cfg *config.Config) *Client {
+	timeout := defaultRequestTimeout
+	if cfg != nil && cfg.RequestTimeout > 0 {
+		timeout = time.Duration(cfg.RequestTimeout) * time.Second
+	}
+	proxyFunc := http.ProxyFromEnvironment
+	if cfg != nil {
+		proxyFunc = util.ProxyFunc(cfg.ProxyHTTP, cfg.ProxyHTTPS, cfg.ProxyUser, cfg.ProxyPass, cfg.ProxyBypass)
+	}
+	return &Client{
+		config:  cfg,
+		account: acc,
+		httpClient: &http.Client{
+			Timeout:   timeout,
+			Transport: &http.Transport{Proxy: proxyFunc, ForceAttemptHTTP2: true},
+		},
+	}
+}
+
+// ChatCompletionsURL returns the chat.completions endpoint for a configured
+// base URL, accepting both ".../v1" and the full endpoint.
+func ChatCompletionsURL(baseURL string) string {
+	base := strings.TrimRight(strings.TrimSpace(baseURL), "/")
+	if strings.HasSuffix(base, "/chat/completions") {
+		return base
+	}
+	return base + "/chat/completions"
+}
+
+func (c *Client) SendRequest(ctx context.Context, prompt string, chatHistory []interface{}, model string, onMessage func(upstream.SSEMessage), logger *debug.Logger) error {
+	req := upstream.UpstreamRequest{
+		Prompt:      prompt,
+		ChatHistory: chatHistory,
+		Model:       model,
+	}
+	return c.SendRequestWithPayload(ctx, req, onMessage, logger)
+}
+
+func (c *Client) SendRequestWithPayload(ctx context.Context, req upstream.UpstreamRequest, onMessage func(upstream.SSEMessage), logger *debug.Logger) error {
+	if c.account == nil || strings.TrimSpace(c.account.BaseURL) == "" {
+		return fmt.Errorf("openai-compatible account has no base_url")
+	}
+	if len(req.Messages) == 0 && strings.TrimSpace(req.Prompt) != "" {
+		req.Messages = []prompt.Message{{Role: "user", Content: prompt.MessageContent{Text: req.Prompt}}}
+	}
+	payload, err := buildRequest(req)
+	if err != nil {
+		return err
+	}
+
+	endpoint := ChatCompletionsURL(c.account.BaseURL)
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Accept", "text/event-stream")
+	if key := strings.TrimSpace(c.account.APIKey); key != "" {
+		request.Header.Set("Authorization", "Bearer "+key)
+	}
+	upstream.ApplyAccountHeaders(request.Header, c.account)
+	upstream.ApplyTraceHeaders(ctx, request.Header)
+	upstream.MeterSent(ctx, len(payload))
+	if logger != nil {
+		logger.LogUpstreamRequest(endpoint, map[string]string{"content-type": "application/json"}, payload)
+	}
+	if c.config != nil && c.config.DebugEnabled {
+		slog.Debug("OpenAI-compatible: Dispatching request", "url", endpoint, "model", req.Model, "body_size", len(payload))
+	}
+
+	result, err := upstream.GetAccountBreaker(c.breakerKey()).Execute(func() (interface{}, error) {
+		return c.httpClient.Do(request)
+	})
+	if err != nil {
+		return err
+	}
+	resp, ok := result.(*http.Response)
+	if !ok || resp == nil {
+		return fmt.Errorf("openai-compatible api error: unexpected response type")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		if logger != nil {
+			logger.LogUpstreamHTTPError(endpoint, resp.StatusCode, string(body), nil)
+		}
+		slog.Warn("OpenAI-compatible request failed", "status", resp.StatusCode, "body", string(body))
+		return fmt.Errorf("openai-compatible api error: HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	ctxDone := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = resp.Body.Close()
+		case <-ctxDone:
+		}
+	}()
+	defer close(ctxDone)
+
+	err = consumeStream(upstream.MeterBody(ctx, resp.Body), onMessage, logger)
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+type streamChunk struct {
+	Choices []struct {
+		Delta struct {
+			Content          string `json:"content"`
+			ReasoningContent string `json:"reasoning_content"`
+			Reasoning        string `json:"reasoning"`
+			ToolCalls        []struct {
+				Index    int    `json:"index"`
+				ID       string `json:"id"`
+				Function struct {
+					Name      string `json:"name"`
+					Arguments string `json:"arguments"`
+				} `json:"function"`
+			} `json:"tool_calls"`
+		} `json:"delta"`
+		FinishReason string `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+	} `json:"usage"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+type pendingToolCall struct {
+	id   string
+	name string
+	args strings.Builder
+}
+
+// consumeStream maps chat.completions chunks onto the shared model.* messages.
+func consumeStream(body io.Reader, onMessage func(upstream.SSEMessage), logger *debug.Logger) error {
+	reader := bufio.NewReader(body)
+	calls := make(map[int]*pendingToolCall)
+	finishReason := ""
+	var usage map[string]interface{}
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "data:") {
+			data := strings.TrimSpace(line[5:])
+			if data == "[DONE]" {
+				break
+			}
+			if logger != nil {
+				logger.LogUpstreamSSE("openai_chunk", data)
+			}
+			var chunk streamChunk
+			if jsonErr := json.Unmarshal([]byte(data), &chunk); jsonErr != nil {
+				continue
+			}
+			if chunk.Error != nil {
+				return fmt.Errorf("openai-compatible stream error: %s", chunk.Error.Message)
+			}
+			if chunk.Usage != nil {
+				usage = map[string]interface{}{
+					"inputTokens":  chunk.Usage.PromptTokens,
+					"outputTokens": chunk.Usage.CompletionTokens,
+				}
+			}
+			for _, choice := range chunk.Choices {
+				if reasoning := choice.Delta.ReasoningContent + choice.Delta.Reasoning; reasoning != "" {
+					onMessage(upstream.SSEMessage{Type: "model.reasoning-delta", Event: map[string]interface{}{"delta": reasoning}})
+				}
+				if choice.Delta.Content != "" {
+					onMessage(upstream.SSEMessage{Type: "model.text-delta", Event: map[string]interface{}{"delta": choice.Delta.Content}})
+				}
+				for _, tc := range choice.Delta.ToolCalls {
+					call, ok := calls[tc.Index]
+					if !ok {
+						call = &pendingToolCall{}
+						calls[tc.Index] = call
+					}
+					if tc.ID != "" {
+						call.id = tc.ID
+					}
+					if tc.Function.Name != "" {
+						call.name = tc.Function.Name
+					}
+					call.args.WriteString(tc.Function.Arguments)
+				}
+				if choice.FinishReason != "" {
+					finishReason = choice.FinishReason
+				}
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+
+	indexes := make([]int, 0, len(calls))
+	for idx := range calls {
+		indexes = append(indexes, idx)
+	}
+	sort.Ints(indexes)
+	for _, idx := range indexes {
+		call := calls[idx]
+		if call.name == "" {
+			continue
+		}
+		args := strings.TrimSpace(call.args.String())
+		if args == "" {
+			args = "{}"
+		}
+		onMessage(upstream.SSEMessage{Type: "model.tool-call", Event: map[string]interface{}{"toolCallId": call.id, "toolName": call.name, "input": args}})
+	}
+
+	finish := map[string]interface{}{"finishReason": "end_turn"}
+	switch {
+	case len(indexes) > 0 || finishReason == "tool_calls":
+		finish["finishReason"] = "tool_use"
+	case finishReason == "length":
+		finish["finishReason"] = "max_tokens"
+	}
+	if usage != nil {
+		finish["usage"] = usage
+	}
+	onMessage(upstream.SSEMessage{Type: "model.finish", Event: finish})
+	return nil
+}
+
+func (c *Client) breakerKey() string {
+	if c == nil || c.account == nil {
+		return "openai-compatible:default"
+	}
+	if name := strings.TrimSpace(c.account.Name); name != "" {
+		return "openai-compatible:" + name
+	}
+	return fmt.Sprintf("openai-compatible:%d", c.account.ID)
+}
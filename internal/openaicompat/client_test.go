@@ -0,0 +1,207 @@
+package openaicompat
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/goccy/go-json"
+
+	"orchids-api/internal/prompt"
+	"orchids-api/internal/store"
+	"orchids-api/internal/upstream"
+)
+
+func TestChatCompletionsURL(t *testing.T) {
+	cases := map[string]string{
+		"https://api.example.com/v1":                   "https://api.example.com/v1/chat/completions",
+		"https://api.example.com/v1/":                  "https://api.example.com/v1/chat/completions",
+		"https://api.example.com/v1/chat/completions/": "https://api.example.com/v1/chat/completions",
+	}
+	for in, want := range cases {
+		if got := ChatCompletionsURL(in); got != want {
+			t.Fatalf("ChatCompletionsURL(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestSendRequestWithPayload(t *testing.T) {
+	var lastBody []byte
+	var auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			http.NotFound(w, r)
+			return
+		}
+		auth = r.Header.Get("Authorization")
+		lastBody, _ = io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "data: {\"choices\":[{\"delta\":{\"reasoning_content\":\"thinking\"}}]}\n\n")
+		io.WriteString(w, "data: {\"choices\":[{\"delta\":{\"content\":\"Reading \"}}]}\n\n")
+		io.WriteString(w, "data: {\"choices\":[{\"delta\":{\"content\":\"file\"}}]}\n\n")
+		io.WriteString(w, "data: {\"choices\":[{\"delta\":{\"tool_calls\":[{\"index\":0,\"id\":\"call_1\",\"function\":{\"name\":\"Read\",\"arguments\":\"{\\\"path\\\":\"}}]}}]}\n\n")
+		io.WriteString(w, "data: {\"choices\":[{\"delta\":{\"tool_calls\":[{\"index\":0,\"function\":{\"arguments\":\"\\\"a.go\\\"}\"}}]},\"finish_reason\":\"tool_calls\"}]}\n\n")
+		io.WriteString(w, "data: {\"choices\":[],\"usage\":{\"prompt_tokens\":42,\"completion_tokens\":7}}\n\n")
+		io.WriteString(w, "data: [DONE]\n\n")
+	}))
+	defer srv.Close()
+
+	acc := &store.Account{ID: 1, Name: "compat-test", AccountType: AccountType, BaseURL: srv.URL + "/v1", APIKey: "sk-test"}
+	client := NewFromAccount(acc, nil)
+
+	req := upstream.UpstreamRequest{
+		Model:  "gpt-4o",
+		System: []prompt.SystemItem{{Type: "text", Text: "Be brief."}},
+		Messages: []prompt.Message{
+			{Role: "user", Content: prompt.MessageContent{Text: "open main.go"}},
+			{Role: "assistant", Content: prompt.MessageContent{Blocks: []prompt.ContentBlock{
+				{Type: "tool_use", ID: "call_0", Name: "Read", Input: map[string]interface{}{"path": "main.go"}},
+			}}},
+			{Role: "user", Content: prompt.MessageContent{Blocks: []prompt.ContentBlock{
+				{Type: "tool_result", ToolUseID: "call_0", Content: "package main"},
+			}}},
+		},
+		Tools: []interface{}{map[string]interface{}{
+			"name":         "Read",
+			"description":  "Read a file",
+			"input_schema": map[string]interface{}{"type": "object"},
+		}},
+	}
+	var messages []upstream.SSEMessage
+	err := client.SendRequestWithPayload(context.Background(), req, func(msg upstream.SSEMessage) {
+		messages = append(messages, msg)
+	}, nil)
+	if err != nil {
+		t.Fatalf("SendRequestWithPayload: %v", err)
+	}
+	if auth != "Bearer sk-test" {
+		t.Fatalf("authorization = %q", auth)
+	}
+
+	var text, reasoning strings.Builder
+	var toolInput string
+	var finish map[string]interface{}
+	for _, msg := range messages {
+		switch msg.Type {
+		case "model.text-delta":
+			text.WriteString(msg.Event["delta"].(string))
+		case "model.reasoning-delta":
+			reasoning.WriteString(msg.Event["delta"].(string))
+		case "model.tool-call":
+			toolInput, _ = msg.Event["input"].(string)
+		case "model.finish":
+			finish = msg.Event
+		}
+	}
+	if text.String() != "Reading file" || reasoning.String() != "thinking" {
+		t.Fatalf("text = %q reasoning = %q", text.String(), reasoning.String())
+	}
+	if toolInput != `{"path":"a.go"}` {
+		t.Fatalf("tool input = %q", toolInput)
+	}
+	if finish["finishReason"] != "tool_use" {
+		t.Fatalf("finish = %v", finish)
+	}
+	if usage, _ := finish["usage"].(map[string]interface{}); usage["inputTokens"] != 42 || usage["outputTokens"] != 7 {
+		t.Fatalf("usage = %v", finish["usage"])
+	}
+
+	var sent struct {
+		Model    string                   `json:"model"`
+		Messages []map[string]interface{} `json:"messages"`
+		Tools    []map[string]interface{} `json:"tools"`
+		Stream   bool                     `json:"stream"`
+	}
+	if err := json.Unmarshal(lastBody, &sent); err != nil {
+		t.Fatalf("decode request: %v", err)
+	}
+	if sent.Model != "gpt-4o" || !sent.Stream || len(sent.Tools) != 1 {
+		t.Fatalf("unexpected request: %s", lastBody)
+	}
+	roles := make([]string, 0, len(sent.Messages))
+	for _, m := range sent.Messages {
+		roles = append(roles, m["role"].(string))
+	}
+	if got := strings.Join(roles, ","); got != "system,user,assistant,tool" {
+		t.Fatalf("roles = %s", got)
+	}
+	if sent.Messages[3]["tool_call_id"] != "call_0" || sent.Messages[3]["content"] != "package main" {
+		t.Fatalf("tool message = %v", sent.Messages[3])
+	}
+}
+
+func TestSendRequestWithPayloadHTTPError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(w, `{"error":{"message":"invalid api key"}}`)
+	}))
+	defer srv.Close()
+
+	acc := &store.Account{ID: 2, Name: "compat-unauthorized", AccountType: AccountType, BaseURL: srv.URL, APIKey: "bad"}
+	err := NewFromAccount(acc, nil).SendRequest(context.Background(), "hi", nil, "gpt-4o", func(upstream.SSEMessage) {}, nil)
+	if err == nil || !strings.Contains(err.Error(), "HTTP 401") {
+		t.Fatalf("expected HTTP 401 error, got %v", err)
+	}
+}
+
+func TestSendRequestWithPayloadResponseCap(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for i := 0; i < 1000; i++ {
+			io.WriteString(w, "data: {\"choices\":[{\"delta\":{\"content\":\"spam spam spam\"}}]}\n\n")
+		}
+	}))
+	defer srv.Close()
+
+	acc := &store.Account{ID: 3, Name: "compat-runaway", AccountType: AccountType, BaseURL: srv.URL}
+	meter := upstream.NewMeter(4 << 10)
+	ctx := upstream.WithMeter(context.Background(), meter)
+	var deltas int
+	err := NewFromAccount(acc, nil).SendRequest(ctx, "hi", nil, "gpt-4o", func(msg upstream.SSEMessage) {
+		if msg.EventKey() == "model.text-delta" {
+			deltas++
+		}
+	}, nil)
+	if !errors.Is(err, upstream.ErrResponseTooLarge) {
+		t.Fatalf("expected ErrResponseTooLarge, got %v", err)
+	}
+	if deltas == 0 || deltas >= 1000 {
+		t.Fatalf("deltas = %d, want the stream cut short", deltas)
+	}
+	if meter.Sent() == 0 || meter.Received() <= 4<<10 {
+		t.Fatalf("sent=%d received=%d", meter.Sent(), meter.Received())
+	}
+}
+
+func TestLengthFinishAndMaxTokens(t *testing.T) {
+	var lastBody []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lastBody, _ = io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "data: {\"choices\":[{\"delta\":{\"content\":\"once upon\"},\"finish_reason\":\"length\"}]}\n\n")
+		io.WriteString(w, "data: [DONE]\n\n")
+	}))
+	defer srv.Close()
+
+	acc := &store.Account{ID: 4, Name: "compat-length", AccountType: AccountType, BaseURL: srv.URL}
+	var finish map[string]interface{}
+	req := upstream.UpstreamRequest{Model: "gpt-4o", Prompt: "tell a story", MaxTokens: 16}
+	err := NewFromAccount(acc, nil).SendRequestWithPayload(context.Background(), req, func(msg upstream.SSEMessage) {
+		if msg.Type == "model.finish" {
+			finish = msg.Event
+		}
+	}, nil)
+	if err != nil {
+		t.Fatalf("SendRequestWithPayload: %v", err)
+	}
+	if finish["finishReason"] != "max_tokens" {
+		t.Fatalf("finish = %v", finish)
+	}
+	if !strings.Contains(string(lastBody), `"max_tokens":16`) {
+		t.Fatalf("max_tokens not forwarded: %s", lastBody)
+	}
+}
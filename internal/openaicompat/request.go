@@ -0,0 +1,183 @@
+package openaicompat
+
+import (
+	"github.com/goccy/go-json"
+	"strings"
+
+	"orchids-api/internal/prompt"
+	"orchids-api/internal/upstream"
+)
+
+type chatRequest struct {
+	Model         string                   `json:"model"`
+	Messages      []map[string]interface{} `json:"messages"`
+	Tools         []map[string]interface{} `json:"tools,omitempty"`
+	MaxTokens     int                      `json:"max_tokens,omitempty"`
+	Stream        bool                     `json:"stream"`
+	StreamOptions map[string]interface{}   `json:"stream_options,omitempty"`
+}
+
+// buildRequest converts an Anthropic-style upstream request into an OpenAI
+// chat.completions body.
+func buildRequest(req upstream.UpstreamRequest) ([]byte, error) {
+	body := chatRequest{
+		Model:         req.Model,
+		MaxTokens:     req.MaxTokens,
+		Stream:        true,
+		StreamOptions: map[string]interface{}{"include_usage": true},
+	}
+	if system := systemText(req.System); system != "" {
+		body.Messages = append(body.Messages, map[string]interface{}{"role": "system", "content": system})
+	}
+	for _, m := range req.Messages {
+		body.Messages = append(body.Messages, convertMessage(m)...)
+	}
+	if !req.NoTools {
+		body.Tools = convertTools(req.Tools)
+	}
+	return json.Marshal(body)
+}
+
+// convertMessage maps one Anthropic message to one or more OpenAI messages:
+// tool_result blocks become separate "tool" role messages and tool_use
+// blocks become assistant tool_calls.
+func convertMessage(m prompt.Message) []map[string]interface{} {
+	role := "user"
+	if m.Role == "assistant" {
+		role = "assistant"
+	}
+	if m.Content.IsString() {
+		return []map[string]interface{}{{"role": role, "content": m.Content.GetText()}}
+	}
+
+	var out []map[string]interface{}
+	var parts []map[string]interface{}
+	var text []string
+	var toolCalls []map[string]interface{}
+	hasImage := false
+	for _, block := range m.Content.GetBlocks() {
+		switch block.Type {
+		case "text":
+			if block.Text == "" {
+				continue
+			}
+			text = append(text, block.Text)
+			parts = append(parts, map[string]interface{}{"type": "text", "text": block.Text})
+		case "image":
+			if role != "user" || block.Source == nil {
+				continue
+			}
+			url := block.Source.URL
+			if block.Source.Type == "base64" && block.Source.Data != "" {
+				url = "data:" + block.Source.MediaType + ";base64," + block.Source.Data
+			}
+			if url == "" {
+				continue
+			}
+			hasImage = true
+			parts = append(parts, map[string]interface{}{"type": "image_url", "image_url": map[string]interface{}{"url": url}})
+		case "tool_use":
+			if role != "assistant" {
+				continue
+			}
+			input := block.Input
+			if input == nil {
+				input = map[string]interface{}{}
+			}
+			args, _ := json.Marshal(input)
+			toolCalls = append(toolCalls, map[string]interface{}{
+				"id":   block.ID,
+				"type": "function",
+				"function": map[string]interface{}{
+					"name":      block.Name,
+					"arguments": string(args),
+				},
+			})
+		case "tool_result":
+			if role != "user" {
+				continue
+			}
+			content := toolResultText(block.Content)
+			if block.IsError && content != "" {
+				content = "Error: " + content
+			}
+			out = append(out, map[string]interface{}{"role": "tool", "tool_call_id": block.ToolUseID, "content": content})
+		}
+	}
+
+	if role == "assistant" {
+		if len(text) == 0 && len(toolCalls) == 0 {
+			return out
+		}
+		msg := map[string]interface{}{"role": "assistant", "content": strings.Join(text, "\n\n")}
+		if len(toolCalls) > 0 {
+			msg["tool_calls"] = toolCalls
+		}
+		return append(out, msg)
+	}
+	if hasImage {
+		return append(out, map[string]interface{}{"role": "user", "content": parts})
+	}
+	if len(text) > 0 {
+		out = append(out, map[string]interface{}{"role": "user", "content": strings.Join(text, "\n\n")})
+	}
+	return out
+}
+
+func toolResultText(content interface{}) string {
+	switch v := content.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []interface{}:
+		var parts []string
+		for _, item := range v {
+			if m, ok := item.(map[string]interface{}); ok {
+				if text, ok := m["text"].(string); ok {
+					parts = append(parts, text)
+				}
+			}
+		}
+		return strings.Join(parts, "\n")
+	default:
+		raw, _ := json.Marshal(v)
+		return string(raw)
+	}
+}
+
+func systemText(items []prompt.SystemItem) string {
+	var parts []string
+	for _, item := range items {
+		if text := strings.TrimSpace(item.Text); text != "" {
+			parts = append(parts, text)
+		}
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// convertTools maps Anthropic tool definitions to OpenAI function tools.
+func convertTools(tools []interface{}) []map[string]interface{} {
+	out := make([]map[string]interface{}, 0, len(tools))
+	for _, raw := range tools {
+		tool, ok := raw.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		name, _ := tool["name"].(string)
+		if strings.TrimSpace(name) == "" {
+			continue
+		}
+		fn := map[string]interface{}{"name": name}
+		if description, _ := tool["description"].(string); description != "" {
+			fn["description"] = description
+		}
+		if schema, ok := tool["input_schema"].(map[string]interface{}); ok {
+			fn["parameters"] = schema
+		} else {
+			fn["parameters"] = map[string]interface{}{"type": "object", "properties": map[string]interface{}{}}
+		}
+		out = append(out, map[string]interface{}{"type": "function", "function": fn})
+	}
+	return out
+}
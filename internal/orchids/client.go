@@ -27,7 +27,25 @@ import (
 )
 
 const defaultUpstreamBaseURL = "https://orchids-server.calmstone-6964e08a.westeurope.azurecontainerapps.io"
-const upstreamURL = defaultUpstreamBaseURL + "/agent/coding-agent"
+const codingAgentPath = "/agent/coding-agent"
+const upstreamURL = defaultUpstreamBaseURL + codingAgentPath
+
+// CodingAgentURL returns the coding-agent endpoint for a configured base URL.
+// An empty base URL means the default upstream; the full endpoint is also
+// accepted.
+func CodingAgentURL(baseURL string) string {
+	base := strings.TrimRight(strings.TrimSpace(baseURL), "/")
+	if base == "" {
+		return upstreamURL
+	}
+	if strings.HasSuffix(base, codingAgentPath) {
+		return base
+	}
+	return base + codingAgentPath
+}
+
+// clerkBaseURL is where session tokens are minted; tests point it at a stub.
+var clerkBaseURL = clerk.ClerkBaseURL
 
 const (
 	defaultTokenTTL = 5 * time.Minute
@@ -67,6 +85,7 @@ type AgentRequest struct {
 
 type cachedToken struct {
 	token     string
+	fetchedAt time.Time
 	expiresAt time.Time
 }
 
@@ -169,6 +188,12 @@ func NewFromAccount(acc *store.Account, base *config.Config) *Client {
 		cfg.ProxyPass = base.ProxyPass
 		cfg.ProxyBypass = base.ProxyBypass
 	}
+	// An account (or channel) base URL points the account at a self-hosted or
+	// regional Orchids server.
+	if baseURL := strings.TrimRight(strings.TrimSpace(acc.BaseURL), "/"); baseURL != "" {
+		cfg.UpstreamURL = CodingAgentURL(baseURL)
+		cfg.OrchidsAPIBaseURL = strings.TrimSuffix(baseURL, codingAgentPath)
+	}
 
 	c := &Client{
 		config:     cfg,
@@ -180,10 +205,29 @@ func NewFromAccount(acc *store.Account, base *config.Config) *Client {
 	return c
 }
 
+// GetToken returns a bearer token for the upstream. Failures of Clerk-backed
+// accounts are cached with a per-session backoff, see tokenBackoff.
 func (c *Client) GetToken() (string, error) {
 	if c == nil || c.config == nil {
 		return "", errors.New("missing config")
 	}
+	key := c.tokenFailureKey()
+	if key == "" {
+		return c.getToken()
+	}
+	if err := tokenBackoff(key, time.Now()); err != nil {
+		return "", err
+	}
+	token, err := c.getToken()
+	if err != nil {
+		recordTokenFailure(key, c.account.ID, err, time.Now())
+		return "", err
+	}
+	clearTokenFailure(key, c.account.ID)
+	return token, nil
+}
+
+func (c *Client) getToken() (string, error) {
 	if c.config.UpstreamToken != "" {
 		return c.config.UpstreamToken, nil
 	}
@@ -195,9 +239,11 @@ func (c *Client) GetToken() (string, error) {
 		if strings.TrimSpace(c.account.ClientCookie) != "" {
 			// If we already have a cached token for this session, use it.
 			if cached, ok := getCachedToken(strings.TrimSpace(c.account.SessionID)); ok {
+				notePrefetchTarget(c.config)
 				return cached, nil
 			}
 
+			start := time.Now()
 			proxyFunc := http.ProxyFromEnvironment
 			if c.config != nil {
 				proxyFunc = util.ProxyFunc(c.config.ProxyHTTP, c.config.ProxyHTTPS, c.config.ProxyUser, c.config.ProxyPass, c.config.ProxyBypass)
@@ -215,6 +261,8 @@ func (c *Client) GetToken() (string, error) {
 					bearer, tokErr := c.fetchToken()
 					if tokErr == nil && strings.TrimSpace(bearer) != "" {
 						setCachedToken(info.SessionID, bearer)
+						observeTokenFetch(tokenFetchOnDemand, start, true)
+						notePrefetchTarget(c.config)
 						slog.Debug("Orchids token source", "source", "clerk_session_tokens_endpoint", "session_id", info.SessionID, "has_session_cookie", strings.TrimSpace(c.account.SessionCookie) != "")
 						return bearer, nil
 					}
@@ -239,6 +287,7 @@ func (c *Client) GetToken() (string, error) {
 				}
 			}
 			// If Clerk fetch fails, fall back to any stored token below.
+			observeTokenFetch(tokenFetchOnDemand, start, false)
 		}
 
 		// Per-account JWT: allow using a pasted bearer token directly.
@@ -314,7 +363,7 @@ func (c *Client) fetchToken() (string, error) {
 	}
 
 	url := fmt.Sprintf("%s/v1/client/sessions/%s/tokens?__clerk_api_version=%s&_clerk_js_version=%s",
-		clerk.ClerkBaseURL, sid, clerk.ClerkAPIVersion, clerk.ClerkJSVersion)
+		clerkBaseURL, sid, clerk.ClerkAPIVersion, clerk.ClerkJSVersion)
 
 	ctx, cancel := withDefaultTimeout(context.Background(), c.requestTimeout())
 	defer cancel()
@@ -481,10 +530,16 @@ func (c *Client) sendRequestSSE(ctx context.Context, req upstream.UpstreamReques
 		email = cfg.Email
 		userID = cfg.UserID
 	}
-	payloadTools := compactIncomingTools(req.Tools)
+	payloadTools := cachedCompactTools(req.Tools).tools
 	if req.NoTools {
 		payloadTools = nil
 	}
+	if v := strings.TrimSpace(req.ProjectID); v != "" {
+		projectID = v
+	}
+	if v := strings.TrimSpace(req.AgentMode); v != "" {
+		agentMode = v
+	}
 	if strings.TrimSpace(agentMode) == "" || strings.EqualFold(agentMode, "auto") {
 		agentMode = normalizeAIClientModel(req.Model)
 	}
@@ -533,6 +588,9 @@ func (c *Client) sendRequestSSE(ctx context.Context, req upstream.UpstreamReques
 		httpReq.Header.Set("Authorization", "Bearer "+token)
 		httpReq.Header.Set("Content-Type", "application/json")
 		httpReq.Header.Set("X-Orchids-Api-Version", "2")
+		upstream.ApplyAccountHeaders(httpReq.Header, c.account)
+		upstream.ApplyTraceHeaders(ctx, httpReq.Header)
+		upstream.MeterSent(ctx, buf.Len())
 
 		// 记录上游请求
 		if logger != nil {
@@ -571,13 +629,13 @@ func (c *Client) sendRequestSSE(ctx context.Context, req upstream.UpstreamReques
 		return fmt.Errorf("upstream request failed with status %d: %s", resp.StatusCode, string(body))
 	}
 
-	limitedBody := resp.Body
+	limitedBody := upstream.MeterBody(ctx, resp.Body)
 
 	reader := perf.AcquireBufioReader(limitedBody)
 	defer perf.ReleaseBufioReader(reader)
 
-	buffer := perf.AcquireStringBuilder()
-	defer perf.ReleaseStringBuilder(buffer)
+	lines := newSSELineReader(reader)
+	defer lines.release()
 
 	var state requestState
 	var fsWG sync.WaitGroup
@@ -589,39 +647,21 @@ func (c *Client) sendRequestSSE(ctx context.Context, req upstream.UpstreamReques
 		default:
 		}
 
-		line, err := reader.ReadString('\n')
+		line, err := lines.next()
 		if err != nil {
 			if err == io.EOF {
 				break
 			}
 			return err
 		}
-
-		buffer.WriteString(line)
-
-		if line == "\n" {
-			eventData := buffer.String()
-			buffer.Reset()
-
-			lines := strings.Split(eventData, "\n")
-			for _, l := range lines {
-				if strings.HasPrefix(l, "data: ") {
-					rawData := strings.TrimPrefix(l, "data: ")
-
-					var msg map[string]interface{}
-					if err := json.Unmarshal([]byte(rawData), &msg); err != nil {
-						continue
-					}
-
-					if shouldBreak := c.handleOrchidsMessage(msg, []byte(rawData), &state, onMessage, logger, nil, &fsWG, req.Workdir); shouldBreak {
-						goto done
-					}
-				}
-			}
+		if !bytes.HasPrefix(line, sseDataPrefix) {
+			continue
+		}
+		if _, shouldBreak := c.handleOrchidsData(line[len(sseDataPrefix):], &state, onMessage, logger, nil, &fsWG, req.Workdir); shouldBreak {
+			break
 		}
 	}
 
-done:
 	if state.errorMsg != "" {
 		return fmt.Errorf("orchids upstream error: %s", state.errorMsg)
 	}
@@ -790,12 +830,13 @@ func setCachedToken(sessionID, token string) {
 	tokenCache.mu.Lock()
 	tokenCache.items[sessionID] = cachedToken{
 		token:     token,
+		fetchedAt: time.Now(),
 		expiresAt: expiresAt,
 	}
 	tokenCache.mu.Unlock()
 }
 
-// InvalidateCachedToken 清除指定 sessionID 的 token 缓存，
+// InvalidateCachedToken 清除指定 sessionID 的 token 缓存与获取失败退避，
 // 用于账号 401 冷却恢复后强制重新获取 token。
 func InvalidateCachedToken(sessionID string) {
 	if sessionID == "" {
@@ -804,6 +845,9 @@ func InvalidateCachedToken(sessionID string) {
 	tokenCache.mu.Lock()
 	delete(tokenCache.items, sessionID)
 	tokenCache.mu.Unlock()
+	tokenFailures.mu.Lock()
+	delete(tokenFailures.sessions, sessionID)
+	tokenFailures.mu.Unlock()
 }
 
 func tokenExpiry(token string) time.Time {
@@ -9,6 +9,25 @@ import (
 	"orchids-api/internal/store"
 )
 
+func TestCodingAgentURL(t *testing.T) {
+	cases := map[string]string{
+		"":                          upstreamURL,
+		"https://orchids.internal":  "https://orchids.internal/agent/coding-agent",
+		"https://orchids.internal/": "https://orchids.internal/agent/coding-agent",
+		"https://orchids.internal/agent/coding-agent": "https://orchids.internal/agent/coding-agent",
+	}
+	for in, want := range cases {
+		if got := CodingAgentURL(in); got != want {
+			t.Fatalf("CodingAgentURL(%q) = %q, want %q", in, got, want)
+		}
+	}
+
+	c := NewFromAccount(&store.Account{ID: 1, BaseURL: "https://orchids.internal/"}, &config.Config{})
+	if c.config.UpstreamURL != "https://orchids.internal/agent/coding-agent" || c.config.OrchidsAPIBaseURL != "https://orchids.internal" {
+		t.Fatalf("account base URL not applied: upstream=%q api=%q", c.config.UpstreamURL, c.config.OrchidsAPIBaseURL)
+	}
+}
+
 func TestNewFromAccount_ProxyAppliedToEachAccount(t *testing.T) {
 	base := &config.Config{
 		ProxyHTTP: "http://proxy.local:3128",
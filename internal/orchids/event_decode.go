@@ -0,0 +1,146 @@
+package orchids
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"sync"
+
+	"github.com/goccy/go-json"
+	"github.com/gorilla/websocket"
+
+	"orchids-api/internal/debug"
+	"orchids-api/internal/perf"
+	"orchids-api/internal/upstream"
+)
+
+// orchidsDeltaEvent is the typed view of the high-volume text/reasoning chunk
+// events. Decoding into it skips building a map for every token; anything it
+// cannot represent falls back to the generic map path.
+type orchidsDeltaEvent struct {
+	Type  string  `json:"type"`
+	Delta *string `json:"delta"`
+	Text  *string `json:"text"`
+}
+
+func (e *orchidsDeltaEvent) text() (string, bool) {
+	switch {
+	case e.Delta != nil:
+		return *e.Delta, true
+	case e.Text != nil:
+		return *e.Text, true
+	}
+	return "", false
+}
+
+// handleOrchidsData decodes one upstream event (SSE data line or WS frame) and
+// dispatches it. decoded is false when data is not a JSON object.
+func (c *Client) handleOrchidsData(
+	data []byte,
+	state *requestState,
+	onMessage func(upstream.SSEMessage),
+	logger *debug.Logger,
+	conn *websocket.Conn,
+	fsWG *sync.WaitGroup,
+	workdir string,
+) (decoded bool, shouldBreak bool) {
+	var ev orchidsDeltaEvent
+	if err := json.Unmarshal(data, &ev); err == nil {
+		switch ev.Type {
+		case EventOutputTextDelta, EventResponseChunk, EventReasoningChunk:
+			if text, ok := ev.text(); ok {
+				if logger != nil {
+					logger.LogUpstreamSSE(ev.Type, string(data))
+				}
+				if ev.Type == EventReasoningChunk {
+					handleReasoningChunk(text, state, onMessage)
+				} else {
+					handleTextChunk(ev.Type, text, state, onMessage)
+				}
+				return true, false
+			}
+		}
+	}
+
+	var msg map[string]interface{}
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return false, false
+	}
+	return true, c.handleOrchidsMessage(msg, data, state, onMessage, logger, conn, fsWG, workdir)
+}
+
+func handleReasoningChunk(text string, state *requestState, onMessage func(upstream.SSEMessage)) {
+	state.preferCodingAgent = true
+	if text == "" {
+		return
+	}
+	if !state.reasoningStarted {
+		state.reasoningStarted = true
+		onMessage(upstream.SSEMessage{Type: "model", Event: map[string]interface{}{"type": "reasoning-start", "id": "0"}})
+	}
+	onMessage(upstream.SSEMessage{Type: "model", Event: map[string]interface{}{"type": "reasoning-delta", "id": "0", "delta": text}})
+}
+
+func handleTextChunk(msgType string, text string, state *requestState, onMessage func(upstream.SSEMessage)) {
+	state.preferCodingAgent = true
+	if text == "" {
+		return
+	}
+	// Suppress only cross-channel duplicate chunks (e.g. output_text_delta + response.chunk).
+	// Repeated chunks from the same channel can be legitimate content and must be preserved.
+	if text == state.lastTextDelta && state.lastTextEvent != msgType {
+		return
+	}
+	state.lastTextDelta = text
+	state.lastTextEvent = msgType
+	if !state.textStarted {
+		state.textStarted = true
+		onMessage(upstream.SSEMessage{Type: "model", Event: map[string]interface{}{"type": "text-start", "id": "0"}})
+	}
+	state.emittedText.WriteString(text)
+	onMessage(upstream.SSEMessage{Type: "model", Event: map[string]interface{}{"type": "text-delta", "id": "0", "delta": text}})
+}
+
+var sseDataPrefix = []byte("data: ")
+
+// sseLineReader yields SSE lines straight out of the bufio buffer. Lines longer
+// than the buffer are stitched together in a pooled overflow buffer, so only
+// oversized events (large tool inputs) cost an extra copy.
+type sseLineReader struct {
+	r        *bufio.Reader
+	overflow *bytes.Buffer
+}
+
+func newSSELineReader(r *bufio.Reader) *sseLineReader {
+	return &sseLineReader{r: r}
+}
+
+// next returns the next line without its trailing "\n" / "\r\n". The slice is
+// only valid until the following call.
+func (s *sseLineReader) next() ([]byte, error) {
+	line, err := s.r.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) {
+		if s.overflow == nil {
+			s.overflow = perf.AcquireLargeByteBuffer()
+		}
+		s.overflow.Reset()
+		s.overflow.Write(line)
+		for errors.Is(err, bufio.ErrBufferFull) {
+			line, err = s.r.ReadSlice('\n')
+			s.overflow.Write(line)
+		}
+		line = s.overflow.Bytes()
+	}
+	if err != nil {
+		return nil, err
+	}
+	line = bytes.TrimSuffix(line, []byte{'\n'})
+	return bytes.TrimSuffix(line, []byte{'\r'}), nil
+}
+
+func (s *sseLineReader) release() {
+	if s.overflow != nil {
+		perf.ReleaseLargeByteBuffer(s.overflow)
+		s.overflow = nil
+	}
+}
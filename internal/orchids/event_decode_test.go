@@ -0,0 +1,70 @@
+package orchids
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"testing"
+
+	"orchids-api/internal/upstream"
+)
+
+func TestSSELineReaderLongAndCRLFLines(t *testing.T) {
+	long := strings.Repeat("x", 100)
+	input := "data: a\r\n\r\ndata: " + long + "\n"
+	lines := newSSELineReader(bufio.NewReaderSize(strings.NewReader(input), 16))
+	defer lines.release()
+
+	want := []string{"data: a", "", "data: " + long}
+	for i, w := range want {
+		got, err := lines.next()
+		if err != nil {
+			t.Fatalf("line %d: %v", i, err)
+		}
+		if string(got) != w {
+			t.Fatalf("line %d = %q, want %q", i, got, w)
+		}
+	}
+	if _, err := lines.next(); err != io.EOF {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+}
+
+func TestHandleOrchidsDataTypedAndFallback(t *testing.T) {
+	c := &Client{}
+	var state requestState
+	var events []map[string]interface{}
+	onMessage := func(msg upstream.SSEMessage) {
+		events = append(events, msg.Event)
+	}
+
+	frames := []string{
+		`{"type":"output_text_delta","delta":"Hel"}`,
+		`{"type":"coding_agent.response.chunk","chunk":{"text":"lo"}}`,
+		`{"type":"coding_agent.response.chunk","delta":"lo"}`,
+		`not json`,
+	}
+	for i, frame := range frames {
+		decoded, shouldBreak := c.handleOrchidsData([]byte(frame), &state, onMessage, nil, nil, nil, "")
+		if shouldBreak {
+			t.Fatalf("frame %d should not stop the stream", i)
+		}
+		if wantDecoded := i < 3; decoded != wantDecoded {
+			t.Fatalf("frame %d decoded = %v, want %v", i, decoded, wantDecoded)
+		}
+	}
+
+	var deltas []string
+	for _, ev := range events {
+		if ev["type"] == "text-delta" {
+			deltas = append(deltas, ev["delta"].(string))
+		}
+	}
+	// The third frame repeats the second chunk on the same channel and is kept.
+	if got := strings.Join(deltas, "|"); got != "Hel|lo|lo" {
+		t.Fatalf("text deltas = %q", got)
+	}
+	if events[0]["type"] != "text-start" || state.emittedText.String() != "Hellolo" {
+		t.Fatalf("unexpected state: events=%v emitted=%q", events, state.emittedText.String())
+	}
+}
@@ -0,0 +1,119 @@
+package orchids
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// projectCreateBackoff stops the pool from hammering the upstream after a
+// failed project creation.
+const projectCreateBackoff = time.Minute
+
+// ProjectCreator creates a fresh upstream project and returns its ID.
+//
+// Client does not implement it yet: no project creation endpoint of the
+// Orchids upstream has been observed, and guessing one would POST with the
+// account's token to paths that may create unrelated resources. Until the
+// endpoint is known the pool never fills and every request keeps the
+// account's own project.
+type ProjectCreator interface {
+	CreateProject(ctx context.Context) (string, error)
+}
+
+type pooledProject struct {
+	ID       string
+	Requests int
+}
+
+type accountProjects struct {
+	projects    []*pooledProject
+	creating    bool
+	backoffTill time.Time
+}
+
+// ProjectPool keeps a small set of upstream projects per account and spreads
+// requests across them. A project is retired once it has served maxRequests
+// requests, and a replacement is created in the background.
+type ProjectPool struct {
+	mu       sync.Mutex
+	accounts map[int64]*accountProjects
+}
+
+func NewProjectPool() *ProjectPool {
+	return &ProjectPool{accounts: make(map[int64]*accountProjects)}
+}
+
+// Acquire returns the project to use for the next request on accountID and
+// counts the request against it. It returns "" while the pool is still empty,
+// in which case the caller keeps the account's own project.
+func (p *ProjectPool) Acquire(accountID int64, creator ProjectCreator, size, maxRequests int) string {
+	if p == nil || creator == nil || size <= 0 {
+		return ""
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	entry := p.accounts[accountID]
+	if entry == nil {
+		entry = &accountProjects{}
+		p.accounts[accountID] = entry
+	}
+
+	var chosen *pooledProject
+	kept := entry.projects[:0]
+	for _, proj := range entry.projects {
+		if maxRequests > 0 && proj.Requests >= maxRequests {
+			slog.Info("Retiring Orchids project", "account_id", accountID, "project_id", proj.ID, "requests", proj.Requests)
+			continue
+		}
+		kept = append(kept, proj)
+		if chosen == nil || proj.Requests < chosen.Requests {
+			chosen = proj
+		}
+	}
+	entry.projects = kept
+
+	if len(entry.projects) < size && !entry.creating && time.Now().After(entry.backoffTill) {
+		entry.creating = true
+		go p.create(accountID, creator)
+	}
+
+	if chosen == nil {
+		return ""
+	}
+	chosen.Requests++
+	return chosen.ID
+}
+
+// Forget drops the projects pooled for accountID, once the account is deleted.
+func (p *ProjectPool) Forget(accountID int64) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	delete(p.accounts, accountID)
+	p.mu.Unlock()
+}
+
+func (p *ProjectPool) create(accountID int64, creator ProjectCreator) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	id, err := creator.CreateProject(ctx)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	entry := p.accounts[accountID]
+	if entry == nil {
+		return
+	}
+	entry.creating = false
+	if err != nil {
+		entry.backoffTill = time.Now().Add(projectCreateBackoff)
+		slog.Warn("Failed to create Orchids project", "account_id", accountID, "error", err)
+		return
+	}
+	entry.projects = append(entry.projects, &pooledProject{ID: id})
+	slog.Info("Created Orchids project", "account_id", accountID, "project_id", id)
+}
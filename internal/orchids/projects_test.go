@@ -0,0 +1,115 @@
+package orchids
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeProjectCreator struct {
+	mu    sync.Mutex
+	calls int
+	err   error
+}
+
+func (f *fakeProjectCreator) CreateProject(ctx context.Context) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	if f.err != nil {
+		return "", f.err
+	}
+	return fmt.Sprintf("proj-%d", f.calls), nil
+}
+
+func waitForProjects(t *testing.T, p *ProjectPool, accountID int64, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		p.mu.Lock()
+		entry := p.accounts[accountID]
+		count := 0
+		creating := false
+		if entry != nil {
+			count = len(entry.projects)
+			creating = entry.creating
+		}
+		p.mu.Unlock()
+		if count >= n && !creating {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("pool for account %d did not reach %d projects", accountID, n)
+}
+
+func TestProjectPoolCreatesAndRecycles(t *testing.T) {
+	pool := NewProjectPool()
+	creator := &fakeProjectCreator{}
+
+	if got := pool.Acquire(1, creator, 1, 2); got != "" {
+		t.Fatalf("empty pool should fall back to account project, got %q", got)
+	}
+	waitForProjects(t, pool, 1, 1)
+
+	if got := pool.Acquire(1, creator, 1, 2); got != "proj-1" {
+		t.Fatalf("Acquire = %q, want proj-1", got)
+	}
+	if got := pool.Acquire(1, creator, 1, 2); got != "proj-1" {
+		t.Fatalf("Acquire = %q, want proj-1", got)
+	}
+	// proj-1 has now served maxRequests and must be retired.
+	if got := pool.Acquire(1, creator, 1, 2); got != "" {
+		t.Fatalf("retired project reused: %q", got)
+	}
+	waitForProjects(t, pool, 1, 1)
+	if got := pool.Acquire(1, creator, 1, 2); got != "proj-2" {
+		t.Fatalf("Acquire = %q, want proj-2", got)
+	}
+}
+
+func TestProjectPoolDisabledAndBackoff(t *testing.T) {
+	pool := NewProjectPool()
+	creator := &fakeProjectCreator{err: errors.New("boom")}
+
+	if got := pool.Acquire(1, creator, 0, 10); got != "" {
+		t.Fatalf("disabled pool returned %q", got)
+	}
+	pool.Acquire(1, creator, 1, 10)
+	deadline := time.Now().Add(time.Second)
+	for {
+		pool.mu.Lock()
+		done := !pool.accounts[1].creating
+		pool.mu.Unlock()
+		if done || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	pool.Acquire(1, creator, 1, 10)
+	time.Sleep(20 * time.Millisecond)
+	creator.mu.Lock()
+	calls := creator.calls
+	creator.mu.Unlock()
+	if calls != 1 {
+		t.Fatalf("expected creation to back off after failure, got %d calls", calls)
+	}
+}
+
+func TestProjectPoolForget(t *testing.T) {
+	pool := NewProjectPool()
+	creator := &fakeProjectCreator{}
+	pool.Acquire(1, creator, 1, 0)
+	waitForProjects(t, pool, 1, 1)
+
+	pool.Forget(1)
+	pool.mu.Lock()
+	_, ok := pool.accounts[1]
+	pool.mu.Unlock()
+	if ok {
+		t.Fatal("Forget kept the account's projects")
+	}
+}
@@ -0,0 +1,88 @@
+package orchids
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"time"
+
+	"github.com/goccy/go-json"
+
+	"orchids-api/internal/perf"
+	"orchids-api/internal/tiktoken"
+)
+
+const (
+	segmentCacheTTL        = 10 * time.Minute
+	segmentCacheMaxEntries = 256
+)
+
+// Clients resend the same system prompt and tool schemas on every turn, so
+// the sections derived from them (condensed system context, compacted tool
+// definitions) are memoized by content hash and only the conversation part is
+// rebuilt per request.
+var (
+	systemSegmentCache = perf.NewTTLCache(segmentCacheTTL, segmentCacheMaxEntries)
+	toolSegmentCache   = perf.NewTTLCache(segmentCacheTTL, segmentCacheMaxEntries)
+)
+
+func segmentKey(parts ...string) string {
+	h := sha256.New()
+	for _, p := range parts {
+		h.Write([]byte(p))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// renderSystemSegment returns the condensed, budget-trimmed system context
+// placed inside <sys>, or "" when nothing survives condensing.
+func renderSystemSegment(systemText string, maxTokens int) string {
+	key := segmentKey(systemText, strconv.Itoa(maxTokens))
+	if v, _, ok := systemSegmentCache.Get(key); ok {
+		return v.(string)
+	}
+	out := ""
+	if condensed := condenseSystemContext(systemText); condensed != "" {
+		out = trimSystemContextToBudget(condensed, maxTokens)
+	}
+	systemSegmentCache.Set(key, out)
+	return out
+}
+
+type compactedTools struct {
+	tools  []interface{}
+	tokens int
+}
+
+// cachedCompactTools returns compactIncomingTools(tools) together with its
+// token estimate. The returned slice is shared between requests and must be
+// treated as read-only.
+func cachedCompactTools(tools []interface{}) compactedTools {
+	if len(tools) == 0 {
+		return compactedTools{}
+	}
+	raw, err := json.Marshal(tools)
+	if err != nil {
+		return buildCompactedTools(tools)
+	}
+	key := segmentKey(string(raw))
+	if v, _, ok := toolSegmentCache.Get(key); ok {
+		return v.(compactedTools)
+	}
+	out := buildCompactedTools(tools)
+	toolSegmentCache.Set(key, out)
+	return out
+}
+
+func buildCompactedTools(tools []interface{}) compactedTools {
+	compacted := compactIncomingTools(tools)
+	if len(compacted) == 0 {
+		return compactedTools{}
+	}
+	out := compactedTools{tools: compacted}
+	if raw, err := json.Marshal(compacted); err == nil {
+		out.tokens = tiktoken.EstimateTextTokens(string(raw))
+	}
+	return out
+}
@@ -0,0 +1,55 @@
+package orchids
+
+import "testing"
+
+func TestRenderSystemSegmentMatchesUncached(t *testing.T) {
+	system := "You are a coding assistant.\n<env>\nWorking directory: /tmp/project\n</env>\n" +
+		"Always run tests before committing."
+	condensed := condenseSystemContext(system)
+	want := ""
+	if condensed != "" {
+		want = trimSystemContextToBudget(condensed, 12000)
+	}
+	for i := 0; i < 2; i++ {
+		if got := renderSystemSegment(system, 12000); got != want {
+			t.Fatalf("call %d: got %q, want %q", i, got, want)
+		}
+	}
+	if _, _, ok := systemSegmentCache.Get(segmentKey(system, "12000")); !ok {
+		t.Fatal("rendered system segment should be cached")
+	}
+}
+
+func TestCachedCompactToolsReusesResult(t *testing.T) {
+	tools := []interface{}{
+		map[string]interface{}{
+			"name":         "Read",
+			"description":  "Read a file from disk",
+			"input_schema": map[string]interface{}{"type": "object", "properties": map[string]interface{}{"path": map[string]interface{}{"type": "string"}}},
+		},
+	}
+	first := cachedCompactTools(tools)
+	if len(first.tools) != 1 || first.tokens <= 0 {
+		t.Fatalf("unexpected compacted tools: %+v", first)
+	}
+	if got := EstimateCompactedToolsTokens(tools); got != first.tokens {
+		t.Fatalf("token estimate = %d, want %d", got, first.tokens)
+	}
+
+	// A structurally identical tool list from a later request hits the cache.
+	again := []interface{}{
+		map[string]interface{}{
+			"input_schema": map[string]interface{}{"properties": map[string]interface{}{"path": map[string]interface{}{"type": "string"}}, "type": "object"},
+			"description":  "Read a file from disk",
+			"name":         "Read",
+		},
+	}
+	second := cachedCompactTools(again)
+	if &second.tools[0] != &first.tools[0] {
+		t.Fatal("expected cached compacted tools to be reused")
+	}
+
+	if out := cachedCompactTools(nil); out.tools != nil || out.tokens != 0 {
+		t.Fatalf("empty tools should compact to nothing, got %+v", out)
+	}
+}
@@ -0,0 +1,89 @@
+package orchids
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// A failed Clerk token fetch is remembered for a backoff that doubles with
+// each consecutive failure of the session, so requests in the meantime fail
+// fast instead of repeating a call that is bound to fail.
+const (
+	tokenBackoffBase = 5 * time.Second
+	tokenBackoffMax  = 5 * time.Minute
+)
+
+type tokenFailure struct {
+	failures int
+	retryAt  time.Time
+	err      error
+}
+
+var tokenFailures = struct {
+	mu       sync.Mutex
+	sessions map[string]*tokenFailure // by session ID
+	accounts map[int64]int            // consecutive failures by account ID
+}{
+	sessions: map[string]*tokenFailure{},
+	accounts: map[int64]int{},
+}
+
+// tokenFailureKey identifies a Clerk-backed client's session for backoff, or
+// returns "" for clients that don't fetch tokens from Clerk.
+func (c *Client) tokenFailureKey() string {
+	if c.account == nil || c.account.ClientCookie == "" || c.config.UpstreamToken != "" {
+		return ""
+	}
+	if c.config.SessionID != "" {
+		return c.config.SessionID
+	}
+	return "account:" + strconv.FormatInt(c.account.ID, 10)
+}
+
+// tokenBackoff returns the remembered error while the session is backing off.
+func tokenBackoff(key string, now time.Time) error {
+	tokenFailures.mu.Lock()
+	defer tokenFailures.mu.Unlock()
+	f, ok := tokenFailures.sessions[key]
+	if !ok || !now.Before(f.retryAt) {
+		return nil
+	}
+	return fmt.Errorf("token fetch backing off until %s after %d failures: %w", f.retryAt.Format(time.RFC3339), f.failures, f.err)
+}
+
+func recordTokenFailure(key string, accountID int64, err error, now time.Time) {
+	tokenFailures.mu.Lock()
+	defer tokenFailures.mu.Unlock()
+	f := tokenFailures.sessions[key]
+	if f == nil {
+		f = &tokenFailure{}
+		tokenFailures.sessions[key] = f
+	}
+	f.failures++
+	f.err = err
+	delay := tokenBackoffMax
+	if f.failures <= 7 {
+		delay = min(tokenBackoffBase<<(f.failures-1), tokenBackoffMax)
+	}
+	f.retryAt = now.Add(delay)
+	if accountID > 0 {
+		tokenFailures.accounts[accountID]++
+	}
+}
+
+func clearTokenFailure(key string, accountID int64) {
+	tokenFailures.mu.Lock()
+	delete(tokenFailures.sessions, key)
+	delete(tokenFailures.accounts, accountID)
+	tokenFailures.mu.Unlock()
+}
+
+// TokenFetchFailures returns how many token fetches of the account failed in
+// a row on this instance; a successful fetch resets it.
+func TokenFetchFailures(accountID int64) int {
+	tokenFailures.mu.Lock()
+	defer tokenFailures.mu.Unlock()
+	return tokenFailures.accounts[accountID]
+}
@@ -0,0 +1,53 @@
+package orchids
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"orchids-api/internal/store"
+)
+
+func TestTokenBackoff(t *testing.T) {
+	const key, accountID = "sess_backoff", int64(4242)
+	t.Cleanup(func() { clearTokenFailure(key, accountID) })
+
+	now := time.Now()
+	cause := errors.New("token request failed with status 401")
+	for i, want := range []time.Duration{5 * time.Second, 10 * time.Second, 20 * time.Second} {
+		recordTokenFailure(key, accountID, cause, now)
+		if err := tokenBackoff(key, now.Add(want-time.Millisecond)); !errors.Is(err, cause) {
+			t.Fatalf("failure %d: still within %v, got %v", i+1, want, err)
+		}
+		if err := tokenBackoff(key, now.Add(want)); err != nil {
+			t.Fatalf("failure %d: backoff should end after %v, got %v", i+1, want, err)
+		}
+	}
+	if got := TokenFetchFailures(accountID); got != 3 {
+		t.Fatalf("TokenFetchFailures = %d, want 3", got)
+	}
+	for i := 0; i < 10; i++ {
+		recordTokenFailure(key, accountID, cause, now)
+	}
+	if err := tokenBackoff(key, now.Add(tokenBackoffMax)); err != nil {
+		t.Fatalf("backoff should be capped at %v: %v", tokenBackoffMax, err)
+	}
+
+	// A Clerk-backed client fails fast while its session backs off.
+	recordTokenFailure(key, accountID, cause, time.Now())
+	c := NewFromAccount(&store.Account{ID: accountID, SessionID: key, ClientCookie: "cookie"}, nil)
+	if _, err := c.GetToken(); err == nil || !strings.Contains(err.Error(), "backing off") {
+		t.Fatalf("GetToken during backoff: %v", err)
+	}
+
+	clearTokenFailure(key, accountID)
+	if TokenFetchFailures(accountID) != 0 || tokenBackoff(key, now) != nil {
+		t.Fatal("success should reset the backoff and failure count")
+	}
+	recordTokenFailure(key, accountID, cause, now)
+	InvalidateCachedToken(key)
+	if tokenBackoff(key, now) != nil {
+		t.Fatal("InvalidateCachedToken should end the backoff")
+	}
+}
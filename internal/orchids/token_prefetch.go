@@ -0,0 +1,124 @@
+package orchids
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"orchids-api/internal/config"
+	"orchids-api/internal/metrics"
+)
+
+// Token fetch triggers, the trigger label of the token fetch metrics.
+const (
+	tokenFetchOnDemand     = "on_demand"     // a request waited for the token
+	tokenFetchRefreshAhead = "refresh_ahead" // fetched in the background by RefreshTokensAhead
+)
+
+const (
+	// prefetchIdle is how long after its last request a session keeps getting
+	// its token refreshed ahead of time.
+	prefetchIdle = 5 * time.Minute
+	// prefetchRemaining is the fraction of a cached token's lifetime left when
+	// it is refreshed.
+	prefetchRemaining = 0.2
+)
+
+// prefetchTarget is a Clerk session that served requests recently, with the
+// cookies and proxy settings needed to mint its tokens.
+type prefetchTarget struct {
+	cfg      config.Config
+	lastUsed time.Time
+}
+
+var prefetchTargets = struct {
+	mu    sync.Mutex
+	items map[string]*prefetchTarget // by session ID
+}{
+	items: map[string]*prefetchTarget{},
+}
+
+// notePrefetchTarget records that cfg's session just handed out a token.
+func notePrefetchTarget(cfg *config.Config) {
+	if cfg == nil || cfg.SessionID == "" || cfg.ClientCookie == "" {
+		return
+	}
+	prefetchTargets.mu.Lock()
+	prefetchTargets.items[cfg.SessionID] = &prefetchTarget{
+		cfg: config.Config{
+			SessionID:      cfg.SessionID,
+			ClientCookie:   cfg.ClientCookie,
+			SessionCookie:  cfg.SessionCookie,
+			ClientUat:      cfg.ClientUat,
+			RequestTimeout: cfg.RequestTimeout,
+			ProxyHTTP:      cfg.ProxyHTTP,
+			ProxyHTTPS:     cfg.ProxyHTTPS,
+			ProxyUser:      cfg.ProxyUser,
+			ProxyPass:      cfg.ProxyPass,
+			ProxyBypass:    cfg.ProxyBypass,
+		},
+		lastUsed: time.Now(),
+	}
+	prefetchTargets.mu.Unlock()
+}
+
+// tokenDue reports whether the cached token of a session is missing or has
+// less than prefetchRemaining of its lifetime left.
+func tokenDue(sessionID string, now time.Time) bool {
+	tokenCache.mu.RLock()
+	entry, ok := tokenCache.items[sessionID]
+	tokenCache.mu.RUnlock()
+	if !ok {
+		return true
+	}
+	lifetime := entry.expiresAt.Sub(entry.fetchedAt)
+	return entry.expiresAt.Sub(now) <= time.Duration(float64(lifetime)*prefetchRemaining)
+}
+
+// RefreshTokensAhead mints new tokens for the Clerk sessions used within the
+// last prefetchIdle whose cached token is missing or nearly expired, so the
+// next request doesn't wait on Clerk. A session whose fetch fails is dropped
+// until it serves a request again.
+func RefreshTokensAhead(ctx context.Context) error {
+	now := time.Now()
+	var due []config.Config
+	prefetchTargets.mu.Lock()
+	for sid, t := range prefetchTargets.items {
+		if now.Sub(t.lastUsed) > prefetchIdle {
+			delete(prefetchTargets.items, sid)
+			continue
+		}
+		if tokenDue(sid, now) {
+			due = append(due, t.cfg)
+		}
+	}
+	prefetchTargets.mu.Unlock()
+
+	for i := range due {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		cfg := &due[i]
+		c := &Client{config: cfg, httpClient: newHTTPClient(cfg)}
+		start := time.Now()
+		_, err := c.fetchToken()
+		observeTokenFetch(tokenFetchRefreshAhead, start, err == nil)
+		if err != nil {
+			slog.Warn("Orchids token refresh-ahead failed", "session_id", cfg.SessionID, "error", err)
+			prefetchTargets.mu.Lock()
+			delete(prefetchTargets.items, cfg.SessionID)
+			prefetchTargets.mu.Unlock()
+		}
+	}
+	return nil
+}
+
+func observeTokenFetch(trigger string, start time.Time, ok bool) {
+	metrics.TokenFetchDuration.WithLabelValues(trigger).Observe(time.Since(start).Seconds())
+	result := "ok"
+	if !ok {
+		result = "error"
+	}
+	metrics.TokenFetchesTotal.WithLabelValues(trigger, result).Inc()
+}
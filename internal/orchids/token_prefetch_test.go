@@ -0,0 +1,79 @@
+package orchids
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"orchids-api/internal/config"
+	"orchids-api/internal/metrics"
+)
+
+func TestRefreshTokensAhead(t *testing.T) {
+	var minted atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "/sess_broken/") {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		minted.Add(1)
+		w.Write([]byte(`{"jwt":"fresh-token"}`))
+	}))
+	defer srv.Close()
+	oldBase := clerkBaseURL
+	clerkBaseURL = srv.URL
+	t.Cleanup(func() {
+		clerkBaseURL = oldBase
+		for _, sid := range []string{"sess_due", "sess_ok", "sess_idle", "sess_broken"} {
+			InvalidateCachedToken(sid)
+			prefetchTargets.mu.Lock()
+			delete(prefetchTargets.items, sid)
+			prefetchTargets.mu.Unlock()
+		}
+	})
+
+	now := time.Now()
+	tokenCache.mu.Lock()
+	// 10% of a one-minute lifetime left: due.
+	tokenCache.items["sess_due"] = cachedToken{token: "old", fetchedAt: now.Add(-54 * time.Second), expiresAt: now.Add(6 * time.Second)}
+	// Half of it left: not yet.
+	tokenCache.items["sess_ok"] = cachedToken{token: "old", fetchedAt: now.Add(-30 * time.Second), expiresAt: now.Add(30 * time.Second)}
+	tokenCache.mu.Unlock()
+	for _, sid := range []string{"sess_due", "sess_ok", "sess_idle", "sess_broken"} {
+		notePrefetchTarget(&config.Config{SessionID: sid, ClientCookie: "cookie"})
+	}
+	prefetchTargets.mu.Lock()
+	prefetchTargets.items["sess_idle"].lastUsed = now.Add(-2 * prefetchIdle)
+	prefetchTargets.mu.Unlock()
+
+	failures := testutil.ToFloat64(metrics.TokenFetchesTotal.WithLabelValues(tokenFetchRefreshAhead, "error"))
+	if err := RefreshTokensAhead(context.Background()); err != nil {
+		t.Fatalf("RefreshTokensAhead: %v", err)
+	}
+
+	if got := minted.Load(); got != 1 {
+		t.Fatalf("minted %d tokens, want 1 (sess_due only)", got)
+	}
+	if tok, ok := getCachedToken("sess_due"); !ok || tok != "fresh-token" {
+		t.Fatalf("sess_due token = %q, %v", tok, ok)
+	}
+	if tok, _ := getCachedToken("sess_ok"); tok != "old" {
+		t.Fatalf("sess_ok token refreshed early: %q", tok)
+	}
+	prefetchTargets.mu.Lock()
+	_, idle := prefetchTargets.items["sess_idle"]
+	_, broken := prefetchTargets.items["sess_broken"]
+	prefetchTargets.mu.Unlock()
+	if idle || broken {
+		t.Fatalf("idle (%v) and failed (%v) sessions should be dropped", idle, broken)
+	}
+	if got := testutil.ToFloat64(metrics.TokenFetchesTotal.WithLabelValues(tokenFetchRefreshAhead, "error")) - failures; got != 1 {
+		t.Fatalf("recorded %v refresh-ahead failures, want 1", got)
+	}
+}
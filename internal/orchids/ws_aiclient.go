@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -86,6 +87,7 @@ type requestState struct {
 	suppressStarts    bool
 	activeWrites      map[string]*fileWriterState
 	errorMsg          string
+	emittedText       strings.Builder // text-delta content sent so far, replayed on resume
 }
 
 type fileWriterState struct {
@@ -102,6 +104,54 @@ func (c *Client) sendRequestWSAIClient(ctx context.Context, req upstream.Upstrea
 	}
 	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
+
+	origPayload, err := c.buildWSRequestAIClient(req)
+	if err != nil {
+		return err
+	}
+
+	// state is shared across reconnects so a resumed stream continues the
+	// same text block instead of opening a new one.
+	var state requestState
+	wsPayload := origPayload
+	for attempt := 0; ; attempt++ {
+		err := c.sendWSAttemptAIClient(ctx, parentCtx, wsPayload, req.Workdir, &state, onMessage, logger)
+		if attempt > 0 && isWSFallback(err) {
+			// Falling back to SSE now would replay the whole request on top of
+			// the text already sent, so a failed reconnect counts as another drop.
+			err = wsDroppedError{err: err}
+		}
+		var dropped wsDroppedError
+		if !errors.As(err, &dropped) {
+			return err
+		}
+		if attempt >= orchidsWSMaxResumes || !canResumeWS(&state) || ctx.Err() != nil {
+			return err
+		}
+
+		delay := wsReconnectBackoff(attempt)
+		slog.Warn("Orchids WS dropped mid-stream, reconnecting", "attempt", attempt+1, "delay", delay, "emitted_chars", state.emittedText.Len(), "error", dropped.err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		wsPayload = buildWSResumeRequest(origPayload, state.emittedText.String())
+	}
+}
+
+// sendWSAttemptAIClient sends wsPayload over one connection and streams the
+// reply. A socket failure after the first upstream message is reported as
+// wsDroppedError so the caller can reconnect and resume.
+func (c *Client) sendWSAttemptAIClient(
+	ctx context.Context,
+	parentCtx context.Context,
+	wsPayload *orchidsWSRequest,
+	workdir string,
+	state *requestState,
+	onMessage func(upstream.SSEMessage),
+	logger *debug.Logger,
+) error {
 	startPool := time.Now()
 
 	proxyFunc := http.ProxyFromEnvironment
@@ -131,6 +181,8 @@ func (c *Client) sendRequestWSAIClient(ctx context.Context, req upstream.Upstrea
 				"User-Agent": []string{orchidsWSUserAgent},
 				"Origin":     []string{orchidsWSOrigin},
 			}
+			upstream.ApplyAccountHeaders(headers, c.account)
+			upstream.ApplyTraceHeaders(ctx, headers)
 			dialer := websocket.Dialer{
 				HandshakeTimeout: orchidsWSConnectTimeout,
 				Proxy:            proxyFunc,
@@ -174,6 +226,8 @@ func (c *Client) sendRequestWSAIClient(ctx context.Context, req upstream.Upstrea
 			"User-Agent": []string{orchidsWSUserAgent},
 			"Origin":     []string{orchidsWSOrigin},
 		}
+		upstream.ApplyAccountHeaders(headers, c.account)
+		upstream.ApplyTraceHeaders(ctx, headers)
 		dialer := websocket.Dialer{
 			HandshakeTimeout: orchidsWSConnectTimeout,
 			Proxy:            proxyFunc,
@@ -194,20 +248,19 @@ func (c *Client) sendRequestWSAIClient(ctx context.Context, req upstream.Upstrea
 
 	startWrite := time.Now()
 
-	wsPayload, err := c.buildWSRequestAIClient(req)
-	if err != nil {
-		returnToPool = false
-		return err
-	}
-
 	// Note: Logger disabled for pooled connections
 	// if logger != nil {
 	// 	logger.LogUpstreamRequest(wsURL, logHeaders, wsPayload)
 	// }
 
 	// Lock to prevent race with ping loop which starts shortly after
+	payloadBytes, err := json.Marshal(wsPayload)
+	if err != nil {
+		return err
+	}
+	upstream.MeterSent(ctx, len(payloadBytes))
 	c.wsWriteMu.Lock()
-	writeErr := conn.WriteJSON(wsPayload)
+	writeErr := conn.WriteMessage(websocket.TextMessage, payloadBytes)
 	c.wsWriteMu.Unlock()
 
 	if writeErr != nil {
@@ -220,16 +273,25 @@ func (c *Client) sendRequestWSAIClient(ctx context.Context, req upstream.Upstrea
 	}
 
 	if c.config.DebugEnabled {
-		slog.Info("[Performance] WS WriteJSON completed", "duration", time.Since(startWrite))
+		slog.Info("[Performance] WS write completed", "duration", time.Since(startWrite))
 	}
 
 	startFirstToken := time.Now()
 	firstReceived := false
 	receivedAnyMessage := false
 
-	var state requestState
 	var fsWG sync.WaitGroup
 
+	// lastSeen is bumped by pongs and by every inbound message. A connection
+	// that stays silent past orchidsWSPongTimeout is closed, which unblocks
+	// the reader below and triggers a reconnect.
+	var lastSeen atomic.Int64
+	lastSeen.Store(time.Now().UnixNano())
+	conn.SetPongHandler(func(string) error {
+		lastSeen.Store(time.Now().UnixNano())
+		return nil
+	})
+
 	// Start Keep-Alive Ping Loop
 	go func() {
 		ticker := time.NewTicker(orchidsWSPingInterval)
@@ -241,6 +303,11 @@ func (c *Client) sendRequestWSAIClient(ctx context.Context, req upstream.Upstrea
 			case <-pingDone:
 				return
 			case <-ticker.C:
+				if silent := time.Since(time.Unix(0, lastSeen.Load())); silent > orchidsWSPongTimeout {
+					slog.Warn("Orchids WS heartbeat lost, closing connection", "silent", silent)
+					_ = conn.Close()
+					return
+				}
 				c.wsWriteMu.Lock()
 				err := conn.WriteControl(websocket.PingMessage, []byte{}, time.Now().Add(10*time.Second))
 				c.wsWriteMu.Unlock()
@@ -296,11 +363,19 @@ func (c *Client) sendRequestWSAIClient(ctx context.Context, req upstream.Upstrea
 				return wsFallbackError{err: err}
 			}
 			returnToPool = false
+			if !state.finishSent {
+				return wsDroppedError{err: err}
+			}
 			break
 		}
+		lastSeen.Store(time.Now().UnixNano())
+		if err := upstream.MeterReceived(ctx, len(data)); err != nil {
+			returnToPool = false
+			return err
+		}
 
-		var msg map[string]interface{}
-		if err := json.Unmarshal(data, &msg); err != nil {
+		decoded, shouldBreak := c.handleOrchidsData(data, state, onMessage, logger, conn, &fsWG, workdir)
+		if !decoded {
 			continue
 		}
 		receivedAnyMessage = true
@@ -310,7 +385,6 @@ func (c *Client) sendRequestWSAIClient(ctx context.Context, req upstream.Upstrea
 			slog.Info("[Performance] WS First response received (TTFT)", "duration", time.Since(startFirstToken))
 		}
 
-		shouldBreak := c.handleOrchidsMessage(msg, data, &state, onMessage, logger, conn, &fsWG, req.Workdir)
 		if shouldBreak {
 			break
 		}
@@ -415,16 +489,7 @@ func (c *Client) handleOrchidsMessage(
 		return false
 
 	case EventReasoningChunk:
-		state.preferCodingAgent = true
-		text := extractOrchidsText(msg)
-		if text == "" {
-			return false
-		}
-		if !state.reasoningStarted {
-			state.reasoningStarted = true
-			onMessage(upstream.SSEMessage{Type: "model", Event: map[string]interface{}{"type": "reasoning-start", "id": "0"}})
-		}
-		onMessage(upstream.SSEMessage{Type: "model", Event: map[string]interface{}{"type": "reasoning-delta", "id": "0", "delta": text}})
+		handleReasoningChunk(extractOrchidsText(msg), state, onMessage)
 		return false
 
 	case EventReasoningCompleted:
@@ -436,23 +501,7 @@ func (c *Client) handleOrchidsMessage(
 		return false
 
 	case EventOutputTextDelta, EventResponseChunk:
-		state.preferCodingAgent = true
-		text := extractOrchidsText(msg)
-		if text == "" {
-			return false
-		}
-		// Suppress only cross-channel duplicate chunks (e.g. output_text_delta + response.chunk).
-		// Repeated chunks from the same channel can be legitimate content and must be preserved.
-		if text == state.lastTextDelta && state.lastTextEvent != msgType {
-			return false
-		}
-		state.lastTextDelta = text
-		state.lastTextEvent = msgType
-		if !state.textStarted {
-			state.textStarted = true
-			onMessage(upstream.SSEMessage{Type: "model", Event: map[string]interface{}{"type": "text-start", "id": "0"}})
-		}
-		onMessage(upstream.SSEMessage{Type: "model", Event: map[string]interface{}{"type": "text-delta", "id": "0", "delta": text}})
+		handleTextChunk(msgType, extractOrchidsText(msg), state, onMessage)
 		return false
 
 	case EventWriteStart, EventWriteContentStart, EventEditStart:
@@ -782,6 +831,13 @@ func (c *Client) buildWSRequestAIClient(req upstream.UpstreamRequest) (*orchidsW
 	}
 
 	agentMode := normalizeAIClientModel(req.Model)
+	if v := strings.TrimSpace(req.AgentMode); v != "" && !strings.EqualFold(v, "auto") {
+		agentMode = v
+	}
+	var projectID interface{}
+	if v := strings.TrimSpace(req.ProjectID); v != "" {
+		projectID = v
+	}
 
 	chatSessionID := req.ChatSessionID
 	if chatSessionID == "" {
@@ -789,7 +845,7 @@ func (c *Client) buildWSRequestAIClient(req upstream.UpstreamRequest) (*orchidsW
 	}
 
 	payload := map[string]interface{}{
-		"projectId":      nil,
+		"projectId":      projectID,
 		"chatSessionId":  chatSessionID,
 		"prompt":         promptText,
 		"agentMode":      agentMode,
@@ -1100,27 +1156,22 @@ func extractAttachmentURLsAIClient(messages []prompt.Message) []string {
 }
 
 func formatMediaHint(block prompt.ContentBlock) string {
+	if block.Type == "image" {
+		return prompt.ImagePlaceholder(block)
+	}
 	sourceType := "unknown"
-	mediaType := "unknown"
 	sizeHint := ""
 	if block.Source != nil {
 		if strings.TrimSpace(block.Source.Type) != "" {
 			sourceType = block.Source.Type
 		}
-		if strings.TrimSpace(block.Source.MediaType) != "" {
-			mediaType = block.Source.MediaType
-		}
 		if block.Source.Data != "" {
 			approx := int(float64(len(block.Source.Data)) * 0.75)
 			sizeHint = fmt.Sprintf(" bytes≈%d", approx)
 		}
 	}
-	switch block.Type {
-	case "image":
-		return fmt.Sprintf("[Image %s %s%s]", mediaType, sourceType, sizeHint)
-	case "document":
+	if block.Type == "document" {
 		return fmt.Sprintf("[Document %s%s]", sourceType, sizeHint)
-	default:
-		return "[Document unknown]"
 	}
+	return "[Document unknown]"
 }
@@ -7,6 +7,7 @@ import (
 
 	"github.com/gorilla/websocket"
 
+	"orchids-api/internal/upstream"
 	"orchids-api/internal/util"
 )
 
@@ -30,6 +31,7 @@ func (c *Client) createWSConnection() (*websocket.Conn, error) {
 		"User-Agent": []string{"Mozilla/5.0"},
 		"Origin":     []string{"https://orchids.app"},
 	}
+	upstream.ApplyAccountHeaders(headers, c.account)
 
 	proxyFunc := http.ProxyFromEnvironment
 	if c.config != nil {
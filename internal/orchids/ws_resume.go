@@ -0,0 +1,79 @@
+package orchids
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	orchidsWSPongTimeout      = 3 * orchidsWSPingInterval
+	orchidsWSMaxResumes       = 3
+	orchidsWSReconnectBackoff = 500 * time.Millisecond
+	orchidsWSReconnectMaxWait = 8 * time.Second
+
+	orchidsWSResumePrompt = "The connection was interrupted while you were answering. " +
+		"Continue your previous reply exactly where it stopped. " +
+		"Do not repeat any text you already wrote and do not add a preamble."
+)
+
+// wsDroppedError means the socket failed after the upstream had started
+// streaming, so part of the response has already been emitted.
+type wsDroppedError struct {
+	err error
+}
+
+func (e wsDroppedError) Error() string {
+	return fmt.Sprintf("ws connection dropped mid-stream: %v", e.err)
+}
+
+func (e wsDroppedError) Unwrap() error {
+	return e.err
+}
+
+// wsReconnectBackoff returns the wait before reconnect attempt n (0-based):
+// 500ms, 1s, 2s, ... capped at orchidsWSReconnectMaxWait.
+func wsReconnectBackoff(attempt int) time.Duration {
+	delay := orchidsWSReconnectBackoff
+	for i := 0; i < attempt; i++ {
+		delay *= 2
+		if delay >= orchidsWSReconnectMaxWait {
+			return orchidsWSReconnectMaxWait
+		}
+	}
+	return delay
+}
+
+// canResumeWS reports whether an interrupted generation can be continued by
+// replaying it as history. Tool calls, file operations and open reasoning
+// blocks cannot be stitched back together, so those streams fail instead.
+func canResumeWS(state *requestState) bool {
+	return state != nil &&
+		!state.sawToolCall &&
+		!state.hasFSOps &&
+		!state.reasoningStarted &&
+		state.errorMsg == ""
+}
+
+// buildWSResumeRequest derives a continuation request from the original one:
+// the original prompt and the text emitted so far move into chatHistory and
+// the model is asked to carry on from there.
+func buildWSResumeRequest(orig *orchidsWSRequest, partial string) *orchidsWSRequest {
+	data := make(map[string]interface{}, len(orig.Data))
+	for k, v := range orig.Data {
+		data[k] = v
+	}
+
+	prevHistory, _ := orig.Data["chatHistory"].([]map[string]string)
+	history := make([]map[string]string, 0, len(prevHistory)+2)
+	history = append(history, prevHistory...)
+	if prompt, _ := orig.Data["prompt"].(string); prompt != "" {
+		history = append(history, map[string]string{"role": "user", "content": prompt})
+	}
+	if partial != "" {
+		history = append(history, map[string]string{"role": "assistant", "content": partial})
+	}
+	data["chatHistory"] = history
+	data["prompt"] = orchidsWSResumePrompt
+
+	return &orchidsWSRequest{Type: orig.Type, Data: data}
+}
@@ -0,0 +1,87 @@
+package orchids
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWSReconnectBackoff(t *testing.T) {
+	want := []time.Duration{
+		500 * time.Millisecond,
+		time.Second,
+		2 * time.Second,
+		4 * time.Second,
+		8 * time.Second,
+		8 * time.Second,
+	}
+	for attempt, expected := range want {
+		if got := wsReconnectBackoff(attempt); got != expected {
+			t.Fatalf("attempt %d: expected %v, got %v", attempt, expected, got)
+		}
+	}
+}
+
+func TestCanResumeWS(t *testing.T) {
+	if !canResumeWS(&requestState{textStarted: true}) {
+		t.Fatal("plain text stream should be resumable")
+	}
+	for name, state := range map[string]*requestState{
+		"tool call": {sawToolCall: true},
+		"fs ops":    {hasFSOps: true},
+		"reasoning": {reasoningStarted: true},
+		"error":     {errorMsg: "boom"},
+	} {
+		if canResumeWS(state) {
+			t.Fatalf("%s: expected not resumable", name)
+		}
+	}
+}
+
+func TestBuildWSResumeRequest(t *testing.T) {
+	orig := &orchidsWSRequest{
+		Type: "user_request",
+		Data: map[string]interface{}{
+			"prompt":        "write a poem",
+			"chatSessionId": "chat_1",
+			"chatHistory": []map[string]string{
+				{"role": "user", "content": "hi"},
+				{"role": "assistant", "content": "hello"},
+			},
+		},
+	}
+
+	resumed := buildWSResumeRequest(orig, "Roses are red")
+	if resumed.Type != "user_request" || resumed.Data["chatSessionId"] != "chat_1" {
+		t.Fatalf("expected request metadata to be kept, got %+v", resumed)
+	}
+	if resumed.Data["prompt"] != orchidsWSResumePrompt {
+		t.Fatalf("expected resume prompt, got %v", resumed.Data["prompt"])
+	}
+	history := resumed.Data["chatHistory"].([]map[string]string)
+	if len(history) != 4 {
+		t.Fatalf("expected 4 history entries, got %d", len(history))
+	}
+	if history[2]["role"] != "user" || history[2]["content"] != "write a poem" {
+		t.Fatalf("expected original prompt in history, got %v", history[2])
+	}
+	if history[3]["role"] != "assistant" || history[3]["content"] != "Roses are red" {
+		t.Fatalf("expected partial reply in history, got %v", history[3])
+	}
+
+	// The original request must stay untouched for later attempts.
+	if orig.Data["prompt"] != "write a poem" || len(orig.Data["chatHistory"].([]map[string]string)) != 2 {
+		t.Fatal("original request was modified")
+	}
+}
+
+func TestWSDroppedErrorUnwrap(t *testing.T) {
+	base := errors.New("connection reset")
+	err := error(wsDroppedError{err: base})
+	if !errors.Is(err, base) {
+		t.Fatal("expected wsDroppedError to unwrap to the read error")
+	}
+	if isWSFallback(err) {
+		t.Fatal("a mid-stream drop must not trigger the SSE fallback")
+	}
+}
@@ -13,7 +13,6 @@ import (
 
 	"orchids-api/internal/clerk"
 	"orchids-api/internal/prompt"
-	"orchids-api/internal/tiktoken"
 	"orchids-api/internal/util"
 )
 
@@ -145,10 +144,9 @@ func buildLocalAssistantPromptWithProfile(systemText string, userText string, mo
 	b.WriteString("</rules>\n\n")
 
 	if strings.TrimSpace(systemText) != "" {
-		condensed := condenseSystemContext(systemText)
-		if condensed != "" {
+		if sys := renderSystemSegment(systemText, maxTokens); sys != "" {
 			b.WriteString("<sys>\n")
-			b.WriteString(trimSystemContextToBudget(condensed, maxTokens))
+			b.WriteString(sys)
 			b.WriteString("\n</sys>\n\n")
 		}
 	}
@@ -704,18 +702,7 @@ func compactIncomingTools(tools []interface{}) []interface{} {
 }
 
 func EstimateCompactedToolsTokens(tools []interface{}) int {
-	if len(tools) == 0 {
-		return 0
-	}
-	compacted := compactIncomingTools(tools)
-	if len(compacted) == 0 {
-		return 0
-	}
-	raw, err := json.Marshal(compacted)
-	if err != nil {
-		return 0
-	}
-	return tiktoken.EstimateTextTokens(string(raw))
+	return cachedCompactTools(tools).tokens
 }
 
 func compactToolDescription(description string) string {
@@ -1017,6 +1004,7 @@ func formatToolResultContentLocal(content interface{}) string {
 	case string:
 		return strings.TrimSpace(v)
 	case []interface{}:
+		v, _ = prompt.StripToolResultImages(v)
 		var parts []string
 		for _, item := range v {
 			if itemMap, ok := item.(map[string]interface{}); ok {
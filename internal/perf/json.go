@@ -0,0 +1,52 @@
+package perf
+
+import (
+	"bytes"
+	"sync"
+
+	"github.com/goccy/go-json"
+)
+
+// maxPooledEncoderBuffer keeps one oversized event (e.g. a huge tool input)
+// from pinning its buffer in the pool forever.
+const maxPooledEncoderBuffer = 1 << 20
+
+// JSONEncoder pairs a reusable buffer with an encoder writing into it, so hot
+// paths can serialize events without allocating a fresh output slice each time.
+type JSONEncoder struct {
+	buf *bytes.Buffer
+	enc *json.Encoder
+}
+
+// JSONEncoderPool provides reusable JSONEncoder instances.
+var JSONEncoderPool = sync.Pool{
+	New: func() interface{} {
+		buf := bytes.NewBuffer(make([]byte, 0, 1024))
+		return &JSONEncoder{buf: buf, enc: json.NewEncoder(buf)}
+	},
+}
+
+// AcquireJSONEncoder gets a JSONEncoder from the pool.
+func AcquireJSONEncoder() *JSONEncoder {
+	return JSONEncoderPool.Get().(*JSONEncoder)
+}
+
+// ReleaseJSONEncoder returns a JSONEncoder to the pool. Bytes previously
+// returned by Marshal must not be used afterwards.
+func ReleaseJSONEncoder(e *JSONEncoder) {
+	if e == nil || e.buf.Cap() > maxPooledEncoderBuffer {
+		return
+	}
+	e.buf.Reset()
+	JSONEncoderPool.Put(e)
+}
+
+// Marshal encodes v like json.Marshal. The returned slice aliases the
+// encoder's buffer and is only valid until the next Marshal or Release.
+func (e *JSONEncoder) Marshal(v interface{}) ([]byte, error) {
+	e.buf.Reset()
+	if err := e.enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(e.buf.Bytes(), []byte{'\n'}), nil
+}
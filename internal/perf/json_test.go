@@ -0,0 +1,60 @@
+package perf
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/goccy/go-json"
+)
+
+func TestJSONEncoderMatchesMarshal(t *testing.T) {
+	values := []interface{}{
+		map[string]interface{}{"type": "text_delta", "text": "a <b> & \"c\"\n"},
+		struct {
+			Index int    `json:"index"`
+			Text  string `json:"text"`
+		}{Index: 3, Text: "héllo"},
+		"plain",
+	}
+	enc := AcquireJSONEncoder()
+	defer ReleaseJSONEncoder(enc)
+	for _, v := range values {
+		want, err := json.Marshal(v)
+		if err != nil {
+			t.Fatalf("json.Marshal: %v", err)
+		}
+		got, err := enc.Marshal(v)
+		if err != nil {
+			t.Fatalf("Marshal: %v", err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("got %s, want %s", got, want)
+		}
+	}
+}
+
+func TestReleaseJSONEncoderDropsOversizedBuffer(t *testing.T) {
+	enc := AcquireJSONEncoder()
+	if _, err := enc.Marshal(string(bytes.Repeat([]byte("x"), maxPooledEncoderBuffer+1))); err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	ReleaseJSONEncoder(enc)
+	if enc.buf.Len() == 0 {
+		t.Fatal("oversized encoder should not be reset and pooled")
+	}
+}
+
+// BenchmarkJSONEncoderMarshal benchmarks pooled encoding of a text delta event.
+func BenchmarkJSONEncoderMarshal(b *testing.B) {
+	b.ReportAllocs()
+	event := struct {
+		Type  string `json:"type"`
+		Index int    `json:"index"`
+		Text  string `json:"text"`
+	}{Type: "content_block_delta", Index: 1, Text: "streaming text chunk"}
+	for i := 0; i < b.N; i++ {
+		enc := AcquireJSONEncoder()
+		_, _ = enc.Marshal(event)
+		ReleaseJSONEncoder(enc)
+	}
+}
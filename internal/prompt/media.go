@@ -0,0 +1,180 @@
+package prompt
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"strings"
+)
+
+// imageHeaderBase64Len is how much of a base64 image is decoded to read its
+// dimensions; headers (including JPEG EXIF segments) fit well within it.
+const imageHeaderBase64Len = 64 * 1024
+
+// ImagePlaceholder describes an image block in text for channels that cannot
+// take images: media type, source, dimensions and size when known, and the
+// block's title as alt text.
+func ImagePlaceholder(block ContentBlock) string {
+	sourceType, mediaType := "unknown", "unknown"
+	var details []string
+	if block.Source != nil {
+		if strings.TrimSpace(block.Source.Type) != "" {
+			sourceType = block.Source.Type
+		}
+		if strings.TrimSpace(block.Source.MediaType) != "" {
+			mediaType = block.Source.MediaType
+		}
+		if block.Source.Data != "" {
+			if w, h, ok := base64ImageSize(block.Source.Data); ok {
+				details = append(details, fmt.Sprintf("%dx%d", w, h))
+			}
+			details = append(details, fmt.Sprintf("bytes≈%d", len(block.Source.Data)*3/4))
+		}
+	}
+	if alt := strings.Join(strings.Fields(block.Title), " "); alt != "" {
+		details = append(details, fmt.Sprintf("alt=%q", alt))
+	}
+	if len(details) == 0 {
+		return fmt.Sprintf("[Image %s %s]", mediaType, sourceType)
+	}
+	return fmt.Sprintf("[Image %s %s %s]", mediaType, sourceType, strings.Join(details, " "))
+}
+
+// StripImages returns messages with every base64 image, including images
+// nested in tool_result content, replaced by a text placeholder, and how many
+// were replaced. URL images are kept. messages is not modified.
+func StripImages(messages []Message) ([]Message, int) {
+	var out []Message
+	stripped := 0
+	for i, msg := range messages {
+		blocks, n := stripImageBlocks(msg.Content.Blocks)
+		if n == 0 {
+			continue
+		}
+		if out == nil {
+			out = make([]Message, len(messages))
+			copy(out, messages)
+		}
+		out[i].Content.Blocks = blocks
+		stripped += n
+	}
+	if out == nil {
+		return messages, 0
+	}
+	return out, stripped
+}
+
+func stripImageBlocks(blocks []ContentBlock) ([]ContentBlock, int) {
+	var out []ContentBlock
+	stripped := 0
+	for i, block := range blocks {
+		replaced := block
+		n := 0
+		switch block.Type {
+		case "image":
+			if block.Source != nil && block.Source.Data != "" {
+				replaced = ContentBlock{Type: "text", Text: ImagePlaceholder(block)}
+				n = 1
+			}
+		case "tool_result":
+			if items, ok := block.Content.([]interface{}); ok {
+				if content, count := StripToolResultImages(items); count > 0 {
+					replaced.Content = content
+					n = count
+				}
+			}
+		}
+		if n == 0 {
+			continue
+		}
+		if out == nil {
+			out = make([]ContentBlock, len(blocks))
+			copy(out, blocks)
+		}
+		out[i] = replaced
+		stripped += n
+	}
+	if out == nil {
+		return blocks, 0
+	}
+	return out, stripped
+}
+
+// StripToolResultImages replaces base64 images in decoded tool_result
+// content with text placeholders. items is not modified.
+func StripToolResultImages(items []interface{}) ([]interface{}, int) {
+	var out []interface{}
+	stripped := 0
+	for i, item := range items {
+		m, ok := item.(map[string]interface{})
+		if !ok || m["type"] != "image" {
+			continue
+		}
+		src, _ := m["source"].(map[string]interface{})
+		data, _ := src["data"].(string)
+		if data == "" {
+			continue
+		}
+		block := ContentBlock{Type: "image", Source: &ImageSource{Data: data}}
+		block.Source.Type, _ = src["type"].(string)
+		block.Source.MediaType, _ = src["media_type"].(string)
+		block.Title, _ = m["title"].(string)
+		if out == nil {
+			out = make([]interface{}, len(items))
+			copy(out, items)
+		}
+		out[i] = map[string]interface{}{"type": "text", "text": ImagePlaceholder(block)}
+		stripped++
+	}
+	if out == nil {
+		return items, 0
+	}
+	return out, stripped
+}
+
+// base64ImageSize reads the dimensions of a base64 encoded PNG, JPEG, GIF or
+// WebP image from its header.
+func base64ImageSize(data string) (int, int, bool) {
+	if len(data) > imageHeaderBase64Len {
+		data = data[:imageHeaderBase64Len]
+	}
+	data = data[:len(data)/4*4]
+	raw, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return 0, 0, false
+	}
+	if w, h, ok := webpSize(raw); ok {
+		return w, h, true
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(raw))
+	if err != nil {
+		return 0, 0, false
+	}
+	return cfg.Width, cfg.Height, true
+}
+
+// webpSize parses the VP8, VP8L and VP8X headers of a WebP image.
+func webpSize(b []byte) (int, int, bool) {
+	if len(b) < 30 || string(b[0:4]) != "RIFF" || string(b[8:12]) != "WEBP" {
+		return 0, 0, false
+	}
+	switch string(b[12:16]) {
+	case "VP8X":
+		w := int(b[24]) | int(b[25])<<8 | int(b[26])<<16
+		h := int(b[27]) | int(b[28])<<8 | int(b[29])<<16
+		return w + 1, h + 1, true
+	case "VP8L":
+		bits := binary.LittleEndian.Uint32(b[21:25])
+		return int(bits&0x3fff) + 1, int(bits>>14&0x3fff) + 1, true
+	case "VP8 ":
+		w := binary.LittleEndian.Uint16(b[26:28]) & 0x3fff
+		h := binary.LittleEndian.Uint16(b[28:30]) & 0x3fff
+		return int(w), int(h), true
+	}
+	return 0, 0, false
+}
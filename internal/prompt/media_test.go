@@ -0,0 +1,82 @@
+package prompt
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func pngBase64(t *testing.T, w, h int) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, w, h))); err != nil {
+		t.Fatalf("encode png: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+func TestImagePlaceholder(t *testing.T) {
+	block := ContentBlock{
+		Type:   "image",
+		Title:  "login  page",
+		Source: &ImageSource{Type: "base64", MediaType: "image/png", Data: pngBase64(t, 320, 200)},
+	}
+	got := ImagePlaceholder(block)
+	if !strings.HasPrefix(got, "[Image image/png base64 320x200 bytes≈") || !strings.HasSuffix(got, `alt="login page"]`) {
+		t.Fatalf("unexpected placeholder: %s", got)
+	}
+
+	webp := make([]byte, 30)
+	copy(webp, "RIFF\x00\x00\x00\x00WEBPVP8X")
+	webp[24], webp[27] = 0x7f, 0x3f // 128x64
+	block.Source = &ImageSource{Type: "base64", MediaType: "image/webp", Data: base64.StdEncoding.EncodeToString(webp)}
+	block.Title = ""
+	if got := ImagePlaceholder(block); !strings.Contains(got, " 128x64 ") {
+		t.Fatalf("expected webp dimensions: %s", got)
+	}
+}
+
+func TestStripImages(t *testing.T) {
+	data := pngBase64(t, 16, 16)
+	messages := []Message{
+		{Role: "user", Content: MessageContent{Text: "plain"}},
+		{Role: "user", Content: MessageContent{Blocks: []ContentBlock{
+			{Type: "text", Text: "see"},
+			{Type: "image", Source: &ImageSource{Type: "base64", MediaType: "image/png", Data: data}},
+			{Type: "image", Source: &ImageSource{Type: "url", URL: "https://example.com/a.png"}},
+			{Type: "tool_result", ToolUseID: "t1", Content: []interface{}{
+				map[string]interface{}{"type": "text", "text": "screenshot"},
+				map[string]interface{}{"type": "image", "source": map[string]interface{}{"type": "base64", "media_type": "image/png", "data": data}},
+			}},
+		}}},
+	}
+
+	out, n := StripImages(messages)
+	if n != 2 {
+		t.Fatalf("stripped=%d want 2", n)
+	}
+	blocks := out[1].Content.Blocks
+	if blocks[1].Type != "text" || !strings.Contains(blocks[1].Text, "16x16") {
+		t.Fatalf("image block not replaced: %+v", blocks[1])
+	}
+	if blocks[2].Type != "image" {
+		t.Fatal("url images should be kept")
+	}
+	items := blocks[3].Content.([]interface{})
+	if item := items[1].(map[string]interface{}); item["type"] != "text" || !strings.HasPrefix(item["text"].(string), "[Image image/png") {
+		t.Fatalf("tool_result image not replaced: %v", item)
+	}
+	if messages[1].Content.Blocks[1].Type != "image" {
+		t.Fatal("input messages must not be modified")
+	}
+	if orig := messages[1].Content.Blocks[3].Content.([]interface{}); orig[1].(map[string]interface{})["type"] != "image" {
+		t.Fatal("input tool_result content must not be modified")
+	}
+
+	if _, n := StripImages(messages[:1]); n != 0 {
+		t.Fatalf("stripped=%d want 0", n)
+	}
+}
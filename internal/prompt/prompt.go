@@ -32,6 +32,7 @@ type ContentBlock struct {
 	Text   string       `json:"text,omitempty"`
 	Source *ImageSource `json:"source,omitempty"`
 	URL    string       `json:"url,omitempty"`
+	Title  string       `json:"title,omitempty"` // image/document 标题，文本渠道占位符中作为替代文字
 
 	// tool_use 字段
 	ID       string      `json:"id,omitempty"`
@@ -39,6 +40,10 @@ type ContentBlock struct {
 	Input    interface{} `json:"input,omitempty"`
 	Thinking string      `json:"thinking,omitempty"`
 
+	// thinking / redacted_thinking 的上游签名与加密内容
+	Signature string `json:"signature,omitempty"`
+	Data      string `json:"data,omitempty"`
+
 	// tool_result 字段
 	ToolUseID    string        `json:"tool_use_id,omitempty"`
 	Content      interface{}   `json:"content,omitempty"`
@@ -0,0 +1,17 @@
+package provider
+
+import (
+	"orchids-api/internal/anthropic"
+	"orchids-api/internal/config"
+	"orchids-api/internal/store"
+)
+
+type anthropicProvider struct{}
+
+func NewAnthropicProvider() Provider { return anthropicProvider{} }
+
+func (anthropicProvider) Name() string { return "anthropic" }
+
+func (anthropicProvider) NewClient(acc *store.Account, cfg *config.Config) interface{} {
+	return anthropic.NewFromAccount(acc, cfg)
+}
@@ -0,0 +1,17 @@
+package provider
+
+import (
+	"orchids-api/internal/config"
+	"orchids-api/internal/kiro"
+	"orchids-api/internal/store"
+)
+
+type kiroProvider struct{}
+
+func NewKiroProvider() Provider { return kiroProvider{} }
+
+func (kiroProvider) Name() string { return "kiro" }
+
+func (kiroProvider) NewClient(acc *store.Account, cfg *config.Config) interface{} {
+	return kiro.NewFromAccount(acc, cfg)
+}
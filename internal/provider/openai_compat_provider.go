@@ -0,0 +1,17 @@
+package provider
+
+import (
+	"orchids-api/internal/config"
+	"orchids-api/internal/openaicompat"
+	"orchids-api/internal/store"
+)
+
+type openAICompatProvider struct{}
+
+func NewOpenAICompatProvider() Provider { return openAICompatProvider{} }
+
+func (openAICompatProvider) Name() string { return "openai-compatible" }
+
+func (openAICompatProvider) NewClient(acc *store.Account, cfg *config.Config) interface{} {
+	return openaicompat.NewFromAccount(acc, cfg)
+}
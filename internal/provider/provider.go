@@ -1,4 +1,4 @@
-// Package provider defines a minimal registry for upstream providers (orchids, warp, grok).
+// Package provider defines a minimal registry for upstream providers (orchids, warp, kiro, grok).
 // It replaces hardcoded type-switch logic with a table-driven dispatch.
 package provider
 
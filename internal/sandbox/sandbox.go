@@ -0,0 +1,264 @@
+// Package sandbox runs untrusted Python or Node.js snippets in a Docker
+// container or a firejail sandbox with CPU, memory and time limits, for the
+// built-in code_execution server tool.
+package sandbox
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	RuntimeDocker   = "docker"
+	RuntimeFirejail = "firejail"
+
+	LanguagePython = "python"
+	LanguageNode   = "node"
+
+	defaultPythonImage = "python:3.12-slim"
+	defaultNodeImage   = "node:22-slim"
+	maxOutputBytes     = 64 << 10
+	maxProcesses       = 64
+	// firejailMaxProcesses caps RLIMIT_NPROC, which counts every process
+	// and thread of the user running the gateway, not just the snippet's.
+	firejailMaxProcesses = 512
+)
+
+// firejailBlacklist hides the host directories that hold credentials, data
+// and logs from firejail runs; the rest of the root file system is mounted
+// read-only. python3 and node must be installed under /usr.
+var firejailBlacklist = []string{"/root", "/home", "/var", "/srv", "/opt", "/mnt", "/media", "/run", "/boot"}
+
+// firejailEtc is all of /etc a firejail run sees.
+const firejailEtc = "alternatives,ld.so.cache,ld.so.conf,ld.so.conf.d,localtime,ssl,ca-certificates"
+
+var (
+	// ErrTimeout is returned when a snippet exceeds the time limit.
+	ErrTimeout = errors.New("sandbox: execution time exceeded")
+	// ErrUnsupportedLanguage is returned for languages other than python and node.
+	ErrUnsupportedLanguage = errors.New("sandbox: unsupported language")
+)
+
+// Options configures the sandbox.
+type Options struct {
+	Runtime     string
+	Timeout     time.Duration
+	MemoryMB    int
+	CPUs        float64
+	Network     bool
+	PythonImage string
+	NodeImage   string
+	// Blacklist lists more host paths to hide from firejail runs. New adds
+	// the working directory, where config.json and the logs live by default.
+	Blacklist []string
+}
+
+// Result is the outcome of one run.
+type Result struct {
+	Stdout     string `json:"stdout"`
+	Stderr     string `json:"stderr"`
+	ReturnCode int    `json:"return_code"`
+}
+
+// Runner executes snippets with the configured limits.
+type Runner struct {
+	opts Options
+	bin  string
+}
+
+// New returns a Runner for the configured runtime, or nil when Runtime is
+// empty.
+func New(opts Options) (*Runner, error) {
+	opts.Runtime = strings.ToLower(strings.TrimSpace(opts.Runtime))
+	switch opts.Runtime {
+	case "":
+		return nil, nil
+	case RuntimeDocker, RuntimeFirejail:
+	default:
+		return nil, fmt.Errorf("sandbox: unknown runtime %q", opts.Runtime)
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 30 * time.Second
+	}
+	if opts.MemoryMB <= 0 {
+		opts.MemoryMB = 256
+	}
+	if opts.CPUs <= 0 {
+		opts.CPUs = 1
+	}
+	if opts.PythonImage == "" {
+		opts.PythonImage = defaultPythonImage
+	}
+	if opts.NodeImage == "" {
+		opts.NodeImage = defaultNodeImage
+	}
+	if opts.Runtime == RuntimeFirejail {
+		if wd, err := os.Getwd(); err == nil {
+			opts.Blacklist = append(opts.Blacklist, wd)
+		}
+		blacklist := make([]string, 0, len(opts.Blacklist))
+		for _, path := range opts.Blacklist {
+			if path = strings.TrimSpace(path); path == "" {
+				continue
+			}
+			if abs, err := filepath.Abs(path); err == nil && abs != "/" {
+				blacklist = append(blacklist, abs)
+			}
+		}
+		opts.Blacklist = blacklist
+	}
+	return &Runner{opts: opts, bin: opts.Runtime}, nil
+}
+
+// interpreter returns the command that reads a program from stdin.
+func interpreter(language string) ([]string, error) {
+	switch strings.ToLower(strings.TrimSpace(language)) {
+	case "", LanguagePython, "python3", "py":
+		return []string{"python3", "-"}, nil
+	case LanguageNode, "javascript", "js", "nodejs":
+		return []string{"node", "-"}, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnsupportedLanguage, language)
+}
+
+// args builds the runtime command line. name identifies the container so it
+// can be killed on timeout.
+func (r *Runner) args(language, name string) ([]string, error) {
+	cmd, err := interpreter(language)
+	if err != nil {
+		return nil, err
+	}
+	o := r.opts
+	switch o.Runtime {
+	case RuntimeDocker:
+		image := o.PythonImage
+		if cmd[0] == "node" {
+			image = o.NodeImage
+		}
+		args := []string{"run", "--rm", "-i", "--name", name,
+			"--memory", fmt.Sprintf("%dm", o.MemoryMB),
+			"--memory-swap", fmt.Sprintf("%dm", o.MemoryMB),
+			"--cpus", strconv.FormatFloat(o.CPUs, 'f', -1, 64),
+			"--pids-limit", strconv.Itoa(maxProcesses),
+			"--cap-drop=ALL", "--security-opt=no-new-privileges",
+			"--read-only", "--tmpfs", "/tmp:rw,size=64m",
+			"--user", "65534:65534",
+			"--workdir", "/tmp",
+		}
+		if !o.Network {
+			args = append(args, "--network", "none")
+		}
+		return append(append(args, image), cmd...), nil
+	default:
+		args := []string{"--quiet", "--noprofile",
+			"--private", "--private-tmp", "--private-dev", "--private-etc=" + firejailEtc,
+			"--read-only=/",
+			"--caps.drop=all", "--nonewprivs", "--noroot", "--seccomp",
+			fmt.Sprintf("--rlimit-as=%d", int64(o.MemoryMB)<<20),
+			fmt.Sprintf("--rlimit-cpu=%d", int(o.Timeout.Seconds())+1),
+			fmt.Sprintf("--rlimit-nproc=%d", firejailMaxProcesses),
+		}
+		for _, path := range append(append([]string(nil), firejailBlacklist...), o.Blacklist...) {
+			args = append(args, "--blacklist="+path)
+		}
+		if cpus := int(math.Ceil(o.CPUs)); cpus < runtime.NumCPU() {
+			cores := make([]string, cpus)
+			for i := range cores {
+				cores[i] = strconv.Itoa(i)
+			}
+			args = append(args, "--cpu="+strings.Join(cores, ","))
+		}
+		if !o.Network {
+			args = append(args, "--net=none")
+		}
+		return append(args, cmd...), nil
+	}
+}
+
+// Run executes code and returns its output. A non-zero exit status is
+// reported in Result.ReturnCode, not as an error.
+func (r *Runner) Run(ctx context.Context, language, code string) (Result, error) {
+	name := "orchids-exec-" + randomSuffix()
+	args, err := r.args(language, name)
+	if err != nil {
+		return Result{}, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, r.opts.Timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, r.bin, args...)
+	if r.opts.Runtime == RuntimeDocker {
+		// Killing the docker client leaves the container running.
+		cmd.Cancel = func() error {
+			_ = exec.Command(r.bin, "kill", name).Run()
+			return cmd.Process.Kill()
+		}
+	}
+	// Don't wait on output pipes a stray child may still hold after a kill.
+	cmd.WaitDelay = time.Second
+	stdout := &limitedBuffer{max: maxOutputBytes}
+	stderr := &limitedBuffer{max: maxOutputBytes}
+	cmd.Stdin = strings.NewReader(code)
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+
+	err = cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return Result{}, ErrTimeout
+	}
+	res := Result{Stdout: stdout.String(), Stderr: stderr.String()}
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+	case errors.As(err, &exitErr):
+		res.ReturnCode = exitErr.ExitCode()
+	default:
+		return Result{}, err
+	}
+	return res, nil
+}
+
+func randomSuffix() string {
+	b := make([]byte, 6)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// limitedBuffer keeps the first max bytes written and drops the rest.
+type limitedBuffer struct {
+	buf       bytes.Buffer
+	max       int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.buf.Len(); room > 0 {
+		if len(p) > room {
+			b.buf.Write(p[:room])
+			b.truncated = true
+		} else {
+			b.buf.Write(p)
+		}
+	} else if len(p) > 0 {
+		b.truncated = true
+	}
+	return len(p), nil
+}
+
+func (b *limitedBuffer) String() string {
+	if b.truncated {
+		return b.buf.String() + "\n...[output truncated]"
+	}
+	return b.buf.String()
+}
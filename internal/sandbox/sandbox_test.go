@@ -0,0 +1,91 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestArgs(t *testing.T) {
+	r, err := New(Options{Runtime: "docker", MemoryMB: 128, CPUs: 0.5})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	args, err := r.args("node", "box")
+	if err != nil {
+		t.Fatalf("args: %v", err)
+	}
+	got := strings.Join(args, " ")
+	for _, want := range []string{"--name box", "--memory 128m", "--cpus 0.5", "--network none", "--cap-drop=ALL", "--security-opt=no-new-privileges", "node:22-slim node -"} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("docker args missing %q: %s", want, got)
+		}
+	}
+
+	r, _ = New(Options{Runtime: "firejail", MemoryMB: 64, Network: true, Blacklist: []string{"/srv/orchids/logs", " "}})
+	args, _ = r.args("python", "box")
+	got = strings.Join(args, " ")
+	if !strings.Contains(got, "--rlimit-as=67108864") || strings.Contains(got, "--net=none") || !strings.HasSuffix(got, "python3 -") {
+		t.Fatalf("firejail args: %s", got)
+	}
+	wd, _ := os.Getwd()
+	for _, want := range []string{"--read-only=/", "--private-etc=", "--private-dev", "--rlimit-nproc=", "--blacklist=/root", "--blacklist=/srv/orchids/logs", "--blacklist=" + wd} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("firejail args missing %q: %s", want, got)
+		}
+	}
+	if strings.Contains(got, "--blacklist= ") {
+		t.Fatalf("blank blacklist entry kept: %s", got)
+	}
+
+	if _, err := r.args("ruby", "box"); !errors.Is(err, ErrUnsupportedLanguage) {
+		t.Fatalf("expected ErrUnsupportedLanguage, got %v", err)
+	}
+	if r, err := New(Options{}); r != nil || err != nil {
+		t.Fatalf("empty runtime: r=%v err=%v", r, err)
+	}
+	if _, err := New(Options{Runtime: "chroot"}); err == nil {
+		t.Fatal("expected an error for an unknown runtime")
+	}
+}
+
+// fakeRuntime writes a shell script standing in for firejail.
+func fakeRuntime(t *testing.T, body string, timeout time.Duration) *Runner {
+	t.Helper()
+	bin := filepath.Join(t.TempDir(), "fake-runtime")
+	if err := os.WriteFile(bin, []byte("#!/bin/sh\n"+body+"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	r, _ := New(Options{Runtime: "firejail", Timeout: timeout})
+	r.bin = bin
+	return r
+}
+
+func TestRun(t *testing.T) {
+	r := fakeRuntime(t, "cat; echo oops >&2; exit 3", time.Second)
+	res, err := r.Run(context.Background(), "python", "print(1)")
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if res.Stdout != "print(1)" || strings.TrimSpace(res.Stderr) != "oops" || res.ReturnCode != 3 {
+		t.Fatalf("result=%+v", res)
+	}
+
+	r = fakeRuntime(t, "sleep 5", 100*time.Millisecond)
+	if _, err := r.Run(context.Background(), "python", ""); !errors.Is(err, ErrTimeout) {
+		t.Fatalf("expected ErrTimeout, got %v", err)
+	}
+}
+
+func TestLimitedBuffer(t *testing.T) {
+	b := &limitedBuffer{max: 4}
+	b.Write([]byte("abc"))
+	b.Write([]byte("def"))
+	if got := b.String(); got != "abcd\n...[output truncated]" {
+		t.Fatalf("got %q", got)
+	}
+}
@@ -0,0 +1,252 @@
+// Package scheduler runs named periodic jobs: each job runs on its own
+// interval, never overlaps itself, can be triggered by hand and has its
+// panics recovered and reported as a failed run.
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+
+	"orchids-api/internal/events"
+)
+
+var (
+	// ErrUnknownJob is returned for a job name that was never added.
+	ErrUnknownJob = errors.New("unknown job")
+	// ErrJobRunning is returned when triggering a job that is already running.
+	ErrJobRunning = errors.New("job is already running")
+)
+
+// Job is a unit of periodic work.
+type Job struct {
+	Name string
+	// Interval between the end of one scheduled run and the start of the
+	// next; 0 or less only runs the job when triggered.
+	Interval time.Duration
+	// Delay before the first scheduled run. RunAtStart runs it right away.
+	Delay      time.Duration
+	RunAtStart bool
+	Run        func(ctx context.Context) error
+}
+
+// Status is the last-run state of a job, as reported by /api/jobs.
+type Status struct {
+	Name         string     `json:"name"`
+	Interval     string     `json:"interval"`
+	Running      bool       `json:"running"`
+	Runs         int64      `json:"runs"`
+	Failures     int64      `json:"failures"`
+	LastRun      *time.Time `json:"last_run,omitempty"`
+	LastDuration int64      `json:"last_duration_ms"`
+	LastStatus   string     `json:"last_status,omitempty"` // "ok", "error" or "panic"
+	LastError    string     `json:"last_error,omitempty"`
+	LastTrigger  string     `json:"last_trigger,omitempty"` // "schedule" or "manual"
+	NextRun      *time.Time `json:"next_run,omitempty"`
+}
+
+type job struct {
+	Job
+	mu     sync.Mutex
+	status Status
+}
+
+// Scheduler owns a set of jobs.
+type Scheduler struct {
+	mu      sync.RWMutex
+	jobs    map[string]*job
+	ctx     context.Context
+	started bool
+}
+
+// New returns an empty scheduler.
+func New() *Scheduler {
+	return &Scheduler{jobs: make(map[string]*job), ctx: context.Background()}
+}
+
+// Add registers j. Jobs added after Start are scheduled immediately.
+func (s *Scheduler) Add(j Job) error {
+	if j.Name == "" || j.Run == nil {
+		return errors.New("scheduler: job needs a name and a Run func")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.jobs[j.Name]; ok {
+		return fmt.Errorf("scheduler: job %q already added", j.Name)
+	}
+	jb := &job{Job: j, status: Status{Name: j.Name, Interval: intervalString(j.Interval)}}
+	s.jobs[j.Name] = jb
+	if s.started {
+		go s.loop(s.ctx, jb)
+	}
+	return nil
+}
+
+// Start runs every job on its schedule until ctx is done.
+func (s *Scheduler) Start(ctx context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started {
+		return
+	}
+	s.started = true
+	s.ctx = ctx
+	for _, jb := range s.jobs {
+		go s.loop(ctx, jb)
+	}
+}
+
+// Trigger runs a job now, in the background. Manual runs do not move the
+// job's schedule.
+func (s *Scheduler) Trigger(name string) error {
+	s.mu.RLock()
+	jb, ok := s.jobs[name]
+	ctx := s.ctx
+	s.mu.RUnlock()
+	if !ok {
+		return ErrUnknownJob
+	}
+	if !jb.begin("manual") {
+		return ErrJobRunning
+	}
+	go s.run(ctx, jb)
+	return nil
+}
+
+// Statuses reports every job, sorted by name.
+func (s *Scheduler) Statuses() []Status {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]Status, 0, len(s.jobs))
+	for _, jb := range s.jobs {
+		out = append(out, jb.snapshot())
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// Status reports one job.
+func (s *Scheduler) Status(name string) (Status, bool) {
+	s.mu.RLock()
+	jb, ok := s.jobs[name]
+	s.mu.RUnlock()
+	if !ok {
+		return Status{}, false
+	}
+	return jb.snapshot(), true
+}
+
+func (s *Scheduler) loop(ctx context.Context, jb *job) {
+	if jb.Interval <= 0 && !jb.RunAtStart && jb.Delay <= 0 {
+		return // manual only
+	}
+	wait := jb.Delay
+	if jb.RunAtStart {
+		wait = 0
+	} else if wait <= 0 {
+		wait = jb.Interval
+	}
+	for {
+		next := time.Now().Add(wait)
+		jb.setNextRun(&next)
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		// A manual run in progress counts as this tick.
+		if jb.begin("schedule") {
+			s.run(ctx, jb)
+		}
+		if jb.Interval <= 0 {
+			jb.setNextRun(nil)
+			return
+		}
+		wait = jb.Interval
+	}
+}
+
+// run executes one run that begin already accounted for.
+func (s *Scheduler) run(ctx context.Context, jb *job) {
+	start := time.Now()
+	err, panicked := safeRun(ctx, jb.Run)
+
+	jb.mu.Lock()
+	defer jb.mu.Unlock()
+	st := &jb.status
+	st.Running = false
+	st.Runs++
+	st.LastRun = &start
+	st.LastDuration = time.Since(start).Milliseconds()
+	st.LastError = ""
+	switch {
+	case panicked:
+		st.Failures++
+		st.LastStatus = "panic"
+		st.LastError = err.Error()
+		slog.Error("Scheduled job panicked", "job", jb.Name, "error", err)
+	case err != nil:
+		st.Failures++
+		st.LastStatus = "error"
+		st.LastError = err.Error()
+		slog.Warn("Scheduled job failed", "job", jb.Name, "error", err)
+	default:
+		st.LastStatus = "ok"
+		slog.Debug("Scheduled job finished", "job", jb.Name, "duration", time.Since(start))
+		return
+	}
+	events.Publish(events.JobFailed, events.LevelError, "job "+jb.Name+" failed: "+st.LastError, map[string]interface{}{
+		"job":     jb.Name,
+		"status":  st.LastStatus,
+		"error":   st.LastError,
+		"trigger": st.LastTrigger,
+	})
+}
+
+func safeRun(ctx context.Context, fn func(context.Context) error) (err error, panicked bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			slog.Debug("Scheduled job stack", "stack", string(debug.Stack()))
+			err, panicked = fmt.Errorf("panic: %v", r), true
+		}
+	}()
+	return fn(ctx), false
+}
+
+// begin marks the job running, or reports false if it already is.
+func (jb *job) begin(trigger string) bool {
+	jb.mu.Lock()
+	defer jb.mu.Unlock()
+	if jb.status.Running {
+		return false
+	}
+	jb.status.Running = true
+	jb.status.LastTrigger = trigger
+	return true
+}
+
+func (jb *job) setNextRun(t *time.Time) {
+	jb.mu.Lock()
+	jb.status.NextRun = t
+	jb.mu.Unlock()
+}
+
+func (jb *job) snapshot() Status {
+	jb.mu.Lock()
+	defer jb.mu.Unlock()
+	return jb.status
+}
+
+func intervalString(d time.Duration) string {
+	if d <= 0 {
+		return "manual"
+	}
+	return d.String()
+}
@@ -0,0 +1,102 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"orchids-api/internal/events"
+)
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestSchedulerRunsOnInterval(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := New()
+	var runs atomic.Int32
+	if err := s.Add(Job{Name: "tick", Interval: 10 * time.Millisecond, RunAtStart: true, Run: func(context.Context) error {
+		runs.Add(1)
+		return nil
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Add(Job{Name: "tick", Run: func(context.Context) error { return nil }}); err == nil {
+		t.Fatal("duplicate job name accepted")
+	}
+	s.Start(ctx)
+	waitFor(t, func() bool { return runs.Load() >= 3 })
+
+	st, ok := s.Status("tick")
+	if !ok || st.LastStatus != "ok" || st.LastRun == nil || st.LastTrigger != "schedule" {
+		t.Fatalf("status=%+v", st)
+	}
+}
+
+func TestSchedulerPanicIsolationAndTrigger(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := New()
+	release := make(chan struct{})
+	var calls atomic.Int32
+	s.Add(Job{Name: "flaky", Run: func(context.Context) error {
+		switch calls.Add(1) {
+		case 1:
+			panic("boom")
+		case 2:
+			return errors.New("failed")
+		}
+		<-release
+		return nil
+	}})
+	s.Start(ctx)
+	failures, unsubscribe := events.Default.Subscribe(4)
+	defer unsubscribe()
+
+	if err := s.Trigger("missing"); !errors.Is(err, ErrUnknownJob) {
+		t.Fatalf("err=%v", err)
+	}
+	if err := s.Trigger("flaky"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { st, _ := s.Status("flaky"); return st.Runs == 1 })
+	if st, _ := s.Status("flaky"); st.LastStatus != "panic" || st.LastError != "panic: boom" || st.Interval != "manual" {
+		t.Fatalf("after panic: %+v", st)
+	}
+
+	s.Trigger("flaky")
+	waitFor(t, func() bool { st, _ := s.Status("flaky"); return st.Runs == 2 })
+	if st, _ := s.Status("flaky"); st.LastStatus != "error" || st.Failures != 2 {
+		t.Fatalf("after error: %+v", st)
+	}
+
+	for _, want := range []string{"panic", "error"} {
+		select {
+		case e := <-failures:
+			if e.Type != events.JobFailed || e.Data["job"] != "flaky" || e.Data["status"] != want {
+				t.Fatalf("failure event: %+v", e)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no %s event published", want)
+		}
+	}
+
+	s.Trigger("flaky")
+	waitFor(t, func() bool { st, _ := s.Status("flaky"); return st.Running })
+	if err := s.Trigger("flaky"); !errors.Is(err, ErrJobRunning) {
+		t.Fatalf("overlapping trigger: err=%v", err)
+	}
+	close(release)
+	waitFor(t, func() bool { st, _ := s.Status("flaky"); return st.Runs == 3 && st.LastStatus == "ok" })
+}
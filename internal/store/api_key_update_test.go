@@ -0,0 +1,61 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestUpdateApiKey(t *testing.T) {
+	mr := miniredis.RunT(t)
+	s, err := New(Options{RedisAddr: mr.Addr(), RedisPrefix: "test:"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ctx := context.Background()
+	key := &ApiKey{Name: "k", KeyHash: "hash", Enabled: true, TenantID: 7}
+	if err := s.CreateApiKey(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+
+	// Concurrent updates of different fields must not overwrite each other.
+	var wg sync.WaitGroup
+	updates := []func(*ApiKey){
+		func(k *ApiKey) { k.SystemPrompt = "be brief" },
+		func(k *ApiKey) { k.AllowPinning = true },
+		func(k *ApiKey) { k.TPMLimit = 1000 },
+		func(k *ApiKey) { k.ToolGateMaxChars = 40 },
+		func(k *ApiKey) { k.SkipPromptHygiene = true },
+		func(k *ApiKey) { k.StrictModels = true },
+		func(k *ApiKey) { k.LogResidency = &LogResidency{} },
+	}
+	for _, update := range updates {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.UpdateApiKey(ctx, key.ID, update); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	got, err := s.GetApiKeyByID(ctx, key.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.SystemPrompt != "be brief" || !got.AllowPinning || got.TPMLimit != 1000 || got.ToolGateMaxChars != 40 ||
+		!got.SkipPromptHygiene || !got.StrictModels || got.LogResidency != nil || !got.Enabled {
+		t.Fatalf("key after updates = %+v", got)
+	}
+
+	if err := s.UpdateApiKey(ctx, key.ID+100, func(*ApiKey) {}); !errors.Is(err, ErrNoRows) {
+		t.Fatalf("missing key: err = %v", err)
+	}
+	if err := s.UpdateApiKey(WithTenant(ctx, 8), key.ID, func(k *ApiKey) { k.Enabled = false }); !errors.Is(err, ErrNoRows) {
+		t.Fatalf("other tenant: err = %v", err)
+	}
+}
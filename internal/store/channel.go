@@ -0,0 +1,187 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/redis/go-redis/v9"
+)
+
+// Channel holds the settings shared by every account of one account type.
+// Built-in channels (orchids, warp, kiro, grok, openai-compatible,
+// anthropic) work without a record; a record overrides their defaults, and
+// a record with a Type makes a new channel name usable as an account type.
+type Channel struct {
+	Name         string       `json:"name"`                    // account_type it applies to, lowercase
+	Type         string       `json:"type,omitempty"`          // Client for new channels: "openai-compatible" or "anthropic"
+	BaseURL      string       `json:"base_url,omitempty"`      // Upstream endpoint for accounts without their own base_url
+	DefaultModel string       `json:"default_model,omitempty"` // Model for channel routes called without one
+	TokenBudget  int          `json:"token_budget,omitempty"`  // Tokens per minute across the channel (0 = unlimited)
+	Retry        *RetryPolicy `json:"retry,omitempty"`         // Upstream retries (nil = global defaults)
+	Enabled      bool         `json:"enabled"`
+	CreatedAt    time.Time    `json:"created_at"`
+	UpdatedAt    time.Time    `json:"updated_at"`
+}
+
+// RetryPolicy overrides how failed upstream attempts are retried.
+type RetryPolicy struct {
+	MaxRetries int `json:"max_retries"`
+	DelayMs    int `json:"delay_ms,omitempty"` // Base backoff (0 = global default)
+}
+
+// BuiltinChannels have a client implementation of their own.
+var BuiltinChannels = []string{"anthropic", "grok", "kiro", "openai-compatible", "orchids", "warp"}
+
+// IsBuiltinChannel reports whether name is one of BuiltinChannels.
+func IsBuiltinChannel(name string) bool {
+	return slices.Contains(BuiltinChannels, NormalizeChannelName(name))
+}
+
+// NormalizeChannelName returns the key channels are stored under.
+func NormalizeChannelName(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+type channelStore interface {
+	SaveChannel(ctx context.Context, ch *Channel) error
+	DeleteChannel(ctx context.Context, name string) error
+	GetChannel(ctx context.Context, name string) (*Channel, error)
+	ListChannels(ctx context.Context) ([]*Channel, error)
+}
+
+// Channel wrappers
+
+// SaveChannel creates or replaces the channel named ch.Name.
+func (s *Store) SaveChannel(ctx context.Context, ch *Channel) error {
+	if s.channels != nil {
+		return s.channels.SaveChannel(ctx, ch)
+	}
+	return fmt.Errorf("channels store not configured")
+}
+
+func (s *Store) DeleteChannel(ctx context.Context, name string) error {
+	if s.channels != nil {
+		return s.channels.DeleteChannel(ctx, name)
+	}
+	return fmt.Errorf("channels store not configured")
+}
+
+func (s *Store) GetChannel(ctx context.Context, name string) (*Channel, error) {
+	if s.channels != nil {
+		return s.channels.GetChannel(ctx, name)
+	}
+	return nil, fmt.Errorf("channels store not configured")
+}
+
+func (s *Store) ListChannels(ctx context.Context) ([]*Channel, error) {
+	if s.channels != nil {
+		return s.channels.ListChannels(ctx)
+	}
+	return nil, fmt.Errorf("channels store not configured")
+}
+
+func (s *redisStore) SaveChannel(ctx context.Context, ch *Channel) error {
+	if s == nil || s.client == nil {
+		return fmt.Errorf("redis store not configured")
+	}
+	ch.Name = NormalizeChannelName(ch.Name)
+	if ch.Name == "" {
+		return fmt.Errorf("channel name is required")
+	}
+	now := time.Now()
+	if existing, err := s.GetChannel(ctx, ch.Name); err == nil {
+		ch.CreatedAt = existing.CreatedAt
+	} else {
+		ch.CreatedAt = now
+	}
+	ch.UpdatedAt = now
+
+	data, err := json.Marshal(ch)
+	if err != nil {
+		return err
+	}
+	pipe := s.client.Pipeline()
+	pipe.Set(ctx, s.channelKey(ch.Name), data, 0)
+	pipe.SAdd(ctx, s.channelNamesKey(), ch.Name)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+func (s *redisStore) DeleteChannel(ctx context.Context, name string) error {
+	if s == nil || s.client == nil {
+		return fmt.Errorf("redis store not configured")
+	}
+	name = NormalizeChannelName(name)
+	pipe := s.client.Pipeline()
+	pipe.Del(ctx, s.channelKey(name))
+	pipe.SRem(ctx, s.channelNamesKey(), name)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (s *redisStore) GetChannel(ctx context.Context, name string) (*Channel, error) {
+	if s == nil || s.client == nil {
+		return nil, fmt.Errorf("redis store not configured")
+	}
+	value, err := s.client.Get(ctx, s.channelKey(NormalizeChannelName(name))).Result()
+	if err == redis.Nil {
+		return nil, ErrNoRows
+	}
+	if err != nil {
+		return nil, err
+	}
+	var ch Channel
+	if err := json.Unmarshal([]byte(value), &ch); err != nil {
+		return nil, err
+	}
+	return &ch, nil
+}
+
+func (s *redisStore) ListChannels(ctx context.Context) ([]*Channel, error) {
+	if s == nil || s.client == nil {
+		return nil, fmt.Errorf("redis store not configured")
+	}
+	names, err := s.client.SMembers(ctx, s.channelNamesKey()).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return []*Channel{}, nil
+	}
+	sort.Strings(names)
+
+	keys := make([]string, 0, len(names))
+	for _, name := range names {
+		keys = append(keys, s.channelKey(name))
+	}
+	values, err := s.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	channels := make([]*Channel, 0, len(values))
+	for _, value := range values {
+		strVal, ok := value.(string)
+		if !ok || strVal == "" {
+			continue
+		}
+		var ch Channel
+		if err := json.Unmarshal([]byte(strVal), &ch); err != nil {
+			continue
+		}
+		channels = append(channels, &ch)
+	}
+	return channels, nil
+}
+
+func (s *redisStore) channelKey(name string) string {
+	return s.prefix + "channels:name:" + name
+}
+
+func (s *redisStore) channelNamesKey() string {
+	return s.prefix + "channels:names"
+}
@@ -0,0 +1,318 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/redis/go-redis/v9"
+)
+
+// Experiment is a prompt-level A/B test: conversations matching Models are
+// split between two variants that differ in an injected system prompt and/or
+// routing, and per-variant results are collected for comparison.
+type Experiment struct {
+	ID          int64                `json:"id"`
+	Name        string               `json:"name"`
+	Description string               `json:"description,omitempty"`
+	Enabled     bool                 `json:"enabled"`
+	Models      []string             `json:"models,omitempty"` // Requested models the experiment applies to (empty = all)
+	Split       int                  `json:"split"`            // Percent of conversations assigned to variant B (0-100)
+	Variants    [2]ExperimentVariant `json:"variants"`         // A (control) and B
+	CreatedAt   time.Time            `json:"created_at"`
+	UpdatedAt   time.Time            `json:"updated_at"`
+}
+
+// ExperimentVariant is one arm of an experiment. Empty fields leave the
+// request unchanged, so a control arm can be left blank.
+type ExperimentVariant struct {
+	Name         string `json:"name"`
+	SystemPrompt string `json:"system_prompt,omitempty"` // Prepended to the request's system prompt
+	Model        string `json:"model,omitempty"`         // Replaces the requested model
+	Channel      string `json:"channel,omitempty"`       // Routes to this channel unless the path forces one
+}
+
+// ExperimentStats are the collected results of one variant.
+type ExperimentStats struct {
+	Requests         int64 `json:"requests"`
+	Errors           int64 `json:"errors"`
+	LatencyMs        int64 `json:"latency_ms"` // Sum over all requests
+	OutputTokens     int64 `json:"output_tokens"`
+	ToolCallRequests int64 `json:"tool_call_requests"` // Requests whose response called a tool
+}
+
+// ExperimentResult is the outcome of one request served by a variant.
+type ExperimentResult struct {
+	Latency      time.Duration
+	OutputTokens int
+	ToolCalls    bool
+	Error        bool
+}
+
+type experimentStore interface {
+	CreateExperiment(ctx context.Context, e *Experiment) error
+	UpdateExperiment(ctx context.Context, e *Experiment) error
+	DeleteExperiment(ctx context.Context, id int64) error
+	GetExperiment(ctx context.Context, id int64) (*Experiment, error)
+	ListExperiments(ctx context.Context) ([]*Experiment, error)
+	RecordExperimentResult(ctx context.Context, id int64, variant int, res ExperimentResult) error
+	GetExperimentStats(ctx context.Context, id int64) ([2]ExperimentStats, error)
+	ResetExperimentStats(ctx context.Context, id int64) error
+}
+
+// Experiment wrappers. Experiments are global: tenant-scoped contexts may
+// read them but not change them.
+
+func (s *Store) CreateExperiment(ctx context.Context, e *Experiment) error {
+	if _, scoped := TenantFromContext(ctx); scoped {
+		return ErrTenantScope
+	}
+	if s.experiments != nil {
+		return s.experiments.CreateExperiment(ctx, e)
+	}
+	return fmt.Errorf("experiments store not configured")
+}
+
+func (s *Store) UpdateExperiment(ctx context.Context, e *Experiment) error {
+	if _, scoped := TenantFromContext(ctx); scoped {
+		return ErrTenantScope
+	}
+	if s.experiments != nil {
+		return s.experiments.UpdateExperiment(ctx, e)
+	}
+	return fmt.Errorf("experiments store not configured")
+}
+
+func (s *Store) DeleteExperiment(ctx context.Context, id int64) error {
+	if _, scoped := TenantFromContext(ctx); scoped {
+		return ErrTenantScope
+	}
+	if s.experiments != nil {
+		return s.experiments.DeleteExperiment(ctx, id)
+	}
+	return fmt.Errorf("experiments store not configured")
+}
+
+func (s *Store) GetExperiment(ctx context.Context, id int64) (*Experiment, error) {
+	if s.experiments != nil {
+		return s.experiments.GetExperiment(ctx, id)
+	}
+	return nil, fmt.Errorf("experiments store not configured")
+}
+
+func (s *Store) ListExperiments(ctx context.Context) ([]*Experiment, error) {
+	if s.experiments != nil {
+		return s.experiments.ListExperiments(ctx)
+	}
+	return nil, fmt.Errorf("experiments store not configured")
+}
+
+// RecordExperimentResult adds the outcome of one request to the totals of
+// variant (0 = A, 1 = B).
+func (s *Store) RecordExperimentResult(ctx context.Context, id int64, variant int, res ExperimentResult) error {
+	if s.experiments != nil {
+		return s.experiments.RecordExperimentResult(ctx, id, variant, res)
+	}
+	return fmt.Errorf("experiments store not configured")
+}
+
+func (s *Store) GetExperimentStats(ctx context.Context, id int64) ([2]ExperimentStats, error) {
+	if s.experiments != nil {
+		return s.experiments.GetExperimentStats(ctx, id)
+	}
+	return [2]ExperimentStats{}, fmt.Errorf("experiments store not configured")
+}
+
+func (s *Store) ResetExperimentStats(ctx context.Context, id int64) error {
+	if _, scoped := TenantFromContext(ctx); scoped {
+		return ErrTenantScope
+	}
+	if s.experiments != nil {
+		return s.experiments.ResetExperimentStats(ctx, id)
+	}
+	return fmt.Errorf("experiments store not configured")
+}
+
+// Redis implementation
+
+func (s *redisStore) CreateExperiment(ctx context.Context, e *Experiment) error {
+	if s == nil || s.client == nil {
+		return fmt.Errorf("redis store not configured")
+	}
+	id, err := s.client.Incr(ctx, s.experimentsNextIDKey()).Result()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	e.ID = id
+	e.CreatedAt = now
+	e.UpdatedAt = now
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	pipe := s.client.Pipeline()
+	pipe.Set(ctx, s.experimentsKey(id), data, 0)
+	pipe.SAdd(ctx, s.experimentsIDsKey(), id)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+func (s *redisStore) UpdateExperiment(ctx context.Context, e *Experiment) error {
+	if s == nil || s.client == nil {
+		return fmt.Errorf("redis store not configured")
+	}
+	existing, err := s.GetExperiment(ctx, e.ID)
+	if err != nil {
+		return err
+	}
+	e.CreatedAt = existing.CreatedAt
+	e.UpdatedAt = time.Now()
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return s.client.Set(ctx, s.experimentsKey(e.ID), data, 0).Err()
+}
+
+func (s *redisStore) DeleteExperiment(ctx context.Context, id int64) error {
+	if s == nil || s.client == nil {
+		return fmt.Errorf("redis store not configured")
+	}
+	pipe := s.client.Pipeline()
+	pipe.Del(ctx, s.experimentsKey(id), s.experimentStatsKey(id, 0), s.experimentStatsKey(id, 1))
+	pipe.SRem(ctx, s.experimentsIDsKey(), id)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (s *redisStore) GetExperiment(ctx context.Context, id int64) (*Experiment, error) {
+	if s == nil || s.client == nil {
+		return nil, fmt.Errorf("redis store not configured")
+	}
+	value, err := s.client.Get(ctx, s.experimentsKey(id)).Result()
+	if err == redis.Nil {
+		return nil, ErrNoRows
+	}
+	if err != nil {
+		return nil, err
+	}
+	var e Experiment
+	if err := json.Unmarshal([]byte(value), &e); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+func (s *redisStore) ListExperiments(ctx context.Context) ([]*Experiment, error) {
+	if s == nil || s.client == nil {
+		return nil, fmt.Errorf("redis store not configured")
+	}
+	ids, err := s.client.SMembers(ctx, s.experimentsIDsKey()).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return []*Experiment{}, nil
+	}
+	slices.SortFunc(ids, func(a, b string) int {
+		x, _ := strconv.ParseInt(a, 10, 64)
+		y, _ := strconv.ParseInt(b, 10, 64)
+		return int(x - y)
+	})
+	keys := make([]string, 0, len(ids))
+	for _, id := range ids {
+		n, err := strconv.ParseInt(id, 10, 64)
+		if err != nil {
+			continue
+		}
+		keys = append(keys, s.experimentsKey(n))
+	}
+	values, err := s.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	experiments := make([]*Experiment, 0, len(values))
+	for _, value := range values {
+		str, ok := value.(string)
+		if !ok || str == "" {
+			continue
+		}
+		var e Experiment
+		if err := json.Unmarshal([]byte(str), &e); err != nil {
+			continue
+		}
+		experiments = append(experiments, &e)
+	}
+	return experiments, nil
+}
+
+func (s *redisStore) RecordExperimentResult(ctx context.Context, id int64, variant int, res ExperimentResult) error {
+	if s == nil || s.client == nil {
+		return fmt.Errorf("redis store not configured")
+	}
+	key := s.experimentStatsKey(id, variant)
+	pipe := s.client.TxPipeline()
+	pipe.HIncrBy(ctx, key, "requests", 1)
+	pipe.HIncrBy(ctx, key, "latency_ms", res.Latency.Milliseconds())
+	pipe.HIncrBy(ctx, key, "output_tokens", int64(res.OutputTokens))
+	if res.ToolCalls {
+		pipe.HIncrBy(ctx, key, "tool_call_requests", 1)
+	}
+	if res.Error {
+		pipe.HIncrBy(ctx, key, "errors", 1)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (s *redisStore) GetExperimentStats(ctx context.Context, id int64) ([2]ExperimentStats, error) {
+	var stats [2]ExperimentStats
+	if s == nil || s.client == nil {
+		return stats, fmt.Errorf("redis store not configured")
+	}
+	for i := range stats {
+		vals, err := s.client.HGetAll(ctx, s.experimentStatsKey(id, i)).Result()
+		if err != nil {
+			return stats, err
+		}
+		parse := func(field string) int64 {
+			n, _ := strconv.ParseInt(vals[field], 10, 64)
+			return n
+		}
+		stats[i] = ExperimentStats{
+			Requests:         parse("requests"),
+			Errors:           parse("errors"),
+			LatencyMs:        parse("latency_ms"),
+			OutputTokens:     parse("output_tokens"),
+			ToolCallRequests: parse("tool_call_requests"),
+		}
+	}
+	return stats, nil
+}
+
+func (s *redisStore) ResetExperimentStats(ctx context.Context, id int64) error {
+	if s == nil || s.client == nil {
+		return fmt.Errorf("redis store not configured")
+	}
+	return s.client.Del(ctx, s.experimentStatsKey(id, 0), s.experimentStatsKey(id, 1)).Err()
+}
+
+func (s *redisStore) experimentsKey(id int64) string {
+	return fmt.Sprintf("%sexperiments:id:%d", s.prefix, id)
+}
+
+func (s *redisStore) experimentsIDsKey() string {
+	return s.prefix + "experiments:ids"
+}
+
+func (s *redisStore) experimentsNextIDKey() string {
+	return s.prefix + "experiments:next_id"
+}
+
+func (s *redisStore) experimentStatsKey(id int64, variant int) string {
+	return fmt.Sprintf("%sexperiments:stats:%d:%d", s.prefix, id, variant)
+}
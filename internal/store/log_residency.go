@@ -0,0 +1,53 @@
+package store
+
+import "strings"
+
+// LogResidency decides where the prompts of a tenant's or an API key's
+// requests may be persisted. Empty destinations fall back to the server
+// defaults.
+type LogResidency struct {
+	DebugDir     string `json:"debug_dir,omitempty"`     // Directory for debug logs and failure snapshots
+	DisableDebug bool   `json:"disable_debug,omitempty"` // Never write debug logs, captures or failure snapshots
+	AuditPrefix  string `json:"audit_prefix,omitempty"`  // Redis key prefix of the audit stream
+	DisableAudit bool   `json:"disable_audit,omitempty"` // Never write audit events
+}
+
+// IsZero reports whether r keeps the server defaults.
+func (r *LogResidency) IsZero() bool {
+	return r == nil || *r == LogResidency{}
+}
+
+// EffectiveLogResidency combines the residency of a key and of its tenant:
+// the key's destinations take precedence and either of them can disable a
+// log.
+func EffectiveLogResidency(tenant *Tenant, key *ApiKey) LogResidency {
+	var res LogResidency
+	for _, r := range []*LogResidency{tenantLogResidency(tenant), keyLogResidency(key)} {
+		if r == nil {
+			continue
+		}
+		if dir := strings.TrimSpace(r.DebugDir); dir != "" {
+			res.DebugDir = dir
+		}
+		if prefix := strings.TrimSpace(r.AuditPrefix); prefix != "" {
+			res.AuditPrefix = prefix
+		}
+		res.DisableDebug = res.DisableDebug || r.DisableDebug
+		res.DisableAudit = res.DisableAudit || r.DisableAudit
+	}
+	return res
+}
+
+func tenantLogResidency(t *Tenant) *LogResidency {
+	if t == nil {
+		return nil
+	}
+	return t.LogResidency
+}
+
+func keyLogResidency(k *ApiKey) *LogResidency {
+	if k == nil {
+		return nil
+	}
+	return k.LogResidency
+}
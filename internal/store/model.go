@@ -78,4 +78,12 @@ type Model struct {
 	Status    ModelStatus `json:"status"`     // Enabled/Disabled
 	IsDefault bool        `json:"is_default"` // Is default for this channel
 	SortOrder int         `json:"sort_order"`
+	// Tools lists the tool names and server tool types (e.g. "web_search")
+	// the channel can execute for this model; "*" allows every client
+	// tool. Empty uses the channel's built-in list.
+	Tools []string `json:"tools,omitempty"`
+	// ContextWindow is the model's context size in tokens; requests whose
+	// estimated input exceeds it are rejected before dispatch. 0 disables
+	// the check.
+	ContextWindow int `json:"context_window,omitempty"`
 }
@@ -20,15 +20,28 @@ type redisStore struct {
 }
 
 type apiKeyRecord struct {
-	ID         int64      `json:"id"`
-	Name       string     `json:"name"`
-	KeyHash    string     `json:"key_hash"`
-	KeyFull    string     `json:"key_full,omitempty"`
-	KeyPrefix  string     `json:"key_prefix"`
-	KeySuffix  string     `json:"key_suffix"`
-	Enabled    bool       `json:"enabled"`
-	LastUsedAt *time.Time `json:"last_used_at"`
-	CreatedAt  time.Time  `json:"created_at"`
+	ID                int64         `json:"id"`
+	Name              string        `json:"name"`
+	KeyHash           string        `json:"key_hash"`
+	KeyFull           string        `json:"key_full,omitempty"`
+	KeyPrefix         string        `json:"key_prefix"`
+	KeySuffix         string        `json:"key_suffix"`
+	Enabled           bool          `json:"enabled"`
+	SystemPrompt      string        `json:"system_prompt,omitempty"`
+	AllowPinning      bool          `json:"allow_pinning"`
+	TPMLimit          int           `json:"tpm_limit,omitempty"`
+	ToolGateMaxChars  int           `json:"tool_gate_max_chars,omitempty"`
+	SkipPromptHygiene bool          `json:"skip_prompt_hygiene,omitempty"`
+	StrictModels      bool          `json:"strict_models,omitempty"`
+	Description       string        `json:"description,omitempty"`
+	Owner             string        `json:"owner,omitempty"`
+	MonthlyTokenQuota int           `json:"monthly_token_quota,omitempty"`
+	ExpiresAt         *time.Time    `json:"expires_at,omitempty"`
+	AllowedIPs        []string      `json:"allowed_ips,omitempty"`
+	TenantID          int64         `json:"tenant_id,omitempty"`
+	LogResidency      *LogResidency `json:"log_residency,omitempty"`
+	LastUsedAt        *time.Time    `json:"last_used_at"`
+	CreatedAt         time.Time     `json:"created_at"`
 }
 
 func newRedisStore(addr, password string, db int, prefix string) (*redisStore, error) {
@@ -90,6 +103,7 @@ func (s *redisStore) CreateAccount(ctx context.Context, acc *Account) error {
 
 	now := time.Now()
 	acc.ID = id
+	acc.TokenExpiresAt = TokenExpiry(acc.Token)
 	if acc.CreatedAt.IsZero() {
 		acc.CreatedAt = now
 	}
@@ -152,8 +166,22 @@ func (s *redisStore) UpdateAccount(ctx context.Context, acc *Account) error {
 	updated.AgentMode = acc.AgentMode
 	updated.Email = acc.Email
 	updated.Weight = acc.Weight
+	updated.MaxConcurrency = acc.MaxConcurrency
+	updated.TPMLimit = acc.TPMLimit
+	updated.MaxRPM = acc.MaxRPM
+	updated.DailyRequestCap = acc.DailyRequestCap
+	updated.MonthlyRequestCap = acc.MonthlyRequestCap
+	updated.DailyTokenCap = acc.DailyTokenCap
+	updated.MonthlyTokenCap = acc.MonthlyTokenCap
+	updated.CapResetDay = acc.CapResetDay
 	updated.Enabled = acc.Enabled
 	updated.Token = acc.Token
+	updated.TokenExpiresAt = TokenExpiry(acc.Token)
+	updated.BaseURL = acc.BaseURL
+	updated.APIKey = acc.APIKey
+	updated.Headers = acc.Headers
+	updated.UserAgents = acc.UserAgents
+	updated.Tags = acc.Tags
 	updated.Subscription = acc.Subscription
 	updated.UsageCurrent = acc.UsageCurrent
 	updated.UsageTotal = acc.UsageTotal
@@ -225,6 +253,23 @@ func (s *redisStore) GetEnabledAccounts(ctx context.Context) ([]*Account, error)
 	return s.getAccountsByIDs(ctx, ids, true)
 }
 
+func (s *redisStore) SetAccountDraining(ctx context.Context, id int64, draining bool) error {
+	if s == nil || s.client == nil {
+		return fmt.Errorf("redis store not configured")
+	}
+	acc, err := s.getAccount(ctx, id)
+	if err != nil {
+		return err
+	}
+	acc.Draining = draining
+	acc.UpdatedAt = time.Now()
+	data, err := json.Marshal(acc)
+	if err != nil {
+		return err
+	}
+	return s.client.Set(ctx, s.accountsKey(id), data, 0).Err()
+}
+
 func (s *redisStore) IncrementRequestCount(ctx context.Context, id int64) error {
 	if s == nil || s.client == nil {
 		return fmt.Errorf("redis store not configured")
@@ -535,6 +580,48 @@ func (s *redisStore) SetSetting(ctx context.Context, key, value string) error {
 	return s.client.Set(ctx, s.settingsKey(key), value, 0).Err()
 }
 
+func (s *redisStore) DeleteSetting(ctx context.Context, key string) error {
+	if s == nil || s.client == nil {
+		return fmt.Errorf("redis store not configured")
+	}
+	key = strings.TrimSpace(key)
+	if key == "" {
+		return nil
+	}
+	return s.client.Del(ctx, s.settingsKey(key)).Err()
+}
+
+// ListSettings returns every stored setting. Settings have no index set, so
+// this scans the settings key space; there are only a handful of them.
+func (s *redisStore) ListSettings(ctx context.Context) (map[string]string, error) {
+	if s == nil || s.client == nil {
+		return nil, fmt.Errorf("redis store not configured")
+	}
+	prefix := s.settingsKey("")
+	var keys []string
+	iter := s.client.Scan(ctx, 0, prefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	out := make(map[string]string, len(keys))
+	if len(keys) == 0 {
+		return out, nil
+	}
+	values, err := s.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	for i, v := range values {
+		if str, ok := v.(string); ok {
+			out[strings.TrimPrefix(keys[i], prefix)] = str
+		}
+	}
+	return out, nil
+}
+
 func (s *redisStore) CreateApiKey(ctx context.Context, key *ApiKey) error {
 	if s == nil || s.client == nil {
 		return fmt.Errorf("redis store not configured")
@@ -626,6 +713,60 @@ func (s *redisStore) UpdateApiKeyEnabled(ctx context.Context, id int64, enabled
 	return nil
 }
 
+// maxApiKeyUpdateAttempts bounds the optimistic retries of UpdateApiKey when
+// another writer changes the key between the read and the write.
+const maxApiKeyUpdateAttempts = 10
+
+// UpdateApiKey applies update to API key id and saves it. The record is
+// watched while update runs, so concurrent updates of different fields do
+// not overwrite each other; a conflicting write makes it retry.
+func (s *redisStore) UpdateApiKey(ctx context.Context, id int64, update func(*ApiKey)) error {
+	if s == nil || s.client == nil {
+		return fmt.Errorf("redis store not configured")
+	}
+	if id == 0 {
+		return ErrNoRows
+	}
+	redisKey := s.apiKeysKey(id)
+	txf := func(tx *redis.Tx) error {
+		value, err := tx.Get(ctx, redisKey).Result()
+		if err == redis.Nil {
+			return ErrNoRows
+		}
+		if err != nil {
+			return err
+		}
+		var record apiKeyRecord
+		if err := json.Unmarshal([]byte(value), &record); err != nil {
+			return err
+		}
+		key := record.toApiKey()
+		if key.ID == 0 {
+			key.ID = id
+		}
+		update(key)
+		if key.LogResidency.IsZero() {
+			key.LogResidency = nil
+		}
+		data, err := json.Marshal(apiKeyRecordFromKey(key))
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, redisKey, data, 0)
+			return nil
+		})
+		return err
+	}
+	for attempt := 0; attempt < maxApiKeyUpdateAttempts; attempt++ {
+		err := s.client.Watch(ctx, txf, redisKey)
+		if err != redis.TxFailedErr {
+			return err
+		}
+	}
+	return fmt.Errorf("update api key %d: too many concurrent writes", id)
+}
+
 func (s *redisStore) UpdateApiKeyLastUsed(ctx context.Context, id int64) error {
 	if s == nil || s.client == nil {
 		return fmt.Errorf("redis store not configured")
@@ -831,29 +972,55 @@ func apiKeyRecordFromKey(key *ApiKey) apiKeyRecord {
 		return apiKeyRecord{}
 	}
 	return apiKeyRecord{
-		ID:         key.ID,
-		Name:       key.Name,
-		KeyHash:    key.KeyHash,
-		KeyFull:    "",
-		KeyPrefix:  key.KeyPrefix,
-		KeySuffix:  key.KeySuffix,
-		Enabled:    key.Enabled,
-		LastUsedAt: key.LastUsedAt,
-		CreatedAt:  key.CreatedAt,
+		ID:                key.ID,
+		Name:              key.Name,
+		KeyHash:           key.KeyHash,
+		KeyFull:           "",
+		KeyPrefix:         key.KeyPrefix,
+		KeySuffix:         key.KeySuffix,
+		Enabled:           key.Enabled,
+		SystemPrompt:      key.SystemPrompt,
+		AllowPinning:      key.AllowPinning,
+		TPMLimit:          key.TPMLimit,
+		ToolGateMaxChars:  key.ToolGateMaxChars,
+		SkipPromptHygiene: key.SkipPromptHygiene,
+		StrictModels:      key.StrictModels,
+		Description:       key.Description,
+		Owner:             key.Owner,
+		MonthlyTokenQuota: key.MonthlyTokenQuota,
+		ExpiresAt:         key.ExpiresAt,
+		AllowedIPs:        key.AllowedIPs,
+		TenantID:          key.TenantID,
+		LogResidency:      key.LogResidency,
+		LastUsedAt:        key.LastUsedAt,
+		CreatedAt:         key.CreatedAt,
 	}
 }
 
 func (r apiKeyRecord) toApiKey() *ApiKey {
 	return &ApiKey{
-		ID:         r.ID,
-		Name:       r.Name,
-		KeyHash:    r.KeyHash,
-		KeyFull:    r.KeyFull,
-		KeyPrefix:  r.KeyPrefix,
-		KeySuffix:  r.KeySuffix,
-		Enabled:    r.Enabled,
-		LastUsedAt: r.LastUsedAt,
-		CreatedAt:  r.CreatedAt,
+		ID:                r.ID,
+		Name:              r.Name,
+		KeyHash:           r.KeyHash,
+		KeyFull:           r.KeyFull,
+		KeyPrefix:         r.KeyPrefix,
+		KeySuffix:         r.KeySuffix,
+		Enabled:           r.Enabled,
+		SystemPrompt:      r.SystemPrompt,
+		AllowPinning:      r.AllowPinning,
+		TPMLimit:          r.TPMLimit,
+		ToolGateMaxChars:  r.ToolGateMaxChars,
+		SkipPromptHygiene: r.SkipPromptHygiene,
+		StrictModels:      r.StrictModels,
+		Description:       r.Description,
+		Owner:             r.Owner,
+		MonthlyTokenQuota: r.MonthlyTokenQuota,
+		ExpiresAt:         r.ExpiresAt,
+		AllowedIPs:        r.AllowedIPs,
+		TenantID:          r.TenantID,
+		LogResidency:      r.LogResidency,
+		LastUsedAt:        r.LastUsedAt,
+		CreatedAt:         r.CreatedAt,
 	}
 }
 
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"slices"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -12,33 +13,49 @@ import (
 var ErrNoRows = fmt.Errorf("no rows in result set")
 
 type Account struct {
-	ID            int64     `json:"id"`
-	Name          string    `json:"name"`
-	AccountType   string    `json:"account_type"`
-	NSFWEnabled   bool      `json:"nsfw_enabled"`
-	SessionID     string    `json:"session_id"`
-	ClientCookie  string    `json:"client_cookie"`
-	RefreshToken  string    `json:"refresh_token,omitempty"`
-	SessionCookie string    `json:"session_cookie"`
-	ClientUat     string    `json:"client_uat"`
-	ProjectID     string    `json:"project_id"`
-	UserID        string    `json:"user_id"`
-	AgentMode     string    `json:"agent_mode"`
-	Email         string    `json:"email"`
-	Weight        int       `json:"weight"`
-	Enabled       bool      `json:"enabled"`
-	Token         string    `json:"token"`        // Truncated display token
-	Subscription  string    `json:"subscription"` // "free", "pro", etc.
-	UsageCurrent  float64   `json:"usage_current"`
-	UsageTotal    float64   `json:"usage_total"` // Used as lifetime usage
-	UsageLimit    float64   `json:"usage_limit"` // Daily limit
-	StatusCode    string    `json:"status_code"`
-	LastAttempt   time.Time `json:"last_attempt"`
-	QuotaResetAt  time.Time `json:"quota_reset_at"`
-	RequestCount  int64     `json:"request_count"`
-	LastUsedAt    time.Time `json:"last_used_at"`
-	CreatedAt     time.Time `json:"created_at"`
-	UpdatedAt     time.Time `json:"updated_at"`
+	ID                int64             `json:"id"`
+	Name              string            `json:"name"`
+	AccountType       string            `json:"account_type"`
+	NSFWEnabled       bool              `json:"nsfw_enabled"`
+	SessionID         string            `json:"session_id"`
+	ClientCookie      string            `json:"client_cookie"`
+	RefreshToken      string            `json:"refresh_token,omitempty"`
+	SessionCookie     string            `json:"session_cookie"`
+	ClientUat         string            `json:"client_uat"`
+	ProjectID         string            `json:"project_id"`
+	UserID            string            `json:"user_id"`
+	AgentMode         string            `json:"agent_mode"`
+	Email             string            `json:"email"`
+	Weight            int               `json:"weight"`
+	MaxConcurrency    int               `json:"max_concurrency,omitempty"`     // Concurrent upstream requests cap (0 = global default)
+	TPMLimit          int               `json:"tpm_limit,omitempty"`           // Tokens per minute (0 = account_tpm_limit)
+	MaxRPM            int               `json:"max_rpm,omitempty"`             // Requests per minute pace (0 = account_max_rpm)
+	DailyRequestCap   int               `json:"daily_request_cap,omitempty"`   // Requests per UTC day (0 = unlimited)
+	MonthlyRequestCap int               `json:"monthly_request_cap,omitempty"` // Requests per month, see CapResetDay (0 = unlimited)
+	DailyTokenCap     int               `json:"daily_token_cap,omitempty"`     // Tokens per UTC day (0 = unlimited)
+	MonthlyTokenCap   int               `json:"monthly_token_cap,omitempty"`   // Tokens per month, see CapResetDay (0 = unlimited)
+	CapResetDay       int               `json:"cap_reset_day,omitempty"`       // Day of month (1-28) the monthly caps reset, e.g. the billing date (0 = calendar month)
+	Enabled           bool              `json:"enabled"`
+	Draining          bool              `json:"draining,omitempty"`         // Out of rotation until undrained; see SetAccountDraining
+	TenantID          int64             `json:"tenant_id,omitempty"`        // Owning tenant (0 = shared pool); see SetAccountTenant
+	Token             string            `json:"token"`                      // Truncated display token
+	TokenExpiresAt    *time.Time        `json:"token_expires_at,omitempty"` // exp claim of Token when it is a JWT; set on write
+	BaseURL           string            `json:"base_url,omitempty"`         // API-key channels: upstream endpoint
+	APIKey            string            `json:"api_key,omitempty"`          // API-key channels: upstream key
+	Headers           map[string]string `json:"headers,omitempty"`          // Extra upstream request headers
+	UserAgents        []string          `json:"user_agents,omitempty"`      // Rotated randomly per upstream request
+	Tags              []string          `json:"tags,omitempty"`             // Free-form labels for the admin UI and bulk actions
+	Subscription      string            `json:"subscription"`               // "free", "pro", etc.
+	UsageCurrent      float64           `json:"usage_current"`
+	UsageTotal        float64           `json:"usage_total"` // Used as lifetime usage
+	UsageLimit        float64           `json:"usage_limit"` // Daily limit
+	StatusCode        string            `json:"status_code"`
+	LastAttempt       time.Time         `json:"last_attempt"`
+	QuotaResetAt      time.Time         `json:"quota_reset_at"`
+	RequestCount      int64             `json:"request_count"`
+	LastUsedAt        time.Time         `json:"last_used_at"`
+	CreatedAt         time.Time         `json:"created_at"`
+	UpdatedAt         time.Time         `json:"updated_at"`
 }
 
 // SyncState compares this account against a snapshot and returns true if key session/auth fields differ.
@@ -61,22 +78,38 @@ type Settings struct {
 }
 
 type ApiKey struct {
-	ID         int64      `json:"id"`
-	Name       string     `json:"name"`
-	KeyHash    string     `json:"-"`
-	KeyFull    string     `json:"-"`
-	KeyPrefix  string     `json:"key_prefix"`
-	KeySuffix  string     `json:"key_suffix"`
-	Enabled    bool       `json:"enabled"`
-	LastUsedAt *time.Time `json:"last_used_at"`
-	CreatedAt  time.Time  `json:"created_at"`
+	ID                int64         `json:"id"`
+	Name              string        `json:"name"`
+	KeyHash           string        `json:"-"`
+	KeyFull           string        `json:"-"`
+	KeyPrefix         string        `json:"key_prefix"`
+	KeySuffix         string        `json:"key_suffix"`
+	Enabled           bool          `json:"enabled"`
+	SystemPrompt      string        `json:"system_prompt,omitempty"`
+	AllowPinning      bool          `json:"allow_pinning"`
+	TPMLimit          int           `json:"tpm_limit,omitempty"`           // Tokens per minute (0 = key_tpm_limit)
+	ToolGateMaxChars  int           `json:"tool_gate_max_chars,omitempty"` // Short-request tool gate (0 = tool_gate_max_chars, <0 = off)
+	SkipPromptHygiene bool          `json:"skip_prompt_hygiene,omitempty"` // Send history without prompt_hygiene rewrites
+	StrictModels      bool          `json:"strict_models,omitempty"`       // Reject unknown models instead of substituting
+	Description       string        `json:"description,omitempty"`
+	Owner             string        `json:"owner,omitempty"`
+	MonthlyTokenQuota int           `json:"monthly_token_quota,omitempty"` // Tokens per calendar month (0 = unlimited)
+	ExpiresAt         *time.Time    `json:"expires_at,omitempty"`          // Requests are rejected after this time
+	AllowedIPs        []string      `json:"allowed_ips,omitempty"`         // Client IPs or CIDRs (empty = any)
+	TenantID          int64         `json:"tenant_id,omitempty"`           // Owning tenant (0 = shared pool); see SetApiKeyTenant
+	LogResidency      *LogResidency `json:"log_residency,omitempty"`       // Where its debug and audit logs go
+	LastUsedAt        *time.Time    `json:"last_used_at"`
+	CreatedAt         time.Time     `json:"created_at"`
 }
 
 type Store struct {
-	accounts accountStore
-	settings settingsStore
-	apiKeys  apiKeyStore
-	models   modelStore
+	accounts    accountStore
+	settings    settingsStore
+	apiKeys     apiKeyStore
+	models      modelStore
+	channels    channelStore
+	tenants     tenantStore
+	experiments experimentStore
 }
 
 type Options struct {
@@ -97,11 +130,14 @@ type accountStore interface {
 	IncrementRequestCount(ctx context.Context, id int64) error
 	IncrementUsage(ctx context.Context, id int64, usage float64) error
 	IncrementAccountStats(ctx context.Context, id int64, usage float64, count int64) error
+	SetAccountDraining(ctx context.Context, id int64, draining bool) error
 }
 
 type settingsStore interface {
 	GetSetting(ctx context.Context, key string) (string, error)
 	SetSetting(ctx context.Context, key, value string) error
+	DeleteSetting(ctx context.Context, key string) error
+	ListSettings(ctx context.Context) (map[string]string, error)
 }
 
 type apiKeyStore interface {
@@ -109,6 +145,7 @@ type apiKeyStore interface {
 	ListApiKeys(ctx context.Context) ([]*ApiKey, error)
 	GetApiKeyByHash(ctx context.Context, hash string) (*ApiKey, error)
 	UpdateApiKeyEnabled(ctx context.Context, id int64, enabled bool) error
+	UpdateApiKey(ctx context.Context, id int64, update func(*ApiKey)) error
 	UpdateApiKeyLastUsed(ctx context.Context, id int64) error
 	DeleteApiKey(ctx context.Context, id int64) error
 	GetApiKeyByID(ctx context.Context, id int64) (*ApiKey, error)
@@ -141,6 +178,9 @@ func New(opts Options) (*Store, error) {
 	store.settings = redisStore
 	store.apiKeys = redisStore
 	store.models = redisStore
+	store.channels = redisStore
+	store.tenants = redisStore
+	store.experiments = redisStore
 	if err := store.seedModels(); err != nil {
 		slog.Warn("failed to seed models in redis", "error", err)
 	}
@@ -187,6 +227,12 @@ func (s *Store) seedModels() error {
 		{ID: "70", Channel: "Warp", ModelID: "warp-basic", Name: "Warp Basic", Status: ModelStatusAvailable, IsDefault: false, SortOrder: 22},
 		{ID: "87", Channel: "Warp", ModelID: "claude-4-6-sonnet-high", Name: "Claude 4.6 Sonnet High (Warp)", Status: ModelStatusAvailable, IsDefault: false, SortOrder: 23},
 		{ID: "88", Channel: "Warp", ModelID: "claude-4-6-sonnet-max", Name: "Claude 4.6 Sonnet Max (Warp)", Status: ModelStatusAvailable, IsDefault: false, SortOrder: 24},
+		// Kiro 模型
+		{ID: "110", Channel: "Kiro", ModelID: "kiro-claude-sonnet-4-5", Name: "Claude Sonnet 4.5 (Kiro)", Status: ModelStatusAvailable, IsDefault: true, SortOrder: 0},
+		{ID: "111", Channel: "Kiro", ModelID: "kiro-claude-opus-4-5", Name: "Claude Opus 4.5 (Kiro)", Status: ModelStatusAvailable, IsDefault: false, SortOrder: 1},
+		{ID: "112", Channel: "Kiro", ModelID: "kiro-claude-sonnet-4", Name: "Claude Sonnet 4 (Kiro)", Status: ModelStatusAvailable, IsDefault: false, SortOrder: 2},
+		{ID: "113", Channel: "Kiro", ModelID: "kiro-claude-haiku-4-5", Name: "Claude Haiku 4.5 (Kiro)", Status: ModelStatusAvailable, IsDefault: false, SortOrder: 3},
+		{ID: "114", Channel: "Kiro", ModelID: "kiro-auto", Name: "Kiro Auto", Status: ModelStatusAvailable, IsDefault: false, SortOrder: 4},
 		// Grok 模型
 		{ID: "90", Channel: "Grok", ModelID: "grok-3", Name: "Grok 3", Status: ModelStatusAvailable, IsDefault: true, SortOrder: 0},
 		{ID: "91", Channel: "Grok", ModelID: "grok-3-mini", Name: "Grok 3 Mini", Status: ModelStatusAvailable, IsDefault: false, SortOrder: 1},
@@ -266,6 +312,9 @@ func (s *Store) RedisPrefix() string {
 }
 
 func (s *Store) CreateAccount(ctx context.Context, acc *Account) error {
+	if tid, scoped := TenantFromContext(ctx); scoped {
+		acc.TenantID = tid
+	}
 	if s.accounts != nil {
 		return s.accounts.CreateAccount(ctx, acc)
 	}
@@ -273,6 +322,9 @@ func (s *Store) CreateAccount(ctx context.Context, acc *Account) error {
 }
 
 func (s *Store) UpdateAccount(ctx context.Context, acc *Account) error {
+	if err := s.checkAccountScope(ctx, acc.ID); err != nil {
+		return err
+	}
 	if s.accounts != nil {
 		return s.accounts.UpdateAccount(ctx, acc)
 	}
@@ -280,6 +332,9 @@ func (s *Store) UpdateAccount(ctx context.Context, acc *Account) error {
 }
 
 func (s *Store) DeleteAccount(ctx context.Context, id int64) error {
+	if err := s.checkAccountScope(ctx, id); err != nil {
+		return err
+	}
 	if s.accounts != nil {
 		return s.accounts.DeleteAccount(ctx, id)
 	}
@@ -288,25 +343,48 @@ func (s *Store) DeleteAccount(ctx context.Context, id int64) error {
 
 func (s *Store) GetAccount(ctx context.Context, id int64) (*Account, error) {
 	if s.accounts != nil {
-		return s.accounts.GetAccount(ctx, id)
+		acc, err := s.accounts.GetAccount(ctx, id)
+		if err == nil && acc != nil && !inScope(ctx, acc.TenantID) {
+			return nil, ErrNoRows
+		}
+		return acc, err
 	}
 	return nil, fmt.Errorf("store not configured")
 }
 
 func (s *Store) ListAccounts(ctx context.Context) ([]*Account, error) {
 	if s.accounts != nil {
-		return s.accounts.ListAccounts(ctx)
+		accounts, err := s.accounts.ListAccounts(ctx)
+		return scopeAccounts(ctx, accounts), err
 	}
 	return nil, fmt.Errorf("store not configured")
 }
 
 func (s *Store) GetEnabledAccounts(ctx context.Context) ([]*Account, error) {
 	if s.accounts != nil {
-		return s.accounts.GetEnabledAccounts(ctx)
+		accounts, err := s.accounts.GetEnabledAccounts(ctx)
+		return scopeAccounts(ctx, accounts), err
 	}
 	return nil, fmt.Errorf("store not configured")
 }
 
+func scopeAccounts(ctx context.Context, accounts []*Account) []*Account {
+	if _, scoped := TenantFromContext(ctx); !scoped {
+		return accounts
+	}
+	return slices.DeleteFunc(accounts, func(acc *Account) bool { return !inScope(ctx, acc.TenantID) })
+}
+
+// checkAccountScope returns ErrNoRows when a tenant-scoped ctx may not
+// change account id.
+func (s *Store) checkAccountScope(ctx context.Context, id int64) error {
+	if _, scoped := TenantFromContext(ctx); !scoped {
+		return nil
+	}
+	_, err := s.GetAccount(ctx, id)
+	return err
+}
+
 func (s *Store) IncrementRequestCount(ctx context.Context, id int64) error {
 	if s.accounts != nil {
 		return s.accounts.IncrementRequestCount(ctx, id)
@@ -314,6 +392,18 @@ func (s *Store) IncrementRequestCount(ctx context.Context, id int64) error {
 	return fmt.Errorf("store not configured")
 }
 
+// SetAccountDraining takes an account out of rotation (or puts it back)
+// without touching its other fields. UpdateAccount leaves the flag alone.
+func (s *Store) SetAccountDraining(ctx context.Context, id int64, draining bool) error {
+	if err := s.checkAccountScope(ctx, id); err != nil {
+		return err
+	}
+	if s.accounts != nil {
+		return s.accounts.SetAccountDraining(ctx, id, draining)
+	}
+	return fmt.Errorf("store not configured")
+}
+
 func (s *Store) IncrementAccountStats(ctx context.Context, id int64, usage float64, count int64) error {
 	if s.accounts != nil {
 		return s.accounts.IncrementAccountStats(ctx, id, usage, count)
@@ -335,7 +425,24 @@ func (s *Store) SetSetting(ctx context.Context, key, value string) error {
 	return fmt.Errorf("settings store not configured")
 }
 
+func (s *Store) DeleteSetting(ctx context.Context, key string) error {
+	if s.settings != nil {
+		return s.settings.DeleteSetting(ctx, key)
+	}
+	return fmt.Errorf("settings store not configured")
+}
+
+func (s *Store) ListSettings(ctx context.Context) (map[string]string, error) {
+	if s.settings != nil {
+		return s.settings.ListSettings(ctx)
+	}
+	return nil, fmt.Errorf("settings store not configured")
+}
+
 func (s *Store) CreateApiKey(ctx context.Context, key *ApiKey) error {
+	if tid, scoped := TenantFromContext(ctx); scoped {
+		key.TenantID = tid
+	}
 	if s.apiKeys != nil {
 		return s.apiKeys.CreateApiKey(ctx, key)
 	}
@@ -344,19 +451,51 @@ func (s *Store) CreateApiKey(ctx context.Context, key *ApiKey) error {
 
 func (s *Store) ListApiKeys(ctx context.Context) ([]*ApiKey, error) {
 	if s.apiKeys != nil {
-		return s.apiKeys.ListApiKeys(ctx)
+		keys, err := s.apiKeys.ListApiKeys(ctx)
+		if _, scoped := TenantFromContext(ctx); scoped {
+			keys = slices.DeleteFunc(keys, func(k *ApiKey) bool { return !inScope(ctx, k.TenantID) })
+		}
+		return keys, err
 	}
 	return nil, fmt.Errorf("api keys store not configured")
 }
 
 func (s *Store) UpdateApiKeyEnabled(ctx context.Context, id int64, enabled bool) error {
+	if err := s.checkApiKeyScope(ctx, id); err != nil {
+		return err
+	}
 	if s.apiKeys != nil {
 		return s.apiKeys.UpdateApiKeyEnabled(ctx, id, enabled)
 	}
 	return fmt.Errorf("api keys store not configured")
 }
 
+// UpdateApiKey changes API key id in one atomic read-modify-write.
+func (s *Store) UpdateApiKey(ctx context.Context, id int64, update func(*ApiKey)) error {
+	if err := s.checkApiKeyScope(ctx, id); err != nil {
+		return err
+	}
+	if s.apiKeys != nil {
+		return s.apiKeys.UpdateApiKey(ctx, id, update)
+	}
+	return fmt.Errorf("api keys store not configured")
+}
+
+func (s *Store) GetApiKeyByHash(ctx context.Context, hash string) (*ApiKey, error) {
+	if s.apiKeys != nil {
+		key, err := s.apiKeys.GetApiKeyByHash(ctx, hash)
+		if err == nil && key != nil && !inScope(ctx, key.TenantID) {
+			return nil, nil
+		}
+		return key, err
+	}
+	return nil, fmt.Errorf("api keys store not configured")
+}
+
 func (s *Store) DeleteApiKey(ctx context.Context, id int64) error {
+	if err := s.checkApiKeyScope(ctx, id); err != nil {
+		return err
+	}
 	if s.apiKeys != nil {
 		return s.apiKeys.DeleteApiKey(ctx, id)
 	}
@@ -365,14 +504,31 @@ func (s *Store) DeleteApiKey(ctx context.Context, id int64) error {
 
 func (s *Store) GetApiKeyByID(ctx context.Context, id int64) (*ApiKey, error) {
 	if s.apiKeys != nil {
-		return s.apiKeys.GetApiKeyByID(ctx, id)
+		key, err := s.apiKeys.GetApiKeyByID(ctx, id)
+		if err == nil && key != nil && !inScope(ctx, key.TenantID) {
+			return nil, ErrNoRows
+		}
+		return key, err
 	}
 	return nil, fmt.Errorf("api keys store not configured")
 }
 
+// checkApiKeyScope returns ErrNoRows when a tenant-scoped ctx may not change
+// API key id.
+func (s *Store) checkApiKeyScope(ctx context.Context, id int64) error {
+	if _, scoped := TenantFromContext(ctx); !scoped {
+		return nil
+	}
+	_, err := s.GetApiKeyByID(ctx, id)
+	return err
+}
+
 // Model wrappers
 
 func (s *Store) CreateModel(ctx context.Context, m *Model) error {
+	if _, scoped := TenantFromContext(ctx); scoped {
+		return ErrTenantScope
+	}
 	if s.models != nil {
 		if m.IsDefault {
 			models, err := s.models.ListModels(ctx)
@@ -391,6 +547,9 @@ func (s *Store) CreateModel(ctx context.Context, m *Model) error {
 }
 
 func (s *Store) UpdateModel(ctx context.Context, m *Model) error {
+	if _, scoped := TenantFromContext(ctx); scoped {
+		return ErrTenantScope
+	}
 	if s.models != nil {
 		if m.IsDefault {
 			models, err := s.models.ListModels(ctx)
@@ -409,6 +568,9 @@ func (s *Store) UpdateModel(ctx context.Context, m *Model) error {
 }
 
 func (s *Store) DeleteModel(ctx context.Context, id string) error {
+	if _, scoped := TenantFromContext(ctx); scoped {
+		return ErrTenantScope
+	}
 	if s.models != nil {
 		return s.models.DeleteModel(ctx, id)
 	}
@@ -417,21 +579,50 @@ func (s *Store) DeleteModel(ctx context.Context, id string) error {
 
 func (s *Store) GetModel(ctx context.Context, id string) (*Model, error) {
 	if s.models != nil {
-		return s.models.GetModel(ctx, id)
+		m, err := s.models.GetModel(ctx, id)
+		if err == nil && m != nil {
+			return s.modelInView(ctx, m)
+		}
+		return m, err
 	}
 	return nil, fmt.Errorf("models store not configured")
 }
 
 func (s *Store) GetModelByModelID(ctx context.Context, modelID string) (*Model, error) {
 	if s.models != nil {
-		return s.models.GetModelByModelID(ctx, modelID)
+		m, err := s.models.GetModelByModelID(ctx, modelID)
+		if err == nil && m != nil {
+			return s.modelInView(ctx, m)
+		}
+		return m, err
 	}
 	return nil, fmt.Errorf("models store not configured")
 }
 
 func (s *Store) ListModels(ctx context.Context) ([]*Model, error) {
 	if s.models != nil {
-		return s.models.ListModels(ctx)
+		models, err := s.models.ListModels(ctx)
+		if err != nil {
+			return nil, err
+		}
+		tenant, err := s.scopedTenant(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return slices.DeleteFunc(models, func(m *Model) bool { return !tenant.AllowsModel(m.ModelID) }), nil
 	}
 	return nil, fmt.Errorf("models store not configured")
 }
+
+// modelInView returns m, or ErrNoRows when it is outside the model view of
+// the tenant ctx is scoped to.
+func (s *Store) modelInView(ctx context.Context, m *Model) (*Model, error) {
+	tenant, err := s.scopedTenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !tenant.AllowsModel(m.ModelID) {
+		return nil, ErrNoRows
+	}
+	return m, nil
+}
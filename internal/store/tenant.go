@@ -0,0 +1,343 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrTenantScope is returned for writes a tenant-scoped context may not
+// make, such as changing the global model list.
+var ErrTenantScope = errors.New("not permitted for a tenant")
+
+// Tenant is an isolated team: it has its own accounts, API keys and admin
+// user, and optionally a monthly token quota and a restricted model list.
+// Accounts and keys without a tenant form the shared pool.
+type Tenant struct {
+	ID                int64         `json:"id"`
+	Name              string        `json:"name"`
+	Description       string        `json:"description,omitempty"`
+	Enabled           bool          `json:"enabled"`
+	AdminUser         string        `json:"admin_user,omitempty"`
+	AdminPassHash     string        `json:"-"`
+	MonthlyTokenQuota int           `json:"monthly_token_quota,omitempty"` // Tokens per calendar month across its keys (0 = unlimited)
+	Models            []string      `json:"models,omitempty"`              // Model IDs the tenant may see and use (empty = all)
+	LogResidency      *LogResidency `json:"log_residency,omitempty"`       // Where its debug and audit logs go
+	CreatedAt         time.Time     `json:"created_at"`
+	UpdatedAt         time.Time     `json:"updated_at"`
+}
+
+// AllowsModel reports whether modelID is in the tenant's model view.
+func (t *Tenant) AllowsModel(modelID string) bool {
+	if t == nil || len(t.Models) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(t.Models, func(m string) bool { return strings.EqualFold(m, modelID) })
+}
+
+type tenantStore interface {
+	CreateTenant(ctx context.Context, t *Tenant) error
+	UpdateTenant(ctx context.Context, t *Tenant) error
+	DeleteTenant(ctx context.Context, id int64) error
+	GetTenant(ctx context.Context, id int64) (*Tenant, error)
+	ListTenants(ctx context.Context) ([]*Tenant, error)
+	SetAccountTenant(ctx context.Context, id, tenantID int64) error
+	SetApiKeyTenant(ctx context.Context, id, tenantID int64) error
+}
+
+type tenantScopeKey struct{}
+
+type tenantScope struct {
+	id     int64
+	scoped bool
+}
+
+// WithTenant scopes store calls made with ctx to one tenant (0 = the shared
+// pool): only its accounts and API keys are listed, read, created or
+// changed, only its model view is listed, and models cannot be changed.
+func WithTenant(ctx context.Context, tenantID int64) context.Context {
+	return context.WithValue(ctx, tenantScopeKey{}, tenantScope{id: tenantID, scoped: true})
+}
+
+// WithoutTenant lifts a WithTenant scope, for internal callers that must
+// see every record.
+func WithoutTenant(ctx context.Context) context.Context {
+	return context.WithValue(ctx, tenantScopeKey{}, tenantScope{})
+}
+
+// TenantFromContext returns the tenant ctx is scoped to.
+func TenantFromContext(ctx context.Context) (int64, bool) {
+	s, _ := ctx.Value(tenantScopeKey{}).(tenantScope)
+	return s.id, s.scoped
+}
+
+// Tenant wrappers
+
+func (s *Store) CreateTenant(ctx context.Context, t *Tenant) error {
+	if _, scoped := TenantFromContext(ctx); scoped {
+		return ErrTenantScope
+	}
+	if s.tenants != nil {
+		return s.tenants.CreateTenant(ctx, t)
+	}
+	return fmt.Errorf("tenants store not configured")
+}
+
+func (s *Store) UpdateTenant(ctx context.Context, t *Tenant) error {
+	if _, scoped := TenantFromContext(ctx); scoped {
+		return ErrTenantScope
+	}
+	if s.tenants != nil {
+		return s.tenants.UpdateTenant(ctx, t)
+	}
+	return fmt.Errorf("tenants store not configured")
+}
+
+func (s *Store) DeleteTenant(ctx context.Context, id int64) error {
+	if _, scoped := TenantFromContext(ctx); scoped {
+		return ErrTenantScope
+	}
+	if s.tenants != nil {
+		return s.tenants.DeleteTenant(ctx, id)
+	}
+	return fmt.Errorf("tenants store not configured")
+}
+
+func (s *Store) GetTenant(ctx context.Context, id int64) (*Tenant, error) {
+	if tid, scoped := TenantFromContext(ctx); scoped && tid != id {
+		return nil, ErrNoRows
+	}
+	if s.tenants != nil {
+		return s.tenants.GetTenant(ctx, id)
+	}
+	return nil, fmt.Errorf("tenants store not configured")
+}
+
+func (s *Store) ListTenants(ctx context.Context) ([]*Tenant, error) {
+	if s.tenants == nil {
+		return nil, fmt.Errorf("tenants store not configured")
+	}
+	tenants, err := s.tenants.ListTenants(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if tid, scoped := TenantFromContext(ctx); scoped {
+		tenants = slices.DeleteFunc(tenants, func(t *Tenant) bool { return t.ID != tid })
+	}
+	return tenants, nil
+}
+
+// SetAccountTenant moves an account to a tenant (0 = the shared pool).
+func (s *Store) SetAccountTenant(ctx context.Context, id, tenantID int64) error {
+	if _, scoped := TenantFromContext(ctx); scoped {
+		return ErrTenantScope
+	}
+	if s.tenants != nil {
+		return s.tenants.SetAccountTenant(ctx, id, tenantID)
+	}
+	return fmt.Errorf("tenants store not configured")
+}
+
+// SetApiKeyTenant moves an API key to a tenant (0 = the shared pool).
+func (s *Store) SetApiKeyTenant(ctx context.Context, id, tenantID int64) error {
+	if _, scoped := TenantFromContext(ctx); scoped {
+		return ErrTenantScope
+	}
+	if s.tenants != nil {
+		return s.tenants.SetApiKeyTenant(ctx, id, tenantID)
+	}
+	return fmt.Errorf("tenants store not configured")
+}
+
+// scopedTenant loads the tenant ctx is scoped to, or nil when ctx is
+// unscoped or scoped to the shared pool.
+func (s *Store) scopedTenant(ctx context.Context) (*Tenant, error) {
+	tid, scoped := TenantFromContext(ctx)
+	if !scoped || tid == 0 || s.tenants == nil {
+		return nil, nil
+	}
+	return s.tenants.GetTenant(ctx, tid)
+}
+
+// inScope reports whether a record owned by tenantID is visible to ctx.
+func inScope(ctx context.Context, tenantID int64) bool {
+	tid, scoped := TenantFromContext(ctx)
+	return !scoped || tid == tenantID
+}
+
+// Redis implementation
+
+type tenantRecord struct {
+	Tenant
+	AdminPassHash string `json:"admin_pass_hash,omitempty"`
+}
+
+func (s *redisStore) CreateTenant(ctx context.Context, t *Tenant) error {
+	if s == nil || s.client == nil {
+		return fmt.Errorf("redis store not configured")
+	}
+	id, err := s.client.Incr(ctx, s.tenantsNextIDKey()).Result()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	t.ID = id
+	t.CreatedAt = now
+	t.UpdatedAt = now
+	data, err := json.Marshal(tenantRecord{Tenant: *t, AdminPassHash: t.AdminPassHash})
+	if err != nil {
+		return err
+	}
+	pipe := s.client.Pipeline()
+	pipe.Set(ctx, s.tenantsKey(id), data, 0)
+	pipe.SAdd(ctx, s.tenantsIDsKey(), id)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+func (s *redisStore) UpdateTenant(ctx context.Context, t *Tenant) error {
+	if s == nil || s.client == nil {
+		return fmt.Errorf("redis store not configured")
+	}
+	existing, err := s.GetTenant(ctx, t.ID)
+	if err != nil {
+		return err
+	}
+	t.CreatedAt = existing.CreatedAt
+	t.UpdatedAt = time.Now()
+	data, err := json.Marshal(tenantRecord{Tenant: *t, AdminPassHash: t.AdminPassHash})
+	if err != nil {
+		return err
+	}
+	return s.client.Set(ctx, s.tenantsKey(t.ID), data, 0).Err()
+}
+
+func (s *redisStore) DeleteTenant(ctx context.Context, id int64) error {
+	if s == nil || s.client == nil {
+		return fmt.Errorf("redis store not configured")
+	}
+	pipe := s.client.Pipeline()
+	pipe.Del(ctx, s.tenantsKey(id))
+	pipe.SRem(ctx, s.tenantsIDsKey(), id)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (s *redisStore) GetTenant(ctx context.Context, id int64) (*Tenant, error) {
+	if s == nil || s.client == nil {
+		return nil, fmt.Errorf("redis store not configured")
+	}
+	value, err := s.client.Get(ctx, s.tenantsKey(id)).Result()
+	if err == redis.Nil {
+		return nil, ErrNoRows
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeTenant(value)
+}
+
+func (s *redisStore) ListTenants(ctx context.Context) ([]*Tenant, error) {
+	if s == nil || s.client == nil {
+		return nil, fmt.Errorf("redis store not configured")
+	}
+	ids, err := s.client.SMembers(ctx, s.tenantsIDsKey()).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return []*Tenant{}, nil
+	}
+	slices.SortFunc(ids, func(a, b string) int {
+		x, _ := strconv.ParseInt(a, 10, 64)
+		y, _ := strconv.ParseInt(b, 10, 64)
+		return int(x - y)
+	})
+	keys := make([]string, 0, len(ids))
+	for _, id := range ids {
+		n, err := strconv.ParseInt(id, 10, 64)
+		if err != nil {
+			continue
+		}
+		keys = append(keys, s.tenantsKey(n))
+	}
+	values, err := s.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	tenants := make([]*Tenant, 0, len(values))
+	for _, value := range values {
+		str, ok := value.(string)
+		if !ok || str == "" {
+			continue
+		}
+		t, err := decodeTenant(str)
+		if err != nil {
+			continue
+		}
+		tenants = append(tenants, t)
+	}
+	return tenants, nil
+}
+
+func decodeTenant(value string) (*Tenant, error) {
+	var record tenantRecord
+	if err := json.Unmarshal([]byte(value), &record); err != nil {
+		return nil, err
+	}
+	t := record.Tenant
+	t.AdminPassHash = record.AdminPassHash
+	return &t, nil
+}
+
+func (s *redisStore) SetAccountTenant(ctx context.Context, id, tenantID int64) error {
+	if s == nil || s.client == nil {
+		return fmt.Errorf("redis store not configured")
+	}
+	acc, err := s.getAccount(ctx, id)
+	if err != nil {
+		return err
+	}
+	acc.TenantID = tenantID
+	acc.UpdatedAt = time.Now()
+	data, err := json.Marshal(acc)
+	if err != nil {
+		return err
+	}
+	return s.client.Set(ctx, s.accountsKey(id), data, 0).Err()
+}
+
+func (s *redisStore) SetApiKeyTenant(ctx context.Context, id, tenantID int64) error {
+	if s == nil || s.client == nil {
+		return fmt.Errorf("redis store not configured")
+	}
+	key, err := s.getApiKeyByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	key.TenantID = tenantID
+	data, err := json.Marshal(apiKeyRecordFromKey(key))
+	if err != nil {
+		return err
+	}
+	return s.client.Set(ctx, s.apiKeysKey(id), data, 0).Err()
+}
+
+func (s *redisStore) tenantsKey(id int64) string {
+	return fmt.Sprintf("%stenants:id:%d", s.prefix, id)
+}
+
+func (s *redisStore) tenantsIDsKey() string {
+	return s.prefix + "tenants:ids"
+}
+
+func (s *redisStore) tenantsNextIDKey() string {
+	return s.prefix + "tenants:next_id"
+}
@@ -0,0 +1,40 @@
+package store
+
+import (
+	"encoding/base64"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+// TokenExpiry returns the exp claim of a JWT access token, or nil when the
+// token is not a JWT or carries no expiry (e.g. opaque Kiro tokens).
+func TokenExpiry(token string) *time.Time {
+	parts := strings.Split(strings.TrimSpace(token), ".")
+	if len(parts) != 3 {
+		return nil
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil
+	}
+	var claims struct {
+		Exp json.Number `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil
+	}
+	exp, err := claims.Exp.Int64()
+	if err != nil || exp <= 0 {
+		return nil
+	}
+	t := time.Unix(exp, 0).UTC()
+	return &t
+}
+
+// TokenExpiresWithin reports whether the account's token has expired or will
+// within window.
+func (a *Account) TokenExpiresWithin(now time.Time, window time.Duration) bool {
+	return a != nil && a.TokenExpiresAt != nil && !now.Add(window).Before(*a.TokenExpiresAt)
+}
@@ -0,0 +1,33 @@
+package store
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+)
+
+func TestTokenExpiry(t *testing.T) {
+	jwt := func(payload string) string {
+		return "eyJhbGciOiJIUzI1NiJ9." + base64.RawURLEncoding.EncodeToString([]byte(payload)) + ".c2lnbmF0dXJl"
+	}
+	exp := TokenExpiry(jwt(`{"sub":"user_1","exp":1893456000}`))
+	if exp == nil || !exp.Equal(time.Unix(1893456000, 0)) {
+		t.Fatalf("TokenExpiry = %v", exp)
+	}
+	for _, tok := range []string{"", "aoaAAAAA-opaque", jwt(`{"sub":"user_1"}`), jwt(`not json`)} {
+		if exp := TokenExpiry(tok); exp != nil {
+			t.Fatalf("TokenExpiry(%q) = %v, want nil", tok, exp)
+		}
+	}
+
+	acc := &Account{TokenExpiresAt: exp}
+	if acc.TokenExpiresWithin(time.Unix(1893456000, 0).Add(-time.Hour), 10*time.Minute) {
+		t.Fatal("token an hour from expiry reported as expiring")
+	}
+	if !acc.TokenExpiresWithin(time.Unix(1893456000, 0).Add(-5*time.Minute), 10*time.Minute) {
+		t.Fatal("token five minutes from expiry not reported as expiring")
+	}
+	if (&Account{}).TokenExpiresWithin(time.Now(), time.Hour) {
+		t.Fatal("account without token expiry reported as expiring")
+	}
+}
@@ -1,8 +1,7 @@
 package tiktoken
 
 import (
-	"math"
-	"unicode"
+	"unicode/utf8"
 )
 
 // EstimateTokens 估算文本的 token 数量
@@ -107,40 +106,104 @@ func EstimateTextTokens(text string) int {
 	if text == "" {
 		return 0
 	}
+	var e Estimator
+	e.WriteString(text)
+	return e.Tokens()
+}
 
-	var tokens float64
-	inWord := false
+// Estimator 以增量方式计算 EstimateTextTokens：按流式增量依次写入，
+// Tokens() 始终等于对已写入全文调用 EstimateTextTokens 的结果，无需重新扫描。
+// 零值可直接使用，不做内存分配；非并发安全。
+type Estimator struct {
+	halves  int     // 已结束部分的 token 数 ×2（CJK 等非 ASCII 字符记 1.5）
+	inWord  bool    // 末尾是否处于未结束的 ASCII 单词中
+	pending [3]byte // 被切断在两次写入之间的 UTF-8 前缀
+	npend   int
+	n       int // 已写入字节数
+}
 
-	for _, r := range text {
-		if r < 128 {
-			if unicode.IsLetter(r) || unicode.IsNumber(r) {
-				if !inWord {
-					inWord = true
-				}
+// WriteString 追加文本。
+func (e *Estimator) WriteString(s string) {
+	e.n += len(s)
+	if e.npend > 0 {
+		s = e.completePending(s)
+	}
+	for i := 0; i < len(s); {
+		b := s[i]
+		if b < utf8.RuneSelf {
+			if (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z') || (b >= '0' && b <= '9') {
+				e.inWord = true
 			} else {
-				if inWord {
-					tokens += 1
-					inWord = false
+				if e.inWord {
+					e.halves += 2
+					e.inWord = false
 				}
-				if r != ' ' && r != '\t' && r != '\n' && r != '\r' {
-					tokens += 1
+				if b != ' ' && b != '\t' && b != '\n' && b != '\r' {
+					e.halves += 2
 				}
 			}
+			i++
 			continue
 		}
-
-		if inWord {
-			tokens += 1
-			inWord = false
+		if !utf8.FullRuneInString(s[i:]) {
+			e.npend = copy(e.pending[:], s[i:])
+			return
 		}
-		tokens += 1.5
+		_, size := utf8.DecodeRuneInString(s[i:])
+		e.nonASCII()
+		i += size
 	}
+}
 
-	if inWord {
-		tokens += 1
+// completePending 用 s 的开头补全上次写入末尾被切断的字符，返回剩余部分。
+func (e *Estimator) completePending(s string) string {
+	var buf [utf8.UTFMax]byte
+	n := copy(buf[:], e.pending[:e.npend])
+	n += copy(buf[n:], s)
+	if !utf8.FullRune(buf[:n]) {
+		e.npend = copy(e.pending[:], buf[:n])
+		return ""
+	}
+	_, size := utf8.DecodeRune(buf[:n])
+	e.nonASCII()
+	// 无效序列时 size 可能小于已缓存的字节数，剩余的缓存字节需要重新扫描
+	used := size - e.npend
+	if used < 0 {
+		rest := string(buf[size:e.npend])
+		e.npend = 0
+		e.n -= len(rest)
+		e.WriteString(rest)
+		return s
+	}
+	e.npend = 0
+	return s[used:]
+}
+
+func (e *Estimator) nonASCII() {
+	if e.inWord {
+		e.halves += 2
+		e.inWord = false
 	}
+	e.halves += 3
+}
+
+// Tokens 返回已写入文本的估算 token 数。
+func (e *Estimator) Tokens() int {
+	halves := e.halves + 3*e.npend
+	if e.inWord {
+		halves += 2
+	}
+	return (halves + 1) / 2
+}
+
+// Len 返回已写入的字节数。
+func (e *Estimator) Len() int {
+	return e.n
+}
 
-	return int(math.Round(tokens))
+// Reset 清空已写入的内容。
+func (e *Estimator) Reset() {
+	*e = Estimator{}
 }
 
 // IsCJK 判断是否是中日韩字符
@@ -1,6 +1,7 @@
 package tiktoken
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -154,3 +155,57 @@ func TestIsCJK(t *testing.T) {
 		})
 	}
 }
+
+func TestEstimatorMatchesEstimateTextTokens(t *testing.T) {
+	texts := []string{
+		"Hello, world! 你好世界 func main() { return x+1 }",
+		"emoji 😀 split, é accents\tand\nnewlines",
+		"invalid \xe4 and truncated \xf0\x9f utf-8 \x80 bytes",
+		"word你word😀word",
+	}
+	for _, text := range texts {
+		for step := 1; step <= 5; step++ {
+			var e Estimator
+			for i := 0; i < len(text); i += step {
+				e.WriteString(text[i:min(i+step, len(text))])
+				if got, want := e.Tokens(), EstimateTextTokens(text[:min(i+step, len(text))]); got != want {
+					t.Fatalf("%q step %d at %d: Tokens() = %d, want %d", text, step, i, got, want)
+				}
+			}
+			if e.Len() != len(text) {
+				t.Fatalf("%q: Len() = %d, want %d", text, e.Len(), len(text))
+			}
+		}
+	}
+
+	var e Estimator
+	e.WriteString("some text")
+	e.Reset()
+	if e.Tokens() != 0 || e.Len() != 0 {
+		t.Fatalf("Reset left %d tokens, %d bytes", e.Tokens(), e.Len())
+	}
+}
+
+// benchText is roughly 100k estimated tokens of mixed code, prose and CJK.
+var benchText = strings.Repeat("The quick brown fox jumps over the lazy dog. 你好世界，func main() { return x+1 }\n", 2500)
+
+func BenchmarkEstimateTextTokens(b *testing.B) {
+	b.SetBytes(int64(len(benchText)))
+	for i := 0; i < b.N; i++ {
+		EstimateTextTokens(benchText)
+	}
+}
+
+// BenchmarkEstimatorDeltas feeds a streamed reply in small deltas and reads
+// the running total after each, as the stream handler does.
+func BenchmarkEstimatorDeltas(b *testing.B) {
+	const delta = 24
+	b.SetBytes(int64(len(benchText)))
+	for i := 0; i < b.N; i++ {
+		var e Estimator
+		for j := 0; j < len(benchText); j += delta {
+			e.WriteString(benchText[j:min(j+delta, len(benchText))])
+			_ = e.Tokens()
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package tokencache
+
+import "sync/atomic"
+
+// HitCounter is implemented by caches that track lookup outcomes so the
+// admin API can report hit rates.
+type HitCounter interface {
+	HitStats() (hits, misses uint64)
+}
+
+// hitStats counts Get outcomes for the lifetime of the process (or until the
+// cache is cleared). Counts are per replica even when the backend is shared.
+type hitStats struct {
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+func (s *hitStats) record(hit bool) {
+	if hit {
+		s.hits.Add(1)
+	} else {
+		s.misses.Add(1)
+	}
+}
+
+func (s *hitStats) reset() {
+	s.hits.Store(0)
+	s.misses.Store(0)
+}
+
+func (s *hitStats) HitStats() (uint64, uint64) {
+	return s.hits.Load(), s.misses.Load()
+}
+
+// HitRate returns hits / (hits + misses), or 0 before any lookup.
+func HitRate(hits, misses uint64) float64 {
+	if hits+misses == 0 {
+		return 0
+	}
+	return float64(hits) / float64(hits+misses)
+}
@@ -1,12 +1,12 @@
 package tokencache
 
 import (
+	"container/list"
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"strings"
 	"sync"
-	"sync/atomic"
 	"time"
 )
 
@@ -18,35 +18,55 @@ type Cache interface {
 	SetTTL(ttl time.Duration)
 }
 
+// MemoryCache is an in-process LRU bounded by entry count and/or total
+// bytes. Entries are kept in a doubly-linked list ordered by recency, so
+// eviction is O(1) and independent of TTL (a zero TTL gives every entry the
+// same zero expiry, which cannot order anything).
 type MemoryCache struct {
-	mu          sync.RWMutex
-	ttl         time.Duration
-	maxEntries  int
-	items       map[string]cacheItem
-	sizeBytes   int64
-	done        chan struct{}
-	accessCount atomic.Uint64
+	mu         sync.Mutex
+	ttl        time.Duration
+	maxEntries int
+	maxBytes   int64
+	items      map[string]*list.Element
+	lru        *list.List // front = most recently used
+	sizeBytes  int64
+	done       chan struct{}
+	hitStats
 }
 
 type cacheItem struct {
-	tokens     int
-	expiresAt  time.Time
-	accessedAt time.Time
-	size       int64
+	key       string
+	tokens    int
+	expiresAt time.Time
+	size      int64
 }
 
 func NewMemoryCache(ttl time.Duration, maxEntries ...int) *MemoryCache {
-	if ttl < 0 {
-		ttl = 0
-	}
 	max := 0
 	if len(maxEntries) > 0 && maxEntries[0] > 0 {
 		max = maxEntries[0]
 	}
+	return NewMemoryCacheWithLimits(ttl, max, 0)
+}
+
+// NewMemoryCacheWithLimits creates a cache capped at maxEntries entries and
+// maxBytes estimated bytes; zero disables the respective limit.
+func NewMemoryCacheWithLimits(ttl time.Duration, maxEntries int, maxBytes int64) *MemoryCache {
+	if ttl < 0 {
+		ttl = 0
+	}
+	if maxEntries < 0 {
+		maxEntries = 0
+	}
+	if maxBytes < 0 {
+		maxBytes = 0
+	}
 	c := &MemoryCache{
 		ttl:        ttl,
-		maxEntries: max,
-		items:      make(map[string]cacheItem),
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		items:      make(map[string]*list.Element),
+		lru:        list.New(),
 		done:       make(chan struct{}),
 	}
 	// Start background cleanup
@@ -62,7 +82,13 @@ func CacheKey(strategy, model, text string) string {
 		hasher.Write([]byte(model))
 		hasher.Write([]byte{0})
 	}
-	hasher.Write([]byte(text))
+	// Hash in chunks so a large prompt is not copied whole into a []byte.
+	var buf [4096]byte
+	for text != "" {
+		n := copy(buf[:], text)
+		hasher.Write(buf[:n])
+		text = text[n:]
+	}
 	return hex.EncodeToString(hasher.Sum(nil))
 }
 
@@ -76,8 +102,7 @@ func (c *MemoryCache) SetTTL(ttl time.Duration) {
 	c.mu.Lock()
 	if c.ttl != ttl {
 		c.ttl = ttl
-		c.items = make(map[string]cacheItem)
-		c.sizeBytes = 0
+		c.resetLocked()
 	}
 	c.mu.Unlock()
 }
@@ -86,38 +111,24 @@ func (c *MemoryCache) Get(ctx context.Context, key string) (int, bool) {
 	if c == nil {
 		return 0, false
 	}
-	c.mu.RLock()
-	item, ok := c.items[key]
+	tokens, ok := c.get(key)
+	c.record(ok)
+	return tokens, ok
+}
+
+func (c *MemoryCache) get(key string) (int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.items[key]
 	if !ok {
-		c.mu.RUnlock()
 		return 0, false
 	}
-	if c.ttl > 0 && !item.expiresAt.IsZero() && time.Now().After(item.expiresAt) {
-		c.mu.RUnlock()
-		c.mu.Lock()
-		if current, ok := c.items[key]; ok {
-			if c.ttl > 0 && !current.expiresAt.IsZero() && time.Now().After(current.expiresAt) {
-				c.sizeBytes -= current.size
-				delete(c.items, key)
-			}
-		}
-		c.mu.Unlock()
+	item := elem.Value.(*cacheItem)
+	if c.expiredLocked(item, time.Now()) {
+		c.removeLocked(elem)
 		return 0, false
 	}
-	c.mu.RUnlock()
-
-	// Sampled LRU update: only update accessedAt ~12.5% of the time to avoid
-	// write-lock contention on every read. Approximate LRU ordering is
-	// sufficient for eviction decisions.
-	if c.accessCount.Add(1)%8 == 0 {
-		c.mu.Lock()
-		if item, ok := c.items[key]; ok {
-			item.accessedAt = time.Now()
-			c.items[key] = item
-		}
-		c.mu.Unlock()
-	}
-
+	c.lru.MoveToFront(elem)
 	return item.tokens, true
 }
 
@@ -125,45 +136,52 @@ func (c *MemoryCache) Put(ctx context.Context, key string, tokens int) {
 	if c == nil {
 		return
 	}
-	now := time.Now()
 	expiresAt := time.Time{}
 	if c.ttl > 0 {
-		expiresAt = now.Add(c.ttl)
+		expiresAt = time.Now().Add(c.ttl)
 	}
 	size := int64(len(key)) + 8
 	c.mu.Lock()
-	if existing, ok := c.items[key]; ok {
-		c.sizeBytes -= existing.size
-	} else if c.maxEntries > 0 && len(c.items) >= c.maxEntries {
-		c.evictLRULocked()
-	}
-	c.items[key] = cacheItem{
-		tokens:     tokens,
-		expiresAt:  expiresAt,
-		accessedAt: now,
-		size:       size,
-	}
-	c.sizeBytes += size
-	c.mu.Unlock()
+	defer c.mu.Unlock()
+	if c.maxBytes > 0 && size > c.maxBytes {
+		return
+	}
+	if elem, ok := c.items[key]; ok {
+		item := elem.Value.(*cacheItem)
+		c.sizeBytes += size - item.size
+		item.tokens, item.expiresAt, item.size = tokens, expiresAt, size
+		c.lru.MoveToFront(elem)
+	} else {
+		c.items[key] = c.lru.PushFront(&cacheItem{key: key, tokens: tokens, expiresAt: expiresAt, size: size})
+		c.sizeBytes += size
+	}
+	c.evictLocked()
 }
 
-func (c *MemoryCache) evictLRULocked() {
-	var lruKey string
-	var lruTime time.Time
-	first := true
-	for k, item := range c.items {
-		if first || item.accessedAt.Before(lruTime) {
-			lruKey = k
-			lruTime = item.accessedAt
-			first = false
-		}
-	}
-	if !first {
-		c.sizeBytes -= c.items[lruKey].size
-		delete(c.items, lruKey)
+// evictLocked drops least recently used entries until both limits hold.
+func (c *MemoryCache) evictLocked() {
+	for c.lru.Len() > 0 &&
+		((c.maxEntries > 0 && c.lru.Len() > c.maxEntries) || (c.maxBytes > 0 && c.sizeBytes > c.maxBytes)) {
+		c.removeLocked(c.lru.Back())
 	}
 }
 
+func (c *MemoryCache) removeLocked(elem *list.Element) {
+	item := c.lru.Remove(elem).(*cacheItem)
+	delete(c.items, item.key)
+	c.sizeBytes -= item.size
+}
+
+func (c *MemoryCache) resetLocked() {
+	c.items = make(map[string]*list.Element)
+	c.lru.Init()
+	c.sizeBytes = 0
+}
+
+func (c *MemoryCache) expiredLocked(item *cacheItem, now time.Time) bool {
+	return c.ttl > 0 && !item.expiresAt.IsZero() && now.After(item.expiresAt)
+}
+
 func (c *MemoryCache) GetStats(ctx context.Context) (int64, int64, error) {
 	if c == nil {
 		return 0, 0, nil
@@ -181,9 +199,9 @@ func (c *MemoryCache) Clear(ctx context.Context) error {
 		return nil
 	}
 	c.mu.Lock()
-	c.items = make(map[string]cacheItem)
-	c.sizeBytes = 0
+	c.resetLocked()
 	c.mu.Unlock()
+	c.reset()
 	return nil
 }
 
@@ -191,11 +209,12 @@ func (c *MemoryCache) pruneExpiredLocked(now time.Time) {
 	if c.ttl <= 0 {
 		return
 	}
-	for key, item := range c.items {
-		if !item.expiresAt.IsZero() && now.After(item.expiresAt) {
-			c.sizeBytes -= item.size
-			delete(c.items, key)
+	for elem := c.lru.Back(); elem != nil; {
+		prev := elem.Prev()
+		if c.expiredLocked(elem.Value.(*cacheItem), now) {
+			c.removeLocked(elem)
 		}
+		elem = prev
 	}
 }
 
@@ -2,6 +2,7 @@ package tokencache
 
 import (
 	"context"
+	"strings"
 	"testing"
 	"time"
 )
@@ -21,7 +22,7 @@ func TestLRUEviction(t *testing.T) {
 	time.Sleep(10 * time.Millisecond)
 	cache.Put(ctx, "key3", 300)
 
-	// Access key1 multiple times to ensure accessedAt is updated (sampled LRU updates 1-in-8)
+	// Access key1 so it becomes most recently used
 	time.Sleep(10 * time.Millisecond)
 	for i := 0; i < 8; i++ {
 		if val, ok := cache.Get(ctx, "key1"); !ok || val != 100 {
@@ -29,7 +30,7 @@ func TestLRUEviction(t *testing.T) {
 		}
 	}
 
-	// Access key2 multiple times to ensure accessedAt is updated
+	// Access key2 so it becomes most recently used
 	time.Sleep(10 * time.Millisecond)
 	for i := 0; i < 8; i++ {
 		if val, ok := cache.Get(ctx, "key2"); !ok || val != 200 {
@@ -89,3 +90,38 @@ func TestLRUEvictionWithoutAccess(t *testing.T) {
 		t.Errorf("Expected newest=3, got %v, %v", val, ok)
 	}
 }
+
+// TestMaxBytesEviction verifies the byte limit evicts in LRU order without
+// relying on timestamps (entries written in the same instant, TTL 0).
+func TestMaxBytesEviction(t *testing.T) {
+	ctx := context.Background()
+
+	// Each entry is len(key)+8 = 10 bytes; room for three.
+	cache := NewMemoryCacheWithLimits(0, 0, 30)
+	defer cache.Close()
+
+	cache.Put(ctx, "k1", 1)
+	cache.Put(ctx, "k2", 2)
+	cache.Put(ctx, "k3", 3)
+	cache.Get(ctx, "k1")
+	cache.Put(ctx, "k4", 4)
+
+	if _, ok := cache.Get(ctx, "k2"); ok {
+		t.Error("Expected k2 (least recently used) to be evicted")
+	}
+	for _, key := range []string{"k1", "k3", "k4"} {
+		if _, ok := cache.Get(ctx, key); !ok {
+			t.Errorf("Expected %s to remain", key)
+		}
+	}
+	count, size, _ := cache.GetStats(ctx)
+	if count != 3 || size != 30 {
+		t.Errorf("stats = %d entries / %d bytes, want 3 / 30", count, size)
+	}
+
+	// An entry larger than the whole budget is not cached at all.
+	cache.Put(ctx, strings.Repeat("x", 40), 5)
+	if count, _, _ := cache.GetStats(ctx); count != 3 {
+		t.Errorf("oversized entry should be skipped, count = %d", count)
+	}
+}
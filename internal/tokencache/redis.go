@@ -17,6 +17,7 @@ type RedisCache struct {
 	prefix string
 	mu     sync.RWMutex
 	ttl    time.Duration
+	hitStats
 }
 
 // NewRedisCache creates a new Redis-backed token cache.
@@ -39,6 +40,12 @@ func (c *RedisCache) Get(ctx context.Context, key string) (int, bool) {
 	if c == nil || c.client == nil {
 		return 0, false
 	}
+	tokens, ok := c.get(ctx, key)
+	c.record(ok)
+	return tokens, ok
+}
+
+func (c *RedisCache) get(ctx context.Context, key string) (int, bool) {
 	val, err := c.client.Get(ctx, c.key(key)).Result()
 	if err == redis.Nil {
 		return 0, false
@@ -120,6 +127,7 @@ func (c *RedisCache) Clear(ctx context.Context) error {
 			break
 		}
 	}
+	c.reset()
 	return nil
 }
 
@@ -161,3 +161,29 @@ func TestRedisCacheNilSafe(t *testing.T) {
 		t.Fatal("nil cache clear should not error")
 	}
 }
+
+func TestRedisCacheHitStats(t *testing.T) {
+	cache, _ := setupRedisCache(t, time.Minute)
+	ctx := context.Background()
+
+	cache.Get(ctx, "k")
+	cache.Put(ctx, "k", 7)
+	cache.Get(ctx, "k")
+	cache.Get(ctx, "k")
+
+	var counter HitCounter = cache
+	hits, misses := counter.HitStats()
+	if hits != 2 || misses != 1 {
+		t.Fatalf("hits=%d misses=%d, want 2/1", hits, misses)
+	}
+	if rate := HitRate(hits, misses); rate < 0.66 || rate > 0.67 {
+		t.Fatalf("hit rate = %v", rate)
+	}
+
+	if err := cache.Clear(ctx); err != nil {
+		t.Fatalf("Clear: %v", err)
+	}
+	if hits, misses = cache.HitStats(); hits != 0 || misses != 0 {
+		t.Fatalf("Clear should reset counters, got %d/%d", hits, misses)
+	}
+}
@@ -0,0 +1,156 @@
+package upstream
+
+import "github.com/goccy/go-json"
+
+// Typed views of the model.* events every upstream client emits. Providers
+// still produce SSEMessage maps; consumers decode through the As* helpers so
+// field names and number types are handled in one place.
+
+// TextDelta is a model.text-delta event.
+type TextDelta struct {
+	Delta string
+}
+
+// ReasoningDelta is a model.reasoning-delta (or coding_agent.reasoning.chunk) event.
+type ReasoningDelta struct {
+	Delta string
+}
+
+// ToolInputStart is a model.tool-input-start event.
+type ToolInputStart struct {
+	ID       string
+	ToolName string
+}
+
+// ToolInputDelta is a model.tool-input-delta event carrying a JSON fragment.
+type ToolInputDelta struct {
+	ID    string
+	Delta string
+}
+
+// ToolInputEnd is a model.tool-input-end event.
+type ToolInputEnd struct {
+	ID string
+}
+
+// ToolCall is a complete model.tool-call event; Input is a JSON string.
+type ToolCall struct {
+	ID    string
+	Name  string
+	Input string
+}
+
+// Usage holds token counts reported by the upstream. HasInput/HasOutput tell
+// a reported zero apart from a missing field.
+type Usage struct {
+	InputTokens  int
+	OutputTokens int
+	HasInput     bool
+	HasOutput    bool
+}
+
+// TokensUsed is a model.tokens-used event.
+type TokensUsed struct {
+	Usage Usage
+}
+
+// Finish is a model.finish event. Reason is the upstream finish reason as-is
+// ("stop", "end_turn", "tool-calls", "tool_use", ...).
+type Finish struct {
+	Reason       string
+	StopSequence string // the stop sequence matched when Reason is "stop_sequence"
+	Usage        Usage
+}
+
+// EventKey returns the normalized event name: "model" messages carrying an
+// inner type become "model.<type>", everything else keeps its Type.
+func (m SSEMessage) EventKey() string {
+	if m.Type == "model" && m.Event != nil {
+		if evtType, ok := m.Event["type"].(string); ok {
+			return "model." + evtType
+		}
+	}
+	return m.Type
+}
+
+func (m SSEMessage) AsTextDelta() TextDelta {
+	return TextDelta{Delta: eventString(m.Event, "delta")}
+}
+
+func (m SSEMessage) AsReasoningDelta() ReasoningDelta {
+	if delta, ok := m.Event["delta"].(string); ok {
+		return ReasoningDelta{Delta: delta}
+	}
+	// coding_agent.reasoning.chunk nests the text under data.
+	data, _ := m.Event["data"].(map[string]interface{})
+	return ReasoningDelta{Delta: eventString(data, "text")}
+}
+
+func (m SSEMessage) AsToolInputStart() ToolInputStart {
+	return ToolInputStart{ID: eventString(m.Event, "id"), ToolName: eventString(m.Event, "toolName")}
+}
+
+func (m SSEMessage) AsToolInputDelta() ToolInputDelta {
+	return ToolInputDelta{ID: eventString(m.Event, "id"), Delta: eventString(m.Event, "delta")}
+}
+
+func (m SSEMessage) AsToolInputEnd() ToolInputEnd {
+	return ToolInputEnd{ID: eventString(m.Event, "id")}
+}
+
+func (m SSEMessage) AsToolCall() ToolCall {
+	return ToolCall{
+		ID:    eventString(m.Event, "toolCallId"),
+		Name:  eventString(m.Event, "toolName"),
+		Input: eventString(m.Event, "input"),
+	}
+}
+
+// AsTokensUsed reads the usage counters from the event itself.
+func (m SSEMessage) AsTokensUsed() TokensUsed {
+	return TokensUsed{Usage: decodeUsage(m.Event)}
+}
+
+func (m SSEMessage) AsFinish() Finish {
+	usage, _ := m.Event["usage"].(map[string]interface{})
+	return Finish{Reason: eventString(m.Event, "finishReason"), StopSequence: eventString(m.Event, "stopSequence"), Usage: decodeUsage(usage)}
+}
+
+func eventString(event map[string]interface{}, key string) string {
+	s, _ := event[key].(string)
+	return s
+}
+
+// decodeUsage accepts both camelCase (internal) and snake_case (Anthropic)
+// keys and any numeric representation a JSON decoder may have produced.
+func decodeUsage(usage map[string]interface{}) Usage {
+	var u Usage
+	if usage == nil {
+		return u
+	}
+	u.InputTokens, u.HasInput = usageInt(usage, "inputTokens")
+	if !u.HasInput {
+		u.InputTokens, u.HasInput = usageInt(usage, "input_tokens")
+	}
+	u.OutputTokens, u.HasOutput = usageInt(usage, "outputTokens")
+	if !u.HasOutput {
+		u.OutputTokens, u.HasOutput = usageInt(usage, "output_tokens")
+	}
+	return u
+}
+
+func usageInt(usage map[string]interface{}, key string) (int, bool) {
+	switch v := usage[key].(type) {
+	case float64:
+		return int(v), true
+	case int:
+		return v, true
+	case int64:
+		return int(v), true
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return int(n), true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,70 @@
+package upstream
+
+import (
+	"testing"
+
+	"github.com/goccy/go-json"
+)
+
+func TestEventKey(t *testing.T) {
+	cases := []struct {
+		msg  SSEMessage
+		want string
+	}{
+		{SSEMessage{Type: "model", Event: map[string]interface{}{"type": "text-delta"}}, "model.text-delta"},
+		{SSEMessage{Type: "model.finish", Event: map[string]interface{}{}}, "model.finish"},
+		{SSEMessage{Type: "model"}, "model"},
+		{SSEMessage{Type: "fs_operation", Event: map[string]interface{}{"type": "x"}}, "fs_operation"},
+	}
+	for _, tc := range cases {
+		if got := tc.msg.EventKey(); got != tc.want {
+			t.Fatalf("EventKey(%+v) = %q, want %q", tc.msg, got, tc.want)
+		}
+	}
+}
+
+func TestAsReasoningDeltaShapes(t *testing.T) {
+	flat := SSEMessage{Type: "model.reasoning-delta", Event: map[string]interface{}{"delta": "a"}}
+	nested := SSEMessage{Type: "coding_agent.reasoning.chunk", Event: map[string]interface{}{"data": map[string]interface{}{"text": "b"}}}
+	if got := flat.AsReasoningDelta().Delta; got != "a" {
+		t.Fatalf("flat delta = %q", got)
+	}
+	if got := nested.AsReasoningDelta().Delta; got != "b" {
+		t.Fatalf("nested delta = %q", got)
+	}
+}
+
+func TestAsFinishUsage(t *testing.T) {
+	finish := SSEMessage{Type: "model.finish", Event: map[string]interface{}{
+		"finishReason": "tool_use",
+		"usage":        map[string]interface{}{"input_tokens": float64(12), "outputTokens": json.Number("5")},
+	}}.AsFinish()
+	if finish.Reason != "tool_use" {
+		t.Fatalf("reason = %q", finish.Reason)
+	}
+	if u := finish.Usage; !u.HasInput || !u.HasOutput || u.InputTokens != 12 || u.OutputTokens != 5 {
+		t.Fatalf("usage = %+v", u)
+	}
+
+	empty := SSEMessage{Type: "model.finish", Event: map[string]interface{}{}}.AsFinish()
+	if empty.Usage.HasInput || empty.Usage.HasOutput {
+		t.Fatalf("missing usage should not be reported: %+v", empty.Usage)
+	}
+
+	used := SSEMessage{Type: "model", Event: map[string]interface{}{"type": "tokens-used", "outputTokens": 0}}.AsTokensUsed()
+	if used.Usage.HasInput || !used.Usage.HasOutput || used.Usage.OutputTokens != 0 {
+		t.Fatalf("tokens-used = %+v", used.Usage)
+	}
+}
+
+func TestAsToolCall(t *testing.T) {
+	call := SSEMessage{Type: "model.tool-call", Event: map[string]interface{}{
+		"toolCallId": "t1", "toolName": "Read", "input": `{"path":"a"}`,
+	}}.AsToolCall()
+	if call.ID != "t1" || call.Name != "Read" || call.Input != `{"path":"a"}` {
+		t.Fatalf("tool call = %+v", call)
+	}
+	if got := (SSEMessage{Type: "model.tool-call"}).AsToolCall(); got != (ToolCall{}) {
+		t.Fatalf("nil event should decode to zero value, got %+v", got)
+	}
+}
@@ -0,0 +1,78 @@
+package upstream
+
+import (
+	"context"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+
+	"orchids-api/internal/middleware"
+	"orchids-api/internal/store"
+)
+
+// protectedHeaders are managed by net/http and cannot be overridden per account.
+var protectedHeaders = map[string]bool{
+	"Host":              true,
+	"Content-Length":    true,
+	"Connection":        true,
+	"Transfer-Encoding": true,
+}
+
+// ApplyAccountHeaders overlays an account's custom headers onto an upstream
+// request and, when the account lists User-Agents, sets one picked at random
+// so consecutive requests do not share a fingerprint. Account values win over
+// the client's defaults; an empty header value removes the header.
+func ApplyAccountHeaders(h http.Header, acc *store.Account) {
+	if h == nil || acc == nil {
+		return
+	}
+	for name, value := range acc.Headers {
+		name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+		if name == "" || protectedHeaders[name] {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		if value == "" {
+			h.Del(name)
+			continue
+		}
+		h.Set(name, value)
+	}
+	if ua := PickUserAgent(acc.UserAgents); ua != "" {
+		h.Set("User-Agent", ua)
+	}
+}
+
+// ApplyTraceHeaders forwards the traceparent, tracestate and X-Request-ID the
+// client sent (see middleware.TraceMiddleware) so the upstream call can be
+// correlated with the caller's trace. Headers the client did not send are left
+// alone.
+func ApplyTraceHeaders(ctx context.Context, h http.Header) {
+	if h == nil {
+		return
+	}
+	th := middleware.GetTraceHeaders(ctx)
+	if th.Traceparent != "" {
+		h.Set(middleware.TraceparentHeader, th.Traceparent)
+		if th.Tracestate != "" {
+			h.Set(middleware.TracestateHeader, th.Tracestate)
+		}
+	}
+	if th.RequestID != "" {
+		h.Set(middleware.RequestIDHeader, th.RequestID)
+	}
+}
+
+// PickUserAgent returns a random non-empty entry, or "" when there is none.
+func PickUserAgent(agents []string) string {
+	candidates := make([]string, 0, len(agents))
+	for _, ua := range agents {
+		if ua = strings.TrimSpace(ua); ua != "" {
+			candidates = append(candidates, ua)
+		}
+	}
+	if len(candidates) == 0 {
+		return ""
+	}
+	return candidates[rand.IntN(len(candidates))]
+}
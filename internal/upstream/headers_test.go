@@ -0,0 +1,70 @@
+package upstream
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"orchids-api/internal/middleware"
+	"orchids-api/internal/store"
+)
+
+func TestApplyAccountHeaders(t *testing.T) {
+	h := http.Header{}
+	h.Set("User-Agent", "default")
+	h.Set("Origin", "https://example.com")
+	h.Set("Content-Length", "10")
+
+	acc := &store.Account{
+		Headers: map[string]string{
+			"accept-language": " en-US ",
+			"Origin":          "",
+			"content-length":  "99",
+		},
+		UserAgents: []string{" ", "ua-a", "ua-b"},
+	}
+	ApplyAccountHeaders(h, acc)
+
+	if got := h.Get("Accept-Language"); got != "en-US" {
+		t.Fatalf("Accept-Language = %q", got)
+	}
+	if _, ok := h["Origin"]; ok {
+		t.Fatalf("empty value should remove the header: %v", h)
+	}
+	if got := h.Get("Content-Length"); got != "10" {
+		t.Fatalf("protected header overridden: %q", got)
+	}
+	if ua := h.Get("User-Agent"); ua != "ua-a" && ua != "ua-b" {
+		t.Fatalf("User-Agent = %q", ua)
+	}
+
+	seen := map[string]bool{}
+	for i := 0; i < 200; i++ {
+		seen[PickUserAgent(acc.UserAgents)] = true
+	}
+	if len(seen) != 2 {
+		t.Fatalf("expected rotation across both agents, got %v", seen)
+	}
+	if PickUserAgent([]string{"", " "}) != "" {
+		t.Fatal("blank agents should yield no override")
+	}
+}
+
+func TestApplyTraceHeaders(t *testing.T) {
+	h := http.Header{}
+	ApplyTraceHeaders(context.Background(), h)
+	if len(h) != 0 {
+		t.Fatalf("no trace context should add no headers: %v", h)
+	}
+
+	ctx := middleware.WithTraceHeaders(context.Background(), middleware.TraceHeaders{
+		Traceparent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		Tracestate:  "vendor=abc",
+		RequestID:   "req-1",
+	})
+	ApplyTraceHeaders(ctx, h)
+	if h.Get("traceparent") != "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01" ||
+		h.Get("tracestate") != "vendor=abc" || h.Get("X-Request-ID") != "req-1" {
+		t.Fatalf("headers = %v", h)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"time"
 
 	"github.com/sony/gobreaker"
+
+	"orchids-api/internal/events"
 )
 
 // CircuitBreaker wraps gobreaker with sensible defaults for API calls.
@@ -47,6 +49,7 @@ func NewCircuitBreaker(cfg CircuitBreakerConfig) *CircuitBreaker {
 			failureRatio := float64(counts.TotalFailures) / float64(counts.Requests)
 			return failureRatio >= cfg.FailureRatio
 		},
+		OnStateChange: publishBreakerState,
 	}
 	return &CircuitBreaker{
 		cb: gobreaker.NewCircuitBreaker(settings),
@@ -57,3 +60,15 @@ func NewCircuitBreaker(cfg CircuitBreakerConfig) *CircuitBreaker {
 func (c *CircuitBreaker) Execute(fn func() (interface{}, error)) (interface{}, error) {
 	return c.cb.Execute(fn)
 }
+
+// publishBreakerState notifies the admin UI when a breaker opens and when it
+// closes again.
+func publishBreakerState(name string, from, to gobreaker.State) {
+	data := map[string]interface{}{"breaker": name, "from": from.String()}
+	switch {
+	case to == gobreaker.StateOpen:
+		events.Publish(events.BreakerOpened, events.LevelWarning, "circuit breaker "+name+" opened", data)
+	case to == gobreaker.StateClosed && from != gobreaker.StateClosed:
+		events.Publish(events.BreakerClosed, events.LevelInfo, "circuit breaker "+name+" closed", data)
+	}
+}
@@ -0,0 +1,107 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+)
+
+// ErrResponseTooLarge is returned once a request has received more than the
+// meter's limit from upstream; it is not worth retrying.
+var ErrResponseTooLarge = errors.New("upstream response too large")
+
+// Meter counts the bytes a client request sends to and receives from
+// upstream across all its attempts, and optionally caps the bytes received so
+// a runaway stream is cut off before it is buffered into the response.
+type Meter struct {
+	limit    int64 // max bytes received, 0 = unlimited
+	sent     atomic.Int64
+	received atomic.Int64
+}
+
+// NewMeter returns a Meter that fails reads once more than limit bytes have
+// been received; limit <= 0 disables the cap.
+func NewMeter(limit int64) *Meter {
+	if limit < 0 {
+		limit = 0
+	}
+	return &Meter{limit: limit}
+}
+
+// Sent returns the bytes of request payloads sent so far.
+func (m *Meter) Sent() int64 { return m.sent.Load() }
+
+// Received returns the bytes of upstream responses received so far.
+func (m *Meter) Received() int64 { return m.received.Load() }
+
+type meterKey struct{}
+
+// WithMeter attaches m to ctx so upstream clients report their traffic to it.
+func WithMeter(ctx context.Context, m *Meter) context.Context {
+	return context.WithValue(ctx, meterKey{}, m)
+}
+
+// MeterFrom returns the Meter attached to ctx, or nil.
+func MeterFrom(ctx context.Context) *Meter {
+	m, _ := ctx.Value(meterKey{}).(*Meter)
+	return m
+}
+
+// MeterSent records an upstream request payload of n bytes.
+func MeterSent(ctx context.Context, n int) {
+	if m := MeterFrom(ctx); m != nil {
+		m.sent.Add(int64(n))
+	}
+}
+
+// MeterReceived records n bytes read from upstream, for transports that don't
+// expose a body reader (WebSocket messages). It returns ErrResponseTooLarge
+// once the cap is exceeded.
+func MeterReceived(ctx context.Context, n int) error {
+	m := MeterFrom(ctx)
+	if m == nil {
+		return nil
+	}
+	return m.add(n)
+}
+
+// MeterBody wraps an upstream response body so the bytes read from it are
+// counted, failing with ErrResponseTooLarge once the cap is exceeded. It
+// returns body unchanged when ctx carries no Meter.
+func MeterBody(ctx context.Context, body io.Reader) io.Reader {
+	m := MeterFrom(ctx)
+	if m == nil {
+		return body
+	}
+	return &meteredReader{r: body, m: m}
+}
+
+func (m *Meter) add(n int) error {
+	total := m.received.Add(int64(n))
+	if m.limit > 0 && total > m.limit {
+		return fmt.Errorf("%w: received %d bytes, limit is %d", ErrResponseTooLarge, total, m.limit)
+	}
+	return nil
+}
+
+type meteredReader struct {
+	r   io.Reader
+	m   *Meter
+	err error
+}
+
+func (r *meteredReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if capErr := r.m.add(n); capErr != nil {
+			r.err = capErr
+			return 0, capErr
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,50 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestMeterBody(t *testing.T) {
+	body := strings.NewReader(strings.Repeat("x", 100))
+	if got := MeterBody(context.Background(), body); got != io.Reader(body) {
+		t.Fatal("body without a meter should be returned as is")
+	}
+
+	m := NewMeter(0)
+	ctx := WithMeter(context.Background(), m)
+	MeterSent(ctx, 42)
+	n, err := io.Copy(io.Discard, MeterBody(ctx, strings.NewReader(strings.Repeat("x", 100))))
+	if err != nil || n != 100 {
+		t.Fatalf("copy = %d, %v", n, err)
+	}
+	if m.Sent() != 42 || m.Received() != 100 {
+		t.Fatalf("sent=%d received=%d", m.Sent(), m.Received())
+	}
+}
+
+func TestMeterLimit(t *testing.T) {
+	m := NewMeter(150)
+	ctx := WithMeter(context.Background(), m)
+	// The limit spans every response of the request.
+	if _, err := io.Copy(io.Discard, MeterBody(ctx, strings.NewReader(strings.Repeat("x", 100)))); err != nil {
+		t.Fatalf("first body: %v", err)
+	}
+	r := MeterBody(ctx, strings.NewReader(strings.Repeat("x", 100)))
+	if _, err := io.Copy(io.Discard, r); !errors.Is(err, ErrResponseTooLarge) {
+		t.Fatalf("second body err = %v, want ErrResponseTooLarge", err)
+	}
+	// The reader stays failed.
+	if _, err := r.Read(make([]byte, 1)); !errors.Is(err, ErrResponseTooLarge) {
+		t.Fatalf("read after cap err = %v", err)
+	}
+	if err := MeterReceived(ctx, 1); !errors.Is(err, ErrResponseTooLarge) {
+		t.Fatalf("MeterReceived err = %v", err)
+	}
+	if err := MeterReceived(context.Background(), 1<<30); err != nil {
+		t.Fatalf("MeterReceived without meter: %v", err)
+	}
+}
@@ -12,9 +12,19 @@ type UpstreamRequest struct {
 	Tools         []interface{}
 	NoTools       bool
 	NoThinking    bool
+	MaxTokens     int // Client-requested max_tokens (passthrough channels only)
 	ChatSessionID string
 	Workdir       string // Dynamic local workdir override
-	ProjectID     string
+	ProjectID     string // Per-request Orchids project override
+	AgentMode     string // Per-request Orchids agent mode override
+
+	// Client-requested sampling and control fields (passthrough channels only)
+	ToolChoice    interface{}
+	Temperature   *float64
+	TopP          *float64
+	StopSequences []string
+	Thinking      interface{}
+	UserID        string // metadata.user_id
 }
 
 // SSEMessage 统一上游 SSE 消息结构（Warp/Orchids 复用）
@@ -67,6 +67,20 @@ func NewFromAccount(acc *store.Account, cfg *config.Config) *Client {
 	}
 }
 
+// AIURL returns the multi-agent endpoint for a configured base URL, e.g. a
+// self-hosted Warp relay. An empty base URL means the default endpoint; the
+// full endpoint is also accepted.
+func AIURL(baseURL string) string {
+	base := strings.TrimRight(strings.TrimSpace(baseURL), "/")
+	if base == "" {
+		return aiURL
+	}
+	if strings.HasSuffix(base, aiPath) {
+		return base
+	}
+	return base + aiPath
+}
+
 const defaultRequestTimeout = 120 * time.Second
 
 func newHTTPClient(timeout time.Duration, cfg *config.Config) *http.Client {
@@ -189,7 +203,11 @@ func (c *Client) SendRequestWithPayload(ctx context.Context, req upstream.Upstre
 		}
 	}
 
-	request, err := http.NewRequestWithContext(ctx, http.MethodPost, aiURL, bytes.NewReader(payload))
+	endpoint := aiURL
+	if c.account != nil {
+		endpoint = AIURL(c.account.BaseURL)
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(payload))
 	if err != nil {
 		return err
 	}
@@ -205,13 +223,16 @@ func (c *Client) SendRequestWithPayload(ctx context.Context, req upstream.Upstre
 	request.Header.Set("content-length", fmt.Sprintf("%d", len(payload)))
 	// 避免 Go 默认注入 User-Agent
 	request.Header.Set("user-agent", "")
+	upstream.ApplyAccountHeaders(request.Header, c.account)
+	upstream.ApplyTraceHeaders(ctx, request.Header)
+	upstream.MeterSent(ctx, len(payload))
 
 	if logger != nil {
 		headers := make(map[string]string)
 		for k, v := range request.Header {
 			headers[k] = strings.Join(v, ", ")
 		}
-		logger.LogUpstreamRequest(aiURL, headers, payload)
+		logger.LogUpstreamRequest(endpoint, headers, payload)
 	}
 
 	breaker := upstream.GetAccountBreaker(c.breakerKey())
@@ -222,7 +243,7 @@ func (c *Client) SendRequestWithPayload(ctx context.Context, req upstream.Upstre
 		for k, v := range request.Header {
 			reqHeaders[k] = strings.Join(v, ", ")
 		}
-		slog.Debug("Warp AI: Dispatching request", "url", aiURL, "headers", reqHeaders, "body_size", len(payload))
+		slog.Debug("Warp AI: Dispatching request", "url", endpoint, "headers", reqHeaders, "body_size", len(payload))
 	}
 
 	result, err := breaker.Execute(func() (interface{}, error) {
@@ -246,7 +267,7 @@ func (c *Client) SendRequestWithPayload(ctx context.Context, req upstream.Upstre
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
 		if logger != nil {
-			logger.LogUpstreamHTTPError(aiURL, resp.StatusCode, string(body), nil)
+			logger.LogUpstreamHTTPError(endpoint, resp.StatusCode, string(body), nil)
 		}
 		headerLog := make(map[string]string)
 		for k, v := range resp.Header {
@@ -266,7 +287,7 @@ func (c *Client) SendRequestWithPayload(ctx context.Context, req upstream.Upstre
 		defer reader.Close()
 	}
 
-	bufReader := bufio.NewReader(reader)
+	bufReader := bufio.NewReader(upstream.MeterBody(ctx, reader))
 	var dataLines []string
 	dataEventCount := 0
 	parsedEventCount := 0
@@ -9,6 +9,20 @@ import (
 	"orchids-api/internal/store"
 )
 
+func TestAIURL(t *testing.T) {
+	cases := map[string]string{
+		"":                             aiURL,
+		"https://warp-relay.internal":  "https://warp-relay.internal/ai/multi-agent",
+		"https://warp-relay.internal/": "https://warp-relay.internal/ai/multi-agent",
+		"https://warp-relay.internal/ai/multi-agent": "https://warp-relay.internal/ai/multi-agent",
+	}
+	for in, want := range cases {
+		if got := AIURL(in); got != want {
+			t.Fatalf("AIURL(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
 func TestNewFromAccount_ProxyAppliedToEachAccount(t *testing.T) {
 	base := &config.Config{
 		ProxyHTTP: "http://proxy.local:3128",
@@ -0,0 +1,209 @@
+// Package websearch queries a configurable search API (SearxNG, Brave or Bing)
+// for the built-in web_search server tool.
+package websearch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-json"
+
+	"orchids-api/internal/util"
+)
+
+const (
+	ProviderSearxNG = "searxng"
+	ProviderBrave   = "brave"
+	ProviderBing    = "bing"
+
+	defaultBraveEndpoint = "https://api.search.brave.com/res/v1/web/search"
+	defaultBingEndpoint  = "https://api.bing.microsoft.com/v7.0/search"
+	requestTimeout       = 15 * time.Second
+	maxResponseBytes     = 4 << 20
+)
+
+// Result is a single search hit.
+type Result struct {
+	Title   string `json:"title"`
+	URL     string `json:"url"`
+	Snippet string `json:"snippet,omitempty"`
+	PageAge string `json:"page_age,omitempty"`
+}
+
+// Searcher runs a web search and returns at most limit results.
+type Searcher interface {
+	Search(ctx context.Context, query string, limit int) ([]Result, error)
+}
+
+// Options configures the search API.
+type Options struct {
+	Provider string
+	Endpoint string
+	APIKey   string
+}
+
+// New returns a Searcher for the configured provider, or nil when Provider is
+// empty.
+func New(opts Options) (Searcher, error) {
+	provider := strings.ToLower(strings.TrimSpace(opts.Provider))
+	endpoint := strings.TrimSpace(opts.Endpoint)
+	apiKey := strings.TrimSpace(opts.APIKey)
+	client := util.GetSharedHTTPClient("direct", requestTimeout, nil)
+
+	switch provider {
+	case "":
+		return nil, nil
+	case ProviderSearxNG:
+		if endpoint == "" {
+			return nil, errors.New("websearch: searxng requires an endpoint")
+		}
+		return &searxng{client: client, endpoint: strings.TrimRight(endpoint, "/") + "/search"}, nil
+	case ProviderBrave:
+		if apiKey == "" {
+			return nil, errors.New("websearch: brave requires an api key")
+		}
+		if endpoint == "" {
+			endpoint = defaultBraveEndpoint
+		}
+		return &brave{client: client, endpoint: endpoint, apiKey: apiKey}, nil
+	case ProviderBing:
+		if apiKey == "" {
+			return nil, errors.New("websearch: bing requires an api key")
+		}
+		if endpoint == "" {
+			endpoint = defaultBingEndpoint
+		}
+		return &bing{client: client, endpoint: endpoint, apiKey: apiKey}, nil
+	default:
+		return nil, fmt.Errorf("websearch: unknown provider %q", opts.Provider)
+	}
+}
+
+// getJSON performs a GET and decodes the JSON response into v.
+func getJSON(ctx context.Context, client *http.Client, endpoint string, params url.Values, header http.Header, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint+"?"+params.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	for k, vals := range header {
+		for _, val := range vals {
+			req.Header.Add(k, val)
+		}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseBytes))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("websearch: search API returned %d: %s", resp.StatusCode, strings.TrimSpace(string(body[:min(len(body), 200)])))
+	}
+	return json.Unmarshal(body, v)
+}
+
+func truncate(results []Result, limit int) []Result {
+	if limit > 0 && len(results) > limit {
+		return results[:limit]
+	}
+	return results
+}
+
+type searxng struct {
+	client   *http.Client
+	endpoint string
+}
+
+func (s *searxng) Search(ctx context.Context, query string, limit int) ([]Result, error) {
+	var out struct {
+		Results []struct {
+			Title         string `json:"title"`
+			URL           string `json:"url"`
+			Content       string `json:"content"`
+			PublishedDate string `json:"publishedDate"`
+		} `json:"results"`
+	}
+	params := url.Values{"q": {query}, "format": {"json"}}
+	if err := getJSON(ctx, s.client, s.endpoint, params, nil, &out); err != nil {
+		return nil, err
+	}
+	results := make([]Result, 0, len(out.Results))
+	for _, r := range out.Results {
+		results = append(results, Result{Title: r.Title, URL: r.URL, Snippet: r.Content, PageAge: r.PublishedDate})
+	}
+	return truncate(results, limit), nil
+}
+
+type brave struct {
+	client   *http.Client
+	endpoint string
+	apiKey   string
+}
+
+func (b *brave) Search(ctx context.Context, query string, limit int) ([]Result, error) {
+	var out struct {
+		Web struct {
+			Results []struct {
+				Title       string `json:"title"`
+				URL         string `json:"url"`
+				Description string `json:"description"`
+				Age         string `json:"age"`
+			} `json:"results"`
+		} `json:"web"`
+	}
+	params := url.Values{"q": {query}}
+	if limit > 0 {
+		params.Set("count", fmt.Sprint(limit))
+	}
+	header := http.Header{"X-Subscription-Token": {b.apiKey}}
+	if err := getJSON(ctx, b.client, b.endpoint, params, header, &out); err != nil {
+		return nil, err
+	}
+	results := make([]Result, 0, len(out.Web.Results))
+	for _, r := range out.Web.Results {
+		results = append(results, Result{Title: r.Title, URL: r.URL, Snippet: r.Description, PageAge: r.Age})
+	}
+	return truncate(results, limit), nil
+}
+
+type bing struct {
+	client   *http.Client
+	endpoint string
+	apiKey   string
+}
+
+func (b *bing) Search(ctx context.Context, query string, limit int) ([]Result, error) {
+	var out struct {
+		WebPages struct {
+			Value []struct {
+				Name            string `json:"name"`
+				URL             string `json:"url"`
+				Snippet         string `json:"snippet"`
+				DateLastCrawled string `json:"dateLastCrawled"`
+			} `json:"value"`
+		} `json:"webPages"`
+	}
+	params := url.Values{"q": {query}}
+	if limit > 0 {
+		params.Set("count", fmt.Sprint(limit))
+	}
+	header := http.Header{"Ocp-Apim-Subscription-Key": {b.apiKey}}
+	if err := getJSON(ctx, b.client, b.endpoint, params, header, &out); err != nil {
+		return nil, err
+	}
+	results := make([]Result, 0, len(out.WebPages.Value))
+	for _, r := range out.WebPages.Value {
+		results = append(results, Result{Title: r.Name, URL: r.URL, Snippet: r.Snippet, PageAge: r.DateLastCrawled})
+	}
+	return truncate(results, limit), nil
+}
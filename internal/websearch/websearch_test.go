@@ -0,0 +1,79 @@
+package websearch
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestProviders(t *testing.T) {
+	cases := []struct {
+		provider string
+		path     string
+		header   string
+		body     string
+	}{
+		{ProviderSearxNG, "/search", "", `{"results":[{"title":"A","url":"https://a.example","content":"alpha","publishedDate":"2024-01-01"},{"title":"B","url":"https://b.example"}]}`},
+		{ProviderBrave, "/", "X-Subscription-Token", `{"web":{"results":[{"title":"A","url":"https://a.example","description":"alpha","age":"2024-01-01"},{"title":"B","url":"https://b.example"}]}}`},
+		{ProviderBing, "/", "Ocp-Apim-Subscription-Key", `{"webPages":{"value":[{"name":"A","url":"https://a.example","snippet":"alpha","dateLastCrawled":"2024-01-01"},{"name":"B","url":"https://b.example"}]}}`},
+	}
+	for _, tc := range cases {
+		t.Run(tc.provider, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != tc.path || r.URL.Query().Get("q") != "golang" {
+					t.Errorf("unexpected request %s", r.URL)
+				}
+				if tc.header != "" && r.Header.Get(tc.header) != "secret" {
+					t.Errorf("missing %s header", tc.header)
+				}
+				w.Write([]byte(tc.body))
+			}))
+			defer srv.Close()
+
+			endpoint := srv.URL
+			if tc.provider != ProviderSearxNG {
+				endpoint += "/"
+			}
+			s, err := New(Options{Provider: tc.provider, Endpoint: endpoint, APIKey: "secret"})
+			if err != nil {
+				t.Fatalf("New: %v", err)
+			}
+			results, err := s.Search(context.Background(), "golang", 1)
+			if err != nil {
+				t.Fatalf("Search: %v", err)
+			}
+			want := Result{Title: "A", URL: "https://a.example", Snippet: "alpha", PageAge: "2024-01-01"}
+			if len(results) != 1 || results[0] != want {
+				t.Fatalf("results=%+v", results)
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	if s, err := New(Options{}); s != nil || err != nil {
+		t.Fatalf("empty provider: s=%v err=%v", s, err)
+	}
+	for _, opts := range []Options{
+		{Provider: "searxng"},
+		{Provider: "brave"},
+		{Provider: "bing"},
+		{Provider: "google", APIKey: "k"},
+	} {
+		if _, err := New(opts); err == nil {
+			t.Fatalf("expected an error for %+v", opts)
+		}
+	}
+}
+
+func TestSearchError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "quota exceeded", http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+	s, _ := New(Options{Provider: ProviderSearxNG, Endpoint: srv.URL})
+	if _, err := s.Search(context.Background(), "x", 5); err == nil {
+		t.Fatal("expected an error for a non-200 response")
+	}
+}
@@ -0,0 +1,300 @@
+// Package workspace builds compact snapshots of a local working directory
+// (file tree, sizes, git status) for prompt injection, cached per directory
+// and refreshed in the background once stale.
+package workspace
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultTTL        = 60 * time.Second
+	defaultMaxEntries = 200
+	defaultMaxDepth   = 3
+	maxCachedDirs     = 64
+	maxGitStatusLines = 30
+	gitTimeout        = 3 * time.Second
+)
+
+// Options configures snapshots.
+type Options struct {
+	TTL        time.Duration
+	MaxEntries int
+	MaxDepth   int
+	Ignore     []string // directory or file names skipped at any depth
+	// Roots are the directories whose subtrees may be snapshotted; a
+	// workdir outside all of them (after resolving symlinks) is ignored,
+	// and no roots means no snapshots.
+	Roots []string
+}
+
+// Entry is one file or directory, relative to the snapshot root.
+type Entry struct {
+	Path  string
+	Size  int64
+	IsDir bool
+}
+
+// Snapshot is the state of a working directory at TakenAt.
+type Snapshot struct {
+	Root      string
+	Entries   []Entry
+	Truncated bool
+	GitBranch string
+	GitStatus []string
+	TakenAt   time.Time
+}
+
+type cacheEntry struct {
+	snap       *Snapshot
+	refreshing bool
+}
+
+// Cache holds the latest snapshot of each working directory.
+type Cache struct {
+	opts Options
+	now  func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+}
+
+// New returns an empty Cache.
+func New(opts Options) *Cache {
+	if opts.TTL <= 0 {
+		opts.TTL = defaultTTL
+	}
+	if opts.MaxEntries <= 0 {
+		opts.MaxEntries = defaultMaxEntries
+	}
+	if opts.MaxDepth <= 0 {
+		opts.MaxDepth = defaultMaxDepth
+	}
+	ignore := append([]string{".git"}, opts.Ignore...)
+	opts.Ignore = ignore
+	roots := make([]string, 0, len(opts.Roots))
+	for _, root := range opts.Roots {
+		root = filepath.Clean(strings.TrimSpace(root))
+		if root == "." || !filepath.IsAbs(root) {
+			continue
+		}
+		if resolved, err := filepath.EvalSymlinks(root); err == nil {
+			root = resolved
+		}
+		roots = append(roots, root)
+	}
+	opts.Roots = roots
+	return &Cache{opts: opts, now: time.Now, entries: make(map[string]*cacheEntry)}
+}
+
+// Get returns the snapshot of dir. The first call for a directory takes the
+// snapshot synchronously; later calls return the cached one and refresh it
+// in the background once it is older than the TTL. It returns nil when dir
+// is not a local directory under one of the configured roots.
+func (c *Cache) Get(dir string) *Snapshot {
+	dir = filepath.Clean(strings.TrimSpace(dir))
+	if dir == "." || !filepath.IsAbs(dir) {
+		return nil
+	}
+	resolved, err := filepath.EvalSymlinks(dir)
+	if err != nil || !c.allowed(resolved) {
+		return nil
+	}
+	dir = resolved
+
+	c.mu.Lock()
+	if e, ok := c.entries[dir]; ok {
+		snap := e.snap
+		if c.now().Sub(snap.TakenAt) >= c.opts.TTL && !e.refreshing {
+			e.refreshing = true
+			go c.refresh(dir)
+		}
+		c.mu.Unlock()
+		return snap
+	}
+	c.mu.Unlock()
+
+	snap := c.take(dir)
+	if snap == nil {
+		return nil
+	}
+	c.mu.Lock()
+	c.store(dir, snap)
+	c.mu.Unlock()
+	return snap
+}
+
+// allowed reports whether dir is one of the roots or lies below one.
+func (c *Cache) allowed(dir string) bool {
+	for _, root := range c.opts.Roots {
+		if rel, err := filepath.Rel(root, dir); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Cache) refresh(dir string) {
+	snap := c.take(dir)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if snap == nil {
+		delete(c.entries, dir)
+		return
+	}
+	c.store(dir, snap)
+}
+
+// store saves snap, evicting the oldest snapshot when the cache is full.
+// Callers hold c.mu.
+func (c *Cache) store(dir string, snap *Snapshot) {
+	if _, ok := c.entries[dir]; !ok && len(c.entries) >= maxCachedDirs {
+		oldest := ""
+		for key, e := range c.entries {
+			if oldest == "" || e.snap.TakenAt.Before(c.entries[oldest].snap.TakenAt) {
+				oldest = key
+			}
+		}
+		delete(c.entries, oldest)
+	}
+	c.entries[dir] = &cacheEntry{snap: snap}
+}
+
+// take walks dir breadth-first up to MaxDepth and MaxEntries and reads its
+// git status.
+func (c *Cache) take(dir string) *Snapshot {
+	info, err := os.Stat(dir)
+	if err != nil || !info.IsDir() {
+		return nil
+	}
+	snap := &Snapshot{Root: dir, TakenAt: c.now()}
+	ignored := make(map[string]struct{}, len(c.opts.Ignore))
+	for _, name := range c.opts.Ignore {
+		if name = strings.TrimSpace(name); name != "" {
+			ignored[name] = struct{}{}
+		}
+	}
+
+	level := []string{""}
+	for depth := 1; depth <= c.opts.MaxDepth && len(level) > 0 && !snap.Truncated; depth++ {
+		var next []string
+		for _, rel := range level {
+			items, err := os.ReadDir(filepath.Join(dir, rel))
+			if err != nil {
+				continue
+			}
+			for _, item := range items {
+				if _, skip := ignored[item.Name()]; skip {
+					continue
+				}
+				if len(snap.Entries) >= c.opts.MaxEntries {
+					snap.Truncated = true
+					break
+				}
+				entry := Entry{Path: filepath.ToSlash(filepath.Join(rel, item.Name())), IsDir: item.IsDir()}
+				if item.IsDir() {
+					next = append(next, filepath.Join(rel, item.Name()))
+				} else if item.Type()&fs.ModeSymlink == 0 {
+					if fi, err := item.Info(); err == nil {
+						entry.Size = fi.Size()
+					}
+				}
+				snap.Entries = append(snap.Entries, entry)
+			}
+			if snap.Truncated {
+				break
+			}
+		}
+		level = next
+	}
+	// Sort by path components so children directly follow their directory.
+	sort.Slice(snap.Entries, func(i, j int) bool {
+		return strings.ReplaceAll(snap.Entries[i].Path, "/", "\x00") < strings.ReplaceAll(snap.Entries[j].Path, "/", "\x00")
+	})
+	snap.GitBranch, snap.GitStatus = gitStatus(dir)
+	return snap
+}
+
+// gitStatus returns the current branch and the porcelain status lines, or
+// empty values when dir is not in a git repository. The repository's own
+// config could name an fsmonitor hook, so that is switched off, and the
+// system config is skipped; status takes no optional locks.
+func gitStatus(dir string) (string, []string) {
+	ctx, cancel := context.WithTimeout(context.Background(), gitTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "git", "-c", "core.fsmonitor=false", "-C", dir, "status", "--porcelain=v1", "--branch")
+	cmd.Env = append(os.Environ(), "GIT_CONFIG_NOSYSTEM=1", "GIT_OPTIONAL_LOCKS=0")
+	out, err := cmd.Output()
+	if err != nil {
+		return "", nil
+	}
+	var branch string
+	var status []string
+	for _, line := range strings.Split(strings.TrimRight(string(out), "\n"), "\n") {
+		if rest, ok := strings.CutPrefix(line, "## "); ok {
+			branch, _, _ = strings.Cut(rest, "...")
+			continue
+		}
+		if line != "" {
+			status = append(status, line)
+		}
+	}
+	return branch, status
+}
+
+// Render returns the compact text form injected into prompts.
+func (s *Snapshot) Render() string {
+	if s == nil {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("root: " + s.Root + "\n")
+	if s.GitBranch != "" {
+		b.WriteString("git branch: " + s.GitBranch + "\n")
+	}
+	if s.Truncated {
+		fmt.Fprintf(&b, "files (first %d entries):\n", len(s.Entries))
+	} else {
+		b.WriteString("files:\n")
+	}
+	for _, e := range s.Entries {
+		depth := strings.Count(e.Path, "/")
+		name := e.Path[strings.LastIndex(e.Path, "/")+1:]
+		b.WriteString(strings.Repeat("  ", depth))
+		if e.IsDir {
+			b.WriteString(name + "/\n")
+		} else {
+			b.WriteString(name + " (" + formatSize(e.Size) + ")\n")
+		}
+	}
+	if len(s.GitStatus) > 0 {
+		b.WriteString("git status:\n")
+		for i, line := range s.GitStatus {
+			if i == maxGitStatusLines {
+				fmt.Fprintf(&b, "... %d more\n", len(s.GitStatus)-i)
+				break
+			}
+			b.WriteString(line + "\n")
+		}
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+func formatSize(n int64) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1fM", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1fK", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%dB", n)
+}
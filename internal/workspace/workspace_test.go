@@ -0,0 +1,124 @@
+package workspace
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRender(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "main.go"), "package main\n")
+	writeFile(t, filepath.Join(dir, "cmd", "tool", "run.go"), strings.Repeat("x", 2048))
+	writeFile(t, filepath.Join(dir, "cmd.txt"), "")
+	writeFile(t, filepath.Join(dir, "node_modules", "dep.js"), "")
+	writeFile(t, filepath.Join(dir, "a", "b", "c", "deep.txt"), "")
+
+	snap := New(Options{Ignore: []string{"node_modules"}, Roots: []string{dir}}).Get(dir)
+	if snap == nil {
+		t.Fatal("expected a snapshot")
+	}
+	want := "root: " + dir + "\n" +
+		"files:\n" +
+		"a/\n" +
+		"  b/\n" +
+		"    c/\n" +
+		"cmd/\n" +
+		"  tool/\n" +
+		"    run.go (2.0K)\n" +
+		"cmd.txt (0B)\n" +
+		"main.go (13B)"
+	if got := snap.Render(); got != want {
+		t.Fatalf("render:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestMaxEntries(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a", "b", "c", "d"} {
+		writeFile(t, filepath.Join(dir, name), "")
+	}
+	snap := New(Options{MaxEntries: 2, Roots: []string{dir}}).Get(dir)
+	if !snap.Truncated || len(snap.Entries) != 2 {
+		t.Fatalf("truncated=%v entries=%v", snap.Truncated, snap.Entries)
+	}
+	if !strings.Contains(snap.Render(), "files (first 2 entries):") {
+		t.Fatalf("render=%s", snap.Render())
+	}
+}
+
+func TestGetCachesAndRefreshes(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "one"), "")
+	c := New(Options{TTL: time.Minute, Roots: []string{dir}})
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	first := c.Get(dir)
+	writeFile(t, filepath.Join(dir, "two"), "")
+	if c.Get(dir) != first {
+		t.Fatal("expected the cached snapshot within the TTL")
+	}
+
+	now = now.Add(2 * time.Minute)
+	if c.Get(dir) != first {
+		t.Fatal("expected the stale snapshot while refreshing")
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if snap := c.Get(dir); snap != first {
+			if len(snap.Entries) != 2 {
+				t.Fatalf("refreshed entries=%v", snap.Entries)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("snapshot was not refreshed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestGetRejectsMissingDirs(t *testing.T) {
+	root := t.TempDir()
+	c := New(Options{Roots: []string{root}})
+	for _, dir := range []string{"", "relative/path", filepath.Join(root, "missing")} {
+		if snap := c.Get(dir); snap != nil {
+			t.Fatalf("dir %q: expected nil, got %+v", dir, snap)
+		}
+	}
+}
+
+func TestGetRequiresRoot(t *testing.T) {
+	root, outside := t.TempDir(), t.TempDir()
+	writeFile(t, filepath.Join(root, "project", "main.go"), "")
+	writeFile(t, filepath.Join(outside, "secret.txt"), "")
+	if err := os.Symlink(outside, filepath.Join(root, "escape")); err != nil {
+		t.Fatal(err)
+	}
+
+	c := New(Options{Roots: []string{root}})
+	if c.Get(filepath.Join(root, "project")) == nil {
+		t.Fatal("expected a snapshot under the root")
+	}
+	for _, dir := range []string{outside, filepath.Join(root, "escape"), filepath.Join(root, "..")} {
+		if snap := c.Get(dir); snap != nil {
+			t.Fatalf("dir %q: expected nil outside the root, got %+v", dir, snap)
+		}
+	}
+	if snap := New(Options{}).Get(root); snap != nil {
+		t.Fatalf("no roots: expected nil, got %+v", snap)
+	}
+}
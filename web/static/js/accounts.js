@@ -236,6 +236,9 @@ function getAccountToken(acc) {
   if (type === 'warp') {
     return acc.refresh_token || acc.token || acc.client_cookie || '';
   }
+  if (type === 'openai-compatible' || type === 'anthropic') {
+    return acc.api_key || '';
+  }
   return acc.client_cookie || '';
 }
 
@@ -244,6 +247,19 @@ function applyTokenLabels(type) {
   const input = document.getElementById("clientCookie");
   const hint = document.getElementById("tokenHint");
   if (!label || !input || !hint) return;
+  const baseUrlGroup = document.getElementById("baseUrlGroup");
+  if (baseUrlGroup) baseUrlGroup.style.display = (type === 'openai-compatible' || type === 'anthropic') ? '' : 'none';
+  const baseUrl = document.getElementById("baseUrl");
+  const baseUrlHint = document.getElementById("baseUrlHint");
+  if (baseUrl && baseUrlHint) {
+    if (type === 'anthropic') {
+      baseUrl.placeholder = "留空使用 https://api.anthropic.com";
+      baseUrlHint.textContent = "请求发送到 {Base URL}/v1/messages";
+    } else {
+      baseUrl.placeholder = "https://openrouter.ai/api/v1";
+      baseUrlHint.textContent = "请求发送到 {Base URL}/chat/completions";
+    }
+  }
   if (type === 'warp') {
     label.textContent = "Refresh Token";
     input.placeholder = "粘贴 refresh_token";
@@ -252,6 +268,14 @@ function applyTokenLabels(type) {
     label.textContent = "SSO Token";
     input.placeholder = "粘贴 sso token（或包含 sso= 的 Cookie）";
     hint.textContent = "Grok 使用 sso token（支持纯 token 或 Cookie 片段）";
+  } else if (type === 'openai-compatible') {
+    label.textContent = "API Key";
+    input.placeholder = "粘贴 API Key";
+    hint.textContent = "以 Authorization: Bearer 发送到上游；模型需在模型管理中以 openai-compatible 渠道添加";
+  } else if (type === 'anthropic') {
+    label.textContent = "API Key";
+    input.placeholder = "粘贴 sk-ant-... API Key";
+    hint.textContent = "以 x-api-key 发送到上游；模型需在模型管理中以 anthropic 渠道添加";
   } else {
     label.textContent = "Client Cookie / JWT";
     input.placeholder = "粘贴 Clerk Cookie 或 JWT";
@@ -263,7 +287,7 @@ function applyTokenLabels(type) {
 function renderPlatformTabs() {
   const container = document.getElementById("platformFilters");
   if (!container) return;
-  const defaultTypes = ["orchids", "warp", "grok"];
+  const defaultTypes = ["orchids", "warp", "grok", "openai-compatible", "anthropic"];
   const types = new Set([...defaultTypes, ...accounts.map(normalizeAccountType)]);
   const sorted = Array.from(types).sort();
   const tabs = [...sorted];
@@ -307,6 +331,12 @@ function evaluateAccountStatus(acc) {
   if (health && !health.ok) {
     return { normal: false, text: '异常', color: '#fb7185', bg: 'rgba(251, 113, 133, 0.16)', tip: health.msg || '检测失败' };
   }
+  if (acc.draining) {
+    return { normal: false, text: '排空中', color: '#f59e0b', bg: 'rgba(245, 158, 11, 0.16)', tip: '已停止分配新请求' };
+  }
+  if (acc.health && acc.health.flags && acc.health.flags.length) {
+    return { normal: false, text: `健康 ${acc.health.score}`, color: '#f59e0b', bg: 'rgba(245, 158, 11, 0.16)', tip: `最近 ${acc.health.requests} 个请求异常: ${acc.health.flags.join(', ')}` };
+  }
   if (!acc.enabled) {
     return { normal: false, text: '禁用', color: '#fb7185', bg: 'rgba(251, 113, 133, 0.16)', tip: '账号已禁用' };
   }
@@ -326,6 +356,12 @@ function evaluateAccountStatus(acc) {
     }
   }
 
+  if (acc.token_expiring) {
+    const left = acc.token_expires_in || 0;
+    const tip = left > 0 ? `Token 将在 ${Math.ceil(left / 60)} 分钟后过期` : 'Token 已过期';
+    return { normal: false, text: left > 0 ? '即将过期' : '已过期', color: '#f59e0b', bg: 'rgba(245, 158, 11, 0.16)', tip };
+  }
+
   const type = normalizeAccountType(acc);
   if (type === 'warp') {
     if (!getAccountToken(acc)) {
@@ -335,6 +371,14 @@ function evaluateAccountStatus(acc) {
     if (!getAccountToken(acc)) {
       return { normal: false, text: '待补全', color: '#f59e0b', bg: 'rgba(245, 158, 11, 0.16)', tip: '缺少 SSO Token' };
     }
+  } else if (type === 'openai-compatible') {
+    if (!acc.base_url) {
+      return { normal: false, text: '待补全', color: '#f59e0b', bg: 'rgba(245, 158, 11, 0.16)', tip: '缺少 Base URL' };
+    }
+  } else if (type === 'anthropic') {
+    if (!getAccountToken(acc)) {
+      return { normal: false, text: '待补全', color: '#f59e0b', bg: 'rgba(245, 158, 11, 0.16)', tip: '缺少 API Key' };
+    }
   } else if (!acc.session_id && !acc.session_cookie) {
     return { normal: false, text: '待补全', color: '#f59e0b', bg: 'rgba(245, 158, 11, 0.16)', tip: '缺少会话信息' };
   }
@@ -701,6 +745,13 @@ function renderAccounts() {
     refresh.title = "检查";
     refresh.textContent = "🔄";
 
+    const drain = document.createElement("i");
+    drain.className = "action-icon";
+    drain.dataset.action = acc.draining ? "undrain" : "drain";
+    drain.dataset.id = encodeData(acc.id);
+    drain.title = acc.draining ? "恢复调度" : "排空（停止分配新请求）";
+    drain.textContent = acc.draining ? "▶️" : "⏸️";
+
     const del = document.createElement("i");
     del.className = "action-icon";
     del.dataset.action = "delete";
@@ -710,6 +761,7 @@ function renderAccounts() {
 
     actionWrap.appendChild(edit);
     actionWrap.appendChild(refresh);
+    actionWrap.appendChild(drain);
     actionWrap.appendChild(del);
     tdActions.appendChild(actionWrap);
     tr.appendChild(tdActions);
@@ -737,6 +789,8 @@ function renderAccounts() {
     const id = parseDataId(idRaw);
     if (action === "edit") editAccount(id);
     if (action === "refresh") refreshToken(id);
+    if (action === "drain") drainAccount(id, true);
+    if (action === "undrain") drainAccount(id, false);
     if (action === "delete") deleteAccount(id);
   };
 
@@ -900,14 +954,33 @@ function openModal(account = null) {
       document.getElementById("accountId").value = account.id;
       document.getElementById("accountType").value = normalizeAccountType(account);
       document.getElementById("clientCookie").value = getAccountToken(account);
+      document.getElementById("revealSecretBtn").style.display = "";
+      document.getElementById("baseUrl").value = account.base_url || "";
+      document.getElementById("customHeaders").value = formatHeaderLines(account.headers);
+      document.getElementById("userAgents").value = (account.user_agents || []).join("\n");
       document.getElementById("weight").value = account.weight || 1;
+      document.getElementById("maxConcurrency").value = account.max_concurrency || 0;
+      document.getElementById("tpmLimit").value = account.tpm_limit || 0;
+      document.getElementById("maxRpm").value = account.max_rpm || 0;
+      document.getElementById("dailyRequestCap").value = account.daily_request_cap || 0;
+      document.getElementById("dailyTokenCap").value = account.daily_token_cap || 0;
+      document.getElementById("monthlyRequestCap").value = account.monthly_request_cap || 0;
+      document.getElementById("monthlyTokenCap").value = account.monthly_token_cap || 0;
+      document.getElementById("capResetDay").value = account.cap_reset_day || 0;
       document.getElementById("enabled").checked = account.enabled;
       renderAgentModeOptions(document.getElementById("accountType").value, account.agent_mode || "");
     } else {
       title.textContent = "添加账号";
       form.reset();
       document.getElementById("accountId").value = "";
+      document.getElementById("revealSecretBtn").style.display = "none";
       document.getElementById("weight").value = "1";
+      document.getElementById("maxConcurrency").value = "0";
+      document.getElementById("tpmLimit").value = "0";
+      document.getElementById("maxRpm").value = "0";
+      ["dailyRequestCap", "dailyTokenCap", "monthlyRequestCap", "monthlyTokenCap", "capResetDay"].forEach(id => {
+        document.getElementById(id).value = "0";
+      });
       document.getElementById("accountType").value = "orchids";
       document.getElementById("enabled").checked = true;
       renderAgentModeOptions("orchids", "");
@@ -928,6 +1001,40 @@ function closeModal() {
   modal.style.display = "none";
 }
 
+// "Name: value" per line <-> headers object
+function parseHeaderLines(text) {
+  const headers = {};
+  String(text || "").split("\n").forEach(line => {
+    const idx = line.indexOf(":");
+    if (idx <= 0) return;
+    const name = line.slice(0, idx).trim();
+    if (name) headers[name] = line.slice(idx + 1).trim();
+  });
+  return headers;
+}
+
+function formatHeaderLines(headers) {
+  return Object.entries(headers || {}).map(([name, value]) => `${name}: ${value}`).join("\n");
+}
+
+// Reveal the stored credential of the account being edited (audited)
+async function revealAccountSecret() {
+  const id = document.getElementById("accountId").value;
+  if (!id) return;
+  try {
+    const res = await fetch(`/api/accounts/${id}/secret`);
+    if (!res.ok) {
+      showToast(await res.text() || "无法显示凭据", "error");
+      return;
+    }
+    const secret = await res.json();
+    secret.account_type = document.getElementById("accountType").value;
+    document.getElementById("clientCookie").value = getAccountToken(secret);
+  } catch (err) {
+    showToast("无法显示凭据: " + err.message, "error");
+  }
+}
+
 // Save account
 async function saveAccount(e) {
   e.preventDefault();
@@ -938,10 +1045,23 @@ async function saveAccount(e) {
     account_type: type,
     agent_mode: document.getElementById("agentMode").value,
     weight: parseInt(document.getElementById("weight").value) || 1,
+    max_concurrency: Math.max(0, parseInt(document.getElementById("maxConcurrency").value) || 0),
+    tpm_limit: Math.max(0, parseInt(document.getElementById("tpmLimit").value) || 0),
+    max_rpm: Math.max(0, parseInt(document.getElementById("maxRpm").value) || 0),
+    daily_request_cap: Math.max(0, parseInt(document.getElementById("dailyRequestCap").value) || 0),
+    daily_token_cap: Math.max(0, parseInt(document.getElementById("dailyTokenCap").value) || 0),
+    monthly_request_cap: Math.max(0, parseInt(document.getElementById("monthlyRequestCap").value) || 0),
+    monthly_token_cap: Math.max(0, parseInt(document.getElementById("monthlyTokenCap").value) || 0),
+    cap_reset_day: Math.min(28, Math.max(0, parseInt(document.getElementById("capResetDay").value) || 0)),
     enabled: document.getElementById("enabled").checked,
+    headers: parseHeaderLines(document.getElementById("customHeaders").value),
+    user_agents: document.getElementById("userAgents").value.split("\n").map(s => s.trim()).filter(Boolean),
   };
   if (type === 'warp') {
     data.refresh_token = token;
+  } else if (type === 'openai-compatible' || type === 'anthropic') {
+    data.api_key = token;
+    data.base_url = document.getElementById("baseUrl").value.trim();
   } else {
     data.client_cookie = token;
   }
@@ -990,6 +1110,28 @@ async function deleteAccount(id) {
   }
 }
 
+// Drain (stop routing new requests to) or undrain an account
+async function drainAccount(id, drain) {
+  let query = "";
+  if (drain) {
+    if (!confirm("确定排空这个账号吗？新请求将不再分配给它。")) return;
+    if (confirm("是否同时中止该账号正在进行的请求？")) query = "?cancel=true";
+  }
+  try {
+    const res = await fetch(`/api/accounts/${id}/drain${query}`, { method: drain ? "POST" : "DELETE" });
+    if (!res.ok) throw new Error(await res.text());
+    const data = await res.json();
+    if (drain) {
+      showToast(`已排空，进行中 ${data.in_flight} 个` + (data.cancelled ? `，已中止 ${data.cancelled} 个` : ""));
+    } else {
+      showToast("已恢复调度");
+    }
+    loadAccounts();
+  } catch (err) {
+    showToast("操作失败: " + err.message, "error");
+  }
+}
+
 // Escape HTML
 function escapeHtml(text) {
   if (text === null || text === undefined) return '';
@@ -1058,9 +1200,28 @@ function formatTime(iso) {
   return d.toLocaleDateString();
 }
 
-// Export accounts
-function exportAccounts() {
-  window.location.href = "/api/export";
+// Export accounts (optionally encrypted with a passphrase)
+async function exportAccounts() {
+  const passphrase = prompt("设置备份密码（至少 8 位，留空导出明文）");
+  if (passphrase === null) return;
+  if (!passphrase) {
+    window.location.href = "/api/export";
+    return;
+  }
+  try {
+    const res = await fetch("/api/export", {
+      headers: { "X-Backup-Passphrase": passphrase },
+    });
+    if (!res.ok) throw new Error(await res.text());
+    const url = URL.createObjectURL(await res.blob());
+    const a = document.createElement("a");
+    a.href = url;
+    a.download = "accounts_export.enc.json";
+    a.click();
+    URL.revokeObjectURL(url);
+  } catch (err) {
+    showToast("导出失败: " + err.message, "error");
+  }
 }
 
 // Import accounts
@@ -1069,13 +1230,23 @@ async function importAccounts(event) {
   if (!file) return;
   try {
     const text = await file.text();
+    const headers = { "Content-Type": "application/json" };
+    if (text.includes('"orchids-backup"')) {
+      const passphrase = prompt("该备份已加密，请输入备份密码");
+      if (!passphrase) {
+        event.target.value = "";
+        return;
+      }
+      headers["X-Backup-Passphrase"] = passphrase;
+    }
     const res = await fetch("/api/import", {
       method: "POST",
-      headers: { "Content-Type": "application/json" },
+      headers,
       body: text,
     });
+    if (!res.ok) throw new Error(await res.text());
     const result = await res.json();
-    showToast(`导入完成: 成功 ${result.imported}, 跳过 ${result.skipped}`);
+    showToast(`导入完成: 新增 ${result.imported}, 更新 ${result.updated || 0}, 跳过 ${result.skipped}`);
     loadAccounts();
   } catch (err) {
     showToast("导入失败: " + err.message, "error");
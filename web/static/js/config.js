@@ -529,7 +529,12 @@ async function loadCacheStats() {
       return;
     }
 
-    statsEl.textContent = `缓存条目: ${Number(data.count) || 0} 条，占用内存: ${formatBytes(data.size_bytes)}`;
+    let text = `缓存条目: ${Number(data.count) || 0} 条，占用内存: ${formatBytes(data.size_bytes)}`;
+    if (data.backend) text += `，后端: ${data.backend}`;
+    if (data.hit_rate !== undefined) {
+      text += `，命中率: ${(Number(data.hit_rate) * 100).toFixed(1)}%（${Number(data.hits) || 0} / ${(Number(data.hits) || 0) + (Number(data.misses) || 0)}）`;
+    }
+    statsEl.textContent = text;
   } catch (err) {
     statsEl.textContent = "缓存统计加载失败";
   }
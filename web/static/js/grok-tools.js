@@ -865,7 +865,7 @@
       // ignore
     }
     if (!res.ok || String(data.status || "") !== "success") {
-      throw new Error(data.detail || data.error || (await res.text()) || "请求失败");
+      throw new Error(data.detail || (data.error && data.error.message) || data.error || (await res.text()) || "请求失败");
     }
 
     const taskID = String(data.task_id || "").trim();
@@ -944,7 +944,7 @@
       // ignore
     }
     if (!res.ok || String(data.status || "") !== "success") {
-      throw new Error(data.detail || data.error || (await res.text()) || "请求失败");
+      throw new Error(data.detail || (data.error && data.error.message) || data.error || (await res.text()) || "请求失败");
     }
 
     const taskID = String(data.task_id || "").trim();
@@ -348,6 +348,8 @@ function openModelModal(model = null) {
     document.getElementById("modelSortOrder").value = model.sort_order;
     setSelectValue(document.getElementById("modelStatus"), model.status);
     document.getElementById("modelIsDefault").checked = model.is_default;
+    document.getElementById("modelTools").value = (model.tools || []).join(", ");
+    document.getElementById("modelContextWindow").value = model.context_window || "";
   } else {
     title.textContent = "添加模型";
     form.reset();
@@ -377,7 +379,12 @@ async function saveModel(e) {
     name: document.getElementById("modelName").value,
     sort_order: parseInt(document.getElementById("modelSortOrder").value) || 0,
     status: document.getElementById("modelStatus").value,
-    is_default: document.getElementById("modelIsDefault").checked
+    is_default: document.getElementById("modelIsDefault").checked,
+    tools: document.getElementById("modelTools").value
+      .split(",")
+      .map((t) => t.trim())
+      .filter(Boolean),
+    context_window: parseInt(document.getElementById("modelContextWindow").value) || 0
   };
 
   if (id) {
@@ -0,0 +1,101 @@
+// Live request inspector JavaScript
+
+let requestsSource = null;
+
+const requestStageLabels = {
+  routing: "排队 / 选号",
+  streaming: "生成中",
+};
+
+function connectRequestsStream() {
+  if (requestsSource) requestsSource.close();
+  const status = document.getElementById("requestsStreamStatus");
+  requestsSource = new EventSource("/api/requests/active");
+  requestsSource.onopen = () => {
+    status.textContent = "实时";
+  };
+  requestsSource.addEventListener("requests", (event) => {
+    try {
+      renderActiveRequests(JSON.parse(event.data) || []);
+    } catch (err) {
+      // ignore bad payload
+    }
+  });
+  requestsSource.onerror = () => {
+    // EventSource reconnects on its own; a closed source means auth failed.
+    status.textContent = requestsSource.readyState === EventSource.CLOSED ? "已断开" : "重连中";
+  };
+}
+
+function formatElapsed(ms) {
+  const s = Math.floor(ms / 1000);
+  if (s < 60) return `${s}s`;
+  return `${Math.floor(s / 60)}m ${s % 60}s`;
+}
+
+function renderActiveRequests(reqs) {
+  const body = document.getElementById("activeRequestsBody");
+  document.getElementById("activeRequestCount").textContent = reqs.length;
+  body.innerHTML = "";
+
+  if (reqs.length === 0) {
+    const tr = document.createElement("tr");
+    const td = document.createElement("td");
+    td.colSpan = 8;
+    td.style.textAlign = "center";
+    td.style.color = "var(--text-secondary)";
+    td.style.padding = "24px";
+    td.textContent = "暂无进行中的请求";
+    tr.appendChild(td);
+    body.appendChild(tr);
+    return;
+  }
+
+  reqs.forEach((req) => {
+    const tr = document.createElement("tr");
+    const cells = [
+      req.id,
+      req.key_scope,
+      req.account ? `${req.account} (#${req.account_id})` : "-",
+      req.model + (req.stream ? "" : " · 非流式"),
+      requestStageLabels[req.stage] || req.stage,
+      formatElapsed(req.elapsed_ms),
+      `${req.input_tokens} / ${req.output_tokens}`,
+    ];
+    cells.forEach((text) => {
+      const td = document.createElement("td");
+      td.textContent = text;
+      tr.appendChild(td);
+    });
+
+    const action = document.createElement("td");
+    const btn = document.createElement("button");
+    btn.className = "btn btn-danger-outline";
+    btn.textContent = req.cancelled ? "中止中" : "中止";
+    btn.disabled = !!req.cancelled;
+    btn.addEventListener("click", () => cancelActiveRequest(req.id));
+    action.appendChild(btn);
+    tr.appendChild(action);
+    body.appendChild(tr);
+  });
+}
+
+async function cancelActiveRequest(id) {
+  if (!confirm(`确定中止请求 ${id} 吗？客户端将收到已生成的部分内容。`)) return;
+  try {
+    const res = await fetch(`/api/requests/active/${encodeURIComponent(id)}`, { method: "DELETE" });
+    if (res.status === 401) {
+      window.location.href = "./login.html";
+      return;
+    }
+    if (!res.ok) {
+      showToast(res.status === 404 ? "请求已结束" : "中止失败", "error");
+      return;
+    }
+    showToast("已中止请求");
+  } catch (err) {
+    showToast("中止失败", "error");
+  }
+}
+
+document.addEventListener("DOMContentLoaded", connectRequestsStream);
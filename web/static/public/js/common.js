@@ -23,6 +23,7 @@
       const data = JSON.parse(text);
       if (data && typeof data.detail === "string" && data.detail) return data.detail;
       if (data && typeof data.error === "string" && data.error) return data.error;
+      if (data && data.error && typeof data.error.message === "string" && data.error.message) return data.error.message;
       return text;
     } catch (err) {
       return text;